@@ -0,0 +1,258 @@
+package bifaci
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+
+	"github.com/machinefabric/capdag-go/urn"
+)
+
+// Arg is one argument stream of a request: its media URN and the CBOR data items its
+// CHUNKs carried, back to back
+type Arg struct {
+	MediaUrn string
+	Data     []byte
+}
+
+// ArgSet holds the argument streams of a request, looked up by media URN pattern. A
+// pattern matches the first argument whose media URN it accepts, so "media:json" finds
+// an argument sent as "media:json;record".
+//
+// The lookups that don't start with Require report a missing argument as a zero value
+// (nil, "", v left untouched) and no error; Has tells the two cases apart.
+type ArgSet struct {
+	args []Arg
+}
+
+// CollectArgs collects every argument stream of a request into an ArgSet, verifying
+// checksums as CollectStreams does
+func CollectArgs(frames <-chan Frame) (*ArgSet, error) {
+	streams, err := CollectStreams(frames)
+	if err != nil {
+		return nil, err
+	}
+	args := make([]Arg, len(streams))
+	for i, stream := range streams {
+		args[i] = Arg{MediaUrn: stream.MediaUrn, Data: stream.Data}
+	}
+	return NewArgSet(args...), nil
+}
+
+// NewArgSet returns an ArgSet of args, in order
+func NewArgSet(args ...Arg) *ArgSet {
+	return &ArgSet{args: args}
+}
+
+// Args returns the arguments in the order they were sent
+func (s *ArgSet) Args() []Arg {
+	return s.args
+}
+
+// Find returns the first argument whose media URN pattern accepts, or nil
+func (s *ArgSet) Find(pattern string) (*Arg, error) {
+	i, err := firstAccepted(pattern, len(s.args), func(i int) string { return s.args[i].MediaUrn })
+	if i < 0 || err != nil {
+		return nil, err
+	}
+	return &s.args[i], nil
+}
+
+// firstAccepted returns the index of the first of n media URNs that pattern accepts,
+// -1 if none does
+func firstAccepted(pattern string, n int, mediaUrn func(i int) string) (int, error) {
+	patternUrn, err := urn.NewMediaUrnFromString(pattern)
+	if err != nil {
+		return -1, fmt.Errorf("invalid media URN pattern '%s': %w", pattern, err)
+	}
+	for i := 0; i < n; i++ {
+		argUrn, err := urn.NewMediaUrnFromString(mediaUrn(i))
+		if err != nil {
+			continue
+		}
+		if patternUrn.Accepts(argUrn) {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
+// Has reports whether an argument matches pattern
+func (s *ArgSet) Has(pattern string) bool {
+	arg, _ := s.Find(pattern)
+	return arg != nil
+}
+
+// Bytes returns the contents of the matching argument: its CHUNKs' byte or text strings
+// concatenated
+func (s *ArgSet) Bytes(pattern string) ([]byte, error) {
+	arg, err := s.Find(pattern)
+	if arg == nil || err != nil {
+		return nil, err
+	}
+	return arg.Bytes()
+}
+
+// String returns the contents of the matching argument as a string
+func (s *ArgSet) String(pattern string) (string, error) {
+	data, err := s.Bytes(pattern)
+	return string(data), err
+}
+
+// Reader returns a reader of the contents of the matching argument, or nil
+func (s *ArgSet) Reader(pattern string) (io.Reader, error) {
+	arg, err := s.Find(pattern)
+	if arg == nil || err != nil {
+		return nil, err
+	}
+	data, err := arg.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+// JSON decodes the contents of the matching argument as JSON into v
+func (s *ArgSet) JSON(pattern string, v interface{}) error {
+	data, err := s.Bytes(pattern)
+	if data == nil || err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("arg %s is not valid JSON: %w", pattern, err)
+	}
+	return nil
+}
+
+// CBOR decodes the matching argument into v, see Arg.DecodeCBOR
+func (s *ArgSet) CBOR(pattern string, v interface{}) error {
+	arg, err := s.Find(pattern)
+	if arg == nil || err != nil {
+		return err
+	}
+	return arg.DecodeCBOR(v)
+}
+
+// require returns the matching argument, failing if there is none
+func (s *ArgSet) require(pattern string) (*Arg, error) {
+	arg, err := s.Find(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if arg == nil {
+		return nil, fmt.Errorf("missing required arg: %s", pattern)
+	}
+	return arg, nil
+}
+
+// RequireBytes is like Bytes but fails if no argument matches
+func (s *ArgSet) RequireBytes(pattern string) ([]byte, error) {
+	arg, err := s.require(pattern)
+	if err != nil {
+		return nil, err
+	}
+	data, err := arg.Bytes()
+	if data == nil && err == nil {
+		data = []byte{}
+	}
+	return data, err
+}
+
+// RequireString is like String but fails if no argument matches
+func (s *ArgSet) RequireString(pattern string) (string, error) {
+	data, err := s.RequireBytes(pattern)
+	return string(data), err
+}
+
+// RequireReader is like Reader but fails if no argument matches
+func (s *ArgSet) RequireReader(pattern string) (io.Reader, error) {
+	data, err := s.RequireBytes(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+// RequireJSON is like JSON but fails if no argument matches
+func (s *ArgSet) RequireJSON(pattern string, v interface{}) error {
+	data, err := s.RequireBytes(pattern)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("arg %s is not valid JSON: %w", pattern, err)
+	}
+	return nil
+}
+
+// RequireCBOR is like CBOR but fails if no argument matches
+func (s *ArgSet) RequireCBOR(pattern string, v interface{}) error {
+	arg, err := s.require(pattern)
+	if err != nil {
+		return err
+	}
+	return arg.DecodeCBOR(v)
+}
+
+// Bytes returns the argument's contents: the byte or text strings its CHUNKs carry,
+// concatenated. A single CHUNK's contents are returned without copying.
+func (a *Arg) Bytes() ([]byte, error) {
+	var pieces [][]byte
+	for rest := a.Data; len(rest) > 0; {
+		piece, n, err := cborStringItem(rest)
+		if err != nil {
+			return nil, fmt.Errorf("arg %s: %w", a.MediaUrn, err)
+		}
+		pieces = append(pieces, piece)
+		rest = rest[n:]
+	}
+	if len(pieces) == 1 {
+		return pieces[0], nil
+	}
+	return bytes.Join(pieces, nil), nil
+}
+
+// DecodeCBOR decodes the argument into v. An argument sent as a single CBOR value other
+// than a byte or text string, e.g. a map, is decoded as that value; otherwise the
+// argument's contents are decoded as a CBOR document.
+func (a *Arg) DecodeCBOR(v interface{}) error {
+	if len(a.Data) > 0 && a.Data[0]>>5 != 2 && a.Data[0]>>5 != 3 && cborlib.Wellformed(a.Data) == nil {
+		return cborlib.Unmarshal(a.Data, v)
+	}
+	data, err := a.Bytes()
+	if err != nil {
+		return err
+	}
+	if err := cborlib.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("arg %s is not valid CBOR: %w", a.MediaUrn, err)
+	}
+	return nil
+}
+
+// cborStringItem returns the contents of the byte or text string at the start of data
+// and the size of its encoding
+func cborStringItem(data []byte) ([]byte, int, error) {
+	major := data[0] >> 5
+	if major == 2 || major == 3 {
+		if n, head, ok := cborHeadArgument(data); ok && uint64(len(data)-head) >= n {
+			end := head + int(n)
+			return data[head:end], end, nil
+		}
+	}
+	// Indefinite-length strings and anything else go through the decoder
+	decoder := cborlib.NewDecoder(bytes.NewReader(data))
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, 0, fmt.Errorf("CHUNK is not valid CBOR: %w", err)
+	}
+	switch v := value.(type) {
+	case []byte:
+		return v, decoder.NumBytesRead(), nil
+	case string:
+		return []byte(v), decoder.NumBytesRead(), nil
+	}
+	return nil, 0, fmt.Errorf("CHUNK is not a CBOR byte or text string")
+}
@@ -0,0 +1,108 @@
+package bifaci
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+)
+
+// argFrames returns the frames of a request whose arguments are sent as CHUNKs, each
+// argument's chunks given as values to CBOR-encode
+func argFrames(args map[string][]interface{}, order ...string) chan Frame {
+	id := NewMessageIdRandom()
+	frames := make(chan Frame, 64)
+	for i, mediaUrn := range order {
+		streamId := "arg-" + string(rune('0'+i))
+		frames <- *NewStreamStart(id, streamId, mediaUrn)
+		for j, value := range args[mediaUrn] {
+			payload, _ := cborlib.Marshal(value)
+			frames <- *NewChunk(id, streamId, 0, payload, uint64(j), ComputeChecksum(payload))
+		}
+		frames <- *NewStreamEnd(id, streamId, uint64(len(args[mediaUrn])))
+	}
+	frames <- *NewEnd(id, nil)
+	close(frames)
+	return frames
+}
+
+func TestArgSetLookups(t *testing.T) {
+	document, _ := cborlib.Marshal(map[string]int{"pages": 3})
+	set, err := CollectArgs(argFrames(map[string][]interface{}{
+		"media:json;record": {`{"name":`, []byte(`"doc"}`)},
+		"media:textable":    {"hello ", "world"},
+		"media:cbor":        {document},
+		"media:record;map":  {map[string]interface{}{"k": "v"}},
+	}, "media:json;record", "media:textable", "media:cbor", "media:record;map"))
+	if err != nil {
+		t.Fatalf("CollectArgs failed: %v", err)
+	}
+	if len(set.Args()) != 4 {
+		t.Fatalf("Expected 4 args, got %d", len(set.Args()))
+	}
+
+	// The pattern accepts a more specific URN
+	var record struct{ Name string }
+	if err := set.JSON("media:json", &record); err != nil || record.Name != "doc" {
+		t.Errorf("JSON: got %+v (%v)", record, err)
+	}
+	if text, err := set.String("media:textable"); err != nil || text != "hello world" {
+		t.Errorf("String: got %q (%v)", text, err)
+	}
+	reader, err := set.Reader("media:textable")
+	if err != nil {
+		t.Fatalf("Reader failed: %v", err)
+	}
+	if data, _ := io.ReadAll(reader); string(data) != "hello world" {
+		t.Errorf("Reader: got %q", data)
+	}
+
+	// A CBOR document sent as bytes, and a CBOR value sent as is
+	var pages map[string]int
+	if err := set.CBOR("media:cbor", &pages); err != nil || pages["pages"] != 3 {
+		t.Errorf("CBOR document: got %v (%v)", pages, err)
+	}
+	var entry map[string]string
+	if err := set.RequireCBOR("media:map", &entry); err != nil || entry["k"] != "v" {
+		t.Errorf("CBOR value: got %v (%v)", entry, err)
+	}
+	if _, err := set.Bytes("media:map"); err == nil {
+		t.Error("Bytes of a map argument must fail")
+	}
+}
+
+func TestArgSetMissing(t *testing.T) {
+	set := NewArgSet(Arg{MediaUrn: "media:textable", Data: []byte{0x60}})
+	if set.Has("media:image") {
+		t.Error("Has: no image argument was sent")
+	}
+	if data, err := set.Bytes("media:image"); data != nil || err != nil {
+		t.Errorf("Bytes of a missing argument: got %v (%v)", data, err)
+	}
+	var v interface{}
+	if err := set.JSON("media:image", &v); err != nil || v != nil {
+		t.Errorf("JSON of a missing argument: got %v (%v)", v, err)
+	}
+	if _, err := set.RequireString("media:image"); err == nil || !strings.Contains(err.Error(), "missing required arg") {
+		t.Errorf("RequireString: expected a missing arg error, got %v", err)
+	}
+	if err := set.RequireJSON("media:image", &v); err == nil {
+		t.Error("RequireJSON: expected a missing arg error")
+	}
+	if data, err := set.RequireBytes("media:textable"); err != nil || data == nil || len(data) != 0 {
+		t.Errorf("RequireBytes of an empty argument: got %v (%v)", data, err)
+	}
+	if _, err := set.Bytes("not a urn"); err == nil {
+		t.Error("Expected an invalid pattern to fail")
+	}
+}
+
+func TestCollectArgsReportsErr(t *testing.T) {
+	frames := make(chan Frame, 1)
+	frames <- *NewErr(NewMessageIdRandom(), "BAD", "no")
+	close(frames)
+	if _, err := CollectArgs(frames); err == nil || !strings.Contains(err.Error(), "BAD") {
+		t.Errorf("Expected the ERR, got %v", err)
+	}
+}
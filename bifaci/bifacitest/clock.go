@@ -0,0 +1,94 @@
+package bifacitest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/machinefabric/capdag-go/bifaci"
+)
+
+// FakeClock is a bifaci.Clock that only moves when Advance is called. Install it with
+// PluginRuntime.SetClock to fire the heartbeat watchdog on demand.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// NewFakeClock returns a clock stopped at the Unix epoch
+func NewFakeClock() *FakeClock {
+	return &FakeClock{now: time.Unix(0, 0)}
+}
+
+// Now returns the clock's current time
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTimer returns a timer that fires once the clock is advanced by d
+func (c *FakeClock) NewTimer(d time.Duration) bifaci.Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	timer := &fakeTimer{clock: c, c: make(chan time.Time, 1)}
+	c.timers = append(c.timers, timer)
+	timer.arm(d)
+	return timer
+}
+
+// Advance moves the clock forward by d and fires the timers that came due
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	for _, timer := range c.timers {
+		timer.fireIfDue()
+	}
+}
+
+// fakeTimer follows time.Timer: Stop and Reset report whether it was active, and a
+// fired value stays in C until received
+type fakeTimer struct {
+	clock    *FakeClock
+	c        chan time.Time
+	deadline time.Time
+	active   bool
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	wasActive := t.active
+	t.active = false
+	return wasActive
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	wasActive := t.active
+	t.arm(d)
+	return wasActive
+}
+
+// arm schedules the timer d after the current time. Caller holds clock.mu.
+func (t *fakeTimer) arm(d time.Duration) {
+	t.deadline = t.clock.now.Add(d)
+	t.active = true
+	t.fireIfDue()
+}
+
+// fireIfDue delivers the current time if the timer is due. Caller holds clock.mu.
+func (t *fakeTimer) fireIfDue() {
+	if !t.active || t.deadline.After(t.clock.now) {
+		return
+	}
+	t.active = false
+	select {
+	case t.c <- t.clock.now:
+	default:
+	}
+}
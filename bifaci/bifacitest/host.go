@@ -0,0 +1,353 @@
+// Package bifacitest runs a PluginRuntime against an in-memory host, so plugin tests
+// can invoke caps without pipes, handshakes or hand-built frames:
+//
+//	host := bifacitest.NewTestHost(t, runtime)
+//	resp := host.Invoke(`cap:in="media:text";op=shout;out="media:text"`,
+//		cap.NewCapArgumentValue("media:text", []byte("hi")))
+//	out, err := resp.Bytes()
+//
+// The host answers the plugin's peer invocations with handlers registered with OnPeer,
+// answers its heartbeats, and captures every frame exchanged for assertions. A
+// FakeClock installed with PluginRuntime.SetClock drives the heartbeat watchdog.
+package bifacitest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+	"github.com/machinefabric/capdag-go/bifaci"
+	"github.com/machinefabric/capdag-go/cap"
+	"github.com/machinefabric/capdag-go/urn"
+)
+
+// closeTimeout bounds how long Close waits for the runtime to stop
+const closeTimeout = 5 * time.Second
+
+// PeerHandler answers a peer invocation made by the plugin under test
+type PeerHandler func(args []cap.CapArgumentValue) ([]byte, error)
+
+// Host is an in-memory host connected to one PluginRuntime
+type Host struct {
+	conn     net.Conn
+	reader   *bifaci.FrameReader
+	writer   *bifaci.FrameWriter
+	writeMu  sync.Mutex
+	limits   bifaci.Limits
+	manifest []byte
+	runErr   chan error
+	done     chan struct{} // Closed when the connection ends
+
+	mu        sync.Mutex
+	calls     map[string]*Call
+	probes    map[string]chan struct{}
+	peers     map[string]PeerHandler
+	peerCalls map[string]*peerCall
+	sent      []*bifaci.Frame
+	received  []*bifaci.Frame
+}
+
+// peerCall collects the arguments of a peer invocation until its END
+type peerCall struct {
+	capUrn  string
+	streams map[string]int // stream_id → index in args
+	args    []cap.CapArgumentValue
+}
+
+// NewTestHost connects a host to runtime and performs the handshake. The connection is
+// closed when the test ends, failing it if the runtime doesn't stop or returns an error
+// other than ErrHostSilent.
+func NewTestHost(t testing.TB, runtime *bifaci.PluginRuntime) *Host {
+	t.Helper()
+	pluginSide, hostSide := net.Pipe()
+	h := &Host{
+		conn:      hostSide,
+		reader:    bifaci.NewFrameReader(hostSide),
+		writer:    bifaci.NewFrameWriter(hostSide),
+		runErr:    make(chan error, 1),
+		done:      make(chan struct{}),
+		calls:     make(map[string]*Call),
+		probes:    make(map[string]chan struct{}),
+		peers:     make(map[string]PeerHandler),
+		peerCalls: make(map[string]*peerCall),
+	}
+	go func() { h.runErr <- runtime.RunConn(pluginSide) }()
+
+	manifest, limits, err := bifaci.HandshakeInitiate(h.reader, h.writer)
+	if err != nil {
+		hostSide.Close()
+		t.Fatalf("bifacitest: handshake failed: %v", err)
+	}
+	h.reader.SetLimits(limits)
+	h.writer.SetLimits(limits)
+	h.limits = limits
+	h.manifest = manifest
+
+	go h.readLoop()
+	t.Cleanup(func() {
+		if err := h.Close(); err != nil && err != bifaci.ErrHostSilent {
+			t.Errorf("bifacitest: %v", err)
+		}
+	})
+	return h
+}
+
+// Manifest returns the manifest the plugin sent in its HELLO
+func (h *Host) Manifest() []byte { return h.manifest }
+
+// Limits returns the limits negotiated in the handshake
+func (h *Host) Limits() bifaci.Limits { return h.limits }
+
+// OnPeer answers the plugin's peer invocations of caps matching capUrn with handler.
+// Invocations nothing answers fail with NO_HANDLER.
+func (h *Host) OnPeer(capUrn string, handler PeerHandler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.peers[capUrn] = handler
+}
+
+// Invoke calls capUrn with args, each sent as one argument stream, and waits for the
+// response
+func (h *Host) Invoke(capUrn string, args ...cap.CapArgumentValue) *Response {
+	return h.Start(capUrn, args...).Wait()
+}
+
+// Start sends a request without waiting for its response
+func (h *Host) Start(capUrn string, args ...cap.CapArgumentValue) *Call {
+	call := &Call{Id: bifaci.NewMessageIdRandom(), host: h, done: make(chan struct{})}
+	h.mu.Lock()
+	h.calls[call.Id.ToString()] = call
+	h.mu.Unlock()
+
+	err := h.send(bifaci.NewReq(call.Id, capUrn, nil, "application/cbor"))
+	for i, arg := range args {
+		if err != nil {
+			break
+		}
+		err = h.sendStream(call.Id, fmt.Sprintf("arg-%d", i), arg.MediaUrn, arg.Value)
+	}
+	if err == nil {
+		err = h.send(bifaci.NewEnd(call.Id, nil))
+	}
+	if err != nil {
+		call.finish(fmt.Errorf("failed to send request: %w", err))
+	}
+	return call
+}
+
+// Heartbeat sends a HEARTBEAT and waits for the plugin to answer it
+func (h *Host) Heartbeat() error {
+	id := bifaci.NewMessageIdRandom()
+	answered := make(chan struct{})
+	h.mu.Lock()
+	h.probes[id.ToString()] = answered
+	h.mu.Unlock()
+
+	if err := h.send(bifaci.NewHeartbeat(id)); err != nil {
+		return err
+	}
+	select {
+	case <-answered:
+		return nil
+	case <-h.done:
+		return errors.New("connection closed before the heartbeat was answered")
+	}
+}
+
+// Sent returns every frame the host sent after the handshake
+func (h *Host) Sent() []*bifaci.Frame {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]*bifaci.Frame(nil), h.sent...)
+}
+
+// Received returns every frame the plugin sent after the handshake
+func (h *Host) Received() []*bifaci.Frame {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]*bifaci.Frame(nil), h.received...)
+}
+
+// Close disconnects from the runtime and waits for it to stop, returning its error
+func (h *Host) Close() error {
+	h.conn.Close()
+	<-h.done
+	select {
+	case err := <-h.runErr:
+		h.runErr <- err // Close may be called again
+		return err
+	case <-time.After(closeTimeout):
+		return fmt.Errorf("runtime did not stop within %v of the host disconnecting", closeTimeout)
+	}
+}
+
+func (h *Host) send(frame *bifaci.Frame) error {
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+	if err := h.writer.WriteFrame(frame); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	h.sent = append(h.sent, frame)
+	h.mu.Unlock()
+	return nil
+}
+
+// sendStream sends data as one stream, split into CHUNKs of CBOR byte strings
+func (h *Host) sendStream(id bifaci.MessageId, streamID, mediaUrn string, data []byte) error {
+	if err := h.send(bifaci.NewStreamStart(id, streamID, mediaUrn)); err != nil {
+		return err
+	}
+	var count uint64
+	for offset := 0; offset < len(data) || count == 0; count++ {
+		end := offset + h.limits.MaxChunk
+		if end > len(data) {
+			end = len(data)
+		}
+		payload, err := cborlib.Marshal(data[offset:end])
+		if err != nil {
+			return err
+		}
+		if err := h.send(bifaci.NewChunk(id, streamID, count, payload, count, bifaci.ComputeChecksum(payload))); err != nil {
+			return err
+		}
+		offset = end
+	}
+	return h.send(bifaci.NewStreamEnd(id, streamID, count))
+}
+
+// readLoop dispatches the plugin's frames until the connection ends
+func (h *Host) readLoop() {
+	defer func() {
+		h.mu.Lock()
+		calls := h.calls
+		h.calls = make(map[string]*Call)
+		h.mu.Unlock()
+		for _, call := range calls {
+			call.finish(errors.New("connection closed before the response ended"))
+		}
+		close(h.done)
+	}()
+
+	for {
+		frame, err := h.reader.ReadFrame()
+		if err != nil {
+			return
+		}
+		idKey := frame.Id.ToString()
+
+		h.mu.Lock()
+		h.received = append(h.received, frame)
+		call := h.calls[idKey]
+		if call != nil && (frame.FrameType == bifaci.FrameTypeEnd || frame.FrameType == bifaci.FrameTypeErr) {
+			delete(h.calls, idKey)
+		}
+		h.mu.Unlock()
+
+		switch {
+		case frame.FrameType == bifaci.FrameTypeHeartbeat:
+			h.mu.Lock()
+			answered, probe := h.probes[idKey]
+			delete(h.probes, idKey)
+			h.mu.Unlock()
+			if probe {
+				close(answered)
+			} else {
+				go h.send(bifaci.NewHeartbeat(frame.Id))
+			}
+		case call != nil:
+			call.add(frame)
+		default:
+			h.handlePeerFrame(idKey, frame)
+		}
+	}
+}
+
+// handlePeerFrame collects a peer invocation and answers it once its END arrives
+func (h *Host) handlePeerFrame(idKey string, frame *bifaci.Frame) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch frame.FrameType {
+	case bifaci.FrameTypeReq:
+		capUrn := ""
+		if frame.Cap != nil {
+			capUrn = *frame.Cap
+		}
+		h.peerCalls[idKey] = &peerCall{capUrn: capUrn, streams: make(map[string]int)}
+	case bifaci.FrameTypeStreamStart:
+		if pc, ok := h.peerCalls[idKey]; ok && frame.StreamId != nil && frame.MediaUrn != nil {
+			pc.streams[*frame.StreamId] = len(pc.args)
+			pc.args = append(pc.args, cap.CapArgumentValue{MediaUrn: *frame.MediaUrn})
+		}
+	case bifaci.FrameTypeChunk:
+		if pc, ok := h.peerCalls[idKey]; ok && frame.StreamId != nil {
+			if i, ok := pc.streams[*frame.StreamId]; ok {
+				pc.args[i].Value = append(pc.args[i].Value, chunkBytes(frame.Payload)...)
+			}
+		}
+	case bifaci.FrameTypeEnd:
+		if pc, ok := h.peerCalls[idKey]; ok {
+			delete(h.peerCalls, idKey)
+			go h.answerPeer(frame.Id, pc.capUrn, h.findPeerLocked(pc.capUrn), pc.args)
+		}
+	case bifaci.FrameTypeCancel:
+		if _, ok := h.peerCalls[idKey]; ok {
+			delete(h.peerCalls, idKey)
+			go h.send(bifaci.NewErr(frame.Id, "CANCELLED", "Peer invocation cancelled"))
+		}
+	}
+}
+
+// findPeerLocked returns the handler for capUrn: an exact registration, else one whose
+// URN the request accepts. Caller holds mu.
+func (h *Host) findPeerLocked(capUrn string) PeerHandler {
+	if handler, ok := h.peers[capUrn]; ok {
+		return handler
+	}
+	requestUrn, err := urn.NewCapUrnFromString(capUrn)
+	if err != nil {
+		return nil
+	}
+	for registered, handler := range h.peers {
+		registeredUrn, err := urn.NewCapUrnFromString(registered)
+		if err == nil && requestUrn.Accepts(registeredUrn) {
+			return handler
+		}
+	}
+	return nil
+}
+
+func (h *Host) answerPeer(id bifaci.MessageId, capUrn string, handler PeerHandler, args []cap.CapArgumentValue) {
+	if handler == nil {
+		h.send(bifaci.NewErr(id, "NO_HANDLER", fmt.Sprintf("No peer handler for cap: %s", capUrn)))
+		return
+	}
+	result, err := handler(args)
+	if err != nil {
+		h.send(bifaci.NewErr(id, "HANDLER_ERROR", err.Error()))
+		return
+	}
+	if h.sendStream(id, "peer-result", "media:", result) == nil {
+		h.send(bifaci.NewEnd(id, nil))
+	}
+}
+
+// chunkBytes decodes a CHUNK payload holding a CBOR byte or text string; other values
+// are kept encoded
+func chunkBytes(payload []byte) []byte {
+	var value interface{}
+	if err := cborlib.Unmarshal(payload, &value); err == nil {
+		switch v := value.(type) {
+		case []byte:
+			return v
+		case string:
+			return []byte(v)
+		}
+	}
+	return payload
+}
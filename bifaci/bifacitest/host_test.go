@@ -0,0 +1,124 @@
+package bifacitest
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/machinefabric/capdag-go/bifaci"
+	"github.com/machinefabric/capdag-go/cap"
+)
+
+const shoutCap = `cap:in="media:text";op=shout;out="media:text"`
+const waitCap = `cap:in="media:void";op=wait;out="media:void"`
+const lookupCap = `cap:in="media:text";op=lookup;out="media:text"`
+
+const testManifest = `{"name":"TestPlugin","version":"1.0.0","description":"Test plugin","caps":[` +
+	`{"urn":"cap:in=\"media:text\";op=shout;out=\"media:text\"","title":"Shout","command":"shout"},` +
+	`{"urn":"cap:in=\"media:void\";op=wait;out=\"media:void\"","title":"Wait","command":"wait"}]}`
+
+func newTestRuntime(t *testing.T) *bifaci.PluginRuntime {
+	t.Helper()
+	runtime, err := bifaci.NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	// shout upper-cases its input, or what the host's lookup cap returns for "?"
+	runtime.Register(shoutCap, func(frames <-chan bifaci.Frame, emitter bifaci.StreamEmitter, peer bifaci.PeerInvoker) error {
+		var input []byte
+		for frame := range frames {
+			if frame.FrameType == bifaci.FrameTypeChunk {
+				input = append(input, chunkBytes(frame.Payload)...)
+			}
+		}
+		if string(input) == "?" {
+			response, err := bifaci.PeerCall(context.Background(), peer, lookupCap, []cap.CapArgumentValue{cap.NewCapArgumentValue("media:text", input)})
+			if err != nil {
+				return err
+			}
+			input = chunkBytes(response.First().Data)
+		}
+		return emitter.EmitCbor(bytes.ToUpper(input))
+	})
+	runtime.Register(waitCap, func(frames <-chan bifaci.Frame, emitter bifaci.StreamEmitter, peer bifaci.PeerInvoker) error {
+		for range frames {
+		}
+		<-bifaci.HandlerContext(emitter).Done()
+		return bifaci.HandlerContext(emitter).Err()
+	})
+	return runtime
+}
+
+func TestInvoke(t *testing.T) {
+	host := NewTestHost(t, newTestRuntime(t))
+
+	response := host.Invoke(shoutCap, cap.NewCapArgumentValue("media:text", []byte("hello")))
+	out, err := response.Bytes()
+	if err != nil {
+		t.Fatalf("Invoke failed: %v", err)
+	}
+	if string(out) != "HELLO" {
+		t.Errorf("Expected HELLO, got %q", out)
+	}
+	AssertFrameTypes(t, response.Frames, bifaci.FrameTypeStreamStart, bifaci.FrameTypeChunk, bifaci.FrameTypeStreamEnd, bifaci.FrameTypeEnd)
+
+	if code := host.Invoke(`cap:in="media:void";op=missing;out="media:void"`).ErrorCode(); code != "NO_HANDLER" {
+		t.Errorf("Expected NO_HANDLER, got %q", code)
+	}
+}
+
+func TestPeerInvocation(t *testing.T) {
+	host := NewTestHost(t, newTestRuntime(t))
+	host.OnPeer(lookupCap, func(args []cap.CapArgumentValue) ([]byte, error) {
+		return []byte("looked up " + string(args[0].Value)), nil
+	})
+
+	out, err := host.Invoke(shoutCap, cap.NewCapArgumentValue("media:text", []byte("?"))).Bytes()
+	if err != nil {
+		t.Fatalf("Invoke failed: %v", err)
+	}
+	if string(out) != "LOOKED UP ?" {
+		t.Errorf("Expected the peer result, got %q", out)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	host := NewTestHost(t, newTestRuntime(t))
+
+	call := host.Start(waitCap)
+	if err := call.Cancel(); err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+	if code := call.Wait().ErrorCode(); code != "CANCELLED" {
+		t.Errorf("Expected CANCELLED, got %q", code)
+	}
+}
+
+// TestFakeClockFiresWatchdog: advancing the fake clock past the heartbeat timeout shuts
+// the runtime down without waiting in real time
+func TestFakeClockFiresWatchdog(t *testing.T) {
+	clock := NewFakeClock()
+	runtime := newTestRuntime(t)
+	runtime.SetClock(clock)
+	runtime.SetHeartbeatTimeout(time.Minute)
+	host := NewTestHost(t, runtime)
+
+	call := host.Start(waitCap)
+	// The answered heartbeat is the last frame the watchdog saw
+	if err := host.Heartbeat(); err != nil {
+		t.Fatalf("Heartbeat failed: %v", err)
+	}
+	clock.Advance(59 * time.Second)
+	if err := host.Heartbeat(); err != nil {
+		t.Fatalf("Heartbeat within the timeout failed: %v", err)
+	}
+	clock.Advance(time.Minute)
+
+	if code := call.Wait().ErrorCode(); code != "CANCELLED" {
+		t.Errorf("Expected CANCELLED, got %q", code)
+	}
+	if err := host.Close(); err != bifaci.ErrHostSilent {
+		t.Errorf("Expected ErrHostSilent, got %v", err)
+	}
+}
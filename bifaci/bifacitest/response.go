@@ -0,0 +1,168 @@
+package bifacitest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+	"github.com/machinefabric/capdag-go/bifaci"
+)
+
+// Call is a request in flight, started with Host.Start
+type Call struct {
+	Id   bifaci.MessageId
+	host *Host
+
+	mu     sync.Mutex
+	frames []*bifaci.Frame
+	err    error
+	done   chan struct{}
+	once   sync.Once
+}
+
+// Cancel asks the plugin to abort the request; it answers with ERR CANCELLED
+func (c *Call) Cancel() error {
+	return c.host.send(bifaci.NewCancel(c.Id))
+}
+
+// Wait blocks until the response ends
+func (c *Call) Wait() *Response {
+	<-c.done
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &Response{Frames: c.frames, err: c.err}
+}
+
+func (c *Call) add(frame *bifaci.Frame) {
+	c.mu.Lock()
+	c.frames = append(c.frames, frame)
+	c.mu.Unlock()
+	if frame.FrameType == bifaci.FrameTypeEnd || frame.FrameType == bifaci.FrameTypeErr {
+		c.finish(nil)
+	}
+}
+
+func (c *Call) finish(err error) {
+	c.once.Do(func() {
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+		close(c.done)
+	})
+}
+
+// Response is everything the plugin sent for one request
+type Response struct {
+	Frames []*bifaci.Frame // In order; the last is END or ERR unless the connection failed
+	err    error
+}
+
+// Err returns the plugin's ERR as a *bifaci.HostError, or the transport failure that
+// cut the response short; nil if the response ended with END
+func (r *Response) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.Frames) == 0 {
+		return errors.New("empty response")
+	}
+	last := r.Frames[len(r.Frames)-1]
+	switch last.FrameType {
+	case bifaci.FrameTypeEnd:
+		return nil
+	case bifaci.FrameTypeErr:
+		return &bifaci.HostError{Type: bifaci.HostErrorTypePluginError, Code: last.ErrorCode(), Message: last.ErrorMessage()}
+	default:
+		return fmt.Errorf("response ended with %v", last.FrameType)
+	}
+}
+
+// ErrorCode returns the code of the plugin's ERR, or "" if there is none
+func (r *Response) ErrorCode() string {
+	var hostErr *bifaci.HostError
+	if errors.As(r.Err(), &hostErr) {
+		return hostErr.Code
+	}
+	return ""
+}
+
+// Values decodes the CBOR value of every CHUNK, in order
+func (r *Response) Values() ([]interface{}, error) {
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+	var values []interface{}
+	for _, frame := range r.Frames {
+		if frame.FrameType != bifaci.FrameTypeChunk {
+			continue
+		}
+		var value interface{}
+		if err := cborlib.Unmarshal(frame.Payload, &value); err != nil {
+			return nil, fmt.Errorf("failed to decode CHUNK payload: %w", err)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// Bytes concatenates the byte and text values of the response
+func (r *Response) Bytes() ([]byte, error) {
+	values, err := r.Values()
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	for _, value := range values {
+		switch v := value.(type) {
+		case []byte:
+			data = append(data, v...)
+		case string:
+			data = append(data, v...)
+		default:
+			return nil, fmt.Errorf("response value %T is not bytes or text", value)
+		}
+	}
+	return data, nil
+}
+
+// Logs returns the messages of the LOG frames of the response
+func (r *Response) Logs() []string {
+	var logs []string
+	for _, frame := range r.Frames {
+		if frame.FrameType == bifaci.FrameTypeLog {
+			logs = append(logs, frame.LogMessage())
+		}
+	}
+	return logs
+}
+
+// FrameTypes returns the type of every frame of the response
+func (r *Response) FrameTypes() []bifaci.FrameType {
+	return frameTypes(r.Frames)
+}
+
+// AssertFrameTypes fails the test unless frames have exactly the types want, in order
+func AssertFrameTypes(t testing.TB, frames []*bifaci.Frame, want ...bifaci.FrameType) {
+	t.Helper()
+	got := frameTypes(frames)
+	if len(got) != len(want) {
+		t.Errorf("frame types: got %v, want %v", got, want)
+		return
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("frame types: got %v, want %v", got, want)
+			return
+		}
+	}
+}
+
+func frameTypes(frames []*bifaci.Frame) []bifaci.FrameType {
+	types := make([]bifaci.FrameType, len(frames))
+	for i, frame := range frames {
+		types[i] = frame.FrameType
+	}
+	return types
+}
@@ -0,0 +1,122 @@
+package bifaci
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// BindError is returned by Request.Bind for a struct field whose argument is missing or
+// can't be decoded into it. The runtime reports it as ERR INVALID_ARGUMENT.
+type BindError struct {
+	Field    string // Name of the struct field
+	MediaUrn string // Media URN pattern of the field's capns tag
+	Err      error  // Decoding error, nil if the argument is missing
+}
+
+func (e *BindError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("missing required arg %s for field %s", e.MediaUrn, e.Field)
+	}
+	return fmt.Sprintf("failed to bind arg %s to field %s: %v", e.MediaUrn, e.Field, e.Err)
+}
+
+func (e *BindError) Unwrap() error {
+	return e.Err
+}
+
+var readerType = reflect.TypeOf((*io.Reader)(nil)).Elem()
+
+// Args collects the request's argument streams into an ArgSet (see CollectArgs). The
+// input frames are consumed by the first call; later calls return the same ArgSet.
+func (r *Request) Args() (*ArgSet, error) {
+	if r.args == nil {
+		args, err := CollectArgs(r.frames)
+		if err != nil {
+			return nil, err
+		}
+		r.args = args
+	}
+	return r.args, nil
+}
+
+// Bind collects the request's arguments (see Args) and stores them in the fields of the
+// struct v points to, matched by the media URN pattern of their capns tag as ArgSet does:
+//
+//	var in struct {
+//		Model string `capns:"media:model-spec;textable"`
+//		PDF   []byte `capns:"media:pdf;bytes"`
+//		Pages *int   `capns:"media:page-range;json,optional"`
+//	}
+//	if err := req.Bind(&in); err != nil {
+//		return err
+//	}
+//
+// []byte and string fields get the argument's contents, io.Reader fields a reader of
+// them; any other type is decoded as RegisterTyped decodes its input. Fields are
+// required unless tagged optional, in which case a missing argument leaves them
+// untouched. A missing or undecodable argument fails with a *BindError.
+func (r *Request) Bind(v interface{}) error {
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Pointer || target.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("Bind needs a pointer to a struct, got %T", v)
+	}
+	args, err := r.Args()
+	if err != nil {
+		return err
+	}
+
+	target = target.Elem()
+	for i := 0; i < target.NumField(); i++ {
+		field := target.Type().Field(i)
+		tag, ok := field.Tag.Lookup("capns")
+		if !ok || !field.IsExported() {
+			continue
+		}
+		pattern, optional := tag, false
+		if idx := strings.LastIndex(tag, ","); idx >= 0 && tag[idx+1:] == "optional" {
+			pattern, optional = tag[:idx], true
+		}
+
+		arg, err := args.Find(pattern)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", field.Name, err)
+		}
+		if arg == nil {
+			if optional {
+				continue
+			}
+			return &BindError{Field: field.Name, MediaUrn: pattern}
+		}
+		if err := bindArg(arg, target.Field(i)); err != nil {
+			return &BindError{Field: field.Name, MediaUrn: pattern, Err: err}
+		}
+	}
+	return nil
+}
+
+// bindArg stores an argument in a struct field
+func bindArg(arg *Arg, field reflect.Value) error {
+	switch {
+	case field.Type() == reflect.TypeOf([]byte(nil)), field.Kind() == reflect.String, field.Type() == readerType:
+		data, err := arg.Bytes()
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			data = []byte{}
+		}
+		switch {
+		case field.Kind() == reflect.String:
+			field.SetString(string(data))
+		case field.Type() == readerType:
+			field.Set(reflect.ValueOf(bytes.NewReader(data)))
+		default:
+			field.SetBytes(data)
+		}
+		return nil
+	}
+	return decodeTypedArg(arg.Data, field.Addr().Interface())
+}
@@ -0,0 +1,73 @@
+package bifaci
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestRequestBind(t *testing.T) {
+	req := &Request{frames: argFrames(map[string][]interface{}{
+		"media:model-spec;textable": {"small"},
+		"media:pdf;bytes":           {[]byte("%PDF"), []byte("-1.7")},
+		"media:page-range;json":     {`{"first":2,"last":5}`},
+	}, "media:model-spec;textable", "media:pdf;bytes", "media:page-range;json")}
+
+	var in struct {
+		Model  string    `capns:"media:model-spec;textable"`
+		PDF    []byte    `capns:"media:pdf;bytes"`
+		Reader io.Reader `capns:"media:pdf"`
+		Pages  *struct {
+			First int `json:"first"`
+			Last  int `json:"last"`
+		} `capns:"media:page-range;json"`
+		Lang    string `capns:"media:language;textable,optional"`
+		Ignored string
+	}
+	in.Lang = "en"
+	if err := req.Bind(&in); err != nil {
+		t.Fatalf("Bind failed: %v", err)
+	}
+	if in.Model != "small" || string(in.PDF) != "%PDF-1.7" {
+		t.Errorf("Expected the model and PDF bound, got %q %q", in.Model, in.PDF)
+	}
+	if data, _ := io.ReadAll(in.Reader); string(data) != "%PDF-1.7" {
+		t.Errorf("Expected a reader of the PDF, got %q", data)
+	}
+	if in.Pages == nil || in.Pages.First != 2 || in.Pages.Last != 5 {
+		t.Errorf("Expected the page range decoded, got %+v", in.Pages)
+	}
+	if in.Lang != "en" {
+		t.Errorf("Expected a missing optional arg to leave the field untouched, got %q", in.Lang)
+	}
+
+	// The arguments stay available once the frames are consumed
+	var again struct {
+		Model string `capns:"media:model-spec"`
+	}
+	if err := req.Bind(&again); err != nil || again.Model != "small" {
+		t.Errorf("Expected a second Bind to see the same args, got %q (%v)", again.Model, err)
+	}
+}
+
+func TestRequestBindMissing(t *testing.T) {
+	req := &Request{frames: argFrames(map[string][]interface{}{
+		"media:textable": {"hi"},
+	}, "media:textable")}
+
+	var in struct {
+		PDF []byte `capns:"media:pdf;bytes"`
+	}
+	err := req.Bind(&in)
+	var bindErr *BindError
+	if !errors.As(err, &bindErr) || bindErr.Field != "PDF" || bindErr.Err != nil {
+		t.Fatalf("Expected a BindError for the missing PDF, got %v", err)
+	}
+	if code := handlerErrorCode(err); code != "INVALID_ARGUMENT" {
+		t.Errorf("Expected INVALID_ARGUMENT, got %s", code)
+	}
+
+	if err := req.Bind(in); err == nil {
+		t.Error("Expected an error binding a non-pointer")
+	}
+}
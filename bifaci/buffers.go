@@ -0,0 +1,84 @@
+package bifaci
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+)
+
+// maxPooledBuffer caps the buffers kept for reuse: a buffer grown by an oversized frame
+// is left to the GC rather than pinned in the pool
+const maxPooledBuffer = 2 * DefaultMaxFrame
+
+// frameBuffers holds the buffers FrameWriter encodes frames into. A buffer lives for one
+// WriteFrame or WriteFrames call, so that writing a long stream reuses a handful of
+// buffers instead of allocating one per frame.
+var frameBuffers = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+func getFrameBuffer() *bytes.Buffer {
+	buf := frameBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putFrameBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	frameBuffers.Put(buf)
+}
+
+// appendLengthPrefixed appends the 4-byte big-endian length prefix and the CBOR encoding
+// of frame to buf, and returns the encoding's offset in buf
+func appendLengthPrefixed(buf *bytes.Buffer, frame *Frame) (int, error) {
+	start := buf.Len()
+	buf.Write([]byte{0, 0, 0, 0})
+	if err := encodeFrameTo(buf, frame); err != nil {
+		buf.Truncate(start)
+		return 0, err
+	}
+	binary.BigEndian.PutUint32(buf.Bytes()[start:], uint32(buf.Len()-start-4))
+	return start + 4, nil
+}
+
+// chunkEncoder builds CHUNK payloads, each an independently decodable CBOR byte or text
+// string, in a buffer reused from one chunk to the next. A payload is only valid until
+// the next one is built, so it must be written before then; FrameWriter doesn't keep
+// frames past WriteFrame.
+type chunkEncoder struct {
+	buf []byte
+}
+
+// bytes returns data encoded as a CBOR byte string
+func (c *chunkEncoder) bytes(data []byte) []byte {
+	c.buf = appendCBORHead(c.buf[:0], 2, uint64(len(data)))
+	c.buf = append(c.buf, data...)
+	return c.buf
+}
+
+// text returns text encoded as a CBOR text string; text must be valid UTF-8
+func (c *chunkEncoder) text(text string) []byte {
+	c.buf = appendCBORHead(c.buf[:0], 3, uint64(len(text)))
+	c.buf = append(c.buf, text...)
+	return c.buf
+}
+
+// appendCBORHead appends the head of a CBOR data item of the given major type and
+// argument, in its shortest form as the CBOR encoder writes it
+func appendCBORHead(dst []byte, major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return append(dst, major|byte(n))
+	case n <= 0xff:
+		return append(dst, major|24, byte(n))
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16(append(dst, major|25), uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32(append(dst, major|26), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(dst, major|27), n)
+	}
+}
@@ -0,0 +1,97 @@
+package bifaci
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+)
+
+// The chunk encoder must produce exactly what the CBOR encoder does, at every head size
+func TestChunkEncoderMatchesCBOR(t *testing.T) {
+	var encoder chunkEncoder
+	for _, n := range []int{0, 1, 23, 24, 255, 256, 65535, 65536} {
+		data := bytes.Repeat([]byte{'x'}, n)
+		want, _ := cborlib.Marshal(data)
+		if got := encoder.bytes(data); !bytes.Equal(got, want) {
+			t.Errorf("%d bytes: byte string encodes differently", n)
+		}
+		want, _ = cborlib.Marshal(string(data))
+		if got := encoder.text(string(data)); !bytes.Equal(got, want) {
+			t.Errorf("%d bytes: text string encodes differently", n)
+		}
+	}
+}
+
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestWriteFramesBatches(t *testing.T) {
+	id := NewMessageIdRandom()
+	frames := []*Frame{NewStreamEnd(id, "s", 2), NewEnd(id, nil)}
+
+	var single bytes.Buffer
+	for _, frame := range frames {
+		if err := NewFrameWriter(&single).WriteFrame(frame); err != nil {
+			t.Fatalf("WriteFrame failed: %v", err)
+		}
+	}
+	batched := &countingWriter{}
+	if err := NewFrameWriter(batched).WriteFrames(frames...); err != nil {
+		t.Fatalf("WriteFrames failed: %v", err)
+	}
+	if batched.writes != 1 {
+		t.Errorf("Expected a single write, got %d", batched.writes)
+	}
+	if !bytes.Equal(batched.Bytes(), single.Bytes()) {
+		t.Error("Batched frames differ from frames written one by one")
+	}
+
+	// Nothing is written when one of the frames is over the limit
+	limits := DefaultLimits()
+	limits.MaxFrame = 1024
+	rejected := &countingWriter{}
+	writer := NewFrameWriter(rejected)
+	writer.SetLimits(limits)
+	big := NewChunk(id, "s", 0, make([]byte, 2048), 0, 0)
+	if err := writer.WriteFrames(NewStreamStart(id, "s", "media:"), big); err == nil {
+		t.Error("Expected the oversized frame to be rejected")
+	}
+	if rejected.writes != 0 {
+		t.Errorf("Expected no write, got %d", rejected.writes)
+	}
+}
+
+// Emitting a large value reuses buffers: allocation stays well below the bytes emitted
+func TestEmitterReusesBuffers(t *testing.T) {
+	if raceEnabled {
+		t.Skip("sync.Pool drops buffers at random under the race detector")
+	}
+	writer := newSyncFrameWriter(NewFrameWriter(io.Discard))
+	emitter := newThreadSafeEmitter(context.Background(), writer, NewMessageIdRandom(), nil, "result", "media:", DefaultMaxChunk, nil, defaultLogger())
+	output := strings.Repeat("x", 64*DefaultMaxChunk)
+	if err := emitter.EmitCbor(output[:DefaultMaxChunk]); err != nil { // Warm the pool
+		t.Fatalf("EmitCbor failed: %v", err)
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if err := emitter.EmitCbor(output); err != nil {
+		t.Fatalf("EmitCbor failed: %v", err)
+	}
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > uint64(len(output)/4) {
+		t.Errorf("Emitting %d bytes allocated %d bytes", len(output), allocated)
+	}
+}
@@ -0,0 +1,120 @@
+package bifaci
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrCode is the code of an ERR frame, telling the class of the failure. An ErrCode is
+// an error itself, so that errors.Is(err, ErrCodeBusy) holds for a *CapError,
+// *PeerError or *HostError with that code.
+type ErrCode string
+
+const (
+	ErrCodeNoHandler         ErrCode = "NO_HANDLER"         // No handler serves the requested cap
+	ErrCodeHandler           ErrCode = "HANDLER_ERROR"      // The handler failed
+	ErrCodeProtocol          ErrCode = "PROTOCOL_ERROR"     // A frame broke the protocol
+	ErrCodeInvalidRequest    ErrCode = "INVALID_REQUEST"    // The REQ is malformed, e.g. has no cap URN
+	ErrCodeInvalidArgument   ErrCode = "INVALID_ARGUMENT"   // An argument is missing or can't be decoded
+	ErrCodeValidation        ErrCode = "VALIDATION_ERROR"   // Arguments fail their media spec schemas
+	ErrCodeInvalidOutput     ErrCode = "INVALID_OUTPUT"     // The handler's output fails the output spec
+	ErrCodeCorruptedData     ErrCode = "CORRUPTED_DATA"     // A CHUNK failed its checksum
+	ErrCodeCancelled         ErrCode = "CANCELLED"          // The request was cancelled
+	ErrCodeTimeout           ErrCode = "TIMEOUT"            // The handler exceeded its deadline
+	ErrCodeBusy              ErrCode = "BUSY"               // Too many concurrent requests
+	ErrCodeRouteConflict     ErrCode = "ROUTE_CONFLICT"     // Several handler patterns match the cap equally well
+	ErrCodeProcessFailed     ErrCode = "PROCESS_FAILED"     // The process an ExecCap handler ran failed
+	ErrCodeSpawnFailed       ErrCode = "SPAWN_FAILED"       // The host couldn't start the plugin
+	ErrCodePluginDied        ErrCode = "PLUGIN_DIED"        // The plugin exited with the request in flight
+	ErrCodeStartFailed       ErrCode = "START_FAILED"       // The plugin's OnStart hooks failed
+	ErrCodeResourceExhausted ErrCode = "RESOURCE_EXHAUSTED" // The request exceeded its cap's resource policy
+	ErrCodeDuplicateRequest  ErrCode = "DUPLICATE_REQUEST"  // A REQ reused the message ID of a running or completed request
+	ErrCodePermissionDenied  ErrCode = "PERMISSION_DENIED"  // The plugin's manifest doesn't permit invoking the cap
+	ErrCodeJobNotFound       ErrCode = "JOB_NOT_FOUND"      // No async job has the ID
+	ErrCodeJobRunning        ErrCode = "JOB_RUNNING"        // The async job has no result yet
+)
+
+func (c ErrCode) Error() string {
+	return string(c)
+}
+
+// Retryable reports whether a request failing with the code may succeed when sent
+// again unchanged: BUSY, TIMEOUT, CORRUPTED_DATA, PLUGIN_DIED and JOB_RUNNING
+func (c ErrCode) Retryable() bool {
+	switch c {
+	case ErrCodeBusy, ErrCodeTimeout, ErrCodeCorruptedData, ErrCodePluginDied, ErrCodeJobRunning:
+		return true
+	}
+	return false
+}
+
+// CapError is a failure carried by an ERR frame. A handler returning one answers with
+// its code, message, retryability and details; ErrorFromFrame turns an ERR frame back
+// into one.
+type CapError struct {
+	Code      ErrCode
+	Message   string
+	Retryable bool                   // Sending the request again may succeed
+	Details   map[string]interface{} // Meta beyond code and message, e.g. "violations"
+}
+
+// NewCapError returns a CapError whose retryability is the code's
+func NewCapError(code ErrCode, message string) *CapError {
+	return &CapError{Code: code, Message: message, Retryable: code.Retryable()}
+}
+
+func (e *CapError) Error() string {
+	return fmt.Sprintf("[%s] %s", e.Code, e.Message)
+}
+
+// Is matches the ErrCode of the error
+func (e *CapError) Is(target error) bool {
+	code, ok := target.(ErrCode)
+	return ok && code == e.Code
+}
+
+// ErrorFromFrame returns the CapError an ERR frame carries, nil for other frames. An
+// ERR without meta "retryable" is retryable if its code is.
+func ErrorFromFrame(frame *Frame) *CapError {
+	if frame == nil || frame.FrameType != FrameTypeErr {
+		return nil
+	}
+	capErr := NewCapError(ErrCode(frame.ErrorCode()), frame.ErrorMessage())
+	for key, value := range frame.Meta {
+		switch key {
+		case "code", "message":
+		case "retryable":
+			if retryable, ok := value.(bool); ok {
+				capErr.Retryable = retryable
+			}
+		default:
+			if capErr.Details == nil {
+				capErr.Details = make(map[string]interface{})
+			}
+			capErr.Details[key] = normalizeMetaValue(value)
+		}
+	}
+	return capErr
+}
+
+// NewErrFromError builds the ERR frame answering a request that failed with err: its
+// code is that of a *CapError in err, or the one the runtime gives the error types it
+// knows (HANDLER_ERROR for others), and its meta carries the error's details and
+// whether it is retryable
+func NewErrFromError(id MessageId, err error) *Frame {
+	code := ErrCode(handlerErrorCode(err))
+	message := err.Error()
+	retryable := code.Retryable()
+	var capErr *CapError
+	if errors.As(err, &capErr) {
+		retryable = capErr.Retryable
+		if err == error(capErr) {
+			message = capErr.Message
+		}
+	}
+
+	frame := NewErr(id, string(code), message)
+	setErrorDetails(frame, err)
+	frame.Meta["retryable"] = retryable
+	return frame
+}
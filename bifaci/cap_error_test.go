@@ -0,0 +1,88 @@
+package bifaci
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCapErrorFrameRoundTrip(t *testing.T) {
+	id := NewMessageIdRandom()
+	handlerErr := NewCapError(ErrCodeBusy, "queue full")
+	handlerErr.Details = map[string]interface{}{"queue": "render"}
+
+	encoded, err := EncodeFrame(NewErrFromError(id, handlerErr))
+	if err != nil {
+		t.Fatalf("EncodeFrame failed: %v", err)
+	}
+	frame, err := DecodeFrame(encoded)
+	if err != nil {
+		t.Fatalf("DecodeFrame failed: %v", err)
+	}
+	if frame.ErrorCode() != "BUSY" || frame.ErrorMessage() != "queue full" {
+		t.Errorf("Unexpected ERR [%s] %s", frame.ErrorCode(), frame.ErrorMessage())
+	}
+
+	capErr := ErrorFromFrame(frame)
+	if capErr.Code != ErrCodeBusy || !capErr.Retryable || capErr.Details["queue"] != "render" {
+		t.Errorf("Unexpected error %+v", capErr)
+	}
+	wrapped := fmt.Errorf("render failed: %w", capErr)
+	if !errors.Is(wrapped, ErrCodeBusy) || errors.Is(wrapped, ErrCodeTimeout) {
+		t.Errorf("Expected errors.Is to match BUSY only")
+	}
+	if ErrorFromFrame(NewEnd(id, nil)) != nil {
+		t.Error("Expected no error for END")
+	}
+}
+
+func TestNewErrFromError(t *testing.T) {
+	id := NewMessageIdRandom()
+	cases := []struct {
+		err       error
+		code      string
+		message   string
+		retryable bool
+	}{
+		{errors.New("boom"), "HANDLER_ERROR", "boom", false},
+		{&EnvArgError{Arg: "media:key", Var: "KEY"}, "INVALID_ARGUMENT", "required argument missing: media:key (environment variable KEY is not set)", false},
+		{&CapError{Code: ErrCodeTimeout, Message: "too slow"}, "TIMEOUT", "too slow", false},
+		{fmt.Errorf("upstream: %w", NewCapError(ErrCodePluginDied, "gone")), "PLUGIN_DIED", "upstream: [PLUGIN_DIED] gone", true},
+	}
+	for _, c := range cases {
+		frame := NewErrFromError(id, c.err)
+		if frame.ErrorCode() != c.code || frame.ErrorMessage() != c.message || frame.Meta["retryable"] != c.retryable {
+			t.Errorf("%v: unexpected ERR %v", c.err, frame.Meta)
+		}
+	}
+
+	// An ERR without "retryable" is retryable if its code is
+	if !ErrorFromFrame(NewErr(id, "BUSY", "later")).Retryable || ErrorFromFrame(NewErr(id, "NO_HANDLER", "never")).Retryable {
+		t.Error("Expected the retryability of the code")
+	}
+	hostErr := &HostError{Type: HostErrorTypePluginError, Code: "NO_HANDLER", Message: "never"}
+	if !errors.Is(hostErr, ErrCodeNoHandler) {
+		t.Error("Expected the host error to match NO_HANDLER")
+	}
+}
+
+// Without RetryOn, retryable ERRs are retried
+func TestRetryPolicyRetryableErrors(t *testing.T) {
+	for _, c := range []struct {
+		err      error
+		attempts int
+	}{
+		{NewCapError(ErrCodeBusy, "later"), 2},
+		{NewCapError(ErrCodeHandler, "broken"), 1},
+	} {
+		attempts := 0
+		retryPeerCall(context.Background(), RetryPolicy{MaxAttempts: 2}, func() (*PeerResponse, error) {
+			attempts++
+			return nil, c.err
+		})
+		if attempts != c.attempts {
+			t.Errorf("%v: expected %d attempts, got %d", c.err, c.attempts, attempts)
+		}
+	}
+}
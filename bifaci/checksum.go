@@ -0,0 +1,322 @@
+package bifaci
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math/bits"
+)
+
+// ChecksumAlgorithm names the hash that fills the checksum of CHUNK frames. Peers agree on
+// one in the handshake: the host offers the algorithms it accepts in its HELLO
+// ("checksums"), and the plugin names its pick in its own HELLO ("checksum"). Without
+// an agreement both sides use FNV-1a, as every protocol v2 peer does.
+type ChecksumAlgorithm string
+
+const (
+	ChecksumFNV1a64 ChecksumAlgorithm = "fnv1a64" // Default; matches Rust Frame::compute_checksum
+	ChecksumCRC32C  ChecksumAlgorithm = "crc32c"  // Castagnoli CRC, hardware-accelerated where the CPU supports it
+	ChecksumXXH64   ChecksumAlgorithm = "xxh64"   // xxHash64 with seed 0
+	ChecksumBLAKE3  ChecksumAlgorithm = "blake3"  // First 8 bytes of the BLAKE3 hash, little-endian
+)
+
+// SupportedChecksums lists the algorithms this implementation computes, fastest first
+func SupportedChecksums() []ChecksumAlgorithm {
+	return []ChecksumAlgorithm{ChecksumXXH64, ChecksumCRC32C, ChecksumBLAKE3, ChecksumFNV1a64}
+}
+
+// normalize maps the zero value (the default of Limits.Checksum) to FNV-1a
+func (a ChecksumAlgorithm) normalize() ChecksumAlgorithm {
+	if a == "" {
+		return ChecksumFNV1a64
+	}
+	return a
+}
+
+// Supported reports whether Sum implements a
+func (a ChecksumAlgorithm) Supported() bool {
+	switch a.normalize() {
+	case ChecksumFNV1a64, ChecksumCRC32C, ChecksumXXH64, ChecksumBLAKE3:
+		return true
+	default:
+		return false
+	}
+}
+
+// Sum computes the checksum of data. Unsupported algorithms fall back to FNV-1a; the
+// handshake never agrees on one.
+func (a ChecksumAlgorithm) Sum(data []byte) uint64 {
+	switch a.normalize() {
+	case ChecksumCRC32C:
+		return uint64(crc32.Checksum(data, crc32cTable))
+	case ChecksumXXH64:
+		return xxh64(data)
+	case ChecksumBLAKE3:
+		return blake3Sum64(data)
+	default:
+		return ComputeChecksum(data)
+	}
+}
+
+// negotiateChecksum returns the first offered algorithm this side supports, or FNV-1a
+func negotiateChecksum(offered []ChecksumAlgorithm) ChecksumAlgorithm {
+	for _, algorithm := range offered {
+		if algorithm.Supported() {
+			return algorithm.normalize()
+		}
+	}
+	return ChecksumFNV1a64
+}
+
+// checksumsFromMeta reads the algorithms a HELLO offers, in preference order
+func checksumsFromMeta(meta map[string]interface{}) []ChecksumAlgorithm {
+	values, _ := meta["checksums"].([]interface{})
+	var offered []ChecksumAlgorithm
+	for _, value := range values {
+		if name, ok := value.(string); ok {
+			offered = append(offered, ChecksumAlgorithm(name))
+		}
+	}
+	return offered
+}
+
+// checksumOffered reports whether the plugin's pick is FNV-1a or one of the host's offers
+func checksumOffered(algorithm ChecksumAlgorithm, offered []ChecksumAlgorithm) bool {
+	if algorithm.normalize() == ChecksumFNV1a64 {
+		return true
+	}
+	for _, o := range offered {
+		if o.normalize() == algorithm.normalize() && o.Supported() {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyChunkChecksumWith verifies a CHUNK frame's checksum under algorithm
+func VerifyChunkChecksumWith(frame *Frame, algorithm ChecksumAlgorithm) error {
+	if frame.Checksum == nil {
+		return fmt.Errorf("CHUNK frame missing required checksum field")
+	}
+	expected := algorithm.Sum(frame.Payload)
+	if *frame.Checksum != expected {
+		return fmt.Errorf("CHUNK %s checksum mismatch: expected %d, got %d (payload %d bytes)",
+			algorithm.normalize(), expected, *frame.Checksum, len(frame.Payload))
+	}
+	return nil
+}
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// =============================================================================
+// xxHash64
+// =============================================================================
+
+const (
+	xxhPrime1 uint64 = 11400714785074694791
+	xxhPrime2 uint64 = 14029467366897019727
+	xxhPrime3 uint64 = 1609587929392839161
+	xxhPrime4 uint64 = 9650029242287828579
+	xxhPrime5 uint64 = 2870177450012600261
+)
+
+func xxh64Round(acc, input uint64) uint64 {
+	acc += input * xxhPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxhPrime1
+}
+
+func xxh64Merge(acc, val uint64) uint64 {
+	acc ^= xxh64Round(0, val)
+	return acc*xxhPrime1 + xxhPrime4
+}
+
+// xxh64 is xxHash64 of data with seed 0
+func xxh64(data []byte) uint64 {
+	n := len(data)
+	var h uint64
+	if n >= 32 {
+		prime1 := xxhPrime1 // Not constant: the initial accumulators wrap around
+		v1 := prime1 + xxhPrime2
+		v2 := xxhPrime2
+		v3 := uint64(0)
+		v4 := -prime1
+		for len(data) >= 32 {
+			v1 = xxh64Round(v1, binary.LittleEndian.Uint64(data[0:]))
+			v2 = xxh64Round(v2, binary.LittleEndian.Uint64(data[8:]))
+			v3 = xxh64Round(v3, binary.LittleEndian.Uint64(data[16:]))
+			v4 = xxh64Round(v4, binary.LittleEndian.Uint64(data[24:]))
+			data = data[32:]
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxh64Merge(h, v1)
+		h = xxh64Merge(h, v2)
+		h = xxh64Merge(h, v3)
+		h = xxh64Merge(h, v4)
+	} else {
+		h = xxhPrime5
+	}
+	h += uint64(n)
+
+	for ; len(data) >= 8; data = data[8:] {
+		h ^= xxh64Round(0, binary.LittleEndian.Uint64(data))
+		h = bits.RotateLeft64(h, 27)*xxhPrime1 + xxhPrime4
+	}
+	if len(data) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(data)) * xxhPrime1
+		h = bits.RotateLeft64(h, 23)*xxhPrime2 + xxhPrime3
+		data = data[4:]
+	}
+	for _, b := range data {
+		h ^= uint64(b) * xxhPrime5
+		h = bits.RotateLeft64(h, 11) * xxhPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxhPrime2
+	h ^= h >> 29
+	h *= xxhPrime3
+	h ^= h >> 32
+	return h
+}
+
+// =============================================================================
+// BLAKE3 (hash mode, portable implementation)
+// =============================================================================
+
+const (
+	blake3ChunkLen   = 1024
+	blake3BlockLen   = 64
+	blake3ChunkStart = 1 << 0
+	blake3ChunkEnd   = 1 << 1
+	blake3Parent     = 1 << 2
+	blake3Root       = 1 << 3
+)
+
+var blake3IV = [8]uint32{
+	0x6A09E667, 0xBB67AE85, 0x3C6EF372, 0xA54FF53A, 0x510E527F, 0x9B05688C, 0x1F83D9AB, 0x5BE0CD19,
+}
+
+var blake3MsgPermutation = [16]int{2, 6, 3, 10, 7, 0, 4, 13, 1, 11, 12, 5, 9, 14, 15, 8}
+
+func blake3G(s *[16]uint32, a, b, c, d int, x, y uint32) {
+	s[a] = s[a] + s[b] + x
+	s[d] = bits.RotateLeft32(s[d]^s[a], -16)
+	s[c] = s[c] + s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -12)
+	s[a] = s[a] + s[b] + y
+	s[d] = bits.RotateLeft32(s[d]^s[a], -8)
+	s[c] = s[c] + s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -7)
+}
+
+func blake3Compress(cv *[8]uint32, block *[16]uint32, counter uint64, blockLen uint32, flags uint32) [16]uint32 {
+	s := [16]uint32{
+		cv[0], cv[1], cv[2], cv[3], cv[4], cv[5], cv[6], cv[7],
+		blake3IV[0], blake3IV[1], blake3IV[2], blake3IV[3],
+		uint32(counter), uint32(counter >> 32), blockLen, flags,
+	}
+	m := *block
+	for round := 0; round < 7; round++ {
+		blake3G(&s, 0, 4, 8, 12, m[0], m[1])
+		blake3G(&s, 1, 5, 9, 13, m[2], m[3])
+		blake3G(&s, 2, 6, 10, 14, m[4], m[5])
+		blake3G(&s, 3, 7, 11, 15, m[6], m[7])
+		blake3G(&s, 0, 5, 10, 15, m[8], m[9])
+		blake3G(&s, 1, 6, 11, 12, m[10], m[11])
+		blake3G(&s, 2, 7, 8, 13, m[12], m[13])
+		blake3G(&s, 3, 4, 9, 14, m[14], m[15])
+		var permuted [16]uint32
+		for i, j := range blake3MsgPermutation {
+			permuted[i] = m[j]
+		}
+		m = permuted
+	}
+	for i := 0; i < 8; i++ {
+		s[i] ^= s[i+8]
+		s[i+8] ^= cv[i]
+	}
+	return s
+}
+
+// blake3Output is the last compression of a chunk or parent, not yet run: it yields a
+// chaining value, or the root hash when it is the last one
+type blake3Output struct {
+	cv       [8]uint32
+	block    [16]uint32
+	counter  uint64
+	blockLen uint32
+	flags    uint32
+}
+
+func (o *blake3Output) chainingValue() [8]uint32 {
+	s := blake3Compress(&o.cv, &o.block, o.counter, o.blockLen, o.flags)
+	var cv [8]uint32
+	copy(cv[:], s[:8])
+	return cv
+}
+
+func blake3BlockWords(data []byte) [16]uint32 {
+	var padded [blake3BlockLen]byte
+	copy(padded[:], data)
+	var words [16]uint32
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(padded[i*4:])
+	}
+	return words
+}
+
+// blake3ChunkOutput compresses all but the last block of a chunk (at most 1024 bytes)
+func blake3ChunkOutput(chunk []byte, counter uint64) blake3Output {
+	cv := blake3IV
+	flags := uint32(blake3ChunkStart)
+	for len(chunk) > blake3BlockLen {
+		block := blake3BlockWords(chunk[:blake3BlockLen])
+		s := blake3Compress(&cv, &block, counter, blake3BlockLen, flags)
+		copy(cv[:], s[:8])
+		chunk = chunk[blake3BlockLen:]
+		flags = 0
+	}
+	return blake3Output{
+		cv:       cv,
+		block:    blake3BlockWords(chunk),
+		counter:  counter,
+		blockLen: uint32(len(chunk)),
+		flags:    flags | blake3ChunkEnd,
+	}
+}
+
+func blake3ParentOutput(left, right [8]uint32) blake3Output {
+	var block [16]uint32
+	copy(block[:8], left[:])
+	copy(block[8:], right[:])
+	return blake3Output{cv: blake3IV, block: block, blockLen: blake3BlockLen, flags: blake3Parent}
+}
+
+// blake3Sum64 returns the first 8 bytes of the BLAKE3 hash of data as a little-endian
+// uint64
+func blake3Sum64(data []byte) uint64 {
+	// Chaining values of completed subtrees; a chunk is pushed only once more input
+	// follows it, so the last chunk always becomes the root's right-most leaf
+	var stack [][8]uint32
+	var counter uint64
+	for len(data) > blake3ChunkLen {
+		output := blake3ChunkOutput(data[:blake3ChunkLen], counter)
+		cv := output.chainingValue()
+		counter++
+		for total := counter; total&1 == 0; total >>= 1 {
+			parent := blake3ParentOutput(stack[len(stack)-1], cv)
+			cv = parent.chainingValue()
+			stack = stack[:len(stack)-1]
+		}
+		stack = append(stack, cv)
+		data = data[blake3ChunkLen:]
+	}
+
+	output := blake3ChunkOutput(data, counter)
+	for i := len(stack) - 1; i >= 0; i-- {
+		output = blake3ParentOutput(stack[i], output.chainingValue())
+	}
+	root := blake3Compress(&output.cv, &output.block, 0, output.blockLen, output.flags|blake3Root)
+	return uint64(root[0]) | uint64(root[1])<<32
+}
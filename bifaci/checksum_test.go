@@ -0,0 +1,216 @@
+package bifaci
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// Published test vectors; BLAKE3 inputs are the official ones (byte i = i % 251), of
+// which the checksum keeps the first 8 bytes of the hash
+func TestChecksumAlgorithmVectors(t *testing.T) {
+	blake3Input := func(n int) []byte {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = byte(i % 251)
+		}
+		return b
+	}
+	leHex := func(sum uint64) string {
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, sum)
+		return fmt.Sprintf("%x", b)
+	}
+
+	for _, tc := range []struct {
+		n    int
+		want string
+	}{
+		{0, "af1349b9f5f9a1a6"},
+		{1, "2d3adedff11b61f1"},
+		{1024, "42214739f095a406"},
+		{1025, "d00278ae47eb27b3"},
+		{2048, "e776b6028c7cd22a"},
+		{3072, "b98cb0ff3623be03"},
+		{8192, "aae792484c8efe4f"},
+	} {
+		if got := leHex(ChecksumBLAKE3.Sum(blake3Input(tc.n))); got != tc.want {
+			t.Errorf("BLAKE3 of %d bytes: got %s, want %s", tc.n, got, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		input string
+		want  uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"abc", 0x44bc2cf5ad770999},
+		{"Nobody inspects the spammish repetition", 0xfbcea83c8a378bf1},
+	} {
+		if got := ChecksumXXH64.Sum([]byte(tc.input)); got != tc.want {
+			t.Errorf("xxh64(%q): got %x, want %x", tc.input, got, tc.want)
+		}
+	}
+
+	if got := ChecksumCRC32C.Sum([]byte("123456789")); got != 0xe3069283 {
+		t.Errorf("crc32c: got %x, want e3069283", got)
+	}
+	if got := ChecksumAlgorithm("").Sum([]byte("abc")); got != ComputeChecksum([]byte("abc")) {
+		t.Error("The zero algorithm must be FNV-1a")
+	}
+}
+
+// handshakePipes runs both sides of the handshake over in-memory pipes
+func handshakePipes(t *testing.T, options HandshakeOptions) (hostLimits, pluginLimits Limits) {
+	t.Helper()
+	hostToPluginR, hostToPluginW := io.Pipe()
+	pluginToHostR, pluginToHostW := io.Pipe()
+	defer hostToPluginW.Close()
+	defer pluginToHostW.Close()
+
+	accepted := make(chan error, 1)
+	go func() {
+		var err error
+		pluginLimits, err = HandshakeAccept(NewFrameReader(hostToPluginR), NewFrameWriter(pluginToHostW), []byte(testManifest))
+		accepted <- err
+	}()
+	_, hostLimits, err := HandshakeInitiateWithOptions(NewFrameReader(pluginToHostR), NewFrameWriter(hostToPluginW), options)
+	if err != nil {
+		t.Fatalf("HandshakeInitiateWithOptions failed: %v", err)
+	}
+	if err := <-accepted; err != nil {
+		t.Fatalf("HandshakeAccept failed: %v", err)
+	}
+	return hostLimits, pluginLimits
+}
+
+func TestChecksumNegotiation(t *testing.T) {
+	host, plugin := handshakePipes(t, HandshakeOptions{Checksums: []ChecksumAlgorithm{"sha1", ChecksumBLAKE3, ChecksumXXH64}})
+	if host.Checksum != ChecksumBLAKE3 || plugin.Checksum != ChecksumBLAKE3 {
+		t.Errorf("Expected both sides to pick the first supported offer, got host %q plugin %q", host.Checksum, plugin.Checksum)
+	}
+
+	host, plugin = handshakePipes(t, HandshakeOptions{})
+	if host.Checksum.normalize() != ChecksumFNV1a64 || plugin.Checksum.normalize() != ChecksumFNV1a64 {
+		t.Errorf("Without an offer both sides keep FNV-1a, got host %q plugin %q", host.Checksum, plugin.Checksum)
+	}
+}
+
+// Frames read from a connection verify under its algorithm, and a writer restamps frames
+// checksummed for another connection unless their checksum is already wrong
+func TestChecksumRestampedAcrossConnections(t *testing.T) {
+	id := NewMessageIdRandom()
+	payload := []byte{0x43, 1, 2, 3}
+	xxhLimits := DefaultLimits()
+	xxhLimits.Checksum = ChecksumXXH64
+
+	var buf bytes.Buffer
+	xxhWriter := NewFrameWriter(&buf)
+	xxhWriter.SetLimits(xxhLimits)
+	// Built with FNV-1a and restamped by the writer, or built for the connection
+	for _, frame := range []*Frame{NewChunk(id, "s", 0, payload, 0, ComputeChecksum(payload)), xxhWriter.NewChunk(id, "s", 1, payload, 1)} {
+		if err := xxhWriter.WriteFrame(frame); err != nil {
+			t.Fatalf("WriteFrame failed: %v", err)
+		}
+	}
+	xxhReader := NewFrameReader(&buf)
+	xxhReader.SetLimits(xxhLimits)
+	var received []*Frame
+	for i := 0; i < 2; i++ {
+		frame, err := xxhReader.ReadFrame()
+		if err != nil {
+			t.Fatalf("ReadFrame failed: %v", err)
+		}
+		if *frame.Checksum != ChecksumXXH64.Sum(payload) {
+			t.Errorf("Frame %d: expected an xxh64 checksum on the wire", i)
+		}
+		if err := VerifyChunkChecksum(frame); err != nil {
+			t.Errorf("Frame %d: %v", i, err)
+		}
+		received = append(received, frame)
+	}
+
+	// Forwarded to an FNV-1a connection
+	corrupted := *received[1]
+	corrupted.Payload = []byte{0x43, 9, 9, 9}
+	var forwarded bytes.Buffer
+	fnvWriter := NewFrameWriter(&forwarded)
+	for _, frame := range []*Frame{received[0], &corrupted} {
+		if err := fnvWriter.WriteFrame(frame); err != nil {
+			t.Fatalf("WriteFrame failed: %v", err)
+		}
+	}
+	fnvReader := NewFrameReader(&forwarded)
+	good, _ := fnvReader.ReadFrame()
+	if err := VerifyChunkChecksum(good); err != nil {
+		t.Errorf("Restamped frame failed verification: %v", err)
+	}
+	bad, _ := fnvReader.ReadFrame()
+	if err := VerifyChunkChecksum(bad); err == nil {
+		t.Error("A corrupted frame must not be restamped with a valid checksum")
+	}
+	if *received[0].Checksum != ChecksumXXH64.Sum(payload) {
+		t.Error("Restamping must not modify the caller's frame")
+	}
+}
+
+// A runtime on a connection that negotiated xxh64 verifies and emits xxh64 checksums
+func TestRuntimeUsesNegotiatedChecksum(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		var input []byte
+		for frame := range frames {
+			if frame.FrameType == FrameTypeChunk {
+				input = append(input, frame.Payload...)
+			}
+		}
+		return emitter.EmitCbor(input)
+	})
+
+	hostToPluginR, hostToPluginW := io.Pipe()
+	pluginToHostR, pluginToHostW := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := runtime.serveCBOR(hostToPluginR, pluginToHostW)
+		pluginToHostW.Close()
+		done <- err
+	}()
+	reader := NewFrameReader(pluginToHostR)
+	writer := NewFrameWriter(hostToPluginW)
+	_, limits, err := HandshakeInitiateWithOptions(reader, writer, HandshakeOptions{Checksums: []ChecksumAlgorithm{ChecksumXXH64}})
+	if err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+	reader.SetLimits(limits)
+	writer.SetLimits(limits)
+
+	reqId := NewMessageIdRandom()
+	writeTestRequest(t, writer, reqId, testCancelCap, []byte{0x43, 'a', 'b', 'c'})
+	frames := readUntilTerminal(t, reader, reqId)
+	if last := frames[len(frames)-1]; last.FrameType != FrameTypeEnd {
+		t.Fatalf("Expected END, got %v: %s", last.FrameType, last.ErrorMessage())
+	}
+	chunks := 0
+	for _, frame := range frames {
+		if frame.FrameType != FrameTypeChunk {
+			continue
+		}
+		chunks++
+		if *frame.Checksum != ChecksumXXH64.Sum(frame.Payload) {
+			t.Error("Response CHUNK is not checksummed with xxh64")
+		}
+	}
+	if chunks == 0 {
+		t.Error("Expected a response CHUNK")
+	}
+
+	hostToPluginW.Close()
+	go io.Copy(io.Discard, pluginToHostR)
+	<-done
+}
@@ -0,0 +1,86 @@
+package bifaci
+
+import "time"
+
+// DefaultChunkLatency is the write latency adaptive chunking aims at when none is given
+const DefaultChunkLatency = 10 * time.Millisecond
+
+// SetAdaptiveChunking makes handlers' emitters size the CHUNKs they split bytes and
+// text into by measured throughput instead of always using the negotiated max_chunk.
+// Each response starts at minChunk bytes; as long as full CHUNKs are written faster
+// than targetLatency (DefaultChunkLatency if 0) the size grows towards max_chunk, and
+// shrinks back when writes slow down. Fast local pipes get large CHUNKs, while a stream
+// of small values, as an interactive handler emits, is never held back. minChunk 0
+// disables it. Takes effect for connections served after the call.
+func (pr *PluginRuntime) SetAdaptiveChunking(minChunk int, targetLatency time.Duration) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if targetLatency <= 0 {
+		targetLatency = DefaultChunkLatency
+	}
+	pr.minChunk = minChunk
+	pr.chunkLatency = targetLatency
+}
+
+// chunkTuner adapts the CHUNK size of one response between min and max. A nil tuner
+// keeps it at max.
+type chunkTuner struct {
+	size   int
+	min    int
+	max    int
+	target time.Duration
+	rate   float64 // Smoothed bytes per second of full CHUNK writes, 0 until measured
+}
+
+// newChunkTuner returns a tuner starting at min, or nil if min is 0
+func newChunkTuner(min, max int, target time.Duration) *chunkTuner {
+	if min <= 0 {
+		return nil
+	}
+	if min > max {
+		min = max
+	}
+	return &chunkTuner{size: min, min: min, max: max, target: target}
+}
+
+// chunkSize returns the size to split the next CHUNK at
+func (t *chunkTuner) chunkSize(max int) int {
+	if t == nil {
+		return max
+	}
+	return t.size
+}
+
+// observe accounts for a CHUNK of n payload bytes written in elapsed. Only full CHUNKs
+// say something about throughput: a small value is written fast whatever the pipe.
+func (t *chunkTuner) observe(n int, elapsed time.Duration) {
+	if t == nil || n < t.size {
+		return
+	}
+	if elapsed <= 0 {
+		elapsed = time.Microsecond
+	}
+	rate := float64(n) / elapsed.Seconds()
+	if t.rate == 0 || elapsed > t.target {
+		// A write slower than the target is acted on at once rather than smoothed away
+		t.rate = rate
+	} else {
+		t.rate = 0.75*t.rate + 0.25*rate
+	}
+
+	// The size the pipe writes in target, moving at most by a factor of 2 per CHUNK
+	ideal := int(t.rate * t.target.Seconds())
+	if ideal > 2*t.size {
+		ideal = 2 * t.size
+	}
+	if ideal < t.size/2 {
+		ideal = t.size / 2
+	}
+	if ideal > t.max {
+		ideal = t.max
+	}
+	if ideal < t.min {
+		ideal = t.min
+	}
+	t.size = ideal
+}
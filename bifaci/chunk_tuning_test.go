@@ -0,0 +1,75 @@
+package bifaci
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestChunkTunerAdapts(t *testing.T) {
+	var fixed *chunkTuner
+	if fixed.chunkSize(DefaultMaxChunk) != DefaultMaxChunk {
+		t.Error("A nil tuner must keep the max chunk size")
+	}
+	if newChunkTuner(0, DefaultMaxChunk, DefaultChunkLatency) != nil {
+		t.Error("Adaptive chunking must be off with a min chunk of 0")
+	}
+
+	tuner := newChunkTuner(1024, 64*1024, 10*time.Millisecond)
+	if tuner.chunkSize(64*1024) != 1024 {
+		t.Fatalf("Expected to start at the min chunk, got %d", tuner.chunkSize(64*1024))
+	}
+
+	// Small values don't count, however fast they are written
+	tuner.observe(10, time.Nanosecond)
+	if tuner.size != 1024 {
+		t.Errorf("Expected a small write to be ignored, size is %d", tuner.size)
+	}
+
+	// A fast pipe grows the size by at most 2x per CHUNK, up to the max
+	sizes := []int{}
+	for i := 0; i < 10; i++ {
+		tuner.observe(tuner.size, time.Microsecond)
+		sizes = append(sizes, tuner.size)
+	}
+	if sizes[0] != 2048 || sizes[len(sizes)-1] != 64*1024 {
+		t.Errorf("Expected growth from 2048 to the max, got %v", sizes)
+	}
+
+	// A slow pipe shrinks it back down to the min
+	for i := 0; i < 20; i++ {
+		tuner.observe(tuner.size, time.Second)
+	}
+	if tuner.size != 1024 {
+		t.Errorf("Expected the size back at the min, got %d", tuner.size)
+	}
+}
+
+// An emitter with a tuner splits bytes at the tuned size instead of max_chunk
+func TestEmitterUsesTunedChunkSize(t *testing.T) {
+	var out bytes.Buffer
+	writer := newSyncFrameWriter(NewFrameWriter(&out))
+	emitter := newThreadSafeEmitter(context.Background(), writer, NewMessageIdRandom(), nil, "result", "media:", DefaultMaxChunk, nil, defaultLogger())
+	emitter.tuner = newChunkTuner(100, DefaultMaxChunk, time.Hour)
+	if err := emitter.EmitCbor(make([]byte, 250)); err != nil {
+		t.Fatalf("EmitCbor failed: %v", err)
+	}
+
+	reader := NewFrameReader(&out)
+	var sizes []int
+	for {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			break
+		}
+		if frame.FrameType == FrameTypeChunk {
+			content, _ := cborStringContent(frame.Payload)
+			sizes = append(sizes, len(content))
+		}
+	}
+	// Writing is far faster than an hour, so each CHUNK doubles the next
+	if len(sizes) != 2 || sizes[0] != 100 || sizes[1] != 150 {
+		t.Errorf("Expected CHUNKs of 100 and 150 bytes, got %v", sizes)
+	}
+}
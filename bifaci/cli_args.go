@@ -0,0 +1,124 @@
+package bifaci
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/machinefabric/capdag-go/cap"
+)
+
+// normalizeCLIArgs parses a cap's CLI args against the flags its arguments declare and
+// returns them in the form the argument sources are read from: each flag as
+// "--flag=value", then "--", then the positional args. It accepts
+//   - "--flag value" and "--flag=value", and the same for short flags declared as
+//     "-x", which also take "-xvalue"
+//   - a boolean flag (see isBoolFlag) alone for true, "--no-flag" for false, and short
+//     boolean flags grouped, e.g. "-vq"
+//   - "--", after which every arg is positional, as are "-" and negative numbers
+//
+// A flag the cap doesn't declare, or one missing its value, is a usage error listing the
+// flags it declares. Normalized args normalize to themselves.
+func normalizeCLIArgs(capDef *cap.Cap, args []string) ([]string, error) {
+	flags := make(map[string]bool) // Declared flag → whether it is boolean
+	for i := range capDef.Args {
+		argDef := &capDef.Args[i]
+		for j := range argDef.Sources {
+			if flag := argDef.Sources[j].CliFlag; flag != nil && *flag != "" {
+				flags[*flag] = isBoolFlag(argDef)
+			}
+		}
+	}
+	usageError := func(format string, a ...interface{}) error {
+		declared := make([]string, 0, len(flags))
+		for flag := range flags {
+			declared = append(declared, flag)
+		}
+		sort.Strings(declared)
+		accepted := "no flags"
+		if len(declared) > 0 {
+			accepted = "flags " + strings.Join(declared, ", ")
+		}
+		return fmt.Errorf("%s (%s accepts %s; see '%s --help')", fmt.Sprintf(format, a...), capDef.Command, accepted, capDef.Command)
+	}
+
+	var normalized, positional []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			positional = append(positional, args[i+1:]...)
+			break
+		}
+		if !isCLIFlag(arg) {
+			positional = append(positional, arg)
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(arg, "=")
+		isBool, declared := flags[name]
+		switch {
+		case declared && hasValue:
+		case declared && isBool:
+			value = "true"
+		case declared:
+			if i+1 >= len(args) {
+				return nil, usageError("flag %s requires a value", name)
+			}
+			i++
+			value = args[i]
+		case strings.HasPrefix(name, "--no-") && flags["--"+strings.TrimPrefix(name, "--no-")] && !hasValue:
+			name, value = "--"+strings.TrimPrefix(name, "--no-"), "false"
+		case !strings.HasPrefix(arg, "--"):
+			// Short flags run together: "-ovalue" or "-vq"
+			shortFlags, pending, err := splitShortFlags(flags, arg)
+			if err != nil {
+				return nil, usageError("%v", err)
+			}
+			normalized = append(normalized, shortFlags...)
+			if pending == "" {
+				continue
+			}
+			if i+1 >= len(args) {
+				return nil, usageError("flag %s requires a value", pending)
+			}
+			i++
+			name, value = pending, args[i]
+		default:
+			return nil, usageError("unknown flag %s", name)
+		}
+		normalized = append(normalized, name+"="+value)
+	}
+	return append(append(normalized, "--"), positional...), nil
+}
+
+// splitShortFlags splits short flags given together, e.g. "-ofile" into "-o=file" and
+// "-vq" into "-v=true", "-q=true". A last flag that takes a value but has none left,
+// like "-o" in "-vo file", is returned as pending, to take the next arg.
+func splitShortFlags(flags map[string]bool, arg string) (split []string, pending string, err error) {
+	for i := 1; i < len(arg); i++ {
+		flag := "-" + arg[i:i+1]
+		isBool, declared := flags[flag]
+		switch {
+		case !declared:
+			return nil, "", fmt.Errorf("unknown flag %s", flag)
+		case isBool:
+			split = append(split, flag+"=true")
+		case i+1 == len(arg):
+			return split, flag, nil
+		default:
+			return append(split, flag+"="+arg[i+1:]), "", nil
+		}
+	}
+	return split, "", nil
+}
+
+// isCLIFlag reports whether arg is a flag rather than a positional arg: it starts with
+// "-" but isn't "-" or a negative number
+func isCLIFlag(arg string) bool {
+	if len(arg) < 2 || arg[0] != '-' {
+		return false
+	}
+	_, err := strconv.ParseFloat(arg, 64)
+	return err != nil
+}
@@ -0,0 +1,74 @@
+package bifaci
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/machinefabric/capdag-go/cap"
+)
+
+func TestNormalizeCLIArgs(t *testing.T) {
+	capDef := createTestCap(`cap:in="media:void";op=convert;out="media:void"`, "Convert", "convert", []cap.CapArg{
+		{MediaUrn: "media:file-path;textable", Sources: []cap.ArgSource{cliFlagSource("--file"), cliFlagSource("-f")}},
+		{MediaUrn: "media:bool;textable", Sources: []cap.ArgSource{cliFlagSource("-v")}},
+		{MediaUrn: "media:quiet;bool;textable", Sources: []cap.ArgSource{cliFlagSource("-q")}},
+		{MediaUrn: "media:integer;textable;numeric", Sources: []cap.ArgSource{positionSource(0)}},
+	})
+
+	cases := []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{"--file", "a.txt", "7"}, []string{"--file=a.txt", "--", "7"}},
+		{[]string{"-fa.txt", "-vq"}, []string{"-f=a.txt", "-v=true", "-q=true", "--"}},
+		{[]string{"-vf", "a.txt", "-5"}, []string{"-v=true", "-f=a.txt", "--", "-5"}},
+		{[]string{"--", "--file", "-"}, []string{"--", "--file", "-"}},
+		{[]string{"--file=a.txt", "--", "7"}, []string{"--file=a.txt", "--", "7"}},
+	}
+	for _, c := range cases {
+		normalized, err := normalizeCLIArgs(capDef, c.args)
+		if err != nil {
+			t.Errorf("%v: %v", c.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(normalized, c.expected) {
+			t.Errorf("%v: expected %v, got %v", c.args, c.expected, normalized)
+		}
+	}
+
+	errorCases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"--fiel", "a.txt"}, "unknown flag --fiel (convert accepts flags --file, -f, -q, -v; see 'convert --help')"},
+		{[]string{"-x"}, "unknown flag -x"},
+		{[]string{"--file"}, "flag --file requires a value"},
+		{[]string{"-vf"}, "flag -f requires a value"},
+	}
+	for _, c := range errorCases {
+		if _, err := normalizeCLIArgs(capDef, c.args); err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("%v: expected %q, got %v", c.args, c.expected, err)
+		}
+	}
+}
+
+// A positional arg after "--" may start with "-"
+func TestPositionalArgAfterSeparator(t *testing.T) {
+	capDef := createTestCap(`cap:in="media:void";op=echo;out="media:void"`, "Echo", "echo", []cap.CapArg{
+		{MediaUrn: "media:textable", Required: true, Sources: []cap.ArgSource{positionSource(0)}},
+	})
+	manifest := createTestManifest("TestPlugin", "1.0.0", "Test", []*cap.Cap{capDef})
+	runtime, err := NewPluginRuntimeWithManifest(manifest)
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+
+	arguments, err := runtime.buildCLIArguments(&manifest.Caps[0], []string{"--", "--not-a-flag"}, nil)
+	if err != nil {
+		t.Fatalf("buildCLIArguments failed: %v", err)
+	}
+	if len(arguments) != 1 || string(arguments[0].value.([]byte)) != "--not-a-flag" {
+		t.Errorf("Expected the positional arg, got %+v", arguments)
+	}
+}
@@ -0,0 +1,98 @@
+package bifaci
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CLICommandFunc runs a runtime-provided CLI subcommand. args are the command-line
+// arguments after the subcommand name.
+type CLICommandFunc func(args []string) error
+
+// cliCommand is a subcommand registered with AddCLICommand
+type cliCommand struct {
+	description string
+	run         CLICommandFunc
+}
+
+// AddCLICommand registers a runtime-provided CLI subcommand (e.g. "selftest") next to
+// the built-in "manifest". It fails if name is reserved, already registered, or used as
+// the command of a cap in the manifest.
+func (pr *PluginRuntime) AddCLICommand(name, description string, run CLICommandFunc) error {
+	if name == "" || strings.HasPrefix(name, "-") {
+		return fmt.Errorf("invalid CLI command name %q", name)
+	}
+	for _, reserved := range reservedCLICommands {
+		if name == reserved {
+			return fmt.Errorf("CLI command %q is reserved by the runtime", name)
+		}
+	}
+
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if _, exists := pr.cliCommands[name]; exists {
+		return fmt.Errorf("CLI command %q is already registered", name)
+	}
+	if pr.manifest != nil {
+		for i := range pr.manifest.Caps {
+			if pr.manifest.Caps[i].Command == name {
+				return fmt.Errorf("CLI command %q collides with the command of cap %s", name, pr.manifest.Caps[i].UrnString())
+			}
+		}
+	}
+	if pr.cliCommands == nil {
+		pr.cliCommands = make(map[string]cliCommand)
+	}
+	pr.cliCommands[name] = cliCommand{description: description, run: run}
+	return nil
+}
+
+// ValidateManifest validates the runtime's manifest like the package-level
+// ValidateManifest, also rejecting cap commands that collide with subcommands
+// registered with AddCLICommand.
+func (pr *PluginRuntime) ValidateManifest() []Diagnostic {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+	return validateManifest(pr.manifest, pr.reservedCommandsLocked())
+}
+
+// reservedCommandsLocked returns the built-in and registered subcommand names, sorted.
+// Caller holds pr.mu.
+func (pr *PluginRuntime) reservedCommandsLocked() []string {
+	names := append([]string(nil), reservedCLICommands...)
+	registered := make([]string, 0, len(pr.cliCommands))
+	for name := range pr.cliCommands {
+		registered = append(registered, name)
+	}
+	sort.Strings(registered)
+	return append(names, registered...)
+}
+
+// findCLICommand returns the runtime-provided subcommand registered as name
+func (pr *PluginRuntime) findCLICommand(name string) (cliCommand, bool) {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+	command, ok := pr.cliCommands[name]
+	return command, ok
+}
+
+// shadowedCapCommands returns the cap commands that collide with runtime subcommands
+func (pr *PluginRuntime) shadowedCapCommands() []string {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+	if pr.manifest == nil {
+		return nil
+	}
+	reserved := make(map[string]bool)
+	for _, name := range pr.reservedCommandsLocked() {
+		reserved[name] = true
+	}
+	var shadowed []string
+	for i := range pr.manifest.Caps {
+		if reserved[pr.manifest.Caps[i].Command] {
+			shadowed = append(shadowed, pr.manifest.Caps[i].Command)
+		}
+	}
+	return shadowed
+}
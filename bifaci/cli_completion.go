@@ -0,0 +1,142 @@
+package bifaci
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Shells supported by the "completion" subcommand
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// completionCommand is one subcommand offered for completion
+type completionCommand struct {
+	name        string
+	description string
+	flags       []string // Long flags including the leading "--"
+}
+
+// completionCommands lists the runtime subcommands followed by the manifest's caps
+func (pr *PluginRuntime) completionCommands() []completionCommand {
+	outputFlags := []string{"--output", "--format", "--quiet", "--config", "--no-stdin", "--help"}
+	commands := []completionCommand{
+		{name: "manifest", description: "Output the plugin manifest as JSON"},
+		{name: "completion", description: "Output a shell completion script"},
+		{name: "repl", description: "Invoke caps interactively"},
+	}
+
+	pr.mu.RLock()
+	names := make([]string, 0, len(pr.cliCommands))
+	for name := range pr.cliCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		commands = append(commands, completionCommand{name: name, description: pr.cliCommands[name].description})
+	}
+	manifest := pr.manifest
+	pr.mu.RUnlock()
+
+	if manifest == nil {
+		return commands
+	}
+	for i := range manifest.Caps {
+		capDef := &manifest.Caps[i]
+		command := completionCommand{name: capDef.Command, description: capDef.Title}
+		for j := range capDef.Args {
+			if flag := capDef.Args[j].GetCliFlag(); flag != nil && strings.HasPrefix(*flag, "--") {
+				command.flags = append(command.flags, *flag)
+			}
+		}
+		command.flags = append(command.flags, outputFlags...)
+		commands = append(commands, command)
+	}
+	return commands
+}
+
+var nonIdentifierChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// writeCompletion writes the completion script for shell, completing the executable program
+func (pr *PluginRuntime) writeCompletion(w io.Writer, shell, program string) error {
+	commands := pr.completionCommands()
+	function := "_" + nonIdentifierChars.ReplaceAllString(program, "_")
+
+	var script strings.Builder
+	switch shell {
+	case "bash":
+		names := make([]string, len(commands))
+		for i, c := range commands {
+			names[i] = c.name
+		}
+		fmt.Fprintf(&script, "# bash completion for %s\n", program)
+		fmt.Fprintf(&script, "%s() {\n", function)
+		fmt.Fprintf(&script, "    local cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+		fmt.Fprintf(&script, "    if [ \"$COMP_CWORD\" -eq 1 ]; then\n")
+		fmt.Fprintf(&script, "        COMPREPLY=( $(compgen -W %q -- \"$cur\") )\n", strings.Join(names, " "))
+		fmt.Fprintf(&script, "        return\n")
+		fmt.Fprintf(&script, "    fi\n")
+		fmt.Fprintf(&script, "    case \"${COMP_WORDS[1]}\" in\n")
+		fmt.Fprintf(&script, "        completion) COMPREPLY=( $(compgen -W %q -- \"$cur\") ) ;;\n", strings.Join(completionShells, " "))
+		for _, c := range commands {
+			if len(c.flags) > 0 {
+				fmt.Fprintf(&script, "        %s) [[ \"$cur\" == -* ]] && COMPREPLY=( $(compgen -W %q -- \"$cur\") ) ;;\n", c.name, strings.Join(c.flags, " "))
+			}
+		}
+		fmt.Fprintf(&script, "    esac\n")
+		fmt.Fprintf(&script, "}\n")
+		fmt.Fprintf(&script, "complete -o default -F %s %s\n", function, program)
+
+	case "zsh":
+		fmt.Fprintf(&script, "#compdef %s\n", program)
+		fmt.Fprintf(&script, "%s() {\n", function)
+		fmt.Fprintf(&script, "    if (( CURRENT == 2 )); then\n")
+		fmt.Fprintf(&script, "        local -a commands=(\n")
+		for _, c := range commands {
+			fmt.Fprintf(&script, "            %s\n", zshQuote(c.name+":"+c.description))
+		}
+		fmt.Fprintf(&script, "        )\n")
+		fmt.Fprintf(&script, "        _describe 'command' commands\n")
+		fmt.Fprintf(&script, "        return\n")
+		fmt.Fprintf(&script, "    fi\n")
+		fmt.Fprintf(&script, "    case \"$words[2]\" in\n")
+		fmt.Fprintf(&script, "        completion) compadd -- %s ;;\n", strings.Join(completionShells, " "))
+		for _, c := range commands {
+			if len(c.flags) > 0 {
+				fmt.Fprintf(&script, "        %s) compadd -- %s; _files ;;\n", c.name, strings.Join(c.flags, " "))
+			}
+		}
+		fmt.Fprintf(&script, "    esac\n")
+		fmt.Fprintf(&script, "}\n")
+		fmt.Fprintf(&script, "compdef %s %s\n", function, program)
+
+	case "fish":
+		fmt.Fprintf(&script, "# fish completion for %s\n", program)
+		for _, c := range commands {
+			fmt.Fprintf(&script, "complete -c %s -f -n __fish_use_subcommand -a %s -d %s\n", program, c.name, fishQuote(c.description))
+		}
+		fmt.Fprintf(&script, "complete -c %s -f -n '__fish_seen_subcommand_from completion' -a %s\n", program, fishQuote(strings.Join(completionShells, " ")))
+		for _, c := range commands {
+			for _, flag := range c.flags {
+				fmt.Fprintf(&script, "complete -c %s -n '__fish_seen_subcommand_from %s' -l %s\n", program, c.name, strings.TrimPrefix(flag, "--"))
+			}
+		}
+
+	default:
+		return fmt.Errorf("unsupported shell %q (expected %s)", shell, strings.Join(completionShells, ", "))
+	}
+
+	_, err := io.WriteString(w, script.String())
+	return err
+}
+
+// zshQuote single-quotes s for zsh
+func zshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// fishQuote single-quotes s for fish
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
@@ -0,0 +1,98 @@
+package bifaci
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Exit codes of a plugin process run with RunWithExit
+const (
+	ExitSuccess       = 0 // The command succeeded
+	ExitUsageError    = 2 // The command line is wrong: unknown command or flag, bad or missing argument
+	ExitHandlerError  = 3 // The cap's handler failed
+	ExitProtocolError = 4 // The runtime failed: invalid manifest, missing handler, frame protocol or I/O
+)
+
+// CLIError is an error of a plugin run, with the exit code and error code it is
+// reported with (see CLIExitCode). The runtime writes it to stderr as an error document,
+// one line of JSON: {"code": ..., "message": ..., "details": {...}}, where details
+// holds what the error carries beyond its message, like validation violations.
+type CLIError struct {
+	ExitCode int
+	Code     string // USAGE_ERROR, PROTOCOL_ERROR, or the ERR code of a handler error
+	Err      error
+
+	reported bool // The error document was written
+}
+
+func (e *CLIError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CLIError) Unwrap() error {
+	return e.Err
+}
+
+// newUsageError returns err as a usage error, unless it is a *CLIError already
+func newUsageError(err error) error {
+	return asCLIError(err, ExitUsageError, "USAGE_ERROR")
+}
+
+// newHandlerError returns err returned by a handler as a handler error, unless it is a
+// *CLIError already
+func newHandlerError(err error) error {
+	return asCLIError(err, ExitHandlerError, handlerErrorCode(err))
+}
+
+func asCLIError(err error, exitCode int, code string) error {
+	var cliErr *CLIError
+	if err == nil || errors.As(err, &cliErr) {
+		return err
+	}
+	return &CLIError{ExitCode: exitCode, Code: code, Err: err}
+}
+
+// CLIExitCode returns the exit code RunWithExit exits with for err returned by Run:
+// ExitSuccess for nil, the exit code of a *CLIError, and ExitProtocolError otherwise
+func CLIExitCode(err error) int {
+	if err == nil {
+		return ExitSuccess
+	}
+	var cliErr *CLIError
+	if errors.As(err, &cliErr) {
+		return cliErr.ExitCode
+	}
+	return ExitProtocolError
+}
+
+// reportCLIError writes the error document of err to w unless it was written already,
+// and returns err as a *CLIError; errors that aren't are protocol errors
+func reportCLIError(w io.Writer, err error) error {
+	if err == nil {
+		return nil
+	}
+	var cliErr *CLIError
+	if !errors.As(err, &cliErr) {
+		cliErr = &CLIError{ExitCode: ExitProtocolError, Code: "PROTOCOL_ERROR", Err: err}
+	}
+	if !cliErr.reported {
+		document, _ := json.Marshal(map[string]interface{}{
+			"code":    cliErr.Code,
+			"message": cliErr.Err.Error(),
+			"details": errorDetails(cliErr.Err),
+		})
+		fmt.Fprintln(w, string(document))
+		cliErr.reported = true
+	}
+	return cliErr
+}
+
+// RunWithExit runs the plugin like Run and exits the process with the exit code of the
+// result (see CLIExitCode), after writing the error document of a failure to stderr
+func (pr *PluginRuntime) RunWithExit() {
+	err := reportCLIError(os.Stderr, pr.Run())
+	os.Exit(CLIExitCode(err))
+}
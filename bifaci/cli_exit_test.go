@@ -0,0 +1,82 @@
+package bifaci
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/machinefabric/capdag-go/cap"
+)
+
+func TestCLIExitCodes(t *testing.T) {
+	capDef := createTestCap(`cap:in="media:void";op=fail;out="media:void"`, "Fail", "fail", nil)
+	manifest := createTestManifest("TestPlugin", "1.0.0", "Test", []*cap.Cap{capDef})
+	runtime, err := NewPluginRuntimeWithManifest(manifest)
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	handlerErr := errors.New("out of paper")
+
+	var stderr bytes.Buffer
+	err = runtime.invokeCLIArguments(func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		return handlerErr
+	}, nil, cliOutputOptions{}, &stderr, &stderr)
+	if !errors.Is(err, handlerErr) || CLIExitCode(err) != ExitHandlerError {
+		t.Errorf("Expected a handler error, got %v (exit code %d)", err, CLIExitCode(err))
+	}
+	var document struct {
+		Code    string                 `json:"code"`
+		Message string                 `json:"message"`
+		Details map[string]interface{} `json:"details"`
+	}
+	if err := json.Unmarshal(stderr.Bytes(), &document); err != nil {
+		t.Fatalf("Expected an error document, got %q: %v", stderr.String(), err)
+	}
+	if document.Code != "HANDLER_ERROR" || document.Message != "out of paper" || document.Details == nil {
+		t.Errorf("Unexpected error document %+v", document)
+	}
+
+	// The document is written once
+	stderr.Reset()
+	reportCLIError(&stderr, err)
+	if stderr.Len() != 0 {
+		t.Errorf("Expected no second error document, got %q", stderr.String())
+	}
+
+	err = runtime.runCLIMode([]string{"plugin", "fial"})
+	if CLIExitCode(err) != ExitUsageError {
+		t.Errorf("Expected a usage error, got %v (exit code %d)", err, CLIExitCode(err))
+	}
+	err = reportCLIError(&stderr, errors.New("broken pipe"))
+	if CLIExitCode(err) != ExitProtocolError || !strings.Contains(stderr.String(), `"code":"PROTOCOL_ERROR"`) {
+		t.Errorf("Expected a protocol error, got %v: %q", err, stderr.String())
+	}
+	if CLIExitCode(nil) != ExitSuccess {
+		t.Error("Expected success for no error")
+	}
+}
+
+// A payload whose arguments can't be decoded fails without running the handler
+func TestCLIUndecodableArguments(t *testing.T) {
+	capDef := createTestCap(`cap:in="media:void";op=fail;out="media:void"`, "Fail", "fail", nil)
+	manifest := createTestManifest("TestPlugin", "1.0.0", "Test", []*cap.Cap{capDef})
+	runtime, err := NewPluginRuntimeWithManifest(manifest)
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+
+	ran := false
+	var stderr bytes.Buffer
+	err = runtime.invokeCLICap(func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		ran = true
+		return nil
+	}, []byte{0xff}, cliOutputOptions{}, &stderr, &stderr)
+	if ran {
+		t.Error("Expected the handler not to run")
+	}
+	if CLIExitCode(err) != ExitUsageError || !strings.Contains(stderr.String(), `"code":"INVALID_ARGUMENT"`) {
+		t.Errorf("Expected an INVALID_ARGUMENT usage error, got %v: %q", err, stderr.String())
+	}
+}
@@ -0,0 +1,164 @@
+package bifaci
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/machinefabric/capdag-go/cap"
+	"github.com/machinefabric/capdag-go/urn"
+)
+
+// writeCapHelp renders "<program> <command> --help": usage, every argument with its
+// media URN, requirement, sources and default, the output, and example invocations.
+func writeCapHelp(w io.Writer, program string, capDef *cap.Cap) {
+	fmt.Fprintf(w, "%s\n", capDef.Title)
+	if capDef.CapDescription != nil {
+		fmt.Fprintf(w, "%s\n", *capDef.CapDescription)
+	}
+	if capDef.Deprecated {
+		fmt.Fprintf(w, "\nDEPRECATED: %s\n", capDef.DeprecationNotice())
+	}
+	if capDef.Since != "" {
+		fmt.Fprintf(w, "Since: %s\n", capDef.Since)
+	}
+
+	fmt.Fprintf(w, "\nUSAGE:\n")
+	fmt.Fprintf(w, "    %s %s%s [OPTIONS]\n", program, capDef.Command, usageArgs(capDef))
+
+	if len(capDef.Args) > 0 {
+		fmt.Fprintf(w, "\nARGUMENTS:\n")
+		for i := range capDef.Args {
+			arg := &capDef.Args[i]
+			requirement := "optional"
+			if arg.Required {
+				requirement = "required"
+			}
+			fmt.Fprintf(w, "    %s (%s)\n", arg.MediaUrn, requirement)
+			if arg.ArgDescription != "" {
+				fmt.Fprintf(w, "        %s\n", arg.ArgDescription)
+			}
+			if sources := describeSources(arg); sources != "" {
+				fmt.Fprintf(w, "        Sources: %s\n", sources)
+			}
+			if arg.DefaultValue != nil {
+				fmt.Fprintf(w, "        Default: %v\n", arg.DefaultValue)
+			}
+		}
+	}
+
+	if capDef.Output != nil {
+		fmt.Fprintf(w, "\nOUTPUT:\n")
+		fmt.Fprintf(w, "    %s\n", capDef.Output.MediaUrn)
+		if capDef.Output.OutputDescription != "" {
+			fmt.Fprintf(w, "        %s\n", capDef.Output.OutputDescription)
+		}
+	}
+
+	fmt.Fprintf(w, "\nOUTPUT OPTIONS:\n")
+	fmt.Fprintf(w, "    --output <FILE>           Write the result to FILE instead of stdout\n")
+	fmt.Fprintf(w, "    --format raw|json|cbor    Serialization of emitted values (default raw)\n")
+	fmt.Fprintf(w, "    --quiet                   Suppress log lines\n")
+	fmt.Fprintf(w, "    --config <FILE>           Read config arguments from FILE\n")
+	fmt.Fprintf(w, "    --no-stdin                Don't read arguments from stdin\n")
+
+	fmt.Fprintf(w, "\nEXAMPLES:\n")
+	for _, example := range capExamples(program, capDef) {
+		fmt.Fprintf(w, "    %s\n", example)
+	}
+}
+
+// describeSources lists where an argument can come from, in lookup order
+func describeSources(arg *cap.CapArg) string {
+	var sources []string
+	for i := range arg.Sources {
+		source := &arg.Sources[i]
+		switch {
+		case source.CliFlag != nil:
+			sources = append(sources, fmt.Sprintf("flag %s", *source.CliFlag))
+		case source.Position != nil:
+			sources = append(sources, fmt.Sprintf("position %d", *source.Position))
+		case source.Stdin != nil:
+			sources = append(sources, fmt.Sprintf("stdin (%s)", *source.Stdin))
+		case source.Env != nil:
+			sources = append(sources, fmt.Sprintf("env $%s", *source.Env))
+		case source.Config != nil:
+			sources = append(sources, fmt.Sprintf("config %s", *source.Config))
+		}
+	}
+	return strings.Join(sources, ", ")
+}
+
+// argPlaceholder names an argument's value in usage lines: the flag name, else the
+// first tag of its media URN
+func argPlaceholder(arg *cap.CapArg) string {
+	if flag := arg.GetCliFlag(); flag != nil {
+		return "<" + strings.ToUpper(strings.TrimLeft(*flag, "-")) + ">"
+	}
+	name := strings.TrimPrefix(arg.MediaUrn, "media:")
+	if i := strings.IndexAny(name, ";="); i >= 0 {
+		name = name[:i]
+	}
+	if name == "" {
+		name = "value"
+	}
+	return "<" + strings.ToUpper(name) + ">"
+}
+
+// usageArgs renders the arguments of the usage line; optional ones in brackets
+func usageArgs(capDef *cap.Cap) string {
+	var usage strings.Builder
+	for i := range capDef.Args {
+		arg := &capDef.Args[i]
+		var part string
+		if flag := arg.GetCliFlag(); flag != nil && isBoolFlag(arg) {
+			part = *flag
+		} else if flag != nil {
+			part = *flag + " " + argPlaceholder(arg)
+		} else if arg.GetPosition() != nil {
+			part = argPlaceholder(arg)
+		} else {
+			continue // stdin only
+		}
+		if argUrn, err := urn.NewMediaUrnFromString(arg.MediaUrn); err == nil && argUrn.IsList() {
+			part += "..." // Repeated flag, or the remaining positional args
+		}
+		if !arg.Required {
+			part = "[" + part + "]"
+		}
+		usage.WriteString(" " + part)
+	}
+	return usage.String()
+}
+
+// capExamples derives invocations from the manifest: required arguments through their
+// first CLI source, and piping stdin for arguments that accept it
+func capExamples(program string, capDef *cap.Cap) []string {
+	base := program + " " + capDef.Command
+	var stdinArg *cap.CapArg
+	for i := range capDef.Args {
+		arg := &capDef.Args[i]
+		for j := range arg.Sources {
+			if arg.Sources[j].Stdin != nil && stdinArg == nil {
+				stdinArg = arg
+			}
+		}
+		if !arg.Required {
+			continue
+		}
+		if flag := arg.GetCliFlag(); flag != nil && isBoolFlag(arg) {
+			base += " " + *flag
+		} else if flag != nil {
+			base += " " + *flag + " " + argPlaceholder(arg)
+		} else if arg.GetPosition() != nil {
+			base += " " + argPlaceholder(arg)
+		}
+	}
+
+	examples := []string{base}
+	if stdinArg != nil {
+		examples = append(examples, fmt.Sprintf("cat input | %s", base))
+	}
+	examples = append(examples, base+" --format json --output result.json")
+	return examples
+}
@@ -0,0 +1,195 @@
+package bifaci
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+	"github.com/machinefabric/capdag-go/cap"
+	"github.com/machinefabric/capdag-go/urn"
+)
+
+// CLI output formats for --format
+const (
+	CLIFormatRaw  = "raw"  // Bytes and text as-is (default)
+	CLIFormatJSON = "json" // One JSON value per line
+	CLIFormatCBOR = "cbor" // CBOR sequence (RFC 8742)
+)
+
+// cliOutputOptions are the runtime's standard output flags in CLI mode, and --config
+// and --no-stdin
+type cliOutputOptions struct {
+	output  string // --output <file>; "" = stdout
+	format  string // --format raw|json|cbor
+	quiet   bool   // --quiet suppresses LOG lines
+	config  string // --config <file>; "" = the default config file (see SetConfigFile)
+	noStdin bool   // --no-stdin leaves stdin unread (see cliStdin)
+}
+
+// parseCLIOutputFlags removes --output, --format, --quiet, --config and --no-stdin
+// from args and returns them.
+// A flag the cap declares as a cli_flag source of its own is left for the cap.
+func parseCLIOutputFlags(capDef *cap.Cap, args []string) (cliOutputOptions, []string, error) {
+	declared := make(map[string]bool)
+	for i := range capDef.Args {
+		if flag := capDef.Args[i].GetCliFlag(); flag != nil {
+			declared[*flag] = true
+		}
+	}
+
+	options := cliOutputOptions{format: CLIFormatRaw}
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(args[i], "=")
+		if declared[name] {
+			rest = append(rest, args[i])
+			continue
+		}
+		switch name {
+		case "--output", "--format", "--config":
+			if !hasValue {
+				if i+1 >= len(args) {
+					return options, nil, fmt.Errorf("%s requires a value", name)
+				}
+				i++
+				value = args[i]
+			}
+			switch name {
+			case "--output":
+				options.output = value
+			case "--format":
+				options.format = value
+			default:
+				options.config = value
+			}
+		case "--quiet", "--no-stdin":
+			if hasValue {
+				return options, nil, fmt.Errorf("%s takes no value", name)
+			}
+			if name == "--quiet" {
+				options.quiet = true
+			} else {
+				options.noStdin = true
+			}
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+
+	switch options.format {
+	case CLIFormatRaw, CLIFormatJSON, CLIFormatCBOR:
+	default:
+		return options, nil, fmt.Errorf("unknown --format %q (expected raw, json or cbor)", options.format)
+	}
+	return options, rest, nil
+}
+
+// openCLIOutput returns where the result goes, stdout unless an output file was given,
+// and a function to finish writing it, told whether the handler succeeded. The file is
+// written under a temporary name and renamed into place once complete, so a failed or
+// interrupted invocation leaves any previous file as it was.
+func openCLIOutput(options cliOutputOptions, stdout io.Writer) (io.Writer, func(ok bool) error, error) {
+	if options.output == "" {
+		return stdout, func(bool) error { return nil }, nil
+	}
+	dir, name := filepath.Split(options.output)
+	if dir == "" {
+		dir = "."
+	}
+	file, err := os.CreateTemp(dir, "."+name+".*.tmp")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	// Permissions of a file replaced are kept
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(options.output); err == nil {
+		mode = info.Mode().Perm()
+	}
+	file.Chmod(mode)
+
+	finish := func(ok bool) error {
+		err := file.Close()
+		if ok && err == nil {
+			err = os.Rename(file.Name(), options.output)
+		}
+		if !ok || err != nil {
+			os.Remove(file.Name())
+		}
+		return err
+	}
+	return file, finish, nil
+}
+
+// MediaTagOutput is the media URN marker tag of a file-path argument naming the file the
+// cap's output goes to, e.g. "media:file-path;output;textable" with cli_flag "--out". In
+// CLI mode the argument isn't passed to the handler: the runtime writes what the handler
+// emits to the file, as for --output. In CBOR mode the path is passed through like any
+// other argument.
+const MediaTagOutput = "output"
+
+// isOutputArg reports whether argDef names the file the cap's output goes to (see
+// MediaTagOutput)
+func isOutputArg(argDef *cap.CapArg) bool {
+	argUrn, err := urn.NewMediaUrnFromString(argDef.MediaUrn)
+	return err == nil && argUrn.IsAnyFilePath() && argUrn.HasTag(MediaTagOutput)
+}
+
+// applyCLIOutputArg makes the file named by the cap's output argument, if given, the one
+// the result goes to (see MediaTagOutput)
+func (pr *PluginRuntime) applyCLIOutputArg(capDef *cap.Cap, cliArgs []string, options *cliOutputOptions) error {
+	cliArgs, err := normalizeCLIArgs(capDef, cliArgs)
+	if err != nil {
+		return err
+	}
+	for i := range capDef.Args {
+		argDef := &capDef.Args[i]
+		if !isOutputArg(argDef) {
+			continue
+		}
+		source, err := pr.findArgSource(argDef, cliArgs, false)
+		if err != nil {
+			return err
+		}
+		path := ""
+		if source != nil {
+			path = source.path
+			if path == "" {
+				// A default value is JSON
+				if json.Unmarshal(source.value, &path) != nil {
+					path = string(source.value)
+				}
+			}
+		}
+		if path == "" {
+			if argDef.Required {
+				return missingArgError(argDef)
+			}
+			continue
+		}
+		if options.output != "" && options.output != path {
+			return fmt.Errorf("--output and argument %s name different output files", argDef.MediaUrn)
+		}
+		options.output = path
+	}
+	return nil
+}
+
+// encodeCLIValue serializes a value emitted in CLI mode for the json and cbor formats
+func encodeCLIValue(format string, value interface{}) ([]byte, error) {
+	switch format {
+	case CLIFormatJSON:
+		encoded, err := json.Marshal(normalizeMetaValue(value))
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode output as JSON: %w", err)
+		}
+		return append(encoded, '\n'), nil
+	case CLIFormatCBOR:
+		return cborlib.Marshal(value)
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+}
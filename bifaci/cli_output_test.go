@@ -0,0 +1,81 @@
+package bifaci
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/machinefabric/capdag-go/cap"
+)
+
+// invokeWithOutputArg runs a cap whose --out argument names its output file with
+// handler, as CLI mode does
+func invokeWithOutputArg(t *testing.T, out string, handler HandlerFunc) error {
+	t.Helper()
+	capDef := createTestCap(
+		`cap:in="media:void";op=render;out="media:png;bytes"`,
+		"Render",
+		"render",
+		[]cap.CapArg{{
+			MediaUrn: "media:file-path;output;textable",
+			Required: true,
+			Sources:  []cap.ArgSource{cliFlagSource("--out")},
+		}},
+	)
+	manifest := createTestManifest("TestPlugin", "1.0.0", "Test", []*cap.Cap{capDef})
+	runtime, err := NewPluginRuntimeWithManifest(manifest)
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+
+	cliArgs := []string{"--out", out}
+	options := cliOutputOptions{format: CLIFormatRaw}
+	if err := runtime.applyCLIOutputArg(&manifest.Caps[0], cliArgs, &options); err != nil {
+		t.Fatalf("applyCLIOutputArg failed: %v", err)
+	}
+	arguments, err := runtime.buildCLIArguments(&manifest.Caps[0], cliArgs, nil)
+	if err != nil {
+		t.Fatalf("buildCLIArguments failed: %v", err)
+	}
+	if len(arguments) != 0 {
+		t.Fatalf("Expected the output path kept from the handler, got %+v", arguments)
+	}
+	return runtime.invokeCLIArguments(handler, arguments, options, io.Discard, io.Discard)
+}
+
+func TestCLIOutputArgWritesResult(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "result.png")
+	err := invokeWithOutputArg(t, out, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		return emitter.EmitCbor([]byte("PNG"))
+	})
+	if err != nil {
+		t.Fatalf("Invocation failed: %v", err)
+	}
+	if data, err := os.ReadFile(out); err != nil || string(data) != "PNG" {
+		t.Errorf("Expected the result in the output file, got %q (%v)", data, err)
+	}
+}
+
+// A failed invocation leaves the previous file in place, and no temporary file behind
+func TestCLIOutputArgFailureKeepsFile(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "result.png")
+	if err := os.WriteFile(out, []byte("previous"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	err := invokeWithOutputArg(t, out, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		emitter.EmitCbor([]byte("partial"))
+		return errors.New("render failed")
+	})
+	if err == nil {
+		t.Fatal("Expected the handler's error")
+	}
+	if data, _ := os.ReadFile(out); string(data) != "previous" {
+		t.Errorf("Expected the previous file kept, got %q", data)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Expected no temporary file left, got %d entries", len(entries))
+	}
+}
@@ -0,0 +1,176 @@
+package bifaci
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// RunInteractive reads cap invocations from in, one per line, and runs each through the
+// registered handler in-process, printing results, logs and errors to out. A line is a
+// cap command followed by its CLI arguments, exactly as in CLI mode; "< FILE" as the
+// last two words supplies FILE as the invocation's stdin. The built-ins are "help",
+// "help <command>" and "exit" (or "quit"). Errors are reported and the loop continues;
+// it returns at end of input or on exit.
+func (pr *PluginRuntime) RunInteractive(in io.Reader, out io.Writer) error {
+	manifest := pr.Manifest()
+	if manifest == nil {
+		return fmt.Errorf("failed to parse manifest for interactive mode")
+	}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for {
+		fmt.Fprintf(out, "%s> ", manifest.Name)
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return scanner.Err()
+		}
+
+		words, err := splitREPLLine(scanner.Text())
+		if err != nil {
+			fmt.Fprintf(out, "error: %v\n", err)
+			continue
+		}
+		if len(words) == 0 {
+			continue
+		}
+
+		switch words[0] {
+		case "exit", "quit":
+			return nil
+		case "help":
+			pr.writeREPLHelp(out, words[1:])
+			continue
+		}
+
+		if err := pr.runREPLCommand(words, out); err != nil {
+			fmt.Fprintf(out, "error: %v\n", err)
+		}
+	}
+}
+
+// runREPLCommand invokes the cap whose command is words[0]
+func (pr *PluginRuntime) runREPLCommand(words []string, out io.Writer) error {
+	capDef := pr.findCapByCommand(words[0])
+	if capDef == nil {
+		return fmt.Errorf("unknown command '%s'. Type 'help' to see available commands", words[0])
+	}
+	_, handler, err := pr.findHandler(capDef.UrnString())
+	if err != nil {
+		return err
+	}
+	if handler == nil {
+		return fmt.Errorf("no handler registered for cap '%s'", capDef.UrnString())
+	}
+
+	args := words[1:]
+	var stdinData []byte
+	if n := len(args); n >= 2 && args[n-2] == "<" {
+		data, err := os.ReadFile(args[n-1])
+		if err != nil {
+			return fmt.Errorf("failed to read stdin file: %w", err)
+		}
+		stdinData = data
+		args = args[:n-2]
+	}
+
+	outputOptions, capArgs, err := parseCLIOutputFlags(capDef, args)
+	if err != nil {
+		return err
+	}
+	if err := pr.loadConfigFile(outputOptions.config); err != nil {
+		return err
+	}
+	if err := pr.applyCLIOutputArg(capDef, capArgs, &outputOptions); err != nil {
+		return err
+	}
+	rawPayload, err := pr.buildPayloadFromCLIArgs(capDef, capArgs, stdinData)
+	if err != nil {
+		return fmt.Errorf("failed to build payload: %w", err)
+	}
+	// Failures were already printed as the CLI-mode error JSON
+	_ = pr.invokeCLICap(handler, rawPayload, outputOptions, out, out)
+	return nil
+}
+
+// writeREPLHelp lists the cap commands, or renders the help of the named one
+func (pr *PluginRuntime) writeREPLHelp(out io.Writer, args []string) {
+	manifest := pr.Manifest()
+	if len(args) > 0 {
+		capDef := pr.findCapByCommand(args[0])
+		if capDef == nil {
+			fmt.Fprintf(out, "error: unknown command '%s'\n", args[0])
+			return
+		}
+		writeCapHelp(out, manifest.Name, capDef)
+		return
+	}
+
+	fmt.Fprintf(out, "COMMANDS:\n")
+	for i := range manifest.Caps {
+		capDef := &manifest.Caps[i]
+		fmt.Fprintf(out, "    %-12s %s\n", capDef.Command, capDef.Title)
+	}
+	fmt.Fprintf(out, "    %-12s %s\n", "help", "Show commands, or 'help <COMMAND>' for one command")
+	fmt.Fprintf(out, "    %-12s %s\n", "exit", "Leave the REPL")
+}
+
+// splitREPLLine splits a line into words like a shell: whitespace separates words,
+// single quotes are literal, double quotes allow \" and \\, and a backslash outside
+// quotes escapes the next character
+func splitREPLLine(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else if r == '\\' && i+1 < len(runes) && (runes[i+1] == '"' || runes[i+1] == '\\') {
+				i++
+				word.WriteRune(runes[i])
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == '\\':
+			if i+1 < len(runes) {
+				i++
+				word.WriteRune(runes[i])
+			}
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,245 @@
+package bifaci
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+
+	"github.com/machinefabric/capdag-go/cap"
+)
+
+// cliArgument is an argument of a CLI invocation, sent to the handler as one stream
+type cliArgument struct {
+	mediaUrn string
+	value    interface{}   // Sent CBOR-encoded in one CHUNK, if content is nil
+	content  io.ReadCloser // Bytes streamed in CHUNKs as the handler runs: a file or stdin
+}
+
+// errCLIHandlerReturned stops sending a CLI invocation's input once its handler returned
+var errCLIHandlerReturned = errors.New("handler returned")
+
+// buildCLIArguments resolves a cap's arguments from CLI args and stdin like
+// buildPayloadFromCLI, except that the file of a file-path argument and stdin aren't
+// read into memory but streamed to the handler (see sendCLIArguments), so a file larger
+// than memory can be processed. The files of a file-path-array argument, and files sent
+// with their metadata (see MediaTagWithMeta), are still read whole, being sent as one
+// CBOR value. stdin is nil if the invocation has none (see cliStdin).
+func (pr *PluginRuntime) buildCLIArguments(capDef *cap.Cap, cliArgs []string, stdin io.Reader) ([]cliArgument, error) {
+	// If no args defined, stdin carries the CBOR arguments array
+	if len(capDef.Args) == 0 {
+		if stdin == nil {
+			return nil, nil
+		}
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stdin: %w", err)
+		}
+		return decodeCLIPayload(data)
+	}
+
+	var arguments []cliArgument
+	fail := func(err error) ([]cliArgument, error) {
+		closeCLIArguments(arguments)
+		return nil, err
+	}
+	cliArgs, err := normalizeCLIArgs(capDef, cliArgs)
+	if err != nil {
+		return fail(err)
+	}
+	for i := range capDef.Args {
+		argDef := &capDef.Args[i]
+		if isOutputArg(argDef) {
+			continue // The runtime writes the result to it
+		}
+		source, err := pr.findArgSource(argDef, cliArgs, stdin != nil)
+		if err != nil {
+			return fail(err)
+		}
+		if source == nil {
+			if argDef.Required {
+				return fail(missingArgError(argDef))
+			}
+			continue
+		}
+
+		arg := cliArgument{mediaUrn: cliArgMediaUrn(argDef)}
+		switch {
+		case source.path != "" && !source.isArray && !source.withMeta:
+			file, err := openCLIFile(source.path)
+			if err != nil {
+				return fail(err)
+			}
+			arg.content = file
+		case source.path != "":
+			value, err := pr.readFilePaths(source.path, source.isArray, source.withMeta)
+			if err != nil {
+				return fail(err)
+			}
+			arg.value = value
+		case source.stdin:
+			arg.content = io.NopCloser(stdin)
+		default:
+			value, err := typedCLIValue(capDef, argDef, source)
+			if err != nil {
+				return fail(err)
+			}
+			arg.value = value
+		}
+		arguments = append(arguments, arg)
+	}
+	return arguments, nil
+}
+
+// openCLIFile opens the file of a file-path argument for streaming
+func openCLIFile(path string) (*os.File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file '%s': %w", path, err)
+	}
+	if info, err := file.Stat(); err != nil || info.IsDir() {
+		file.Close()
+		if err == nil {
+			err = errors.New("is a directory")
+		}
+		return nil, fmt.Errorf("failed to read file '%s': %w", path, err)
+	}
+	return file, nil
+}
+
+// decodeCLIPayload decodes a CBOR arguments array as buildPayloadFromCLIArgs encodes it.
+// Entries without a media URN or value are skipped.
+func decodeCLIPayload(rawPayload []byte) ([]cliArgument, error) {
+	if len(rawPayload) == 0 {
+		return nil, nil
+	}
+	var entries []interface{}
+	if err := cborlib.Unmarshal(rawPayload, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode CBOR arguments: %w", err)
+	}
+
+	var arguments []cliArgument
+	for _, entry := range entries {
+		argMap, ok := entry.(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+		var arg cliArgument
+		// Extract media_urn and value from arg map
+		for k, v := range argMap {
+			key, ok := k.(string)
+			if !ok {
+				continue
+			}
+			if key == "media_urn" {
+				if urnStr, ok := v.(string); ok {
+					arg.mediaUrn = urnStr
+				}
+			} else if key == "value" {
+				arg.value = v
+			}
+		}
+		if arg.mediaUrn == "" || arg.value == nil {
+			continue
+		}
+		arguments = append(arguments, arg)
+	}
+	return arguments, nil
+}
+
+// closeCLIArguments closes the streamed contents of arguments
+func closeCLIArguments(arguments []cliArgument) {
+	for _, arg := range arguments {
+		if arg.content != nil {
+			arg.content.Close()
+		}
+	}
+}
+
+// sendCLIArguments sends arguments to a handler's input as one stream each, then END,
+// and closes frames. Streamed contents are sent in CHUNKs of at most maxChunk bytes; one
+// that fails to read ends the input with ERR instead. Sending stops once handlerDone is
+// closed.
+func (pr *PluginRuntime) sendCLIArguments(frames chan<- Frame, handlerDone <-chan struct{}, requestID MessageId, arguments []cliArgument, maxChunk int) {
+	defer close(frames)
+	defer closeCLIArguments(arguments)
+
+	send := func(frame *Frame) error {
+		select {
+		case frames <- *frame:
+			return nil
+		case <-handlerDone:
+			return errCLIHandlerReturned
+		}
+	}
+
+	for i, arg := range arguments {
+		streamID := fmt.Sprintf("arg-%d", i)
+
+		// STREAM_START
+		if send(NewStreamStart(requestID, streamID, arg.mediaUrn)) != nil {
+			return
+		}
+
+		chunkCount := uint64(1)
+		if arg.content != nil {
+			count, err := sendCLIContent(send, requestID, streamID, arg.content, maxChunk)
+			if errors.Is(err, errCLIHandlerReturned) {
+				return
+			}
+			if err != nil {
+				send(NewErr(requestID, "INVALID_ARGUMENT", fmt.Sprintf("failed to read argument %s: %v", arg.mediaUrn, err)))
+				return
+			}
+			chunkCount = count
+		} else {
+			// CHUNK: CBOR-encode the value before sending
+			// Protocol: ALL values must be CBOR-encoded (encode once, no double-wrapping)
+			cborValue, err := cborlib.Marshal(arg.value)
+			if err != nil {
+				pr.log().Error("failed to encode argument value", "error", err)
+				continue
+			}
+			if send(NewChunk(requestID, streamID, 0, cborValue, 0, ComputeChecksum(cborValue))) != nil {
+				return
+			}
+		}
+
+		// STREAM_END
+		if send(NewStreamEnd(requestID, streamID, chunkCount)) != nil {
+			return
+		}
+	}
+
+	// END
+	send(NewEnd(requestID, nil))
+}
+
+// sendCLIContent sends content as CHUNKs of CBOR byte strings holding at most maxChunk
+// bytes each, at least one, and returns their count
+func sendCLIContent(send func(*Frame) error, requestID MessageId, streamID string, content io.Reader, maxChunk int) (uint64, error) {
+	if maxChunk <= 0 {
+		maxChunk = DefaultMaxChunk
+	}
+	buf := make([]byte, maxChunk)
+	var index uint64
+	for {
+		n, err := io.ReadFull(content, buf)
+		if n > 0 || (index == 0 && err == io.EOF) {
+			// Each CHUNK gets a payload of its own: the handler may hold it past the next
+			payload := append(appendCBORHead(make([]byte, 0, n+9), 2, uint64(n)), buf[:n]...)
+			if err := send(NewChunk(requestID, streamID, 0, payload, index, ComputeChecksum(payload))); err != nil {
+				return index, err
+			}
+			index++
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return index, nil
+		}
+		if err != nil {
+			return index, err
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package bifaci
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/machinefabric/capdag-go/cap"
+)
+
+// newFilePathRuntime returns a runtime with a cap taking a file-path argument whose
+// contents are sent, and the cap
+func newFilePathRuntime(t *testing.T) (*PluginRuntime, *cap.Cap) {
+	t.Helper()
+	capDef := createTestCap(
+		`cap:in="media:pdf";op=process;out="media:void"`,
+		"Process",
+		"process",
+		[]cap.CapArg{{
+			MediaUrn: "media:file-path;textable",
+			Required: true,
+			Sources:  []cap.ArgSource{stdinSource("media:pdf"), positionSource(0)},
+		}},
+	)
+	manifest := createTestManifest("TestPlugin", "1.0.0", "Test", []*cap.Cap{capDef})
+	runtime, err := NewPluginRuntimeWithManifest(manifest)
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	return runtime, &manifest.Caps[0]
+}
+
+// A file-path argument's file is streamed in CHUNKs of at most max_chunk bytes
+func TestCLIFilePathStreamsChunks(t *testing.T) {
+	runtime, capDef := newFilePathRuntime(t)
+	runtime.limits.MaxChunk = 1024
+	content := bytes.Repeat([]byte("0123456789"), 250)
+	path := filepath.Join(t.TempDir(), "input.pdf")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	arguments, err := runtime.buildCLIArguments(capDef, []string{path}, nil)
+	if err != nil {
+		t.Fatalf("buildCLIArguments failed: %v", err)
+	}
+	if len(arguments) != 1 || arguments[0].content == nil || arguments[0].mediaUrn != "media:pdf" {
+		t.Fatalf("Expected the file streamed as media:pdf, got %+v", arguments)
+	}
+
+	var chunks int
+	var received []byte
+	handler := func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		counted := make(chan Frame, 8)
+		go func() {
+			defer close(counted)
+			for frame := range frames {
+				if frame.FrameType == FrameTypeChunk {
+					chunks++
+				}
+				counted <- frame
+			}
+		}()
+		args, err := CollectArgs(counted)
+		if err != nil {
+			return err
+		}
+		received, err = args.Args()[0].Bytes()
+		return err
+	}
+	if err := runtime.invokeCLIArguments(handler, arguments, cliOutputOptions{}, io.Discard, io.Discard); err != nil {
+		t.Fatalf("Invocation failed: %v", err)
+	}
+	if !bytes.Equal(received, content) {
+		t.Errorf("Expected the file's %d bytes, got %d", len(content), len(received))
+	}
+	if chunks != 3 {
+		t.Errorf("Expected 3 CHUNKs, got %d", chunks)
+	}
+}
+
+func TestCLIFilePathMissingFile(t *testing.T) {
+	runtime, capDef := newFilePathRuntime(t)
+	if _, err := runtime.buildCLIArguments(capDef, []string{filepath.Join(t.TempDir(), "missing.pdf")}, nil); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+	if _, err := runtime.buildCLIArguments(capDef, []string{t.TempDir()}, nil); err == nil {
+		t.Error("Expected an error for a directory")
+	}
+}
+
+// Stdin is only taken when it isn't a terminal or empty, --no-stdin isn't given and an
+// argument reads it
+func TestCLIStdin(t *testing.T) {
+	withStdin := func(t *testing.T, data string) {
+		path := filepath.Join(t.TempDir(), "stdin")
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatalf("Failed to write stdin: %v", err)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("Failed to open stdin: %v", err)
+		}
+		saved := os.Stdin
+		os.Stdin = f
+		t.Cleanup(func() {
+			os.Stdin = saved
+			f.Close()
+		})
+	}
+	stdinCap := createTestCap(`cap:in="media:";op=read;out="media:void"`, "Read", "read", []cap.CapArg{
+		{MediaUrn: "media:", Sources: []cap.ArgSource{stdinSource("media:")}},
+	})
+	flagCap := createTestCap(`cap:in="media:void";op=flag;out="media:void"`, "Flag", "flag", []cap.CapArg{
+		{MediaUrn: "media:textable", Sources: []cap.ArgSource{cliFlagSource("--text")}},
+	})
+
+	withStdin(t, "input")
+	stdin, err := cliStdin(stdinCap, false)
+	if err != nil || stdin == nil {
+		t.Fatalf("Expected stdin, got %v, %v", stdin, err)
+	}
+	if data, _ := io.ReadAll(stdin); string(data) != "input" {
+		t.Errorf("Expected the whole input, got %q", data)
+	}
+	if stdin, _ := cliStdin(stdinCap, true); stdin != nil {
+		t.Error("Expected no stdin with --no-stdin")
+	}
+	if stdin, _ := cliStdin(flagCap, false); stdin != nil {
+		t.Error("Expected no stdin for a cap without a stdin source")
+	}
+
+	withStdin(t, "")
+	if stdin, _ := cliStdin(stdinCap, false); stdin != nil {
+		t.Error("Expected no stdin for an empty file")
+	}
+}
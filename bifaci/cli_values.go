@@ -0,0 +1,210 @@
+package bifaci
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/machinefabric/capdag-go/cap"
+	"github.com/machinefabric/capdag-go/urn"
+)
+
+// MediaTagInteger is the media URN tag of a numeric argument taking whole numbers only,
+// e.g. "media:integer;textable;numeric"
+const MediaTagInteger = "integer"
+
+// isBoolArg reports whether argDef is a single boolean, e.g. "media:bool;textable"
+func isBoolArg(argDef *cap.CapArg) bool {
+	argUrn, err := urn.NewMediaUrnFromString(argDef.MediaUrn)
+	return err == nil && argUrn.IsBool() && !argUrn.IsList()
+}
+
+// isBoolFlag reports whether argDef is a boolean given as a CLI flag: present is true,
+// absent false
+func isBoolFlag(argDef *cap.CapArg) bool {
+	return argDef.HasCliFlagSource() && isBoolArg(argDef)
+}
+
+// cliArgName names an argument in usage errors: its flag, else its media URN
+func cliArgName(argDef *cap.CapArg) string {
+	if flag := argDef.GetCliFlag(); flag != nil {
+		return *flag
+	}
+	return argDef.MediaUrn
+}
+
+// typedCLIValue returns the value of an argument given as text on the command line, in
+// the environment or config file, or as its default value, as it is sent to the
+// handler: a boolean argument (media:bool) as a CBOR bool, an integer one
+// (media:integer;numeric) as a CBOR integer, another numeric one as a CBOR float, and
+// anything else as the text's bytes. A value the media spec enumerates the allowed
+// values of (schema "enum" or validation allowed_values) must be one of them.
+//
+// A list argument (media:...;list) given as separate values or a JSON array is sent as
+// a CBOR array of its values typed the same way, text as CBOR text strings.
+func typedCLIValue(capDef *cap.Cap, argDef *cap.CapArg, source *cliArgSource) (interface{}, error) {
+	text := string(source.value)
+	if source.isDefault {
+		// A default value is JSON; its strings are the text given
+		var s string
+		if json.Unmarshal(source.value, &s) == nil {
+			text = s
+		}
+	}
+
+	argUrn, err := urn.NewMediaUrnFromString(argDef.MediaUrn)
+	if err != nil {
+		return source.value, nil
+	}
+	allowed := allowedValues(capDef, argDef)
+
+	if !argUrn.IsList() {
+		value, err := typedCLIItem(argDef, argUrn, text, allowed)
+		if _, untyped := value.(string); untyped {
+			return source.value, err
+		}
+		return value, err
+	}
+
+	items := source.list
+	if items == nil {
+		if items = jsonArrayItems(text); items == nil {
+			return source.value, nil
+		}
+	}
+	values := make([]interface{}, len(items))
+	for i, item := range items {
+		value, err := typedCLIItem(argDef, argUrn, item, allowed)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// typedCLIItem returns a scalar value, or an element of a list, given as text: typed
+// as the argument's media URN says, else the text itself
+func typedCLIItem(argDef *cap.CapArg, argUrn *urn.MediaUrn, text string, allowed []interface{}) (interface{}, error) {
+	invalid := func(expected string) error {
+		return fmt.Errorf("invalid value %q for %s: expected %s", text, cliArgName(argDef), expected)
+	}
+
+	var value interface{} = text
+	switch {
+	case argUrn.IsBool():
+		b, ok := parseCLIBool(text)
+		if !ok {
+			return nil, invalid("true or false")
+		}
+		value = b
+	case argUrn.IsNumeric() && argUrn.HasTag(MediaTagInteger):
+		i, err := strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+		if err != nil {
+			return nil, invalid("an integer")
+		}
+		value = i
+	case argUrn.IsNumeric():
+		f, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+		if err != nil {
+			return nil, invalid("a number")
+		}
+		value = f
+	}
+
+	if len(allowed) > 0 && !enumContains(allowed, value) {
+		names := make([]string, len(allowed))
+		for i, a := range allowed {
+			names[i] = fmt.Sprint(a)
+		}
+		return nil, invalid("one of " + strings.Join(names, ", "))
+	}
+	return value, nil
+}
+
+// isJSONArray reports whether text is a JSON array
+func isJSONArray(text string) bool {
+	return jsonArrayItems(text) != nil
+}
+
+// jsonArrayItems returns the elements of a JSON array as text: strings as-is, other
+// values as JSON. nil if text isn't a JSON array.
+func jsonArrayItems(text string) []string {
+	trimmed := strings.TrimSpace(text)
+	if !strings.HasPrefix(trimmed, "[") {
+		return nil
+	}
+	var elements []json.RawMessage
+	if json.Unmarshal([]byte(trimmed), &elements) != nil {
+		return nil
+	}
+	items := make([]string, len(elements))
+	for i, element := range elements {
+		var s string
+		if json.Unmarshal(element, &s) == nil {
+			items[i] = s
+		} else {
+			items[i] = string(element)
+		}
+	}
+	return items
+}
+
+// parseCLIBool parses the spellings of a boolean on the command line
+func parseCLIBool(text string) (bool, bool) {
+	switch strings.ToLower(strings.TrimSpace(text)) {
+	case "true", "1", "yes", "on":
+		return true, true
+	case "false", "0", "no", "off":
+		return false, true
+	}
+	return false, false
+}
+
+// allowedValues returns the values the media spec of argDef allows, or the elements of
+// a list argument, nil if it doesn't enumerate them
+func allowedValues(capDef *cap.Cap, argDef *cap.CapArg) []interface{} {
+	for _, spec := range capDef.GetMediaSpecs() {
+		if spec.Urn != argDef.MediaUrn {
+			continue
+		}
+		if schema, ok := normalizeMetaValue(spec.Schema).(map[string]interface{}); ok {
+			if enum, ok := schema["enum"].([]interface{}); ok && len(enum) > 0 {
+				return enum
+			}
+			// The elements of a list
+			if items, ok := schema["items"].(map[string]interface{}); ok {
+				if enum, ok := items["enum"].([]interface{}); ok && len(enum) > 0 {
+					return enum
+				}
+			}
+		}
+		if spec.Validation != nil && len(spec.Validation.AllowedValues) > 0 {
+			allowed := make([]interface{}, len(spec.Validation.AllowedValues))
+			for i, a := range spec.Validation.AllowedValues {
+				allowed[i] = a
+			}
+			return allowed
+		}
+	}
+	return nil
+}
+
+// enumContains reports whether value is one of allowed, comparing as JSON, or as text
+// for allowed values given as strings
+func enumContains(allowed []interface{}, value interface{}) bool {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return false
+	}
+	for _, a := range allowed {
+		if s, ok := a.(string); ok && s == fmt.Sprint(value) {
+			return true
+		}
+		if candidate, err := json.Marshal(a); err == nil && string(candidate) == string(encoded) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+package bifaci
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+
+	"github.com/machinefabric/capdag-go/cap"
+	"github.com/machinefabric/capdag-go/media"
+)
+
+// newTypedArgsCap returns a cap taking an integer, a number, a boolean flag, an
+// enumerated mode and a positional name
+func newTypedArgsCap(t *testing.T) (*PluginRuntime, *cap.Cap) {
+	t.Helper()
+	capDef := createTestCap(`cap:in="media:void";op=tune;out="media:void"`, "Tune", "tune", []cap.CapArg{
+		{MediaUrn: "media:integer;textable;numeric", Sources: []cap.ArgSource{cliFlagSource("--count")}},
+		{MediaUrn: "media:textable;numeric", Sources: []cap.ArgSource{cliFlagSource("--ratio")}},
+		{MediaUrn: "media:bool;textable", Sources: []cap.ArgSource{cliFlagSource("--verbose")}},
+		{MediaUrn: "media:mode;textable", Sources: []cap.ArgSource{cliFlagSource("--mode")}},
+		{MediaUrn: "media:name;textable", Sources: []cap.ArgSource{positionSource(0)}},
+	})
+	capDef.MediaSpecs = []media.MediaSpecDef{{
+		Urn:       "media:mode;textable",
+		MediaType: "text/plain",
+		Schema:    map[string]interface{}{"enum": []interface{}{"fast", "slow"}},
+	}}
+	manifest := createTestManifest("TestPlugin", "1.0.0", "Test", []*cap.Cap{capDef})
+	runtime, err := NewPluginRuntimeWithManifest(manifest)
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	return runtime, &manifest.Caps[0]
+}
+
+func TestTypedCLIValues(t *testing.T) {
+	runtime, capDef := newTypedArgsCap(t)
+	values := func(cliArgs ...string) []interface{} {
+		t.Helper()
+		arguments, err := runtime.buildCLIArguments(capDef, cliArgs, nil)
+		if err != nil {
+			t.Fatalf("buildCLIArguments failed: %v", err)
+		}
+		values := make([]interface{}, len(arguments))
+		for i, arg := range arguments {
+			values[i] = arg.value
+		}
+		return values
+	}
+
+	// A bare boolean flag doesn't take the positional argument after it
+	got := values("--count", "3", "--ratio", "0.5", "--verbose", "job", "--mode", "fast")
+	expected := []interface{}{int64(3), 0.5, true, []byte("fast"), []byte("job")}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	// A boolean flag not given is false, as is its negation
+	for _, cliArgs := range [][]string{{"job"}, {"--no-verbose", "job"}, {"--verbose=false", "job"}} {
+		if got := values(cliArgs...); len(got) != 2 || got[0] != false {
+			t.Errorf("%v: expected verbose false, got %v", cliArgs, got)
+		}
+	}
+}
+
+func TestTypedCLIValueErrors(t *testing.T) {
+	runtime, capDef := newTypedArgsCap(t)
+	cases := []struct {
+		cliArgs  []string
+		expected string
+	}{
+		{[]string{"--count", "3.5"}, `invalid value "3.5" for --count: expected an integer`},
+		{[]string{"--ratio", "half"}, `invalid value "half" for --ratio: expected a number`},
+		{[]string{"--verbose=maybe"}, `invalid value "maybe" for --verbose: expected true or false`},
+		{[]string{"--mode", "medium"}, `invalid value "medium" for --mode: expected one of fast, slow`},
+	}
+	for _, c := range cases {
+		_, err := runtime.buildCLIArguments(capDef, c.cliArgs, nil)
+		if err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("%v: expected %q, got %v", c.cliArgs, c.expected, err)
+		}
+	}
+}
+
+func TestListCLIValues(t *testing.T) {
+	capDef := createTestCap(`cap:in="media:void";op=tag;out="media:void"`, "Tag", "tag", []cap.CapArg{
+		{MediaUrn: "media:tag;textable;list", Sources: []cap.ArgSource{cliFlagSource("--tag")}},
+		{MediaUrn: "media:integer;list;textable;numeric", Sources: []cap.ArgSource{positionSource(0)}},
+	})
+	manifest := createTestManifest("TestPlugin", "1.0.0", "Test", []*cap.Cap{capDef})
+	runtime, err := NewPluginRuntimeWithManifest(manifest)
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+
+	cases := []struct {
+		cliArgs  []string
+		expected []interface{}
+	}{
+		{
+			[]string{"--tag", "a", "1", "--tag=b", "2", "3"},
+			[]interface{}{[]interface{}{"a", "b"}, []interface{}{int64(1), int64(2), int64(3)}},
+		},
+		{
+			[]string{"--tag", `["x", "y"]`, "[4, 5]"},
+			[]interface{}{[]interface{}{"x", "y"}, []interface{}{int64(4), int64(5)}},
+		},
+	}
+	for _, c := range cases {
+		arguments, err := runtime.buildCLIArguments(&manifest.Caps[0], c.cliArgs, nil)
+		if err != nil {
+			t.Fatalf("%v: buildCLIArguments failed: %v", c.cliArgs, err)
+		}
+		got := make([]interface{}, len(arguments))
+		for i, arg := range arguments {
+			got[i] = arg.value
+		}
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%v: expected %v, got %v", c.cliArgs, c.expected, got)
+		}
+	}
+
+	if _, err := runtime.buildCLIArguments(&manifest.Caps[0], []string{"1", "two"}, nil); err == nil || !strings.Contains(err.Error(), `invalid value "two"`) {
+		t.Errorf("Expected an error for the non-integer element, got %v", err)
+	}
+}
+
+// The files of a file-path list can be given as separate positional args
+func TestFilePathListPositionalArgs(t *testing.T) {
+	root := fileTree(t, "a.txt", "b.txt", "c.txt")
+	capDef := createTestCap(`cap:in="media:";op=batch;out="media:void"`, "Batch", "batch", []cap.CapArg{{
+		MediaUrn: "media:file-path;textable;list",
+		Required: true,
+		Sources:  []cap.ArgSource{stdinSource("media:"), positionSource(0)},
+	}})
+	manifest := createTestManifest("TestPlugin", "1.0.0", "Test", []*cap.Cap{capDef})
+	runtime, err := NewPluginRuntimeWithManifest(manifest)
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+
+	cliArgs := []string{filepath.Join(root, "c.txt"), filepath.Join(root, "a.txt")}
+	result, err := runtime.extractArgValue(&manifest.Caps[0].Args[0], cliArgs, nil)
+	if err != nil {
+		t.Fatalf("extractArgValue failed: %v", err)
+	}
+	var files [][]byte
+	if err := cborlib.Unmarshal(result, &files); err != nil {
+		t.Fatalf("Failed to decode CBOR array: %v", err)
+	}
+	if len(files) != 2 || string(files[0]) != "a.txt" || string(files[1]) != "c.txt" {
+		t.Errorf("Expected a.txt and c.txt, got %q", files)
+	}
+}
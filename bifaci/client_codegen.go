@@ -0,0 +1,310 @@
+package bifaci
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"strings"
+	"unicode"
+
+	"github.com/machinefabric/capdag-go/cap"
+	"github.com/machinefabric/capdag-go/urn"
+)
+
+// ClientOptions configures GenerateClient
+type ClientOptions struct {
+	Package  string // Package of the generated file
+	TypeName string // Client type ("" = the manifest name followed by "Client")
+	Command  string // Command line recorded in the generated file's header ("" = capns-gen)
+}
+
+// GenerateClient generates the Go source of a typed client for the caps of a manifest,
+// so callers don't hand-write Invoke calls with raw media URNs. The client wraps a
+// PeerInvoker and has one method per cap (except the identity cap), named after its op
+// tag, taking a context and one parameter per argument and returning the decoded output:
+//
+//	func (c *ModelsClient) Generate(ctx context.Context, prompt string, temperature *float64) (string, error)
+//
+// Go types follow the media URNs: void is no value, textable is string, numeric
+// float64, bool bool, record map[string]interface{}, list a slice and anything else
+// []byte. Optional arguments are pointers or nil-able, and left out when nil.
+// cmd/capns-gen runs it from go:generate:
+//
+//	//go:generate go run github.com/machinefabric/capdag-go/cmd/capns-gen -manifest manifest.yaml -package models -out client_gen.go
+func GenerateClient(manifest *CapManifest, opts ClientOptions) ([]byte, error) {
+	if opts.Package == "" {
+		return nil, fmt.Errorf("the client needs a package name")
+	}
+	typeName := opts.TypeName
+	if typeName == "" {
+		typeName = goIdentifier(manifest.Name, true) + "Client"
+	}
+	command := opts.Command
+	if command == "" {
+		command = "capns-gen"
+	}
+
+	identityUrn, err := urn.NewCapUrnFromString("cap:")
+	if err != nil {
+		return nil, err
+	}
+	var methods []clientMethod
+	names := make(map[string]bool)
+	for i := range manifest.Caps {
+		capDef := &manifest.Caps[i]
+		if capDef.Urn == nil || capDef.Urn.Equals(identityUrn) {
+			continue
+		}
+		method, err := newClientMethod(capDef, names)
+		if err != nil {
+			return nil, fmt.Errorf("cap %s: %w", capDef.UrnString(), err)
+		}
+		methods = append(methods, method)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by %s from the %s manifest. DO NOT EDIT.\n\n", command, manifest.Name)
+	fmt.Fprintf(&b, "package %s\n\n", opts.Package)
+	b.WriteString("import (\n")
+	if len(methods) > 0 {
+		b.WriteString("\t\"context\"\n\n")
+	}
+	b.WriteString("\t\"github.com/machinefabric/capdag-go/bifaci\"\n")
+	if len(methods) > 0 {
+		b.WriteString("\t\"github.com/machinefabric/capdag-go/cap\"\n")
+	}
+	b.WriteString(")\n\n")
+	fmt.Fprintf(&b, "// %s invokes the caps of %s %s through a bifaci.PeerInvoker\n", typeName, manifest.Name, manifest.Version)
+	fmt.Fprintf(&b, "type %s struct {\n\tpeer bifaci.PeerInvoker\n}\n\n", typeName)
+	fmt.Fprintf(&b, "// New%s returns a client invoking caps through peer\n", typeName)
+	fmt.Fprintf(&b, "func New%s(peer bifaci.PeerInvoker) *%s {\n\treturn &%s{peer: peer}\n}\n", typeName, typeName, typeName)
+	for _, method := range methods {
+		b.WriteString("\n")
+		method.write(&b, typeName)
+	}
+
+	source, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated client doesn't parse: %w", err)
+	}
+	return source, nil
+}
+
+// clientMethod is the method of a generated client invoking one cap
+type clientMethod struct {
+	name   string
+	capDef *cap.Cap
+	params []clientParam
+	result goMediaType
+}
+
+// clientParam is a method parameter passing one cap argument
+type clientParam struct {
+	name     string
+	arg      cap.CapArg
+	goType   goMediaType
+	optional bool
+}
+
+// byPointer reports whether an optional parameter is a pointer, nil to leave it out;
+// slices and maps are nil-able as they are
+func (p clientParam) byPointer() bool {
+	return p.optional && !strings.HasPrefix(p.goType.name, "[]") && !strings.HasPrefix(p.goType.name, "map[")
+}
+
+// goMediaType is the Go type values of a media URN are passed as
+type goMediaType struct {
+	name   string // "" for void
+	encode string // How a value v becomes a CapArgumentValue: "bytes", "string" or "json"
+}
+
+func newClientMethod(capDef *cap.Cap, names map[string]bool) (clientMethod, error) {
+	name := ""
+	if op, ok := capDef.Urn.GetTag("op"); ok && op != "*" {
+		name = goIdentifier(op, true)
+	}
+	if (name == "" || names[name]) && capDef.Command != "" {
+		name += goIdentifier(capDef.Command, true)
+	}
+	if name == "" {
+		name = "Invoke"
+	}
+	for base, n := name, 2; names[name]; n++ {
+		name = fmt.Sprintf("%s%d", base, n)
+	}
+	names[name] = true
+
+	method := clientMethod{name: name, capDef: capDef}
+	paramNames := map[string]bool{"ctx": true, "c": true, "arguments": true, "response": true, "result": true, "err": true}
+	for i, arg := range capDef.Args {
+		goType, err := goTypeForMedia(arg.MediaUrn)
+		if err != nil {
+			return clientMethod{}, err
+		}
+		if goType.name == "" {
+			continue
+		}
+		paramName := goIdentifier(mediaName(arg.MediaUrn), false)
+		if paramName == "" || paramNames[paramName] {
+			paramName = fmt.Sprintf("arg%d", i)
+		}
+		paramNames[paramName] = true
+		method.params = append(method.params, clientParam{name: paramName, arg: arg, goType: goType, optional: !arg.Required})
+	}
+	if capDef.Output != nil {
+		result, err := goTypeForMedia(capDef.Output.MediaUrn)
+		if err != nil {
+			return clientMethod{}, err
+		}
+		method.result = result
+	} else if outSpec := capDef.Urn.OutSpec(); outSpec != "" {
+		if result, err := goTypeForMedia(outSpec); err == nil {
+			method.result = result
+		}
+	}
+	return method, nil
+}
+
+func (m clientMethod) write(b *bytes.Buffer, typeName string) {
+	if m.capDef.Title != "" {
+		fmt.Fprintf(b, "// %s invokes the %s cap (%s)\n", m.name, m.capDef.Title, m.capDef.UrnString())
+	} else {
+		fmt.Fprintf(b, "// %s invokes %s\n", m.name, m.capDef.UrnString())
+	}
+	if m.capDef.CapDescription != nil && *m.capDef.CapDescription != "" {
+		fmt.Fprintf(b, "//\n// %s\n", *m.capDef.CapDescription)
+	}
+	if m.capDef.Deprecated {
+		fmt.Fprintf(b, "//\n// Deprecated: %s\n", m.capDef.DeprecationNotice())
+	}
+
+	var signature []string
+	for _, param := range m.params {
+		goType := param.goType.name
+		if param.byPointer() {
+			goType = "*" + goType
+		}
+		signature = append(signature, param.name+" "+goType)
+	}
+	results := "error"
+	if m.result.name != "" {
+		results = fmt.Sprintf("(%s, error)", m.result.name)
+	}
+	fmt.Fprintf(b, "func (c *%s) %s(ctx context.Context", typeName, m.name)
+	for _, param := range signature {
+		b.WriteString(", " + param)
+	}
+	fmt.Fprintf(b, ") %s {\n", results)
+
+	zero := ""
+	if m.result.name != "" {
+		zero = "result, "
+		fmt.Fprintf(b, "\tvar result %s\n", m.result.name)
+	}
+	b.WriteString("\tvar arguments []cap.CapArgumentValue\n")
+	for _, param := range m.params {
+		value := param.name
+		indent := "\t"
+		if param.optional {
+			fmt.Fprintf(b, "\tif %s != nil {\n", param.name)
+			indent = "\t\t"
+			if param.byPointer() {
+				value = "*" + value
+			}
+		}
+		mediaUrn := fmt.Sprintf("%q", param.arg.MediaUrn)
+		switch param.goType.encode {
+		case "bytes":
+			fmt.Fprintf(b, "%sarguments = append(arguments, cap.NewCapArgumentValue(%s, %s))\n", indent, mediaUrn, value)
+		case "string":
+			fmt.Fprintf(b, "%sarguments = append(arguments, cap.NewCapArgumentValueFromStr(%s, %s))\n", indent, mediaUrn, value)
+		default:
+			fmt.Fprintf(b, "%s%sArg, err := cap.NewCapArgumentValueJSON(%s, %s)\n", indent, param.name, mediaUrn, value)
+			fmt.Fprintf(b, "%sif err != nil {\n%s\treturn %serr\n%s}\n", indent, indent, zero, indent)
+			fmt.Fprintf(b, "%sarguments = append(arguments, %sArg)\n", indent, param.name)
+		}
+		if param.optional {
+			b.WriteString("\t}\n")
+		}
+	}
+
+	if m.result.name == "" {
+		fmt.Fprintf(b, "\tif _, err := bifaci.PeerCall(ctx, c.peer, %q, arguments); err != nil {\n", m.capDef.UrnString())
+		b.WriteString("\t\treturn err\n\t}\n\treturn nil\n}\n")
+		return
+	}
+	fmt.Fprintf(b, "\tresponse, err := bifaci.PeerCall(ctx, c.peer, %q, arguments)\n", m.capDef.UrnString())
+	b.WriteString("\tif err != nil {\n\t\treturn result, err\n\t}\n")
+	b.WriteString("\tif stream := response.First(); stream != nil {\n")
+	b.WriteString("\t\terr = stream.Decode(&result)\n\t}\n")
+	b.WriteString("\treturn result, err\n}\n")
+}
+
+// goTypeForMedia returns the Go type values of a media URN are passed as
+func goTypeForMedia(mediaUrn string) (goMediaType, error) {
+	parsed, err := urn.NewMediaUrnFromString(mediaUrn)
+	if err != nil {
+		return goMediaType{}, fmt.Errorf("invalid media URN %q: %w", mediaUrn, err)
+	}
+	var element goMediaType
+	switch {
+	case parsed.IsVoid():
+		return goMediaType{}, nil
+	case parsed.IsRecord() || parsed.IsJson():
+		element = goMediaType{name: "map[string]interface{}", encode: "json"}
+	case parsed.IsBool():
+		element = goMediaType{name: "bool", encode: "json"}
+	case parsed.IsNumeric():
+		element = goMediaType{name: "float64", encode: "json"}
+	case parsed.IsTextable():
+		element = goMediaType{name: "string", encode: "string"}
+	default:
+		element = goMediaType{name: "[]byte", encode: "bytes"}
+	}
+	if parsed.IsList() {
+		return goMediaType{name: "[]" + element.name, encode: "json"}, nil
+	}
+	return element, nil
+}
+
+// mediaName returns the first tag of a media URN that names its type rather than
+// marking a property, e.g. "model-spec" for "media:model-spec;textable"
+func mediaName(mediaUrn string) string {
+	markers := map[string]bool{"textable": true, "record": true, "list": true, "json": true, "numeric": true, "bool": true, "void": true, "binary": true}
+	for _, tag := range strings.Split(strings.TrimPrefix(mediaUrn, "media:"), ";") {
+		tag = strings.Trim(tag, `" `)
+		if tag != "" && !strings.Contains(tag, "=") && !markers[tag] {
+			return tag
+		}
+	}
+	return ""
+}
+
+// goIdentifier turns a name like "extract_metadata" or "model-spec" into a Go
+// identifier in camel case, exported if upper; "" if nothing of it is usable
+func goIdentifier(name string, upper bool) string {
+	var b strings.Builder
+	capitalize := upper
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			capitalize = b.Len() > 0 || upper
+			continue
+		}
+		if b.Len() == 0 && unicode.IsDigit(r) {
+			b.WriteRune('_')
+		}
+		if capitalize {
+			r = unicode.ToUpper(r)
+			capitalize = false
+		} else if b.Len() == 0 && !upper {
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	identifier := b.String()
+	if token.IsKeyword(identifier) {
+		identifier += "Value"
+	}
+	return identifier
+}
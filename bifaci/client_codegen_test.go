@@ -0,0 +1,75 @@
+package bifaci
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/machinefabric/capdag-go/cap"
+	"github.com/machinefabric/capdag-go/standard"
+	"github.com/machinefabric/capdag-go/urn"
+)
+
+func TestGenerateClient(t *testing.T) {
+	identityUrn, _ := urn.NewCapUrnFromString(standard.CapIdentity)
+	generateUrn, _ := urn.NewCapUrnFromString(`cap:in="media:model-spec;textable";op=generate;out="media:textable"`)
+	resetUrn, _ := urn.NewCapUrnFromString(`cap:in="media:void";op=reset;out="media:void"`)
+
+	generate := cap.NewCap(generateUrn, "Generate", "generate")
+	generate.Args = []cap.CapArg{
+		cap.NewCapArg("media:model-spec;textable", true, nil),
+		cap.NewCapArg("media:temperature;numeric;textable", false, nil),
+		cap.NewCapArg("media:options;record;textable", false, nil),
+	}
+	generate.Output = cap.NewCapOutput("media:textable", "generated text")
+	manifest := NewCapManifest("models", "1.0", "Models", []cap.Cap{
+		*cap.NewCap(identityUrn, "Identity", "identity"),
+		*generate,
+		*cap.NewCap(resetUrn, "Reset", "reset"),
+	})
+
+	source, err := GenerateClient(manifest, ClientOptions{Package: "models"})
+	if err != nil {
+		t.Fatalf("Failed to generate the client: %v", err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "client_gen.go", source, 0); err != nil {
+		t.Fatalf("Generated client doesn't parse: %v\n%s", err, source)
+	}
+	code := string(source)
+	for _, want := range []string{
+		"type ModelsClient struct",
+		"func NewModelsClient(peer bifaci.PeerInvoker) *ModelsClient",
+		"func (c *ModelsClient) Generate(ctx context.Context, modelSpec string, temperature *float64, options map[string]interface{}) (string, error)",
+		"func (c *ModelsClient) Reset(ctx context.Context) error",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("Expected the client to contain %q:\n%s", want, code)
+		}
+	}
+	if strings.Contains(code, "Identity(") {
+		t.Error("Expected no method for the identity cap")
+	}
+
+	if _, err := GenerateClient(manifest, ClientOptions{}); err == nil {
+		t.Error("Expected an error without a package name")
+	}
+}
+
+func TestGoIdentifier(t *testing.T) {
+	cases := []struct {
+		name  string
+		upper bool
+		want  string
+	}{
+		{"extract_metadata", true, "ExtractMetadata"},
+		{"model-spec", false, "modelSpec"},
+		{"Type", false, "typeValue"},
+		{"2d", false, "_2d"},
+	}
+	for _, c := range cases {
+		if got := goIdentifier(c.name, c.upper); got != c.want {
+			t.Errorf("goIdentifier(%q, %v) = %q, want %q", c.name, c.upper, got, c.want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package bifaci
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 
@@ -29,9 +30,23 @@ const (
 	keyChecksum    = 16 // checksum (u64, REQUIRED for CHUNK frames - FNV-1a hash)
 )
 
+// frameEncMode sorts map keys so that a frame always encodes to the same bytes: integer
+// keys ascend as in the Rust encoder, and meta keys follow the core deterministic order.
+// The conformance vectors depend on it.
+var frameEncMode, _ = cbor.EncOptions{Sort: cbor.SortCoreDeterministic}.UserBufferEncMode()
+
 // EncodeFrame encodes a Frame to CBOR bytes using integer keys (matches Rust)
 func EncodeFrame(frame *Frame) ([]byte, error) {
-	// Build CBOR map with integer keys matching Rust layout
+	return frameEncMode.Marshal(frameMap(frame))
+}
+
+// encodeFrameTo appends the encoding of frame to buf, for writers that reuse buffers
+func encodeFrameTo(buf *bytes.Buffer, frame *Frame) error {
+	return frameEncMode.MarshalToBuffer(frameMap(frame), buf)
+}
+
+// frameMap builds the CBOR map of a frame with integer keys matching the Rust layout
+func frameMap(frame *Frame) map[int]interface{} {
 	m := make(map[int]interface{})
 
 	// 0: version (always 1)
@@ -123,7 +138,7 @@ func EncodeFrame(frame *Frame) ([]byte, error) {
 		m[keyChecksum] = *frame.Checksum
 	}
 
-	return cbor.Marshal(m)
+	return m
 }
 
 // DecodeFrame decodes CBOR bytes to a Frame using integer keys (matches Rust)
@@ -156,8 +171,8 @@ func DecodeFrame(data []byte) (*Frame, error) {
 	}
 	if ft, ok := ftVal.(uint64); ok {
 		frameType := FrameType(ft)
-		// Validate frame type is in valid range (0-11, excluding removed value 2)
-		if frameType < FrameTypeHello || frameType > FrameTypeRelayState {
+		// Validate frame type is in valid range (0-17, excluding removed value 2)
+		if frameType < FrameTypeHello || frameType > FrameTypeNotify {
 			return nil, fmt.Errorf("invalid frame_type %d", ft)
 		}
 		// Reject old RES frame type (2) - no longer supported
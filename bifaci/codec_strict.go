@@ -0,0 +1,233 @@
+package bifaci
+
+import (
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// FrameDecodeError is returned by the strict decoder (DecodeFrameStrict, or a
+// FrameReader with SetStrict) for a frame that doesn't conform to the protocol
+type FrameDecodeError struct {
+	Type    FrameDecodeErrorType
+	Key     int // CBOR map key of the offending field, -1 if none
+	Message string
+}
+
+type FrameDecodeErrorType int
+
+const (
+	FrameDecodeErrorTypeMalformed        FrameDecodeErrorType = iota // Not a CBOR map with integer keys
+	FrameDecodeErrorTypeUnknownField                                 // Map key outside the protocol's keys
+	FrameDecodeErrorTypeInvalidFrameType                             // frame_type is not a known type
+	FrameDecodeErrorTypeInvalidField                                 // Field of the wrong type or value
+	FrameDecodeErrorTypeMissingField                                 // Field the frame type requires is absent
+	FrameDecodeErrorTypeOverLimit                                    // Payload exceeds the negotiated limits
+)
+
+func (e *FrameDecodeError) Error() string {
+	switch e.Type {
+	case FrameDecodeErrorTypeMalformed:
+		return fmt.Sprintf("malformed frame: %s", e.Message)
+	case FrameDecodeErrorTypeUnknownField:
+		return fmt.Sprintf("unknown frame field %d", e.Key)
+	case FrameDecodeErrorTypeInvalidFrameType:
+		return fmt.Sprintf("invalid frame type: %s", e.Message)
+	case FrameDecodeErrorTypeInvalidField:
+		return fmt.Sprintf("invalid frame field %d: %s", e.Key, e.Message)
+	case FrameDecodeErrorTypeMissingField:
+		return fmt.Sprintf("missing frame field: %s", e.Message)
+	case FrameDecodeErrorTypeOverLimit:
+		return fmt.Sprintf("frame over limit: %s", e.Message)
+	default:
+		return fmt.Sprintf("frame decode error: %s", e.Message)
+	}
+}
+
+// strictDecMode rejects duplicate map keys and indefinite-length items, neither of
+// which the Rust encoder produces
+var strictDecMode, _ = cbor.DecOptions{
+	DupMapKey:   cbor.DupMapKeyEnforcedAPF,
+	IndefLength: cbor.IndefLengthForbidden,
+}.DecMode()
+
+// DecodeFrameStrict decodes a frame like DecodeFrame, but instead of ignoring what it
+// doesn't understand it fails with a *FrameDecodeError: unknown keys, fields of the
+// wrong type, frame types outside the protocol, fields the frame type requires that are
+// missing, and CHUNK payloads over the default max_chunk. It is meant for fuzzing and
+// for peers that want to catch protocol drift early.
+func DecodeFrameStrict(data []byte) (*Frame, error) {
+	return decodeFrameStrict(data, DefaultLimits())
+}
+
+func decodeFrameStrict(data []byte, limits Limits) (*Frame, error) {
+	var m map[interface{}]interface{}
+	if err := strictDecMode.Unmarshal(data, &m); err != nil {
+		return nil, &FrameDecodeError{Type: FrameDecodeErrorTypeMalformed, Key: -1, Message: err.Error()}
+	}
+
+	for k, v := range m {
+		key, ok := k.(uint64)
+		if !ok {
+			if _, negative := k.(int64); negative {
+				return nil, &FrameDecodeError{Type: FrameDecodeErrorTypeUnknownField, Key: int(k.(int64))}
+			}
+			return nil, &FrameDecodeError{Type: FrameDecodeErrorTypeMalformed, Key: -1, Message: fmt.Sprintf("map key %v is not an integer", k)}
+		}
+		if key > keyChecksum {
+			return nil, &FrameDecodeError{Type: FrameDecodeErrorTypeUnknownField, Key: int(key)}
+		}
+		if err := checkStrictField(int(key), v); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, key := range []int{keyVersion, keyFrameType, keyId} {
+		if _, ok := m[uint64(key)]; !ok {
+			return nil, &FrameDecodeError{Type: FrameDecodeErrorTypeMissingField, Key: key, Message: fmt.Sprintf("key %d", key)}
+		}
+	}
+	frameType := FrameType(m[uint64(keyFrameType)].(uint64))
+	for _, key := range strictRequiredKeys[frameType] {
+		if _, ok := m[uint64(key)]; !ok {
+			return nil, &FrameDecodeError{Type: FrameDecodeErrorTypeMissingField, Key: key,
+				Message: fmt.Sprintf("%v frame requires key %d", frameType, key)}
+		}
+	}
+
+	// The fields are well-typed, so the lenient decoder reads every one of them
+	frame, err := DecodeFrame(data)
+	if err != nil {
+		return nil, &FrameDecodeError{Type: FrameDecodeErrorTypeMalformed, Key: -1, Message: err.Error()}
+	}
+	if err := checkStrictFrame(frame, limits); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// strictRequiredKeys lists the fields each frame type carries besides version,
+// frame_type and id
+var strictRequiredKeys = map[FrameType][]int{
+	FrameTypeReq:         {keyCap},
+	FrameTypeChunk:       {keyStreamId, keyChunkIndex, keyChecksum},
+	FrameTypeStreamStart: {keyStreamId, keyMediaUrn},
+	FrameTypeStreamEnd:   {keyStreamId, keyChunkCount},
+	FrameTypeRelayState:  {keyPayload},
+}
+
+// checkStrictField checks the CBOR type (and for version and frame_type, the value) of
+// one field
+func checkStrictField(key int, value interface{}) error {
+	invalid := func(format string, args ...interface{}) error {
+		return &FrameDecodeError{Type: FrameDecodeErrorTypeInvalidField, Key: key, Message: fmt.Sprintf(format, args...)}
+	}
+
+	switch key {
+	case keyVersion:
+		ver, ok := value.(uint64)
+		if !ok {
+			return invalid("version must be uint, got %T", value)
+		}
+		if ver != uint64(ProtocolVersion) {
+			return invalid("version %d, expected %d", ver, ProtocolVersion)
+		}
+	case keyFrameType:
+		ft, ok := value.(uint64)
+		if !ok {
+			return invalid("frame_type must be uint, got %T", value)
+		}
+		if ft > uint64(FrameTypeNotify) || ft == 2 {
+			return &FrameDecodeError{Type: FrameDecodeErrorTypeInvalidFrameType, Key: key, Message: fmt.Sprintf("%d", ft)}
+		}
+	case keyId, keyRoutingId:
+		switch v := value.(type) {
+		case uint64:
+		case []byte:
+			if len(v) != 16 {
+				return invalid("UUID must be 16 bytes, got %d", len(v))
+			}
+		default:
+			return invalid("must be bytes[16] or uint, got %T", value)
+		}
+	case keySeq, keyLen, keyOffset, keyChunkIndex, keyChunkCount, keyChecksum:
+		if _, ok := value.(uint64); !ok {
+			return invalid("must be uint, got %T", value)
+		}
+	case keyContentType, keyCap, keyStreamId, keyMediaUrn:
+		if _, ok := value.(string); !ok {
+			return invalid("must be text, got %T", value)
+		}
+	case keyMeta:
+		meta, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return invalid("meta must be a map, got %T", value)
+		}
+		for k := range meta {
+			if _, ok := k.(string); !ok {
+				return invalid("meta key %v is not text", k)
+			}
+		}
+	case keyPayload:
+		if _, ok := value.([]byte); !ok {
+			return invalid("payload must be bytes, got %T", value)
+		}
+	case keyEof:
+		if _, ok := value.(bool); !ok {
+			return invalid("eof must be bool, got %T", value)
+		}
+	}
+	return nil
+}
+
+// checkStrictFrame checks the meta entries each frame type requires and the payload
+// limits
+func checkStrictFrame(frame *Frame, limits Limits) error {
+	requireMeta := func(name string, isValid func(interface{}) bool) error {
+		value, ok := frame.Meta[name]
+		if !ok {
+			return &FrameDecodeError{Type: FrameDecodeErrorTypeMissingField, Key: keyMeta,
+				Message: fmt.Sprintf("%v frame requires meta %s", frame.FrameType, name)}
+		}
+		if !isValid(value) {
+			return &FrameDecodeError{Type: FrameDecodeErrorTypeInvalidField, Key: keyMeta,
+				Message: fmt.Sprintf("meta %s has type %T", name, value)}
+		}
+		return nil
+	}
+	isText := func(v interface{}) bool { _, ok := v.(string); return ok }
+	isUint := func(v interface{}) bool { _, ok := v.(uint64); return ok }
+	isBytes := func(v interface{}) bool { _, ok := v.([]byte); return ok }
+
+	var required []string
+	var valid []func(interface{}) bool
+	switch frame.FrameType {
+	case FrameTypeHello:
+		required, valid = []string{"max_frame", "max_chunk"}, []func(interface{}) bool{isUint, isUint}
+	case FrameTypeErr:
+		required, valid = []string{"code", "message"}, []func(interface{}) bool{isText, isText}
+	case FrameTypeLog:
+		required, valid = []string{"level", "message"}, []func(interface{}) bool{isText, isText}
+	case FrameTypeAck:
+		required, valid = []string{"credit"}, []func(interface{}) bool{isUint}
+	case FrameTypeRelayNotify:
+		required, valid = []string{"manifest", "max_frame", "max_chunk"}, []func(interface{}) bool{isBytes, isUint, isUint}
+	case FrameTypeManifestUpdated:
+		required, valid = []string{"manifest"}, []func(interface{}) bool{isBytes}
+	case FrameTypeSessionClose:
+		required, valid = []string{"session_id"}, []func(interface{}) bool{isText}
+	case FrameTypeAccepted:
+		required, valid = []string{"job_id"}, []func(interface{}) bool{isText}
+	case FrameTypeChunk:
+		if len(frame.Payload) > limits.MaxChunk {
+			return &FrameDecodeError{Type: FrameDecodeErrorTypeOverLimit, Key: keyPayload,
+				Message: fmt.Sprintf("CHUNK payload of %d bytes exceeds max_chunk %d", len(frame.Payload), limits.MaxChunk)}
+		}
+	}
+	for i, name := range required {
+		if err := requireMeta(name, valid[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
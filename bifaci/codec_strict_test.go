@@ -0,0 +1,141 @@
+package bifaci
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Every frame the constructors build passes the strict decoder
+func TestDecodeFrameStrictAcceptsConstructedFrames(t *testing.T) {
+	id := NewMessageIdRandom()
+	payload := []byte{0x43, 1, 2, 3}
+	frames := []*Frame{
+		NewHello(DefaultMaxFrame, DefaultMaxChunk, DefaultMaxReorderBuffer),
+		NewHelloWithManifest(DefaultMaxFrame, DefaultMaxChunk, DefaultMaxReorderBuffer, []byte("{}")),
+		NewReq(id, `cap:in="media:void";op=test;out="media:void"`, nil, "application/cbor"),
+		NewStreamStart(id, "s1", "media:bytes"),
+		NewChunk(id, "s1", 0, payload, 0, ComputeChecksum(payload)),
+		NewStreamEnd(id, "s1", 1),
+		NewEnd(id, nil),
+		NewErr(id, "HANDLER_ERROR", "failed"),
+		NewLog(id, "info", "hello"),
+		NewHeartbeat(id),
+		NewCancel(id),
+		NewAck(id, 4),
+		NewRelayNotify([]byte("{}"), DefaultMaxFrame, DefaultMaxChunk, DefaultMaxReorderBuffer),
+		NewRelayState([]byte("{}")),
+	}
+	for _, frame := range frames {
+		encoded, err := EncodeFrame(frame)
+		if err != nil {
+			t.Fatalf("EncodeFrame(%v) failed: %v", frame.FrameType, err)
+		}
+		decoded, err := DecodeFrameStrict(encoded)
+		if err != nil {
+			t.Errorf("DecodeFrameStrict rejected %v: %v", frame.FrameType, err)
+			continue
+		}
+		if decoded.FrameType != frame.FrameType || decoded.Id.ToString() != frame.Id.ToString() {
+			t.Errorf("DecodeFrameStrict changed %v frame", frame.FrameType)
+		}
+	}
+}
+
+func TestDecodeFrameStrictRejections(t *testing.T) {
+	id := NewMessageIdRandom().uuidBytes
+	base := func(frameType FrameType) map[int]interface{} {
+		return map[int]interface{}{keyVersion: ProtocolVersion, keyFrameType: uint8(frameType), keyId: id}
+	}
+	with := func(m map[int]interface{}, key int, value interface{}) map[int]interface{} {
+		m[key] = value
+		return m
+	}
+	chunk := func(payload []byte) map[int]interface{} {
+		m := base(FrameTypeChunk)
+		m[keyStreamId] = "s1"
+		m[keyChunkIndex] = 0
+		m[keyChecksum] = ComputeChecksum(payload)
+		m[keyPayload] = payload
+		return m
+	}
+
+	tests := []struct {
+		name  string
+		frame interface{}
+		want  FrameDecodeErrorType
+		key   int
+	}{
+		{"not a map", []int{1, 2}, FrameDecodeErrorTypeMalformed, -1},
+		{"text key", map[string]int{"version": 2}, FrameDecodeErrorTypeMalformed, -1},
+		{"unknown key", with(base(FrameTypeEnd), 17, "x"), FrameDecodeErrorTypeUnknownField, 17},
+		{"negative key", with(base(FrameTypeEnd), -1, 0), FrameDecodeErrorTypeUnknownField, -1},
+		{"removed frame type", base(FrameType(2)), FrameDecodeErrorTypeInvalidFrameType, keyFrameType},
+		{"frame type past notify", base(FrameTypeNotify + 1), FrameDecodeErrorTypeInvalidFrameType, keyFrameType},
+		{"wrong version", with(base(FrameTypeEnd), keyVersion, 1), FrameDecodeErrorTypeInvalidField, keyVersion},
+		{"short id", with(base(FrameTypeEnd), keyId, []byte{1, 2}), FrameDecodeErrorTypeInvalidField, keyId},
+		{"text seq", with(base(FrameTypeEnd), keySeq, "1"), FrameDecodeErrorTypeInvalidField, keySeq},
+		{"text payload", with(base(FrameTypeEnd), keyPayload, "x"), FrameDecodeErrorTypeInvalidField, keyPayload},
+		{"missing id", map[int]interface{}{keyVersion: ProtocolVersion, keyFrameType: uint8(FrameTypeEnd)}, FrameDecodeErrorTypeMissingField, keyId},
+		{"req without cap", base(FrameTypeReq), FrameDecodeErrorTypeMissingField, keyCap},
+		{"chunk without checksum", func() map[int]interface{} { m := chunk([]byte{0x40}); delete(m, keyChecksum); return m }(), FrameDecodeErrorTypeMissingField, keyChecksum},
+		{"stream start without media", with(base(FrameTypeStreamStart), keyStreamId, "s1"), FrameDecodeErrorTypeMissingField, keyMediaUrn},
+		{"stream end without count", with(base(FrameTypeStreamEnd), keyStreamId, "s1"), FrameDecodeErrorTypeMissingField, keyChunkCount},
+		{"err without message", with(base(FrameTypeErr), keyMeta, map[string]interface{}{"code": "X"}), FrameDecodeErrorTypeMissingField, keyMeta},
+		{"ack with text credit", with(base(FrameTypeAck), keyMeta, map[string]interface{}{"credit": "4"}), FrameDecodeErrorTypeInvalidField, keyMeta},
+		{"hello without limits", base(FrameTypeHello), FrameDecodeErrorTypeMissingField, keyMeta},
+		{"oversized chunk", chunk(make([]byte, DefaultMaxChunk+1)), FrameDecodeErrorTypeOverLimit, keyPayload},
+	}
+	for _, tc := range tests {
+		encoded, err := cbor.Marshal(tc.frame)
+		if err != nil {
+			t.Fatalf("%s: failed to encode: %v", tc.name, err)
+		}
+		_, err = DecodeFrameStrict(encoded)
+		var decodeErr *FrameDecodeError
+		if !errors.As(err, &decodeErr) {
+			t.Errorf("%s: expected *FrameDecodeError, got %v", tc.name, err)
+			continue
+		}
+		if decodeErr.Type != tc.want || decodeErr.Key != tc.key {
+			t.Errorf("%s: got type %d key %d (%v), want type %d key %d", tc.name, decodeErr.Type, decodeErr.Key, err, tc.want, tc.key)
+		}
+	}
+
+	// Duplicate keys can't come from a Go map, so encode one by hand: {0: 2, 0: 2}
+	if _, err := DecodeFrameStrict([]byte{0xa2, 0x00, 0x02, 0x00, 0x02}); err == nil {
+		t.Error("Expected duplicate keys to be rejected")
+	}
+}
+
+// A strict FrameReader checks CHUNK payloads against its own limits, where the lenient
+// reader passes them through
+func TestFrameReaderStrict(t *testing.T) {
+	id := NewMessageIdRandom()
+	payload := make([]byte, 100)
+	var buf bytes.Buffer
+	writer := NewFrameWriter(&buf)
+	if err := writer.WriteFrame(NewChunk(id, "s1", 0, payload, 0, ComputeChecksum(payload))); err != nil {
+		t.Fatalf("WriteFrame failed: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	limits := DefaultLimits()
+	limits.MaxChunk = 50
+	lenient := NewFrameReader(bytes.NewReader(encoded))
+	lenient.SetLimits(limits)
+	if _, err := lenient.ReadFrame(); err != nil {
+		t.Fatalf("Lenient reader failed: %v", err)
+	}
+
+	strict := NewFrameReader(bytes.NewReader(encoded))
+	strict.SetLimits(limits)
+	strict.SetStrict(true)
+	_, err := strict.ReadFrame()
+	var decodeErr *FrameDecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Type != FrameDecodeErrorTypeOverLimit {
+		t.Errorf("Expected an over-limit error, got %v", err)
+	}
+}
@@ -0,0 +1,278 @@
+package bifaci
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+
+	"github.com/machinefabric/capdag-go/cap"
+	"github.com/machinefabric/capdag-go/urn"
+)
+
+// Coercer converts the content of an argument, its bytes or text as Arg.Bytes returns
+// them, into the value to pass on in its place
+type Coercer func(content []byte) (interface{}, error)
+
+// coercion converts arguments whose media URN from accepts into ones for cap arguments
+// whose media URN to accepts
+type coercion struct {
+	from    *urn.MediaUrn
+	to      *urn.MediaUrn
+	convert Coercer
+}
+
+// CoercionRegistry holds the converters used to coerce arguments whose media URN no cap
+// argument accepts into one it does (see SetCoercionRegistry). Converters are tried in
+// the order they were registered.
+type CoercionRegistry struct {
+	coercions []coercion
+	mu        sync.RWMutex
+}
+
+// NewCoercionRegistry creates a registry with the built-in converters:
+//   - media:file-path to any argument: the contents of the file
+//   - media:json;textable to media:record: the JSON text parsed into a CBOR map
+func NewCoercionRegistry() *CoercionRegistry {
+	registry := &CoercionRegistry{}
+	registry.Register("media:file-path;textable", "media:", func(content []byte) (interface{}, error) {
+		data, err := os.ReadFile(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		return data, nil
+	})
+	registry.Register("media:json;textable", "media:record", func(content []byte) (interface{}, error) {
+		var value interface{}
+		if err := json.Unmarshal(content, &value); err != nil {
+			return nil, fmt.Errorf("not valid JSON: %w", err)
+		}
+		return value, nil
+	})
+	return registry
+}
+
+// Register adds a converter for arguments whose media URN the pattern from accepts to cap
+// arguments whose media URN the pattern to accepts
+func (r *CoercionRegistry) Register(from, to string, convert Coercer) error {
+	fromUrn, err := urn.NewMediaUrnFromString(from)
+	if err != nil {
+		return fmt.Errorf("invalid media URN pattern '%s': %w", from, err)
+	}
+	toUrn, err := urn.NewMediaUrnFromString(to)
+	if err != nil {
+		return fmt.Errorf("invalid media URN pattern '%s': %w", to, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.coercions = append(r.coercions, coercion{from: fromUrn, to: toUrn, convert: convert})
+	return nil
+}
+
+// find returns the first converter from arg to target, nil if there is none
+func (r *CoercionRegistry) find(arg, target *urn.MediaUrn) Coercer {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, c := range r.coercions {
+		if c.from.Accepts(arg) && c.to.Accepts(target) {
+			return c.convert
+		}
+	}
+	return nil
+}
+
+// SetCoercionRegistry turns on argument coercion with registry, nil turning it off. An
+// argument stream whose media URN none of the cap's arguments accepts is converted into
+// the first argument not otherwise supplied that a converter leads to, and reaches the
+// handler under that argument's media URN. Applies to the caps the manifest declares
+// unless turned off for one with SetCoercion.
+func (pr *PluginRuntime) SetCoercionRegistry(registry *CoercionRegistry) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.coercions = registry
+}
+
+// SetCoercion turns argument coercion on or off for the cap registered under capUrn.
+// Coercion needs a registry (see SetCoercionRegistry) either way.
+func (pr *PluginRuntime) SetCoercion(capUrn string, enabled bool) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if pr.coercionByCap == nil {
+		pr.coercionByCap = make(map[string]bool)
+	}
+	pr.coercionByCap[capUrn] = enabled
+}
+
+// CoercionError is returned for an argument a converter failed on. The runtime reports
+// it as ERR INVALID_ARGUMENT.
+type CoercionError struct {
+	CapUrn string
+	From   string // Media URN the argument was sent as
+	To     string // Media URN of the cap argument it was coerced to
+	Err    error
+}
+
+func (e *CoercionError) Error() string {
+	return fmt.Sprintf("failed to coerce argument of cap %s from %s to %s: %v", e.CapUrn, e.From, e.To, e.Err)
+}
+
+func (e *CoercionError) Unwrap() error {
+	return e.Err
+}
+
+// withCoercion wraps the handler registered under pattern in argument coercion, if
+// enabled for it and the manifest declares the cap. Caller holds pr.mu.
+func (pr *PluginRuntime) withCoercion(pattern string, handler HandlerFunc) HandlerFunc {
+	if pr.coercions == nil {
+		return handler
+	}
+	if enabled, ok := pr.coercionByCap[pattern]; ok && !enabled {
+		return handler
+	}
+	capDef := pr.manifestCap(pattern)
+	if capDef == nil {
+		return handler
+	}
+	registry := pr.coercions
+	return func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		var input []Frame
+		for frame := range frames {
+			input = append(input, frame)
+		}
+		coerced, err := coerceArgs(capDef, registry, input)
+		if err != nil {
+			return err
+		}
+
+		replay := make(chan Frame, len(coerced))
+		for _, frame := range coerced {
+			replay <- frame
+		}
+		close(replay)
+		return handler(replay, emitter, peer)
+	}
+}
+
+// coerceArgs returns the input frames of a request with each argument stream no
+// argument of capDef accepts replaced by its coerced version, if a converter leads to
+// an argument not otherwise supplied
+func coerceArgs(capDef *cap.Cap, registry *CoercionRegistry, input []Frame) ([]Frame, error) {
+	var targets []*urn.MediaUrn
+	for _, arg := range capDef.GetArgs() {
+		if target, err := urn.NewMediaUrnFromString(arg.MediaUrn); err == nil {
+			targets = append(targets, target)
+		}
+	}
+	if len(targets) == 0 {
+		if target, err := urn.NewMediaUrnFromString(capDef.Urn.InSpec()); err == nil {
+			targets = append(targets, target)
+		}
+	}
+
+	// Streams in the order they were started, and the arguments supplied as they are
+	var streamIds []string
+	streamUrns := make(map[string]*urn.MediaUrn)
+	supplied := make([]bool, len(targets))
+	for _, frame := range input {
+		if frame.FrameType != FrameTypeStreamStart || frame.StreamId == nil || frame.MediaUrn == nil {
+			continue
+		}
+		streamUrn, err := urn.NewMediaUrnFromString(*frame.MediaUrn)
+		if err != nil {
+			continue
+		}
+		matched := false
+		for j, target := range targets {
+			if target.Accepts(streamUrn) {
+				supplied[j] = true
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			streamIds = append(streamIds, *frame.StreamId)
+			streamUrns[*frame.StreamId] = streamUrn
+		}
+	}
+
+	// The CBOR payload each coerced stream is sent with, and its new media URN
+	payloads := make(map[string][]byte)
+	mediaUrns := make(map[string]string)
+	for _, streamId := range streamIds {
+		streamUrn := streamUrns[streamId]
+		for j, target := range targets {
+			if supplied[j] {
+				continue
+			}
+			convert := registry.find(streamUrn, target)
+			if convert == nil {
+				continue
+			}
+			coercionErr := func(err error) error {
+				return &CoercionError{CapUrn: capDef.UrnString(), From: streamUrn.String(), To: target.String(), Err: err}
+			}
+			content, err := streamContent(input, streamId)
+			if err != nil {
+				// Left for the handler to reject
+				break
+			}
+			value, err := convert(content)
+			if err != nil {
+				return nil, coercionErr(err)
+			}
+			payload, err := cborlib.Marshal(value)
+			if err != nil {
+				return nil, coercionErr(err)
+			}
+			payloads[streamId] = payload
+			mediaUrns[streamId] = target.String()
+			supplied[j] = true
+			break
+		}
+	}
+	if len(payloads) == 0 {
+		return input, nil
+	}
+
+	// Each coerced stream is sent as one CHUNK in place of its frames
+	output := make([]Frame, 0, len(input))
+	for _, frame := range input {
+		if frame.StreamId == nil || payloads[*frame.StreamId] == nil {
+			output = append(output, frame)
+			continue
+		}
+		if frame.FrameType != FrameTypeStreamStart {
+			continue
+		}
+		streamId := *frame.StreamId
+		payload := payloads[streamId]
+		start := NewStreamStart(frame.Id, streamId, mediaUrns[streamId])
+		chunk := NewChunk(frame.Id, streamId, 0, payload, 0, ComputeChecksum(payload))
+		end := NewStreamEnd(frame.Id, streamId, 1)
+		start.RoutingId, chunk.RoutingId, end.RoutingId = frame.RoutingId, frame.RoutingId, frame.RoutingId
+		output = append(output, *start, *chunk, *end)
+	}
+	return output, nil
+}
+
+// streamContent returns the bytes or text the stream streamId of a request's input
+// carried
+func streamContent(input []Frame, streamId string) ([]byte, error) {
+	frames := make(chan Frame, len(input))
+	for _, frame := range input {
+		if frame.FrameType == FrameTypeEnd || (frame.StreamId != nil && *frame.StreamId == streamId) {
+			frames <- frame
+		}
+	}
+	close(frames)
+	streams, err := CollectStreams(frames)
+	if err != nil {
+		return nil, err
+	}
+	if len(streams) != 1 {
+		return nil, fmt.Errorf("stream %s is incomplete", streamId)
+	}
+	return (&Arg{MediaUrn: streams[0].MediaUrn, Data: streams[0].Data}).Bytes()
+}
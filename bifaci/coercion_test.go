@@ -0,0 +1,129 @@
+package bifaci
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+)
+
+const testImportCap = `cap:in="media:void";op=import;out="media:void"`
+
+const testCoercionManifest = `{"name":"TestPlugin","version":"1.0.0","description":"Test plugin","caps":[` +
+	`{"urn":"cap:in=\"media:void\";op=import;out=\"media:void\"","title":"Import","command":"import",` +
+	`"args":[{"media_urn":"media:config;record","required":true,"sources":[{"cli_flag":"--config"}]},` +
+	`{"media_urn":"media:image;bytes","required":false,"sources":[{"cli_flag":"--image"}]}]}]}`
+
+// importFrames returns the frames of a request with one argument stream per media URN
+// and value
+func importFrames(args ...interface{}) []Frame {
+	reqId := NewMessageIdRandom()
+	frames := []Frame{*NewReq(reqId, testImportCap, nil, "application/cbor")}
+	for i := 0; i < len(args); i += 2 {
+		streamId := "arg-" + string(rune('0'+i/2))
+		payload, _ := cborlib.Marshal(args[i+1])
+		frames = append(frames,
+			*NewStreamStart(reqId, streamId, args[i].(string)),
+			*NewChunk(reqId, streamId, 0, payload, 0, ComputeChecksum(payload)),
+			*NewStreamEnd(reqId, streamId, 1))
+	}
+	return append(frames, *NewEnd(reqId, nil))
+}
+
+// coercedStreams runs input through the runtime's coercion for the import cap
+func coercedStreams(t *testing.T, runtime *PluginRuntime, input []Frame) ([]struct {
+	MediaUrn string
+	Data     []byte
+}, error) {
+	t.Helper()
+	var streams []struct {
+		MediaUrn string
+		Data     []byte
+	}
+	runtime.Register(testImportCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		var err error
+		streams, err = CollectStreams(frames)
+		return err
+	})
+	_, handler, err := runtime.findHandler(testImportCap)
+	if err != nil {
+		t.Fatalf("findHandler failed: %v", err)
+	}
+	frames := make(chan Frame, len(input))
+	for _, frame := range input {
+		frames <- frame
+	}
+	close(frames)
+	err = handler(frames, &mockStreamEmitter{}, &noPeerInvoker{})
+	return streams, err
+}
+
+func newCoercionRuntime(t *testing.T) *PluginRuntime {
+	t.Helper()
+	runtime, err := NewPluginRuntime([]byte(testCoercionManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.SetCoercionRegistry(NewCoercionRegistry())
+	return runtime
+}
+
+func TestCoerceJsonToRecord(t *testing.T) {
+	runtime := newCoercionRuntime(t)
+	streams, err := coercedStreams(t, runtime, importFrames("media:json;textable", `{"depth":3}`))
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+	if len(streams) != 1 || streams[0].MediaUrn != "media:config;record" {
+		t.Fatalf("Expected the argument as media:config;record, got %+v", streams)
+	}
+	var config map[string]interface{}
+	if err := cborlib.Unmarshal(streams[0].Data, &config); err != nil || config["depth"] != float64(3) {
+		t.Errorf("Expected the JSON parsed into a map, got %v (%v)", config, err)
+	}
+
+	// JSON that doesn't parse is an invalid argument
+	_, err = coercedStreams(t, runtime, importFrames("media:json;textable", `{"depth":`))
+	var coercionErr *CoercionError
+	if !errors.As(err, &coercionErr) || handlerErrorCode(err) != "INVALID_ARGUMENT" {
+		t.Errorf("Expected a CoercionError reported as INVALID_ARGUMENT, got %v", err)
+	}
+}
+
+func TestCoerceFilePath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "image.png")
+	if err := os.WriteFile(path, []byte("png data"), 0o644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	runtime := newCoercionRuntime(t)
+
+	// The config argument is supplied as is, so the path becomes the image
+	streams, err := coercedStreams(t, runtime, importFrames(
+		"media:config;record", map[string]interface{}{"depth": 1},
+		"media:file-path;textable", path))
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+	// The coerced stream carries the canonical form of the argument's media URN
+	if len(streams) != 2 || streams[0].MediaUrn != "media:config;record" || streams[1].MediaUrn != "media:bytes;image" {
+		t.Fatalf("Expected the config and image arguments, got %+v", streams)
+	}
+	var image []byte
+	if err := cborlib.Unmarshal(streams[1].Data, &image); err != nil || string(image) != "png data" {
+		t.Errorf("Expected the file contents, got %q (%v)", image, err)
+	}
+}
+
+func TestCoercionDisabledPerCap(t *testing.T) {
+	runtime := newCoercionRuntime(t)
+	runtime.SetCoercion(testImportCap, false)
+	streams, err := coercedStreams(t, runtime, importFrames("media:json;textable", `{"depth":3}`))
+	if err != nil {
+		t.Fatalf("Handler failed: %v", err)
+	}
+	if len(streams) != 1 || streams[0].MediaUrn != "media:json;textable" {
+		t.Errorf("Expected the argument passed on unchanged, got %+v", streams)
+	}
+}
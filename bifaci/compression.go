@@ -0,0 +1,195 @@
+package bifaci
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// CompressionAlgorithm names a compression of CHUNK payloads. Peers agree on one in the
+// handshake: the host offers those it decodes in its HELLO ("compressions"), and the
+// plugin names its pick in its own HELLO ("compression"). A sender then compresses the
+// streams it chooses, flagging each in its STREAM_START meta ("compression").
+//
+// Compression is transparent: the FrameWriter compresses the CHUNK payloads of flagged
+// streams as it writes them and the FrameReader decompresses them as it reads them, so
+// handlers only ever see plain payloads. Checksums cover the uncompressed payload.
+type CompressionAlgorithm string
+
+const (
+	CompressionGzip CompressionAlgorithm = "gzip" // Built in
+	CompressionZstd CompressionAlgorithm = "zstd" // Needs an implementation, see RegisterCompressor
+)
+
+// Compressor compresses and decompresses single CHUNK payloads
+type Compressor interface {
+	Compress(data []byte) ([]byte, error)
+	// Decompress fails once the output would exceed limit bytes, so that a small
+	// payload can't inflate without bound
+	Decompress(data []byte, limit int) ([]byte, error)
+}
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[CompressionAlgorithm]Compressor{CompressionGzip: gzipCompressor{}}
+)
+
+// RegisterCompressor makes algorithm available for negotiation. gzip is built in; zstd
+// is offered once an implementation is registered, e.g. one wrapping
+// github.com/klauspost/compress/zstd, which this module doesn't depend on.
+func RegisterCompressor(algorithm CompressionAlgorithm, compressor Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	compressors[algorithm] = compressor
+}
+
+// lookupCompressor returns the registered implementation of algorithm, or nil
+func lookupCompressor(algorithm CompressionAlgorithm) Compressor {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	return compressors[algorithm]
+}
+
+// negotiateCompression returns the first offered algorithm with an implementation, or
+// "" for none
+func negotiateCompression(offered []CompressionAlgorithm) CompressionAlgorithm {
+	for _, algorithm := range offered {
+		if lookupCompressor(algorithm) != nil {
+			return algorithm
+		}
+	}
+	return ""
+}
+
+// compressionsFromMeta reads the algorithms a HELLO offers, in preference order
+func compressionsFromMeta(meta map[string]interface{}) []CompressionAlgorithm {
+	values, _ := meta["compressions"].([]interface{})
+	var offered []CompressionAlgorithm
+	for _, value := range values {
+		if name, ok := value.(string); ok {
+			offered = append(offered, CompressionAlgorithm(name))
+		}
+	}
+	return offered
+}
+
+// compressionOffered reports whether the plugin's pick is one of the host's offers
+func compressionOffered(algorithm CompressionAlgorithm, offered []CompressionAlgorithm) bool {
+	for _, o := range offered {
+		if o == algorithm && lookupCompressor(o) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// StreamCompression returns the compression a STREAM_START flags for its stream, or ""
+func (f *Frame) StreamCompression() CompressionAlgorithm {
+	if f.FrameType != FrameTypeStreamStart || f.Meta == nil {
+		return ""
+	}
+	algorithm, _ := f.Meta["compression"].(string)
+	return CompressionAlgorithm(algorithm)
+}
+
+// SetStreamCompression flags a STREAM_START's stream as compressed with algorithm
+func (f *Frame) SetStreamCompression(algorithm CompressionAlgorithm) {
+	if f.Meta == nil {
+		f.Meta = make(map[string]interface{})
+	}
+	f.Meta["compression"] = string(algorithm)
+}
+
+// withoutStreamCompression returns a copy of a STREAM_START without the compression flag
+func withoutStreamCompression(frame *Frame) *Frame {
+	stripped := *frame
+	stripped.Meta = make(map[string]interface{}, len(frame.Meta))
+	for k, v := range frame.Meta {
+		if k != "compression" {
+			stripped.Meta[k] = v
+		}
+	}
+	return &stripped
+}
+
+// streamCompressions tracks the compressed streams of a connection, from STREAM_START
+// to STREAM_END or the end of the request. Used by a single reader or writer goroutine.
+type streamCompressions map[FlowKey]map[string]Compressor
+
+// track records the stream a STREAM_START opens with compressor (nil: not compressed),
+// and forgets streams and requests as they end
+func (sc streamCompressions) track(frame *Frame, compressor Compressor) {
+	key := FlowKeyFromFrame(frame)
+	switch frame.FrameType {
+	case FrameTypeStreamStart:
+		if compressor != nil && frame.StreamId != nil {
+			if sc[key] == nil {
+				sc[key] = make(map[string]Compressor)
+			}
+			sc[key][*frame.StreamId] = compressor
+		}
+	case FrameTypeStreamEnd:
+		if streams := sc[key]; streams != nil && frame.StreamId != nil {
+			delete(streams, *frame.StreamId)
+			if len(streams) == 0 {
+				delete(sc, key)
+			}
+		}
+	case FrameTypeEnd, FrameTypeErr, FrameTypeCancel:
+		delete(sc, key)
+	}
+}
+
+// lookup returns the compressor of a CHUNK's stream, or nil
+func (sc streamCompressions) lookup(frame *Frame) Compressor {
+	if frame.FrameType != FrameTypeChunk || frame.StreamId == nil || len(sc) == 0 {
+		return nil
+	}
+	return sc[FlowKeyFromFrame(frame)][*frame.StreamId]
+}
+
+// decompressChunk returns a CHUNK with its payload decompressed. A payload that fails to
+// decompress is kept as is: its checksum, computed over the plain payload, then fails
+// verification and the request ends with CORRUPTED_DATA instead of the connection.
+func decompressChunk(frame *Frame, compressor Compressor, limit int) *Frame {
+	payload, err := compressor.Decompress(frame.Payload, limit)
+	if err != nil {
+		return frame
+	}
+	decompressed := *frame
+	decompressed.Payload = payload
+	return &decompressed
+}
+
+// gzipCompressor is the built-in gzip Compressor
+type gzipCompressor struct{}
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(data []byte, limit int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	out, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > limit {
+		return nil, fmt.Errorf("decompressed payload exceeds %d bytes", limit)
+	}
+	return out, nil
+}
@@ -0,0 +1,191 @@
+package bifaci
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+)
+
+// reverseCompressor is a registrable stand-in for an external codec such as zstd
+type reverseCompressor struct{}
+
+func (reverseCompressor) Compress(data []byte) ([]byte, error) {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[len(data)-1-i] = b
+	}
+	return out, nil
+}
+
+func (c reverseCompressor) Decompress(data []byte, limit int) ([]byte, error) {
+	return c.Compress(data)
+}
+
+func TestCompressionNegotiation(t *testing.T) {
+	// zstd has no implementation unless one is registered
+	host, plugin := handshakePipes(t, HandshakeOptions{Compressions: []CompressionAlgorithm{CompressionZstd, CompressionGzip}})
+	if host.Compression != CompressionGzip || plugin.Compression != CompressionGzip {
+		t.Errorf("Expected gzip on both sides, got host %q plugin %q", host.Compression, plugin.Compression)
+	}
+
+	RegisterCompressor("test-reverse", reverseCompressor{})
+	host, plugin = handshakePipes(t, HandshakeOptions{Compressions: []CompressionAlgorithm{"test-reverse", CompressionGzip}})
+	if host.Compression != "test-reverse" || plugin.Compression != "test-reverse" {
+		t.Errorf("Expected the registered compressor, got host %q plugin %q", host.Compression, plugin.Compression)
+	}
+
+	host, plugin = handshakePipes(t, HandshakeOptions{})
+	if host.Compression != "" || plugin.Compression != "" {
+		t.Errorf("Without an offer nothing is compressed, got host %q plugin %q", host.Compression, plugin.Compression)
+	}
+}
+
+// Flagged streams are compressed on the wire and arrive plain, with the flag removed and
+// the checksum verifying against the plain payload
+func TestCompressedStreamRoundTrip(t *testing.T) {
+	limits := DefaultLimits()
+	limits.Compression = CompressionGzip
+	id := NewMessageIdRandom()
+	payload, _ := cborlib.Marshal(strings.Repeat(`{"key":"value"},`, 1000))
+
+	var wire bytes.Buffer
+	writer := NewFrameWriter(&wire)
+	writer.SetLimits(limits)
+	start := NewStreamStart(id, "out", "media:json")
+	start.SetStreamCompression(CompressionGzip)
+	chunk := writer.NewChunk(id, "out", 0, payload, 0)
+	for _, frame := range []*Frame{start, chunk, NewStreamEnd(id, "out", 1), NewEnd(id, nil)} {
+		if err := writer.WriteFrame(frame); err != nil {
+			t.Fatalf("WriteFrame failed: %v", err)
+		}
+	}
+	if wire.Len() >= len(payload) {
+		t.Errorf("Expected compression, wrote %d bytes for a %d byte payload", wire.Len(), len(payload))
+	}
+	if !bytes.Equal(chunk.Payload, payload) {
+		t.Error("Compression must not modify the caller's frame")
+	}
+
+	reader := NewFrameReader(&wire)
+	reader.SetLimits(limits)
+	frames := make([]*Frame, 4)
+	for i := range frames {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatalf("ReadFrame failed: %v", err)
+		}
+		frames[i] = frame
+	}
+	if frames[0].StreamCompression() != "" {
+		t.Error("The reader must remove the compression flag")
+	}
+	if !bytes.Equal(frames[1].Payload, payload) {
+		t.Error("CHUNK payload was not decompressed")
+	}
+	if err := VerifyChunkChecksum(frames[1]); err != nil {
+		t.Errorf("Checksum of the decompressed payload: %v", err)
+	}
+	if len(reader.compressed) != 0 {
+		t.Error("The reader must forget the stream once the request ends")
+	}
+}
+
+func TestUnnegotiatedCompressionRejected(t *testing.T) {
+	id := NewMessageIdRandom()
+	start := NewStreamStart(id, "out", "media:json")
+	start.SetStreamCompression(CompressionGzip)
+
+	// A writer without the negotiation sends the stream plain
+	var wire bytes.Buffer
+	if err := NewFrameWriter(&wire).WriteFrame(start); err != nil {
+		t.Fatalf("WriteFrame failed: %v", err)
+	}
+	frame, err := NewFrameReader(&wire).ReadFrame()
+	if err != nil || frame.StreamCompression() != "" {
+		t.Errorf("Expected an unflagged STREAM_START, got %v (%v)", frame, err)
+	}
+
+	// A reader without the negotiation refuses a flagged stream
+	encoded, _ := EncodeFrame(start)
+	wire.Reset()
+	wire.Write([]byte{0, 0, 0, byte(len(encoded))})
+	wire.Write(encoded)
+	if _, err := NewFrameReader(&wire).ReadFrame(); err == nil {
+		t.Error("Expected a flagged stream to be refused without negotiation")
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+// A runtime on a connection that negotiated gzip compresses its response streams
+func TestRuntimeCompressesResponses(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	output := strings.Repeat("all work and no play ", 5000)
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		return emitter.EmitCbor(output)
+	})
+
+	hostToPluginR, hostToPluginW := io.Pipe()
+	pluginToHostR, pluginToHostW := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := runtime.serveCBOR(hostToPluginR, pluginToHostW)
+		pluginToHostW.Close()
+		done <- err
+	}()
+	counted := &countingReader{r: pluginToHostR}
+	reader := NewFrameReader(counted)
+	writer := NewFrameWriter(hostToPluginW)
+	_, limits, err := HandshakeInitiateWithOptions(reader, writer, HandshakeOptions{Compressions: []CompressionAlgorithm{CompressionGzip}})
+	if err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+	reader.SetLimits(limits)
+	writer.SetLimits(limits)
+	afterHandshake := atomic.LoadInt64(&counted.n)
+
+	reqId := NewMessageIdRandom()
+	writeTestRequest(t, writer, reqId, testCancelCap, []byte{0x40})
+	frames := readUntilTerminal(t, reader, reqId)
+	var text string
+	for _, frame := range frames {
+		if frame.FrameType == FrameTypeChunk {
+			if err := VerifyChunkChecksum(frame); err != nil {
+				t.Errorf("Checksum: %v", err)
+			}
+			var value string
+			if err := cborlib.Unmarshal(frame.Payload, &value); err != nil {
+				t.Fatalf("CHUNK is not a CBOR text: %v", err)
+			}
+			text += value
+		}
+	}
+	if text != output {
+		t.Errorf("Expected the full output, got %d bytes", len(text))
+	}
+	if onWire := atomic.LoadInt64(&counted.n) - afterHandshake; onWire >= int64(len(output)) {
+		t.Errorf("Expected a compressed response, read %d bytes for %d bytes of output", onWire, len(output))
+	}
+
+	hostToPluginW.Close()
+	go io.Copy(io.Discard, pluginToHostR)
+	<-done
+}
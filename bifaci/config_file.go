@@ -0,0 +1,156 @@
+package bifaci
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// configFile is a plugin's parsed config file, which CLI mode reads arguments with a
+// config source from (see SetConfigFile)
+type configFile struct {
+	path   string
+	values map[string]interface{}
+}
+
+// SetConfigFile sets the config file CLI mode reads arguments with a config source
+// from, unless --config names another. The file is JSON if its name ends in ".json",
+// else TOML. Each config source names a dotted key, e.g. "openai.model" is the key
+// "model" of table [openai]. By default the file is config.toml, or else config.json,
+// in the directory named after the plugin in the user's config directory, e.g.
+// ~/.config/<plugin>/config.toml; it is not an error if none exists.
+//
+// An argument is taken from the first of its command-line, stdin and env sources that
+// supplies it, in the order the cap declares them, then from the config file, then its
+// default value. Relative file paths in the config file are relative to its directory.
+func (pr *PluginRuntime) SetConfigFile(path string) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.configPath = path
+}
+
+// loadConfigFile loads the config file for a CLI invocation: the one --config named,
+// if any, which must exist, else the one set with SetConfigFile or the default one
+func (pr *PluginRuntime) loadConfigFile(flagPath string) error {
+	pr.mu.RLock()
+	paths := []string{pr.configPath}
+	pr.mu.RUnlock()
+	required := true
+	if flagPath != "" {
+		paths = []string{flagPath}
+	} else if paths[0] == "" {
+		required = false
+		paths = pr.defaultConfigPaths()
+	}
+
+	var config *configFile
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) && !required {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read config file: %w", err)
+		}
+		values, err := parseConfigFile(path, data)
+		if err != nil {
+			return fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+		config = &configFile{path: path, values: values}
+		break
+	}
+
+	pr.mu.Lock()
+	pr.configFile = config
+	pr.mu.Unlock()
+	return nil
+}
+
+// defaultConfigPaths returns the config files looked for without SetConfigFile or
+// --config, in order
+func (pr *PluginRuntime) defaultConfigPaths() []string {
+	manifest := pr.Manifest()
+	dir, err := os.UserConfigDir()
+	if err != nil || manifest == nil || manifest.Name == "" {
+		return nil
+	}
+	dir = filepath.Join(dir, manifest.Name)
+	return []string{filepath.Join(dir, "config.toml"), filepath.Join(dir, "config.json")}
+}
+
+// parseConfigFile parses a config file as JSON or TOML by its name
+func parseConfigFile(path string, data []byte) (map[string]interface{}, error) {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		var values map[string]interface{}
+		if err := json.Unmarshal(data, &values); err != nil {
+			return nil, err
+		}
+		return values, nil
+	}
+	return parseTOML(string(data))
+}
+
+// lookup returns the value at a dotted key
+func (c *configFile) lookup(key string) (interface{}, bool) {
+	var value interface{} = c.values
+	for _, part := range strings.Split(key, ".") {
+		table, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = table[part]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// text renders a config value as the CLI would take it: a string as-is, anything
+// else as JSON. Relative file paths are made relative to the config file's directory.
+func (c *configFile) text(value interface{}, isFilePath bool) (string, error) {
+	if isFilePath {
+		value = c.resolvePaths(value)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("config value is not representable as JSON: %w", err)
+	}
+	return string(data), nil
+}
+
+// resolvePaths joins the relative paths in a path or list of paths to the config
+// file's directory. Exclusion patterns (see SetFileExpansion) keep their "!".
+func (c *configFile) resolvePaths(value interface{}) interface{} {
+	resolve := func(path string) string {
+		exclude := strings.HasPrefix(path, "!")
+		path = strings.TrimPrefix(path, "!")
+		if !filepath.IsAbs(path) && !(exclude && !strings.ContainsRune(path, filepath.Separator)) {
+			path = filepath.Join(filepath.Dir(c.path), path)
+		}
+		if exclude {
+			return "!" + path
+		}
+		return path
+	}
+	switch v := value.(type) {
+	case string:
+		return resolve(v)
+	case []interface{}:
+		paths := make([]interface{}, len(v))
+		for i, item := range v {
+			if s, ok := item.(string); ok {
+				paths[i] = resolve(s)
+			} else {
+				paths[i] = item
+			}
+		}
+		return paths
+	}
+	return value
+}
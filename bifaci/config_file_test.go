@@ -0,0 +1,109 @@
+package bifaci
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/machinefabric/capdag-go/cap"
+)
+
+// newConfigArgRuntime returns a runtime whose cap takes a model from --model, the MODEL
+// environment variable or the config key openai.model, defaulting to "small"
+func newConfigArgRuntime(t *testing.T) (*PluginRuntime, *cap.Cap) {
+	t.Helper()
+	envVar, configKey := "MODEL", "openai.model"
+	capDef := createTestCap(testEnvCap, "Generate", "generate", []cap.CapArg{{
+		MediaUrn:     "media:model-name;textable",
+		Sources:      []cap.ArgSource{cliFlagSource("--model"), {Env: &envVar}, {Config: &configKey}},
+		DefaultValue: "small",
+	}})
+	manifest := createTestManifest("TestPlugin", "1.0.0", "Test", []*cap.Cap{capDef})
+	runtime, err := NewPluginRuntimeWithManifest(manifest)
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	return runtime, &manifest.Caps[0]
+}
+
+// writeConfig writes a config file named name into a temporary directory
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestConfigArgPrecedence(t *testing.T) {
+	runtime, capDef := newConfigArgRuntime(t)
+	model := func(cliArgs ...string) string {
+		t.Helper()
+		value, err := runtime.extractArgValue(&capDef.Args[0], cliArgs, nil)
+		if err != nil {
+			t.Fatalf("extractArgValue failed: %v", err)
+		}
+		return string(value)
+	}
+
+	t.Setenv("MODEL", "")
+	os.Unsetenv("MODEL")
+	if got := model(); got != `"small"` {
+		t.Errorf("Expected the default without a config file, got %s", got)
+	}
+
+	runtime.SetConfigFile(writeConfig(t, "config.toml", "[openai]\nmodel = \"medium\"\n"))
+	if err := runtime.loadConfigFile(""); err != nil {
+		t.Fatalf("loadConfigFile failed: %v", err)
+	}
+	if got := model(); got != "medium" {
+		t.Errorf("Expected the config file over the default, got %s", got)
+	}
+
+	t.Setenv("MODEL", "large")
+	if got := model(); got != "large" {
+		t.Errorf("Expected the environment over the config file, got %s", got)
+	}
+	if got := model("--model", "huge"); got != "huge" {
+		t.Errorf("Expected the flag over the environment, got %s", got)
+	}
+}
+
+// --config names a config file of another format in place of the default one
+func TestConfigFlag(t *testing.T) {
+	runtime, capDef := newConfigArgRuntime(t)
+	t.Setenv("MODEL", "")
+	os.Unsetenv("MODEL")
+	runtime.SetConfigFile(writeConfig(t, "config.toml", "[openai]\nmodel = \"medium\"\n"))
+
+	options, rest, err := parseCLIOutputFlags(capDef, []string{"--config", writeConfig(t, "custom.json", `{"openai": {"model": "tiny"}}`)})
+	if err != nil || len(rest) != 0 {
+		t.Fatalf("parseCLIOutputFlags failed: %v %v", rest, err)
+	}
+	if err := runtime.loadConfigFile(options.config); err != nil {
+		t.Fatalf("loadConfigFile failed: %v", err)
+	}
+	value, err := runtime.extractArgValue(&capDef.Args[0], nil, nil)
+	if err != nil || string(value) != "tiny" {
+		t.Errorf("Expected the model from the --config file, got %s (%v)", value, err)
+	}
+
+	if err := runtime.loadConfigFile(filepath.Join(t.TempDir(), "missing.toml")); err == nil {
+		t.Error("Expected an error for a missing --config file")
+	}
+}
+
+// A relative file path in the config file is relative to its directory
+func TestConfigFilePathRelative(t *testing.T) {
+	config := &configFile{path: filepath.Join("/etc", "plugin", "config.toml")}
+	if got, _ := config.text("prompts/system.txt", true); got != filepath.Join("/etc", "plugin", "prompts", "system.txt") {
+		t.Errorf("Expected the path under the config directory, got %s", got)
+	}
+	if got, _ := config.text([]interface{}{"docs", "!*.tmp"}, true); got != `["/etc/plugin/docs","!*.tmp"]` {
+		t.Errorf("Expected the patterns under the config directory, got %s", got)
+	}
+	if got, _ := config.text("prompts/system.txt", false); got != "prompts/system.txt" {
+		t.Errorf("Expected a value other than a path unchanged, got %s", got)
+	}
+}
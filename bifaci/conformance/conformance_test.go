@@ -0,0 +1,73 @@
+package conformance
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"testing"
+
+	"github.com/machinefabric/capdag-go/bifaci"
+)
+
+var update = flag.Bool("update", false, "rewrite testdata/vectors.json")
+
+func TestDefaultCodecConforms(t *testing.T) {
+	Verify(t, DefaultCodec())
+}
+
+// The golden bytes also satisfy the strict decoder
+func TestGoldenFramesDecodeStrictly(t *testing.T) {
+	Verify(t, Codec{Encode: bifaci.EncodeFrame, Decode: bifaci.DecodeFrameStrict})
+}
+
+// A codec that drifts from the golden encoding is reported
+func TestCheckVectorDetectsDrift(t *testing.T) {
+	drifting := Codec{
+		Encode: func(frame *bifaci.Frame) ([]byte, error) {
+			encoded, err := bifaci.EncodeFrame(frame)
+			return append(encoded, 0), err
+		},
+		Decode: bifaci.DecodeFrame,
+	}
+	if err := CheckVector(Vectors()[0], drifting); err == nil {
+		t.Error("Expected a mismatch for a drifting encoder")
+	}
+}
+
+func TestSequenceWireReadsBack(t *testing.T) {
+	for _, seq := range Sequences() {
+		reader := bifaci.NewFrameReader(bytes.NewReader(seq.Wire(HostToPlugin)))
+		for _, step := range seq.Steps {
+			if step.Direction != HostToPlugin {
+				continue
+			}
+			frame, err := reader.ReadFrame()
+			if err != nil {
+				t.Fatalf("%s: ReadFrame failed: %v", seq.Name, err)
+			}
+			if frame.FrameType != step.Vector.Frame.FrameType {
+				t.Errorf("%s: got %v, want %v", seq.Name, frame.FrameType, step.Vector.Frame.FrameType)
+			}
+		}
+	}
+}
+
+// testdata/vectors.json is the copy the other SDKs vendor; regenerate it with -update
+func TestJSONMatchesGoldenFile(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	if *update {
+		if err := os.WriteFile("testdata/vectors.json", buf.Bytes(), 0o644); err != nil {
+			t.Fatalf("Failed to write golden file: %v", err)
+		}
+	}
+	golden, err := os.ReadFile("testdata/vectors.json")
+	if err != nil {
+		t.Fatalf("Failed to read golden file: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), golden) {
+		t.Error("testdata/vectors.json is out of date; run the test with -update")
+	}
+}
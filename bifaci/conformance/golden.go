@@ -0,0 +1,24 @@
+package conformance
+
+// Golden encodings of the vectors, as hex. They are the wire format: change one only
+// together with the Rust and Python SDKs.
+const (
+	goldenHello         = "a400020100020005a46776657273696f6e02696d61785f6368756e6b1a00010000696d61785f6672616d651a00100000726d61785f72656f726465725f6275666665721840"
+	goldenHelloManifest = "a400020100020005a56776657273696f6e02686d616e6966657374582d7b226e616d65223a22476f6c64656e222c2276657273696f6e223a22312e302e30222c2263617073223a5b5d7d696d61785f6368756e6b1a00010000696d61785f6672616d651a00100000726d61785f72656f726465725f6275666665721840"
+	goldenReq           = "a50002010102500123456789abcdef0123456789abcdef04706170706c69636174696f6e2f63626f720a782c6361703a696e3d226d656469613a74657874223b6f703d6563686f3b6f75743d226d656469613a7465787422"
+	goldenReqUintId     = "a50002010102182a04706170706c69636174696f6e2f63626f720a782c6361703a696e3d226d656469613a74657874223b6f703d6563686f3b6f75743d226d656469613a7465787422"
+	goldenReqRouted     = "a60002010102500123456789abcdef0123456789abcdef04706170706c69636174696f6e2f63626f720a782c6361703a696e3d226d656469613a74657874223b6f703d6563686f3b6f75743d226d656469613a74657874220d50fedcba9876543210fedcba9876543210"
+	goldenStreamStart   = "a50002010802500123456789abcdef0123456789abcdef0b656172672d300c6a6d656469613a74657874"
+	goldenChunk         = "a70002010302500123456789abcdef0123456789abcdef06436268690b656172672d300e00101b00573f191352565a"
+	goldenChunkSeq      = "a80002010302500123456789abcdef0123456789abcdef030106436268690b656172672d300e01101b00573f191352565a"
+	goldenStreamEnd     = "a50002010902500123456789abcdef0123456789abcdef0b656172672d300f02"
+	goldenEnd           = "a40002010402500123456789abcdef0123456789abcdef09f5"
+	goldenEndPayload    = "a50002010402500123456789abcdef0123456789abcdef064362686909f5"
+	goldenLog           = "a40002010502500123456789abcdef0123456789abcdef05a2656c6576656c64696e666f676d65737361676567776f726b696e67"
+	goldenErr           = "a40002010602500123456789abcdef0123456789abcdef05a264636f64656d48414e444c45525f4552524f52676d657373616765696974206661696c6564"
+	goldenHeartbeat     = "a30002010702500123456789abcdef0123456789abcdef"
+	goldenRelayNotify   = "a40002010a020005a4686d616e6966657374582d7b226e616d65223a22476f6c64656e222c2276657273696f6e223a22312e302e30222c2263617073223a5b5d7d696d61785f6368756e6b1a00010000696d61785f6672616d651a00100000726d61785f72656f726465725f6275666665721840"
+	goldenRelayState    = "a40002010b020006497b22637075223a347d"
+	goldenCancel        = "a30002010c02500123456789abcdef0123456789abcdef"
+	goldenAck           = "a40002010d02500123456789abcdef0123456789abcdef05a16663726564697408"
+)
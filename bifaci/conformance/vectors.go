@@ -0,0 +1,252 @@
+// Package conformance holds the golden CBOR encodings of the Bifaci frame protocol:
+// one vector per frame type and the frame sequences of a handshake and a request. An
+// implementation is wire-compatible when it encodes each vector's frame to exactly the
+// golden bytes and decodes the golden bytes back to a frame that re-encodes identically.
+//
+// The Go, Rust and Python SDKs check themselves against the same vectors. The SDKs that
+// can't import this package vendor testdata/vectors.json, written by WriteJSON.
+package conformance
+
+import (
+	"encoding/hex"
+
+	"github.com/machinefabric/capdag-go/bifaci"
+)
+
+// Vector is one frame and its canonical encoding
+type Vector struct {
+	Name        string
+	Description string
+	Frame       *bifaci.Frame
+	Golden      []byte // CBOR encoding, without the length prefix
+}
+
+// Direction is the sender of a frame in a Sequence
+type Direction string
+
+const (
+	HostToPlugin Direction = "host_to_plugin"
+	PluginToHost Direction = "plugin_to_host"
+)
+
+// Step is one frame of a Sequence
+type Step struct {
+	Direction Direction
+	Vector    Vector
+}
+
+// Sequence is a conversation between a host and a plugin, frame by frame
+type Sequence struct {
+	Name        string
+	Description string
+	Steps       []Step
+}
+
+// Fixed ids, so that the encodings are reproducible
+var (
+	requestId = mustUuid("0123456789abcdef0123456789abcdef")
+	routingId = mustUuid("fedcba9876543210fedcba9876543210")
+)
+
+// Golden limits and manifest used by the HELLO and RELAY_NOTIFY vectors
+const (
+	goldenMaxFrame         = 1_048_576
+	goldenMaxChunk         = 65_536
+	goldenMaxReorderBuffer = 64
+	goldenManifest         = `{"name":"Golden","version":"1.0.0","caps":[]}`
+	goldenCap              = `cap:in="media:text";op=echo;out="media:text"`
+)
+
+// goldenPayload is the CBOR text string "hi", the value every CHUNK vector carries
+var goldenPayload = []byte{0x62, 'h', 'i'}
+
+func mustUuid(s string) bifaci.MessageId {
+	id, err := bifaci.NewMessageIdFromUuid(mustHex(s))
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func chunk(index uint64) *bifaci.Frame {
+	return bifaci.NewChunk(requestId, "arg-0", index, goldenPayload, index, bifaci.ComputeChecksum(goldenPayload))
+}
+
+func routed(frame *bifaci.Frame) *bifaci.Frame {
+	frame.RoutingId = &routingId
+	return frame
+}
+
+// Vectors returns one vector per frame type, plus variants of the fields whose encoding
+// has more than one form (uint ids, routing ids, optional payloads)
+func Vectors() []Vector {
+	return []Vector{
+		{
+			Name:        "hello",
+			Description: "Host HELLO carrying its limits",
+			Frame:       bifaci.NewHello(goldenMaxFrame, goldenMaxChunk, goldenMaxReorderBuffer),
+			Golden:      mustHex(goldenHello),
+		},
+		{
+			Name:        "hello_manifest",
+			Description: "Plugin HELLO carrying its limits and manifest",
+			Frame:       bifaci.NewHelloWithManifest(goldenMaxFrame, goldenMaxChunk, goldenMaxReorderBuffer, []byte(goldenManifest)),
+			Golden:      mustHex(goldenHelloManifest),
+		},
+		{
+			Name:        "req",
+			Description: "REQ opening a streamed request",
+			Frame:       bifaci.NewReq(requestId, goldenCap, nil, "application/cbor"),
+			Golden:      mustHex(goldenReq),
+		},
+		{
+			Name:        "req_uint_id",
+			Description: "REQ with a uint message id",
+			Frame:       bifaci.NewReq(bifaci.NewMessageIdFromUint(42), goldenCap, nil, "application/cbor"),
+			Golden:      mustHex(goldenReqUintId),
+		},
+		{
+			Name:        "req_routed",
+			Description: "REQ carrying a relay routing id",
+			Frame:       routed(bifaci.NewReq(requestId, goldenCap, nil, "application/cbor")),
+			Golden:      mustHex(goldenReqRouted),
+		},
+		{
+			Name:        "stream_start",
+			Description: "STREAM_START of an argument stream",
+			Frame:       bifaci.NewStreamStart(requestId, "arg-0", "media:text"),
+			Golden:      mustHex(goldenStreamStart),
+		},
+		{
+			Name:        "chunk",
+			Description: "First CHUNK of a stream, holding the CBOR text \"hi\"",
+			Frame:       chunk(0),
+			Golden:      mustHex(goldenChunk),
+		},
+		{
+			Name:        "chunk_seq",
+			Description: "Second CHUNK of a stream, with a non-zero seq",
+			Frame:       chunk(1),
+			Golden:      mustHex(goldenChunkSeq),
+		},
+		{
+			Name:        "stream_end",
+			Description: "STREAM_END after two chunks",
+			Frame:       bifaci.NewStreamEnd(requestId, "arg-0", 2),
+			Golden:      mustHex(goldenStreamEnd),
+		},
+		{
+			Name:        "end",
+			Description: "END without a payload",
+			Frame:       bifaci.NewEnd(requestId, nil),
+			Golden:      mustHex(goldenEnd),
+		},
+		{
+			Name:        "end_payload",
+			Description: "END with a final payload",
+			Frame:       bifaci.NewEnd(requestId, goldenPayload),
+			Golden:      mustHex(goldenEndPayload),
+		},
+		{
+			Name:        "log",
+			Description: "LOG with a level and message",
+			Frame:       bifaci.NewLog(requestId, "info", "working"),
+			Golden:      mustHex(goldenLog),
+		},
+		{
+			Name:        "err",
+			Description: "ERR with a code and message",
+			Frame:       bifaci.NewErr(requestId, "HANDLER_ERROR", "it failed"),
+			Golden:      mustHex(goldenErr),
+		},
+		{
+			Name:        "heartbeat",
+			Description: "HEARTBEAT probe",
+			Frame:       bifaci.NewHeartbeat(requestId),
+			Golden:      mustHex(goldenHeartbeat),
+		},
+		{
+			Name:        "relay_notify",
+			Description: "RELAY_NOTIFY advertising a manifest and limits",
+			Frame:       bifaci.NewRelayNotify([]byte(goldenManifest), goldenMaxFrame, goldenMaxChunk, goldenMaxReorderBuffer),
+			Golden:      mustHex(goldenRelayNotify),
+		},
+		{
+			Name:        "relay_state",
+			Description: "RELAY_STATE carrying host resources",
+			Frame:       bifaci.NewRelayState([]byte(`{"cpu":4}`)),
+			Golden:      mustHex(goldenRelayState),
+		},
+		{
+			Name:        "cancel",
+			Description: "CANCEL of a request",
+			Frame:       bifaci.NewCancel(requestId),
+			Golden:      mustHex(goldenCancel),
+		},
+		{
+			Name:        "ack",
+			Description: "ACK granting flow-control credit",
+			Frame:       bifaci.NewAck(requestId, 8),
+			Golden:      mustHex(goldenAck),
+		},
+	}
+}
+
+// Sequences returns the golden conversations: the handshake, a request answered with
+// a stream, and a request that fails
+func Sequences() []Sequence {
+	byName := make(map[string]Vector)
+	for _, v := range Vectors() {
+		byName[v.Name] = v
+	}
+	step := func(direction Direction, name string) Step {
+		return Step{Direction: direction, Vector: byName[name]}
+	}
+	return []Sequence{
+		{
+			Name:        "handshake",
+			Description: "The host sends its HELLO, the plugin answers with its HELLO and manifest",
+			Steps: []Step{
+				step(HostToPlugin, "hello"),
+				step(PluginToHost, "hello_manifest"),
+			},
+		},
+		{
+			Name:        "request",
+			Description: "A request with one argument stream, answered with one output stream",
+			Steps: []Step{
+				step(HostToPlugin, "req"),
+				step(HostToPlugin, "stream_start"),
+				step(HostToPlugin, "chunk"),
+				step(HostToPlugin, "chunk_seq"),
+				step(HostToPlugin, "stream_end"),
+				step(HostToPlugin, "end"),
+				step(PluginToHost, "log"),
+				step(PluginToHost, "stream_start"),
+				step(PluginToHost, "chunk"),
+				step(PluginToHost, "chunk_seq"),
+				step(PluginToHost, "stream_end"),
+				step(PluginToHost, "end"),
+			},
+		},
+		{
+			Name:        "failed_request",
+			Description: "A request without arguments; the plugin probes the host, then fails",
+			Steps: []Step{
+				step(HostToPlugin, "req"),
+				step(HostToPlugin, "end"),
+				step(PluginToHost, "heartbeat"),
+				step(HostToPlugin, "heartbeat"),
+				step(PluginToHost, "err"),
+			},
+		},
+	}
+}
@@ -0,0 +1,164 @@
+package conformance
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/machinefabric/capdag-go/bifaci"
+)
+
+// Codec is the frame encoder and decoder of the implementation under test
+type Codec struct {
+	Encode func(frame *bifaci.Frame) ([]byte, error)
+	Decode func(data []byte) (*bifaci.Frame, error)
+}
+
+// DefaultCodec is this SDK's codec
+func DefaultCodec() Codec {
+	return Codec{Encode: bifaci.EncodeFrame, Decode: bifaci.DecodeFrame}
+}
+
+// CheckVector verifies that codec encodes the vector's frame to the golden bytes, and
+// that decoding the golden bytes yields a frame that encodes back to them
+func CheckVector(v Vector, codec Codec) error {
+	encoded, err := codec.Encode(v.Frame)
+	if err != nil {
+		return fmt.Errorf("%s: encode failed: %w", v.Name, err)
+	}
+	if !bytes.Equal(encoded, v.Golden) {
+		return fmt.Errorf("%s: encoded\n  %x\nwant\n  %x", v.Name, encoded, v.Golden)
+	}
+
+	decoded, err := codec.Decode(v.Golden)
+	if err != nil {
+		return fmt.Errorf("%s: decode failed: %w", v.Name, err)
+	}
+	if decoded.FrameType != v.Frame.FrameType {
+		return fmt.Errorf("%s: decoded frame type %v, want %v", v.Name, decoded.FrameType, v.Frame.FrameType)
+	}
+	reencoded, err := codec.Encode(decoded)
+	if err != nil {
+		return fmt.Errorf("%s: re-encode failed: %w", v.Name, err)
+	}
+	if !bytes.Equal(reencoded, v.Golden) {
+		return fmt.Errorf("%s: decoded frame re-encoded\n  %x\nwant\n  %x", v.Name, reencoded, v.Golden)
+	}
+	return nil
+}
+
+// CheckSequence verifies every frame of seq with CheckVector, then decodes the
+// sequence's wire bytes (see Wire) back into its frames, in order
+func CheckSequence(seq Sequence, codec Codec) error {
+	for i, step := range seq.Steps {
+		if err := CheckVector(step.Vector, codec); err != nil {
+			return fmt.Errorf("%s step %d: %w", seq.Name, i, err)
+		}
+	}
+
+	for _, direction := range []Direction{HostToPlugin, PluginToHost} {
+		wire := seq.Wire(direction)
+		var want []Step
+		for _, step := range seq.Steps {
+			if step.Direction == direction {
+				want = append(want, step)
+			}
+		}
+		for i := 0; len(wire) > 0; i++ {
+			if len(wire) < 4 {
+				return fmt.Errorf("%s %s: truncated length prefix", seq.Name, direction)
+			}
+			length := binary.BigEndian.Uint32(wire)
+			if uint64(len(wire)-4) < uint64(length) {
+				return fmt.Errorf("%s %s frame %d: truncated frame", seq.Name, direction, i)
+			}
+			frame, err := codec.Decode(wire[4 : 4+length])
+			if err != nil {
+				return fmt.Errorf("%s %s frame %d: decode failed: %w", seq.Name, direction, i, err)
+			}
+			if i >= len(want) || frame.FrameType != want[i].Vector.Frame.FrameType {
+				return fmt.Errorf("%s %s frame %d: unexpected %v", seq.Name, direction, i, frame.FrameType)
+			}
+			wire = wire[4+length:]
+		}
+	}
+	return nil
+}
+
+// Wire returns the frames one side sends in seq as they appear on the stream: each
+// golden encoding behind its 4-byte big-endian length
+func (s Sequence) Wire(direction Direction) []byte {
+	var wire []byte
+	for _, step := range s.Steps {
+		if step.Direction != direction {
+			continue
+		}
+		wire = binary.BigEndian.AppendUint32(wire, uint32(len(step.Vector.Golden)))
+		wire = append(wire, step.Vector.Golden...)
+	}
+	return wire
+}
+
+// Verify runs every vector and sequence against codec, failing t for each mismatch
+func Verify(t testing.TB, codec Codec) {
+	t.Helper()
+	for _, v := range Vectors() {
+		if err := CheckVector(v, codec); err != nil {
+			t.Errorf("conformance: %v", err)
+		}
+	}
+	for _, seq := range Sequences() {
+		if err := CheckSequence(seq, codec); err != nil {
+			t.Errorf("conformance: %v", err)
+		}
+	}
+}
+
+type jsonVector struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	FrameType   string `json:"frame_type"`
+	Hex         string `json:"hex"`
+}
+
+type jsonStep struct {
+	Direction Direction `json:"direction"`
+	Vector    string    `json:"vector"`
+}
+
+type jsonSequence struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Steps       []jsonStep `json:"steps"`
+}
+
+// WriteJSON writes the vectors and sequences as JSON, with the golden encodings in hex
+// and sequence steps referring to vectors by name
+func WriteJSON(w io.Writer) error {
+	var doc struct {
+		Vectors   []jsonVector   `json:"vectors"`
+		Sequences []jsonSequence `json:"sequences"`
+	}
+	for _, v := range Vectors() {
+		doc.Vectors = append(doc.Vectors, jsonVector{
+			Name:        v.Name,
+			Description: v.Description,
+			FrameType:   v.Frame.FrameType.String(),
+			Hex:         hex.EncodeToString(v.Golden),
+		})
+	}
+	for _, seq := range Sequences() {
+		js := jsonSequence{Name: seq.Name, Description: seq.Description}
+		for _, step := range seq.Steps {
+			js.Steps = append(js.Steps, jsonStep{Direction: step.Direction, Vector: step.Vector.Name})
+		}
+		doc.Sequences = append(doc.Sequences, js)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(doc)
+}
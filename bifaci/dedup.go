@@ -0,0 +1,97 @@
+package bifaci
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+// DefaultDedupWindow is a suggested number of completed requests to remember to
+// recognize a retried REQ (see SetRequestDedup)
+const DefaultDedupWindow = 1024
+
+// requestOutcome is how a completed request ended
+type requestOutcome struct {
+	idKey   string
+	code    string // ERR code, "" if the request ended with END
+	message string
+}
+
+// requestDedup remembers the outcomes of the most recently completed requests
+type requestDedup struct {
+	mu       sync.Mutex
+	size     int
+	order    *list.List // *requestOutcome, oldest first
+	outcomes map[string]*list.Element
+}
+
+// newRequestDedup returns a dedup window of size requests, nil if size is 0
+func newRequestDedup(size int) *requestDedup {
+	if size <= 0 {
+		return nil
+	}
+	return &requestDedup{size: size, order: list.New(), outcomes: make(map[string]*list.Element)}
+}
+
+// complete remembers how a request ended, forgetting the oldest request past the window
+func (d *requestDedup) complete(idKey, code, message string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if element, ok := d.outcomes[idKey]; ok {
+		d.order.Remove(element)
+	}
+	d.outcomes[idKey] = d.order.PushBack(&requestOutcome{idKey: idKey, code: code, message: message})
+	for d.order.Len() > d.size {
+		oldest := d.order.Front()
+		d.order.Remove(oldest)
+		delete(d.outcomes, oldest.Value.(*requestOutcome).idKey)
+	}
+}
+
+// outcome returns how the request with the ID ended, if it is in the window
+func (d *requestDedup) outcome(idKey string) (requestOutcome, bool) {
+	if d == nil {
+		return requestOutcome{}, false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	element, ok := d.outcomes[idKey]
+	if !ok {
+		return requestOutcome{}, false
+	}
+	return *element.Value.(*requestOutcome), true
+}
+
+// duplicateReply answers a REQ retrying a completed request: a failed request's ERR is
+// replayed, since it carries the whole outcome; a successful one is rejected with
+// DUPLICATE_REQUEST, as its output isn't kept.
+func (o requestOutcome) duplicateReply(id MessageId) *Frame {
+	if o.code != "" {
+		errFrame := NewErr(id, o.code, o.message)
+		errFrame.Meta["retryable"] = ErrCode(o.code).Retryable()
+		return errFrame
+	}
+	return NewErr(id, string(ErrCodeDuplicateRequest), fmt.Sprintf("request %s already completed", id.ToString()))
+}
+
+// SetRequestDedup sets how many completed requests the runtime remembers to recognize
+// a REQ the host retries with the same message ID, so a non-idempotent handler doesn't
+// run twice. It is off by default; 0 turns it off again.
+//
+// A retry of a request still running is answered with ERR DUPLICATE_REQUEST. A retry
+// of a request that failed gets the same ERR replayed. A retry of a request that
+// succeeded is not given the original result, which isn't kept: it gets ERR
+// DUPLICATE_REQUEST, and the host has to treat that as "already done".
+//
+// Requests are recognized by message ID alone, across all connections, so retries over
+// a new connection are caught too. Only turn it on if the host keeps message IDs unique
+// across connections; a host that numbers requests per connection would have new
+// requests rejected as duplicates after it reconnects.
+func (pr *PluginRuntime) SetRequestDedup(size int) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.dedup = newRequestDedup(size)
+}
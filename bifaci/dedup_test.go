@@ -0,0 +1,120 @@
+package bifaci
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRequestDedupWindow(t *testing.T) {
+	dedup := newRequestDedup(2)
+	dedup.complete("a", "", "")
+	dedup.complete("b", "BUSY", "overloaded")
+	dedup.complete("c", "", "")
+	if _, ok := dedup.outcome("a"); ok {
+		t.Error("Expected the oldest request to leave the window")
+	}
+	if outcome, ok := dedup.outcome("b"); !ok || outcome.code != "BUSY" || outcome.message != "overloaded" {
+		t.Errorf("Expected the failed outcome of b, got %+v %v", outcome, ok)
+	}
+	if newRequestDedup(0) != nil {
+		t.Error("Expected a zero window to disable deduplication")
+	}
+}
+
+// A REQ retried with the same message ID doesn't run its handler again. REQ and END
+// are written before the reply is read, as a pipelining host does.
+func TestRetriedRequestNotRerun(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.SetRequestDedup(DefaultDedupWindow)
+	var runs atomic.Int32
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		if runs.Add(1) > 1 {
+			return errors.New("charged twice")
+		}
+		return emitter.EmitCbor("charged")
+	})
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	defer stop()
+
+	reqId := NewMessageIdRandom()
+	for attempt := 0; attempt < 2; attempt++ {
+		writer.WriteFrame(NewReq(reqId, testCancelCap, nil, "application/cbor"))
+		writer.WriteFrame(NewEnd(reqId, nil))
+		frames := readUntilTerminal(t, reader, reqId)
+		last := frames[len(frames)-1]
+		if attempt == 0 && last.FrameType != FrameTypeEnd {
+			t.Fatalf("Expected the first attempt to succeed, got %v", last.FrameType)
+		}
+		if attempt == 1 && last.ErrorCode() != string(ErrCodeDuplicateRequest) {
+			t.Fatalf("Expected the retry to get DUPLICATE_REQUEST, got %v %s", last.FrameType, last.ErrorCode())
+		}
+	}
+	if n := runs.Load(); n != 1 {
+		t.Errorf("Expected the handler to run once, ran %d times", n)
+	}
+}
+
+// The retry of a failed request gets its ERR replayed
+func TestRetriedFailureReplayed(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.SetRequestDedup(DefaultDedupWindow)
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		return NewCapError(ErrCodeBusy, "overloaded")
+	})
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	defer stop()
+
+	reqId := NewMessageIdRandom()
+	var errs []*CapError
+	for attempt := 0; attempt < 2; attempt++ {
+		writer.WriteFrame(NewReq(reqId, testCancelCap, nil, "application/cbor"))
+		writer.WriteFrame(NewEnd(reqId, nil))
+		frames := readUntilTerminal(t, reader, reqId)
+		errs = append(errs, ErrorFromFrame(frames[len(frames)-1]))
+	}
+	if errs[1] == nil || errs[1].Code != ErrCodeBusy || errs[1].Message != "overloaded" || !errs[1].Retryable {
+		t.Errorf("Expected the BUSY ERR replayed, got %+v", errs[1])
+	}
+}
+
+// Deduplication is off by default, so a host that numbers requests per connection can
+// reuse a message ID after reconnecting
+func TestReusedIdRerunByDefault(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	var runs atomic.Int32
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		runs.Add(1)
+		return emitter.EmitCbor("done")
+	})
+
+	reqId := NewMessageIdRandom()
+	for conn := 0; conn < 2; conn++ {
+		reader, writer, stop := startCBORRuntime(t, runtime)
+		writer.WriteFrame(NewReq(reqId, testCancelCap, nil, "application/cbor"))
+		writer.WriteFrame(NewEnd(reqId, nil))
+		frames := readUntilTerminal(t, reader, reqId)
+		stop()
+		if last := frames[len(frames)-1]; last.FrameType != FrameTypeEnd {
+			t.Fatalf("Expected the request on connection %d to succeed, got %v %s", conn, last.FrameType, last.ErrorCode())
+		}
+	}
+	if n := runs.Load(); n != 2 {
+		t.Errorf("Expected the handler to run twice, ran %d times", n)
+	}
+}
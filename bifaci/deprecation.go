@@ -0,0 +1,24 @@
+package bifaci
+
+// withDeprecationWarning makes the handler registered under pattern warn each caller,
+// with a LOG frame at level warn, if the manifest marks the cap deprecated. The LOG
+// carries the cap, its replacement and the version it was introduced in as attributes,
+// so hosts can find callers still to migrate. Caller holds pr.mu.
+func (pr *PluginRuntime) withDeprecationWarning(pattern string, handler HandlerFunc) HandlerFunc {
+	capDef := pr.manifestCap(pattern)
+	if capDef == nil || !capDef.Deprecated {
+		return handler
+	}
+	notice := capDef.DeprecationNotice()
+	attrs := []any{"deprecated_cap", capDef.UrnString()}
+	if capDef.ReplacedBy != "" {
+		attrs = append(attrs, "replaced_by", capDef.ReplacedBy)
+	}
+	if capDef.Since != "" {
+		attrs = append(attrs, "since", capDef.Since)
+	}
+	return func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		EmitLogAttrs(emitter, "warn", notice, attrs...)
+		return handler(frames, emitter, peer)
+	}
+}
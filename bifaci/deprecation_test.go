@@ -0,0 +1,56 @@
+package bifaci
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const testDeprecatedManifest = `{"name":"TestPlugin","version":"2.0.0","description":"Test plugin","caps":[` +
+	`{"urn":"cap:in=\"media:void\";op=test;out=\"media:void\"","title":"Test","command":"test",` +
+	`"deprecated":true,"replaced_by":"cap:in=\"media:void\";op=test2;out=\"media:void\"","since":"1.0.0"}]}`
+
+// Invoking a deprecated cap sends the caller a structured LOG warning before the response
+func TestDeprecatedCapWarns(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testDeprecatedManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		return emitter.EmitCbor("still served")
+	})
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	defer stop()
+
+	reqId := NewMessageIdRandom()
+	writer.WriteFrame(NewReq(reqId, testCancelCap, nil, "application/cbor"))
+	writer.WriteFrame(NewEnd(reqId, nil))
+	frames := readUntilTerminal(t, reader, reqId)
+	if frames[len(frames)-1].FrameType != FrameTypeEnd {
+		t.Fatalf("Expected a deprecated cap to still be served, got %v", frames[len(frames)-1].FrameType)
+	}
+	warning := frames[0]
+	if warning.FrameType != FrameTypeLog || warning.LogLevel() != "warn" || !strings.Contains(warning.LogMessage(), "deprecated") {
+		t.Fatalf("Expected a LOG warning first, got %v %s", warning.FrameType, warning.LogMessage())
+	}
+	attrs := warning.LogAttrs()
+	if attrs["replaced_by"] != `cap:in="media:void";op=test2;out="media:void"` || attrs["since"] != "1.0.0" {
+		t.Errorf("Expected replacement and version attributes, got %v", attrs)
+	}
+}
+
+func TestCapHelpMarksDeprecation(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testDeprecatedManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	var help bytes.Buffer
+	writeCapHelp(&help, "my-plugin", &runtime.manifest.Caps[0])
+	for _, want := range []string{"DEPRECATED:", "use cap:in=\"media:void\";op=test2;out=\"media:void\" instead", "Since: 1.0.0"} {
+		if !strings.Contains(help.String(), want) {
+			t.Errorf("Help is missing %q:\n%s", want, help.String())
+		}
+	}
+}
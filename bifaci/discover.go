@@ -0,0 +1,125 @@
+package bifaci
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+
+	"github.com/machinefabric/capdag-go/cap"
+	"github.com/machinefabric/capdag-go/standard"
+	"github.com/machinefabric/capdag-go/urn"
+)
+
+// Discover asks the host for the caps it can serve (standard.CapDiscover) and returns
+// those a request for pattern would be routed to, all of them if pattern is "".
+func Discover(ctx context.Context, peer PeerInvoker, pattern string) ([]cap.Cap, error) {
+	var patternUrn *urn.CapUrn
+	if pattern != "" {
+		var err error
+		if patternUrn, err = urn.NewCapUrnFromString(pattern); err != nil {
+			return nil, fmt.Errorf("invalid discovery pattern %q: %w", pattern, err)
+		}
+	}
+	response, err := PeerCall(ctx, peer, standard.CapDiscover, nil)
+	if err != nil {
+		return nil, err
+	}
+	stream := response.First()
+	if stream == nil {
+		return nil, fmt.Errorf("discovery response has no stream")
+	}
+	var entries []json.RawMessage
+	if err := stream.Decode(&entries); err != nil {
+		return nil, fmt.Errorf("invalid discovery response: %w", err)
+	}
+
+	var caps []cap.Cap
+	for _, entry := range entries {
+		capDef, err := decodeDiscoveredCap(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid discovery response: %w", err)
+		}
+		// Routing direction: request.Accepts(registered_cap)
+		if patternUrn == nil || patternUrn.Accepts(capDef.Urn) {
+			caps = append(caps, capDef)
+		}
+	}
+	return caps, nil
+}
+
+// decodeDiscoveredCap decodes a cap definition of a discovery response. Manifests may
+// declare a cap by its URN alone, so a definition without title or command yields a
+// cap with only its URN set.
+func decodeDiscoveredCap(entry json.RawMessage) (cap.Cap, error) {
+	var capDef cap.Cap
+	if err := json.Unmarshal(entry, &capDef); err == nil {
+		return capDef, nil
+	}
+	var bare struct {
+		Urn string `json:"urn"`
+	}
+	if err := json.Unmarshal(entry, &bare); err != nil {
+		return cap.Cap{}, err
+	}
+	capUrn, err := urn.NewCapUrnFromString(bare.Urn)
+	if err != nil {
+		return cap.Cap{}, err
+	}
+	return cap.Cap{Urn: capUrn}, nil
+}
+
+// isDiscoverRequest reports whether a REQ asks for the discovery cap
+func isDiscoverRequest(frame *Frame) bool {
+	if frame.Cap == nil {
+		return false
+	}
+	requestUrn, err := urn.NewCapUrnFromString(*frame.Cap)
+	if err != nil {
+		return false
+	}
+	discoverUrn, err := urn.NewCapUrnFromString(standard.CapDiscover)
+	if err != nil {
+		return false
+	}
+	return requestUrn.Equals(discoverUrn)
+}
+
+// discoveryResponse answers a discovery REQ with the cap definitions of manifests, each
+// cap once: a JSON array sent as one CHUNK, then END
+func discoveryResponse(reqId MessageId, manifests [][]byte) ([]*Frame, error) {
+	seen := make(map[string]bool)
+	entries := []json.RawMessage{}
+	for _, manifest := range manifests {
+		var parsed struct {
+			Caps []json.RawMessage `json:"caps"`
+		}
+		if err := json.Unmarshal(manifest, &parsed); err != nil {
+			continue
+		}
+		for _, entry := range parsed.Caps {
+			capDef, err := decodeDiscoveredCap(entry)
+			if err != nil || seen[capDef.Urn.String()] {
+				continue
+			}
+			seen[capDef.Urn.String()] = true
+			entries = append(entries, entry)
+		}
+	}
+	document, err := json.Marshal(entries)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := cborlib.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	const streamId = "discovery"
+	return []*Frame{
+		NewStreamStart(reqId, streamId, standard.MediaJSON),
+		NewChunk(reqId, streamId, 0, payload, 0, ComputeChecksum(payload)),
+		NewStreamEnd(reqId, streamId, 1),
+		NewEnd(reqId, nil),
+	}, nil
+}
@@ -0,0 +1,88 @@
+package bifaci
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/machinefabric/capdag-go/cap"
+	"github.com/machinefabric/capdag-go/standard"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const discoveryManifest = `{"name":"Models","version":"1.0","caps":[{"urn":"cap:in=\"media:void\";op=generate;model=small;out=\"media:void\"","title":"Generate","command":"generate"},{"urn":"cap:in=\"media:void\";op=summarize;out=\"media:void\""}]}`
+
+// replayPeer answers every peer call with a fixed response
+type replayPeer struct {
+	frames []*Frame
+}
+
+func (p *replayPeer) Invoke(capUrn string, arguments []cap.CapArgumentValue) (<-chan Frame, error) {
+	ch := make(chan Frame, len(p.frames))
+	for _, frame := range p.frames {
+		ch <- *frame
+	}
+	close(ch)
+	return ch, nil
+}
+
+func TestDiscoverFiltersByPattern(t *testing.T) {
+	frames, err := discoveryResponse(NewMessageIdRandom(), [][]byte{[]byte(discoveryManifest), []byte(discoveryManifest)})
+	require.NoError(t, err)
+	peer := &replayPeer{frames: frames}
+
+	caps, err := Discover(context.Background(), peer, "")
+	require.NoError(t, err)
+	require.Len(t, caps, 2, "each cap must be listed once")
+	assert.Equal(t, "Generate", caps[0].Title)
+	assert.NotNil(t, caps[1].Urn, "a cap declared by URN alone must still be listed")
+
+	caps, err = Discover(context.Background(), peer, "cap:op=generate")
+	require.NoError(t, err)
+	require.Len(t, caps, 1)
+	assert.Equal(t, "generate", caps[0].Command)
+
+	_, err = Discover(context.Background(), peer, "not a urn")
+	assert.Error(t, err)
+}
+
+// The host answers a plugin's discovery REQ itself and drops the rest of the request
+func TestHostAnswersDiscovery(t *testing.T) {
+	hostRead, pluginWrite := net.Pipe()
+	pluginRead, hostWrite := net.Pipe()
+	defer pluginRead.Close()
+	received := make(chan *Frame, 8)
+	go simulatePlugin(t, pluginRead, pluginWrite, discoveryManifest, func(reader *FrameReader, writer *FrameWriter) {
+		for {
+			frame, err := reader.ReadFrame()
+			if err != nil {
+				close(received)
+				return
+			}
+			received <- frame
+		}
+	})
+
+	host := NewPluginHost()
+	idx, err := host.AttachPlugin(hostRead, hostWrite)
+	require.NoError(t, err)
+
+	reqId := NewMessageIdRandom()
+	host.handlePluginFrame(idx, 0, NewReq(reqId, standard.CapDiscover, nil, "application/cbor"), nil)
+	// With no relay to forward to, a forwarded END would panic
+	host.handlePluginFrame(idx, 0, NewEnd(reqId, nil), nil)
+	assert.Empty(t, host.answered, "the discovery must be settled by the plugin's END")
+
+	var frames []*Frame
+	for frame := range received {
+		frames = append(frames, frame)
+		if frame.FrameType == FrameTypeEnd {
+			break
+		}
+	}
+	peer := &replayPeer{frames: frames}
+	caps, err := Discover(context.Background(), peer, "cap:op=summarize")
+	require.NoError(t, err)
+	assert.Len(t, caps, 1, "the plugin's own caps must be discoverable")
+}
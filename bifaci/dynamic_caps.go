@@ -0,0 +1,138 @@
+package bifaci
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/machinefabric/capdag-go/cap"
+	"github.com/machinefabric/capdag-go/standard"
+	"github.com/machinefabric/capdag-go/urn"
+)
+
+// AddCap adds a cap to the manifest after construction and registers its handler, for
+// caps only known at run time, e.g. one per installed model. Every connected host is
+// sent the updated manifest in a MANIFEST_UPDATED frame, so it can route the cap without
+// restarting the plugin. An error is returned if the manifest didn't parse or already
+// declares the cap.
+func (pr *PluginRuntime) AddCap(capDef cap.Cap, handler HandlerFunc) error {
+	if capDef.Urn == nil {
+		return fmt.Errorf("cap has no URN")
+	}
+	return pr.updateManifest(func(caps []cap.Cap) ([]cap.Cap, error) {
+		for i := range caps {
+			if caps[i].Urn != nil && caps[i].Urn.Equals(capDef.Urn) {
+				return nil, fmt.Errorf("cap %s is already declared as %s", capDef.UrnString(), caps[i].UrnString())
+			}
+		}
+		pr.handlers[capDef.UrnString()] = handler
+		return append(caps, capDef), nil
+	})
+}
+
+// RemoveCap removes a cap from the manifest, along with the handler registered for it,
+// and sends the connected hosts the updated manifest (see AddCap). Requests already
+// running finish. The identity cap can't be removed.
+func (pr *PluginRuntime) RemoveCap(capUrn string) error {
+	removed, err := urn.NewCapUrnFromString(capUrn)
+	if err != nil {
+		return fmt.Errorf("invalid cap URN %q: %w", capUrn, err)
+	}
+	identity, _ := urn.NewCapUrnFromString(standard.CapIdentity)
+	if removed.Equals(identity) {
+		return fmt.Errorf("the identity cap can't be removed")
+	}
+	return pr.updateManifest(func(caps []cap.Cap) ([]cap.Cap, error) {
+		for i := range caps {
+			if caps[i].Urn == nil || !caps[i].Urn.Equals(removed) {
+				continue
+			}
+			for registered := range pr.handlers {
+				if registeredUrn, err := urn.NewCapUrnFromString(registered); err == nil && registeredUrn.Equals(removed) {
+					delete(pr.handlers, registered)
+				}
+			}
+			return append(caps[:i:i], caps[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("cap %s is not declared in the manifest", capUrn)
+	})
+}
+
+// updateManifest replaces the manifest's caps with what change returns, called with
+// pr.mu held, and notifies the connected hosts. The manifest is copied rather than
+// modified, so readers holding the previous one are unaffected.
+func (pr *PluginRuntime) updateManifest(change func(caps []cap.Cap) ([]cap.Cap, error)) error {
+	// Serializes updates, so the host receives manifests in the order they were made
+	pr.manifestMu.Lock()
+	defer pr.manifestMu.Unlock()
+
+	pr.mu.Lock()
+	if pr.manifest == nil {
+		pr.mu.Unlock()
+		return fmt.Errorf("the plugin manifest didn't parse; caps can't be changed")
+	}
+	caps, err := change(append([]cap.Cap(nil), pr.manifest.Caps...))
+	if err != nil {
+		pr.mu.Unlock()
+		return err
+	}
+	manifest := *pr.manifest
+	manifest.Caps = caps
+	manifestData, err := manifest.CanonicalJSON()
+	if err != nil {
+		pr.mu.Unlock()
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	pr.manifest = &manifest
+	pr.manifestData = manifestData
+	conns := pr.connsLocked()
+	pr.mu.Unlock()
+
+	var errs []error
+	for _, conn := range conns {
+		if err := conn.WriteFrame(NewManifestUpdated(manifestData)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("manifest updated, but %d of %d hosts could not be notified: %w", len(errs), len(conns), errors.Join(errs...))
+	}
+	return nil
+}
+
+// connSet is the set of connections a runtime serves, one per host with RunListener
+type connSet map[*syncFrameWriter]bool
+
+// connsLocked returns the connections frames pushed to the host, such as NOTIFY, are
+// sent on. Caller holds pr.mu.
+func (pr *PluginRuntime) connsLocked() []*syncFrameWriter {
+	conns := make([]*syncFrameWriter, 0, len(pr.conns))
+	for conn := range pr.conns {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// attachConn adds conn to the connections manifest updates are sent on. The manifest is
+// sent at once if it changed since sent in the handshake.
+func (pr *PluginRuntime) attachConn(conn *syncFrameWriter, handshakeManifest []byte) {
+	pr.manifestMu.Lock()
+	defer pr.manifestMu.Unlock()
+	pr.mu.Lock()
+	if pr.conns == nil {
+		pr.conns = make(connSet)
+	}
+	pr.conns[conn] = true
+	current := pr.manifestData
+	pr.mu.Unlock()
+	if !bytes.Equal(current, handshakeManifest) {
+		conn.WriteFrame(NewManifestUpdated(current))
+	}
+}
+
+// detachConn stops sending manifest updates on conn
+func (pr *PluginRuntime) detachConn(conn *syncFrameWriter) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	delete(pr.conns, conn)
+}
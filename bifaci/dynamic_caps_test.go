@@ -0,0 +1,174 @@
+package bifaci
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/machinefabric/capdag-go/cap"
+	"github.com/machinefabric/capdag-go/urn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testModelCap = `cap:in="media:void";model=small;op=generate;out="media:void"`
+
+// updateCaps runs change, which sends the host a MANIFEST_UPDATED, while reading the
+// frames it writes, and returns the updated manifest. The pipe is unbuffered, so the
+// update must be read before change can return.
+func updateCaps(t *testing.T, reader *FrameReader, change func() error) string {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- change() }()
+	manifest := readManifestUpdate(t, reader)
+	require.NoError(t, <-done)
+	return manifest
+}
+
+// readManifestUpdate reads frames until a MANIFEST_UPDATED and returns its manifest
+func readManifestUpdate(t *testing.T, reader *FrameReader) string {
+	t.Helper()
+	for {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatalf("Expected MANIFEST_UPDATED, got %v", err)
+		}
+		if frame.FrameType == FrameTypeManifestUpdated {
+			return string(frame.UpdatedManifest())
+		}
+	}
+}
+
+func TestAddAndRemoveCap(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	require.NoError(t, err)
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	defer stop()
+
+	capUrn, err := urn.NewCapUrnFromString(testModelCap)
+	require.NoError(t, err)
+	added := updateCaps(t, reader, func() error {
+		return runtime.AddCap(*cap.NewCap(capUrn, "Generate", "generate"), func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+			for range frames {
+			}
+			return emitter.EmitCbor("generated")
+		})
+	})
+	assert.Contains(t, added, "model=small", "host must be sent the manifest with the new cap")
+	assert.Error(t, runtime.AddCap(*cap.NewCap(capUrn, "Generate", "generate"), nil), "a declared cap can't be added twice")
+
+	reqId := NewMessageIdRandom()
+	require.NoError(t, writer.WriteFrame(NewReq(reqId, testModelCap, nil, "application/cbor")))
+	require.NoError(t, writer.WriteFrame(NewEnd(reqId, nil)))
+	frames := readUntilTerminal(t, reader, reqId)
+	assert.Equal(t, FrameTypeEnd, frames[len(frames)-1].FrameType, "added cap must be routed")
+
+	removed := updateCaps(t, reader, func() error { return runtime.RemoveCap(testModelCap) })
+	assert.NotContains(t, removed, "model=small", "host must be sent the manifest without the cap")
+	assert.Nil(t, runtime.FindHandler(testModelCap), "removed cap's handler must be gone")
+	assert.Error(t, runtime.RemoveCap(testModelCap), "an undeclared cap can't be removed")
+	assert.Error(t, runtime.RemoveCap("cap:"), "the identity cap can't be removed")
+}
+
+// The host routes by the manifest a plugin sends in MANIFEST_UPDATED
+// The manifest is read by the CLI and HTTP paths while caps are added (run with -race)
+func TestManifestReadDuringUpdate(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	require.NoError(t, err)
+	handler := runtime.HTTPHandler()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			capUrn, err := urn.NewCapUrnFromString(fmt.Sprintf(`cap:in="media:void";model=m%d;op=generate;out="media:void"`, i))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if err := runtime.AddCap(*cap.NewCap(capUrn, "Generate", fmt.Sprintf("generate-%d", i)), nil); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for reading := true; reading; {
+		select {
+		case <-done:
+			reading = false
+		default:
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/manifest", nil))
+		require.Equal(t, http.StatusOK, recorder.Code)
+		runtime.findCapByCommand("generate-0")
+	}
+	assert.NotNil(t, runtime.findCapByCommand("generate-19"))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/manifest", nil))
+	assert.Contains(t, recorder.Body.String(), "generate-19", "the manifest served must be the updated one")
+}
+
+// With RunListener, every connected host is sent the updated manifest
+func TestManifestUpdateSentToEveryConnection(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	require.NoError(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	done := make(chan error, 1)
+	go func() { done <- runtime.RunListener(listener) }()
+
+	var readers []*FrameReader
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		reader := NewFrameReader(conn)
+		_, _, err = HandshakeInitiate(reader, NewFrameWriter(conn))
+		require.NoError(t, err)
+		readers = append(readers, reader)
+	}
+
+	capUrn, err := urn.NewCapUrnFromString(testModelCap)
+	require.NoError(t, err)
+	require.NoError(t, runtime.AddCap(*cap.NewCap(capUrn, "Generate", "generate"), nil))
+	for i, reader := range readers {
+		assert.Contains(t, readManifestUpdate(t, reader), "model=small", "host %d must be sent the new manifest", i)
+	}
+
+	listener.Close()
+	assert.NoError(t, <-done)
+}
+
+func TestHostAppliesManifestUpdate(t *testing.T) {
+	hostRead, pluginWrite := net.Pipe()
+	pluginRead, hostWrite := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		simulatePlugin(t, pluginRead, pluginWrite, `{"name":"Test","version":"1.0","caps":[{"urn":"cap:in=media:;out=media:"}]}`, nil)
+	}()
+
+	host := NewPluginHost()
+	idx, err := host.AttachPlugin(hostRead, hostWrite)
+	require.NoError(t, err)
+	<-done
+
+	_, found := host.FindPluginForCap(testModelCap)
+	require.False(t, found, "cap must not be routed before the update")
+
+	update := `{"name":"Test","version":"1.0","caps":[{"urn":"cap:in=media:;out=media:"},{"urn":"` + strings.ReplaceAll(testModelCap, `"`, `\"`) + `"}]}`
+	host.handlePluginFrame(idx, 0, NewManifestUpdated([]byte(update)), nil)
+
+	_, found = host.FindPluginForCap(testModelCap)
+	assert.True(t, found, "cap added by the update must be routed to the plugin")
+	assert.Contains(t, string(host.Capabilities()), "model=small")
+
+	hostRead.Close()
+	hostWrite.Close()
+	pluginRead.Close()
+	pluginWrite.Close()
+}
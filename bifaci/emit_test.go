@@ -0,0 +1,231 @@
+package bifaci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+
+	"github.com/machinefabric/capdag-go/standard"
+)
+
+// runEmitHandler runs handler for one request and returns the response frames
+func runEmitHandler(t *testing.T, handler HandlerFunc) []*Frame {
+	t.Helper()
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.Register(testCancelCap, handler)
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	defer stop()
+
+	reqId := NewMessageIdRandom()
+	writer.WriteFrame(NewReq(reqId, testCancelCap, nil, "application/cbor"))
+	writer.WriteFrame(NewEnd(reqId, nil))
+	return readUntilTerminal(t, reader, reqId)
+}
+
+// EmitJSON sends a map as one JSON document instead of a CHUNK per entry
+func TestEmitJSON(t *testing.T) {
+	frames := runEmitHandler(t, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		return EmitJSON(emitter, map[string]interface{}{"pages": 3, "title": "doc"})
+	})
+
+	var chunks []*Frame
+	for _, frame := range frames {
+		switch frame.FrameType {
+		case FrameTypeStreamStart:
+			if *frame.MediaUrn != standard.MediaJSON {
+				t.Errorf("Expected the stream tagged %s, got %s", standard.MediaJSON, *frame.MediaUrn)
+			}
+		case FrameTypeChunk:
+			chunks = append(chunks, frame)
+		}
+	}
+	if len(chunks) != 1 {
+		t.Fatalf("Expected one CHUNK, got %d", len(chunks))
+	}
+	var text string
+	if err := cborlib.Unmarshal(chunks[0].Payload, &text); err != nil {
+		t.Fatalf("Expected a CBOR text CHUNK: %v", err)
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal([]byte(text), &document); err != nil || document["title"] != "doc" {
+		t.Errorf("Expected the JSON document, got %q (%v)", text, err)
+	}
+}
+
+func TestEmitTextAndBytesTagging(t *testing.T) {
+	frames := runEmitHandler(t, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		if err := EmitText(emitter, "hello"); err != nil {
+			return err
+		}
+		// The stream already started as text
+		return EmitBytes(emitter, []byte{1, 2})
+	})
+	if frames[0].FrameType != FrameTypeStreamStart || *frames[0].MediaUrn != standard.MediaString {
+		t.Errorf("Expected the stream tagged %s, got %+v", standard.MediaString, frames[0])
+	}
+	if last := frames[len(frames)-1]; last.FrameType != FrameTypeEnd {
+		t.Errorf("Expected END, got %v", last.FrameType)
+	}
+}
+
+func TestEmitError(t *testing.T) {
+	frames := runEmitHandler(t, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		return EmitError(emitter, ErrCodeBusy, "overloaded")
+	})
+	last := frames[len(frames)-1]
+	if last.FrameType != FrameTypeErr || last.ErrorCode() != string(ErrCodeBusy) || last.ErrorMessage() != "overloaded" {
+		t.Errorf("Expected ERR BUSY, got %v %s %s", last.FrameType, last.ErrorCode(), last.ErrorMessage())
+	}
+}
+
+// emitFrames emits with an emitter of the given chunk size and returns the frames written
+func emitFrames(t *testing.T, maxChunk int, emit func(emitter *threadSafeEmitter) error) chan Frame {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := newSyncFrameWriter(NewFrameWriter(&buf))
+	emitter := newThreadSafeEmitter(context.Background(), writer, NewMessageIdRandom(), nil, "result", "media:", maxChunk, nil, defaultLogger())
+	if err := emit(emitter); err != nil {
+		t.Fatalf("Emit failed: %v", err)
+	}
+	emitter.Finalize()
+
+	frames := make(chan Frame, 64)
+	reader := NewFrameReader(&buf)
+	for {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		frames <- *frame
+		if frame.FrameType == FrameTypeEnd {
+			close(frames)
+			return frames
+		}
+	}
+}
+
+// A map is emitted as one CBOR value, not a CHUNK per entry
+func TestEmitCborMapSingleValue(t *testing.T) {
+	frames := emitFrames(t, DefaultMaxChunk, func(emitter *threadSafeEmitter) error {
+		return emitter.EmitCbor(map[interface{}]interface{}{"a": 1, "b": 2, "c": 3})
+	})
+	response, err := CollectPluginResponse(frames)
+	if err != nil {
+		t.Fatalf("CollectPluginResponse failed: %v", err)
+	}
+	if chunks := len(response.Streams()[0].Chunks); chunks != 1 {
+		t.Errorf("Expected one CHUNK, got %d", chunks)
+	}
+	var decoded map[string]int
+	if err := response.DecodeCBOR(&decoded); err != nil || decoded["c"] != 3 {
+		t.Errorf("Expected the map back, got %v (%v)", decoded, err)
+	}
+}
+
+// A value larger than a chunk is split at the byte level and still decodes
+func TestEmitCborLargeValueSplit(t *testing.T) {
+	value := map[string]string{"text": strings.Repeat("x", 100), "more": strings.Repeat("y", 100)}
+	frames := emitFrames(t, 32, func(emitter *threadSafeEmitter) error {
+		return emitter.EmitCbor(value)
+	})
+	response, err := CollectPluginResponse(frames)
+	if err != nil {
+		t.Fatalf("CollectPluginResponse failed: %v", err)
+	}
+	if chunks := len(response.Streams()[0].Chunks); chunks < 2 {
+		t.Errorf("Expected the value split over several CHUNKs, got %d", chunks)
+	}
+	var decoded map[string]string
+	if err := response.DecodeCBOR(&decoded); err != nil || decoded["more"] != value["more"] {
+		t.Errorf("Expected the map back, got %v (%v)", decoded, err)
+	}
+
+	var data []byte
+	for _, chunk := range response.Streams()[0].Chunks {
+		data = append(data, chunk.Payload...)
+	}
+	var typed map[string]string
+	if err := decodeTypedArg(data, &typed); err != nil || typed["text"] != value["text"] {
+		t.Errorf("Expected typed decoding to get the map back, got %v (%v)", typed, err)
+	}
+}
+
+// EmitSeq sends each element as it is produced, decodable one by one
+func TestEmitSeq(t *testing.T) {
+	frames := runEmitHandler(t, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		return EmitSeq(emitter, func(yield func(v interface{}) error) error {
+			for _, token := range []string{"Hello", ",", " world"} {
+				if err := yield(token); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+
+	ch := make(chan Frame, len(frames))
+	for _, frame := range frames {
+		ch <- *frame
+	}
+	close(ch)
+	elements := NewElementDecoder(ch)
+	var tokens []string
+	for {
+		var token string
+		err := elements.Next(&token)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		tokens = append(tokens, token)
+	}
+	if strings.Join(tokens, "|") != "Hello|,| world" {
+		t.Errorf("Expected the three tokens, got %q", tokens)
+	}
+	if err := elements.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+}
+
+func TestEmitHelpersOnPlainEmitter(t *testing.T) {
+	emitter := &mockStreamEmitter{}
+	if err := EmitJSON(emitter, map[string]int{"pages": 3}); err != nil {
+		t.Fatalf("EmitJSON failed: %v", err)
+	}
+	if err := EmitText(emitter, "hello"); err != nil {
+		t.Fatalf("EmitText failed: %v", err)
+	}
+	var document, text string
+	if err := cborlib.Unmarshal(emitter.emittedData[0], &document); err != nil || document != `{"pages":3}` {
+		t.Errorf("Expected the JSON document as a string, got %q (%v)", document, err)
+	}
+	if err := cborlib.Unmarshal(emitter.emittedData[1], &text); err != nil || text != "hello" {
+		t.Errorf("Expected the text emitted as is, got %q (%v)", text, err)
+	}
+
+	if _, err := OpenStream(emitter, "media:json"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Expected OpenStream to be unsupported, got %v", err)
+	}
+	if err := Abort(emitter, NewCapError(ErrCodeBusy, "overloaded")); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Expected Abort to be unsupported, got %v", err)
+	}
+}
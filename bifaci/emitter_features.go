@@ -0,0 +1,220 @@
+package bifaci
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Optional StreamEmitter features. The emitters the runtime hands handlers implement
+// all of them; handlers reach them through the functions below, like HandlerContext.
+// An emitter written against StreamEmitter alone, such as a test mock, gets EmitCbor
+// and EmitLog in their place where they can stand in, and errors.ErrUnsupported where
+// they can't.
+
+// TypedEmitter emits values announced with their media type (see EmitJSON, EmitText
+// and EmitBytes).
+type TypedEmitter interface {
+	// EmitJSON emits v encoded as a JSON document, sent as text on the primary stream,
+	// which is tagged standard.MediaJSON if nothing was emitted on it yet.
+	EmitJSON(v interface{}) error
+	// EmitText emits text on the primary stream, tagged standard.MediaString if nothing
+	// was emitted on it yet.
+	EmitText(s string) error
+	// EmitBytes emits bytes on the primary stream, tagged standard.MediaBinary if
+	// nothing was emitted on it yet.
+	EmitBytes(b []byte) error
+}
+
+// SeqEmitter emits a sequence one element per CHUNK (see EmitSeq).
+type SeqEmitter interface {
+	// EmitSeq emits an unbounded sequence, e.g. tailed log lines or generated tokens:
+	// produce calls yield for each element, which is sent right away as its own CBOR
+	// value in its own CHUNK (see ElementDecoder). yield fails once the element can't
+	// be sent, e.g. the request was cancelled, and produce should then return its
+	// error, which EmitSeq returns.
+	EmitSeq(produce func(yield func(v interface{}) error) error) error
+}
+
+// LogAttrsEmitter emits LOG frames carrying attributes (see EmitLogAttrs).
+type LogAttrsEmitter interface {
+	// EmitLogAttrs is EmitLog with slog-style key/value attributes
+	// (e.g. EmitLogAttrs("info", "page rendered", "page", 3)).
+	EmitLogAttrs(level, message string, attrs ...any)
+}
+
+// ProgressEmitter reports how far a handler got (see EmitProgress).
+type ProgressEmitter interface {
+	// EmitProgress reports completed units of total (0 if unknown) in the named stage,
+	// e.g. EmitProgress("render", 3, 10). Sends a LOG frame of level "progress" (see
+	// Progress), a side-channel like EmitLog.
+	EmitProgress(stage string, completed, total uint64)
+}
+
+// StreamOpener opens additional output streams in a response (see OpenStream).
+type StreamOpener interface {
+	// OpenStream opens an additional output stream in the same response,
+	// e.g. a media:json metadata stream next to a media:pdf primary output.
+	// STREAM_START is sent immediately; the stream is closed by OutputStream.Close
+	// or, if left open, when the response is finalized.
+	OpenStream(mediaUrn string) (OutputStream, error)
+}
+
+// TextWriterEmitter hands out a batching writer of text (see TextWriterOf).
+type TextWriterEmitter interface {
+	// TextWriter returns a writer of text on the primary stream, tagged like EmitText,
+	// for text produced in small pieces such as generated tokens. Small writes are
+	// batched into CHUNKs of DefaultTextBatch bytes, never splitting a UTF-8 sequence
+	// or grapheme cluster across CHUNKs; Flush sends the text written so far right
+	// away. What is still buffered when the handler returns is sent before END.
+	TextWriter() TextWriter
+}
+
+// Aborter ends a response early with an ERR (see Abort).
+type Aborter interface {
+	// Abort ends the response with an ERR for err, keeping the output already sent.
+	// The ERR lists the streams it cut short and how many of their chunks were sent
+	// (see Frame.PartialStreams), so the receiver knows how much output is valid.
+	// Nothing can be emitted afterwards, and the handler's return value is ignored.
+	Abort(err error) error
+}
+
+// EmitJSON emits v as a JSON document (see TypedEmitter). Other emitters are given the
+// document as a string.
+func EmitJSON(emitter StreamEmitter, v interface{}) error {
+	if typed, ok := emitter.(TypedEmitter); ok {
+		return typed.EmitJSON(v)
+	}
+	document, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to JSON-encode value: %w", err)
+	}
+	return emitter.EmitCbor(string(document))
+}
+
+// EmitText emits text (see TypedEmitter). Other emitters are given it with EmitCbor.
+func EmitText(emitter StreamEmitter, s string) error {
+	if typed, ok := emitter.(TypedEmitter); ok {
+		return typed.EmitText(s)
+	}
+	return emitter.EmitCbor(s)
+}
+
+// EmitBytes emits bytes (see TypedEmitter). Other emitters are given them with EmitCbor.
+func EmitBytes(emitter StreamEmitter, b []byte) error {
+	if typed, ok := emitter.(TypedEmitter); ok {
+		return typed.EmitBytes(b)
+	}
+	return emitter.EmitCbor(b)
+}
+
+// EmitError ends the response with ERR code and message, like Abort with a CapError.
+func EmitError(emitter StreamEmitter, code ErrCode, message string) error {
+	return Abort(emitter, NewCapError(code, message))
+}
+
+// EmitSeq emits the sequence produce yields (see SeqEmitter). Other emitters are given
+// each element with EmitCbor.
+func EmitSeq(emitter StreamEmitter, produce func(yield func(v interface{}) error) error) error {
+	if seq, ok := emitter.(SeqEmitter); ok {
+		return seq.EmitSeq(produce)
+	}
+	return produce(emitter.EmitCbor)
+}
+
+// EmitStreamElements emits each element elements yields as its own CBOR value in its
+// own CHUNK, so the receiver can process a long list as it arrives (see EmitSeq). It
+// stops at the first element that fails to emit and returns the error.
+func EmitStreamElements(emitter StreamEmitter, elements ElementSeq) error {
+	return EmitSeq(emitter, func(yield func(v interface{}) error) error {
+		var emitErr error
+		elements(func(element interface{}) bool {
+			emitErr = yield(element)
+			return emitErr == nil
+		})
+		return emitErr
+	})
+}
+
+// EmitLogAttrs emits a log message with attributes (see LogAttrsEmitter). Other
+// emitters are given the attributes appended to the message as key=value pairs.
+func EmitLogAttrs(emitter StreamEmitter, level, message string, attrs ...any) {
+	if logger, ok := emitter.(LogAttrsEmitter); ok {
+		logger.EmitLogAttrs(level, message, attrs...)
+		return
+	}
+	emitter.EmitLog(level, message+formatLogAttrs(attrs))
+}
+
+// formatLogAttrs formats attributes as " key=value" pairs sorted by key
+func formatLogAttrs(attrs []any) string {
+	attrMap := logAttrsToMap(attrs)
+	keys := make([]string, 0, len(attrMap))
+	for key := range attrMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var text string
+	for _, key := range keys {
+		text += fmt.Sprintf(" %s=%v", key, attrMap[key])
+	}
+	return text
+}
+
+// EmitProgress reports progress (see ProgressEmitter). Other emitters log it at level
+// "info".
+func EmitProgress(emitter StreamEmitter, stage string, completed, total uint64) {
+	if progress, ok := emitter.(ProgressEmitter); ok {
+		progress.EmitProgress(stage, completed, total)
+		return
+	}
+	EmitLogAttrs(emitter, "info", stage, "completed", completed, "total", total)
+}
+
+// OpenStream opens an additional output stream (see StreamOpener).
+func OpenStream(emitter StreamEmitter, mediaUrn string) (OutputStream, error) {
+	if opener, ok := emitter.(StreamOpener); ok {
+		return opener.OpenStream(mediaUrn)
+	}
+	return nil, fmt.Errorf("%T can't open output streams: %w", emitter, errors.ErrUnsupported)
+}
+
+// Stdout returns a writer whose writes are emitted as bytes on the response stream,
+// e.g. as the Stdout of a tool whose output is the response. The process's own stdout
+// is not the response (see PluginRuntime.RunGuard).
+func Stdout(emitter StreamEmitter) io.Writer {
+	return &emitterWriter{emitter: emitter}
+}
+
+// TextWriterOf returns the emitter's writer of text (see TextWriterEmitter). Other
+// emitters get a writer that batches text the same way, but nothing sends what it
+// still buffers when the handler returns: its Flush sends all of it, so call Flush
+// before returning.
+func TextWriterOf(emitter StreamEmitter) TextWriter {
+	if texts, ok := emitter.(TextWriterEmitter); ok {
+		return texts.TextWriter()
+	}
+	return flushingTextWriter{newTextWriter(func(text string) error { return EmitText(emitter, text) }, DefaultTextBatch)}
+}
+
+// flushingTextWriter is the TextWriter of an emitter that doesn't hand one out
+type flushingTextWriter struct {
+	*textWriter
+}
+
+func (w flushingTextWriter) Flush() error {
+	if text := w.drain(); text != "" {
+		return w.emit(text)
+	}
+	return nil
+}
+
+// Abort ends the response with an ERR for err (see Aborter).
+func Abort(emitter StreamEmitter, err error) error {
+	if aborter, ok := emitter.(Aborter); ok {
+		return aborter.Abort(err)
+	}
+	return fmt.Errorf("%T can't abort the response: %w", emitter, errors.ErrUnsupported)
+}
@@ -0,0 +1,158 @@
+package bifaci
+
+import (
+	"context"
+	"fmt"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+
+	"github.com/machinefabric/capdag-go/cap"
+)
+
+// EnvArgError is returned for a required argument with an env source that no other
+// source supplied while its environment variable is not set
+type EnvArgError struct {
+	Arg string // Media URN of the argument
+	Var string // Environment variable it is read from
+}
+
+func (e *EnvArgError) Error() string {
+	return fmt.Sprintf("required argument missing: %s (environment variable %s is not set)", e.Arg, e.Var)
+}
+
+// missingArgError is the error for a required argument no source supplied
+func missingArgError(argDef *cap.CapArg) error {
+	if envVar := argDef.GetEnv(); envVar != nil {
+		return &EnvArgError{Arg: argDef.MediaUrn, Var: *envVar}
+	}
+	return fmt.Errorf("required argument missing: %s", argDef.MediaUrn)
+}
+
+type envContextKey struct{}
+
+// contextWithEnv returns ctx carrying the environment a request's env arguments are
+// read from
+func contextWithEnv(ctx context.Context, env map[string]string) context.Context {
+	if len(env) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, envContextKey{}, env)
+}
+
+// envFromContext returns the environment a request's env arguments are read from
+func envFromContext(ctx context.Context) map[string]string {
+	env, _ := ctx.Value(envContextKey{}).(map[string]string)
+	return env
+}
+
+// mergeEnv returns the connection's environment extended by a request's own
+func mergeEnv(connEnv, reqEnv map[string]string) map[string]string {
+	if len(reqEnv) == 0 {
+		return connEnv
+	}
+	if len(connEnv) == 0 {
+		return reqEnv
+	}
+	env := make(map[string]string, len(connEnv)+len(reqEnv))
+	for name, value := range connEnv {
+		env[name] = value
+	}
+	for name, value := range reqEnv {
+		env[name] = value
+	}
+	return env
+}
+
+// withEnvArgs wraps the handler registered under pattern so that arguments with an env
+// source the host didn't send are read from the environment the host sent in HELLO or
+// REQ meta "env" (see Frame.Env), if the manifest declares the cap. The plugin's own
+// process environment is only read in CLI mode. Caller holds pr.mu.
+func (pr *PluginRuntime) withEnvArgs(pattern string, handler HandlerFunc) HandlerFunc {
+	capDef := pr.manifestCap(pattern)
+	if capDef == nil {
+		return handler
+	}
+	var envArgs []cap.CapArg
+	for _, arg := range capDef.GetArgs() {
+		if arg.HasEnvSource() {
+			envArgs = append(envArgs, arg)
+		}
+	}
+	if len(envArgs) == 0 {
+		return handler
+	}
+	return func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		env := envFromContext(HandlerContext(emitter))
+		input := make(chan Frame, 64)
+		handlerDone := make(chan struct{})
+		filled := make(chan error, 1)
+		go func() {
+			// The error is ready before the handler sees its input end
+			filled <- fillEnvArgs(frames, input, handlerDone, envArgs, env)
+			close(input)
+		}()
+
+		err := handler(input, emitter, peer)
+		close(handlerDone)
+		select {
+		case fillErr := <-filled:
+			if fillErr != nil {
+				return fillErr
+			}
+		default:
+		}
+		return err
+	}
+}
+
+// fillEnvArgs forwards frames to input and, before END, sends each of envArgs that no
+// stream supplied as a stream of its variable's value in env. A required one whose
+// variable isn't set ends the input there with an EnvArgError. Forwarding stops once
+// handlerDone is closed.
+func fillEnvArgs(frames <-chan Frame, input chan<- Frame, handlerDone <-chan struct{}, envArgs []cap.CapArg, env map[string]string) error {
+	send := func(frame Frame) bool {
+		select {
+		case input <- frame:
+			return true
+		case <-handlerDone:
+			return false
+		}
+	}
+
+	var streamUrns []string
+	for frame := range frames {
+		if frame.FrameType == FrameTypeStreamStart && frame.MediaUrn != nil {
+			streamUrns = append(streamUrns, *frame.MediaUrn)
+		}
+		if frame.FrameType == FrameTypeEnd {
+			for i := range envArgs {
+				arg := &envArgs[i]
+				if j, _ := firstAccepted(arg.MediaUrn, len(streamUrns), func(j int) string { return streamUrns[j] }); j >= 0 {
+					continue
+				}
+				value, ok := env[*arg.GetEnv()]
+				if !ok {
+					if arg.Required {
+						return missingArgError(arg)
+					}
+					continue
+				}
+				// Sent as CLI mode sends a value given on the command line
+				payload, err := cborlib.Marshal([]byte(value))
+				if err != nil {
+					return err
+				}
+				streamID := fmt.Sprintf("env-%d", i)
+				if !send(*NewStreamStart(frame.Id, streamID, arg.MediaUrn)) ||
+					!send(*NewChunk(frame.Id, streamID, 0, payload, 0, ComputeChecksum(payload))) ||
+					!send(*NewStreamEnd(frame.Id, streamID, 1)) {
+					return nil
+				}
+			}
+		}
+		if !send(frame) {
+			return nil
+		}
+	}
+	return nil
+}
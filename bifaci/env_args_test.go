@@ -0,0 +1,128 @@
+package bifaci
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+
+	"github.com/machinefabric/capdag-go/cap"
+)
+
+const testEnvCap = `cap:in="media:void";op=generate;out="media:textable"`
+
+// newEnvArgRuntime returns a runtime whose cap takes its API key from --api-key or the
+// API_KEY environment variable, and answers with the key it got
+func newEnvArgRuntime(t *testing.T) (*PluginRuntime, *cap.Cap) {
+	t.Helper()
+	envVar := "API_KEY"
+	capDef := createTestCap(testEnvCap, "Generate", "generate", []cap.CapArg{{
+		MediaUrn: "media:api-key;textable",
+		Required: true,
+		Sources:  []cap.ArgSource{cliFlagSource("--api-key"), {Env: &envVar}},
+	}})
+	manifest := createTestManifest("TestPlugin", "1.0.0", "Test", []*cap.Cap{capDef})
+	runtime, err := NewPluginRuntimeWithManifest(manifest)
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.Register(testEnvCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		args, err := CollectArgs(frames)
+		if err != nil {
+			return err
+		}
+		key, err := args.Bytes("media:api-key;textable")
+		if err != nil {
+			return err
+		}
+		return emitter.EmitCbor(string(key))
+	})
+	return runtime, &manifest.Caps[0]
+}
+
+func TestEnvArgFromEnvironment(t *testing.T) {
+	runtime, capDef := newEnvArgRuntime(t)
+
+	t.Setenv("API_KEY", "from-env")
+	arguments, err := runtime.buildCLIArguments(capDef, nil, nil)
+	if err != nil {
+		t.Fatalf("buildCLIArguments failed: %v", err)
+	}
+	if len(arguments) != 1 || string(arguments[0].value.([]byte)) != "from-env" {
+		t.Errorf("Expected the key from the environment, got %+v", arguments)
+	}
+
+	// A flag comes first
+	arguments, err = runtime.buildCLIArguments(capDef, []string{"--api-key", "from-flag"}, nil)
+	if err != nil {
+		t.Fatalf("buildCLIArguments failed: %v", err)
+	}
+	if len(arguments) != 1 || string(arguments[0].value.([]byte)) != "from-flag" {
+		t.Errorf("Expected the key from the flag, got %+v", arguments)
+	}
+
+	os.Unsetenv("API_KEY")
+	_, err = runtime.buildCLIArguments(capDef, nil, nil)
+	var envErr *EnvArgError
+	if !errors.As(err, &envErr) || envErr.Var != "API_KEY" {
+		t.Errorf("Expected an error naming API_KEY, got %v", err)
+	}
+}
+
+// In CBOR mode the value comes from the env the host sends, not the plugin's own
+func TestEnvArgFromHostEnv(t *testing.T) {
+	runtime, _ := newEnvArgRuntime(t)
+	t.Setenv("API_KEY", "plugin-env")
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	defer stop()
+
+	request := func(env map[string]string) []*Frame {
+		reqId := NewMessageIdRandom()
+		req := NewReq(reqId, testEnvCap, nil, "application/cbor")
+		if env != nil {
+			req.SetEnv(env)
+		}
+		for _, f := range []*Frame{req, NewEnd(reqId, nil)} {
+			if err := writer.WriteFrame(f); err != nil {
+				t.Fatalf("Failed to write %v: %v", f.FrameType, err)
+			}
+		}
+		return readUntilTerminal(t, reader, reqId)
+	}
+
+	var key string
+	for _, frame := range request(map[string]string{"API_KEY": "host-env"}) {
+		if frame.FrameType == FrameTypeChunk {
+			if err := cborlib.Unmarshal(frame.Payload, &key); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+		}
+	}
+	if key != "host-env" {
+		t.Errorf("Expected the key from the host's env, got %q", key)
+	}
+
+	frames := request(nil)
+	last := frames[len(frames)-1]
+	if last.FrameType != FrameTypeErr || last.ErrorCode() != "INVALID_ARGUMENT" || !strings.Contains(last.ErrorMessage(), "API_KEY") {
+		t.Errorf("Expected ERR INVALID_ARGUMENT naming API_KEY, got %v %s", last.FrameType, last.ErrorMessage())
+	}
+}
+
+func TestFrameEnv(t *testing.T) {
+	frame := NewReq(NewMessageIdRandom(), testEnvCap, nil, "application/cbor")
+	frame.SetEnv(map[string]string{"MODEL": "large"})
+	encoded, err := EncodeFrame(frame)
+	if err != nil {
+		t.Fatalf("EncodeFrame failed: %v", err)
+	}
+	decoded, err := DecodeFrame(encoded)
+	if err != nil {
+		t.Fatalf("DecodeFrame failed: %v", err)
+	}
+	if env := decoded.Env(); env["MODEL"] != "large" {
+		t.Errorf("Expected MODEL in the decoded env, got %v", env)
+	}
+}
@@ -0,0 +1,155 @@
+package bifaci
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// execWaitDelay is how long a finished process's output is still read, e.g. while
+// descendants that escaped its process group hold the pipes open
+const execWaitDelay = 5 * time.Second
+
+// ExitCodeError is returned by an ExecCap handler for a process that exited with a
+// non-zero code. The runtime reports it as ERR PROCESS_FAILED with the code in meta
+// "exit_code" (see Frame.ExitCode).
+type ExitCodeError struct {
+	Command  string
+	ExitCode int // -1 if the process was killed by a signal
+}
+
+func (e *ExitCodeError) Error() string {
+	if e.ExitCode < 0 {
+		return fmt.Sprintf("%s was killed", e.Command)
+	}
+	return fmt.Sprintf("%s exited with code %d", e.Command, e.ExitCode)
+}
+
+// ExitCode returns the exit code carried by an ERR frame for a failed ExecCap process
+func (f *Frame) ExitCode() (int, bool) {
+	if f.FrameType != FrameTypeErr || f.Meta == nil {
+		return 0, false
+	}
+	switch code := f.Meta["exit_code"].(type) {
+	case int:
+		return code, true
+	case int64:
+		return int(code), true
+	case uint64:
+		return int(code), true
+	}
+	return 0, false
+}
+
+// ExecCap returns a handler running a copy of cmd for each request, for plugins that
+// wrap a CLI tool: the contents of the request's first argument stream are written to
+// the process's stdin, its stdout is emitted as the response, and each line of its
+// stderr is sent as a LOG frame (level "info", attribute stream=stderr). A non-zero
+// exit code is answered with ERR PROCESS_FAILED (see ExitCodeError). Cancelling the
+// request kills the process along with everything it started.
+//
+// cmd is a template and never run itself: its Path, Args, Env, Dir and SysProcAttr
+// are copied, while stdio always belongs to the request.
+//
+//	runtime.Register(capUrn, bifaci.ExecCap(exec.Command("pandoc", "-f", "markdown", "-t", "html")))
+func ExecCap(cmd *exec.Cmd) HandlerFunc {
+	return func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		ctx := HandlerContext(emitter)
+		run := exec.CommandContext(ctx, cmd.Path)
+		run.Args, run.Env, run.Dir = cmd.Args, cmd.Env, cmd.Dir
+		run.SysProcAttr = cmd.SysProcAttr
+		setProcessGroup(run)
+		run.Cancel = func() error { return killProcessGroup(run) }
+		run.WaitDelay = execWaitDelay
+
+		stdin, err := run.StdinPipe()
+		if err != nil {
+			return fmt.Errorf("failed to create stdin pipe: %w", err)
+		}
+		stderr := &logLineWriter{emitter: emitter, attrs: []any{"stream", "stderr"}}
+		run.Stdout = Stdout(emitter)
+		run.Stderr = stderr
+		if err := run.Start(); err != nil {
+			return fmt.Errorf("failed to start %s: %w", cmd.Path, err)
+		}
+
+		// Input the process stops reading is discarded
+		fed := make(chan error, 1)
+		go func() {
+			defer stdin.Close()
+			input := NewStreamDecoder(frames)
+			buf := make([]byte, 32*1024)
+			for {
+				n, err := input.Read(buf)
+				if n > 0 {
+					if _, writeErr := stdin.Write(buf[:n]); writeErr != nil {
+						fed <- nil
+						return
+					}
+				}
+				if err == io.EOF {
+					fed <- input.Close()
+					return
+				}
+				if err != nil {
+					fed <- err
+					return
+				}
+			}
+		}()
+
+		waitErr := run.Wait()
+		stderr.Flush()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if inputErr := <-fed; inputErr != nil {
+			return fmt.Errorf("failed to read input for %s: %w", cmd.Path, inputErr)
+		}
+		var exitErr *exec.ExitError
+		if errors.As(waitErr, &exitErr) {
+			return &ExitCodeError{Command: cmd.Path, ExitCode: exitErr.ExitCode()}
+		}
+		return waitErr
+	}
+}
+
+// logLineWriter is an io.Writer sending each line written to it as a LOG frame
+type logLineWriter struct {
+	emitter StreamEmitter
+	attrs   []any
+	mu      sync.Mutex
+	partial []byte // Written since the last newline
+}
+
+func (w *logLineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 && len(w.partial) < maxStdoutLine {
+			break
+		}
+		if i < 0 {
+			i = len(w.partial) - 1
+		}
+		EmitLogAttrs(w.emitter, "info", string(trimNewline(w.partial[:i+1])), w.attrs...)
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush sends the last line if it didn't end with a newline
+func (w *logLineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.partial) > 0 {
+		EmitLogAttrs(w.emitter, "info", string(w.partial), w.attrs...)
+		w.partial = nil
+	}
+}
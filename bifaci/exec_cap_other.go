@@ -0,0 +1,13 @@
+//go:build !unix
+
+package bifaci
+
+import "os/exec"
+
+// Process groups aren't available, so only the process itself is killed
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
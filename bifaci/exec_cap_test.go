@@ -0,0 +1,107 @@
+//go:build unix
+
+package bifaci
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+)
+
+// startExecRuntime serves a runtime whose test cap runs cmd
+func startExecRuntime(t *testing.T, cmd *exec.Cmd) (*FrameReader, *FrameWriter, func() error) {
+	t.Helper()
+	if _, err := exec.LookPath(cmd.Args[0]); err != nil {
+		t.Skipf("%s not available: %v", cmd.Args[0], err)
+	}
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.Register(testCancelCap, ExecCap(cmd))
+	return startCBORRuntime(t, runtime)
+}
+
+func TestExecCapStreamsStdinToStdout(t *testing.T) {
+	reader, writer, stop := startExecRuntime(t, exec.Command("cat"))
+	defer stop()
+
+	reqId := NewMessageIdRandom()
+	input, _ := cborlib.Marshal([]byte("through the tool"))
+	writeTestRequest(t, writer, reqId, testCancelCap, input)
+	frames := readUntilTerminal(t, reader, reqId)
+	if last := frames[len(frames)-1]; last.FrameType != FrameTypeEnd {
+		t.Fatalf("Expected END, got %v %s", last.FrameType, last.ErrorMessage())
+	}
+	var output []byte
+	for _, frame := range frames {
+		if frame.FrameType == FrameTypeChunk {
+			var piece []byte
+			if err := cborlib.Unmarshal(frame.Payload, &piece); err != nil {
+				t.Fatalf("Failed to decode CHUNK: %v", err)
+			}
+			output = append(output, piece...)
+		}
+	}
+	if string(output) != "through the tool" {
+		t.Errorf("Expected the input echoed, got %q", output)
+	}
+}
+
+func TestExecCapStderrAndExitCode(t *testing.T) {
+	reader, writer, stop := startExecRuntime(t, exec.Command("sh", "-c", "echo converting >&2; printf 'no newline' >&2; exit 3"))
+	defer stop()
+
+	reqId := NewMessageIdRandom()
+	writeTestRequest(t, writer, reqId, testCancelCap, []byte{0x40})
+	frames := readUntilTerminal(t, reader, reqId)
+	var logs []string
+	for _, frame := range frames {
+		if frame.FrameType == FrameTypeLog && frame.LogAttrs()["stream"] == "stderr" {
+			logs = append(logs, frame.LogMessage())
+		}
+	}
+	if strings.Join(logs, ",") != "converting,no newline" {
+		t.Errorf("Expected each stderr line as a LOG frame, got %v", logs)
+	}
+	last := frames[len(frames)-1]
+	if last.FrameType != FrameTypeErr || last.ErrorCode() != "PROCESS_FAILED" {
+		t.Fatalf("Expected ERR PROCESS_FAILED, got %v %s", last.FrameType, last.ErrorCode())
+	}
+	if code, ok := last.ExitCode(); !ok || code != 3 {
+		t.Errorf("Expected exit code 3, got %d (%v)", code, ok)
+	}
+}
+
+func TestExecCapCancelKillsProcessGroup(t *testing.T) {
+	// The shell's child sleep would keep stdout open if only the shell were killed
+	reader, writer, stop := startExecRuntime(t, exec.Command("sh", "-c", "echo started; sleep 30; echo finished"))
+	defer stop()
+
+	reqId := NewMessageIdRandom()
+	writeTestRequest(t, writer, reqId, testCancelCap, []byte{0x40})
+	for {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		if frame.FrameType == FrameTypeChunk {
+			break
+		}
+	}
+
+	started := time.Now()
+	if err := writer.WriteFrame(NewCancel(reqId)); err != nil {
+		t.Fatalf("Failed to write CANCEL: %v", err)
+	}
+	frames := readUntilTerminal(t, reader, reqId)
+	if last := frames[len(frames)-1]; last.FrameType != FrameTypeErr || last.ErrorCode() != "CANCELLED" {
+		t.Fatalf("Expected ERR CANCELLED, got %v %s", last.FrameType, last.ErrorCode())
+	}
+	if elapsed := time.Since(started); elapsed >= execWaitDelay {
+		t.Errorf("Expected the process group killed at once, took %v", elapsed)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package bifaci
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts cmd in a process group of its own, so killProcessGroup
+// reaches what it starts too
+func setProcessGroup(cmd *exec.Cmd) {
+	attr := &syscall.SysProcAttr{}
+	if cmd.SysProcAttr != nil {
+		copied := *cmd.SysProcAttr
+		attr = &copied
+	}
+	attr.Setpgid = true
+	cmd.SysProcAttr = attr
+}
+
+// killProcessGroup kills the process group of cmd
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
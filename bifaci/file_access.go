@@ -0,0 +1,136 @@
+package bifaci
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileAccessPolicy constrains the files file-path arguments may read, so a host can run
+// an untrusted plugin's CLI with a constrained filesystem (see SetFileAccessPolicy)
+type FileAccessPolicy struct {
+	AllowRoots  []string // Directories files must be in (none = anywhere)
+	DenyRoots   []string // Directories files must not be in, even within an allowed root
+	MaxFileSize int64    // Largest file read, in bytes (0 = unlimited)
+}
+
+// FileAccessError is returned for a file-path argument naming a file the file access
+// policy doesn't let the plugin read
+type FileAccessError struct {
+	Path   string
+	Reason string
+}
+
+func (e *FileAccessError) Error() string {
+	return fmt.Sprintf("access to file '%s' denied: %s", e.Path, e.Reason)
+}
+
+// SetFileAccessPolicy constrains the files file-path arguments read. A file is read only
+// if its path, with symlinks resolved, is within an allowed root and no denied root, so a
+// symlink can't escape the roots, and only if it is no larger than MaxFileSize. Other
+// files fail the run with a *FileAccessError. By default any file is read.
+func (pr *PluginRuntime) SetFileAccessPolicy(policy FileAccessPolicy) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.fileAccess = policy
+}
+
+// readFile returns the contents and info of the file at path if the policy permits
+// reading it
+func (p FileAccessPolicy) readFile(path string) ([]byte, os.FileInfo, error) {
+	resolved, err := p.resolve(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.Open(resolved)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.MaxFileSize > 0 && info.Size() > p.MaxFileSize {
+		return nil, nil, &FileAccessError{Path: path, Reason: fmt.Sprintf("%d bytes exceeds the limit of %d", info.Size(), p.MaxFileSize)}
+	}
+
+	reader := io.Reader(file)
+	if p.MaxFileSize > 0 {
+		// The file may grow after the check
+		reader = io.LimitReader(file, p.MaxFileSize+1)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.MaxFileSize > 0 && int64(len(data)) > p.MaxFileSize {
+		return nil, nil, &FileAccessError{Path: path, Reason: fmt.Sprintf("file exceeds the limit of %d bytes", p.MaxFileSize)}
+	}
+	return data, info, nil
+}
+
+// resolve returns path with symlinks resolved if it is within the policy's roots
+func (p FileAccessPolicy) resolve(path string) (string, error) {
+	if len(p.AllowRoots) == 0 && len(p.DenyRoots) == 0 {
+		return path, nil
+	}
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(absolute)
+	if err != nil {
+		return "", err
+	}
+
+	for _, root := range p.DenyRoots {
+		if withinRoot(resolved, resolveRoot(root)) {
+			return "", &FileAccessError{Path: path, Reason: fmt.Sprintf("'%s' is a denied directory", root)}
+		}
+	}
+	if len(p.AllowRoots) == 0 {
+		return resolved, nil
+	}
+	for _, root := range p.AllowRoots {
+		if withinRoot(resolved, resolveRoot(root)) {
+			return resolved, nil
+		}
+	}
+	for _, root := range p.AllowRoots {
+		if withinRoot(absolute, mustAbs(root)) || withinRoot(absolute, resolveRoot(root)) {
+			return "", &FileAccessError{Path: path, Reason: fmt.Sprintf("a symlink escapes the allowed directories to '%s'", resolved)}
+		}
+	}
+	return "", &FileAccessError{Path: path, Reason: "not in an allowed directory"}
+}
+
+// resolveRoot returns the absolute path of a policy root with symlinks resolved, as the
+// paths checked against it are; a root that doesn't exist is only made absolute
+func resolveRoot(root string) string {
+	absolute := mustAbs(root)
+	if resolved, err := filepath.EvalSymlinks(absolute); err == nil {
+		return resolved
+	}
+	return absolute
+}
+
+// mustAbs returns the absolute form of path, or path itself if the working directory is
+// unknown
+func mustAbs(path string) string {
+	if absolute, err := filepath.Abs(path); err == nil {
+		return absolute
+	}
+	return path
+}
+
+// withinRoot reports whether path is root or a path under it; both are clean and absolute
+func withinRoot(path, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
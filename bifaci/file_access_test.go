@@ -0,0 +1,82 @@
+package bifaci
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestFileAccessPolicy(t *testing.T) {
+	root := fileTree(t, "allowed/a.txt", "allowed/secret/s.txt", "outside/o.txt")
+	allowed := filepath.Join(root, "allowed")
+	path := func(name string) string {
+		return filepath.Join(root, filepath.FromSlash(name))
+	}
+	policy := FileAccessPolicy{
+		AllowRoots:  []string{allowed},
+		DenyRoots:   []string{filepath.Join(allowed, "secret")},
+		MaxFileSize: int64(len("allowed/a.txt")),
+	}
+
+	data, _, err := policy.readFile(path("allowed/a.txt"))
+	if err != nil || string(data) != "allowed/a.txt" {
+		t.Errorf("Expected the allowed file read, got %q %v", data, err)
+	}
+	for _, name := range []string{"outside/o.txt", "allowed/secret/s.txt", "allowed/../outside/o.txt"} {
+		var accessErr *FileAccessError
+		if _, _, err := policy.readFile(path(name)); !errors.As(err, &accessErr) {
+			t.Errorf("Expected %s denied, got %v", name, err)
+		}
+	}
+
+	policy.MaxFileSize = 4
+	var accessErr *FileAccessError
+	if _, _, err := policy.readFile(path("allowed/a.txt")); !errors.As(err, &accessErr) {
+		t.Errorf("Expected a file past the size limit denied, got %v", err)
+	}
+}
+
+// A symlink in an allowed root can't reach a file outside it
+func TestFileAccessSymlinkEscape(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Symlinks need privileges on Windows")
+	}
+	root := fileTree(t, "allowed/a.txt", "outside/o.txt")
+	link := filepath.Join(root, "allowed", "link.txt")
+	if err := os.Symlink(filepath.Join(root, "outside", "o.txt"), link); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	policy := FileAccessPolicy{AllowRoots: []string{filepath.Join(root, "allowed")}}
+
+	_, _, err := policy.readFile(link)
+	var accessErr *FileAccessError
+	if !errors.As(err, &accessErr) {
+		t.Fatalf("Expected the symlink escape denied, got %v", err)
+	}
+	if handlerErrorCode(err) != "PERMISSION_DENIED" {
+		t.Errorf("Expected PERMISSION_DENIED, got %s", handlerErrorCode(err))
+	}
+}
+
+// The runtime reads file-path arguments under its policy
+func TestReadFilePathsUnderPolicy(t *testing.T) {
+	root := fileTree(t, "allowed/a.txt", "outside/o.txt")
+	pr, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	pr.SetFileAccessPolicy(FileAccessPolicy{AllowRoots: []string{filepath.Join(root, "allowed")}})
+
+	if _, err := pr.readFilePathToBytes(filepath.Join(root, "allowed", "a.txt"), false); err != nil {
+		t.Errorf("Expected the allowed file read, got %v", err)
+	}
+	var accessErr *FileAccessError
+	if _, err := pr.readFilePathToBytes(filepath.Join(root, "outside", "o.txt"), false); !errors.As(err, &accessErr) {
+		t.Errorf("Expected the outside file denied, got %v", err)
+	}
+	if _, err := pr.readFilePaths(`["`+filepath.ToSlash(root)+`/**/*.txt"]`, true, true); !errors.As(err, &accessErr) {
+		t.Errorf("Expected an array reaching outside denied, got %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package bifaci
+
+import (
+	"fmt"
+	"time"
+)
+
+// MediaTagWithMeta is the media URN marker tag of a file-path argument whose files are
+// sent with their metadata, e.g. "media:file-path;textable;with-meta": each file as a
+// CBOR map {name, size, mtime, bytes} (see FileMeta) rather than its bytes. A handler
+// decodes it with Arg.File, or Arg.Files for a file-path-array argument.
+const MediaTagWithMeta = "with-meta"
+
+// FileMeta is a file sent with its metadata (see MediaTagWithMeta)
+type FileMeta struct {
+	Name  string `cbor:"name"`  // Path as given, or as found by expanding a file-path-array pattern
+	Size  int64  `cbor:"size"`  // Size in bytes
+	Mtime int64  `cbor:"mtime"` // Modification time, Unix seconds
+	Bytes []byte `cbor:"bytes"`
+}
+
+// ModTime returns the file's modification time
+func (f *FileMeta) ModTime() time.Time {
+	return time.Unix(f.Mtime, 0)
+}
+
+// readFileMeta reads the file at path with its metadata
+func readFileMeta(path string, policy FileAccessPolicy) (*FileMeta, error) {
+	data, info, err := policy.readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &FileMeta{Name: path, Size: int64(len(data)), Mtime: info.ModTime().Unix(), Bytes: data}, nil
+}
+
+// File decodes the argument as a file sent with its metadata (see MediaTagWithMeta)
+func (a *Arg) File() (*FileMeta, error) {
+	var file FileMeta
+	if err := a.DecodeCBOR(&file); err != nil {
+		return nil, fmt.Errorf("arg %s is not a file with metadata: %w", a.MediaUrn, err)
+	}
+	return &file, nil
+}
+
+// Files decodes the argument as the files of a file-path-array argument sent with their
+// metadata (see MediaTagWithMeta)
+func (a *Arg) Files() ([]FileMeta, error) {
+	var files []FileMeta
+	if err := a.DecodeCBOR(&files); err != nil {
+		return nil, fmt.Errorf("arg %s is not a list of files with metadata: %w", a.MediaUrn, err)
+	}
+	return files, nil
+}
+
+// File decodes the matching argument as a file sent with its metadata, see Arg.File
+func (s *ArgSet) File(pattern string) (*FileMeta, error) {
+	arg, err := s.Find(pattern)
+	if arg == nil || err != nil {
+		return nil, err
+	}
+	return arg.File()
+}
+
+// Files decodes the matching argument as files sent with their metadata, see Arg.Files
+func (s *ArgSet) Files(pattern string) ([]FileMeta, error) {
+	arg, err := s.Find(pattern)
+	if arg == nil || err != nil {
+		return nil, err
+	}
+	return arg.Files()
+}
@@ -0,0 +1,78 @@
+package bifaci
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/machinefabric/capdag-go/cap"
+)
+
+// A file-path argument tagged with-meta reaches the handler with its name, size and mtime
+func TestFilePathWithMeta(t *testing.T) {
+	capDef := createTestCap(
+		`cap:in="media:pdf";op=inspect;out="media:void"`,
+		"Inspect",
+		"inspect",
+		[]cap.CapArg{{
+			MediaUrn: "media:file-path;textable;with-meta",
+			Required: true,
+			Sources:  []cap.ArgSource{stdinSource("media:pdf"), positionSource(0)},
+		}},
+	)
+	manifest := createTestManifest("TestPlugin", "1.0.0", "Test", []*cap.Cap{capDef})
+	runtime, err := NewPluginRuntimeWithManifest(manifest)
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "report.pdf")
+	if err := os.WriteFile(path, []byte("%PDF-1.7"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	mtime := time.Unix(1700000000, 0)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatalf("Failed to set mtime: %v", err)
+	}
+
+	arguments, err := runtime.buildCLIArguments(&manifest.Caps[0], []string{path}, nil)
+	if err != nil {
+		t.Fatalf("buildCLIArguments failed: %v", err)
+	}
+	var file *FileMeta
+	handler := func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		args, err := CollectArgs(frames)
+		if err != nil {
+			return err
+		}
+		file, err = args.File("media:pdf")
+		return err
+	}
+	if err := runtime.invokeCLIArguments(handler, arguments, cliOutputOptions{}, io.Discard, io.Discard); err != nil {
+		t.Fatalf("Invocation failed: %v", err)
+	}
+	if file == nil || file.Name != path || file.Size != 8 || !file.ModTime().Equal(mtime) || string(file.Bytes) != "%PDF-1.7" {
+		t.Errorf("Expected the file with its metadata, got %+v", file)
+	}
+}
+
+func TestFilePathArrayWithMeta(t *testing.T) {
+	root := fileTree(t, "a.txt", "b.txt")
+	runtime := newRoutingRuntime(t)
+	patterns, _ := json.Marshal([]string{filepath.Join(root, "*.txt")})
+	result, err := runtime.readFilePaths(string(patterns), true, true)
+	if err != nil {
+		t.Fatalf("readFilePaths failed: %v", err)
+	}
+
+	files, err := (&Arg{MediaUrn: "media:", Data: result}).Files()
+	if err != nil {
+		t.Fatalf("Files failed: %v", err)
+	}
+	if len(files) != 2 || files[0].Name != filepath.Join(root, "a.txt") || files[1].Size != 5 || string(files[1].Bytes) != "b.txt" {
+		t.Errorf("Expected a.txt and b.txt with their metadata, got %+v", files)
+	}
+}
@@ -0,0 +1,227 @@
+package bifaci
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FileExpansion configures how the path patterns of a file-path-array argument expand
+// to files (see SetFileExpansion)
+type FileExpansion struct {
+	Recursive bool // A directory expands to the files of its subdirectories too
+	WithNames bool // Each file is sent as a map {"name": path, "bytes": contents} instead of its bytes
+}
+
+// SetFileExpansion sets how the path patterns of file-path-array arguments given on the
+// command line expand to files. A pattern is one of:
+//   - a file path
+//   - a directory path, expanding to the regular files in it (see FileExpansion.Recursive)
+//   - a glob as filepath.Glob takes it, with "**" as a path segment matching any number
+//     of directories, e.g. "src/**/*.go"; directories it matches are skipped
+//   - an exclusion "!" + glob, dropping the files the others matched that it matches:
+//     their base name for a glob without a separator, e.g. "!*.tmp", else their path
+//
+// Files are sent once each, sorted by path. By default directories expand to their own
+// files only, and files are sent as their bytes.
+func (pr *PluginRuntime) SetFileExpansion(expansion FileExpansion) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.fileExpansion = expansion
+}
+
+// expandFilePaths returns the files the path patterns of a file-path-array argument
+// expand to (see SetFileExpansion)
+func expandFilePaths(patterns []string, recursive bool) ([]string, error) {
+	var includes, excludes []string
+	for _, pattern := range patterns {
+		if exclude, ok := strings.CutPrefix(pattern, "!"); ok {
+			if err := checkGlob(exclude); err != nil {
+				return nil, err
+			}
+			excludes = append(excludes, exclude)
+		} else {
+			includes = append(includes, pattern)
+		}
+	}
+
+	found := make(map[string]bool)
+	for _, pattern := range includes {
+		// Check if this is a literal path (no glob metacharacters) or a glob pattern
+		if !containsAny(pattern, "*?[") {
+			info, err := os.Stat(pattern)
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil, fmt.Errorf(
+						"failed to read file '%s' from file-path-array: No such file or directory",
+						pattern,
+					)
+				}
+				return nil, fmt.Errorf(
+					"failed to read file '%s' from file-path-array: %w",
+					pattern, err,
+				)
+			}
+			if info.IsDir() {
+				if err := listDirFiles(pattern, recursive, found); err != nil {
+					return nil, err
+				}
+			} else if info.Mode().IsRegular() {
+				found[pattern] = true
+			}
+			continue
+		}
+
+		if err := checkGlob(pattern); err != nil {
+			return nil, err
+		}
+		if err := globFiles(pattern, found); err != nil {
+			return nil, err
+		}
+	}
+
+	files := make([]string, 0, len(found))
+	for path := range found {
+		if !excludedPath(path, excludes) {
+			files = append(files, path)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// checkGlob returns an error for a malformed glob pattern
+func checkGlob(pattern string) error {
+	for _, segment := range globSegments(pattern) {
+		if _, err := filepath.Match(segment, ""); err != nil {
+			return fmt.Errorf("invalid glob pattern '%s': %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// listDirFiles adds the regular files in dir to found, and those of its subdirectories
+// if recursive
+func listDirFiles(dir string, recursive bool, found map[string]bool) error {
+	if !recursive {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("failed to read directory '%s' from file-path-array: %w", dir, err)
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			if isRegularFile(path) {
+				found[path] = true
+			}
+		}
+		return nil
+	}
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return fmt.Errorf("failed to read directory '%s' from file-path-array: %w", dir, err)
+			}
+			return nil // Unreadable subdirectories are skipped
+		}
+		if !entry.IsDir() && isRegularFile(path) {
+			found[path] = true
+		}
+		return nil
+	})
+}
+
+// globFiles adds the regular files pattern matches to found
+func globFiles(pattern string, found map[string]bool) error {
+	segments := globSegments(pattern)
+	globstar := false
+	for _, segment := range segments {
+		globstar = globstar || segment == "**"
+	}
+	if !globstar {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid glob pattern '%s': %w", pattern, err)
+		}
+		for _, path := range matches {
+			// Only include files (skip directories)
+			if isRegularFile(path) {
+				found[path] = true
+			}
+		}
+		return nil
+	}
+
+	// Walk the directory the segments before the first glob name
+	literal := 0
+	for literal < len(segments) && !containsAny(segments[literal], "*?[") {
+		literal++
+	}
+	root := filepath.FromSlash(strings.Join(segments[:literal], "/"))
+	switch {
+	case literal == 0:
+		root = "."
+	case root == "":
+		root = string(filepath.Separator)
+	}
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // A missing root matches nothing, unreadable directories are skipped
+		}
+		if !entry.IsDir() && matchSegments(segments, globSegments(path)) && isRegularFile(path) {
+			found[path] = true
+		}
+		return nil
+	})
+}
+
+// excludedPath reports whether an exclusion pattern matches path: its base name for a
+// pattern without a separator, else the path
+func excludedPath(path string, excludes []string) bool {
+	for _, pattern := range excludes {
+		target := path
+		if !strings.ContainsAny(pattern, `/\`) {
+			target = filepath.Base(path)
+		}
+		if matchSegments(globSegments(pattern), globSegments(target)) {
+			return true
+		}
+	}
+	return false
+}
+
+// globSegments splits a path or glob pattern into its segments
+func globSegments(path string) []string {
+	return strings.Split(filepath.ToSlash(filepath.Clean(path)), "/")
+}
+
+// matchSegments reports whether path matches pattern segment by segment, as
+// filepath.Match matches them, a "**" segment matching any number of segments
+func matchSegments(pattern, path []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(path); i++ {
+				if matchSegments(pattern[1:], path[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(path) == 0 {
+			return false
+		}
+		if ok, _ := filepath.Match(pattern[0], path[0]); !ok {
+			return false
+		}
+		pattern, path = pattern[1:], path[1:]
+	}
+	return len(path) == 0
+}
+
+// isRegularFile reports whether path is a regular file, following symlinks
+func isRegularFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
@@ -0,0 +1,91 @@
+package bifaci
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+)
+
+// fileTree creates files under a temporary directory and returns it
+func fileTree(t *testing.T, files ...string) string {
+	t.Helper()
+	root := t.TempDir()
+	for _, name := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+	return root
+}
+
+func TestExpandFilePaths(t *testing.T) {
+	root := fileTree(t, "a.txt", "b.tmp", "sub/c.txt", "sub/deep/d.txt")
+	in := func(names ...string) []string {
+		paths := make([]string, len(names))
+		for i, name := range names {
+			paths[i] = filepath.Join(root, filepath.FromSlash(name))
+		}
+		return paths
+	}
+
+	cases := []struct {
+		name      string
+		patterns  []string
+		recursive bool
+		expected  []string
+	}{
+		{"globstar", in("**/*.txt"), false, in("a.txt", "sub/c.txt", "sub/deep/d.txt")},
+		{"globstar in the middle", in("sub/**/d.txt"), false, in("sub/deep/d.txt")},
+		{"directory", []string{root}, false, in("a.txt", "b.tmp")},
+		{"recursive directory", []string{root}, true, in("a.txt", "b.tmp", "sub/c.txt", "sub/deep/d.txt")},
+		{"excluded name", append([]string{root}, "!*.tmp"), false, in("a.txt")},
+		{"excluded path", append(in("**"), "!"+filepath.Join(root, "sub", "**")), false, in("a.txt", "b.tmp")},
+		{"duplicates", append(in("a.txt"), in("*.txt")...), false, in("a.txt")},
+		{"no matches", in("**/*.pdf"), false, []string{}},
+	}
+	for _, c := range cases {
+		files, err := expandFilePaths(c.patterns, c.recursive)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(files, c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, files)
+		}
+	}
+
+	if _, err := expandFilePaths([]string{root, "![invalid"}, false); err == nil {
+		t.Error("Expected an error for an invalid exclusion pattern")
+	}
+}
+
+// With names on, each file is sent as a map of its path and bytes
+func TestFilePathArrayWithNames(t *testing.T) {
+	root := fileTree(t, "b.txt", "a.txt")
+	runtime := newRoutingRuntime(t)
+	runtime.SetFileExpansion(FileExpansion{WithNames: true})
+
+	patterns, _ := json.Marshal([]string{root})
+	result, err := runtime.readFilePathToBytes(string(patterns), true)
+	if err != nil {
+		t.Fatalf("readFilePathToBytes failed: %v", err)
+	}
+	var files []struct {
+		Name  string `cbor:"name"`
+		Bytes []byte `cbor:"bytes"`
+	}
+	if err := cborlib.Unmarshal(result, &files); err != nil {
+		t.Fatalf("Failed to decode CBOR array: %v", err)
+	}
+	if len(files) != 2 || files[0].Name != filepath.Join(root, "a.txt") || string(files[0].Bytes) != "a.txt" || string(files[1].Bytes) != "b.txt" {
+		t.Errorf("Expected a.txt then b.txt with their names, got %+v", files)
+	}
+}
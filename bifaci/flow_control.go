@@ -0,0 +1,66 @@
+package bifaci
+
+import (
+	"context"
+	"sync"
+)
+
+// flowWindow is the sender side of per-request flow control.
+//
+// When a window was negotiated in HELLO (Limits.MaxWindow > 0), a sender may have at most
+// that many CHUNK frames of a request unacknowledged. Each ACK frame from the receiver
+// returns credit. A nil *flowWindow means flow control is disabled and never blocks.
+type flowWindow struct {
+	mu      sync.Mutex
+	credit  uint64
+	granted chan struct{} // Signalled when credit is added
+}
+
+// newFlowWindow creates a window with the negotiated initial credit, or nil if disabled.
+func newFlowWindow(maxWindow int) *flowWindow {
+	if maxWindow <= 0 {
+		return nil
+	}
+	return &flowWindow{
+		credit:  uint64(maxWindow),
+		granted: make(chan struct{}, 1),
+	}
+}
+
+// acquire takes one unit of credit, blocking until the receiver grants more
+// or the request is cancelled.
+func (w *flowWindow) acquire(ctx context.Context) error {
+	if w == nil {
+		return nil
+	}
+	for {
+		w.mu.Lock()
+		if w.credit > 0 {
+			w.credit--
+			w.mu.Unlock()
+			return nil
+		}
+		w.mu.Unlock()
+
+		select {
+		case <-w.granted:
+		case <-ctx.Done():
+			return ErrRequestCancelled
+		}
+	}
+}
+
+// grant adds credit from an ACK frame and wakes a blocked sender.
+func (w *flowWindow) grant(credit uint64) {
+	if w == nil || credit == 0 {
+		return
+	}
+	w.mu.Lock()
+	w.credit += credit
+	w.mu.Unlock()
+
+	select {
+	case w.granted <- struct{}{}:
+	default:
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -28,15 +29,21 @@ const (
 	FrameTypeHello FrameType = 0 // MUST be 0 - matches Rust
 	FrameTypeReq   FrameType = 1
 	// Res = 2 REMOVED - old single-response protocol no longer supported
-	FrameTypeChunk       FrameType = 3
-	FrameTypeEnd         FrameType = 4
-	FrameTypeLog         FrameType = 5 // MUST be 5 - matches Rust
-	FrameTypeErr         FrameType = 6 // MUST be 6 - matches Rust
-	FrameTypeHeartbeat   FrameType = 7
-	FrameTypeStreamStart FrameType = 8  // Announce new stream for a request (multiplexed streaming)
-	FrameTypeStreamEnd   FrameType = 9  // End a specific stream (multiplexed streaming)
-	FrameTypeRelayNotify FrameType = 10 // Relay capability advertisement (slave → master)
-	FrameTypeRelayState  FrameType = 11 // Relay host system resources + cap demands (master → slave)
+	FrameTypeChunk           FrameType = 3
+	FrameTypeEnd             FrameType = 4
+	FrameTypeLog             FrameType = 5 // MUST be 5 - matches Rust
+	FrameTypeErr             FrameType = 6 // MUST be 6 - matches Rust
+	FrameTypeHeartbeat       FrameType = 7
+	FrameTypeStreamStart     FrameType = 8  // Announce new stream for a request (multiplexed streaming)
+	FrameTypeStreamEnd       FrameType = 9  // End a specific stream (multiplexed streaming)
+	FrameTypeRelayNotify     FrameType = 10 // Relay capability advertisement (slave → master)
+	FrameTypeRelayState      FrameType = 11 // Relay host system resources + cap demands (master → slave)
+	FrameTypeCancel          FrameType = 12 // Abort an in-flight request (either direction)
+	FrameTypeAck             FrameType = 13 // Grant flow-control credit for a request (receiver → sender)
+	FrameTypeManifestUpdated FrameType = 14 // Plugin manifest changed after the handshake (plugin → host)
+	FrameTypeSessionClose    FrameType = 15 // End a session and drop its state (host → plugin)
+	FrameTypeAccepted        FrameType = 16 // Request accepted as an async job (plugin → host)
+	FrameTypeNotify          FrameType = 17 // Outcome of a scheduled cap run, sent unasked (plugin → host)
 )
 
 // String returns the frame type name
@@ -65,6 +72,18 @@ func (ft FrameType) String() string {
 		return "RELAY_NOTIFY"
 	case FrameTypeRelayState:
 		return "RELAY_STATE"
+	case FrameTypeCancel:
+		return "CANCEL"
+	case FrameTypeAck:
+		return "ACK"
+	case FrameTypeManifestUpdated:
+		return "MANIFEST_UPDATED"
+	case FrameTypeSessionClose:
+		return "SESSION_CLOSE"
+	case FrameTypeAccepted:
+		return "ACCEPTED"
+	case FrameTypeNotify:
+		return "NOTIFY"
 	default:
 		return fmt.Sprintf("UNKNOWN(%d)", ft)
 	}
@@ -175,7 +194,9 @@ type Frame struct {
 	RoutingId   *MessageId             // Routing ID for relay (optional)
 	ChunkIndex  *uint64                // Chunk index within stream (REQUIRED for CHUNK frames)
 	ChunkCount  *uint64                // Total chunk count (REQUIRED for STREAM_END frames)
-	Checksum    *uint64                // Payload checksum (FNV-1a hash, REQUIRED for CHUNK frames)
+	Checksum    *uint64                // Payload checksum (FNV-1a hash unless negotiated otherwise, REQUIRED for CHUNK frames)
+
+	checksumAlg ChecksumAlgorithm // Algorithm of Checksum, set by the reader of a connection that negotiated one ("" = FNV-1a)
 }
 
 // New creates a new frame with required fields (matches Rust Frame::new)
@@ -281,11 +302,78 @@ func NewLog(id MessageId, level string, message string) *Frame {
 	return frame
 }
 
+// NewLogWithAttrs creates a LOG frame carrying structured key/value attributes
+// in meta "attrs" next to level and message, so hosts can index plugin logs.
+func NewLogWithAttrs(id MessageId, level string, message string, attrs map[string]interface{}) *Frame {
+	frame := NewLog(id, level, message)
+	if len(attrs) > 0 {
+		frame.Meta["attrs"] = attrs
+	}
+	return frame
+}
+
 // NewHeartbeat creates a HEARTBEAT frame (matches Rust Frame::heartbeat)
 func NewHeartbeat(id MessageId) *Frame {
 	return newFrame(FrameTypeHeartbeat, id)
 }
 
+// NewCancel creates a CANCEL frame asking the receiver to abort the request with the given ID.
+// The receiver answers with a terminal ERR (code CANCELLED) if the request was still in flight.
+func NewCancel(id MessageId) *Frame {
+	return newFrame(FrameTypeCancel, id)
+}
+
+// NewAck creates an ACK frame granting the sender credit for more CHUNK frames of a request.
+// Only meaningful when a flow-control window was negotiated in HELLO (max_window).
+func NewAck(id MessageId, credit uint64) *Frame {
+	frame := newFrame(FrameTypeAck, id)
+	frame.Meta = map[string]interface{}{
+		"credit": credit,
+	}
+	return frame
+}
+
+// NewManifestUpdated creates a MANIFEST_UPDATED frame carrying the plugin's whole current
+// manifest, sent when caps are added or removed after the handshake.
+func NewManifestUpdated(manifest []byte) *Frame {
+	frame := newFrame(FrameTypeManifestUpdated, MessageId{uintValue: new(uint64)})
+	frame.Meta = map[string]interface{}{
+		"manifest": manifest,
+	}
+	return frame
+}
+
+// NewSessionClose creates a SESSION_CLOSE frame ending the session with the given ID:
+// the plugin drops the state its handlers kept for the session (see Session).
+func NewSessionClose(sessionId string) *Frame {
+	frame := newFrame(FrameTypeSessionClose, MessageId{uintValue: new(uint64)})
+	frame.Meta = map[string]interface{}{
+		"session_id": sessionId,
+	}
+	return frame
+}
+
+// NewAccepted creates an ACCEPTED frame telling the requester that its request runs on
+// as the async job with the given ID, whose outcome it fetches with the job caps (see
+// PluginRuntime.RegisterAsync). The request's END follows right away.
+func NewAccepted(id MessageId, jobId string) *Frame {
+	frame := newFrame(FrameTypeAccepted, id)
+	frame.Meta = map[string]interface{}{
+		"job_id": jobId,
+	}
+	return frame
+}
+
+// NewNotify creates a NOTIFY frame, which the plugin sends unasked with the outcome of a
+// scheduled run of capUrn (see PluginRuntime.Schedule): the payload is the CBOR-encoded
+// Notification. The ID is the run's own; no response follows.
+func NewNotify(id MessageId, capUrn string, payload []byte) *Frame {
+	frame := newFrame(FrameTypeNotify, id)
+	frame.Cap = &capUrn
+	frame.Payload = payload
+	return frame
+}
+
 // NewHello creates a HELLO frame for handshake (host side - no manifest)
 // Matches Rust Frame::hello
 func NewHello(maxFrame, maxChunk, maxReorderBuffer int) *Frame {
@@ -380,6 +468,183 @@ func (f *Frame) LogMessage() string {
 	return ""
 }
 
+// LogAttrs gets the structured attributes from LOG frame meta (nil if none)
+func (f *Frame) LogAttrs() map[string]interface{} {
+	if f.FrameType != FrameTypeLog || f.Meta == nil {
+		return nil
+	}
+	attrs, _ := normalizeMetaValue(f.Meta["attrs"]).(map[string]interface{})
+	return attrs
+}
+
+// TraceContext returns the W3C trace context carried in meta "traceparent"/"tracestate"
+// (REQ and HELLO frames). ok is false if the frame carries none.
+func (f *Frame) TraceContext() (TraceContext, bool) {
+	if f.Meta == nil {
+		return TraceContext{}, false
+	}
+	parent, _ := f.Meta["traceparent"].(string)
+	if parent == "" {
+		return TraceContext{}, false
+	}
+	state, _ := f.Meta["tracestate"].(string)
+	return TraceContext{TraceParent: parent, TraceState: state}, true
+}
+
+// SetTraceContext stores tc in meta so the receiver can link its spans to the sender's.
+// A zero tc is ignored.
+func (f *Frame) SetTraceContext(tc TraceContext) {
+	if tc.IsZero() {
+		return
+	}
+	if f.Meta == nil {
+		f.Meta = make(map[string]interface{})
+	}
+	f.Meta["traceparent"] = tc.TraceParent
+	if tc.TraceState != "" {
+		f.Meta["tracestate"] = tc.TraceState
+	}
+}
+
+// Deadline returns the time the sender allows for a REQ, from meta "deadline_ms".
+// The deadline is relative (remaining milliseconds when the REQ was sent) so it does
+// not depend on the two sides' clocks agreeing.
+func (f *Frame) Deadline() (time.Duration, bool) {
+	if f.Meta == nil {
+		return 0, false
+	}
+	if _, ok := f.Meta["deadline_ms"]; !ok {
+		return 0, false
+	}
+	return time.Duration(extractIntFromMeta(f.Meta, "deadline_ms")) * time.Millisecond, true
+}
+
+// SetDeadline stores the remaining time for a REQ in meta "deadline_ms"
+func (f *Frame) SetDeadline(remaining time.Duration) {
+	if f.Meta == nil {
+		f.Meta = make(map[string]interface{})
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+	f.Meta["deadline_ms"] = uint64(remaining / time.Millisecond)
+}
+
+// IdempotencyKey returns the key of a REQ in meta "idempotency_key": REQs carrying the
+// same key are attempts at the same request, which the receiver may answer once
+func (f *Frame) IdempotencyKey() string {
+	if f.Meta == nil {
+		return ""
+	}
+	key, _ := f.Meta["idempotency_key"].(string)
+	return key
+}
+
+// SetIdempotencyKey stores the idempotency key of a REQ in meta "idempotency_key"
+func (f *Frame) SetIdempotencyKey(key string) {
+	if f.Meta == nil {
+		f.Meta = make(map[string]interface{})
+	}
+	f.Meta["idempotency_key"] = key
+}
+
+// SessionId returns the session a REQ belongs to, or a SESSION_CLOSE ends, from meta
+// "session_id" ("" if none)
+func (f *Frame) SessionId() string {
+	if f.Meta == nil {
+		return ""
+	}
+	id, _ := f.Meta["session_id"].(string)
+	return id
+}
+
+// JobId returns the job ID of an ACCEPTED frame, "" for other frames
+func (f *Frame) JobId() string {
+	if f.Meta == nil {
+		return ""
+	}
+	id, _ := f.Meta["job_id"].(string)
+	return id
+}
+
+// SetSessionId makes a REQ part of a session in meta "session_id": the plugin keeps
+// state for the session across its requests until SESSION_CLOSE (see Session)
+func (f *Frame) SetSessionId(sessionId string) {
+	if f.Meta == nil {
+		f.Meta = make(map[string]interface{})
+	}
+	f.Meta["session_id"] = sessionId
+}
+
+// Env returns the environment variables a HELLO or REQ carries in meta "env", which
+// fill arguments with an env source (see cap.ArgSource). Non-string values are skipped.
+func (f *Frame) Env() map[string]string {
+	if f.Meta == nil {
+		return nil
+	}
+	switch vars := normalizeMetaValue(f.Meta["env"]).(type) {
+	case map[string]string:
+		return vars
+	case map[string]interface{}:
+		env := make(map[string]string, len(vars))
+		for name, value := range vars {
+			if s, ok := value.(string); ok {
+				env[name] = s
+			}
+		}
+		return env
+	}
+	return nil
+}
+
+// SetEnv stores environment variables for arguments with an env source in meta "env"
+func (f *Frame) SetEnv(env map[string]string) {
+	if f.Meta == nil {
+		f.Meta = make(map[string]interface{})
+	}
+	f.Meta["env"] = env
+}
+
+// normalizeMetaValue converts decoded CBOR maps (map[interface{}]interface{}) to string-keyed maps
+func normalizeMetaValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			if ks, ok := k.(string); ok {
+				m[ks] = normalizeMetaValue(item)
+			}
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			m[k] = normalizeMetaValue(item)
+		}
+		return m
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = normalizeMetaValue(item)
+		}
+		return items
+	default:
+		return value
+	}
+}
+
+// AckCredit gets the granted credit from ACK frame meta
+func (f *Frame) AckCredit() uint64 {
+	if f.FrameType != FrameTypeAck || f.Meta == nil {
+		return 0
+	}
+	credit := extractIntFromMeta(f.Meta, "credit")
+	if credit <= 0 {
+		return 0
+	}
+	return uint64(credit)
+}
+
 // RelayNotifyManifest extracts manifest bytes from RelayNotify metadata.
 // Returns nil if not a RelayNotify frame or no manifest present.
 func (f *Frame) RelayNotifyManifest() []byte {
@@ -392,6 +657,18 @@ func (f *Frame) RelayNotifyManifest() []byte {
 	return nil
 }
 
+// UpdatedManifest extracts the manifest bytes from MANIFEST_UPDATED metadata.
+// Returns nil if not a MANIFEST_UPDATED frame or no manifest present.
+func (f *Frame) UpdatedManifest() []byte {
+	if f.FrameType != FrameTypeManifestUpdated || f.Meta == nil {
+		return nil
+	}
+	if manifest, ok := f.Meta["manifest"].([]byte); ok {
+		return manifest
+	}
+	return nil
+}
+
 // RelayNotifyLimits extracts Limits from RelayNotify metadata.
 // Returns nil if not a RelayNotify frame or limits are missing.
 func (f *Frame) RelayNotifyLimits() *Limits {
@@ -444,9 +721,13 @@ func ComputeChecksum(data []byte) uint64 {
 	return hash
 }
 
-// VerifyChunkChecksum verifies a CHUNK frame's checksum matches its payload.
+// VerifyChunkChecksum verifies a CHUNK frame's checksum matches its payload, under the
+// algorithm negotiated on the connection the frame was read from.
 // Returns nil if valid, error if checksum missing or mismatched.
 func VerifyChunkChecksum(frame *Frame) error {
+	if frame.checksumAlg.normalize() != ChecksumFNV1a64 {
+		return VerifyChunkChecksumWith(frame, frame.checksumAlg)
+	}
 	if frame.Checksum == nil {
 		return fmt.Errorf("CHUNK frame missing required checksum field")
 	}
@@ -471,11 +752,12 @@ func (f *Frame) IsEof() bool {
 }
 
 // IsFlowFrame returns true if this frame type participates in flow ordering (seq tracking).
-// Non-flow frames (Hello, Heartbeat, RelayNotify, RelayState) bypass seq assignment
-// and reorder buffers entirely. (matches Rust Frame::is_flow_frame)
+// Non-flow frames (Hello, Heartbeat, RelayNotify, RelayState, Cancel, Ack, ManifestUpdated, SessionClose, Notify) bypass seq assignment
+// and reorder buffers entirely. CANCEL and ACK are control frames: they must be able to overtake
+// frames still queued for the flow they refer to. (matches Rust Frame::is_flow_frame)
 func (f *Frame) IsFlowFrame() bool {
 	switch f.FrameType {
-	case FrameTypeHello, FrameTypeHeartbeat, FrameTypeRelayNotify, FrameTypeRelayState:
+	case FrameTypeHello, FrameTypeHeartbeat, FrameTypeRelayNotify, FrameTypeRelayState, FrameTypeCancel, FrameTypeAck, FrameTypeManifestUpdated, FrameTypeSessionClose, FrameTypeNotify:
 		return false
 	default:
 		return true
@@ -99,6 +99,7 @@ func CollectArgsByMediaUrn(frames <-chan Frame, mediaUrnPattern string) ([]inter
 // Returns raw bytes (concatenated chunks from first stream).
 //
 // This is useful for handlers that accept a single argument of any type.
+// To process the argument as it arrives instead, read it through a StreamDecoder.
 func CollectFirstArg(frames <-chan Frame) ([]byte, error) {
 	var firstStreamID string
 	var chunks [][]byte
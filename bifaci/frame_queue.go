@@ -0,0 +1,193 @@
+package bifaci
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// DefaultMaxRequestMemory is the default number of payload bytes of not yet consumed input
+// kept in memory per request. Beyond it, input frames spill to a temporary file.
+const DefaultMaxRequestMemory int = 64 * 1024 * 1024
+
+// frameQueue decouples the runtime's read loop from a running handler.
+//
+// The read loop pushes frames without ever blocking, so a slow handler cannot stall
+// heartbeats or other requests; pump delivers them to the handler in order.
+// Once more than maxMemory payload bytes are queued, further frames are appended to a
+// spill file and read back when the handler catches up, so a large argument never has
+// to live in RAM.
+type frameQueue struct {
+	mu        sync.Mutex
+	frames    []Frame
+	memBytes  int    // Payload bytes held in frames
+	maxMemory int    // 0 = never spill
+	spillDir  string // "" = os.TempDir()
+	logger    Logger
+	closed    bool          // No more frames will be pushed
+	stopped   bool          // pump exited - further pushes are dropped
+	ready     chan struct{} // Signalled when frames are pushed or the queue is closed
+
+	// Spill state: while spill != nil every push goes to the file to keep order
+	spill        *os.File
+	spillWriter  *FrameWriter
+	spillReader  *FrameReader
+	spillReadFd  *os.File
+	spillPending int // Frames written to the spill file but not yet read back
+}
+
+func newFrameQueue(maxMemory int, spillDir string, logger Logger) *frameQueue {
+	return &frameQueue{
+		maxMemory: maxMemory,
+		spillDir:  spillDir,
+		logger:    logger,
+		ready:     make(chan struct{}, 1),
+	}
+}
+
+// push appends a frame. Never blocks on the consumer.
+// An error means the spill file could not be written and the input is incomplete;
+// the request must be aborted.
+func (q *frameQueue) push(frame Frame) error {
+	q.mu.Lock()
+	defer q.signal()
+	defer q.mu.Unlock()
+
+	if q.closed || q.stopped {
+		return nil
+	}
+
+	if q.spill == nil && q.maxMemory > 0 && q.memBytes+len(frame.Payload) > q.maxMemory {
+		if err := q.createSpillLocked(); err != nil {
+			// Keep the request alive at the cost of memory
+			q.logger.Warn("spilling input failed - buffering in memory", "error", err)
+		}
+	}
+
+	if q.spill != nil {
+		if err := q.spillWriter.WriteFrame(&frame); err != nil {
+			return fmt.Errorf("failed to write spill file: %w", err)
+		}
+		q.spillPending++
+		return nil
+	}
+
+	q.frames = append(q.frames, frame)
+	q.memBytes += len(frame.Payload)
+	return nil
+}
+
+// createSpillLocked creates the spill file (caller must hold mu).
+func (q *frameQueue) createSpillLocked() error {
+	file, err := os.CreateTemp(q.spillDir, "bifaci-spill-*")
+	if err != nil {
+		return fmt.Errorf("failed to create spill file: %w", err)
+	}
+	readFd, err := os.Open(file.Name())
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return fmt.Errorf("failed to open spill file: %w", err)
+	}
+	// Spilled frames already passed the negotiated limits - only the hard limit applies
+	spillLimits := Limits{MaxFrame: MaxFrameHardLimit, MaxChunk: MaxFrameHardLimit}
+	q.spill = file
+	q.spillWriter = NewFrameWriter(file)
+	q.spillWriter.SetLimits(spillLimits)
+	q.spillReadFd = readFd
+	q.spillReader = NewFrameReader(readFd)
+	q.spillReader.SetLimits(spillLimits)
+	return nil
+}
+
+// removeSpillLocked deletes the spill file (caller must hold mu).
+func (q *frameQueue) removeSpillLocked() {
+	if q.spill == nil {
+		return
+	}
+	q.spillReadFd.Close()
+	q.spill.Close()
+	os.Remove(q.spill.Name())
+	q.spill, q.spillWriter, q.spillReader, q.spillReadFd = nil, nil, nil, nil
+	q.spillPending = 0
+}
+
+// close marks the end of input; pump returns once the queued frames are delivered.
+func (q *frameQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *frameQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pump delivers queued frames through send until the queue is closed and drained,
+// send reports false (the request was cancelled) or ctx is done.
+// In-memory frames are always older than spilled ones, so they are delivered first.
+func (q *frameQueue) pump(ctx context.Context, send func(*Frame) bool) {
+	defer func() {
+		q.mu.Lock()
+		q.stopped = true
+		q.frames = nil
+		q.memBytes = 0
+		q.removeSpillLocked()
+		q.mu.Unlock()
+	}()
+
+	for {
+		q.mu.Lock()
+		batch := q.frames
+		q.frames = nil
+		q.memBytes = 0
+		var spillReader *FrameReader
+		if len(batch) == 0 {
+			if q.spillPending > 0 {
+				// Written completely under mu, so it can be read back without holding it
+				q.spillPending--
+				spillReader = q.spillReader
+			} else {
+				// Caught up with the spill file - back to memory
+				q.removeSpillLocked()
+			}
+		}
+		closed := q.closed
+		q.mu.Unlock()
+
+		if spillReader != nil {
+			frame, err := spillReader.ReadFrame()
+			if err != nil {
+				q.logger.Error("failed to read spilled input frame", "error", err)
+				return
+			}
+			if !send(frame) {
+				return
+			}
+			continue
+		}
+
+		for i := range batch {
+			if !send(&batch[i]) {
+				return
+			}
+		}
+		if len(batch) > 0 {
+			continue
+		}
+		if closed {
+			return
+		}
+
+		select {
+		case <-q.ready:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
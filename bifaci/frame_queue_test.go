@@ -0,0 +1,67 @@
+package bifaci
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+// TestFrameQueueSpillRoundtrip: frames beyond maxMemory go to a spill file, come back
+// in push order, and the spill file is removed once the queue is drained
+func TestFrameQueueSpillRoundtrip(t *testing.T) {
+	spillDir := t.TempDir()
+	queue := newFrameQueue(8, spillDir, defaultLogger())
+	id := NewMessageIdRandom()
+
+	const count = 10
+	for i := uint64(0); i < count; i++ {
+		payload := []byte{byte(i), byte(i), byte(i), byte(i)}
+		if err := queue.push(*NewChunk(id, "s1", i, payload, i, ComputeChecksum(payload))); err != nil {
+			t.Fatalf("push %d failed: %v", i, err)
+		}
+	}
+
+	entries, _ := os.ReadDir(spillDir)
+	if len(entries) != 1 {
+		t.Fatalf("Expected one spill file, found %d", len(entries))
+	}
+
+	queue.close()
+	var received []*Frame
+	queue.pump(context.Background(), func(f *Frame) bool {
+		received = append(received, f)
+		return true
+	})
+
+	if len(received) != count {
+		t.Fatalf("Expected %d frames, got %d", count, len(received))
+	}
+	for i, frame := range received {
+		if frame.Seq != uint64(i) || frame.Payload[0] != byte(i) {
+			t.Errorf("Frame %d out of order: seq=%d payload=%v", i, frame.Seq, frame.Payload)
+		}
+	}
+	entries, _ = os.ReadDir(spillDir)
+	if len(entries) != 0 {
+		t.Errorf("Spill file not removed: %d entries left", len(entries))
+	}
+}
+
+// TestFrameQueueStopsOnCancel: pump returns when the consumer refuses frames,
+// and later pushes are dropped
+func TestFrameQueueStopsOnCancel(t *testing.T) {
+	queue := newFrameQueue(0, "", defaultLogger())
+	id := NewMessageIdRandom()
+	queue.push(*NewEnd(id, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	queue.pump(ctx, func(f *Frame) bool { return false })
+
+	if err := queue.push(*NewEnd(id, nil)); err != nil {
+		t.Errorf("push after stop must be a no-op, got %v", err)
+	}
+	if len(queue.frames) != 0 {
+		t.Errorf("Expected no queued frames after stop, got %d", len(queue.frames))
+	}
+}
@@ -47,9 +47,15 @@ func Test172_frame_type_valid_range(t *testing.T) {
 		9:  true,  // STREAM_END
 		10: true,  // RELAY_NOTIFY
 		11: true,  // RELAY_STATE
+		12: true,  // CANCEL
+		13: true,  // ACK
+		14: true,  // MANIFEST_UPDATED
+		15: true,  // SESSION_CLOSE
+		16: true,  // ACCEPTED
+		17: true,  // NOTIFY
 	}
 
-	for i := uint8(0); i <= 11; i++ {
+	for i := uint8(0); i <= 17; i++ {
 		if expected, exists := validTypes[i]; exists && expected {
 			ft := FrameType(i)
 			if ft.String() == fmt.Sprintf("UNKNOWN(%d)", i) {
@@ -57,10 +63,10 @@ func Test172_frame_type_valid_range(t *testing.T) {
 			}
 		}
 	}
-	// 12 is one past RelayState — must be invalid
-	ft12 := FrameType(12)
-	if ft12.String() != "UNKNOWN(12)" {
-		t.Errorf("Expected 12 to be invalid, got %s", ft12.String())
+	// 18 is one past Notify — must be invalid
+	ft18 := FrameType(18)
+	if ft18.String() != "UNKNOWN(18)" {
+		t.Errorf("Expected 18 to be invalid, got %s", ft18.String())
 	}
 }
 
@@ -767,11 +773,36 @@ func Test402_relay_state_factory_and_payload(t *testing.T) {
 	}
 }
 
-// TEST403: FrameType from value 12 is invalid (one past RelayState)
-func Test403_frame_type_one_past_relay_state(t *testing.T) {
-	ft := FrameType(12)
-	if ft.String() != fmt.Sprintf("UNKNOWN(%d)", 12) {
-		t.Errorf("FrameType(12) must be unknown, got %s", ft.String())
+// TEST403: FrameType from value 18 is invalid (one past Notify)
+func Test403_frame_type_one_past_notify(t *testing.T) {
+	ft := FrameType(18)
+	if ft.String() != fmt.Sprintf("UNKNOWN(%d)", 18) {
+		t.Errorf("FrameType(18) must be unknown, got %s", ft.String())
+	}
+}
+
+// TestCancelFrame: Frame::cancel carries only the request ID and is not a flow frame
+func TestCancelFrame(t *testing.T) {
+	id := NewMessageIdRandom()
+	frame := NewCancel(id)
+
+	if frame.FrameType != FrameTypeCancel {
+		t.Errorf("Expected CANCEL, got %v", frame.FrameType)
+	}
+	if uint8(FrameTypeCancel) != 12 {
+		t.Errorf("CANCEL must be 12, got %d", FrameTypeCancel)
+	}
+	if FrameTypeCancel.String() != "CANCEL" {
+		t.Errorf("Expected name CANCEL, got %s", FrameTypeCancel.String())
+	}
+	if !frame.Id.Equals(id) {
+		t.Error("CANCEL must carry the request ID it aborts")
+	}
+	if frame.Payload != nil || frame.Meta != nil {
+		t.Error("CANCEL must have no payload or meta")
+	}
+	if frame.IsFlowFrame() {
+		t.Error("CANCEL must bypass flow ordering so it can overtake queued frames")
 	}
 }
 
@@ -812,3 +843,58 @@ func Test667_verify_chunk_checksum_detects_corruption(t *testing.T) {
 		t.Errorf("Error should mention missing checksum, got: %v", err)
 	}
 }
+
+// TestAckFrame: Frame::ack carries the granted credit and is not a flow frame
+func TestAckFrame(t *testing.T) {
+	id := NewMessageIdRandom()
+	frame := NewAck(id, 5)
+
+	if frame.FrameType != FrameTypeAck {
+		t.Errorf("Expected ACK, got %v", frame.FrameType)
+	}
+	if uint8(FrameTypeAck) != 13 {
+		t.Errorf("ACK must be 13, got %d", FrameTypeAck)
+	}
+	if FrameTypeAck.String() != "ACK" {
+		t.Errorf("Expected name ACK, got %s", FrameTypeAck.String())
+	}
+	if !frame.Id.Equals(id) {
+		t.Error("ACK must carry the request ID it grants credit for")
+	}
+	if frame.AckCredit() != 5 {
+		t.Errorf("Expected credit 5, got %d", frame.AckCredit())
+	}
+	if frame.IsFlowFrame() {
+		t.Error("ACK must bypass flow ordering so it can overtake queued frames")
+	}
+	if NewCancel(id).AckCredit() != 0 {
+		t.Error("AckCredit must be 0 for non-ACK frames")
+	}
+}
+
+// TestLogFrameAttrsRoundtrip: LOG attributes survive encoding with string keys
+func TestLogFrameAttrsRoundtrip(t *testing.T) {
+	id := NewMessageIdRandom()
+	frame := NewLogWithAttrs(id, "warn", "slow page", map[string]interface{}{
+		"page":   2,
+		"timing": map[string]interface{}{"ms": 1500},
+	})
+
+	encoded, err := EncodeFrame(frame)
+	if err != nil {
+		t.Fatalf("EncodeFrame failed: %v", err)
+	}
+	decoded, err := DecodeFrame(encoded)
+	if err != nil {
+		t.Fatalf("DecodeFrame failed: %v", err)
+	}
+
+	attrs := decoded.LogAttrs()
+	timing, ok := attrs["timing"].(map[string]interface{})
+	if decoded.LogLevel() != "warn" || attrs["page"] != uint64(2) || !ok || timing["ms"] != uint64(1500) {
+		t.Errorf("Unexpected attrs after roundtrip: %v", attrs)
+	}
+	if NewLog(id, "info", "plain").LogAttrs() != nil {
+		t.Error("LOG frame without attrs must report nil")
+	}
+}
@@ -0,0 +1,425 @@
+// Package grpcbridge exposes the caps of a PluginRuntime as a gRPC service.
+//
+// Every cap with a command becomes a bidirectional-streaming method of the
+// bifaci.v1.Caps service (command "extract-text" → method ExtractText) exchanging
+// CapFrame messages, so consumers can call plugins with any gRPC client instead of
+// speaking the CBOR frame protocol. ProtoFile returns the matching .proto definition.
+//
+// The bridge speaks the gRPC wire protocol directly on net/http, so it needs an
+// HTTP/2 server: TLS, or cleartext HTTP/2 enabled via http.Server.Protocols.
+// Messages must be uncompressed.
+package grpcbridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/machinefabric/capdag-go/bifaci"
+)
+
+// ServiceName is the fully-qualified gRPC service the caps are served under.
+const ServiceName = "bifaci.v1.Caps"
+
+// gRPC status codes used by the bridge
+const (
+	codeOK                 = 0
+	codeCancelled          = 1
+	codeUnknown            = 2
+	codeInvalidArgument    = 3
+	codeDeadlineExceeded   = 4
+	codeNotFound           = 5
+	codePermissionDenied   = 7
+	codeResourceExhausted  = 8
+	codeFailedPrecondition = 9
+	codeUnimplemented      = 12
+	codeInternal           = 13
+	codeUnavailable        = 14
+)
+
+// Bridge is an http.Handler serving a PluginRuntime's caps over gRPC. Methods are
+// resolved against the runtime's current manifest on each call, so caps added or
+// removed with AddCap and RemoveCap are served without rebuilding the bridge.
+type Bridge struct {
+	runtime  *bifaci.PluginRuntime
+	maxFrame int
+}
+
+// methodTable maps gRPC method names to the caps of a manifest
+type methodTable struct {
+	urns   map[string]string // gRPC method name → cap URN
+	titles map[string]string // gRPC method name → cap title (for ProtoFile)
+}
+
+// newMethodTable maps the caps of manifest with a command to their methods. When two
+// caps map to the same method the first keeps it, and an error names both.
+func newMethodTable(manifest *bifaci.CapManifest) (*methodTable, error) {
+	table := &methodTable{urns: make(map[string]string), titles: make(map[string]string)}
+	if manifest == nil {
+		return table, nil
+	}
+	var conflict error
+	for i := range manifest.Caps {
+		capDef := &manifest.Caps[i]
+		if capDef.Command == "" {
+			continue
+		}
+		method := MethodName(capDef.Command)
+		if existing, dup := table.urns[method]; dup {
+			if conflict == nil {
+				conflict = fmt.Errorf("caps %s and %s both map to gRPC method %s", existing, capDef.UrnString(), method)
+			}
+			continue
+		}
+		table.urns[method] = capDef.UrnString()
+		table.titles[method] = capDef.Title
+	}
+	return table, conflict
+}
+
+// New creates a bridge for the caps in the runtime's manifest.
+func New(runtime *bifaci.PluginRuntime) (*Bridge, error) {
+	manifest := runtime.Manifest()
+	if manifest == nil {
+		return nil, fmt.Errorf("runtime has no parsed manifest")
+	}
+	if _, err := newMethodTable(manifest); err != nil {
+		return nil, err
+	}
+	return &Bridge{runtime: runtime, maxFrame: bifaci.DefaultLimits().MaxFrame}, nil
+}
+
+// methods returns the method table of the runtime's current manifest. A cap added later
+// whose method is taken already isn't served.
+func (b *Bridge) methods() *methodTable {
+	table, _ := newMethodTable(b.runtime.Manifest())
+	return table
+}
+
+// MethodName converts a cap command into a gRPC method name ("extract-text" → "ExtractText").
+func MethodName(command string) string {
+	var sb strings.Builder
+	upper := true
+	for _, r := range command {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		sb.WriteRune(r)
+	}
+	name := sb.String()
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "Cap" + name
+	}
+	return name
+}
+
+// Methods returns the served gRPC method names, sorted.
+func (b *Bridge) Methods() []string {
+	return b.methods().names()
+}
+
+// names returns the method names of the table, sorted
+func (t *methodTable) names() []string {
+	methods := make([]string, 0, len(t.urns))
+	for method := range t.urns {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// ProtoFile returns the .proto definition of the service, for generating clients.
+func (b *Bridge) ProtoFile() string {
+	var sb strings.Builder
+	sb.WriteString(`syntax = "proto3";
+
+package bifaci.v1;
+
+// One message of a cap invocation. Requests send STREAM_START, CHUNK... and
+// STREAM_END per argument, then close the stream. Responses have the same shape
+// plus LOG messages. CHUNK payloads are complete CBOR values.
+message CapFrame {
+  enum Kind {
+    STREAM_START = 0;
+    CHUNK = 1;
+    STREAM_END = 2;
+    LOG = 3;
+  }
+  Kind kind = 1;
+  string stream_id = 2;
+  string media_urn = 3;
+  bytes payload = 4;
+  string log_level = 5;
+  string log_message = 6;
+}
+
+service Caps {
+`)
+	table := b.methods()
+	for _, method := range table.names() {
+		fmt.Fprintf(&sb, "  // %s\n  // %s\n", table.titles[method], table.urns[method])
+		fmt.Fprintf(&sb, "  rpc %s(stream CapFrame) returns (stream CapFrame);\n", method)
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// ServeHTTP handles one gRPC call.
+func (b *Bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 {
+		http.Error(w, "gRPC requires HTTP/2", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "not a gRPC request", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	code, message := b.serveCall(r, w, flusher)
+
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", encodeGrpcMessage(message))
+	}
+}
+
+// serveCall runs the call against the runtime and returns the gRPC status.
+func (b *Bridge) serveCall(r *http.Request, w http.ResponseWriter, flusher http.Flusher) (int, string) {
+	method := strings.TrimPrefix(r.URL.Path, "/"+ServiceName+"/")
+	capUrn, ok := b.methods().urns[method]
+	if !ok || method == r.URL.Path {
+		return codeUnimplemented, fmt.Sprintf("unknown method %s", r.URL.Path)
+	}
+
+	// Each call gets its own in-memory connection to the runtime; the bridge acts as the host
+	pluginSide, hostSide := net.Pipe()
+	defer hostSide.Close()
+	go b.runtime.RunConn(pluginSide)
+
+	reader := bifaci.NewFrameReader(hostSide)
+	rawWriter := bifaci.NewFrameWriter(hostSide)
+	_, limits, err := bifaci.HandshakeInitiate(reader, rawWriter)
+	if err != nil {
+		return codeUnavailable, fmt.Sprintf("plugin handshake failed: %v", err)
+	}
+	reader.SetLimits(limits)
+	rawWriter.SetLimits(limits)
+	writer := &lockedWriter{writer: rawWriter}
+
+	reqId := bifaci.NewMessageIdRandom()
+	if err := writer.WriteFrame(bifaci.NewReq(reqId, capUrn, nil, "application/cbor")); err != nil {
+		return codeUnavailable, fmt.Sprintf("failed to send request: %v", err)
+	}
+
+	// Request input is forwarded concurrently - the response may start before it ends
+	var inputMu sync.Mutex
+	var inputErr error
+	abort := func(err error) {
+		inputMu.Lock()
+		if inputErr == nil {
+			inputErr = err
+		}
+		inputMu.Unlock()
+		writer.WriteFrame(bifaci.NewCancel(reqId))
+	}
+	go func() {
+		if err := b.forwardInput(r, writer, reqId, limits); err != nil {
+			abort(err)
+		}
+	}()
+
+	// Client went away: cancel the request in the plugin
+	stop := context.AfterFunc(r.Context(), func() {
+		writer.WriteFrame(bifaci.NewCancel(reqId))
+	})
+	defer stop()
+
+	for {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			return codeUnavailable, fmt.Sprintf("plugin connection failed: %v", err)
+		}
+
+		switch frame.FrameType {
+		case bifaci.FrameTypeHeartbeat:
+			writer.WriteFrame(bifaci.NewHeartbeat(frame.Id))
+			continue
+		case bifaci.FrameTypeReq:
+			// Peer invocation - the bridge has no other caps to route to
+			errFrame := bifaci.NewErr(frame.Id, "NO_HANDLER", "gRPC bridge does not serve peer invocations")
+			writer.WriteFrame(errFrame)
+			continue
+		}
+		if !frame.Id.Equals(reqId) {
+			continue
+		}
+
+		var out *CapFrame
+		switch frame.FrameType {
+		case bifaci.FrameTypeStreamStart:
+			out = &CapFrame{Kind: KindStreamStart, StreamId: derefString(frame.StreamId), MediaUrn: derefString(frame.MediaUrn)}
+		case bifaci.FrameTypeChunk:
+			if err := bifaci.VerifyChunkChecksum(frame); err != nil {
+				writer.WriteFrame(bifaci.NewCancel(reqId))
+				return codeInternal, fmt.Sprintf("corrupted response data: %v", err)
+			}
+			out = &CapFrame{Kind: KindChunk, StreamId: derefString(frame.StreamId), Payload: frame.Payload}
+		case bifaci.FrameTypeStreamEnd:
+			out = &CapFrame{Kind: KindStreamEnd, StreamId: derefString(frame.StreamId)}
+		case bifaci.FrameTypeLog:
+			out = &CapFrame{Kind: KindLog, LogLevel: frame.LogLevel(), LogMessage: frame.LogMessage()}
+		case bifaci.FrameTypeEnd:
+			return codeOK, ""
+		case bifaci.FrameTypeErr:
+			inputMu.Lock()
+			invalidInput := inputErr
+			inputMu.Unlock()
+			if invalidInput != nil {
+				return codeInvalidArgument, invalidInput.Error()
+			}
+			return statusForError(bifaci.ErrCode(frame.ErrorCode())), fmt.Sprintf("[%s] %s", frame.ErrorCode(), frame.ErrorMessage())
+		default:
+			continue
+		}
+
+		if err := writeMessage(w, out.Marshal()); err != nil {
+			writer.WriteFrame(bifaci.NewCancel(reqId))
+			return codeCancelled, fmt.Sprintf("failed to write response: %v", err)
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// forwardInput translates the request's CapFrame messages into argument streams and sends END
+// when the client closes its side.
+func (b *Bridge) forwardInput(r *http.Request, writer *lockedWriter, reqId bifaci.MessageId, limits bifaci.Limits) error {
+	chunkCounts := make(map[string]uint64) // open streams → chunks sent
+
+	for {
+		message, err := readMessage(r.Body, b.maxFrame)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				if len(chunkCounts) > 0 {
+					return fmt.Errorf("request ended with %d unterminated streams", len(chunkCounts))
+				}
+				return writer.WriteFrame(bifaci.NewEnd(reqId, nil))
+			}
+			return err
+		}
+		in, err := UnmarshalCapFrame(message)
+		if err != nil {
+			return err
+		}
+		if in.StreamId == "" {
+			return fmt.Errorf("CapFrame without stream_id")
+		}
+
+		var frame *bifaci.Frame
+		switch in.Kind {
+		case KindStreamStart:
+			if in.MediaUrn == "" {
+				return fmt.Errorf("STREAM_START for %s without media_urn", in.StreamId)
+			}
+			if _, dup := chunkCounts[in.StreamId]; dup {
+				return fmt.Errorf("duplicate stream_id %s", in.StreamId)
+			}
+			chunkCounts[in.StreamId] = 0
+			frame = bifaci.NewStreamStart(reqId, in.StreamId, in.MediaUrn)
+		case KindChunk:
+			index, open := chunkCounts[in.StreamId]
+			if !open {
+				return fmt.Errorf("CHUNK for unknown stream_id %s", in.StreamId)
+			}
+			if len(in.Payload) > limits.MaxChunk {
+				return fmt.Errorf("CHUNK payload of %d bytes exceeds max_chunk %d", len(in.Payload), limits.MaxChunk)
+			}
+			chunkCounts[in.StreamId]++
+			frame = bifaci.NewChunk(reqId, in.StreamId, index, in.Payload, index, bifaci.ComputeChecksum(in.Payload))
+		case KindStreamEnd:
+			count, open := chunkCounts[in.StreamId]
+			if !open {
+				return fmt.Errorf("STREAM_END for unknown stream_id %s", in.StreamId)
+			}
+			delete(chunkCounts, in.StreamId)
+			frame = bifaci.NewStreamEnd(reqId, in.StreamId, count)
+		default:
+			return fmt.Errorf("unexpected CapFrame kind %d in request", in.Kind)
+		}
+
+		if err := writer.WriteFrame(frame); err != nil {
+			return err
+		}
+	}
+}
+
+// statusForError maps an ERR frame code to a gRPC status code. Codes it doesn't know,
+// like a handler's own, are unknown errors.
+func statusForError(code bifaci.ErrCode) int {
+	switch code {
+	case bifaci.ErrCodeCancelled:
+		return codeCancelled
+	case bifaci.ErrCodeNoHandler:
+		return codeUnimplemented
+	case bifaci.ErrCodeInvalidArgument, bifaci.ErrCodeInvalidRequest, bifaci.ErrCodeValidation, bifaci.ErrCodeProtocol, bifaci.ErrCodeCorruptedData:
+		return codeInvalidArgument
+	case bifaci.ErrCodePermissionDenied:
+		return codePermissionDenied
+	case bifaci.ErrCodeBusy, bifaci.ErrCodeResourceExhausted:
+		return codeResourceExhausted
+	case bifaci.ErrCodeDuplicateRequest, bifaci.ErrCodeJobRunning:
+		return codeFailedPrecondition
+	case bifaci.ErrCodeJobNotFound:
+		return codeNotFound
+	case bifaci.ErrCodeTimeout:
+		return codeDeadlineExceeded
+	case bifaci.ErrCodePluginDied, bifaci.ErrCodeSpawnFailed, bifaci.ErrCodeStartFailed:
+		return codeUnavailable
+	case bifaci.ErrCodeInvalidOutput, bifaci.ErrCodeRouteConflict:
+		return codeInternal
+	case bifaci.ErrCodeHandler, bifaci.ErrCodeProcessFailed:
+		return codeUnknown
+	default:
+		return codeUnknown
+	}
+}
+
+// lockedWriter serializes frames from the input forwarder and the response loop.
+type lockedWriter struct {
+	mu     sync.Mutex
+	writer *bifaci.FrameWriter
+}
+
+func (lw *lockedWriter) WriteFrame(frame *bifaci.Frame) error {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.writer.WriteFrame(frame)
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
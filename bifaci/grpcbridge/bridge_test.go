@@ -0,0 +1,247 @@
+package grpcbridge
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+
+	"github.com/machinefabric/capdag-go/bifaci"
+	"github.com/machinefabric/capdag-go/cap"
+	"github.com/machinefabric/capdag-go/urn"
+)
+
+const testManifest = `{"name":"TestPlugin","version":"1.0.0","description":"Test plugin","caps":[{"urn":"cap:in=\"media:void\";op=test;out=\"media:void\"","title":"Test","command":"echo-text"}]}`
+
+const testCap = `cap:in="media:void";op=test;out="media:void"`
+
+// startBridge serves an echo runtime over HTTP/2 (TLS) and returns the server
+func startBridge(t *testing.T) *httptest.Server {
+	t.Helper()
+	runtime, err := bifaci.NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.Register(testCap, func(frames <-chan bifaci.Frame, emitter bifaci.StreamEmitter, peer bifaci.PeerInvoker) error {
+		for frame := range frames {
+			if frame.FrameType == bifaci.FrameTypeChunk {
+				var text string
+				if err := cborlib.Unmarshal(frame.Payload, &text); err != nil {
+					return err
+				}
+				if text == "fail" {
+					return errors.New("asked to fail")
+				}
+				if err := emitter.EmitCbor("echo:" + text); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return serveBridge(t, runtime)
+}
+
+// serveBridge serves a bridge for runtime over HTTP/2 (TLS) and returns the server
+func serveBridge(t *testing.T, runtime *bifaci.PluginRuntime) *httptest.Server {
+	t.Helper()
+	bridge, err := New(runtime)
+	if err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+	server := httptest.NewUnstartedServer(bridge)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// callBridge sends the messages as one gRPC call and returns the response messages and trailers
+func callBridge(t *testing.T, server *httptest.Server, method string, messages ...*CapFrame) ([]*CapFrame, http.Header) {
+	t.Helper()
+	var body bytes.Buffer
+	for _, m := range messages {
+		writeMessage(&body, m.Marshal())
+	}
+	request, _ := http.NewRequest(http.MethodPost, server.URL+"/"+ServiceName+"/"+method, &body)
+	request.Header.Set("Content-Type", "application/grpc")
+	request.Header.Set("TE", "trailers")
+
+	response, err := server.Client().Do(request)
+	if err != nil {
+		t.Fatalf("gRPC call failed: %v", err)
+	}
+	defer response.Body.Close()
+	if response.ProtoMajor != 2 {
+		t.Fatalf("Expected HTTP/2, got %s", response.Proto)
+	}
+
+	var frames []*CapFrame
+	for {
+		message, err := readMessage(response.Body, 1<<20)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read response message: %v", err)
+		}
+		frame, err := UnmarshalCapFrame(message)
+		if err != nil {
+			t.Fatalf("Failed to decode response message: %v", err)
+		}
+		frames = append(frames, frame)
+	}
+	return frames, response.Trailer
+}
+
+func textChunk(streamId, text string) *CapFrame {
+	payload, _ := cborlib.Marshal(text)
+	return &CapFrame{Kind: KindChunk, StreamId: streamId, Payload: payload}
+}
+
+// TestBridgeCallsCap: a gRPC call is translated into a request and the response streamed back
+func TestBridgeCallsCap(t *testing.T) {
+	server := startBridge(t)
+
+	frames, trailer := callBridge(t, server, "EchoText",
+		&CapFrame{Kind: KindStreamStart, StreamId: "arg-0", MediaUrn: "media:"},
+		textChunk("arg-0", "hello"),
+		&CapFrame{Kind: KindStreamEnd, StreamId: "arg-0"},
+	)
+
+	if status := trailer.Get("Grpc-Status"); status != "0" {
+		t.Fatalf("Expected grpc-status 0, got %q (%s)", status, trailer.Get("Grpc-Message"))
+	}
+	var texts []string
+	for _, frame := range frames {
+		if frame.Kind == KindChunk {
+			var text string
+			cborlib.Unmarshal(frame.Payload, &text)
+			texts = append(texts, text)
+		}
+	}
+	if len(texts) != 1 || texts[0] != "echo:hello" {
+		t.Errorf("Expected [echo:hello], got %v", texts)
+	}
+	if frames[0].Kind != KindStreamStart || frames[len(frames)-1].Kind != KindStreamEnd {
+		t.Errorf("Expected response framed by STREAM_START/STREAM_END, got %v ... %v", frames[0].Kind, frames[len(frames)-1].Kind)
+	}
+}
+
+// TestBridgeErrorStatus: handler errors, invalid input and unknown methods map to gRPC statuses
+func TestBridgeErrorStatus(t *testing.T) {
+	server := startBridge(t)
+
+	_, trailer := callBridge(t, server, "EchoText",
+		&CapFrame{Kind: KindStreamStart, StreamId: "arg-0", MediaUrn: "media:"},
+		textChunk("arg-0", "fail"),
+		&CapFrame{Kind: KindStreamEnd, StreamId: "arg-0"},
+	)
+	if trailer.Get("Grpc-Status") != "2" || !strings.Contains(trailer.Get("Grpc-Message"), "asked to fail") {
+		t.Errorf("Expected UNKNOWN with handler message, got %q %q", trailer.Get("Grpc-Status"), trailer.Get("Grpc-Message"))
+	}
+
+	_, trailer = callBridge(t, server, "EchoText", textChunk("never-started", "x"))
+	if trailer.Get("Grpc-Status") != "3" {
+		t.Errorf("Expected INVALID_ARGUMENT for a chunk without STREAM_START, got %q %q", trailer.Get("Grpc-Status"), trailer.Get("Grpc-Message"))
+	}
+
+	_, trailer = callBridge(t, server, "Missing")
+	if trailer.Get("Grpc-Status") != "12" {
+		t.Errorf("Expected UNIMPLEMENTED for an unknown method, got %q", trailer.Get("Grpc-Status"))
+	}
+}
+
+// TestBridgeFollowsManifest: caps added or removed after the bridge was created are
+// served or rejected without rebuilding it
+func TestBridgeFollowsManifest(t *testing.T) {
+	runtime, err := bifaci.NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	server := serveBridge(t, runtime)
+
+	const addedCap = `cap:in="media:void";op=generate;out="media:void"`
+	capUrn, err := urn.NewCapUrnFromString(addedCap)
+	if err != nil {
+		t.Fatalf("Failed to parse cap URN: %v", err)
+	}
+	err = runtime.AddCap(*cap.NewCap(capUrn, "Generate", "generate"), func(frames <-chan bifaci.Frame, emitter bifaci.StreamEmitter, peer bifaci.PeerInvoker) error {
+		for range frames {
+		}
+		return emitter.EmitCbor("generated")
+	})
+	if err != nil {
+		t.Fatalf("Failed to add cap: %v", err)
+	}
+
+	frames, trailer := callBridge(t, server, "Generate")
+	if trailer.Get("Grpc-Status") != "0" || len(frames) == 0 {
+		t.Errorf("Expected the added cap to be served, got %q %q", trailer.Get("Grpc-Status"), trailer.Get("Grpc-Message"))
+	}
+
+	if err := runtime.RemoveCap(addedCap); err != nil {
+		t.Fatalf("Failed to remove cap: %v", err)
+	}
+	_, trailer = callBridge(t, server, "Generate")
+	if trailer.Get("Grpc-Status") != "12" {
+		t.Errorf("Expected UNIMPLEMENTED for the removed cap, got %q", trailer.Get("Grpc-Status"))
+	}
+}
+
+// TestStatusForError: every ERR code maps to the gRPC status of its class
+func TestStatusForError(t *testing.T) {
+	cases := map[bifaci.ErrCode]int{
+		bifaci.ErrCodeValidation:        codeInvalidArgument,
+		bifaci.ErrCodeInvalidRequest:    codeInvalidArgument,
+		bifaci.ErrCodePermissionDenied:  codePermissionDenied,
+		bifaci.ErrCodeResourceExhausted: codeResourceExhausted,
+		bifaci.ErrCodeDuplicateRequest:  codeFailedPrecondition,
+		bifaci.ErrCodeJobRunning:        codeFailedPrecondition,
+		bifaci.ErrCodeJobNotFound:       codeNotFound,
+		bifaci.ErrCodePluginDied:        codeUnavailable,
+		bifaci.ErrCodeTimeout:           codeDeadlineExceeded,
+		bifaci.ErrCodeHandler:           codeUnknown,
+		"SOMETHING_ELSE":                codeUnknown,
+	}
+	for code, expected := range cases {
+		if got := statusForError(code); got != expected {
+			t.Errorf("statusForError(%s) = %d, expected %d", code, got, expected)
+		}
+	}
+}
+
+// TestCapFrameRoundtrip: protobuf encoding roundtrips and unknown fields are skipped
+func TestCapFrameRoundtrip(t *testing.T) {
+	original := &CapFrame{Kind: KindLog, StreamId: "s", MediaUrn: "media:", Payload: []byte{1, 2}, LogLevel: "info", LogMessage: "hi"}
+	// Unknown varint field 15 appended
+	encoded := append(original.Marshal(), 15<<3|wireVarint, 7)
+
+	decoded, err := UnmarshalCapFrame(encoded)
+	if err != nil {
+		t.Fatalf("UnmarshalCapFrame failed: %v", err)
+	}
+	if decoded.Kind != original.Kind || decoded.StreamId != original.StreamId || decoded.MediaUrn != original.MediaUrn ||
+		!bytes.Equal(decoded.Payload, original.Payload) || decoded.LogLevel != original.LogLevel || decoded.LogMessage != original.LogMessage {
+		t.Errorf("Roundtrip mismatch: %+v vs %+v", decoded, original)
+	}
+
+	if _, err := UnmarshalCapFrame([]byte{fieldStreamId<<3 | wireBytes, 10, 'x'}); err == nil {
+		t.Error("Truncated field must be rejected")
+	}
+}
+
+// TestMethodName: commands become exported gRPC method names
+func TestMethodName(t *testing.T) {
+	cases := map[string]string{"extract-text": "ExtractText", "to_png": "ToPng", "3d": "Cap3d", "": "Cap"}
+	for command, expected := range cases {
+		if got := MethodName(command); got != expected {
+			t.Errorf("MethodName(%q) = %q, expected %q", command, got, expected)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package grpcbridge
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Kind identifies what a CapFrame carries.
+type Kind uint64
+
+const (
+	KindStreamStart Kind = 0
+	KindChunk       Kind = 1
+	KindStreamEnd   Kind = 2
+	KindLog         Kind = 3
+)
+
+// CapFrame is the gRPC message exchanged in both directions of a cap method.
+// It is encoded as the protobuf message described by ProtoFile.
+//
+// Requests are sent as STREAM_START / CHUNK... / STREAM_END per argument; closing
+// the request stream ends the request. Responses use the same shape, plus LOG messages.
+// CHUNK payloads are complete CBOR values, exactly as in the frame protocol.
+type CapFrame struct {
+	Kind       Kind
+	StreamId   string
+	MediaUrn   string
+	Payload    []byte
+	LogLevel   string
+	LogMessage string
+}
+
+// Protobuf field numbers of CapFrame
+const (
+	fieldKind       = 1
+	fieldStreamId   = 2
+	fieldMediaUrn   = 3
+	fieldPayload    = 4
+	fieldLogLevel   = 5
+	fieldLogMessage = 6
+)
+
+// Protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// Marshal encodes the frame as protobuf (proto3: default values are omitted).
+func (f *CapFrame) Marshal() []byte {
+	var buf []byte
+	if f.Kind != KindStreamStart {
+		buf = binary.AppendUvarint(buf, fieldKind<<3|wireVarint)
+		buf = binary.AppendUvarint(buf, uint64(f.Kind))
+	}
+	appendBytes := func(field uint64, value []byte) {
+		if len(value) == 0 {
+			return
+		}
+		buf = binary.AppendUvarint(buf, field<<3|wireBytes)
+		buf = binary.AppendUvarint(buf, uint64(len(value)))
+		buf = append(buf, value...)
+	}
+	appendBytes(fieldStreamId, []byte(f.StreamId))
+	appendBytes(fieldMediaUrn, []byte(f.MediaUrn))
+	appendBytes(fieldPayload, f.Payload)
+	appendBytes(fieldLogLevel, []byte(f.LogLevel))
+	appendBytes(fieldLogMessage, []byte(f.LogMessage))
+	return buf
+}
+
+// UnmarshalCapFrame decodes a protobuf CapFrame. Unknown fields are skipped.
+func UnmarshalCapFrame(data []byte) (*CapFrame, error) {
+	frame := &CapFrame{}
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid protobuf tag")
+		}
+		data = data[n:]
+		field, wireType := tag>>3, tag&0x7
+
+		switch wireType {
+		case wireVarint:
+			value, n := binary.Uvarint(data)
+			if n <= 0 {
+				return nil, fmt.Errorf("invalid protobuf varint for field %d", field)
+			}
+			data = data[n:]
+			if field == fieldKind {
+				frame.Kind = Kind(value)
+			}
+		case wireBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return nil, fmt.Errorf("truncated protobuf field %d", field)
+			}
+			value := data[n : n+int(length)]
+			data = data[n+int(length):]
+			switch field {
+			case fieldStreamId:
+				frame.StreamId = string(value)
+			case fieldMediaUrn:
+				frame.MediaUrn = string(value)
+			case fieldPayload:
+				frame.Payload = append([]byte(nil), value...)
+			case fieldLogLevel:
+				frame.LogLevel = string(value)
+			case fieldLogMessage:
+				frame.LogMessage = string(value)
+			}
+		case wireFixed64:
+			if len(data) < 8 {
+				return nil, fmt.Errorf("truncated protobuf field %d", field)
+			}
+			data = data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return nil, fmt.Errorf("truncated protobuf field %d", field)
+			}
+			data = data[4:]
+		default:
+			return nil, fmt.Errorf("unsupported protobuf wire type %d", wireType)
+		}
+	}
+	return frame, nil
+}
+
+// readMessage reads one length-prefixed gRPC message (1-byte compressed flag, 4-byte length).
+func readMessage(r io.Reader, maxSize int) ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if header[0] != 0 {
+		return nil, fmt.Errorf("compressed gRPC messages are not supported")
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if int(length) > maxSize {
+		return nil, fmt.Errorf("gRPC message size %d exceeds limit %d", length, maxSize)
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, fmt.Errorf("truncated gRPC message: %w", err)
+	}
+	return message, nil
+}
+
+// writeMessage writes one uncompressed length-prefixed gRPC message.
+func writeMessage(w io.Writer, message []byte) error {
+	buf := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(message)))
+	_, err := w.Write(append(buf, message...))
+	return err
+}
+
+// encodeGrpcMessage percent-encodes a status message for the grpc-message trailer.
+func encodeGrpcMessage(message string) string {
+	var sb strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c < 0x20 || c > 0x7E || c == '%' {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		} else {
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
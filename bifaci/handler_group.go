@@ -0,0 +1,132 @@
+package bifaci
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/machinefabric/capdag-go/urn"
+)
+
+// HandlerGroup is a set of handlers, e.g. those of one package, registered apart from
+// the runtime and added to it with Mount. Its middleware wraps only its own handlers.
+type HandlerGroup struct {
+	constraint *urn.CapUrn // nil = any cap
+	handlers   map[string]HandlerFunc
+	patterns   []patternRoute
+	middleware []Middleware
+	mu         sync.Mutex
+}
+
+// NewHandlerGroup creates a handler group. A non-empty constraint is a cap URN pattern
+// every cap registered in the group must satisfy, e.g. "cap:type=imaging" for a group
+// of imaging caps; Mount rejects the group otherwise.
+func NewHandlerGroup(constraint string) (*HandlerGroup, error) {
+	group := &HandlerGroup{handlers: make(map[string]HandlerFunc)}
+	if constraint != "" {
+		constraintUrn, err := urn.NewCapUrnFromString(constraint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid group constraint %q: %w", constraint, err)
+		}
+		group.constraint = constraintUrn
+	}
+	return group, nil
+}
+
+// Register registers a handler for a cap URN in the group (see PluginRuntime.Register)
+func (g *HandlerGroup) Register(capUrn string, handler HandlerFunc) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.handlers[capUrn] = handler
+}
+
+// RegisterPattern registers a handler for a cap URN pattern in the group (see
+// PluginRuntime.RegisterPattern)
+func (g *HandlerGroup) RegisterPattern(pattern string, handler HandlerFunc) error {
+	patternUrn, err := urn.NewCapUrnFromString(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid cap URN pattern %q: %w", pattern, err)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, route := range g.patterns {
+		if route.urn.Equals(patternUrn) {
+			return fmt.Errorf("cap URN pattern %q is already registered as %q", pattern, route.pattern)
+		}
+	}
+	g.patterns = append(g.patterns, patternRoute{pattern: pattern, urn: patternUrn, handler: handler})
+	return nil
+}
+
+// Use adds middleware wrapping the group's handlers, inside the runtime's middleware.
+// The first middleware added is the outermost.
+func (g *HandlerGroup) Use(middleware ...Middleware) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.middleware = append(g.middleware, middleware...)
+}
+
+// wrap wraps handler in the group's middleware. Caller holds g.mu.
+func (g *HandlerGroup) wrap(handler HandlerFunc) HandlerFunc {
+	for i := len(g.middleware) - 1; i >= 0; i-- {
+		handler = g.middleware[i](handler)
+	}
+	return handler
+}
+
+// Mount adds the handlers of group to the runtime's routing table, wrapped in the
+// group's middleware. Nothing is added, and an error listing every problem returned,
+// if a registration doesn't satisfy the group's constraint or conflicts with the
+// runtime's: an exact URN, or a pattern, equal to one already registered. Handlers and
+// middleware added to the group after Mount have no effect on the runtime.
+func (pr *PluginRuntime) Mount(group *HandlerGroup) error {
+	group.mu.Lock()
+	defer group.mu.Unlock()
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	var problems []string
+	exact := make(map[string]HandlerFunc, len(group.handlers))
+	for capUrn, handler := range group.handlers {
+		parsed, err := urn.NewCapUrnFromString(capUrn)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("invalid cap URN %q: %v", capUrn, err))
+			continue
+		}
+		if group.constraint != nil && !group.constraint.Accepts(parsed) {
+			problems = append(problems, fmt.Sprintf("cap %s doesn't satisfy the group constraint %s", capUrn, group.constraint))
+			continue
+		}
+		for registered := range pr.handlers {
+			if registeredUrn, err := urn.NewCapUrnFromString(registered); err == nil && registeredUrn.Equals(parsed) {
+				problems = append(problems, fmt.Sprintf("cap %s is already registered as %s", capUrn, registered))
+			}
+		}
+		exact[capUrn] = group.wrap(handler)
+	}
+
+	patterns := make([]patternRoute, 0, len(group.patterns))
+	for _, route := range group.patterns {
+		if group.constraint != nil && !group.constraint.Accepts(route.urn) {
+			problems = append(problems, fmt.Sprintf("pattern %s doesn't satisfy the group constraint %s", route.pattern, group.constraint))
+			continue
+		}
+		for _, registered := range pr.patterns {
+			if registered.urn.Equals(route.urn) {
+				problems = append(problems, fmt.Sprintf("pattern %s is already registered as %s", route.pattern, registered.pattern))
+			}
+		}
+		patterns = append(patterns, patternRoute{pattern: route.pattern, urn: route.urn, handler: group.wrap(route.handler)})
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("cannot mount handler group: %s", strings.Join(problems, "; "))
+	}
+	for capUrn, handler := range exact {
+		pr.handlers[capUrn] = handler
+	}
+	pr.patterns = append(pr.patterns, patterns...)
+	return nil
+}
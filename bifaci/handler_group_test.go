@@ -0,0 +1,78 @@
+package bifaci
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMountHandlerGroup(t *testing.T) {
+	runtime := newRoutingRuntime(t)
+	var calls []string
+	runtime.Use(func(next HandlerFunc) HandlerFunc {
+		return func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+			calls = append(calls, "runtime")
+			return next(frames, emitter, peer)
+		}
+	})
+
+	group, err := NewHandlerGroup("cap:type=imaging")
+	if err != nil {
+		t.Fatalf("NewHandlerGroup failed: %v", err)
+	}
+	group.Use(func(next HandlerFunc) HandlerFunc {
+		return func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+			calls = append(calls, "group")
+			return next(frames, emitter, peer)
+		}
+	})
+	const resize = `cap:in="media:image;bytes";op=resize;out="media:image;bytes";type=imaging`
+	group.Register(resize, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		calls = append(calls, "handler")
+		return nil
+	})
+	if err := group.RegisterPattern(`cap:op=thumbnail;type=imaging`, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		return nil
+	}); err != nil {
+		t.Fatalf("RegisterPattern failed: %v", err)
+	}
+
+	if err := runtime.Mount(group); err != nil {
+		t.Fatalf("Mount failed: %v", err)
+	}
+	handler := runtime.FindHandler(resize)
+	if handler == nil {
+		t.Fatal("Expected the group's handler to be routed to")
+	}
+	handler(nil, nil, nil)
+	if strings.Join(calls, ",") != "runtime,group,handler" {
+		t.Errorf("Expected runtime then group middleware, got %v", calls)
+	}
+	if runtime.FindHandler(`cap:in="media:image;bytes";op=thumbnail;out="media:image;bytes";type=imaging`) == nil {
+		t.Error("Expected the group's pattern route to be routed to")
+	}
+
+	// Mounting the same routes again conflicts, and adds nothing
+	again, _ := NewHandlerGroup("")
+	again.Register(`cap:op=resize;type=imaging;in="media:image;bytes";out="media:image;bytes"`, handler)
+	again.Register(`cap:in="media:void";op=fresh;out="media:void"`, handler)
+	if err := runtime.Mount(again); err == nil || !strings.Contains(err.Error(), "already registered") {
+		t.Errorf("Expected a conflict error, got %v", err)
+	}
+	if _, fresh, _ := runtime.findHandler(`cap:in="media:void";op=fresh;out="media:void"`); fresh != nil {
+		t.Error("Expected a rejected group to add nothing")
+	}
+}
+
+func TestMountChecksGroupConstraint(t *testing.T) {
+	runtime := newRoutingRuntime(t)
+	group, _ := NewHandlerGroup("cap:type=imaging")
+	group.Register(`cap:in="media:void";op=speak;out="media:void";type=audio`, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		return nil
+	})
+	if err := runtime.Mount(group); err == nil || !strings.Contains(err.Error(), "group constraint") {
+		t.Errorf("Expected a constraint error, got %v", err)
+	}
+	if _, err := NewHandlerGroup("not a urn"); err == nil {
+		t.Error("Expected an error for an invalid constraint")
+	}
+}
@@ -0,0 +1,139 @@
+package bifaci
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/machinefabric/capdag-go/standard"
+	"github.com/machinefabric/capdag-go/urn"
+)
+
+// HealthCheck reports whether a part of the plugin works, nil if it does
+// (see AddHealthCheck)
+type HealthCheck func() error
+
+// HealthReport is what the health cap (standard.CapHealth) answers with, as a CBOR map
+type HealthReport struct {
+	Healthy        bool              `cbor:"healthy"`         // All health checks passed
+	UptimeMs       int64             `cbor:"uptime_ms"`       // Since the runtime was created
+	ActiveHandlers int               `cbor:"active_handlers"` // Including the health request's own
+	Limits         Limits            `cbor:"limits"`          // Negotiated with the host
+	PeerRequests   int               `cbor:"peer_requests"`   // Sent to the host and not yet answered
+	LastError      *HealthError      `cbor:"last_error"`      // nil if no request failed
+	Checks         map[string]string `cbor:"checks"`          // Check name → "ok" or its error
+}
+
+// HealthError is the last request a handler answered with ERR
+type HealthError struct {
+	Cap  string `cbor:"cap"`
+	Code string `cbor:"code"`
+	At   int64  `cbor:"at"` // Unix milliseconds
+}
+
+// healthState is what the runtime tracks for its health report
+type healthState struct {
+	mu        sync.Mutex
+	since     time.Time
+	active    int
+	lastError *HealthError
+	checks    map[string]HealthCheck
+}
+
+func newHealthState() *healthState {
+	return &healthState{since: time.Now(), checks: make(map[string]HealthCheck)}
+}
+
+// handlerStarted counts a running handler
+func (h *healthState) handlerStarted() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.active++
+}
+
+// handlerFinished uncounts a handler, remembering its ERR code ("" on success)
+func (h *healthState) handlerFinished(capUrn, errCode string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.active--
+	if errCode != "" {
+		h.lastError = &HealthError{Cap: capUrn, Code: errCode, At: time.Now().UnixMilli()}
+	}
+}
+
+// AddHealthCheck adds a check to the health cap's report under name, replacing the
+// check added before under it. The plugin is reported healthy while all its checks
+// return nil. Checks run on every health request, so they should be quick: ping a
+// database, not rebuild an index.
+func (pr *PluginRuntime) AddHealthCheck(name string, check HealthCheck) {
+	pr.health.mu.Lock()
+	defer pr.health.mu.Unlock()
+	pr.health.checks[name] = check
+}
+
+// Health runs the health checks and reports the runtime's status, as the health cap
+// does. A check that panics is reported as failed.
+func (pr *PluginRuntime) Health() HealthReport {
+	pr.mu.RLock()
+	limits := pr.limits
+	pr.mu.RUnlock()
+
+	h := pr.health
+	h.mu.Lock()
+	report := HealthReport{
+		Healthy:        true,
+		UptimeMs:       time.Since(h.since).Milliseconds(),
+		ActiveHandlers: h.active,
+		Limits:         limits,
+		PeerRequests:   pr.PeerRequestsInFlight(),
+		LastError:      h.lastError,
+		Checks:         make(map[string]string, len(h.checks)),
+	}
+	names := make([]string, 0, len(h.checks))
+	for name := range h.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	checks := make([]HealthCheck, len(names))
+	for i, name := range names {
+		checks[i] = h.checks[name]
+	}
+	h.mu.Unlock()
+
+	for i, name := range names {
+		if err := runHealthCheck(checks[i]); err != nil {
+			report.Healthy = false
+			report.Checks[name] = err.Error()
+		} else {
+			report.Checks[name] = "ok"
+		}
+	}
+	return report
+}
+
+// runHealthCheck runs a check, turning a panic into its error
+func runHealthCheck(check HealthCheck) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("health check panicked: %v", r)
+		}
+	}()
+	return check()
+}
+
+// healthHandler answers the health cap with the runtime's HealthReport
+func (pr *PluginRuntime) healthHandler(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+	for range frames {
+	}
+	return emitter.EmitCbor(pr.Health())
+}
+
+// isHealthRequest reports whether a request URN asks for the health cap
+func isHealthRequest(requestUrn *urn.CapUrn) bool {
+	healthUrn, err := urn.NewCapUrnFromString(standard.CapHealth)
+	if err != nil {
+		return false
+	}
+	return requestUrn.HasTag("op", "health") && requestUrn.Accepts(healthUrn)
+}
@@ -0,0 +1,71 @@
+package bifaci
+
+import (
+	"errors"
+	"testing"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+)
+
+// The runtime answers the health cap with its status and the plugin's own checks
+func TestHealthCap(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		return NewCapError(ErrCodeBusy, "overloaded")
+	})
+	runtime.AddHealthCheck("db", func() error { return nil })
+	runtime.AddHealthCheck("model", func() error { return errors.New("model not loaded") })
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	defer stop()
+
+	failing := NewMessageIdRandom()
+	writer.WriteFrame(NewReq(failing, testCancelCap, nil, "application/cbor"))
+	writer.WriteFrame(NewEnd(failing, nil))
+	readUntilTerminal(t, reader, failing)
+
+	reqId := NewMessageIdRandom()
+	writer.WriteFrame(NewReq(reqId, "cap:op=health", nil, "application/cbor"))
+	writer.WriteFrame(NewEnd(reqId, nil))
+	streams, err := collectFrames(readUntilTerminal(t, reader, reqId))
+	if err != nil || len(streams) != 1 {
+		t.Fatalf("Expected the health report, got %v %v", streams, err)
+	}
+	var report HealthReport
+	if err := cborlib.Unmarshal(streams[0].Data, &report); err != nil {
+		t.Fatalf("Failed to decode health report: %v", err)
+	}
+
+	if report.Healthy {
+		t.Error("Expected a failing check to make the plugin unhealthy")
+	}
+	if report.Checks["db"] != "ok" || report.Checks["model"] != "model not loaded" {
+		t.Errorf("Unexpected check results %v", report.Checks)
+	}
+	if report.ActiveHandlers != 1 {
+		t.Errorf("Expected the health request as the only active handler, got %d", report.ActiveHandlers)
+	}
+	if report.LastError == nil || report.LastError.Cap != testCancelCap || report.LastError.Code != string(ErrCodeBusy) {
+		t.Errorf("Expected the BUSY error as the last error, got %+v", report.LastError)
+	}
+	if report.Limits.MaxChunk == 0 {
+		t.Errorf("Expected the negotiated limits, got %+v", report.Limits)
+	}
+}
+
+// A health check that panics is reported as failed
+func TestHealthCheckPanic(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.AddHealthCheck("cache", func() error { panic("nil map") })
+	report := runtime.Health()
+	if report.Healthy || report.Checks["cache"] == "ok" {
+		t.Errorf("Expected the panicking check to fail, got %+v", report)
+	}
+}
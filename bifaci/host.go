@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
 	"sync"
+	"time"
 
 	"github.com/machinefabric/capdag-go/urn"
 )
@@ -14,67 +16,6 @@ import (
 // It receives the concatenated payload bytes and returns response bytes.
 type CapHandler func(payload []byte) ([]byte, error)
 
-// ResponseChunk represents a response chunk from a plugin (matches Rust ResponseChunk)
-type ResponseChunk struct {
-	Payload []byte
-	Seq     uint64
-	Offset  *uint64
-	Len     *uint64
-	IsEof   bool
-}
-
-// PluginResponseType indicates whether a response is single or streaming
-type PluginResponseType int
-
-const (
-	PluginResponseTypeSingle PluginResponseType = iota
-	PluginResponseTypeStreaming
-)
-
-// PluginResponse represents a complete response from a plugin
-type PluginResponse struct {
-	Type      PluginResponseType
-	Single    []byte
-	Streaming []*ResponseChunk
-}
-
-// FinalPayload gets the final payload
-func (pr *PluginResponse) FinalPayload() []byte {
-	switch pr.Type {
-	case PluginResponseTypeSingle:
-		return pr.Single
-	case PluginResponseTypeStreaming:
-		if len(pr.Streaming) > 0 {
-			return pr.Streaming[len(pr.Streaming)-1].Payload
-		}
-		return nil
-	default:
-		return nil
-	}
-}
-
-// Concatenated concatenates all payloads into a single buffer
-func (pr *PluginResponse) Concatenated() []byte {
-	switch pr.Type {
-	case PluginResponseTypeSingle:
-		result := make([]byte, len(pr.Single))
-		copy(result, pr.Single)
-		return result
-	case PluginResponseTypeStreaming:
-		totalLen := 0
-		for _, chunk := range pr.Streaming {
-			totalLen += len(chunk.Payload)
-		}
-		result := make([]byte, 0, totalLen)
-		for _, chunk := range pr.Streaming {
-			result = append(result, chunk.Payload...)
-		}
-		return result
-	default:
-		return nil
-	}
-}
-
 // HostError represents errors from the plugin host
 type HostError struct {
 	Type    HostErrorType
@@ -82,6 +23,12 @@ type HostError struct {
 	Code    string
 }
 
+// Is matches the ErrCode of a plugin error (see CapError)
+func (e *HostError) Is(target error) bool {
+	code, ok := target.(ErrCode)
+	return ok && e.Type == HostErrorTypePluginError && string(code) == e.Code
+}
+
 type HostErrorType int
 
 const (
@@ -127,9 +74,11 @@ func (e *HostError) Error() string {
 
 // pluginEvent is an internal event from a plugin reader goroutine.
 type pluginEvent struct {
-	pluginIdx int
-	frame     *Frame
-	isDeath   bool
+	pluginIdx  int
+	generation int // Process generation the event belongs to; stale events are dropped
+	frame      *Frame
+	isDeath    bool
+	restart    bool // Supervision backoff elapsed; restart the plugin
 }
 
 // capTableEntry maps a cap URN to a plugin index.
@@ -142,6 +91,10 @@ type capTableEntry struct {
 type routingEntry struct {
 	pluginIdx int
 	msgId     MessageId
+	seq       uint64   // Arrival order, for deterministic requeue and failure
+	buffered  []*Frame // Relay frames kept for replay after a restart (supervision only)
+	responded bool     // The plugin has started answering; the request can't be replayed
+	cancelled bool
 }
 
 // ManagedPlugin represents a plugin managed by the PluginHost.
@@ -155,6 +108,19 @@ type ManagedPlugin struct {
 	knownCaps   []string
 	running     bool
 	helloFailed bool
+
+	permissions []string       // Permission patterns of the manifest (see SetPermissionApprover)
+	allowed     capPermissions // Host caps the plugin's peer requests may invoke
+	granted     bool           // Permissions were granted to a process of the plugin
+
+	cacheTTL map[string]time.Duration // Cacheable caps of the manifest → TTL (see SetResponseCache)
+
+	generation int       // Bumped on each death so events of the old process are dropped
+	restarting bool      // Supervision restart pending; requests wait for it
+	crashes    int       // Consecutive deaths and failed restarts
+	startedAt  time.Time // Last successful spawn
+	probeId    *MessageId
+	probeSent  time.Time
 }
 
 // PluginHost manages N plugin binaries with cap-based routing.
@@ -168,9 +134,28 @@ type PluginHost struct {
 	capTable       []capTableEntry
 	requestRouting map[string]routingEntry // reqId string → routing info
 	peerRequests   map[string]bool         // plugin-initiated reqIds
+	answered       map[string]int          // Peer reqId the host answered itself (discovery, denial) → plugin index, until the plugin's END
 	capabilities   []byte
 	eventCh        chan pluginEvent
+	supervision    *SupervisionPolicy
+	checksums      []ChecksumAlgorithm    // Offered in the handshake with each plugin
+	compressions   []CompressionAlgorithm // Offered in the handshake with each plugin
+	wireCodec      WireCodec              // Codec of plugin connections ("" = WireCBOR, see SetWireCodec)
+	nextSeq        uint64
+	done           chan struct{}
+	stopped        bool
 	mu             sync.Mutex
+
+	onProgress      ProgressFunc              // Told of response stream progress (see OnProgress)
+	responseLengths map[string]*streamLengths // reqId string → its response streams' progress
+	progressWatches map[string]*progressWatch // reqId string → its progress watch (see WatchProgress)
+
+	approvePermissions PermissionApprover // Approves the permissions of plugins, nil = all (see SetPermissionApprover)
+
+	responseCache ResponseCache                // Answers requests for cacheable caps, nil = off (see SetResponseCache)
+	cacheable     map[string]*cacheableRequest // reqId string → its cacheable request
+
+	logger Logger
 }
 
 // NewPluginHost creates a new multi-plugin host.
@@ -178,10 +163,42 @@ func NewPluginHost() *PluginHost {
 	return &PluginHost{
 		requestRouting: make(map[string]routingEntry),
 		peerRequests:   make(map[string]bool),
+		answered:       make(map[string]int),
 		eventCh:        make(chan pluginEvent, 256),
+		done:           make(chan struct{}),
+		logger:         defaultHostLogger(),
 	}
 }
 
+// SetLogger routes the host's diagnostics to logger. nil restores the default (slog
+// text handler on stderr, level INFO).
+func (h *PluginHost) SetLogger(logger Logger) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if logger == nil {
+		logger = defaultHostLogger()
+	}
+	h.logger = logger
+}
+
+// SetChecksums offers checksum algorithms for CHUNK frames, in preference order, in the
+// handshake with plugins attached or spawned afterwards. Each plugin picks the first it
+// implements; plugins that implement none keep FNV-1a.
+func (h *PluginHost) SetChecksums(algorithms ...ChecksumAlgorithm) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checksums = algorithms
+}
+
+// SetCompressions offers stream compressions, in preference order, in the handshake with
+// plugins attached or spawned afterwards. Responses of plugins that accept one may
+// arrive compressed; the host decompresses them as it reads them.
+func (h *PluginHost) SetCompressions(algorithms ...CompressionAlgorithm) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.compressions = algorithms
+}
+
 // RegisterPlugin registers a plugin binary for on-demand spawning.
 // The plugin is not spawned until a REQ arrives for one of its known caps.
 func (h *PluginHost) RegisterPlugin(path string, knownCaps []string) {
@@ -207,7 +224,12 @@ func (h *PluginHost) AttachPlugin(pluginRead io.Reader, pluginWrite io.Writer) (
 	reader := NewFrameReader(pluginRead)
 	writer := NewFrameWriter(pluginWrite)
 
-	manifest, limits, err := HandshakeInitiate(reader, writer)
+	h.mu.Lock()
+	options := HandshakeOptions{MaxWindow: DefaultMaxWindow, Checksums: h.checksums, Compressions: h.compressions}
+	reader.SetCodec(h.wireCodec)
+	writer.SetCodec(h.wireCodec)
+	h.mu.Unlock()
+	manifest, limits, err := HandshakeInitiateWithOptions(reader, writer, options)
 	if err != nil {
 		return -1, fmt.Errorf("handshake failed: %w", err)
 	}
@@ -225,12 +247,19 @@ func (h *PluginHost) AttachPlugin(pluginRead io.Reader, pluginWrite io.Writer) (
 
 	writerCh := make(chan *Frame, 64)
 	plugin := &ManagedPlugin{
-		writerCh: writerCh,
-		manifest: manifest,
-		limits:   limits,
-		caps:     caps,
-		running:  true,
-	}
+		writerCh:  writerCh,
+		manifest:  manifest,
+		limits:    limits,
+		caps:      caps,
+		cacheTTL:  parseCacheableCaps(manifest),
+		running:   true,
+		startedAt: time.Now(),
+	}
+	if err := h.grantPermissionsLocked(plugin, manifest); err != nil {
+		h.mu.Unlock()
+		return -1, err
+	}
+	plugin.probeSent = plugin.startedAt
 	h.plugins = append(h.plugins, plugin)
 
 	for _, cap := range caps {
@@ -240,7 +269,7 @@ func (h *PluginHost) AttachPlugin(pluginRead io.Reader, pluginWrite io.Writer) (
 	h.mu.Unlock()
 
 	go h.writerLoop(writer, writerCh)
-	go h.readerLoop(pluginIdx, reader)
+	go h.readerLoop(pluginIdx, 0, reader)
 
 	return pluginIdx, nil
 }
@@ -293,6 +322,16 @@ func (h *PluginHost) Run(relayRead io.Reader, relayWrite io.Writer, resourceFn f
 	relayReader := NewFrameReader(relayRead)
 	relayWriter := NewFrameWriter(relayWrite)
 
+	// Heartbeat probes run only under a supervision policy
+	var heartbeatTick <-chan time.Time
+	h.mu.Lock()
+	if h.supervision != nil && h.supervision.HeartbeatInterval > 0 {
+		ticker := time.NewTicker(h.supervision.HeartbeatInterval)
+		defer ticker.Stop()
+		heartbeatTick = ticker.C
+	}
+	h.mu.Unlock()
+
 	relayCh := make(chan *Frame, 64)
 	relayDone := make(chan error, 1)
 	go func() {
@@ -325,11 +364,17 @@ func (h *PluginHost) Run(relayRead io.Reader, relayWrite io.Writer, resourceFn f
 			}
 
 		case event := <-h.eventCh:
-			if event.isDeath {
-				h.handlePluginDeath(event.pluginIdx, relayWriter)
-			} else if event.frame != nil {
-				h.handlePluginFrame(event.pluginIdx, event.frame, relayWriter)
+			switch {
+			case event.restart:
+				h.restartPlugin(event.pluginIdx, event.generation, relayWriter)
+			case event.isDeath:
+				h.handlePluginDeath(event.pluginIdx, event.generation, relayWriter)
+			case event.frame != nil:
+				h.handlePluginFrame(event.pluginIdx, event.generation, event.frame, relayWriter)
 			}
+
+		case <-heartbeatTick:
+			h.checkHeartbeats(relayWriter)
 		}
 	}
 }
@@ -356,7 +401,7 @@ func (h *PluginHost) handleRelayFrame(frame *Frame, relayWriter *FrameWriter) er
 		}
 
 		plugin := h.plugins[pluginIdx]
-		if !plugin.running {
+		if !plugin.running && !plugin.restarting {
 			if plugin.helloFailed {
 				errFrame := NewErr(frame.Id, "SPAWN_FAILED", "plugin previously failed to start")
 				relayWriter.WriteFrame(errFrame)
@@ -369,16 +414,34 @@ func (h *PluginHost) handleRelayFrame(frame *Frame, relayWriter *FrameWriter) er
 			}
 		}
 
-		h.requestRouting[idKey] = routingEntry{pluginIdx: pluginIdx, msgId: frame.Id}
+		h.requestRouting[idKey] = routingEntry{pluginIdx: pluginIdx, msgId: frame.Id, seq: h.nextSeq}
+		h.nextSeq++
+		if h.holdCacheableLocked(idKey, pluginIdx, frame) {
+			return nil
+		}
+		h.trackRelayFrameLocked(idKey, frame)
 		h.sendToPlugin(pluginIdx, frame)
 
 	case FrameTypeStreamStart, FrameTypeChunk, FrameTypeStreamEnd:
-		if entry, ok := h.requestRouting[idKey]; ok {
+		if entry, ok := h.requestRouting[idKey]; ok && !h.holdFrameLocked(idKey, frame) {
+			h.trackRelayFrameLocked(idKey, frame)
 			h.sendToPlugin(entry.pluginIdx, frame)
 		}
 
 	case FrameTypeEnd, FrameTypeErr:
+		// A request held back for the response cache was not sent on yet: it is answered
+		// from cache or sent on now, or dropped if the engine gives up on it
+		if request, ok := h.cacheable[idKey]; ok && request.held != nil {
+			if frame.FrameType == FrameTypeEnd {
+				h.releaseCacheableLocked(idKey, h.requestRouting[idKey], frame, relayWriter)
+			} else {
+				delete(h.cacheable, idKey)
+				delete(h.requestRouting, idKey)
+			}
+			return nil
+		}
 		if entry, ok := h.requestRouting[idKey]; ok {
+			h.trackRelayFrameLocked(idKey, frame)
 			h.sendToPlugin(entry.pluginIdx, frame)
 			// Only remove routing on terminal frames if this is a PEER response
 			// (engine responding to a plugin's peer invoke). For engine-initiated
@@ -390,6 +453,33 @@ func (h *PluginHost) handleRelayFrame(frame *Frame, relayWriter *FrameWriter) er
 			}
 		}
 
+	case FrameTypeCancel:
+		// Engine aborts a request — the plugin answers with ERR CANCELLED,
+		// which removes the routing entry like any other terminal frame
+		if request, ok := h.cacheable[idKey]; ok && request.held != nil {
+			relayWriter.WriteFrame(NewErr(frame.Id, "CANCELLED", "Request cancelled by peer"))
+			delete(h.cacheable, idKey)
+			delete(h.requestRouting, idKey)
+			return nil
+		}
+		if entry, ok := h.requestRouting[idKey]; ok {
+			h.trackRelayFrameLocked(idKey, frame)
+			h.sendToPlugin(entry.pluginIdx, frame)
+		}
+
+	case FrameTypeSessionClose:
+		// A session may span requests routed to any plugin - every running one drops it
+		for pluginIdx, plugin := range h.plugins {
+			if plugin.running {
+				h.sendToPlugin(pluginIdx, frame)
+			}
+		}
+
+	case FrameTypeAck:
+		// Flow control is hop-by-hop: the host grants plugin credit itself as it
+		// forwards response chunks (see handlePluginFrame), so engine ACKs stop here
+		return nil
+
 	case FrameTypeHeartbeat:
 		// Engine-level heartbeat — not forwarded to plugins
 		return nil
@@ -405,14 +495,33 @@ func (h *PluginHost) handleRelayFrame(frame *Frame, relayWriter *FrameWriter) er
 }
 
 // handlePluginFrame processes a frame from a plugin.
-func (h *PluginHost) handlePluginFrame(pluginIdx int, frame *Frame, relayWriter *FrameWriter) {
+func (h *PluginHost) handlePluginFrame(pluginIdx, generation int, frame *Frame, relayWriter *FrameWriter) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	if h.plugins[pluginIdx].generation != generation {
+		return // From a process that has already been declared dead
+	}
+
 	idKey := frame.Id.ToString()
+	h.noteResponseLocked(idKey, frame)
+	h.noteProgressLocked(idKey, frame)
+	h.noteReportLocked(idKey, frame)
+	h.noteCacheableLocked(idKey, frame)
+
+	// The rest of a peer request the host answered itself goes no further
+	if _, answered := h.answered[idKey]; answered && frame.FrameType != FrameTypeHeartbeat && frame.FrameType != FrameTypeLog {
+		if frame.FrameType == FrameTypeEnd || frame.FrameType == FrameTypeErr || frame.FrameType == FrameTypeCancel {
+			delete(h.answered, idKey)
+		}
+		return
+	}
 
 	switch frame.FrameType {
 	case FrameTypeHeartbeat:
+		if h.answerProbeLocked(pluginIdx, frame) {
+			return
+		}
 		// Respond to plugin heartbeat locally — don't forward
 		response := NewHeartbeat(frame.Id)
 		h.sendToPlugin(pluginIdx, response)
@@ -421,18 +530,68 @@ func (h *PluginHost) handlePluginFrame(pluginIdx int, frame *Frame, relayWriter
 		// HELLO post-handshake — protocol violation, ignore
 		return
 
+	case FrameTypeManifestUpdated:
+		// Plugin added or removed caps at run time — route by its new manifest
+		manifest := frame.UpdatedManifest()
+		caps, err := parseCapsFromManifest(manifest)
+		if err != nil {
+			return
+		}
+		plugin := h.plugins[pluginIdx]
+		plugin.manifest = manifest
+		plugin.caps = caps
+		plugin.cacheTTL = parseCacheableCaps(manifest)
+		h.updateCapTable()
+		h.rebuildCapabilities()
+
 	case FrameTypeReq:
+		// Plugin asks which caps the host serves - answered from the plugins' manifests
+		if isDiscoverRequest(frame) {
+			h.answerDiscoveryLocked(pluginIdx, frame.Id)
+			return
+		}
+		// The runtime checks its permissions itself; a plugin that doesn't is still
+		// held to those granted to it
+		if frame.Cap != nil {
+			if err := h.plugins[pluginIdx].allowed.check(*frame.Cap); err != nil {
+				h.answered[idKey] = pluginIdx
+				h.sendToPlugin(pluginIdx, NewErr(frame.Id, string(ErrCodePermissionDenied), err.(*CapError).Message))
+				return
+			}
+		}
 		// Plugin is invoking a peer cap (sending request to engine)
-		h.requestRouting[idKey] = routingEntry{pluginIdx: pluginIdx, msgId: frame.Id}
+		h.requestRouting[idKey] = routingEntry{pluginIdx: pluginIdx, msgId: frame.Id, seq: h.nextSeq}
+		h.nextSeq++
 		h.peerRequests[idKey] = true
 		relayWriter.WriteFrame(frame)
 
 	case FrameTypeLog:
 		relayWriter.WriteFrame(frame)
 
-	case FrameTypeStreamStart, FrameTypeChunk, FrameTypeStreamEnd:
+	case FrameTypeCancel:
+		// Plugin aborts one of its peer invocations — routing is kept until
+		// the engine's terminal frame arrives
+		if h.peerRequests[idKey] {
+			relayWriter.WriteFrame(frame)
+		}
+
+	case FrameTypeStreamStart, FrameTypeStreamEnd, FrameTypeAccepted:
 		relayWriter.WriteFrame(frame)
 
+	case FrameTypeNotify:
+		// Scheduled runs are the plugin's own: the relay gets their outcome unasked
+		relayWriter.WriteFrame(frame)
+
+	case FrameTypeChunk:
+		// The relay write blocks while the engine is slow; only once it completes is
+		// the plugin granted credit for another chunk of its response
+		if err := relayWriter.WriteFrame(frame); err != nil {
+			return
+		}
+		if h.plugins[pluginIdx].limits.MaxWindow > 0 && !h.peerRequests[idKey] {
+			h.sendToPlugin(pluginIdx, NewAck(frame.Id, 1))
+		}
+
 	case FrameTypeEnd:
 		relayWriter.WriteFrame(frame)
 		if !h.peerRequests[idKey] {
@@ -446,13 +605,44 @@ func (h *PluginHost) handlePluginFrame(pluginIdx int, frame *Frame, relayWriter
 	}
 }
 
+// answerDiscoveryLocked answers a plugin's discovery REQ with the caps of the running
+// plugins. Caller holds mu.
+func (h *PluginHost) answerDiscoveryLocked(pluginIdx int, reqId MessageId) {
+	var manifests [][]byte
+	for _, plugin := range h.plugins {
+		if plugin.running {
+			manifests = append(manifests, plugin.manifest)
+		}
+	}
+	frames, err := discoveryResponse(reqId, manifests)
+	if err != nil {
+		frames = []*Frame{NewErr(reqId, string(ErrCodeHandler), err.Error())}
+	}
+	h.answered[reqId.ToString()] = pluginIdx
+	for _, frame := range frames {
+		h.sendToPlugin(pluginIdx, frame)
+	}
+}
+
 // handlePluginDeath processes a plugin death event.
-func (h *PluginHost) handlePluginDeath(pluginIdx int, relayWriter *FrameWriter) {
+func (h *PluginHost) handlePluginDeath(pluginIdx, generation int, relayWriter *FrameWriter) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	if h.plugins[pluginIdx].generation != generation {
+		return // Already handled, e.g. after a missed heartbeat
+	}
+	h.pluginDiedLocked(pluginIdx, relayWriter)
+}
+
+// pluginDiedLocked stops a dead or hung plugin and settles its pending requests: they
+// are kept for replay when the supervision policy restarts the plugin and requeues them,
+// and fail with PLUGIN_DIED otherwise, in arrival order. Caller holds mu.
+func (h *PluginHost) pluginDiedLocked(pluginIdx int, relayWriter *FrameWriter) {
 	plugin := h.plugins[pluginIdx]
 	plugin.running = false
+	plugin.generation++
+	plugin.probeId = nil
 
 	if plugin.writerCh != nil {
 		close(plugin.writerCh)
@@ -461,24 +651,34 @@ func (h *PluginHost) handlePluginDeath(pluginIdx int, relayWriter *FrameWriter)
 
 	if plugin.cmd != nil && plugin.cmd.Process != nil {
 		plugin.cmd.Process.Kill()
+		go plugin.cmd.Wait()
 		plugin.cmd = nil
 	}
 
-	// Send ERR for all pending requests routed to this plugin
-	var failedEntries []routingEntry
-	var failedKeys []string
-	for reqId, entry := range h.requestRouting {
-		if entry.pluginIdx == pluginIdx {
-			failedEntries = append(failedEntries, entry)
-			failedKeys = append(failedKeys, reqId)
+	restart := h.shouldRestartLocked(plugin)
+	for _, key := range h.pendingRequestsLocked(pluginIdx) {
+		entry := h.requestRouting[key]
+		if restart && h.supervision.RequeueRequests && !h.peerRequests[key] && !entry.responded && !entry.cancelled {
+			continue // Replayed once the plugin is back
 		}
-	}
-
-	for i, key := range failedKeys {
-		errFrame := NewErr(failedEntries[i].msgId, "PLUGIN_DIED", fmt.Sprintf("plugin %d died", pluginIdx))
+		errFrame := NewErr(entry.msgId, "PLUGIN_DIED", fmt.Sprintf("plugin %d died", pluginIdx))
 		relayWriter.WriteFrame(errFrame)
 		delete(h.requestRouting, key)
 		delete(h.peerRequests, key)
+		delete(h.responseLengths, key)
+		delete(h.progressWatches, key)
+		delete(h.cacheable, key)
+	}
+	for key, idx := range h.answered {
+		if idx == pluginIdx {
+			delete(h.answered, key)
+		}
+	}
+
+	if restart {
+		h.scheduleRestartLocked(pluginIdx)
+	} else if h.supervision != nil && plugin.path != "" && !h.stopped {
+		plugin.helloFailed = true // Restart budget exhausted
 	}
 
 	h.updateCapTable()
@@ -507,14 +707,14 @@ func (h *PluginHost) writerLoop(writer *FrameWriter, ch chan *Frame) {
 }
 
 // readerLoop reads frames from a plugin and sends events to the event channel.
-func (h *PluginHost) readerLoop(pluginIdx int, reader *FrameReader) {
+func (h *PluginHost) readerLoop(pluginIdx, generation int, reader *FrameReader) {
 	for {
 		frame, err := reader.ReadFrame()
 		if err != nil {
-			h.eventCh <- pluginEvent{pluginIdx: pluginIdx, isDeath: true}
+			h.eventCh <- pluginEvent{pluginIdx: pluginIdx, generation: generation, isDeath: true}
 			return
 		}
-		h.eventCh <- pluginEvent{pluginIdx: pluginIdx, frame: frame}
+		h.eventCh <- pluginEvent{pluginIdx: pluginIdx, generation: generation, frame: frame}
 	}
 }
 
@@ -527,6 +727,9 @@ func (h *PluginHost) spawnPluginLocked(pluginIdx int) error {
 	}
 
 	cmd := exec.Command(plugin.path)
+	if h.wireCodec != "" {
+		cmd.Env = append(os.Environ(), WireEnvVar+"="+string(h.wireCodec))
+	}
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
 		plugin.helloFailed = true
@@ -546,8 +749,10 @@ func (h *PluginHost) spawnPluginLocked(pluginIdx int) error {
 
 	reader := NewFrameReader(stdout)
 	writer := NewFrameWriter(stdin)
+	reader.SetCodec(h.wireCodec)
+	writer.SetCodec(h.wireCodec)
 
-	manifest, limits, err := HandshakeInitiate(reader, writer)
+	manifest, limits, err := HandshakeInitiateWithOptions(reader, writer, HandshakeOptions{MaxWindow: DefaultMaxWindow, Checksums: h.checksums, Compressions: h.compressions})
 	if err != nil {
 		plugin.helloFailed = true
 		cmd.Process.Kill()
@@ -563,11 +768,20 @@ func (h *PluginHost) spawnPluginLocked(pluginIdx int) error {
 		cmd.Process.Kill()
 		return fmt.Errorf("failed to parse manifest: %w", parseErr)
 	}
+	if err := h.grantPermissionsLocked(plugin, manifest); err != nil {
+		plugin.helloFailed = true
+		cmd.Process.Kill()
+		return err
+	}
 
 	plugin.manifest = manifest
 	plugin.limits = limits
 	plugin.caps = caps
+	plugin.cacheTTL = parseCacheableCaps(manifest)
 	plugin.running = true
+	plugin.startedAt = time.Now()
+	plugin.probeId = nil
+	plugin.probeSent = plugin.startedAt
 
 	writerCh := make(chan *Frame, 64)
 	plugin.writerCh = writerCh
@@ -576,7 +790,7 @@ func (h *PluginHost) spawnPluginLocked(pluginIdx int) error {
 	h.rebuildCapabilities()
 
 	go h.writerLoop(writer, writerCh)
-	go h.readerLoop(pluginIdx, reader)
+	go h.readerLoop(pluginIdx, plugin.generation, reader)
 
 	return nil
 }
@@ -626,6 +840,11 @@ func (h *PluginHost) killAllPlugins() {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	if !h.stopped {
+		h.stopped = true
+		close(h.done) // Pending restarts are abandoned
+	}
+
 	for _, plugin := range h.plugins {
 		if plugin.writerCh != nil {
 			close(plugin.writerCh)
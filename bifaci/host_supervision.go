@@ -0,0 +1,217 @@
+package bifaci
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// SupervisionPolicy configures how a PluginHost supervises the plugin processes it
+// spawns. Without a policy the host respawns a dead plugin on the next request for it
+// and fails its in-flight requests.
+type SupervisionPolicy struct {
+	// HeartbeatInterval is how often each running plugin is probed with a HEARTBEAT;
+	// 0 disables probing
+	HeartbeatInterval time.Duration
+	// HeartbeatTimeout is how long a probe may go unanswered before the plugin is
+	// considered hung and killed
+	HeartbeatTimeout time.Duration
+	// InitialBackoff is the delay before the first restart; it doubles with every
+	// consecutive crash up to MaxBackoff. A plugin that ran for at least MaxBackoff
+	// before dying starts over at InitialBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// MaxRestarts is the number of consecutive crashes and failed restarts after which
+	// the plugin is given up on; 0 means unlimited
+	MaxRestarts int
+	// RequeueRequests replays requests the dead plugin had not started answering to the
+	// restarted one. Requests it had answered in part, and cancelled ones, always fail
+	// with PLUGIN_DIED.
+	RequeueRequests bool
+}
+
+// DefaultSupervisionPolicy probes every 5s, restarts with 100ms..30s backoff, gives up
+// after 5 consecutive crashes, and requeues unanswered requests
+func DefaultSupervisionPolicy() SupervisionPolicy {
+	return SupervisionPolicy{
+		HeartbeatInterval: 5 * time.Second,
+		HeartbeatTimeout:  10 * time.Second,
+		InitialBackoff:    100 * time.Millisecond,
+		MaxBackoff:        30 * time.Second,
+		MaxRestarts:       5,
+		RequeueRequests:   true,
+	}
+}
+
+// SetSupervisionPolicy enables supervision of spawned plugins: heartbeat probes,
+// restarts with exponential backoff, and requeueing of in-flight requests. Attached
+// plugins are probed but can't be restarted. Must be called before Run.
+func (h *PluginHost) SetSupervisionPolicy(policy SupervisionPolicy) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.supervision = &policy
+}
+
+// trackRelayFrameLocked keeps a relay frame of an engine-initiated request so the
+// request can be replayed to a restarted plugin. Caller holds mu.
+func (h *PluginHost) trackRelayFrameLocked(idKey string, frame *Frame) {
+	if h.supervision == nil || h.peerRequests[idKey] {
+		return
+	}
+	entry, ok := h.requestRouting[idKey]
+	if !ok || entry.responded {
+		return
+	}
+	if frame.FrameType == FrameTypeCancel {
+		entry.cancelled = true
+	}
+	entry.buffered = append(entry.buffered, frame)
+	h.requestRouting[idKey] = entry
+}
+
+// noteResponseLocked marks a request as answered once the plugin sends response data,
+// dropping its replay buffer. Caller holds mu.
+func (h *PluginHost) noteResponseLocked(idKey string, frame *Frame) {
+	switch frame.FrameType {
+	case FrameTypeStreamStart, FrameTypeChunk, FrameTypeStreamEnd, FrameTypeEnd, FrameTypeErr:
+	default:
+		return
+	}
+	entry, ok := h.requestRouting[idKey]
+	if !ok || entry.responded || h.peerRequests[idKey] {
+		return
+	}
+	entry.responded = true
+	entry.buffered = nil
+	h.requestRouting[idKey] = entry
+}
+
+// answerProbeLocked reports whether frame answers the host's outstanding heartbeat
+// probe to the plugin. Caller holds mu.
+func (h *PluginHost) answerProbeLocked(pluginIdx int, frame *Frame) bool {
+	plugin := h.plugins[pluginIdx]
+	if plugin.probeId == nil || !plugin.probeId.Equals(frame.Id) {
+		return false
+	}
+	plugin.probeId = nil
+	return true
+}
+
+// checkHeartbeats kills plugins whose probe went unanswered for HeartbeatTimeout and
+// probes those due for their next heartbeat
+func (h *PluginHost) checkHeartbeats(relayWriter *FrameWriter) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	for pluginIdx, plugin := range h.plugins {
+		if !plugin.running {
+			continue
+		}
+		if plugin.probeId != nil {
+			if now.Sub(plugin.probeSent) > h.supervision.HeartbeatTimeout {
+				h.pluginDiedLocked(pluginIdx, relayWriter)
+			}
+			continue
+		}
+		if now.Sub(plugin.probeSent) >= h.supervision.HeartbeatInterval {
+			probeId := NewMessageIdRandom()
+			plugin.probeId = &probeId
+			plugin.probeSent = now
+			h.sendToPlugin(pluginIdx, NewHeartbeat(probeId))
+		}
+	}
+}
+
+// shouldRestartLocked reports whether a plugin that just died is restarted, counting
+// the crash. Caller holds mu.
+func (h *PluginHost) shouldRestartLocked(plugin *ManagedPlugin) bool {
+	if h.supervision == nil || plugin.path == "" || h.stopped {
+		return false
+	}
+	if time.Since(plugin.startedAt) >= h.supervision.MaxBackoff {
+		plugin.crashes = 0 // It was stable; this is a fresh failure
+	}
+	plugin.crashes++
+	return h.supervision.MaxRestarts == 0 || plugin.crashes <= h.supervision.MaxRestarts
+}
+
+// pendingRequestsLocked returns the routing keys of the plugin's requests in arrival
+// order. Caller holds mu.
+func (h *PluginHost) pendingRequestsLocked(pluginIdx int) []string {
+	var keys []string
+	for key, entry := range h.requestRouting {
+		if entry.pluginIdx == pluginIdx {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return h.requestRouting[keys[i]].seq < h.requestRouting[keys[j]].seq
+	})
+	return keys
+}
+
+// scheduleRestartLocked restarts the plugin after the backoff for its crash count.
+// Caller holds mu.
+func (h *PluginHost) scheduleRestartLocked(pluginIdx int) {
+	plugin := h.plugins[pluginIdx]
+	plugin.restarting = true
+
+	delay := h.supervision.InitialBackoff
+	for i := 1; i < plugin.crashes && delay < h.supervision.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > h.supervision.MaxBackoff {
+		delay = h.supervision.MaxBackoff
+	}
+
+	event := pluginEvent{pluginIdx: pluginIdx, generation: plugin.generation, restart: true}
+	time.AfterFunc(delay, func() {
+		select {
+		case h.eventCh <- event:
+		case <-h.done:
+		}
+	})
+}
+
+// restartPlugin respawns a supervised plugin and replays its requeued requests in
+// arrival order. A failed spawn counts as a crash; once the restart budget is
+// exhausted the waiting requests fail with SPAWN_FAILED.
+func (h *PluginHost) restartPlugin(pluginIdx, generation int, relayWriter *FrameWriter) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	plugin := h.plugins[pluginIdx]
+	if h.stopped || !plugin.restarting || plugin.generation != generation {
+		return
+	}
+
+	if err := h.spawnPluginLocked(pluginIdx); err != nil {
+		plugin.helloFailed = false
+		plugin.crashes++
+		if h.supervision.MaxRestarts == 0 || plugin.crashes <= h.supervision.MaxRestarts {
+			h.scheduleRestartLocked(pluginIdx)
+			return
+		}
+
+		plugin.restarting = false
+		plugin.helloFailed = true
+		for _, key := range h.pendingRequestsLocked(pluginIdx) {
+			errFrame := NewErr(h.requestRouting[key].msgId, "SPAWN_FAILED", fmt.Sprintf("plugin %d could not be restarted: %v", pluginIdx, err))
+			relayWriter.WriteFrame(errFrame)
+			delete(h.requestRouting, key)
+			delete(h.peerRequests, key)
+		}
+		h.updateCapTable()
+		h.rebuildCapabilities()
+		return
+	}
+
+	plugin.restarting = false
+	for _, key := range h.pendingRequestsLocked(pluginIdx) {
+		// Blocking sends: a replayed request may be longer than the writer buffer
+		for _, frame := range h.requestRouting[key].buffered {
+			plugin.writerCh <- frame
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package bifaci
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const flakyCapUrn = `cap:in="media:void";op=flaky;out="media:void"`
+
+const flakyManifest = `{"name":"Flaky","version":"1.0","caps":[{"urn":"cap:in=\"media:void\";op=flaky;out=\"media:void\"","title":"Flaky","command":"flaky"}]}`
+
+// TestSupervisionHelperPlugin is not a test: re-executed by flakyPluginPath, it serves
+// a plugin whose first request crashes the process and whose later requests succeed
+func TestSupervisionHelperPlugin(t *testing.T) {
+	marker := os.Getenv("BIFACI_FLAKY_MARKER")
+	if marker == "" {
+		return
+	}
+	runtime, err := NewPluginRuntime([]byte(flakyManifest))
+	if err != nil {
+		os.Exit(2)
+	}
+	runtime.Register(flakyCapUrn, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		if _, err := os.Stat(marker); os.IsNotExist(err) {
+			os.WriteFile(marker, nil, 0644)
+			os.Exit(1)
+		}
+		return emitter.EmitCbor("recovered")
+	})
+	runtime.runCBORMode()
+	os.Exit(0)
+}
+
+// flakyPluginPath returns an executable that runs TestSupervisionHelperPlugin
+func flakyPluginPath(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("BIFACI_FLAKY_MARKER", filepath.Join(dir, "crashed"))
+	script := filepath.Join(dir, "flaky-plugin")
+	content := "#!/bin/sh\nexec '" + os.Args[0] + "' -test.run='^TestSupervisionHelperPlugin$'\n"
+	require.NoError(t, os.WriteFile(script, []byte(content), 0755))
+	return script
+}
+
+// invokeFlaky sends one request per id through a supervised host running the flaky
+// plugin and returns each request's terminal frame and emitted value
+func invokeFlaky(t *testing.T, policy SupervisionPolicy, requests int) ([]*Frame, []string) {
+	t.Helper()
+	host := NewPluginHost()
+	host.SetSupervisionPolicy(policy)
+	host.RegisterPlugin(flakyPluginPath(t), []string{flakyCapUrn})
+
+	relayRead, engineWrite := net.Pipe()
+	engineRead, relayWrite := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- host.Run(relayRead, relayWrite, nil) }()
+
+	writer := NewFrameWriter(engineWrite)
+	reader := NewFrameReader(engineRead)
+	terminals := make([]*Frame, requests)
+	values := make([]string, requests)
+	for i := 0; i < requests; i++ {
+		reqId := NewMessageIdRandom()
+		go func() {
+			writer.WriteFrame(NewReq(reqId, flakyCapUrn, []byte{}, "application/cbor"))
+			writer.WriteFrame(NewEnd(reqId, nil))
+		}()
+		for terminals[i] == nil {
+			frame, err := reader.ReadFrame()
+			require.NoError(t, err)
+			switch frame.FrameType {
+			case FrameTypeChunk:
+				require.NoError(t, cborlib.Unmarshal(frame.Payload, &values[i]))
+			case FrameTypeEnd, FrameTypeErr:
+				terminals[i] = frame
+			}
+		}
+	}
+
+	engineWrite.Close()
+	engineRead.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("host did not stop")
+	}
+	return terminals, values
+}
+
+// TestSupervisionRequeuesAfterCrash: a request the plugin died on is replayed to the
+// restarted process instead of failing
+func TestSupervisionRequeuesAfterCrash(t *testing.T) {
+	terminals, values := invokeFlaky(t, SupervisionPolicy{
+		HeartbeatInterval: 20 * time.Millisecond,
+		HeartbeatTimeout:  5 * time.Second,
+		InitialBackoff:    10 * time.Millisecond,
+		MaxBackoff:        time.Second,
+		MaxRestarts:       3,
+		RequeueRequests:   true,
+	}, 1)
+
+	assert.Equal(t, FrameTypeEnd, terminals[0].FrameType, "requeued request must complete: %s", terminals[0].ErrorMessage())
+	assert.Equal(t, "recovered", values[0])
+}
+
+// TestSupervisionRestartsWithoutRequeue: without requeueing the in-flight request fails
+// with PLUGIN_DIED, and the plugin is restarted for the next one
+func TestSupervisionRestartsWithoutRequeue(t *testing.T) {
+	terminals, values := invokeFlaky(t, SupervisionPolicy{
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		MaxRestarts:    3,
+	}, 2)
+
+	require.Equal(t, FrameTypeErr, terminals[0].FrameType)
+	assert.Equal(t, "PLUGIN_DIED", terminals[0].ErrorCode())
+	assert.Equal(t, FrameTypeEnd, terminals[1].FrameType, "request after restart must complete: %s", terminals[1].ErrorMessage())
+	assert.Equal(t, "recovered", values[1])
+}
@@ -0,0 +1,450 @@
+package bifaci
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+
+	"github.com/machinefabric/capdag-go/cap"
+	"github.com/machinefabric/capdag-go/urn"
+)
+
+// maxHTTPMultipartMemory is how much of a multipart body is held in memory; larger
+// file parts are stored in temporary files by net/http.
+const maxHTTPMultipartMemory = 32 << 20
+
+// httpOutputDecMode decodes CHUNK values with string map keys so they can be written as JSON
+var httpOutputDecMode, _ = cborlib.DecOptions{DefaultMapType: reflect.TypeOf(map[string]interface{}{})}.DecMode()
+
+// RunHTTP serves the caps as HTTP endpoints on addr (see HTTPHandler).
+func (pr *PluginRuntime) RunHTTP(addr string) error {
+	return http.ListenAndServe(addr, pr.HTTPHandler())
+}
+
+// HTTPHandler returns an http.Handler exposing the caps CLI-style, e.g. for curl:
+//
+//	GET  /manifest          - the manifest JSON
+//	POST /caps/<command>    - invoke the cap with that command
+//
+// Arguments are taken from the request according to the cap's arg sources:
+//   - cli_flag "--name": query parameter or form field "name"; a multipart file part "name" supplies its content
+//   - position N: the N-th "arg" query parameter or form field
+//   - stdin: the request body, or the multipart part "stdin"
+//
+// File-path arguments are never read from the server's filesystem: their content must be
+// uploaded as a multipart file part.
+//
+// Output is streamed with chunked transfer encoding: bytes and text values are written as-is,
+// other values as JSON lines. ERR frames are mapped to HTTP status codes with a JSON body
+// {"error", "code"}; an error after output has started is reported in the X-Cap-Error-Code
+// and X-Cap-Error trailers.
+func (pr *PluginRuntime) HTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/manifest", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		pr.mu.RLock()
+		manifestData := pr.manifestData
+		pr.mu.RUnlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(manifestData)
+	})
+	mux.HandleFunc("/caps/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		command := strings.TrimPrefix(r.URL.Path, "/caps/")
+		capDef := pr.findCapByCommand(command)
+		if capDef == nil {
+			writeHTTPError(w, http.StatusNotFound, "NO_HANDLER", fmt.Sprintf("unknown command '%s'", command))
+			return
+		}
+
+		arguments, err := pr.buildArgumentsFromHTTP(capDef, r)
+		if err != nil {
+			writeHTTPError(w, http.StatusBadRequest, "INVALID_ARGUMENT", err.Error())
+			return
+		}
+		pr.serveHTTPInvocation(w, r, capDef.UrnString(), arguments)
+	})
+	return mux
+}
+
+// serveHTTPInvocation runs the request over an in-memory frame protocol connection and
+// streams the response.
+func (pr *PluginRuntime) serveHTTPInvocation(w http.ResponseWriter, r *http.Request, capUrn string, arguments []cap.CapArgumentValue) {
+	pluginSide, hostSide := net.Pipe()
+	defer hostSide.Close()
+	go pr.RunConn(pluginSide)
+
+	reader := NewFrameReader(hostSide)
+	writer := NewFrameWriter(hostSide)
+	_, limits, err := HandshakeInitiate(reader, writer)
+	if err != nil {
+		writeHTTPError(w, http.StatusInternalServerError, "PROTOCOL_ERROR", fmt.Sprintf("handshake failed: %v", err))
+		return
+	}
+	reader.SetLimits(limits)
+	writer.SetLimits(limits)
+	syncWriter := newSyncFrameWriter(writer)
+
+	reqId := NewMessageIdRandom()
+	go func() {
+		if err := writeRequestFrames(syncWriter, reqId, capUrn, arguments, limits.MaxChunk); err != nil {
+			pr.log().Error("HTTP gateway failed to send request", "cap", capUrn, "error", err)
+		}
+	}()
+
+	// Client went away: cancel the request
+	stop := context.AfterFunc(r.Context(), func() {
+		syncWriter.WriteFrame(NewCancel(reqId))
+	})
+	defer stop()
+
+	flusher, _ := w.(http.Flusher)
+	started := false
+	startOutput := func(mediaUrn string) {
+		if started {
+			return
+		}
+		started = true
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if mediaUrn != "" {
+			w.Header().Set("X-Cap-Media-Urn", mediaUrn)
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	firstMediaUrn := ""
+
+	for {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			if !started {
+				writeHTTPError(w, http.StatusInternalServerError, "PLUGIN_DIED", fmt.Sprintf("connection to handler failed: %v", err))
+			}
+			return
+		}
+
+		switch frame.FrameType {
+		case FrameTypeHeartbeat:
+			syncWriter.WriteFrame(NewHeartbeat(frame.Id))
+			continue
+		case FrameTypeReq:
+			// Peer invocation - nothing to route it to in gateway mode
+			syncWriter.WriteFrame(NewErr(frame.Id, "NO_HANDLER", "peer invocation is not available in HTTP gateway mode"))
+			continue
+		}
+		if !frame.Id.Equals(reqId) {
+			continue
+		}
+
+		switch frame.FrameType {
+		case FrameTypeStreamStart:
+			if firstMediaUrn == "" && frame.MediaUrn != nil {
+				firstMediaUrn = *frame.MediaUrn
+			}
+		case FrameTypeChunk:
+			if err := VerifyChunkChecksum(frame); err != nil {
+				syncWriter.WriteFrame(NewCancel(reqId))
+				if !started {
+					writeHTTPError(w, http.StatusInternalServerError, "CORRUPTED_DATA", err.Error())
+				}
+				return
+			}
+			startOutput(firstMediaUrn)
+			if err := writeHTTPValue(w, frame.Payload); err != nil {
+				syncWriter.WriteFrame(NewCancel(reqId))
+				w.Header().Set(http.TrailerPrefix+"X-Cap-Error-Code", "HANDLER_ERROR")
+				w.Header().Set(http.TrailerPrefix+"X-Cap-Error", err.Error())
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case FrameTypeLog:
+			pr.log().Info(frame.LogMessage(), "level", frame.LogLevel(), "cap", capUrn, "attrs", frame.LogAttrs())
+		case FrameTypeEnd:
+			startOutput(firstMediaUrn)
+			return
+		case FrameTypeErr:
+			if !started {
+				writeHTTPError(w, httpStatusForError(ErrCode(frame.ErrorCode())), frame.ErrorCode(), frame.ErrorMessage())
+				return
+			}
+			w.Header().Set(http.TrailerPrefix+"X-Cap-Error-Code", frame.ErrorCode())
+			w.Header().Set(http.TrailerPrefix+"X-Cap-Error", frame.ErrorMessage())
+			return
+		}
+	}
+}
+
+// writeRequestFrames sends REQ + one stream per argument + END.
+func writeRequestFrames(writer *syncFrameWriter, reqId MessageId, capUrn string, arguments []cap.CapArgumentValue, maxChunk int) error {
+	frames, err := argumentFrames(reqId, arguments, maxChunk)
+	if err != nil {
+		return err
+	}
+	if err := writer.WriteFrame(NewReq(reqId, capUrn, nil, "application/cbor")); err != nil {
+		return err
+	}
+	for i := range frames {
+		if err := writer.WriteFrame(&frames[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// argumentFrames returns the input frames of a request with the arguments: one stream
+// per argument, then END
+func argumentFrames(reqId MessageId, arguments []cap.CapArgumentValue, maxChunk int) ([]Frame, error) {
+	if maxChunk <= 0 {
+		maxChunk = DefaultMaxChunk
+	}
+	var frames []Frame
+	for i, arg := range arguments {
+		streamID := fmt.Sprintf("arg-%d", i)
+		frames = append(frames, *NewStreamStart(reqId, streamID, arg.MediaUrn))
+		// Each CHUNK is an independently decodable CBOR byte string
+		chunkIndex := uint64(0)
+		for offset := 0; offset < len(arg.Value); offset += maxChunk {
+			end := offset + maxChunk
+			if end > len(arg.Value) {
+				end = len(arg.Value)
+			}
+			cborPayload, err := cborlib.Marshal(arg.Value[offset:end])
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode chunk: %w", err)
+			}
+			frames = append(frames, *NewChunk(reqId, streamID, chunkIndex, cborPayload, chunkIndex, ComputeChecksum(cborPayload)))
+			chunkIndex++
+		}
+		frames = append(frames, *NewStreamEnd(reqId, streamID, chunkIndex))
+	}
+	return append(frames, *NewEnd(reqId, nil)), nil
+}
+
+// writeHTTPValue writes one CHUNK value: bytes and text as-is, anything else as a JSON line.
+func writeHTTPValue(w io.Writer, cborPayload []byte) error {
+	var value interface{}
+	if err := httpOutputDecMode.Unmarshal(cborPayload, &value); err != nil {
+		return fmt.Errorf("failed to decode output chunk: %w", err)
+	}
+	switch v := value.(type) {
+	case []byte:
+		_, err := w.Write(v)
+		return err
+	case string:
+		_, err := io.WriteString(w, v)
+		return err
+	default:
+		line, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("failed to encode output as JSON: %w", err)
+		}
+		_, err = w.Write(append(line, '\n'))
+		return err
+	}
+}
+
+// httpStatusForError maps an ERR frame code to an HTTP status code. Codes it doesn't
+// know, like a handler's own, are internal errors.
+func httpStatusForError(code ErrCode) int {
+	switch code {
+	case ErrCodeNoHandler, ErrCodeJobNotFound:
+		return http.StatusNotFound
+	case ErrCodeInvalidArgument, ErrCodeInvalidRequest, ErrCodeValidation, ErrCodeProtocol, ErrCodeCorruptedData:
+		return http.StatusBadRequest
+	case ErrCodePermissionDenied:
+		return http.StatusForbidden
+	case ErrCodeResourceExhausted:
+		return http.StatusRequestEntityTooLarge
+	case ErrCodeDuplicateRequest, ErrCodeJobRunning:
+		return http.StatusConflict
+	case ErrCodeCancelled, ErrCodeBusy, ErrCodePluginDied, ErrCodeSpawnFailed, ErrCodeStartFailed:
+		return http.StatusServiceUnavailable
+	case ErrCodeTimeout:
+		return http.StatusGatewayTimeout
+	case ErrCodeHandler, ErrCodeInvalidOutput, ErrCodeRouteConflict, ErrCodeProcessFailed:
+		return http.StatusInternalServerError
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeHTTPError writes the same {"error", "code"} JSON the CLI mode prints on failure.
+func writeHTTPError(w http.ResponseWriter, status int, code, message string) {
+	body, _ := json.Marshal(map[string]string{
+		"error": message,
+		"code":  code,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+// httpArgInputs holds the parts of an HTTP request arguments can come from.
+type httpArgInputs struct {
+	values url.Values                         // Form fields, then query parameters
+	files  map[string][]*multipart.FileHeader // Multipart file parts
+	body   []byte                             // Raw body (stdin) for non-form requests
+}
+
+// buildArgumentsFromHTTP extracts the cap's arguments from an HTTP request, mirroring
+// buildPayloadFromCLI.
+func (pr *PluginRuntime) buildArgumentsFromHTTP(capDef *cap.Cap, r *http.Request) ([]cap.CapArgumentValue, error) {
+	inputs := httpArgInputs{values: r.URL.Query()}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "multipart/form-data":
+		if err := r.ParseMultipartForm(maxHTTPMultipartMemory); err != nil {
+			return nil, fmt.Errorf("invalid multipart body: %w", err)
+		}
+		defer r.MultipartForm.RemoveAll()
+		inputs.files = r.MultipartForm.File
+		for key, values := range r.MultipartForm.Value {
+			inputs.values[key] = append(values, inputs.values[key]...)
+		}
+	case "application/x-www-form-urlencoded":
+		if err := r.ParseForm(); err != nil {
+			return nil, fmt.Errorf("invalid form body: %w", err)
+		}
+		for key, values := range r.PostForm {
+			inputs.values[key] = append(values, inputs.values[key]...)
+		}
+	default:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read body: %w", err)
+		}
+		inputs.body = body
+	}
+
+	// No args defined: the body is the cap's input
+	if len(capDef.Args) == 0 {
+		if len(inputs.body) == 0 {
+			return nil, nil
+		}
+		return []cap.CapArgumentValue{{MediaUrn: capDef.Urn.InSpec(), Value: inputs.body}}, nil
+	}
+
+	var arguments []cap.CapArgumentValue
+	for i := range capDef.Args {
+		argDef := &capDef.Args[i]
+		value, mediaUrn, err := extractHTTPArgValue(argDef, &inputs)
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			arguments = append(arguments, cap.CapArgumentValue{MediaUrn: mediaUrn, Value: value})
+		} else if argDef.Required {
+			return nil, fmt.Errorf("required argument missing: %s", argDef.MediaUrn)
+		}
+	}
+	return arguments, nil
+}
+
+// extractHTTPArgValue extracts one argument from the request inputs, trying the arg's sources
+// in order like extractArgValue. File-path args with a stdin source take uploaded content
+// and use the stdin source's media URN.
+func extractHTTPArgValue(argDef *cap.CapArg, inputs *httpArgInputs) ([]byte, string, error) {
+	argMediaUrn, err := urn.NewMediaUrnFromString(argDef.MediaUrn)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid media URN '%s': %w", argDef.MediaUrn, err)
+	}
+	filePathPattern, _ := urn.NewMediaUrnFromString(MediaFilePath)
+	filePathArrayPattern, _ := urn.NewMediaUrnFromString(MediaFilePathArray)
+	isFilePath := filePathArrayPattern.Accepts(argMediaUrn) || filePathPattern.Accepts(argMediaUrn)
+
+	mediaUrn := argDef.MediaUrn
+	var stdinUrn *string
+	for i := range argDef.Sources {
+		if argDef.Sources[i].Stdin != nil {
+			stdinUrn = argDef.Sources[i].Stdin
+			break
+		}
+	}
+	uploadsContent := isFilePath && stdinUrn != nil
+	if uploadsContent {
+		mediaUrn = *stdinUrn
+	}
+
+	for i := range argDef.Sources {
+		source := &argDef.Sources[i]
+
+		switch {
+		case source.CliFlag != nil:
+			key := strings.TrimLeft(*source.CliFlag, "-")
+			if content, found, err := readHTTPFilePart(inputs.files[key], 0); found || err != nil {
+				return content, mediaUrn, err
+			}
+			if values, found := inputs.values[key]; found && len(values) > 0 {
+				if uploadsContent {
+					return nil, "", fmt.Errorf("file-path argument %s must be uploaded as multipart file part '%s'", *source.CliFlag, key)
+				}
+				return []byte(values[0]), mediaUrn, nil
+			}
+		case source.Position != nil:
+			position := *source.Position
+			if content, found, err := readHTTPFilePart(inputs.files["arg"], position); found || err != nil {
+				return content, mediaUrn, err
+			}
+			if values := inputs.values["arg"]; position < len(values) {
+				if uploadsContent {
+					return nil, "", fmt.Errorf("file-path argument at position %d must be uploaded as multipart file part 'arg'", position)
+				}
+				return []byte(values[position]), mediaUrn, nil
+			}
+		case source.Stdin != nil:
+			if content, found, err := readHTTPFilePart(inputs.files["stdin"], 0); found || err != nil {
+				return content, mediaUrn, err
+			}
+			if values := inputs.values["stdin"]; len(values) > 0 {
+				return []byte(values[0]), mediaUrn, nil
+			}
+			if len(inputs.body) > 0 {
+				return inputs.body, mediaUrn, nil
+			}
+		}
+	}
+
+	if argDef.DefaultValue != nil {
+		bytes, err := json.Marshal(argDef.DefaultValue)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to serialize default value: %w", err)
+		}
+		return bytes, argDef.MediaUrn, nil
+	}
+	return nil, "", nil
+}
+
+// readHTTPFilePart reads the content of the index-th uploaded file part, if present.
+func readHTTPFilePart(parts []*multipart.FileHeader, index int) ([]byte, bool, error) {
+	if index >= len(parts) {
+		return nil, false, nil
+	}
+	file, err := parts[index].Open()
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to open uploaded file %s: %w", parts[index].Filename, err)
+	}
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to read uploaded file %s: %w", parts[index].Filename, err)
+	}
+	return content, true, nil
+}
@@ -0,0 +1,144 @@
+package bifaci
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+)
+
+const testGatewayManifest = `{"name":"TestPlugin","version":"1.0.0","description":"Test plugin","caps":[` +
+	`{"urn":"cap:in=\"media:void\";op=greet;out=\"media:void\"","title":"Greet","command":"greet","args":[` +
+	`{"media_urn":"media:text","required":true,"sources":[{"cli_flag":"--name"}]},` +
+	`{"media_urn":"media:file-path","required":false,"sources":[{"stdin":"media:bytes"},{"position":0}]}]}]}`
+
+// startGateway serves a greet handler: "hello <name>" plus {"size": n} for an uploaded file
+func startGateway(t *testing.T) *httptest.Server {
+	t.Helper()
+	runtime, err := NewPluginRuntime([]byte(testGatewayManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.Register(`cap:in="media:void";op=greet;out="media:void"`, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		streamMedia := make(map[string]string)
+		var name string
+		fileSize := -1
+		for frame := range frames {
+			switch frame.FrameType {
+			case FrameTypeStreamStart:
+				streamMedia[*frame.StreamId] = *frame.MediaUrn
+			case FrameTypeChunk:
+				var value []byte
+				if err := cborlib.Unmarshal(frame.Payload, &value); err != nil {
+					return err
+				}
+				if streamMedia[*frame.StreamId] == "media:text" {
+					name += string(value)
+				} else {
+					fileSize = len(value)
+				}
+			}
+		}
+		if name == "fail" {
+			return errors.New("refusing to greet")
+		}
+		if err := emitter.EmitCbor("hello " + name); err != nil {
+			return err
+		}
+		if fileSize >= 0 {
+			return emitter.EmitCbor(map[string]interface{}{"size": fileSize})
+		}
+		return nil
+	})
+
+	server := httptest.NewServer(runtime.HTTPHandler())
+	t.Cleanup(server.Close)
+	return server
+}
+
+// TestHTTPGatewayInvokesCap: flags from the query and an uploaded file reach the handler
+func TestHTTPGatewayInvokesCap(t *testing.T) {
+	server := startGateway(t)
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("arg", "input.bin")
+	part.Write([]byte("abc"))
+	form.Close()
+
+	response, err := http.Post(server.URL+"/caps/greet?name=bob", form.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	defer response.Body.Close()
+	output, _ := io.ReadAll(response.Body)
+
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", response.StatusCode, output)
+	}
+	if string(output) != "hello bob{\"size\":3}\n" {
+		t.Errorf("Unexpected output %q", output)
+	}
+}
+
+// TestHTTPGatewayErrorStatus: invalid arguments, handler errors and unknown commands map to HTTP statuses
+func TestHTTPGatewayErrorStatus(t *testing.T) {
+	server := startGateway(t)
+
+	cases := []struct {
+		name   string
+		path   string
+		status int
+		code   string
+	}{
+		{"missing required flag", "/caps/greet", http.StatusBadRequest, "INVALID_ARGUMENT"},
+		{"file path not uploaded", "/caps/greet?name=bob&arg=/etc/passwd", http.StatusBadRequest, "INVALID_ARGUMENT"},
+		{"handler error", "/caps/greet?name=fail", http.StatusInternalServerError, "HANDLER_ERROR"},
+		{"unknown command", "/caps/missing", http.StatusNotFound, "NO_HANDLER"},
+	}
+	for _, tc := range cases {
+		response, err := http.Post(server.URL+tc.path, "application/octet-stream", strings.NewReader(""))
+		if err != nil {
+			t.Fatalf("%s: POST failed: %v", tc.name, err)
+		}
+		var errorBody struct {
+			Error string `json:"error"`
+			Code  string `json:"code"`
+		}
+		json.NewDecoder(response.Body).Decode(&errorBody)
+		response.Body.Close()
+
+		if response.StatusCode != tc.status || errorBody.Code != tc.code {
+			t.Errorf("%s: expected %d %s, got %d %s (%s)", tc.name, tc.status, tc.code, response.StatusCode, errorBody.Code, errorBody.Error)
+		}
+	}
+}
+
+// TestHTTPStatusForError: every ERR code maps to the HTTP status of its class
+func TestHTTPStatusForError(t *testing.T) {
+	cases := map[ErrCode]int{
+		ErrCodeValidation:        http.StatusBadRequest,
+		ErrCodeInvalidRequest:    http.StatusBadRequest,
+		ErrCodePermissionDenied:  http.StatusForbidden,
+		ErrCodeResourceExhausted: http.StatusRequestEntityTooLarge,
+		ErrCodeDuplicateRequest:  http.StatusConflict,
+		ErrCodeJobRunning:        http.StatusConflict,
+		ErrCodeJobNotFound:       http.StatusNotFound,
+		ErrCodePluginDied:        http.StatusServiceUnavailable,
+		ErrCodeTimeout:           http.StatusGatewayTimeout,
+		ErrCodeHandler:           http.StatusInternalServerError,
+		"SOMETHING_ELSE":         http.StatusInternalServerError,
+	}
+	for code, expected := range cases {
+		if got := httpStatusForError(code); got != expected {
+			t.Errorf("httpStatusForError(%s) = %d, expected %d", code, got, expected)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package bifaci
+
+import (
+	"fmt"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+
+	"github.com/machinefabric/capdag-go/standard"
+)
+
+// SetIdentityHandler replaces the handler of the identity cap (cap:). By default the
+// identity cap answers with the plugin's manifest (see NewPluginRuntimeWithManifest);
+// IdentityEchoHandler echoes its input instead. A nil handler restores the default.
+func (pr *PluginRuntime) SetIdentityHandler(handler HandlerFunc) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if handler == nil {
+		handler = pr.manifestIdentityHandler
+	}
+	pr.handlers[standard.CapIdentity] = handler
+}
+
+// manifestIdentityHandler answers the identity cap with the plugin's current manifest as
+// a CBOR map: name, version, description and the URNs of its caps
+func (pr *PluginRuntime) manifestIdentityHandler(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+	for frame := range frames {
+		if frame.FrameType == FrameTypeChunk {
+			if err := VerifyChunkChecksum(&frame); err != nil {
+				return fmt.Errorf("corrupted data: %w", err)
+			}
+		}
+	}
+
+	pr.mu.RLock()
+	manifest := pr.manifest
+	pr.mu.RUnlock()
+	if manifest == nil {
+		return fmt.Errorf("the plugin manifest didn't parse")
+	}
+	caps := make([]string, len(manifest.Caps))
+	for i := range manifest.Caps {
+		caps[i] = manifest.Caps[i].UrnString()
+	}
+	return emitter.EmitCbor(map[string]interface{}{
+		"name":        manifest.Name,
+		"version":     manifest.Version,
+		"description": manifest.Description,
+		"caps":        caps,
+	})
+}
+
+// IdentityEchoHandler is an identity cap handler that returns its input as-is: a single
+// value unchanged, several byte or text chunks concatenated, other values as an array.
+// Register it with SetIdentityHandler.
+func IdentityEchoHandler(input <-chan Frame, output StreamEmitter, peer PeerInvoker) error {
+	// Collect all incoming frames
+	var chunks []interface{}
+	for frame := range input {
+		switch frame.FrameType {
+		case FrameTypeChunk:
+			// Verify checksum (protocol v2 integrity check)
+			if err := VerifyChunkChecksum(&frame); err != nil {
+				return fmt.Errorf("corrupted data: %w", err)
+			}
+			if frame.Payload != nil {
+				// Decode each chunk as CBOR
+				var value interface{}
+				if err := cborlib.Unmarshal(frame.Payload, &value); err != nil {
+					return err
+				}
+				chunks = append(chunks, value)
+			}
+		case FrameTypeEnd:
+			goto done
+		}
+	}
+done:
+	// Echo back - emit single value or concatenated chunks
+	if len(chunks) == 0 {
+		return output.EmitCbor([]byte{})
+	} else if len(chunks) == 1 {
+		return output.EmitCbor(chunks[0])
+	} else {
+		// Multiple chunks - try to concatenate if bytes/string, otherwise array
+		switch chunks[0].(type) {
+		case []byte:
+			var result []byte
+			for _, chunk := range chunks {
+				if b, ok := chunk.([]byte); ok {
+					result = append(result, b...)
+				}
+			}
+			return output.EmitCbor(result)
+		case string:
+			var result string
+			for _, chunk := range chunks {
+				if s, ok := chunk.(string); ok {
+					result += s
+				}
+			}
+			return output.EmitCbor(result)
+		default:
+			return output.EmitCbor(chunks)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package bifaci
+
+import (
+	"encoding/json"
+	"testing"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+
+	"github.com/machinefabric/capdag-go/standard"
+	"github.com/machinefabric/capdag-go/urn"
+)
+
+func newIdentityRuntime(t *testing.T) *PluginRuntime {
+	t.Helper()
+	var manifest CapManifest
+	if err := json.Unmarshal([]byte(testManifest), &manifest); err != nil {
+		t.Fatalf("Failed to parse test manifest: %v", err)
+	}
+	runtime, err := NewPluginRuntimeWithManifest(manifest.EnsureIdentity())
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	return runtime
+}
+
+// The default identity handler answers with the manifest
+func TestIdentityReturnsManifest(t *testing.T) {
+	runtime := newIdentityRuntime(t)
+	emitter := &mockStreamEmitter{}
+	if err := runtime.FindHandler(standard.CapIdentity)(bytesToFrameChannel([]byte("ignored")), emitter, &noPeerInvoker{}); err != nil {
+		t.Fatalf("Identity handler failed: %v", err)
+	}
+	if len(emitter.emittedData) != 1 {
+		t.Fatalf("Expected one value, got %d", len(emitter.emittedData))
+	}
+	var identity struct {
+		Name    string   `cbor:"name"`
+		Version string   `cbor:"version"`
+		Caps    []string `cbor:"caps"`
+	}
+	if err := cborlib.Unmarshal(emitter.emittedData[0], &identity); err != nil {
+		t.Fatalf("Expected a CBOR map: %v", err)
+	}
+	if identity.Name != "TestPlugin" || identity.Version != "1.0.0" {
+		t.Errorf("Expected TestPlugin 1.0.0, got %s %s", identity.Name, identity.Version)
+	}
+	expected, _ := urn.NewCapUrnFromString(testCancelCap)
+	found := false
+	for _, capUrn := range identity.Caps {
+		parsed, err := urn.NewCapUrnFromString(capUrn)
+		found = found || (err == nil && parsed.Equals(expected))
+	}
+	if !found {
+		t.Errorf("Expected the manifest's caps to include %s, got %v", testCancelCap, identity.Caps)
+	}
+}
+
+func TestSetIdentityHandler(t *testing.T) {
+	runtime := newIdentityRuntime(t)
+	runtime.SetIdentityHandler(IdentityEchoHandler)
+	emitter := &mockStreamEmitter{}
+	payload, _ := cborlib.Marshal([]byte("hello"))
+	if err := runtime.FindHandler(standard.CapIdentity)(bytesToFrameChannel(payload), emitter, &noPeerInvoker{}); err != nil {
+		t.Fatalf("Echo handler failed: %v", err)
+	}
+	var echoed []byte
+	if len(emitter.emittedData) != 1 || cborlib.Unmarshal(emitter.emittedData[0], &echoed) != nil || string(echoed) != "hello" {
+		t.Errorf("Expected the input echoed, got %v", emitter.emittedData)
+	}
+
+	// nil restores the manifest
+	runtime.SetIdentityHandler(nil)
+	emitter = &mockStreamEmitter{}
+	runtime.FindHandler(standard.CapIdentity)(bytesToFrameChannel(nil), emitter, &noPeerInvoker{})
+	var identity map[string]interface{}
+	if len(emitter.emittedData) != 1 || cborlib.Unmarshal(emitter.emittedData[0], &identity) != nil || identity["name"] != "TestPlugin" {
+		t.Errorf("Expected the manifest again, got %v", emitter.emittedData)
+	}
+}
@@ -0,0 +1,228 @@
+package bifaci
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+	"github.com/xeipuuv/gojsonschema"
+
+	"github.com/machinefabric/capdag-go/cap"
+)
+
+// SetInputValidation makes the runtime validate each argument of a request against the
+// JSON schema its media spec carries in the cap's media_specs before the handler runs,
+// in CBOR and CLI mode alike. The input of a validated cap is collected before the
+// handler starts. Invalid arguments are answered with ERR VALIDATION_ERROR, whose meta
+// "violations" lists each failing argument and path (see Frame.Violations). Arguments
+// whose media spec has no schema are passed through unchecked.
+func (pr *PluginRuntime) SetInputValidation(enabled bool) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.validateInput = enabled
+}
+
+// Violation is one way an argument fails its media spec's schema
+type Violation struct {
+	Arg     string // Media URN of the argument
+	Path    string // JSON path of the failing value, "(root)" for the argument itself
+	Message string
+}
+
+// InputValidationError is returned for a request whose arguments fail their schemas.
+// The runtime reports it as ERR VALIDATION_ERROR listing the violations.
+type InputValidationError struct {
+	CapUrn     string
+	Violations []Violation
+}
+
+func (e *InputValidationError) Error() string {
+	details := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		details[i] = fmt.Sprintf("%s %s: %s", v.Arg, v.Path, v.Message)
+	}
+	return fmt.Sprintf("invalid arguments for cap %s: %s", e.CapUrn, strings.Join(details, "; "))
+}
+
+// Violations gets the violations listed in the meta of a VALIDATION_ERROR or
+// INVALID_OUTPUT ERR frame
+func (f *Frame) Violations() []Violation {
+	if f.FrameType != FrameTypeErr || f.Meta == nil {
+		return nil
+	}
+	items, _ := f.Meta["violations"].([]interface{})
+	violations := make([]Violation, 0, len(items))
+	for _, item := range items {
+		fields, _ := normalizeMetaValue(item).(map[string]interface{})
+		arg, _ := fields["arg"].(string)
+		path, _ := fields["path"].(string)
+		message, _ := fields["message"].(string)
+		violations = append(violations, Violation{Arg: arg, Path: path, Message: message})
+	}
+	return violations
+}
+
+// setErrorDetails adds what err carries beyond its message to an ERR frame's meta
+func setErrorDetails(frame *Frame, err error) {
+	for key, value := range errorDetails(err) {
+		frame.Meta[key] = value
+	}
+}
+
+// errorDetails returns what err carries beyond its message: the details of a *CapError,
+// the "violations" of a validation error and the "exit_code" of an *ExitCodeError
+func errorDetails(err error) map[string]interface{} {
+	details := make(map[string]interface{})
+	var capErr *CapError
+	if errors.As(err, &capErr) {
+		for key, value := range capErr.Details {
+			details[key] = value
+		}
+	}
+	if violations := errorViolations(err); violations != nil {
+		details["violations"] = violations
+	}
+	var exitErr *ExitCodeError
+	if errors.As(err, &exitErr) {
+		details["exit_code"] = exitErr.ExitCode
+	}
+	return details
+}
+
+// errorViolations returns the violations of an *InputValidationError or
+// *OutputValidationError in err as meta values, nil if there is none
+func errorViolations(err error) []interface{} {
+	var list []Violation
+	var inputErr *InputValidationError
+	var outputErr *OutputValidationError
+	switch {
+	case errors.As(err, &inputErr):
+		list = inputErr.Violations
+	case errors.As(err, &outputErr):
+		list = outputErr.Violations
+	default:
+		return nil
+	}
+	violations := make([]interface{}, len(list))
+	for i, v := range list {
+		violations[i] = map[string]interface{}{"arg": v.Arg, "path": v.Path, "message": v.Message}
+	}
+	return violations
+}
+
+// withInputValidation wraps the handler registered under pattern in schema validation
+// of its arguments, if enabled and the manifest declares the cap. Caller holds pr.mu.
+func (pr *PluginRuntime) withInputValidation(pattern string, handler HandlerFunc) HandlerFunc {
+	if !pr.validateInput {
+		return handler
+	}
+	if capDef := pr.manifestCap(pattern); capDef != nil {
+		return validatingHandler(capDef, handler)
+	}
+	return handler
+}
+
+// validatingHandler collects a request's input, validates its arguments against capDef
+// and then replays the input to next
+func validatingHandler(capDef *cap.Cap, next HandlerFunc) HandlerFunc {
+	return func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		var input []Frame
+		for frame := range frames {
+			input = append(input, frame)
+		}
+
+		replay := make(chan Frame, len(input))
+		for _, frame := range input {
+			replay <- frame
+		}
+		close(replay)
+
+		// Input the streams can't be collected from is left for the handler to reject
+		if streams, err := CollectStreams(replay); err == nil {
+			if violations := validateArgs(capDef, streams); len(violations) > 0 {
+				return &InputValidationError{CapUrn: capDef.UrnString(), Violations: violations}
+			}
+		}
+
+		replay = make(chan Frame, len(input))
+		for _, frame := range input {
+			replay <- frame
+		}
+		close(replay)
+		return next(replay, emitter, peer)
+	}
+}
+
+// validateArgs checks each argument stream against the schema of its media spec
+func validateArgs(capDef *cap.Cap, streams []struct {
+	MediaUrn string
+	Data     []byte
+}) []Violation {
+	var violations []Violation
+	for _, stream := range streams {
+		schema := argSchema(capDef, stream.MediaUrn)
+		if schema == nil {
+			continue
+		}
+		value, err := argValue(Arg{MediaUrn: stream.MediaUrn, Data: stream.Data})
+		if err != nil {
+			violations = append(violations, Violation{Arg: stream.MediaUrn, Path: "(root)", Message: err.Error()})
+			continue
+		}
+		violations = append(violations, schemaViolations(stream.MediaUrn, schema, value)...)
+	}
+	return violations
+}
+
+// argSchema returns the schema of the media spec of the cap argument that accepts
+// mediaUrn, nil if there is none
+func argSchema(capDef *cap.Cap, mediaUrn string) interface{} {
+	for _, arg := range capDef.GetArgs() {
+		if i, err := firstAccepted(arg.MediaUrn, 1, func(int) string { return mediaUrn }); i < 0 || err != nil {
+			continue
+		}
+		for _, spec := range capDef.GetMediaSpecs() {
+			if spec.Urn == arg.MediaUrn && spec.Schema != nil {
+				return spec.Schema
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+// argValue returns an argument as the value a JSON schema describes: a value sent as
+// CBOR, e.g. a map, is taken as is, and byte or text contents are parsed as JSON
+func argValue(arg Arg) (interface{}, error) {
+	if len(arg.Data) > 0 && arg.Data[0]>>5 != 2 && arg.Data[0]>>5 != 3 && cborlib.Wellformed(arg.Data) == nil {
+		var value interface{}
+		if err := cborlib.Unmarshal(arg.Data, &value); err != nil {
+			return nil, err
+		}
+		return normalizeMetaValue(value), nil
+	}
+	data, err := arg.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("not valid JSON: %v", err)
+	}
+	return value, nil
+}
+
+// schemaViolations validates value against schema
+func schemaViolations(mediaUrn string, schema interface{}, value interface{}) []Violation {
+	result, err := gojsonschema.Validate(gojsonschema.NewGoLoader(schema), gojsonschema.NewGoLoader(value))
+	if err != nil {
+		return []Violation{{Arg: mediaUrn, Path: "(root)", Message: fmt.Sprintf("schema could not be applied: %v", err)}}
+	}
+	violations := make([]Violation, 0, len(result.Errors()))
+	for _, resultErr := range result.Errors() {
+		violations = append(violations, Violation{Arg: mediaUrn, Path: resultErr.Field(), Message: resultErr.Description()})
+	}
+	return violations
+}
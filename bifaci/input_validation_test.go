@@ -0,0 +1,107 @@
+package bifaci
+
+import (
+	"testing"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+)
+
+const testOrderCap = `cap:in="media:void";op=order;out="media:void"`
+
+const testValidationManifest = `{"name":"TestPlugin","version":"1.0.0","description":"Test plugin","caps":[` +
+	`{"urn":"cap:in=\"media:void\";op=order;out=\"media:void\"","title":"Order","command":"order",` +
+	`"media_specs":[{"urn":"media:order;record;textable","media_type":"application/json","schema":` +
+	`{"type":"object","required":["qty"],"properties":{"qty":{"type":"integer","minimum":1}}}}],` +
+	`"args":[{"media_urn":"media:order;record;textable","required":true,"sources":[{"cli_flag":"--order"}]},` +
+	`{"media_urn":"media:note;textable","required":false,"sources":[{"cli_flag":"--note"}]}]}]}`
+
+// sendOrder sends a request whose media:order argument is value and returns its response
+func sendOrder(t *testing.T, reader *FrameReader, writer *FrameWriter, value interface{}) []*Frame {
+	t.Helper()
+	reqId := NewMessageIdRandom()
+	payload, _ := cborlib.Marshal(value)
+	note, _ := cborlib.Marshal("not JSON, and unchecked")
+	for _, frame := range []*Frame{
+		NewReq(reqId, testOrderCap, nil, "application/cbor"),
+		NewStreamStart(reqId, "arg-0", "media:order;record;textable"),
+		NewChunk(reqId, "arg-0", 0, payload, 0, ComputeChecksum(payload)),
+		NewStreamEnd(reqId, "arg-0", 1),
+		NewStreamStart(reqId, "arg-1", "media:note;textable"),
+		NewChunk(reqId, "arg-1", 0, note, 0, ComputeChecksum(note)),
+		NewStreamEnd(reqId, "arg-1", 1),
+		NewEnd(reqId, nil),
+	} {
+		if err := writer.WriteFrame(frame); err != nil {
+			t.Fatalf("WriteFrame failed: %v", err)
+		}
+	}
+	return readUntilTerminal(t, reader, reqId)
+}
+
+func TestInputValidation(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testValidationManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.SetInputValidation(true)
+	runtime.Register(testOrderCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		args, err := CollectArgs(frames)
+		if err != nil {
+			return err
+		}
+		order, err := args.RequireString("media:order")
+		if err != nil {
+			return err
+		}
+		return emitter.EmitCbor(order)
+	})
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	defer stop()
+
+	// Valid JSON text reaches the handler intact
+	frames := sendOrder(t, reader, writer, `{"qty":2}`)
+	if last := frames[len(frames)-1]; last.FrameType != FrameTypeEnd {
+		t.Fatalf("Expected END for a valid order, got %v %s", last.FrameType, last.ErrorMessage())
+	}
+
+	// A CBOR map is validated as the value it is
+	frames = sendOrder(t, reader, writer, map[string]interface{}{"qty": 0})
+	last := frames[len(frames)-1]
+	if last.FrameType != FrameTypeErr || last.ErrorCode() != "VALIDATION_ERROR" {
+		t.Fatalf("Expected ERR VALIDATION_ERROR, got %v %s", last.FrameType, last.ErrorCode())
+	}
+	violations := last.Violations()
+	if len(violations) != 1 || violations[0].Arg != "media:order;record;textable" || violations[0].Path != "qty" {
+		t.Errorf("Expected one violation at qty, got %+v", violations)
+	}
+
+	frames = sendOrder(t, reader, writer, `{"size":2}`)
+	if violations := frames[len(frames)-1].Violations(); len(violations) != 1 || violations[0].Path != "(root)" {
+		t.Errorf("Expected a missing qty violation at the root, got %+v", violations)
+	}
+
+	frames = sendOrder(t, reader, writer, `{"qty":`)
+	if violations := frames[len(frames)-1].Violations(); len(violations) != 1 || violations[0].Path != "(root)" {
+		t.Errorf("Expected an invalid JSON violation, got %+v", violations)
+	}
+}
+
+// Without SetInputValidation arguments reach the handler unchecked
+func TestInputValidationIsOptIn(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testValidationManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.Register(testOrderCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		return emitter.EmitCbor("accepted")
+	})
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	defer stop()
+
+	frames := sendOrder(t, reader, writer, `{"qty":0}`)
+	if last := frames[len(frames)-1]; last.FrameType != FrameTypeEnd {
+		t.Errorf("Expected END without validation, got %v %s", last.FrameType, last.ErrorMessage())
+	}
+}
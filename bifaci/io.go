@@ -1,6 +1,8 @@
 package bifaci
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -11,8 +13,13 @@ import (
 
 // FrameReader reads length-prefixed CBOR frames from a stream
 type FrameReader struct {
-	reader io.Reader
-	limits Limits
+	reader     io.Reader
+	limits     Limits
+	strict     bool
+	compressed streamCompressions                 // Compressed streams being read
+	observe    func(frame *Frame, encoded []byte) // Metrics and recording hook, nil if unset
+	codec      WireCodec                          // "" = WireCBOR (see SetCodec)
+	lines      *bufio.Reader                      // Buffers reader for WireJSON, nil until needed
 }
 
 // NewFrameReader creates a new FrameReader
@@ -28,8 +35,53 @@ func (fr *FrameReader) SetLimits(limits Limits) {
 	fr.limits = limits
 }
 
+// SetStrict makes the reader decode with DecodeFrameStrict rules, failing with a
+// *FrameDecodeError on frames the lenient decoder would accept; CHUNK payloads are
+// checked against the reader's limits
+func (fr *FrameReader) SetStrict(strict bool) {
+	fr.strict = strict
+}
+
+// SetCodec sets how the reader's frames are serialized, WireCBOR by default. The
+// observe hook is still handed each frame's CBOR encoding.
+func (fr *FrameReader) SetCodec(codec WireCodec) {
+	fr.codec = codec
+}
+
 // ReadFrame reads a single frame from the stream
 func (fr *FrameReader) ReadFrame() (*Frame, error) {
+	var frameBuf []byte
+	var err error
+	if fr.codec == WireJSON {
+		frameBuf, err = fr.readJSONFrame()
+	} else {
+		frameBuf, err = fr.readCBORFrame()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode frame
+	var frame *Frame
+	if fr.strict {
+		frame, err = decodeFrameStrict(frameBuf, fr.limits)
+	} else {
+		frame, err = DecodeFrame(frameBuf)
+	}
+	if err == nil && frame.FrameType == FrameTypeChunk && fr.limits.Checksum.normalize() != ChecksumFNV1a64 {
+		frame.checksumAlg = fr.limits.Checksum
+	}
+	if err == nil {
+		frame, err = fr.decompress(frame)
+	}
+	if err == nil && fr.observe != nil {
+		fr.observe(frame, frameBuf)
+	}
+	return frame, err
+}
+
+// readCBORFrame reads the CBOR encoding of the next frame behind its length prefix
+func (fr *FrameReader) readCBORFrame() ([]byte, error) {
 	// Read 4-byte length prefix (big-endian)
 	var lengthBuf [4]byte
 	if _, err := io.ReadFull(fr.reader, lengthBuf[:]); err != nil {
@@ -53,15 +105,40 @@ func (fr *FrameReader) ReadFrame() (*Frame, error) {
 	if _, err := io.ReadFull(fr.reader, frameBuf); err != nil {
 		return nil, err
 	}
+	return frameBuf, nil
+}
 
-	// Decode frame
-	return DecodeFrame(frameBuf)
+// decompress undoes the stream compression of the frames of flagged streams. The flag
+// is removed from STREAM_START, so frames forwarded to another connection go out plain
+// unless their sender flags them again.
+func (fr *FrameReader) decompress(frame *Frame) (*Frame, error) {
+	if algorithm := frame.StreamCompression(); algorithm != "" {
+		compressor := lookupCompressor(algorithm)
+		if algorithm != fr.limits.Compression || compressor == nil {
+			return nil, fmt.Errorf("stream compressed with %q, which was not negotiated", algorithm)
+		}
+		if fr.compressed == nil {
+			fr.compressed = make(streamCompressions)
+		}
+		fr.compressed.track(frame, compressor)
+		return withoutStreamCompression(frame), nil
+	}
+	if compressor := fr.compressed.lookup(frame); compressor != nil {
+		return decompressChunk(frame, compressor, fr.limits.MaxFrame), nil
+	}
+	if len(fr.compressed) > 0 {
+		fr.compressed.track(frame, nil)
+	}
+	return frame, nil
 }
 
 // FrameWriter writes length-prefixed CBOR frames to a stream
 type FrameWriter struct {
-	writer io.Writer
-	limits Limits
+	writer     io.Writer
+	limits     Limits
+	compressed streamCompressions                 // Compressed streams being written
+	observe    func(frame *Frame, encoded []byte) // Metrics and recording hook, nil if unset
+	codec      WireCodec                          // "" = WireCBOR (see SetCodec)
 }
 
 // NewFrameWriter creates a new FrameWriter
@@ -77,39 +154,167 @@ func (fw *FrameWriter) SetLimits(limits Limits) {
 	fw.limits = limits
 }
 
-// WriteFrame writes a single frame to the stream
+// SetCodec sets how the writer's frames are serialized, WireCBOR by default. Limits
+// apply to each frame's CBOR encoding, which the observe hook is still handed.
+func (fw *FrameWriter) SetCodec(codec WireCodec) {
+	fw.codec = codec
+}
+
+// NewChunk builds a CHUNK frame whose checksum uses the algorithm negotiated for this
+// writer's connection
+func (fw *FrameWriter) NewChunk(reqId MessageId, streamId string, seq uint64, payload []byte, chunkIndex uint64) *Frame {
+	return newChunkWith(fw.limits.Checksum, reqId, streamId, seq, payload, chunkIndex)
+}
+
+func newChunkWith(algorithm ChecksumAlgorithm, reqId MessageId, streamId string, seq uint64, payload []byte, chunkIndex uint64) *Frame {
+	frame := NewChunk(reqId, streamId, seq, payload, chunkIndex, algorithm.Sum(payload))
+	if algorithm.normalize() != ChecksumFNV1a64 {
+		frame.checksumAlg = algorithm
+	}
+	return frame
+}
+
+// restampChecksum returns frame with its checksum recomputed under algorithm, for a
+// CHUNK built for (or read from) a connection that uses another one, e.g. one a relay
+// forwards. A checksum that doesn't verify is kept: it fails verification downstream
+// too, instead of a fresh one vouching for a corrupted payload.
+func restampChecksum(frame *Frame, algorithm ChecksumAlgorithm) *Frame {
+	if frame.FrameType != FrameTypeChunk || frame.Checksum == nil ||
+		frame.checksumAlg.normalize() == algorithm.normalize() || VerifyChunkChecksum(frame) != nil {
+		return frame
+	}
+	stamped := *frame // The caller may still hold frame, e.g. to replay it
+	checksum := algorithm.Sum(frame.Payload)
+	stamped.Checksum = &checksum
+	stamped.checksumAlg = ""
+	if algorithm.normalize() != ChecksumFNV1a64 {
+		stamped.checksumAlg = algorithm
+	}
+	return &stamped
+}
+
+// WriteFrame writes a single frame to the stream. The frame is encoded, length prefix
+// included, into a pooled buffer and written with a single Write.
 func (fw *FrameWriter) WriteFrame(frame *Frame) error {
-	// Encode frame to CBOR
-	frameBuf, err := EncodeFrame(frame)
+	buf := getFrameBuffer()
+	defer putFrameBuffer(buf)
+
+	frame, offset, err := fw.appendFrame(buf, frame)
 	if err != nil {
 		return err
 	}
-
-	// Enforce max_frame limit
-	if len(frameBuf) > fw.limits.MaxFrame {
-		return fmt.Errorf("encoded frame size %d exceeds max_frame limit %d", len(frameBuf), fw.limits.MaxFrame)
+	if err := fw.write(buf, frame); err != nil {
+		return err
 	}
 
-	// Hard limit check
-	if len(frameBuf) > MaxFrameHardLimit {
-		return fmt.Errorf("encoded frame size %d exceeds hard limit %d", len(frameBuf), MaxFrameHardLimit)
+	// The encoding handed to the hook is only valid during the call
+	if fw.observe != nil {
+		fw.observe(frame, buf.Bytes()[offset:])
 	}
+	return nil
+}
 
-	// Write 4-byte length prefix (big-endian)
-	var lengthBuf [4]byte
-	binary.BigEndian.PutUint32(lengthBuf[:], uint32(len(frameBuf)))
-	if _, err := fw.writer.Write(lengthBuf[:]); err != nil {
-		return err
+// WriteFrames writes frames to the stream in order with a single Write, so that e.g. a
+// stream's STREAM_END and the request's END go out in one syscall. If any frame fails to
+// encode or exceeds the limits, nothing is written.
+func (fw *FrameWriter) WriteFrames(frames ...*Frame) error {
+	buf := getFrameBuffer()
+	defer putFrameBuffer(buf)
+
+	written := make([]*Frame, len(frames))
+	bounds := make([]int, 2*len(frames))
+	for i, frame := range frames {
+		frame, offset, err := fw.appendFrame(buf, frame)
+		if err != nil {
+			return err
+		}
+		written[i] = frame
+		bounds[2*i], bounds[2*i+1] = offset, buf.Len()
 	}
-
-	// Write CBOR payload
-	if _, err := fw.writer.Write(frameBuf); err != nil {
+	if err := fw.write(buf, written...); err != nil {
 		return err
 	}
 
+	if fw.observe != nil {
+		for i, frame := range written {
+			fw.observe(frame, buf.Bytes()[bounds[2*i]:bounds[2*i+1]])
+		}
+	}
 	return nil
 }
 
+// write writes frames, whose length-prefixed CBOR encodings buf holds, in the writer's
+// codec with a single Write
+func (fw *FrameWriter) write(buf *bytes.Buffer, frames ...*Frame) error {
+	out := buf.Bytes()
+	if fw.codec == WireJSON {
+		var err error
+		if out, err = encodeJSONFrames(frames...); err != nil {
+			return err
+		}
+	}
+	_, err := fw.writer.Write(out)
+	return err
+}
+
+// appendFrame appends frame, as it goes on the wire, to buf and returns the frame as
+// written along with the offset of its CBOR encoding in buf
+func (fw *FrameWriter) appendFrame(buf *bytes.Buffer, frame *Frame) (*Frame, int, error) {
+	frame = restampChecksum(frame, fw.limits.Checksum)
+	frame, err := fw.compress(frame)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Encode frame to CBOR behind its 4-byte length prefix (big-endian)
+	offset, err := appendLengthPrefixed(buf, frame)
+	if err != nil {
+		return nil, 0, err
+	}
+	size := buf.Len() - offset
+
+	// Enforce max_frame limit
+	if size > fw.limits.MaxFrame {
+		return nil, 0, fmt.Errorf("encoded frame size %d exceeds max_frame limit %d", size, fw.limits.MaxFrame)
+	}
+
+	// Hard limit check
+	if size > MaxFrameHardLimit {
+		return nil, 0, fmt.Errorf("encoded frame size %d exceeds hard limit %d", size, MaxFrameHardLimit)
+	}
+	return frame, offset, nil
+}
+
+// compress applies the stream compression of flagged streams to their CHUNK payloads.
+// A flag naming anything but the negotiated compression is dropped, and the stream is
+// sent plain.
+func (fw *FrameWriter) compress(frame *Frame) (*Frame, error) {
+	if algorithm := frame.StreamCompression(); algorithm != "" {
+		compressor := lookupCompressor(algorithm)
+		if algorithm != fw.limits.Compression || compressor == nil {
+			return withoutStreamCompression(frame), nil
+		}
+		if fw.compressed == nil {
+			fw.compressed = make(streamCompressions)
+		}
+		fw.compressed.track(frame, compressor)
+		return frame, nil
+	}
+	if compressor := fw.compressed.lookup(frame); compressor != nil {
+		payload, err := compressor.Compress(frame.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress CHUNK: %w", err)
+		}
+		compressed := *frame // The caller may still hold frame
+		compressed.Payload = payload
+		return &compressed, nil
+	}
+	if len(fw.compressed) > 0 {
+		fw.compressed.track(frame, nil)
+	}
+	return frame, nil
+}
+
 // WriteResponseWithChunking writes a response with automatic chunking for large payloads.
 // Uses stream multiplexing protocol: STREAM_START + CHUNK + STREAM_END + END
 func (fw *FrameWriter) WriteResponseWithChunking(requestId MessageId, streamId string, mediaUrn string, payload []byte) error {
@@ -130,8 +335,7 @@ func (fw *FrameWriter) WriteResponseWithChunking(requestId MessageId, streamId s
 			chunkSize := min(remaining, fw.limits.MaxChunk)
 			chunkData := payload[offset : offset+chunkSize]
 
-			checksum := ComputeChecksum(chunkData)
-			frame := NewChunk(requestId, streamId, seq, chunkData, chunkIndex, checksum)
+			frame := fw.NewChunk(requestId, streamId, seq, chunkData, chunkIndex)
 			if err := fw.WriteFrame(frame); err != nil {
 				return err
 			}
@@ -157,14 +361,23 @@ func (fw *FrameWriter) WriteResponseWithChunking(requestId MessageId, streamId s
 
 // HandshakeAccept performs handshake from plugin side
 func HandshakeAccept(reader *FrameReader, writer *FrameWriter, manifestData []byte) (Limits, error) {
+	limits, _, err := handshakeAccept(reader, writer, manifestData, nil)
+	return limits, err
+}
+
+// handshakeAccept is HandshakeAccept that also returns the host's HELLO frame
+// (the runtime reads the connection-level trace context from it). ready, if not nil,
+// is called once the host's HELLO is read, before it is answered; if it fails the host
+// gets ERR START_FAILED instead of HELLO.
+func handshakeAccept(reader *FrameReader, writer *FrameWriter, manifestData []byte, ready func() error) (Limits, *Frame, error) {
 	// 1. Read HELLO from host
 	helloFrame, err := reader.ReadFrame()
 	if err != nil {
-		return Limits{}, fmt.Errorf("failed to read HELLO: %w", err)
+		return Limits{}, nil, fmt.Errorf("failed to read HELLO: %w", err)
 	}
 
 	if helloFrame.FrameType != FrameTypeHello {
-		return Limits{}, errors.New("expected HELLO frame")
+		return Limits{}, nil, errors.New("expected HELLO frame")
 	}
 
 	// 2. Decode host limits from Meta map
@@ -180,23 +393,115 @@ func HandshakeAccept(reader *FrameReader, writer *FrameWriter, manifestData []by
 	if hostLimits.MaxReorderBuffer == 0 {
 		hostLimits.MaxReorderBuffer = DefaultMaxReorderBuffer
 	}
+	// No default for the window: a host that doesn't advertise one never sends ACK
+	hostLimits.MaxWindow = extractIntFromMeta(helloFrame.Meta, "max_window")
+	// The first checksum algorithm and compression the host offers that we implement
+	checksum := negotiateChecksum(checksumsFromMeta(helloFrame.Meta))
+	compression := negotiateCompression(compressionsFromMeta(helloFrame.Meta))
+	// The runtime schedules by priority whenever the host offers it
+	priorities, _ := helloFrame.Meta["priorities"].(bool)
+
+	if ready != nil {
+		if err := ready(); err != nil {
+			errFrame := NewErr(NewMessageIdDefault(), string(ErrCodeStartFailed), err.Error())
+			if writeErr := writer.WriteFrame(errFrame); writeErr != nil {
+				return Limits{}, nil, fmt.Errorf("failed to write ERR: %w", writeErr)
+			}
+			return Limits{}, nil, err
+		}
+	}
 
 	// 3. Send HELLO back with manifest
 	responseFrame := NewHelloWithManifest(DefaultMaxFrame, DefaultMaxChunk, DefaultMaxReorderBuffer, manifestData)
+	responseFrame.Meta["max_window"] = DefaultMaxWindow
+	if checksum != ChecksumFNV1a64 {
+		responseFrame.Meta["checksum"] = string(checksum)
+	}
+	if compression != "" {
+		responseFrame.Meta["compression"] = string(compression)
+	}
+	if priorities {
+		responseFrame.Meta["priorities"] = true
+	}
 	if err := writer.WriteFrame(responseFrame); err != nil {
-		return Limits{}, fmt.Errorf("failed to write HELLO response: %w", err)
+		return Limits{}, nil, fmt.Errorf("failed to write HELLO response: %w", err)
 	}
 
 	// 4. Negotiate limits (min of both sides)
 	negotiated := NegotiateLimits(DefaultLimits(), hostLimits)
+	if checksum != ChecksumFNV1a64 {
+		negotiated.Checksum = checksum
+	}
+	negotiated.Compression = compression
+	negotiated.Priorities = priorities
 
-	return negotiated, nil
+	return negotiated, helloFrame, nil
 }
 
-// HandshakeInitiate performs handshake from host side
+// HandshakeInitiate performs handshake from host side.
+// No flow-control window is offered, so the plugin never waits for ACK.
 func HandshakeInitiate(reader *FrameReader, writer *FrameWriter) ([]byte, Limits, error) {
+	return HandshakeInitiateWithWindow(reader, writer, 0)
+}
+
+// HandshakeInitiateWithWindow performs handshake from host side, offering a flow-control
+// window of maxWindow CHUNK frames per request. If the plugin accepts (negotiated MaxWindow > 0)
+// the host must send ACK frames as it consumes response chunks, or the plugin will stall.
+func HandshakeInitiateWithWindow(reader *FrameReader, writer *FrameWriter, maxWindow int) ([]byte, Limits, error) {
+	return HandshakeInitiateWithTrace(reader, writer, maxWindow, TraceContext{})
+}
+
+// HandshakeInitiateWithTrace is HandshakeInitiateWithWindow that also sends trace in the
+// HELLO frame. The plugin uses it as the parent span of every request on the connection
+// that does not carry its own trace context in REQ.
+func HandshakeInitiateWithTrace(reader *FrameReader, writer *FrameWriter, maxWindow int, trace TraceContext) ([]byte, Limits, error) {
+	return HandshakeInitiateWithOptions(reader, writer, HandshakeOptions{MaxWindow: maxWindow, Trace: trace})
+}
+
+// HandshakeOptions is what the host offers in its HELLO
+type HandshakeOptions struct {
+	MaxWindow int          // Flow-control window in CHUNK frames per request, 0 = none
+	Trace     TraceContext // Parent span for requests without their own trace context
+	// Checksums are the CHUNK checksum algorithms the host accepts besides FNV-1a, in
+	// preference order. The plugin picks the first it implements; peers that predate
+	// negotiation ignore the offer and keep FNV-1a.
+	Checksums []ChecksumAlgorithm
+	// Compressions are the stream compressions the host decodes, in preference order.
+	// Either side may then compress the streams it sends with the plugin's pick.
+	Compressions []CompressionAlgorithm
+	// Priorities asks the plugin to honor REQ priorities (see Frame.SetPriority).
+	// Plugins that predate priorities ignore the offer and treat every REQ alike.
+	Priorities bool
+}
+
+// HandshakeInitiateWithOptions performs the handshake from the host side with all
+// negotiable options
+func HandshakeInitiateWithOptions(reader *FrameReader, writer *FrameWriter, options HandshakeOptions) ([]byte, Limits, error) {
+	maxWindow := options.MaxWindow
+
 	// 1. Send HELLO with our limits
 	helloFrame := NewHello(DefaultMaxFrame, DefaultMaxChunk, DefaultMaxReorderBuffer)
+	if maxWindow > 0 {
+		helloFrame.Meta["max_window"] = maxWindow
+	}
+	if len(options.Checksums) > 0 {
+		offered := make([]string, len(options.Checksums))
+		for i, algorithm := range options.Checksums {
+			offered[i] = string(algorithm.normalize())
+		}
+		helloFrame.Meta["checksums"] = offered
+	}
+	if len(options.Compressions) > 0 {
+		offered := make([]string, len(options.Compressions))
+		for i, algorithm := range options.Compressions {
+			offered[i] = string(algorithm)
+		}
+		helloFrame.Meta["compressions"] = offered
+	}
+	if options.Priorities {
+		helloFrame.Meta["priorities"] = true
+	}
+	helloFrame.SetTraceContext(options.Trace)
 	if err := writer.WriteFrame(helloFrame); err != nil {
 		return nil, Limits{}, fmt.Errorf("failed to write HELLO: %w", err)
 	}
@@ -207,6 +512,9 @@ func HandshakeInitiate(reader *FrameReader, writer *FrameWriter) ([]byte, Limits
 		return nil, Limits{}, fmt.Errorf("failed to read HELLO response: %w", err)
 	}
 
+	if capErr := ErrorFromFrame(responseFrame); capErr != nil {
+		return nil, Limits{}, fmt.Errorf("plugin refused the handshake: %w", capErr)
+	}
 	if responseFrame.FrameType != FrameTypeHello {
 		return nil, Limits{}, errors.New("expected HELLO response")
 	}
@@ -232,9 +540,28 @@ func HandshakeInitiate(reader *FrameReader, writer *FrameWriter) ([]byte, Limits
 	if pluginLimits.MaxReorderBuffer == 0 {
 		pluginLimits.MaxReorderBuffer = DefaultMaxReorderBuffer
 	}
+	pluginLimits.MaxWindow = extractIntFromMeta(responseFrame.Meta, "max_window")
+	if checksum, ok := responseFrame.Meta["checksum"].(string); ok {
+		pluginLimits.Checksum = ChecksumAlgorithm(checksum)
+		if !checksumOffered(pluginLimits.Checksum, options.Checksums) {
+			return nil, Limits{}, fmt.Errorf("plugin chose checksum %q, which was not offered", checksum)
+		}
+	}
+	if compression, ok := responseFrame.Meta["compression"].(string); ok {
+		pluginLimits.Compression = CompressionAlgorithm(compression)
+		if !compressionOffered(pluginLimits.Compression, options.Compressions) {
+			return nil, Limits{}, fmt.Errorf("plugin chose compression %q, which was not offered", compression)
+		}
+	}
+	pluginLimits.Priorities, _ = responseFrame.Meta["priorities"].(bool)
 
 	// 5. Negotiate limits
-	negotiated := NegotiateLimits(DefaultLimits(), pluginLimits)
+	ownLimits := DefaultLimits()
+	ownLimits.MaxWindow = maxWindow
+	ownLimits.Checksum = pluginLimits.Checksum
+	ownLimits.Compression = pluginLimits.Compression
+	ownLimits.Priorities = options.Priorities
+	negotiated := NegotiateLimits(ownLimits, pluginLimits)
 
 	return manifestData, negotiated, nil
 }
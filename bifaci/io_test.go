@@ -911,3 +911,90 @@ func Test400a_relay_state_roundtrip(t *testing.T) {
 		t.Errorf("Payload mismatch after roundtrip: got %s", string(decoded.Payload))
 	}
 }
+
+// TestCancelFrameRoundtrip: CANCEL encode/decode roundtrip preserves type and request ID
+func TestCancelFrameRoundtrip(t *testing.T) {
+	id := NewMessageIdRandom()
+
+	encoded, err := EncodeFrame(NewCancel(id))
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	decoded, err := DecodeFrame(encoded)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	if decoded.FrameType != FrameTypeCancel {
+		t.Errorf("Expected CANCEL, got %v", decoded.FrameType)
+	}
+	if !decoded.Id.Equals(id) {
+		t.Error("Request ID mismatch after roundtrip")
+	}
+}
+
+// TestAckFrameRoundtrip: ACK credit survives encode/decode
+func TestAckFrameRoundtrip(t *testing.T) {
+	id := NewMessageIdRandom()
+
+	encoded, err := EncodeFrame(NewAck(id, 64))
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	decoded, err := DecodeFrame(encoded)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	if decoded.FrameType != FrameTypeAck {
+		t.Errorf("Expected ACK, got %v", decoded.FrameType)
+	}
+	if !decoded.Id.Equals(id) {
+		t.Error("Request ID mismatch after roundtrip")
+	}
+	if decoded.AckCredit() != 64 {
+		t.Errorf("Expected credit 64, got %d", decoded.AckCredit())
+	}
+}
+
+// TestHandshakeWindowNegotiation: the window is the minimum of both sides and is
+// disabled unless the host offers one
+func TestHandshakeWindowNegotiation(t *testing.T) {
+	tests := []struct {
+		hostWindow int
+		expected   int
+	}{
+		{0, 0},
+		{8, 8},
+		{DefaultMaxWindow * 2, DefaultMaxWindow},
+	}
+
+	for _, tt := range tests {
+		hostToPluginR, hostToPluginW := io.Pipe()
+		pluginToHostR, pluginToHostW := io.Pipe()
+
+		pluginLimits := make(chan Limits, 1)
+		go func() {
+			limits, err := HandshakeAccept(NewFrameReader(hostToPluginR), NewFrameWriter(pluginToHostW), []byte(`{}`))
+			if err != nil {
+				t.Errorf("HandshakeAccept failed: %v", err)
+			}
+			pluginLimits <- limits
+		}()
+
+		_, hostLimits, err := HandshakeInitiateWithWindow(NewFrameReader(pluginToHostR), NewFrameWriter(hostToPluginW), tt.hostWindow)
+		if err != nil {
+			t.Fatalf("HandshakeInitiateWithWindow failed: %v", err)
+		}
+		plugin := <-pluginLimits
+
+		if hostLimits.MaxWindow != tt.expected {
+			t.Errorf("host window %d: host negotiated %d, expected %d", tt.hostWindow, hostLimits.MaxWindow, tt.expected)
+		}
+		if plugin.MaxWindow != tt.expected {
+			t.Errorf("host window %d: plugin negotiated %d, expected %d", tt.hostWindow, plugin.MaxWindow, tt.expected)
+		}
+	}
+}
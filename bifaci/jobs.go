@@ -0,0 +1,635 @@
+package bifaci
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+	"github.com/google/uuid"
+	"github.com/machinefabric/capdag-go/standard"
+)
+
+// JobState is the state of an async job
+type JobState string
+
+const (
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+	JobCancelled JobState = "cancelled"
+)
+
+// Job is a request to a cap registered with RegisterAsync, run on after the request
+// was answered with ACCEPTED
+type Job struct {
+	Id        string       `cbor:"id"`
+	CapUrn    string       `cbor:"cap"`
+	State     JobState     `cbor:"state"`
+	CreatedMs int64        `cbor:"created_ms"` // Unix time the request was accepted
+	UpdatedMs int64        `cbor:"updated_ms"` // Unix time of the last change
+	Progress  *JobProgress `cbor:"progress,omitempty"`
+	ErrCode   string       `cbor:"error_code,omitempty"` // Code of the ERR the job failed with
+	ErrMsg    string       `cbor:"error_message,omitempty"`
+	Output    []JobOutput  `cbor:"output,omitempty"` // Values emitted so far, in order
+}
+
+// JobProgress is the last progress a job reported (see EmitProgress)
+type JobProgress struct {
+	Stage     string `cbor:"stage"`
+	Completed uint64 `cbor:"completed"`
+	Total     uint64 `cbor:"total"` // 0 if unknown
+}
+
+// JobOutput is a value a job emitted
+type JobOutput struct {
+	Stream   int    `cbor:"stream"` // 0 for the primary stream, else the order OpenStream opened it in
+	MediaUrn string `cbor:"media_urn"`
+	Cbor     []byte `cbor:"cbor"` // The value, CBOR-encoded
+}
+
+// clone returns a copy of the job that shares nothing with it
+func (j *Job) clone() *Job {
+	copied := *j
+	if j.Progress != nil {
+		progress := *j.Progress
+		copied.Progress = &progress
+	}
+	copied.Output = append([]JobOutput(nil), j.Output...)
+	return &copied
+}
+
+// JobStore keeps the jobs of a runtime (see SetJobStore). Implementations must be safe
+// for concurrent use.
+type JobStore interface {
+	// Put stores a job, replacing the one with its ID
+	Put(job *Job) error
+	// Get returns the job with the ID, nil if there is none
+	Get(id string) (*Job, error)
+}
+
+// MemoryJobStore is a JobStore keeping jobs in memory, lost when the plugin exits
+type MemoryJobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// NewMemoryJobStore returns an empty in-memory job store
+func NewMemoryJobStore() *MemoryJobStore {
+	return &MemoryJobStore{jobs: make(map[string]*Job)}
+}
+
+func (s *MemoryJobStore) Put(job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.Id] = job.clone()
+	return nil
+}
+
+func (s *MemoryJobStore) Get(id string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, nil
+	}
+	return job.clone(), nil
+}
+
+// FileJobStore is a JobStore keeping each job in a CBOR file of a directory, so jobs
+// outlive the plugin process: a restarted plugin still answers for the jobs its
+// predecessor finished. Jobs running when the process exited are reported failed with
+// PLUGIN_DIED.
+type FileJobStore struct {
+	dir string
+	mu  sync.Mutex // Serializes writes of the same job
+}
+
+// NewFileJobStore returns a store of jobs in dir, creating the directory if needed
+func NewFileJobStore(dir string) (*FileJobStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create job store directory: %w", err)
+	}
+	return &FileJobStore{dir: dir}, nil
+}
+
+// Put writes the job to a temporary file first, so a crash never leaves it half written
+func (s *FileJobStore) Put(job *Job) error {
+	path, err := s.path(job.Id)
+	if err != nil {
+		return err
+	}
+	data, err := cborlib.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to encode job %s: %w", job.Id, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write job %s: %w", job.Id, err)
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *FileJobStore) Get(id string) (*Job, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read job %s: %w", id, err)
+	}
+	var job Job
+	if err := cborlib.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("failed to decode job %s: %w", id, err)
+	}
+	return &job, nil
+}
+
+// path returns the file of the job with the ID, which comes from requests and must not
+// reach outside the directory
+func (s *FileJobStore) path(id string) (string, error) {
+	if id == "" || filepath.Base(id) != id || id == "." || id == ".." {
+		return "", fmt.Errorf("invalid job ID %q", id)
+	}
+	return filepath.Join(s.dir, id+".cbor"), nil
+}
+
+// jobRunner runs the async jobs of a runtime (see RegisterAsync)
+type jobRunner struct {
+	mu      sync.Mutex
+	store   JobStore
+	running map[string]*jobEmitter // By job ID
+	logger  func() Logger          // The runtime's current logger (see SetLogger)
+	now     func() time.Time
+}
+
+func newJobRunner(pr *PluginRuntime) *jobRunner {
+	return &jobRunner{store: NewMemoryJobStore(), running: make(map[string]*jobEmitter), logger: pr.log, now: time.Now}
+}
+
+// SetJobStore sets where the jobs of async caps are kept (default: in memory, see
+// NewFileJobStore for jobs that outlive the process). Takes effect for jobs accepted
+// afterwards.
+func (pr *PluginRuntime) SetJobStore(store JobStore) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	jobs := pr.jobsLocked()
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	jobs.store = store
+}
+
+// RegisterAsync registers a handler for a cap URN that runs as an async job, for caps
+// taking too long to hold the request open. Once the request's input arrived the
+// runtime answers it with ACCEPTED carrying the job ID, then END, and runs the handler
+// on with an emitter writing to the job store instead of the connection: the job
+// finishes and keeps its result even if the connection that requested it is gone.
+// The requester then polls standard.CapJobStatus, fetches the output with
+// standard.CapJobResult and may cancel the job with standard.CapJobCancel, each taking
+// the job ID as text. The runtime answers those caps unless handlers are registered for
+// them. In CLI mode the handler runs in the request.
+func (pr *PluginRuntime) RegisterAsync(capUrn string, handler HandlerFunc) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	jobs := pr.jobsLocked()
+	pr.handlers[capUrn] = jobs.asyncHandler(capUrn, handler)
+	for jobCap, jobHandler := range map[string]HandlerFunc{
+		standard.CapJobStatus: jobs.statusHandler,
+		standard.CapJobResult: jobs.resultHandler,
+		standard.CapJobCancel: jobs.cancelHandler,
+	} {
+		if _, exists := pr.handlers[jobCap]; !exists {
+			pr.handlers[jobCap] = jobHandler
+		}
+	}
+}
+
+// jobsLocked returns the runtime's job runner, creating it if needed. Caller holds mu.
+func (pr *PluginRuntime) jobsLocked() *jobRunner {
+	if pr.jobs == nil {
+		pr.jobs = newJobRunner(pr)
+	}
+	return pr.jobs
+}
+
+// jobAccepter is implemented by emitters that can answer a request with ACCEPTED
+type jobAccepter interface {
+	accept(jobId string) error
+}
+
+// asyncHandler returns the handler a cap registered with RegisterAsync is served by
+func (r *jobRunner) asyncHandler(capUrn string, handler HandlerFunc) HandlerFunc {
+	return func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		var input []Frame
+		for frame := range frames {
+			input = append(input, frame)
+		}
+		accepter, ok := emitter.(jobAccepter)
+		if !ok {
+			return handler(replayFrames(input), emitter, peer)
+		}
+
+		now := r.now().UnixMilli()
+		job := &Job{Id: uuid.New().String(), CapUrn: capUrn, State: JobRunning, CreatedMs: now, UpdatedMs: now}
+		ctx, cancel := context.WithCancel(context.Background())
+		recorder := &jobEmitter{ctx: ctx, cancel: cancel, logger: r.logger, now: r.now, job: job}
+
+		r.mu.Lock()
+		store := r.store
+		recorder.store = store
+		r.running[job.Id] = recorder
+		r.mu.Unlock()
+		if err := store.Put(job); err != nil {
+			r.finished(job.Id)
+			cancel()
+			return fmt.Errorf("failed to store job: %w", err)
+		}
+		if err := accepter.accept(job.Id); err != nil {
+			recorder.cancelJob()
+			r.finished(job.Id)
+			return err
+		}
+		go r.run(recorder, handler, input, peer)
+		return nil
+	}
+}
+
+// run runs a job's handler to its end. A panic fails the job rather than taking down
+// the plugin, as no request is left to answer with ERR.
+func (r *jobRunner) run(emitter *jobEmitter, handler HandlerFunc, input []Frame, peer PeerInvoker) {
+	defer r.finished(emitter.job.Id)
+	var err error
+	func() {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("handler panicked: %v", p)
+			}
+		}()
+		err = handler(replayFrames(input), emitter, peer)
+	}()
+	emitter.finish(err)
+}
+
+func (r *jobRunner) finished(jobId string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if emitter, ok := r.running[jobId]; ok {
+		emitter.cancel()
+		delete(r.running, jobId)
+	}
+}
+
+// lookup returns the job with the ID a job cap request names. A job the store has as
+// running that this runtime doesn't run was cut short by the process exiting.
+func (r *jobRunner) lookup(frames <-chan Frame) (*Job, error) {
+	raw, err := CollectFirstArg(frames)
+	if err != nil {
+		return nil, err
+	}
+	var jobId string
+	if err := decodeTypedArg(raw, &jobId); err != nil {
+		return nil, NewCapError(ErrCodeInvalidArgument, fmt.Sprintf("job ID must be text: %v", err))
+	}
+
+	r.mu.Lock()
+	store := r.store
+	emitter, running := r.running[jobId]
+	r.mu.Unlock()
+	if running {
+		return emitter.snapshot(), nil
+	}
+	job, err := store.Get(jobId)
+	if err != nil {
+		return nil, err
+	}
+	if job == nil {
+		return nil, NewCapError(ErrCodeJobNotFound, fmt.Sprintf("no job %s", jobId))
+	}
+	if job.State == JobRunning {
+		job.State = JobFailed
+		job.ErrCode = string(ErrCodePluginDied)
+		job.ErrMsg = "the plugin exited while the job was running"
+		job.UpdatedMs = r.now().UnixMilli()
+		if err := store.Put(job); err != nil {
+			r.logger().Error("failed to store job", "job_id", jobId, "error", err)
+		}
+	}
+	return job, nil
+}
+
+// statusHandler answers the job status cap with the job, without its output
+func (r *jobRunner) statusHandler(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+	job, err := r.lookup(frames)
+	if err != nil {
+		return err
+	}
+	job.Output = nil
+	return emitter.EmitCbor(job)
+}
+
+// resultHandler answers the job result cap with the output of a job that succeeded,
+// each of its streams on a stream of its own, or with the ERR of one that didn't
+func (r *jobRunner) resultHandler(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+	job, err := r.lookup(frames)
+	if err != nil {
+		return err
+	}
+	switch job.State {
+	case JobRunning:
+		return NewCapError(ErrCodeJobRunning, fmt.Sprintf("job %s is still running", job.Id))
+	case JobCancelled:
+		return NewCapError(ErrCodeCancelled, fmt.Sprintf("job %s was cancelled", job.Id))
+	case JobFailed:
+		return NewCapError(ErrCode(job.ErrCode), job.ErrMsg)
+	}
+
+	streams := make(map[int]OutputStream)
+	for _, output := range job.Output {
+		stream, ok := streams[output.Stream]
+		if !ok {
+			if stream, err = OpenStream(emitter, output.MediaUrn); err != nil {
+				return err
+			}
+			streams[output.Stream] = stream
+		}
+		var value interface{}
+		if err := cborlib.Unmarshal(output.Cbor, &value); err != nil {
+			return fmt.Errorf("failed to decode output of job %s: %w", job.Id, err)
+		}
+		if err := stream.EmitCbor(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cancelHandler answers the job cancel cap: it cancels the job if it is running, and
+// emits the job like the status cap
+func (r *jobRunner) cancelHandler(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+	job, err := r.lookup(frames)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	running, ok := r.running[job.Id]
+	r.mu.Unlock()
+	if ok {
+		running.cancelJob()
+		job = running.snapshot()
+	}
+	job.Output = nil
+	return emitter.EmitCbor(job)
+}
+
+// replayFrames returns a closed channel yielding frames, a request's input collected
+// before its handler runs
+func replayFrames(frames []Frame) <-chan Frame {
+	replay := make(chan Frame, len(frames))
+	for _, frame := range frames {
+		replay <- frame
+	}
+	close(replay)
+	return replay
+}
+
+// jobEmitter is the StreamEmitter of a job's handler, recording the output in the job
+// and writing it to the job store when the job ends
+type jobEmitter struct {
+	ctx     context.Context // Cancelled by the job cancel cap
+	cancel  context.CancelFunc
+	logger  func() Logger    // The runtime's current logger
+	now     func() time.Time // Time of the job's updates
+	store   JobStore
+	mu      sync.Mutex
+	job     *Job        // guarded by mu
+	ended   bool        // The job's state is final (guarded by mu)
+	streams int         // Streams opened with OpenStream (guarded by mu)
+	primary string      // Media URN of the primary stream, "" until something was emitted on it
+	text    *textWriter // Writer returned by TextWriter, drained by finish (nil until then)
+}
+
+// Context returns the job context (see HandlerContext). It is cancelled when the job
+// is cancelled.
+func (e *jobEmitter) Context() context.Context {
+	return e.ctx
+}
+
+// snapshot returns a copy of the job as it is now
+func (e *jobEmitter) snapshot() *Job {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.job.clone()
+}
+
+// save writes the job to the store, if it has one: scheduled runs aren't stored (caller
+// must hold mu)
+func (e *jobEmitter) save() {
+	if e.store == nil {
+		return
+	}
+	e.job.UpdatedMs = e.now().UnixMilli()
+	if err := e.store.Put(e.job); err != nil {
+		e.logger().Error("failed to store job", "job_id", e.job.Id, "error", err)
+	}
+}
+
+// open checks that the job can still be emitted on (caller must hold mu)
+func (e *jobEmitter) open() error {
+	if e.ctx.Err() != nil {
+		return ErrRequestCancelled
+	}
+	if e.ended {
+		return ErrResponseEnded
+	}
+	return nil
+}
+
+// record appends a value to the output of a stream (caller must hold mu)
+func (e *jobEmitter) record(stream int, mediaUrn string, value interface{}) error {
+	if err := e.open(); err != nil {
+		return err
+	}
+	encoded, err := cborlib.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode output value: %w", err)
+	}
+	e.job.Output = append(e.job.Output, JobOutput{Stream: stream, MediaUrn: mediaUrn, Cbor: encoded})
+	return nil
+}
+
+func (e *jobEmitter) emitTagged(mediaUrn string, value interface{}) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.primary == "" {
+		e.primary = mediaUrn
+	}
+	return e.record(0, e.primary, value)
+}
+
+func (e *jobEmitter) EmitCbor(value interface{}) error {
+	return e.emitTagged("media:", value)
+}
+
+func (e *jobEmitter) EmitSeq(produce func(yield func(v interface{}) error) error) error {
+	return produce(e.EmitCbor)
+}
+
+func (e *jobEmitter) EmitJSON(v interface{}) error {
+	document, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to JSON-encode value: %w", err)
+	}
+	return e.emitTagged(standard.MediaJSON, string(document))
+}
+
+func (e *jobEmitter) EmitText(s string) error {
+	return e.emitTagged(standard.MediaString, s)
+}
+
+func (e *jobEmitter) EmitBytes(b []byte) error {
+	return e.emitTagged(standard.MediaBinary, b)
+}
+
+func (e *jobEmitter) EmitLog(level, message string) {
+	e.EmitLogAttrs(level, message)
+}
+
+// EmitLogAttrs writes the message to the runtime's log, as no request is left to send
+// it on
+func (e *jobEmitter) EmitLogAttrs(level, message string, attrs ...any) {
+	logger := e.logger()
+	attrs = append(attrs, "job_id", e.job.Id)
+	switch level {
+	case "error":
+		logger.Error(message, attrs...)
+	case "warn":
+		logger.Warn(message, attrs...)
+	case "debug":
+		logger.Debug(message, attrs...)
+	default:
+		logger.Info(message, attrs...)
+	}
+}
+
+// EmitProgress records the progress in the job, for the job status cap to report
+func (e *jobEmitter) EmitProgress(stage string, completed, total uint64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.ended {
+		return
+	}
+	e.job.Progress = &JobProgress{Stage: stage, Completed: completed, Total: total}
+	e.save()
+}
+
+func (e *jobEmitter) OpenStream(mediaUrn string) (OutputStream, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.open(); err != nil {
+		return nil, err
+	}
+	e.streams++
+	return &jobOutputStream{emitter: e, stream: e.streams, mediaUrn: mediaUrn}, nil
+}
+
+func (e *jobEmitter) TextWriter() TextWriter {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.text == nil {
+		e.text = newTextWriter(e.EmitText, DefaultTextBatch)
+	}
+	return e.text
+}
+
+// Abort fails the job with err, keeping the output emitted so far
+func (e *jobEmitter) Abort(err error) error {
+	if err == nil {
+		return errors.New("abort requires an error")
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if openErr := e.open(); openErr != nil {
+		return openErr
+	}
+	e.failLocked(err)
+	return nil
+}
+
+// failLocked ends the job with the ERR err answers a request with (caller must hold mu)
+func (e *jobEmitter) failLocked(err error) {
+	errFrame := NewErrFromError(MessageId{}, err)
+	e.ended = true
+	e.job.State = JobFailed
+	e.job.ErrCode = errFrame.ErrorCode()
+	e.job.ErrMsg = errFrame.ErrorMessage()
+	e.save()
+}
+
+// cancelJob ends a running job as cancelled and cancels its context
+func (e *jobEmitter) cancelJob() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.ended {
+		return
+	}
+	e.ended = true
+	e.job.State = JobCancelled
+	e.job.ErrCode = string(ErrCodeCancelled)
+	e.job.ErrMsg = "job cancelled"
+	e.save()
+	e.cancel()
+}
+
+// finish ends the job with the outcome of its handler, unless it already ended
+func (e *jobEmitter) finish(err error) {
+	if err == nil && e.text != nil {
+		if text := e.text.drain(); text != "" {
+			err = e.EmitText(text)
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.ended {
+		return
+	}
+	if err != nil {
+		e.failLocked(err)
+		return
+	}
+	e.ended = true
+	e.job.State = JobSucceeded
+	e.save()
+}
+
+// jobOutputStream is a stream of a job opened with OpenStream
+type jobOutputStream struct {
+	emitter  *jobEmitter
+	stream   int
+	mediaUrn string
+}
+
+func (s *jobOutputStream) StreamId() string {
+	return fmt.Sprintf("job-%s-%d", s.emitter.job.Id, s.stream)
+}
+
+func (s *jobOutputStream) EmitCbor(value interface{}) error {
+	s.emitter.mu.Lock()
+	defer s.emitter.mu.Unlock()
+	return s.emitter.record(s.stream, s.mediaUrn, value)
+}
+
+// Close does nothing: a job's streams end with the job
+func (s *jobOutputStream) Close() error {
+	return nil
+}
@@ -0,0 +1,181 @@
+package bifaci
+
+import (
+	"testing"
+	"time"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+	"github.com/machinefabric/capdag-go/standard"
+)
+
+const testAsyncCap = `cap:in="media:void";op=render;out="media:textable"`
+
+// requestJob sends a job cap request for jobId and returns the response frames
+func requestJob(t *testing.T, reader *FrameReader, writer *FrameWriter, capUrn, jobId string) []*Frame {
+	t.Helper()
+	arg, err := cborlib.Marshal(jobId)
+	if err != nil {
+		t.Fatalf("Failed to encode job ID: %v", err)
+	}
+	reqId := NewMessageIdRandom()
+	writeTestRequest(t, writer, reqId, capUrn, arg)
+	return readUntilTerminal(t, reader, reqId)
+}
+
+// jobStatus requests the status of a job
+func jobStatus(t *testing.T, reader *FrameReader, writer *FrameWriter, jobId string) Job {
+	t.Helper()
+	streams, err := collectFrames(requestJob(t, reader, writer, standard.CapJobStatus, jobId))
+	if err != nil || len(streams) != 1 {
+		t.Fatalf("Expected the job status, got %v %v", streams, err)
+	}
+	var job Job
+	if err := cborlib.Unmarshal(streams[0].Data, &job); err != nil {
+		t.Fatalf("Failed to decode job status: %v", err)
+	}
+	return job
+}
+
+// startJob requests the async cap and returns the job ID the runtime accepted it as
+func startJob(t *testing.T, reader *FrameReader, writer *FrameWriter) string {
+	t.Helper()
+	reqId := NewMessageIdRandom()
+	writer.WriteFrame(NewReq(reqId, testAsyncCap, nil, "application/cbor"))
+	writer.WriteFrame(NewEnd(reqId, nil))
+	frames := readUntilTerminal(t, reader, reqId)
+	if frames[0].FrameType != FrameTypeAccepted || frames[0].JobId() == "" {
+		t.Fatalf("Expected ACCEPTED with a job ID first, got %v", frames[0].FrameType)
+	}
+	if last := frames[len(frames)-1]; last.FrameType != FrameTypeEnd {
+		t.Fatalf("Expected the request to end with END, got %v", last.FrameType)
+	}
+	return frames[0].JobId()
+}
+
+func TestAsyncJob(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	progressed := make(chan struct{})
+	release := make(chan struct{})
+	runtime.RegisterAsync(testAsyncCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		EmitProgress(emitter, "render", 1, 2)
+		close(progressed)
+		<-release
+		return EmitText(emitter, "rendered")
+	})
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	defer stop()
+
+	jobId := startJob(t, reader, writer)
+	<-progressed
+	job := jobStatus(t, reader, writer, jobId)
+	if job.State != JobRunning || job.Progress == nil || job.Progress.Completed != 1 {
+		t.Errorf("Expected the running job with its progress, got %+v", job)
+	}
+	frames := requestJob(t, reader, writer, standard.CapJobResult, jobId)
+	if last := frames[len(frames)-1]; last.ErrorCode() != string(ErrCodeJobRunning) {
+		t.Errorf("Expected JOB_RUNNING before the job finished, got %v %s", last.FrameType, last.ErrorCode())
+	}
+
+	close(release)
+	deadline := time.Now().Add(2 * time.Second)
+	for job.State == JobRunning && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		job = jobStatus(t, reader, writer, jobId)
+	}
+	if job.State != JobSucceeded {
+		t.Fatalf("Expected the job to succeed, got %+v", job)
+	}
+	streams, err := collectFrames(requestJob(t, reader, writer, standard.CapJobResult, jobId))
+	if err != nil || len(streams) != 1 {
+		t.Fatalf("Expected the job output, got %v %v", streams, err)
+	}
+	var text string
+	if err := cborlib.Unmarshal(streams[0].Data, &text); err != nil || text != "rendered" {
+		t.Errorf("Expected the emitted text, got %q %v", text, err)
+	}
+	if streams[0].MediaUrn != standard.MediaString {
+		t.Errorf("Expected the text stream's media URN, got %s", streams[0].MediaUrn)
+	}
+}
+
+func TestAsyncJobCancel(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	cancelled := make(chan struct{})
+	runtime.RegisterAsync(testAsyncCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		<-HandlerContext(emitter).Done()
+		close(cancelled)
+		return HandlerContext(emitter).Err()
+	})
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	defer stop()
+
+	jobId := startJob(t, reader, writer)
+	streams, err := collectFrames(requestJob(t, reader, writer, standard.CapJobCancel, jobId))
+	if err != nil || len(streams) != 1 {
+		t.Fatalf("Expected the cancelled job, got %v %v", streams, err)
+	}
+	var job Job
+	if err := cborlib.Unmarshal(streams[0].Data, &job); err != nil || job.State != JobCancelled {
+		t.Errorf("Expected the job cancelled, got %+v %v", job, err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the job context to be cancelled")
+	}
+
+	frames := requestJob(t, reader, writer, standard.CapJobResult, jobId)
+	if last := frames[len(frames)-1]; last.ErrorCode() != string(ErrCodeCancelled) {
+		t.Errorf("Expected CANCELLED for the result, got %v %s", last.FrameType, last.ErrorCode())
+	}
+	frames = requestJob(t, reader, writer, standard.CapJobStatus, "no-such-job")
+	if last := frames[len(frames)-1]; last.ErrorCode() != string(ErrCodeJobNotFound) {
+		t.Errorf("Expected JOB_NOT_FOUND for an unknown job, got %v %s", last.FrameType, last.ErrorCode())
+	}
+}
+
+func TestFileJobStore(t *testing.T) {
+	store, err := NewFileJobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	done := &Job{Id: "done", CapUrn: testAsyncCap, State: JobSucceeded, Output: []JobOutput{{MediaUrn: "media:", Cbor: []byte{0x01}}}}
+	interrupted := &Job{Id: "interrupted", CapUrn: testAsyncCap, State: JobRunning}
+	for _, job := range []*Job{done, interrupted} {
+		if err := store.Put(job); err != nil {
+			t.Fatalf("Failed to store job: %v", err)
+		}
+	}
+	if job, err := store.Get("done"); err != nil || job == nil || len(job.Output) != 1 || job.Output[0].Cbor[0] != 0x01 {
+		t.Errorf("Expected the stored job back, got %+v %v", job, err)
+	}
+	if job, err := store.Get("../done"); job != nil || err != nil {
+		t.Errorf("Expected no job outside the store, got %+v %v", job, err)
+	}
+
+	// A restarted plugin reports the job its predecessor was running as failed
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.SetJobStore(store)
+	runtime.RegisterAsync(testAsyncCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		return nil
+	})
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	defer stop()
+	job := jobStatus(t, reader, writer, "interrupted")
+	if job.State != JobFailed || job.ErrCode != string(ErrCodePluginDied) {
+		t.Errorf("Expected the interrupted job failed with PLUGIN_DIED, got %+v", job)
+	}
+}
@@ -0,0 +1,336 @@
+package bifaci
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// WireCodec is how frames are serialized on a connection
+type WireCodec string
+
+const (
+	// WireCBOR is the protocol's encoding: CBOR frames, each behind a 4-byte length prefix
+	WireCBOR WireCodec = "cbor"
+	// WireJSON writes each frame as one line of JSON, for debugging: exchanges can be
+	// read with jq and typed into netcat. Both ends of a connection must agree on it out
+	// of band, e.g. through WireEnvVar; there is no negotiation.
+	WireJSON WireCodec = "json"
+)
+
+// WireEnvVar names the environment variable that forces the codec of a plugin's
+// connection, "cbor" or "json". A PluginHost set to WireJSON sets it for the plugins it
+// spawns.
+const WireEnvVar = "CAPDAG_WIRE"
+
+// WireCodecFromEnv returns the codec WireEnvVar names, WireCBOR if it is unset
+func WireCodecFromEnv() (WireCodec, error) {
+	value := strings.ToLower(strings.TrimSpace(os.Getenv(WireEnvVar)))
+	switch WireCodec(value) {
+	case "", WireCBOR:
+		return WireCBOR, nil
+	case WireJSON:
+		return WireJSON, nil
+	}
+	return "", fmt.Errorf("invalid %s %q: expected cbor or json", WireEnvVar, value)
+}
+
+// SetWireCodec forces the codec of the runtime's connection in CBOR mode. By default it
+// is the one WireEnvVar names, WireCBOR if it is unset.
+func (pr *PluginRuntime) SetWireCodec(codec WireCodec) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.wireCodec = codec
+}
+
+// SetWireCodec sets the codec of the connections of plugins attached or spawned
+// afterwards. Spawned plugins are told through WireEnvVar; an attached plugin must have
+// been started with it.
+func (h *PluginHost) SetWireCodec(codec WireCodec) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.wireCodec = codec
+}
+
+// jsonFrame is a frame as a line of the JSON codec. Field names follow the CBOR keys.
+// The payload is base64 in "payload", or plain in "payload_text" for a frame other than
+// a CHUNK whose payload is printable UTF-8. Reading, version defaults to
+// ProtocolVersion, frame_type may be a name or a number, and a CHUNK without a
+// checksum gets one, so frames are easy to write by hand.
+type jsonFrame struct {
+	Version     *uint8                 `json:"version,omitempty"`
+	FrameType   interface{}            `json:"frame_type"`
+	Id          interface{}            `json:"id"`
+	RoutingId   interface{}            `json:"routing_id,omitempty"`
+	Cap         string                 `json:"cap,omitempty"`
+	StreamId    string                 `json:"stream_id,omitempty"`
+	MediaUrn    string                 `json:"media_urn,omitempty"`
+	ContentType string                 `json:"content_type,omitempty"`
+	Seq         uint64                 `json:"seq,omitempty"`
+	ChunkIndex  *uint64                `json:"chunk_index,omitempty"`
+	ChunkCount  *uint64                `json:"chunk_count,omitempty"`
+	Len         *uint64                `json:"len,omitempty"`
+	Offset      *uint64                `json:"offset,omitempty"`
+	Eof         bool                   `json:"eof,omitempty"`
+	Checksum    *uint64                `json:"checksum,omitempty"`
+	Meta        map[string]interface{} `json:"meta,omitempty"`
+	Payload     *[]byte                `json:"payload,omitempty"`
+	PayloadText *string                `json:"payload_text,omitempty"`
+}
+
+// encodeJSONFrames returns frames as lines of the JSON codec
+func encodeJSONFrames(frames ...*Frame) ([]byte, error) {
+	var out []byte
+	for _, frame := range frames {
+		version := ProtocolVersion
+		f := jsonFrame{
+			Version:    &version,
+			FrameType:  frame.FrameType.String(),
+			Id:         messageIdJSON(frame.Id),
+			Seq:        frame.Seq,
+			ChunkIndex: frame.ChunkIndex,
+			ChunkCount: frame.ChunkCount,
+			Len:        frame.Len,
+			Offset:     frame.Offset,
+			Eof:        frame.Eof != nil && *frame.Eof,
+			Checksum:   frame.Checksum,
+		}
+		if frame.RoutingId != nil {
+			f.RoutingId = messageIdJSON(*frame.RoutingId)
+		}
+		if frame.Cap != nil {
+			f.Cap = *frame.Cap
+		}
+		if frame.StreamId != nil {
+			f.StreamId = *frame.StreamId
+		}
+		if frame.MediaUrn != nil {
+			f.MediaUrn = *frame.MediaUrn
+		}
+		if frame.ContentType != nil {
+			f.ContentType = *frame.ContentType
+		}
+		if len(frame.Meta) > 0 {
+			f.Meta, _ = normalizeMetaValue(frame.Meta).(map[string]interface{})
+		}
+		if frame.Payload != nil {
+			if frame.FrameType != FrameTypeChunk && isPrintableText(frame.Payload) {
+				text := string(frame.Payload)
+				f.PayloadText = &text
+			} else {
+				f.Payload = &frame.Payload
+			}
+		}
+		line, err := json.Marshal(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %v frame as JSON: %w", frame.FrameType, err)
+		}
+		out = append(append(out, line...), '\n')
+	}
+	return out, nil
+}
+
+// messageIdJSON returns a UUID message ID as its string and a uint one as a number
+func messageIdJSON(id MessageId) interface{} {
+	if id.IsUuid() {
+		return id.ToUuidString()
+	}
+	if id.uintValue != nil {
+		return *id.uintValue
+	}
+	return uint64(0)
+}
+
+// isPrintableText reports whether data is UTF-8 without control characters other than
+// whitespace
+func isPrintableText(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, r := range string(data) {
+		if unicode.IsControl(r) && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// readJSONFrame reads the next line of the JSON codec, skipping blank lines, and
+// returns the frame's CBOR encoding, so that it is decoded and checked as a frame read
+// as CBOR is. A line may take twice max_frame, for base64 payloads.
+func (fr *FrameReader) readJSONFrame() ([]byte, error) {
+	if fr.lines == nil {
+		fr.lines = bufio.NewReader(fr.reader)
+	}
+	for {
+		var line []byte
+		for {
+			part, err := fr.lines.ReadSlice('\n')
+			line = append(line, part...)
+			if len(line) > 2*fr.limits.MaxFrame {
+				return nil, fmt.Errorf("JSON frame exceeds %d bytes", 2*fr.limits.MaxFrame)
+			}
+			if err == bufio.ErrBufferFull {
+				continue
+			}
+			if err != nil && (err != io.EOF || len(bytes.TrimSpace(line)) == 0) {
+				return nil, err
+			}
+			break
+		}
+		if line = bytes.TrimSpace(line); len(line) == 0 {
+			continue
+		}
+		frameBuf, err := decodeJSONFrame(line, fr.limits.Checksum)
+		if err == nil && len(frameBuf) > fr.limits.MaxFrame {
+			return nil, fmt.Errorf("frame size %d exceeds max_frame limit %d", len(frameBuf), fr.limits.MaxFrame)
+		}
+		return frameBuf, err
+	}
+}
+
+// decodeJSONFrame converts a line of the JSON codec to the CBOR encoding of its frame.
+// A CHUNK without a checksum gets one under algorithm.
+func decodeJSONFrame(line []byte, algorithm ChecksumAlgorithm) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+	var f jsonFrame
+	if err := decoder.Decode(&f); err != nil {
+		return nil, fmt.Errorf("invalid JSON frame: %w", err)
+	}
+
+	m := make(map[int]interface{})
+	m[keyVersion] = ProtocolVersion
+	if f.Version != nil {
+		m[keyVersion] = *f.Version
+	}
+	frameType, err := jsonFrameType(f.FrameType)
+	if err != nil {
+		return nil, err
+	}
+	m[keyFrameType] = uint8(frameType)
+	if m[keyId], err = jsonMessageId("id", f.Id); err != nil {
+		return nil, err
+	}
+	if f.RoutingId != nil {
+		if m[keyRoutingId], err = jsonMessageId("routing_id", f.RoutingId); err != nil {
+			return nil, err
+		}
+	}
+
+	var payload []byte
+	switch {
+	case f.Payload != nil && f.PayloadText != nil:
+		return nil, errors.New("invalid JSON frame: both payload and payload_text are set")
+	case f.Payload != nil:
+		payload = *f.Payload
+	case f.PayloadText != nil:
+		payload = []byte(*f.PayloadText)
+	}
+	if payload != nil {
+		m[keyPayload] = payload
+	}
+	if frameType == FrameTypeChunk && f.Checksum == nil {
+		checksum := algorithm.Sum(payload)
+		f.Checksum = &checksum
+	}
+
+	for key, value := range map[int]string{keyCap: f.Cap, keyStreamId: f.StreamId, keyMediaUrn: f.MediaUrn, keyContentType: f.ContentType} {
+		if value != "" {
+			m[key] = value
+		}
+	}
+	for key, value := range map[int]*uint64{keyChunkIndex: f.ChunkIndex, keyChunkCount: f.ChunkCount, keyLen: f.Len, keyOffset: f.Offset, keyChecksum: f.Checksum} {
+		if value != nil {
+			m[key] = *value
+		}
+	}
+	if f.Seq != 0 {
+		m[keySeq] = f.Seq
+	}
+	if f.Eof {
+		m[keyEof] = true
+	}
+	if len(f.Meta) > 0 {
+		m[keyMeta] = jsonMetaValue(f.Meta)
+	}
+	return frameEncMode.Marshal(m)
+}
+
+// jsonFrameType parses a frame type given by name, e.g. "REQ", or by number
+func jsonFrameType(value interface{}) (FrameType, error) {
+	switch v := value.(type) {
+	case string:
+		for ft := FrameTypeHello; ft <= FrameTypeNotify; ft++ {
+			if ft.String() == strings.ToUpper(v) {
+				return ft, nil
+			}
+		}
+	case json.Number:
+		if n, err := strconv.ParseUint(v.String(), 10, 8); err == nil {
+			return FrameType(n), nil
+		}
+	case nil:
+		return 0, errors.New("invalid JSON frame: missing frame_type")
+	}
+	return 0, fmt.Errorf("invalid JSON frame: unknown frame_type %v", value)
+}
+
+// jsonMessageId parses a message ID given as a UUID string or a uint, to its CBOR value
+func jsonMessageId(field string, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		if id, err := uuid.Parse(v); err == nil {
+			return id[:], nil
+		}
+	case json.Number:
+		if n, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+			return n, nil
+		}
+	case nil:
+		return nil, fmt.Errorf("invalid JSON frame: missing %s", field)
+	}
+	return nil, fmt.Errorf("invalid JSON frame: %s must be a UUID or a uint, got %v", field, value)
+}
+
+// jsonMetaValue converts the numbers of a decoded JSON meta value to the types CBOR
+// decoding gives: uint64 for non-negative integers, int64 for negative ones, float64
+// otherwise
+func jsonMetaValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = jsonMetaValue(item)
+		}
+		return m
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = jsonMetaValue(item)
+		}
+		return items
+	case json.Number:
+		if n, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+			return n
+		}
+		if n, err := strconv.ParseInt(v.String(), 10, 64); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	default:
+		return value
+	}
+}
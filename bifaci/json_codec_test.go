@@ -0,0 +1,116 @@
+package bifaci
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONCodecRoundTrip(t *testing.T) {
+	reqId := NewMessageIdRandom()
+	req := NewReq(reqId, `cap:in="media:void";op=test;out="media:void"`, []byte("hello"), "text/plain")
+	req.SetEnv(map[string]string{"MODEL": "large"})
+	frames := []*Frame{
+		req,
+		NewStreamStart(reqId, "s1", "media:bytes"),
+		NewChunk(reqId, "s1", 0, []byte{0x43, 0x00, 0xff, 0x01}, 0, ChecksumFNV1a64.Sum([]byte{0x43, 0x00, 0xff, 0x01})),
+		NewStreamEnd(reqId, "s1", 1),
+		NewEnd(reqId, nil),
+	}
+
+	var buf bytes.Buffer
+	writer := NewFrameWriter(&buf)
+	writer.SetCodec(WireJSON)
+	if err := writer.WriteFrames(frames...); err != nil {
+		t.Fatalf("WriteFrames failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(frames) {
+		t.Fatalf("Expected a line per frame, got %q", buf.String())
+	}
+	var first map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("Expected JSON, got %q: %v", lines[0], err)
+	}
+	if first["frame_type"] != "REQ" || first["id"] != reqId.ToUuidString() || first["payload_text"] != "hello" {
+		t.Errorf("Unexpected REQ line %s", lines[0])
+	}
+
+	reader := NewFrameReader(&buf)
+	reader.SetCodec(WireJSON)
+	for _, expected := range frames {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatalf("ReadFrame failed: %v", err)
+		}
+		expectedCBOR, _ := EncodeFrame(expected)
+		gotCBOR, _ := EncodeFrame(frame)
+		if !bytes.Equal(expectedCBOR, gotCBOR) {
+			t.Errorf("%v frame changed in the round trip: %+v", expected.FrameType, frame)
+		}
+	}
+}
+
+// Frames typed by hand may leave out the version and a CHUNK's checksum
+func TestJSONCodecHandwrittenFrames(t *testing.T) {
+	input := `
+{"frame_type": "req", "id": 7, "cap": "cap:op=test", "payload_text": "{}", "meta": {"attempt": 2}}
+
+{"frame_type": 3, "id": 7, "stream_id": "s1", "chunk_index": 0, "payload": "QwABAg=="}
+`
+	reader := NewFrameReader(strings.NewReader(input))
+	reader.SetCodec(WireJSON)
+
+	req, err := reader.ReadFrame()
+	if err != nil {
+		t.Fatalf("ReadFrame failed: %v", err)
+	}
+	if req.FrameType != FrameTypeReq || req.Id.ToString() != "7" || string(req.Payload) != "{}" {
+		t.Errorf("Unexpected REQ %+v", req)
+	}
+	if !reflect.DeepEqual(req.Meta["attempt"], uint64(2)) {
+		t.Errorf("Expected meta attempt 2 as a uint, got %#v", req.Meta["attempt"])
+	}
+
+	chunk, err := reader.ReadFrame()
+	if err != nil {
+		t.Fatalf("ReadFrame failed: %v", err)
+	}
+	if err := VerifyChunkChecksum(chunk); err != nil {
+		t.Errorf("Expected the CHUNK to get a valid checksum: %v", err)
+	}
+	if _, err := reader.ReadFrame(); err == nil {
+		t.Error("Expected EOF after the last line")
+	}
+
+	for _, line := range []string{
+		`{"frame_type": "REQ"}`,
+		`{"frame_type": "NOPE", "id": 1}`,
+		`{"frame_type": "END", "id": "not-a-uuid"}`,
+		`{"frame_type": "END", "id": 1, "colour": "red"}`,
+		`{"frame_type": "CHUNK", "id": 1, "stream_id": "s1"}`,
+	} {
+		reader := NewFrameReader(strings.NewReader(line))
+		reader.SetCodec(WireJSON)
+		if _, err := reader.ReadFrame(); err == nil {
+			t.Errorf("Expected an error for %s", line)
+		}
+	}
+}
+
+func TestWireCodecFromEnv(t *testing.T) {
+	t.Setenv(WireEnvVar, "JSON")
+	if codec, err := WireCodecFromEnv(); err != nil || codec != WireJSON {
+		t.Errorf("Expected json, got %q, %v", codec, err)
+	}
+	t.Setenv(WireEnvVar, "")
+	if codec, err := WireCodecFromEnv(); err != nil || codec != WireCBOR {
+		t.Errorf("Expected cbor, got %q, %v", codec, err)
+	}
+	t.Setenv(WireEnvVar, "xml")
+	if _, err := WireCodecFromEnv(); err == nil {
+		t.Error("Expected an error for xml")
+	}
+}
@@ -0,0 +1,109 @@
+package bifaci
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// LifecycleHook is run by the runtime when it starts or stops (see OnStart and OnStop)
+type LifecycleHook func(ctx context.Context) error
+
+// lifecycle is the state of the runtime's start and stop hooks
+type lifecycle struct {
+	mu        sync.Mutex
+	onStart   []LifecycleHook
+	onStop    []LifecycleHook
+	startOnce sync.Once
+	startErr  error
+	started   bool // The start hooks ran, so the stop hooks must
+	stopOnce  sync.Once
+}
+
+// StartError reports a failed OnStart hook. The runtime reports it as ERR START_FAILED.
+type StartError struct {
+	Err error
+}
+
+func (e *StartError) Error() string {
+	return fmt.Sprintf("plugin failed to start: %v", e.Err)
+}
+
+func (e *StartError) Unwrap() error {
+	return e.Err
+}
+
+// OnStart registers a hook run once, in registration order, before the runtime serves
+// its first request: to load a model or open a database. In CBOR mode the hooks run
+// after the host's HELLO and before the plugin answers it, so the host sees the plugin
+// ready only once they succeeded. A failing hook aborts the handshake: the host gets
+// ERR START_FAILED instead of HELLO and Run returns a *StartError. In CLI mode the
+// hooks run before the cap's handler, and a failure is the command's error.
+func (pr *PluginRuntime) OnStart(hook LifecycleHook) {
+	pr.lifecycle.mu.Lock()
+	defer pr.lifecycle.mu.Unlock()
+	pr.lifecycle.onStart = append(pr.lifecycle.onStart, hook)
+}
+
+// OnStop registers a hook run once, in reverse registration order, when Run, RunConn,
+// RunWebSocket or RunListener returns, to release what the OnStart hooks acquired. The
+// hooks only run if the OnStart hooks did, even if one of those failed. RunListener runs
+// them once the listener is closed, while connections it accepted may still be served.
+func (pr *PluginRuntime) OnStop(hook LifecycleHook) {
+	pr.lifecycle.mu.Lock()
+	defer pr.lifecycle.mu.Unlock()
+	pr.lifecycle.onStop = append(pr.lifecycle.onStop, hook)
+}
+
+// start runs the OnStart hooks the first time it is called, returning the *StartError
+// of the hook that failed on every call
+func (pr *PluginRuntime) start(ctx context.Context) error {
+	l := &pr.lifecycle
+	l.startOnce.Do(func() {
+		l.mu.Lock()
+		hooks := l.onStart
+		l.started = true
+		l.mu.Unlock()
+		for _, hook := range hooks {
+			if err := hook(ctx); err != nil {
+				l.startErr = &StartError{Err: err}
+				return
+			}
+		}
+	})
+	return l.startErr
+}
+
+// stop runs the OnStop hooks once if the OnStart hooks ran, joining their errors
+func (pr *PluginRuntime) stop(ctx context.Context) error {
+	l := &pr.lifecycle
+	l.mu.Lock()
+	hooks, started := l.onStop, l.started
+	l.mu.Unlock()
+	if !started {
+		return nil
+	}
+	var errs []error
+	l.stopOnce.Do(func() {
+		for i := len(hooks) - 1; i >= 0; i-- {
+			if err := hooks[i](ctx); err != nil {
+				pr.log().Error("stop hook failed", "error", err)
+				errs = append(errs, err)
+			}
+		}
+	})
+	if len(errs) > 0 {
+		return fmt.Errorf("plugin failed to stop: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// stopAfter runs the OnStop hooks once serving ended with err, returning err or, if
+// serving succeeded, the hooks' error
+func (pr *PluginRuntime) stopAfter(err error) error {
+	if stopErr := pr.stop(context.Background()); err == nil {
+		return stopErr
+	}
+	return err
+}
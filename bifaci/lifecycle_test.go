@@ -0,0 +1,81 @@
+package bifaci
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// serveWithHooks runs RunConn over a pipe and completes the host side of the handshake
+func serveWithHooks(t *testing.T, runtime *PluginRuntime) (handshakeErr error, stop func() error) {
+	t.Helper()
+	hostConn, pluginConn := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- runtime.RunConn(pluginConn) }()
+	_, _, handshakeErr = HandshakeInitiate(NewFrameReader(hostConn), NewFrameWriter(hostConn))
+	return handshakeErr, func() error {
+		hostConn.Close()
+		return <-done
+	}
+}
+
+func TestLifecycleHooks(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	var calls []string
+	hook := func(name string) LifecycleHook {
+		return func(ctx context.Context) error {
+			calls = append(calls, name)
+			return nil
+		}
+	}
+	runtime.OnStart(hook("open db"))
+	runtime.OnStart(hook("load model"))
+	runtime.OnStop(hook("close db"))
+	runtime.OnStop(hook("free model"))
+
+	handshakeErr, stop := serveWithHooks(t, runtime)
+	if handshakeErr != nil {
+		t.Fatalf("Handshake failed: %v", handshakeErr)
+	}
+	if !reflect.DeepEqual(calls, []string{"open db", "load model"}) {
+		t.Errorf("Expected the start hooks before HELLO, got %v", calls)
+	}
+	if err := stop(); err != nil {
+		t.Fatalf("RunConn failed: %v", err)
+	}
+	if !reflect.DeepEqual(calls, []string{"open db", "load model", "free model", "close db"}) {
+		t.Errorf("Expected the stop hooks in reverse order, got %v", calls)
+	}
+}
+
+// A failing start hook answers HELLO with ERR START_FAILED
+func TestStartHookFailureAbortsHandshake(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	modelMissing := errors.New("model file missing")
+	stopped := false
+	runtime.OnStart(func(ctx context.Context) error { return modelMissing })
+	runtime.OnStop(func(ctx context.Context) error {
+		stopped = true
+		return nil
+	})
+
+	handshakeErr, stop := serveWithHooks(t, runtime)
+	if !errors.Is(handshakeErr, ErrCodeStartFailed) {
+		t.Errorf("Expected the host to get START_FAILED, got %v", handshakeErr)
+	}
+	var startErr *StartError
+	if err := stop(); !errors.As(err, &startErr) || !errors.Is(err, modelMissing) {
+		t.Errorf("Expected RunConn to fail with the StartError, got %v", err)
+	}
+	if !stopped {
+		t.Error("Expected the stop hooks to run after a failed start")
+	}
+}
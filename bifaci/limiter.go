@@ -0,0 +1,125 @@
+package bifaci
+
+import (
+	"context"
+	"sync"
+)
+
+// requestLimiter bounds the number of handlers running at once across all connections
+// of a runtime. Requests beyond the limit wait in a bounded queue, the most urgent
+// first (see Frame.SetPriority); a request that finds the queue full is rejected with
+// ERR BUSY. A nil limiter admits everything.
+type requestLimiter struct {
+	maxConcurrent int
+	maxQueued     int
+
+	mu       sync.Mutex
+	reserved int              // Running + queued requests
+	running  int              // Requests holding a slot
+	waiters  []*limiterWaiter // Queued requests, most urgent first, in arrival order within a priority
+}
+
+// limiterWaiter is a queued request; ready is closed once it has been given a slot
+type limiterWaiter struct {
+	priority int
+	ready    chan struct{}
+}
+
+func newRequestLimiter(maxConcurrent, maxQueued int) *requestLimiter {
+	if maxConcurrent <= 0 {
+		return nil
+	}
+	if maxQueued < 0 {
+		maxQueued = 0
+	}
+	return &requestLimiter{
+		maxConcurrent: maxConcurrent,
+		maxQueued:     maxQueued,
+	}
+}
+
+// reserve admits a new request (running or queued). false means the runtime is saturated.
+func (l *requestLimiter) reserve() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.reserved >= l.maxConcurrent+l.maxQueued {
+		return false
+	}
+	l.reserved++
+	return true
+}
+
+// acquire waits for a free slot for a reserved request of the given priority. On false
+// (ctx done) the reservation has been given back.
+func (l *requestLimiter) acquire(ctx context.Context, priority int) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	if l.running < l.maxConcurrent && len(l.waiters) == 0 {
+		l.running++
+		l.mu.Unlock()
+		return true
+	}
+	waiter := &limiterWaiter{priority: priority, ready: make(chan struct{})}
+	i := len(l.waiters)
+	for i > 0 && l.waiters[i-1].priority < priority {
+		i--
+	}
+	l.waiters = append(l.waiters, nil)
+	copy(l.waiters[i+1:], l.waiters[i:])
+	l.waiters[i] = waiter
+	l.mu.Unlock()
+
+	select {
+	case <-waiter.ready:
+		return true
+	case <-ctx.Done():
+	}
+	l.mu.Lock()
+	for i, queued := range l.waiters {
+		if queued == waiter {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			l.reserved--
+			l.mu.Unlock()
+			return false
+		}
+	}
+	l.mu.Unlock()
+	// Given a slot as the context ended
+	l.release()
+	return false
+}
+
+// release frees the slot and reservation of a finished request, handing the slot to
+// the first queued request
+func (l *requestLimiter) release() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.reserved--
+	l.running--
+	if len(l.waiters) > 0 {
+		next := l.waiters[0]
+		l.waiters = l.waiters[1:]
+		l.running++
+		close(next.ready)
+	}
+}
+
+// SetMaxConcurrentRequests limits how many handlers run at once (0 = unlimited, the default).
+// Up to maxQueued further requests wait for a free slot, their input buffered like any
+// other request's; beyond that REQ is answered with ERR BUSY so a flood of requests
+// can't exhaust memory or file descriptors. Queued requests start in order of priority
+// (see Frame.SetPriority). The limit is shared by all connections and applies to
+// connections served after the call.
+func (pr *PluginRuntime) SetMaxConcurrentRequests(maxConcurrent, maxQueued int) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.limiter = newRequestLimiter(maxConcurrent, maxQueued)
+}
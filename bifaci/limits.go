@@ -3,11 +3,19 @@ package bifaci
 // DefaultMaxReorderBuffer is the default reorder buffer size (64 slots)
 const DefaultMaxReorderBuffer int = 64
 
+// DefaultMaxWindow is the default flow-control window: CHUNK frames a sender may have
+// in flight per request before it must wait for ACK credit from the receiver.
+const DefaultMaxWindow int = 64
+
 // Limits represents protocol negotiation limits
 type Limits struct {
-	MaxFrame         int `cbor:"max_frame"`
-	MaxChunk         int `cbor:"max_chunk"`
-	MaxReorderBuffer int `cbor:"max_reorder_buffer"`
+	MaxFrame         int                  `cbor:"max_frame"`
+	MaxChunk         int                  `cbor:"max_chunk"`
+	MaxReorderBuffer int                  `cbor:"max_reorder_buffer"`
+	MaxWindow        int                  `cbor:"max_window"`  // 0 = flow control disabled
+	Checksum         ChecksumAlgorithm    `cbor:"checksum"`    // CHUNK checksum algorithm; "" = FNV-1a
+	Compression      CompressionAlgorithm `cbor:"compression"` // Stream compression both sides decode; "" = none
+	Priorities       bool                 `cbor:"priorities"`  // REQ priorities are honored (see Frame.SetPriority)
 }
 
 // DefaultLimits returns the default protocol limits
@@ -16,16 +24,29 @@ func DefaultLimits() Limits {
 		MaxFrame:         DefaultMaxFrame,
 		MaxChunk:         DefaultMaxChunk,
 		MaxReorderBuffer: DefaultMaxReorderBuffer,
+		MaxWindow:        DefaultMaxWindow,
 	}
 }
 
-// NegotiateLimits returns the minimum of two limit sets
+// NegotiateLimits returns the minimum of two limit sets.
+// Flow control is only enabled if both sides advertise a window, and a checksum
+// algorithm other than FNV-1a or a compression only if both sides name the same one.
+// Priorities are honored only if both sides support them.
 func NegotiateLimits(a, b Limits) Limits {
-	return Limits{
+	negotiated := Limits{
 		MaxFrame:         min(a.MaxFrame, b.MaxFrame),
 		MaxChunk:         min(a.MaxChunk, b.MaxChunk),
 		MaxReorderBuffer: min(a.MaxReorderBuffer, b.MaxReorderBuffer),
+		MaxWindow:        min(a.MaxWindow, b.MaxWindow),
+		Priorities:       a.Priorities && b.Priorities,
+	}
+	if a.Checksum.normalize() == b.Checksum.normalize() {
+		negotiated.Checksum = a.Checksum
+	}
+	if a.Compression == b.Compression {
+		negotiated.Compression = a.Compression
 	}
+	return negotiated
 }
 
 func min(a, b int) int {
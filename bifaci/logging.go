@@ -0,0 +1,106 @@
+package bifaci
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// Logger receives the runtime's internal diagnostics as a message plus slog-style
+// alternating key/value attributes. *slog.Logger implements it.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// defaultLogger writes text records to stderr - stdout carries frames in CBOR mode.
+func defaultLogger() Logger {
+	return slog.New(slog.NewTextHandler(os.Stderr, nil)).With("component", "PluginRuntime")
+}
+
+// defaultHostLogger is defaultLogger for a PluginHost.
+func defaultHostLogger() Logger {
+	return slog.New(slog.NewTextHandler(os.Stderr, nil)).With("component", "PluginHost")
+}
+
+// SetLogger routes the runtime's diagnostics to logger. nil restores the default
+// (slog text handler on stderr, level INFO).
+func (pr *PluginRuntime) SetLogger(logger Logger) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if logger == nil {
+		logger = defaultLogger()
+	}
+	pr.logger = logger
+}
+
+// log returns the runtime's logger
+func (pr *PluginRuntime) log() Logger {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+	return pr.logger
+}
+
+// logAttrsToMap converts slog-style key/value pairs (or slog.Attr values) into the map
+// carried by LOG frames. A key without a value is stored under "!BADKEY" like slog does.
+func logAttrsToMap(args []any) map[string]interface{} {
+	if len(args) == 0 {
+		return nil
+	}
+	attrs := make(map[string]interface{})
+	for len(args) > 0 {
+		switch key := args[0].(type) {
+		case slog.Attr:
+			attrs[key.Key] = logAttrValue(key.Value)
+			args = args[1:]
+		case string:
+			if len(args) == 1 {
+				attrs["!BADKEY"] = key
+				args = args[1:]
+				continue
+			}
+			attrs[key] = logAttrValue(slog.AnyValue(args[1]))
+			args = args[2:]
+		default:
+			attrs["!BADKEY"] = logAttrValue(slog.AnyValue(key))
+			args = args[1:]
+		}
+	}
+	return attrs
+}
+
+// logAttrValue reduces a slog value to something CBOR encodes portably.
+func logAttrValue(value slog.Value) interface{} {
+	value = value.Resolve()
+	switch value.Kind() {
+	case slog.KindString:
+		return value.String()
+	case slog.KindInt64:
+		return value.Int64()
+	case slog.KindUint64:
+		return value.Uint64()
+	case slog.KindFloat64:
+		return value.Float64()
+	case slog.KindBool:
+		return value.Bool()
+	case slog.KindGroup:
+		group := make(map[string]interface{})
+		for _, attr := range value.Group() {
+			group[attr.Key] = logAttrValue(attr.Value)
+		}
+		return group
+	case slog.KindAny:
+		if err, ok := value.Any().(error); ok {
+			return err.Error()
+		}
+		if b, ok := value.Any().([]byte); ok {
+			return b
+		}
+		return fmt.Sprint(value.Any())
+	default:
+		// Duration, Time, LogValuer results
+		return value.String()
+	}
+}
@@ -26,6 +26,10 @@ type CapManifest struct {
 
 	// Human-readable page URL for the plugin (e.g., repository page, documentation)
 	PageUrl *string `json:"page_url,omitempty"`
+
+	// Cap URN patterns of the host caps the plugin may invoke as a peer; none means any
+	// (see WithPermissions)
+	Permissions []string `json:"permissions,omitempty"`
 }
 
 // NewCapManifest creates a new cap manifest
@@ -50,6 +54,15 @@ func (cm *CapManifest) WithPageUrl(pageUrl string) *CapManifest {
 	return cm
 }
 
+// WithPermissions restricts the host caps the plugin may invoke to those the patterns
+// accept, as a request for a pattern would be routed to them. The runtime rejects other
+// peer invocations with PERMISSION_DENIED, and hosts see the patterns in the handshake
+// to approve them (see SetPermissionApprover).
+func (cm *CapManifest) WithPermissions(patterns ...string) *CapManifest {
+	cm.Permissions = append(cm.Permissions, patterns...)
+	return cm
+}
+
 // EnsureIdentity ensures the manifest includes CAP_IDENTITY
 // Returns a new manifest with identity added if not present, or the same manifest if already present
 func (cm *CapManifest) EnsureIdentity() *CapManifest {
@@ -78,6 +91,7 @@ func (cm *CapManifest) EnsureIdentity() *CapManifest {
 		Caps:        newCaps,
 		Author:      cm.Author,
 		PageUrl:     cm.PageUrl,
+		Permissions: cm.Permissions,
 	}
 }
 
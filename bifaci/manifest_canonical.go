@@ -0,0 +1,130 @@
+package bifaci
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/machinefabric/capdag-go/urn"
+)
+
+// canonicalRequiredFields are the fields kept in the canonical form even when empty, by
+// the path of the objects holding them in a manifest
+var canonicalRequiredFields = map[string]map[string]bool{
+	"":            {"name": true, "version": true, "description": true, "caps": true},
+	"caps":        {"urn": true, "title": true, "command": true},
+	"caps.args":   {"media_urn": true, "required": true, "sources": true},
+	"caps.output": {"media_urn": true, "output_description": true},
+}
+
+// CanonicalJSON returns the manifest in the canonical form hosts compare manifests in,
+// whichever SDK produced them: compact JSON with the keys of every object sorted,
+// nulls and empty optional fields left out, cap and media URNs in their normalized
+// form, and no HTML escaping, as the Rust SDK writes it.
+func (cm *CapManifest) CanonicalJSON() ([]byte, error) {
+	data, err := json.Marshal(cm)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var document map[string]interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+
+	if caps, ok := document["caps"].([]interface{}); ok {
+		for i, entry := range caps {
+			capData, ok := entry.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if urnStr, ok := capData["urn"].(string); ok {
+				canonical, err := urn.Canonicalize(urnStr)
+				if err != nil {
+					return nil, fmt.Errorf("cap %d: invalid URN %q: %w", i, urnStr, err)
+				}
+				capData["urn"] = canonical
+			}
+			if args, ok := capData["args"].([]interface{}); ok {
+				for _, arg := range args {
+					if argData, ok := arg.(map[string]interface{}); ok && argData["sources"] == nil {
+						argData["sources"] = []interface{}{}
+					}
+				}
+			}
+		}
+	} else {
+		document["caps"] = []interface{}{}
+	}
+	pruneEmpty(document, "")
+	normalizeMediaUrns(document)
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	// Maps are encoded with their keys sorted
+	if err := encoder.Encode(document); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// pruneEmpty removes the nulls of the object at path in a manifest, and its empty
+// strings, arrays and objects but for its required fields, recursively
+func pruneEmpty(object map[string]interface{}, path string) {
+	for key, value := range object {
+		nestedPath := key
+		if path != "" {
+			nestedPath = path + "." + key
+		}
+		switch v := value.(type) {
+		case nil:
+			delete(object, key)
+			continue
+		case map[string]interface{}:
+			pruneEmpty(v, nestedPath)
+		case []interface{}:
+			for _, element := range v {
+				if nested, ok := element.(map[string]interface{}); ok {
+					pruneEmpty(nested, nestedPath)
+				}
+			}
+		}
+		if !canonicalRequiredFields[path][key] && isEmptyJSON(value) {
+			delete(object, key)
+		}
+	}
+}
+
+func isEmptyJSON(value interface{}) bool {
+	switch v := value.(type) {
+	case string:
+		return v == ""
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+// normalizeMediaUrns rewrites every "media_urn" field in its normalized form
+func normalizeMediaUrns(value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if mediaStr, ok := field.(string); ok && key == "media_urn" {
+				if mediaUrn, err := urn.NewMediaUrnFromString(mediaStr); err == nil {
+					v[key] = mediaUrn.String()
+				}
+				continue
+			}
+			normalizeMediaUrns(field)
+		}
+	case []interface{}:
+		for _, element := range v {
+			normalizeMediaUrns(element)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package bifaci
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// NewPluginRuntimeFromManifestFile creates a plugin runtime from a JSON or YAML manifest
+// file, so the manifest can be maintained as a declarative file shared with the Rust
+// and Python SDKs.
+func NewPluginRuntimeFromManifestFile(filePath string) (*PluginRuntime, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	return newPluginRuntimeFromManifestData(filePath, data)
+}
+
+// NewPluginRuntimeFromFS is NewPluginRuntimeFromManifestFile reading from fsys, e.g. an
+// embed.FS:
+//
+//	//go:embed manifest.yaml
+//	var manifestFS embed.FS
+//	runtime, err := bifaci.NewPluginRuntimeFromFS(manifestFS, "manifest.yaml")
+func NewPluginRuntimeFromFS(fsys fs.FS, filePath string) (*PluginRuntime, error) {
+	data, err := fs.ReadFile(fsys, filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	return newPluginRuntimeFromManifestData(filePath, data)
+}
+
+func newPluginRuntimeFromManifestData(filePath string, data []byte) (*PluginRuntime, error) {
+	manifestJSON, err := ManifestJSON(filePath, data)
+	if err != nil {
+		return nil, err
+	}
+	// Unlike NewPluginRuntime, a file that doesn't describe a manifest is an error
+	var manifest CapManifest
+	if err := json.Unmarshal(manifestJSON, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", filePath, err)
+	}
+	return NewPluginRuntime(manifestJSON)
+}
+
+// ManifestJSON returns the JSON form of a manifest file. Files named *.yaml/*.yml, and
+// files whose content doesn't start with '{', are read as YAML and converted; JSON is
+// returned unchanged.
+func ManifestJSON(filePath string, data []byte) ([]byte, error) {
+	ext := strings.ToLower(path.Ext(filePath))
+	trimmed := bytes.TrimSpace(data)
+	if ext == ".json" || (ext != ".yaml" && ext != ".yml" && bytes.HasPrefix(trimmed, []byte("{"))) {
+		return data, nil
+	}
+
+	var document interface{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("invalid YAML manifest %s: %w", filePath, err)
+	}
+	manifestJSON, err := json.Marshal(yamlToJSONValue(document))
+	if err != nil {
+		return nil, fmt.Errorf("invalid YAML manifest %s: %w", filePath, err)
+	}
+	return manifestJSON, nil
+}
+
+// yamlToJSONValue converts YAML maps with non-string keys (map[interface{}]interface{})
+// to the string-keyed maps encoding/json accepts
+func yamlToJSONValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = yamlToJSONValue(item)
+		}
+		return v
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = yamlToJSONValue(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = yamlToJSONValue(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
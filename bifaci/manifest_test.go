@@ -2,7 +2,10 @@ package bifaci
 
 import (
 	"encoding/json"
+	"os"
+	"path/filepath"
 	"testing"
+	"testing/fstest"
 
 	"github.com/machinefabric/capdag-go/cap"
 	"github.com/machinefabric/capdag-go/standard"
@@ -351,3 +354,115 @@ func TestCapManifestCompatibility(t *testing.T) {
 	assert.IsType(t, providerMap["description"], pluginMap["description"])
 	assert.IsType(t, providerMap["caps"], pluginMap["caps"])
 }
+
+// TestManifestFromYAMLFS: a YAML manifest read from an fs.FS is converted to JSON and parsed
+func TestManifestFromYAMLFS(t *testing.T) {
+	fsys := fstest.MapFS{"manifest.yaml": {Data: []byte(`
+name: TestPlugin
+version: 1.2.0
+description: Plugin with a YAML manifest
+caps:
+  - urn: 'cap:in="media:void";op=test;out="media:void"'
+    title: Test
+    command: test
+`)}}
+
+	runtime, err := NewPluginRuntimeFromFS(fsys, "manifest.yaml")
+	require.NoError(t, err)
+	manifest := runtime.Manifest()
+	require.NotNil(t, manifest)
+	assert.Equal(t, "TestPlugin", manifest.Name)
+	assert.Equal(t, "1.2.0", manifest.Version)
+	require.Len(t, manifest.Caps, 1)
+	assert.Equal(t, "test", manifest.Caps[0].Command)
+
+	// The handshake carries the manifest as JSON
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(runtime.manifestData, &decoded))
+	assert.Equal(t, "TestPlugin", decoded["name"])
+}
+
+// TestManifestFromJSONFile: JSON manifests are used verbatim; unparseable files are rejected
+func TestManifestFromJSONFile(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "manifest.json")
+	require.NoError(t, os.WriteFile(jsonPath, []byte(testManifest), 0o644))
+
+	runtime, err := NewPluginRuntimeFromManifestFile(jsonPath)
+	require.NoError(t, err)
+	assert.Equal(t, testManifest, string(runtime.manifestData))
+
+	badPath := filepath.Join(dir, "bad.yaml")
+	require.NoError(t, os.WriteFile(badPath, []byte("caps: [unclosed"), 0o644))
+	_, err = NewPluginRuntimeFromManifestFile(badPath)
+	assert.Error(t, err)
+
+	_, err = NewPluginRuntimeFromManifestFile(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err)
+}
+
+// TestValidateManifestReportsAllProblems: every problem is reported with its field path
+func TestValidateManifestReportsAllProblems(t *testing.T) {
+	manifestJSON := `{"name":"P","version":"1.0.0","description":"","caps":[` +
+		`{"urn":"cap:","title":"Identity","command":"identity"},` +
+		`{"urn":"cap:in=\"media:void\";op=a;out=\"media:void\"","title":"A","command":"manifest"},` +
+		`{"urn":"cap:in=\"media:void\";op=b;out=\"media:void\"","title":"B","command":"identity",` +
+		`"args":[{"media_urn":"text","required":true,"sources":[]}],` +
+		`"output":{"media_urn":"media:undeclared-thing","output_description":""}}]}`
+	var manifest CapManifest
+	require.NoError(t, json.Unmarshal([]byte(manifestJSON), &manifest))
+
+	paths := make(map[string]bool)
+	for _, d := range ValidateManifest(&manifest) {
+		paths[d.Path] = true
+	}
+	for _, want := range []string{
+		"caps[1].command",
+		"caps[2].command",
+		"caps[2].args[0].media_urn",
+		"caps[2].args[0].sources",
+		"caps[2].output.media_urn",
+	} {
+		assert.True(t, paths[want], "missing diagnostic for %s (got %v)", want, paths)
+	}
+	assert.Len(t, paths, 5)
+}
+
+// TestValidateManifestAcceptsValidManifest: a well-formed manifest yields no diagnostics
+func TestValidateManifestAcceptsValidManifest(t *testing.T) {
+	manifestJSON := `{"name":"P","version":"1.0.0","description":"","caps":[` +
+		`{"urn":"cap:","title":"Identity","command":"identity"},` +
+		`{"urn":"cap:in=\"media:void\";op=a;out=\"media:void\"","title":"A","command":"run",` +
+		`"args":[{"media_urn":"media:text","required":true,"sources":[{"cli_flag":"--text"}]}],` +
+		`"output":{"media_urn":"media:void","output_description":""}}]}`
+	var manifest CapManifest
+	require.NoError(t, json.Unmarshal([]byte(manifestJSON), &manifest))
+
+	assert.Empty(t, ValidateManifest(&manifest))
+}
+
+func TestManifestCanonicalJSON(t *testing.T) {
+	// The same manifest as two SDKs may write it: tags and keys in another order,
+	// optional fields null or empty
+	first := `{"name":"Models","version":"1.0","description":"","caps":[{"urn":"cap:op=generate;in=\"media:textable;model-spec\";out=\"media:textable\"","title":"Generate","command":"generate","args":[{"media_urn":"media:textable;model-spec","required":true,"sources":null}]}],"author":null}`
+	second := `{"caps":[{"command":"generate","title":"Generate","urn":"cap:in=\"media:model-spec;textable\";op=generate;out=\"media:textable\"","metadata":{},"args":[{"required":true,"media_urn":"media:model-spec;textable","sources":[],"arg_description":""}]}],"version":"1.0","name":"Models","description":"","page_url":null}`
+
+	var canonical []string
+	for _, document := range []string{first, second} {
+		var manifest CapManifest
+		require.NoError(t, json.Unmarshal([]byte(document), &manifest))
+		data, err := manifest.CanonicalJSON()
+		require.NoError(t, err)
+		canonical = append(canonical, string(data))
+	}
+	assert.Equal(t, canonical[0], canonical[1], "equivalent manifests must serialize identically")
+
+	capUrn, err := urn.NewCapUrnFromString(`cap:in="media:model-spec;textable";op=generate;out="media:textable"`)
+	require.NoError(t, err)
+	mediaUrn, err := urn.NewMediaUrnFromString("media:model-spec;textable")
+	require.NoError(t, err)
+	urnJSON, _ := json.Marshal(capUrn.String())
+	mediaJSON, _ := json.Marshal(mediaUrn.String())
+	expected := `{"caps":[{"args":[{"media_urn":` + string(mediaJSON) + `,"required":true,"sources":[]}],"command":"generate","title":"Generate","urn":` + string(urnJSON) + `}],"description":"","name":"Models","version":"1.0"}`
+	assert.Equal(t, expected, canonical[0])
+}
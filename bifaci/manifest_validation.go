@@ -0,0 +1,171 @@
+package bifaci
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/machinefabric/capdag-go/cap"
+	"github.com/machinefabric/capdag-go/media"
+	"github.com/machinefabric/capdag-go/urn"
+)
+
+// reservedCLICommands are handled by the runtime in CLI mode before any cap command
+var reservedCLICommands = []string{"manifest", "completion", "repl", "--help", "-h"}
+
+// Diagnostic is one problem found by ValidateManifest
+type Diagnostic struct {
+	Path    string // Field path, e.g. "caps[1].args[0].media_urn"
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s", d.Path, d.Message)
+}
+
+// ValidateManifest checks a manifest in depth and returns every problem found, with the
+// path of the offending field, instead of stopping at the first one. An empty result
+// means the manifest is valid.
+//
+// Checks: name/version present, CAP_IDENTITY declared, every cap has a URN and a unique
+// command that doesn't shadow a reserved CLI subcommand, arg media URNs parse, required
+// args have a source and no default, positions and CLI flags are unique per cap, and the
+// output media URN is declared in the cap's media_specs or the standard media specs, and
+// permissions are cap URN patterns.
+func ValidateManifest(manifest *CapManifest) []Diagnostic {
+	return validateManifest(manifest, reservedCLICommands)
+}
+
+func validateManifest(manifest *CapManifest, reservedCommands []string) []Diagnostic {
+	var diagnostics []Diagnostic
+	report := func(path, format string, args ...interface{}) {
+		diagnostics = append(diagnostics, Diagnostic{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if manifest == nil {
+		report("", "manifest is missing")
+		return diagnostics
+	}
+	if strings.TrimSpace(manifest.Name) == "" {
+		report("name", "name is required")
+	}
+	if strings.TrimSpace(manifest.Version) == "" {
+		report("version", "version is required")
+	}
+
+	reserved := make(map[string]bool, len(reservedCommands))
+	for _, command := range reservedCommands {
+		reserved[command] = true
+	}
+	registry, _ := media.NewMediaUrnRegistry()
+
+	identityUrn, _ := urn.NewCapUrnFromString("cap:")
+	hasIdentity := false
+	commands := make(map[string]int)
+	urns := make(map[string]int)
+
+	for i, c := range manifest.Caps {
+		capPath := fmt.Sprintf("caps[%d]", i)
+
+		if c.Urn == nil {
+			report(capPath+".urn", "cap URN is required")
+		} else {
+			if identityUrn.ConformsTo(c.Urn) || c.Urn.ConformsTo(identityUrn) {
+				hasIdentity = true
+			}
+			urnString := c.Urn.String()
+			if first, dup := urns[urnString]; dup {
+				report(capPath+".urn", "duplicate cap URN %s (also caps[%d])", urnString, first)
+			} else {
+				urns[urnString] = i
+			}
+		}
+
+		switch {
+		case c.Command == "":
+			report(capPath+".command", "command is required")
+		case reserved[c.Command]:
+			report(capPath+".command", "command %q collides with a reserved subcommand", c.Command)
+		default:
+			if first, dup := commands[c.Command]; dup {
+				report(capPath+".command", "duplicate command %q (also caps[%d])", c.Command, first)
+			} else {
+				commands[c.Command] = i
+			}
+		}
+
+		diagnostics = append(diagnostics, validateCapArgs(capPath, c)...)
+
+		if c.Output != nil {
+			outputPath := capPath + ".output.media_urn"
+			if _, err := urn.NewMediaUrnFromString(c.Output.MediaUrn); err != nil {
+				report(outputPath, "invalid media URN %q: %v", c.Output.MediaUrn, err)
+			} else if !mediaDeclared(c.Output.MediaUrn, c.MediaSpecs, registry) {
+				report(outputPath, "media URN %q is not declared in media_specs or the standard media specs", c.Output.MediaUrn)
+			}
+		}
+	}
+
+	if !hasIdentity {
+		report("caps", "manifest must declare CAP_IDENTITY (cap:)")
+	}
+	for i, pattern := range manifest.Permissions {
+		if _, err := urn.NewCapUrnFromString(pattern); err != nil {
+			report(fmt.Sprintf("permissions[%d]", i), "invalid cap URN pattern %q: %v", pattern, err)
+		}
+	}
+	return diagnostics
+}
+
+// validateCapArgs checks the args of one cap
+func validateCapArgs(capPath string, c cap.Cap) []Diagnostic {
+	var diagnostics []Diagnostic
+	report := func(path, format string, args ...interface{}) {
+		diagnostics = append(diagnostics, Diagnostic{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	positions := make(map[int]int)
+	flags := make(map[string]int)
+	for j := range c.Args {
+		arg := &c.Args[j]
+		argPath := fmt.Sprintf("%s.args[%d]", capPath, j)
+
+		if _, err := urn.NewMediaUrnFromString(arg.MediaUrn); err != nil {
+			report(argPath+".media_urn", "invalid media URN %q: %v", arg.MediaUrn, err)
+		}
+		if arg.Required && len(arg.Sources) == 0 {
+			report(argPath+".sources", "required argument has no source")
+		}
+		if arg.Required && arg.DefaultValue != nil {
+			report(argPath+".default_value", "required argument cannot have a default value")
+		}
+		if pos := arg.GetPosition(); pos != nil {
+			if first, dup := positions[*pos]; dup {
+				report(argPath+".sources", "duplicate position %d (also args[%d])", *pos, first)
+			} else {
+				positions[*pos] = j
+			}
+		}
+		if flag := arg.GetCliFlag(); flag != nil && *flag != "" {
+			if first, dup := flags[*flag]; dup {
+				report(argPath+".sources", "duplicate CLI flag %q (also args[%d])", *flag, first)
+			} else {
+				flags[*flag] = j
+			}
+		}
+	}
+	return diagnostics
+}
+
+// mediaDeclared reports whether mediaUrn is defined by the cap or the standard specs
+func mediaDeclared(mediaUrn string, mediaSpecs []media.MediaSpecDef, registry *media.MediaUrnRegistry) bool {
+	for _, spec := range mediaSpecs {
+		if spec.Urn == mediaUrn {
+			return true
+		}
+	}
+	if registry == nil {
+		return false
+	}
+	_, err := registry.GetMediaSpec(mediaUrn)
+	return err == nil
+}
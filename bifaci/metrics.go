@@ -0,0 +1,247 @@
+package bifaci
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MetricsCollector receives runtime events for observability. Methods are called from
+// the frame reader loop and handler goroutines concurrently and must not block.
+// Metrics implements it with in-memory counters; adapt it to another metrics library
+// by implementing the interface.
+type MetricsCollector interface {
+	// FrameRead is called for every frame decoded from the host; size includes the length prefix
+	FrameRead(frameType FrameType, size int)
+	// FrameWritten is called for every frame written to the host; size includes the length prefix
+	FrameWritten(frameType FrameType, size int)
+	// ChecksumFailure is called when an incoming CHUNK fails checksum verification
+	ChecksumFailure()
+	// StrayFrame is called for an input frame of no running request (see StrayFramePolicy)
+	StrayFrame(frameType FrameType)
+	// HandlerStarted is called when a handler is dispatched for capUrn
+	HandlerStarted(capUrn string)
+	// HandlerFinished is called when the handler returns. errCode is the ERR code sent
+	// to the host ("" on success).
+	HandlerFinished(capUrn string, duration time.Duration, errCode string)
+}
+
+// SetMetrics routes runtime events to collector (nil disables collection).
+// Takes effect for connections served after the call.
+func (pr *PluginRuntime) SetMetrics(collector MetricsCollector) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.metrics = collector
+}
+
+// nopMetrics is used when no collector is set
+type nopMetrics struct{}
+
+func (nopMetrics) FrameRead(FrameType, int)                      {}
+func (nopMetrics) FrameWritten(FrameType, int)                   {}
+func (nopMetrics) ChecksumFailure()                              {}
+func (nopMetrics) StrayFrame(FrameType)                          {}
+func (nopMetrics) HandlerStarted(string)                         {}
+func (nopMetrics) HandlerFinished(string, time.Duration, string) {}
+
+// maxFrameTypes bounds the per-type counter arrays (frame types are 0..17)
+const maxFrameTypes = 18
+
+// Metrics is an in-memory MetricsCollector. It can be read with Snapshot or served
+// in the Prometheus text exposition format (it implements http.Handler).
+type Metrics struct {
+	framesIn         [maxFrameTypes]atomic.Uint64
+	framesOut        [maxFrameTypes]atomic.Uint64
+	bytesIn          atomic.Uint64
+	bytesOut         atomic.Uint64
+	checksumFailures atomic.Uint64
+	strayFrames      [maxFrameTypes]atomic.Uint64
+	activeHandlers   atomic.Int64
+
+	mu       sync.Mutex
+	handlers map[string]*HandlerStats // By cap URN
+}
+
+// HandlerStats aggregates the invocations of one cap
+type HandlerStats struct {
+	Count    uint64
+	Duration time.Duration     // Total time spent in the handler
+	Errors   map[string]uint64 // ERR code → count
+}
+
+// MetricsSnapshot is a point-in-time copy of Metrics
+type MetricsSnapshot struct {
+	FramesRead       map[FrameType]uint64
+	FramesWritten    map[FrameType]uint64
+	BytesRead        uint64
+	BytesWritten     uint64
+	ChecksumFailures uint64
+	StrayFrames      map[FrameType]uint64
+	ActiveHandlers   int64
+	Handlers         map[string]HandlerStats
+}
+
+// NewMetrics creates an empty in-memory collector
+func NewMetrics() *Metrics {
+	return &Metrics{handlers: make(map[string]*HandlerStats)}
+}
+
+func (m *Metrics) FrameRead(frameType FrameType, size int) {
+	if int(frameType) < maxFrameTypes {
+		m.framesIn[frameType].Add(1)
+	}
+	m.bytesIn.Add(uint64(size))
+}
+
+func (m *Metrics) FrameWritten(frameType FrameType, size int) {
+	if int(frameType) < maxFrameTypes {
+		m.framesOut[frameType].Add(1)
+	}
+	m.bytesOut.Add(uint64(size))
+}
+
+func (m *Metrics) ChecksumFailure() {
+	m.checksumFailures.Add(1)
+}
+
+func (m *Metrics) StrayFrame(frameType FrameType) {
+	if int(frameType) < maxFrameTypes {
+		m.strayFrames[frameType].Add(1)
+	}
+}
+
+func (m *Metrics) HandlerStarted(capUrn string) {
+	m.activeHandlers.Add(1)
+}
+
+func (m *Metrics) HandlerFinished(capUrn string, duration time.Duration, errCode string) {
+	m.activeHandlers.Add(-1)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats, ok := m.handlers[capUrn]
+	if !ok {
+		stats = &HandlerStats{Errors: make(map[string]uint64)}
+		m.handlers[capUrn] = stats
+	}
+	stats.Count++
+	stats.Duration += duration
+	if errCode != "" {
+		stats.Errors[errCode]++
+	}
+}
+
+// Snapshot returns a copy of the current counters
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	snapshot := MetricsSnapshot{
+		FramesRead:       make(map[FrameType]uint64),
+		FramesWritten:    make(map[FrameType]uint64),
+		BytesRead:        m.bytesIn.Load(),
+		BytesWritten:     m.bytesOut.Load(),
+		ChecksumFailures: m.checksumFailures.Load(),
+		StrayFrames:      make(map[FrameType]uint64),
+		ActiveHandlers:   m.activeHandlers.Load(),
+		Handlers:         make(map[string]HandlerStats),
+	}
+	for i := 0; i < maxFrameTypes; i++ {
+		if n := m.framesIn[i].Load(); n > 0 {
+			snapshot.FramesRead[FrameType(i)] = n
+		}
+		if n := m.framesOut[i].Load(); n > 0 {
+			snapshot.FramesWritten[FrameType(i)] = n
+		}
+		if n := m.strayFrames[i].Load(); n > 0 {
+			snapshot.StrayFrames[FrameType(i)] = n
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for capUrn, stats := range m.handlers {
+		errorCounts := make(map[string]uint64, len(stats.Errors))
+		for code, n := range stats.Errors {
+			errorCounts[code] = n
+		}
+		snapshot.Handlers[capUrn] = HandlerStats{Count: stats.Count, Duration: stats.Duration, Errors: errorCounts}
+	}
+	return snapshot
+}
+
+// WritePrometheus writes the counters in the Prometheus text exposition format
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	snapshot := m.Snapshot()
+	var out []string
+	add := func(format string, args ...interface{}) {
+		out = append(out, fmt.Sprintf(format, args...))
+	}
+
+	add("# TYPE bifaci_frames_read_total counter")
+	for _, ft := range sortedFrameTypes(snapshot.FramesRead) {
+		add("bifaci_frames_read_total{type=%q} %d", ft.String(), snapshot.FramesRead[ft])
+	}
+	add("# TYPE bifaci_frames_written_total counter")
+	for _, ft := range sortedFrameTypes(snapshot.FramesWritten) {
+		add("bifaci_frames_written_total{type=%q} %d", ft.String(), snapshot.FramesWritten[ft])
+	}
+	add("# TYPE bifaci_bytes_read_total counter")
+	add("bifaci_bytes_read_total %d", snapshot.BytesRead)
+	add("# TYPE bifaci_bytes_written_total counter")
+	add("bifaci_bytes_written_total %d", snapshot.BytesWritten)
+	add("# TYPE bifaci_checksum_failures_total counter")
+	add("bifaci_checksum_failures_total %d", snapshot.ChecksumFailures)
+	add("# TYPE bifaci_stray_frames_total counter")
+	for _, ft := range sortedFrameTypes(snapshot.StrayFrames) {
+		add("bifaci_stray_frames_total{type=%q} %d", ft.String(), snapshot.StrayFrames[ft])
+	}
+	add("# TYPE bifaci_active_handlers gauge")
+	add("bifaci_active_handlers %d", snapshot.ActiveHandlers)
+
+	capUrns := make([]string, 0, len(snapshot.Handlers))
+	for capUrn := range snapshot.Handlers {
+		capUrns = append(capUrns, capUrn)
+	}
+	sort.Strings(capUrns)
+	add("# TYPE bifaci_handler_duration_seconds summary")
+	for _, capUrn := range capUrns {
+		stats := snapshot.Handlers[capUrn]
+		add("bifaci_handler_duration_seconds_sum{cap=%q} %g", capUrn, stats.Duration.Seconds())
+		add("bifaci_handler_duration_seconds_count{cap=%q} %d", capUrn, stats.Count)
+	}
+	add("# TYPE bifaci_handler_errors_total counter")
+	for _, capUrn := range capUrns {
+		stats := snapshot.Handlers[capUrn]
+		codes := make([]string, 0, len(stats.Errors))
+		for code := range stats.Errors {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		for _, code := range codes {
+			add("bifaci_handler_errors_total{cap=%q,code=%q} %d", capUrn, code, stats.Errors[code])
+		}
+	}
+
+	for _, line := range out {
+		if _, err := io.WriteString(w, line+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeHTTP serves the counters for a Prometheus scraper
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WritePrometheus(w)
+}
+
+func sortedFrameTypes(counts map[FrameType]uint64) []FrameType {
+	types := make([]FrameType, 0, len(counts))
+	for ft := range counts {
+		types = append(types, ft)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
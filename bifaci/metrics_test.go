@@ -0,0 +1,83 @@
+package bifaci
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestMetricsCountFramesAndHandlers: frames in both directions, handler outcomes and
+// checksum failures are recorded, and the Prometheus output reflects them
+func TestMetricsCountFramesAndHandlers(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	metrics := NewMetrics()
+	runtime.SetMetrics(metrics)
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		var arg []byte
+		for frame := range frames {
+			if frame.FrameType == FrameTypeChunk {
+				arg = frame.Payload
+			}
+		}
+		if bytes.Equal(arg, []byte{0x41, 0x01}) {
+			return errors.New("bad input")
+		}
+		return emitter.EmitCbor("ok")
+	})
+
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	okId := NewMessageIdRandom()
+	writeTestRequest(t, writer, okId, testCancelCap, []byte{0x40})
+	readUntilTerminal(t, reader, okId)
+	failId := NewMessageIdRandom()
+	writeTestRequest(t, writer, failId, testCancelCap, []byte{0x41, 0x01})
+	readUntilTerminal(t, reader, failId)
+
+	// CHUNK with a wrong checksum for an unknown request
+	corruptId := NewMessageIdRandom()
+	writer.WriteFrame(NewChunk(corruptId, "s", 0, []byte{0x40}, 0, 1))
+	readUntilTerminal(t, reader, corruptId)
+
+	if err := stop(); err != nil {
+		t.Errorf("Runtime exited with error: %v", err)
+	}
+
+	snapshot := metrics.Snapshot()
+	if snapshot.FramesRead[FrameTypeReq] != 2 || snapshot.FramesRead[FrameTypeHello] != 1 {
+		t.Errorf("Unexpected frames read: %v", snapshot.FramesRead)
+	}
+	if snapshot.FramesWritten[FrameTypeEnd] != 1 || snapshot.FramesWritten[FrameTypeErr] != 2 {
+		t.Errorf("Unexpected frames written: %v", snapshot.FramesWritten)
+	}
+	if snapshot.BytesRead == 0 || snapshot.BytesWritten == 0 {
+		t.Errorf("Byte counters not updated: in=%d out=%d", snapshot.BytesRead, snapshot.BytesWritten)
+	}
+	if snapshot.ChecksumFailures != 1 {
+		t.Errorf("Expected 1 checksum failure, got %d", snapshot.ChecksumFailures)
+	}
+	if snapshot.ActiveHandlers != 0 {
+		t.Errorf("Expected no active handlers, got %d", snapshot.ActiveHandlers)
+	}
+	stats := snapshot.Handlers[testCancelCap]
+	if stats.Count != 2 || stats.Errors["HANDLER_ERROR"] != 1 {
+		t.Errorf("Unexpected handler stats: %+v", stats)
+	}
+
+	var exposition bytes.Buffer
+	if err := metrics.WritePrometheus(&exposition); err != nil {
+		t.Fatalf("WritePrometheus failed: %v", err)
+	}
+	for _, want := range []string{
+		`bifaci_frames_read_total{type="REQ"} 2`,
+		`bifaci_checksum_failures_total 1`,
+		`bifaci_handler_errors_total{cap="` + strings.ReplaceAll(testCancelCap, `"`, `\"`) + `",code="HANDLER_ERROR"} 1`,
+	} {
+		if !strings.Contains(exposition.String(), want) {
+			t.Errorf("Missing %q in:\n%s", want, exposition.String())
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package bifaci
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// Middleware wraps a handler with cross-cutting behaviour (logging, panic recovery,
+// auth, input validation). It returns the handler to run in place of next.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Use adds middleware wrapping every handler, registered before or after the call,
+// in CBOR and CLI mode alike. The first middleware added is the outermost.
+func (pr *PluginRuntime) Use(middleware ...Middleware) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.middleware = append(pr.middleware, middleware...)
+}
+
+// applyMiddleware wraps handler in the registered middleware. Caller holds pr.mu.
+func (pr *PluginRuntime) applyMiddleware(handler HandlerFunc) HandlerFunc {
+	for i := len(pr.middleware) - 1; i >= 0; i-- {
+		handler = pr.middleware[i](handler)
+	}
+	return handler
+}
+
+// RecoverMiddleware turns a handler panic into a handler error, so the request is
+// answered with ERR HANDLER_ERROR instead of the panic taking down the plugin.
+func RecoverMiddleware(next HandlerFunc) HandlerFunc {
+	return func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("handler panicked: %v\n%s", r, debug.Stack())
+			}
+		}()
+		return next(frames, emitter, peer)
+	}
+}
@@ -0,0 +1,309 @@
+package bifaci
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+
+	"github.com/machinefabric/capdag-go/cap"
+	"github.com/machinefabric/capdag-go/urn"
+)
+
+// WithOutputValidation makes the runtime check each value a handler emits on its primary
+// output stream against the cap's declared output media URN, and against the schema of
+// its media spec in the cap's media_specs if it has one, in CBOR and CLI mode alike. The
+// shape follows the URN's tags: nothing may be emitted for media:void, a record is a map
+// (or JSON text if textable), numeric a number, bool a boolean, other textable media
+// text and media without textable bytes. For a list each element is checked, emitted
+// one by one or as an array. A value of the wrong shape is not sent: EmitCbor returns an
+// *OutputValidationError, which the runtime reports as ERR INVALID_OUTPUT.
+func (pr *PluginRuntime) WithOutputValidation() {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.validateOutput = true
+}
+
+// OutputValidationError is returned by EmitCbor for a value that doesn't match the cap's
+// output spec
+type OutputValidationError struct {
+	CapUrn     string
+	Violations []Violation // Arg is the output media URN
+}
+
+func (e *OutputValidationError) Error() string {
+	details := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		details[i] = fmt.Sprintf("%s %s: %s", v.Arg, v.Path, v.Message)
+	}
+	return fmt.Sprintf("invalid output for cap %s: %s", e.CapUrn, strings.Join(details, "; "))
+}
+
+// withOutputValidation wraps the emitter the handler registered under pattern receives,
+// if enabled and the manifest declares the cap. Caller holds pr.mu.
+func (pr *PluginRuntime) withOutputValidation(pattern string, handler HandlerFunc) HandlerFunc {
+	if !pr.validateOutput {
+		return handler
+	}
+	capDef := pr.manifestCap(pattern)
+	if capDef == nil {
+		return handler
+	}
+	outUrn := capDef.Urn.OutSpec()
+	if output := capDef.GetOutput(); output != nil && output.MediaUrn != "" {
+		outUrn = output.MediaUrn
+	}
+	mediaUrn, err := urn.NewMediaUrnFromString(outUrn)
+	if err != nil {
+		return handler
+	}
+	var schema interface{}
+	for _, spec := range capDef.GetMediaSpecs() {
+		if spec.Urn == outUrn {
+			schema = spec.Schema
+		}
+	}
+	return func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		return handler(frames, &validatingEmitter{
+			StreamEmitter: emitter,
+			capDef:        capDef,
+			outUrn:        outUrn,
+			mediaUrn:      mediaUrn,
+			schema:        schema,
+		}, peer)
+	}
+}
+
+// withValidation wraps the handler registered under pattern in the enabled input and
+// output validation. Caller holds pr.mu.
+func (pr *PluginRuntime) withValidation(pattern string, handler HandlerFunc) HandlerFunc {
+	return pr.withInputValidation(pattern, pr.withOutputValidation(pattern, handler))
+}
+
+// manifestCap returns the manifest's definition of the cap registered under pattern,
+// nil if it has none. Caller holds pr.mu.
+func (pr *PluginRuntime) manifestCap(pattern string) *cap.Cap {
+	if pr.manifest == nil {
+		return nil
+	}
+	patternUrn, err := urn.NewCapUrnFromString(pattern)
+	if err != nil {
+		return nil
+	}
+	for i := range pr.manifest.Caps {
+		capDef := &pr.manifest.Caps[i]
+		if capDef.Urn != nil && capDef.Urn.Equals(patternUrn) {
+			return capDef
+		}
+	}
+	return nil
+}
+
+// validatingEmitter checks the values emitted on the primary stream before passing them on
+type validatingEmitter struct {
+	StreamEmitter
+	capDef   *cap.Cap
+	outUrn   string
+	mediaUrn *urn.MediaUrn
+	schema   interface{} // nil if the output media spec has none
+}
+
+// Context returns the request context of the wrapped emitter (see HandlerContext)
+func (e *validatingEmitter) Context() context.Context {
+	return HandlerContext(e.StreamEmitter)
+}
+
+// accept answers the request with ACCEPTED through the wrapped emitter (see
+// RegisterAsync)
+func (e *validatingEmitter) accept(jobId string) error {
+	accepter, ok := e.StreamEmitter.(jobAccepter)
+	if !ok {
+		return errors.New("emitter can't accept async jobs")
+	}
+	return accepter.accept(jobId)
+}
+
+func (e *validatingEmitter) EmitCbor(value interface{}) error {
+	if violations := e.check(value); len(violations) > 0 {
+		return &OutputValidationError{CapUrn: e.capDef.UrnString(), Violations: violations}
+	}
+	return e.StreamEmitter.EmitCbor(value)
+}
+
+func (e *validatingEmitter) EmitSeq(produce func(yield func(v interface{}) error) error) error {
+	return EmitSeq(e.StreamEmitter, func(yield func(v interface{}) error) error {
+		return produce(func(v interface{}) error {
+			if violations := e.check(v); len(violations) > 0 {
+				return &OutputValidationError{CapUrn: e.capDef.UrnString(), Violations: violations}
+			}
+			return yield(v)
+		})
+	})
+}
+
+// TextWriter fails every write if the output isn't text, the writer being only checked
+// for its shape
+func (e *validatingEmitter) TextWriter() TextWriter {
+	if expected, ok := e.expectedShape(""); !ok || e.mediaUrn.IsVoid() {
+		violation := Violation{Arg: e.outUrn, Path: "(root)", Message: fmt.Sprintf("expected %s, got text", expected)}
+		if e.mediaUrn.IsVoid() {
+			violation.Message = "the cap's output is void"
+		}
+		err := &OutputValidationError{CapUrn: e.capDef.UrnString(), Violations: []Violation{violation}}
+		return &directTextWriter{emit: func(string) error { return err }}
+	}
+	return TextWriterOf(e.StreamEmitter)
+}
+
+func (e *validatingEmitter) EmitJSON(v interface{}) error {
+	document, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to JSON-encode value: %w", err)
+	}
+	if violations := e.check(string(document)); len(violations) > 0 {
+		return &OutputValidationError{CapUrn: e.capDef.UrnString(), Violations: violations}
+	}
+	return EmitJSON(e.StreamEmitter, v)
+}
+
+func (e *validatingEmitter) EmitText(s string) error {
+	if violations := e.check(s); len(violations) > 0 {
+		return &OutputValidationError{CapUrn: e.capDef.UrnString(), Violations: violations}
+	}
+	return EmitText(e.StreamEmitter, s)
+}
+
+func (e *validatingEmitter) EmitBytes(b []byte) error {
+	if violations := e.check(b); len(violations) > 0 {
+		return &OutputValidationError{CapUrn: e.capDef.UrnString(), Violations: violations}
+	}
+	return EmitBytes(e.StreamEmitter, b)
+}
+
+// The side-channels, additional streams and abort aren't checked
+
+func (e *validatingEmitter) EmitLogAttrs(level, message string, attrs ...any) {
+	EmitLogAttrs(e.StreamEmitter, level, message, attrs...)
+}
+
+func (e *validatingEmitter) EmitProgress(stage string, completed, total uint64) {
+	EmitProgress(e.StreamEmitter, stage, completed, total)
+}
+
+func (e *validatingEmitter) OpenStream(mediaUrn string) (OutputStream, error) {
+	return OpenStream(e.StreamEmitter, mediaUrn)
+}
+
+func (e *validatingEmitter) Abort(err error) error {
+	return Abort(e.StreamEmitter, err)
+}
+
+// check returns how value fails the output spec
+func (e *validatingEmitter) check(value interface{}) []Violation {
+	violation := func(path, message string) []Violation {
+		return []Violation{{Arg: e.outUrn, Path: path, Message: message}}
+	}
+	if e.mediaUrn.IsVoid() {
+		return violation("(root)", "the cap's output is void")
+	}
+	encoded, err := cborlib.Marshal(value)
+	if err != nil {
+		return violation("(root)", fmt.Sprintf("not encodable as CBOR: %v", err))
+	}
+	var decoded interface{}
+	if err := cborlib.Unmarshal(encoded, &decoded); err != nil {
+		return violation("(root)", fmt.Sprintf("not decodable as CBOR: %v", err))
+	}
+
+	// A list is emitted as an array or element by element
+	if items, ok := decoded.([]interface{}); ok && e.mediaUrn.IsList() {
+		var violations []Violation
+		for i, item := range items {
+			violations = append(violations, e.checkElement(fmt.Sprintf("%d", i), item)...)
+		}
+		return violations
+	}
+	return e.checkElement("(root)", decoded)
+}
+
+// checkElement checks one scalar value, or element of a list, at path
+func (e *validatingEmitter) checkElement(path string, value interface{}) []Violation {
+	expected, ok := e.expectedShape(value)
+	if !ok {
+		return []Violation{{Arg: e.outUrn, Path: path, Message: fmt.Sprintf("expected %s, got %s", expected, cborShape(value))}}
+	}
+	if e.schema == nil {
+		return nil
+	}
+	if text, isText := value.(string); isText && e.mediaUrn.IsRecord() {
+		// JSON text of a record is checked as the value it encodes
+		var parsed interface{}
+		if err := json.Unmarshal([]byte(text), &parsed); err != nil {
+			return []Violation{{Arg: e.outUrn, Path: path, Message: fmt.Sprintf("not valid JSON: %v", err)}}
+		}
+		value = parsed
+	}
+	violations := schemaViolations(e.outUrn, e.schema, normalizeMetaValue(value))
+	if path != "(root)" {
+		for i := range violations {
+			if violations[i].Path == "(root)" {
+				violations[i].Path = path
+			} else {
+				violations[i].Path = path + "." + violations[i].Path
+			}
+		}
+	}
+	return violations
+}
+
+// expectedShape returns the shape the output media URN calls for and whether value has it
+func (e *validatingEmitter) expectedShape(value interface{}) (string, bool) {
+	switch {
+	case e.mediaUrn.IsRecord():
+		_, isMap := value.(map[interface{}]interface{})
+		_, isText := value.(string)
+		if e.mediaUrn.IsTextable() {
+			return "a map or JSON text", isMap || isText
+		}
+		return "a map", isMap
+	case e.mediaUrn.IsNumeric():
+		switch value.(type) {
+		case uint64, int64, float32, float64:
+			return "a number", true
+		}
+		return "a number", false
+	case e.mediaUrn.IsBool():
+		_, isBool := value.(bool)
+		return "a boolean", isBool
+	case e.mediaUrn.IsTextable():
+		_, isText := value.(string)
+		return "text", isText
+	default:
+		_, isBytes := value.([]byte)
+		return "bytes", isBytes
+	}
+}
+
+// cborShape names the kind of a decoded CBOR value for messages
+func cborShape(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case []byte:
+		return "bytes"
+	case string:
+		return "text"
+	case bool:
+		return "a boolean"
+	case uint64, int64, float32, float64:
+		return "a number"
+	case []interface{}:
+		return "an array"
+	case map[interface{}]interface{}:
+		return "a map"
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,74 @@
+package bifaci
+
+import "testing"
+
+const testQuoteCap = `cap:in="media:void";op=quote;out="media:order;record;textable"`
+
+const testOutputValidationManifest = `{"name":"TestPlugin","version":"1.0.0","description":"Test plugin","caps":[` +
+	`{"urn":"cap:in=\"media:void\";op=quote;out=\"media:order;record;textable\"","title":"Quote","command":"quote",` +
+	`"media_specs":[{"urn":"media:order;record;textable","media_type":"application/json","schema":` +
+	`{"type":"object","required":["qty"],"properties":{"qty":{"type":"integer","minimum":1}}}}]}]}`
+
+// requestQuote sends a quote request and returns its response
+func requestQuote(t *testing.T, reader *FrameReader, writer *FrameWriter) []*Frame {
+	t.Helper()
+	reqId := NewMessageIdRandom()
+	for _, frame := range []*Frame{
+		NewReq(reqId, testQuoteCap, nil, "application/cbor"),
+		NewEnd(reqId, nil),
+	} {
+		if err := writer.WriteFrame(frame); err != nil {
+			t.Fatalf("WriteFrame failed: %v", err)
+		}
+	}
+	return readUntilTerminal(t, reader, reqId)
+}
+
+func TestOutputValidation(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testOutputValidationManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.WithOutputValidation()
+	values := make(chan interface{}, 4)
+	runtime.Register(testQuoteCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		return emitter.EmitCbor(<-values)
+	})
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	defer stop()
+
+	// A map and JSON text are both a textable record
+	for _, value := range []interface{}{map[string]interface{}{"qty": 2}, `{"qty":3}`} {
+		values <- value
+		frames := requestQuote(t, reader, writer)
+		if last := frames[len(frames)-1]; last.FrameType != FrameTypeEnd {
+			t.Fatalf("Expected END for %v, got %v %s", value, last.FrameType, last.ErrorMessage())
+		}
+	}
+
+	// The wrong shape is rejected before it is sent
+	values <- 42
+	frames := requestQuote(t, reader, writer)
+	last := frames[len(frames)-1]
+	if last.FrameType != FrameTypeErr || last.ErrorCode() != "INVALID_OUTPUT" {
+		t.Fatalf("Expected ERR INVALID_OUTPUT, got %v %s", last.FrameType, last.ErrorCode())
+	}
+	for _, frame := range frames {
+		if frame.FrameType == FrameTypeChunk {
+			t.Error("Expected no CHUNK for a rejected value")
+		}
+	}
+	violations := last.Violations()
+	if len(violations) != 1 || violations[0].Arg != "media:order;record;textable" || violations[0].Path != "(root)" {
+		t.Errorf("Expected one shape violation at the root, got %+v", violations)
+	}
+
+	// A value of the right shape is checked against the schema too
+	values <- map[string]interface{}{"qty": 0}
+	frames = requestQuote(t, reader, writer)
+	if violations := frames[len(frames)-1].Violations(); len(violations) != 1 || violations[0].Path != "qty" {
+		t.Errorf("Expected one schema violation at qty, got %+v", violations)
+	}
+}
@@ -0,0 +1,102 @@
+package bifaci
+
+import (
+	"fmt"
+	"sort"
+)
+
+// PartialStream is a response stream an ERR cut short. The first ChunkCount chunks
+// sent on it are valid output; the stream has no STREAM_END.
+type PartialStream struct {
+	StreamId   string
+	MediaUrn   string
+	ChunkCount uint64 // Chunks sent before the ERR
+	Data       []byte // Bytes of the valid chunks (set by CollectStreams)
+}
+
+// PartialStreams gets the streams an ERR frame cut short, from its meta
+// "partial_streams". Streams closed with STREAM_END before the ERR are complete and
+// not listed.
+func (f *Frame) PartialStreams() []PartialStream {
+	if f.FrameType != FrameTypeErr || f.Meta == nil {
+		return nil
+	}
+	items, _ := f.Meta["partial_streams"].([]interface{})
+	var streams []PartialStream
+	for _, item := range items {
+		fields, _ := normalizeMetaValue(item).(map[string]interface{})
+		streamId, _ := fields["stream_id"].(string)
+		if streamId == "" {
+			continue
+		}
+		mediaUrn, _ := fields["media_urn"].(string)
+		streams = append(streams, PartialStream{StreamId: streamId, MediaUrn: mediaUrn, ChunkCount: uint64(extractIntFromMeta(fields, "chunk_count"))})
+	}
+	return streams
+}
+
+// SetPartialStreams lists the streams an ERR frame cuts short in meta "partial_streams"
+func (f *Frame) SetPartialStreams(streams []PartialStream) {
+	if len(streams) == 0 {
+		return
+	}
+	if f.Meta == nil {
+		f.Meta = make(map[string]interface{})
+	}
+	items := make([]interface{}, len(streams))
+	for i, stream := range streams {
+		items[i] = map[string]interface{}{
+			"stream_id":   stream.StreamId,
+			"media_urn":   stream.MediaUrn,
+			"chunk_count": stream.ChunkCount,
+		}
+	}
+	f.Meta["partial_streams"] = items
+}
+
+// PartialResultError is returned by CollectStreams for a response that ended with ERR.
+// CollectStreams still returns the streams completed before the ERR; Partial holds the
+// valid part of the streams the ERR cut short.
+type PartialResultError struct {
+	Err     *CapError
+	Partial []PartialStream
+}
+
+func (e *PartialResultError) Error() string {
+	message := "error: " + e.Err.Error()
+	if len(e.Partial) > 0 {
+		message += fmt.Sprintf(" (after partial output on %d streams)", len(e.Partial))
+	}
+	return message
+}
+
+func (e *PartialResultError) Unwrap() error {
+	return e.Err
+}
+
+// partialStreams returns the valid part of the streams left open when errFrame ended the
+// response: the chunks the ERR counts for a stream it lists, all chunks received for a
+// stream it doesn't (a responder predating "partial_streams")
+func partialStreams(errFrame *Frame, open map[string]struct {
+	MediaUrn string
+	Chunks   [][]byte
+}) []PartialStream {
+	counts := make(map[string]uint64)
+	for _, stream := range errFrame.PartialStreams() {
+		counts[stream.StreamId] = stream.ChunkCount
+	}
+	partial := make([]PartialStream, 0, len(open))
+	for streamId, stream := range open {
+		chunks := stream.Chunks
+		if count, ok := counts[streamId]; ok && count < uint64(len(chunks)) {
+			chunks = chunks[:count]
+		}
+		var data []byte
+		for _, chunk := range chunks {
+			data = append(data, chunk...)
+		}
+		partial = append(partial, PartialStream{StreamId: streamId, MediaUrn: stream.MediaUrn, ChunkCount: uint64(len(chunks)), Data: data})
+	}
+	sort.Slice(partial, func(i, j int) bool { return partial[i].StreamId < partial[j].StreamId })
+	return partial
+}
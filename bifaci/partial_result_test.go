@@ -0,0 +1,129 @@
+package bifaci
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// collectFrames runs CollectStreams over the frames of a response
+func collectFrames(frames []*Frame) ([]struct {
+	MediaUrn string
+	Data     []byte
+}, error) {
+	ch := make(chan Frame, len(frames))
+	for _, frame := range frames {
+		ch <- *frame
+	}
+	close(ch)
+	return CollectStreams(ch)
+}
+
+// A handler failing after some output answers with an ERR listing the streams it cut
+// short, and CollectStreams returns the complete and partial output with the error
+func TestHandlerErrorAfterOutput(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		if err := emitter.EmitCbor([]byte("page 1")); err != nil {
+			return err
+		}
+		meta, err := OpenStream(emitter, "media:json")
+		if err != nil {
+			return err
+		}
+		if err := meta.EmitCbor("{}"); err != nil {
+			return err
+		}
+		if err := meta.Close(); err != nil {
+			return err
+		}
+		return errors.New("disk full")
+	})
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	defer stop()
+
+	reqId := NewMessageIdRandom()
+	writer.WriteFrame(NewReq(reqId, testCancelCap, nil, "application/cbor"))
+	writer.WriteFrame(NewEnd(reqId, nil))
+	frames := readUntilTerminal(t, reader, reqId)
+	errFrame := frames[len(frames)-1]
+	if errFrame.FrameType != FrameTypeErr {
+		t.Fatalf("Expected ERR, got %v", errFrame.FrameType)
+	}
+	partial := errFrame.PartialStreams()
+	if len(partial) != 1 || partial[0].StreamId != *frames[0].StreamId || partial[0].ChunkCount != 1 {
+		t.Fatalf("Expected the primary stream cut short after 1 chunk, got %+v", partial)
+	}
+
+	streams, err := collectFrames(frames)
+	var partialErr *PartialResultError
+	if !errors.As(err, &partialErr) || !errors.Is(err, ErrCodeHandler) {
+		t.Fatalf("Expected a partial HANDLER_ERROR result, got %v", err)
+	}
+	if len(streams) != 1 || streams[0].MediaUrn != "media:json" {
+		t.Errorf("Expected the complete metadata stream, got %+v", streams)
+	}
+	if len(partialErr.Partial) != 1 || !bytes.Contains(partialErr.Partial[0].Data, []byte("page 1")) {
+		t.Errorf("Expected the partial primary output, got %+v", partialErr.Partial)
+	}
+}
+
+// Abort ends the response with its ERR; nothing can be emitted afterwards
+func TestEmitterAbort(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	emitAfterAbort := make(chan error, 1)
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		emitter.EmitCbor("partial")
+		if err := Abort(emitter, NewCapError(ErrCodeBusy, "overloaded")); err != nil {
+			return err
+		}
+		emitAfterAbort <- emitter.EmitCbor("more")
+		return errors.New("ignored")
+	})
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	defer stop()
+
+	reqId := NewMessageIdRandom()
+	writer.WriteFrame(NewReq(reqId, testCancelCap, nil, "application/cbor"))
+	writer.WriteFrame(NewEnd(reqId, nil))
+	frames := readUntilTerminal(t, reader, reqId)
+	errFrame := ErrorFromFrame(frames[len(frames)-1])
+	if errFrame == nil || errFrame.Code != ErrCodeBusy || !errFrame.Retryable {
+		t.Fatalf("Expected a retryable BUSY ERR, got %+v", errFrame)
+	}
+	if len(frames[len(frames)-1].PartialStreams()) != 1 {
+		t.Errorf("Expected the primary stream listed as partial")
+	}
+	if err := <-emitAfterAbort; !errors.Is(err, ErrResponseEnded) {
+		t.Errorf("Expected ErrResponseEnded after Abort, got %v", err)
+	}
+}
+
+// In CLI mode the output written before Abort stays and the abort error is the failure
+func TestCLIEmitterAbort(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	var stdout, stderr bytes.Buffer
+	err = runtime.invokeCLIArguments(func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		emitter.EmitCbor("partial")
+		return Abort(emitter, NewCapError(ErrCodeTimeout, "gave up"))
+	}, nil, cliOutputOptions{}, &stdout, &stderr)
+	if !errors.Is(err, ErrCodeTimeout) || CLIExitCode(err) != ExitHandlerError {
+		t.Errorf("Expected the abort error, got %v", err)
+	}
+	if stdout.String() != "partial" {
+		t.Errorf("Expected the output written before Abort, got %q", stdout.String())
+	}
+}
@@ -0,0 +1,153 @@
+package bifaci
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/machinefabric/capdag-go/cap"
+)
+
+// PeerCallSpec is one peer invocation of a PeerBatch
+type PeerCallSpec struct {
+	CapUrn    string
+	Arguments []cap.CapArgumentValue
+}
+
+// BatchMode is how a PeerBatch handles a failed call
+type BatchMode int
+
+const (
+	// BatchFailFast cancels the calls still in flight at the first failure and returns it
+	BatchFailFast BatchMode = iota
+	// BatchCollectAll completes every call and returns the failures together in a
+	// *BatchError, along with the responses of the calls that succeeded
+	BatchCollectAll
+)
+
+// PeerCallError is the failure of one call of a PeerBatch
+type PeerCallError struct {
+	Index  int // Position of the call in the batch
+	CapUrn string
+	Err    error
+}
+
+func (e *PeerCallError) Error() string {
+	return fmt.Sprintf("peer call %d (%s) failed: %v", e.Index, e.CapUrn, e.Err)
+}
+
+func (e *PeerCallError) Unwrap() error {
+	return e.Err
+}
+
+// BatchError is returned by PeerBatch.Invoke in BatchCollectAll mode when calls failed
+type BatchError struct {
+	Errs []error // One per call, nil for those that succeeded, else a *PeerCallError
+}
+
+func (e *BatchError) Error() string {
+	var messages []string
+	for _, err := range e.Errs {
+		if err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+	return fmt.Sprintf("%d of %d peer calls failed: %s", len(messages), len(e.Errs), strings.Join(messages, "; "))
+}
+
+// Unwrap returns the errors of the failed calls
+func (e *BatchError) Unwrap() []error {
+	var errs []error
+	for _, err := range e.Errs {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// PeerBatch issues peer invocations concurrently:
+//
+//	batch := &bifaci.PeerBatch{Peer: peer, Concurrency: 4}
+//	responses, err := batch.Invoke(ctx, specs)
+//
+// Each call is made as PeerCall makes it. The peer's own in-flight limit
+// (see SetPeerLimits) still applies on top of Concurrency.
+type PeerBatch struct {
+	Peer        PeerInvoker
+	Concurrency int       // Calls in flight at once (0 = all of them)
+	Mode        BatchMode // BatchFailFast by default
+}
+
+// Invoke makes the calls of specs and returns their responses in the order of specs.
+// In BatchFailFast mode the first failure, a *PeerCallError, is returned alone; in
+// BatchCollectAll mode the responses of failed calls are left zero and a *BatchError
+// is returned with them.
+func (b *PeerBatch) Invoke(ctx context.Context, specs []PeerCallSpec) ([]PeerResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	concurrency := b.Concurrency
+	if concurrency <= 0 || concurrency > len(specs) {
+		concurrency = len(specs)
+	}
+	responses := make([]PeerResponse, len(specs))
+	errs := make([]error, len(specs))
+	var failOnce sync.Once
+	var firstErr error
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				response, err := PeerCall(ctx, b.Peer, specs[i].CapUrn, specs[i].Arguments)
+				if err != nil {
+					errs[i] = &PeerCallError{Index: i, CapUrn: specs[i].CapUrn, Err: err}
+					if b.Mode == BatchFailFast {
+						failOnce.Do(func() {
+							firstErr = errs[i]
+							cancel()
+						})
+					}
+					continue
+				}
+				responses[i] = *response
+			}
+		}()
+	}
+	fed := 0
+feed:
+	for ; fed < len(specs); fed++ {
+		select {
+		case indexes <- fed:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if b.Mode == BatchFailFast {
+		if firstErr != nil {
+			return nil, firstErr
+		}
+		if fed < len(specs) {
+			return nil, ctx.Err()
+		}
+		return responses, nil
+	}
+	// Calls never made because ctx was cancelled fail with its error
+	for i := fed; i < len(specs); i++ {
+		errs[i] = &PeerCallError{Index: i, CapUrn: specs[i].CapUrn, Err: ctx.Err()}
+	}
+	for _, err := range errs {
+		if err != nil {
+			return responses, &BatchError{Errs: errs}
+		}
+	}
+	return responses, nil
+}
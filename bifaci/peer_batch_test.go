@@ -0,0 +1,80 @@
+package bifaci
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/machinefabric/capdag-go/cap"
+)
+
+func TestPeerBatchOrderAndConcurrency(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	peer := &funcPeer{caps: map[string]func([]cap.CapArgumentValue) (string, error){
+		"cap:op=echo": func(args []cap.CapArgumentValue) (string, error) {
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for {
+				max := maxInFlight.Load()
+				if n <= max || maxInFlight.CompareAndSwap(max, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			return string(args[0].Value), nil
+		},
+	}}
+
+	var specs []PeerCallSpec
+	for i := 0; i < 10; i++ {
+		specs = append(specs, PeerCallSpec{CapUrn: "cap:op=echo", Arguments: []cap.CapArgumentValue{
+			cap.NewCapArgumentValueFromStr("media:textable", fmt.Sprintf("item %d", i)),
+		}})
+	}
+	batch := &PeerBatch{Peer: peer, Concurrency: 3}
+	responses, err := batch.Invoke(context.Background(), specs)
+	if err != nil {
+		t.Fatalf("Invoke failed: %v", err)
+	}
+	for i, response := range responses {
+		var text string
+		if err := response.First().Decode(&text); err != nil || text != fmt.Sprintf("item %d", i) {
+			t.Errorf("Response %d: got %q (%v)", i, text, err)
+		}
+	}
+	if max := maxInFlight.Load(); max > 3 {
+		t.Errorf("Expected at most 3 calls in flight, got %d", max)
+	}
+}
+
+func TestPeerBatchModes(t *testing.T) {
+	peer := &funcPeer{caps: map[string]func([]cap.CapArgumentValue) (string, error){
+		"cap:op=ok": func([]cap.CapArgumentValue) (string, error) { return "ok", nil },
+		"cap:op=busy": func([]cap.CapArgumentValue) (string, error) {
+			return "", NewCapError(ErrCodeBusy, "overloaded")
+		},
+	}}
+	specs := []PeerCallSpec{{CapUrn: "cap:op=ok"}, {CapUrn: "cap:op=busy"}, {CapUrn: "cap:op=ok"}}
+
+	_, err := (&PeerBatch{Peer: peer}).Invoke(context.Background(), specs)
+	var callErr *PeerCallError
+	if !errors.As(err, &callErr) || callErr.Index != 1 {
+		t.Errorf("Expected fail-fast to return the failed call, got %v", err)
+	}
+
+	responses, err := (&PeerBatch{Peer: peer, Mode: BatchCollectAll}).Invoke(context.Background(), specs)
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || batchErr.Errs[0] != nil || batchErr.Errs[1] == nil || batchErr.Errs[2] != nil {
+		t.Fatalf("Expected a BatchError for the second call only, got %v", err)
+	}
+	var capErr *CapError
+	if !errors.As(err, &capErr) || capErr.Code != ErrCodeBusy {
+		t.Errorf("Expected the BatchError to unwrap to the BUSY error, got %v", capErr)
+	}
+	if responses[2].First() == nil {
+		t.Error("Expected the responses of the calls that succeeded")
+	}
+}
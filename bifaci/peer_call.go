@@ -0,0 +1,163 @@
+package bifaci
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/machinefabric/capdag-go/cap"
+	"github.com/machinefabric/capdag-go/urn"
+)
+
+// PeerError is the CapError of an ERR frame received in answer to a peer invocation.
+type PeerError = CapError
+
+// PeerResponseStream is one reassembled response stream of a peer invocation.
+type PeerResponseStream struct {
+	StreamId string
+	MediaUrn string
+	Data     []byte // Concatenated CHUNK payloads - a sequence of CBOR values
+}
+
+// Values decodes the stream into the CBOR values it was sent as (one per chunk).
+func (s *PeerResponseStream) Values() ([]interface{}, error) {
+	return decodeCborSequence(s.Data)
+}
+
+// Decode decodes the stream into target using the same rules as RegisterTyped:
+// byte/text chunks are joined and assigned to []byte/string targets or decoded
+// as JSON, structured values are decoded via CBOR.
+func (s *PeerResponseStream) Decode(target interface{}) error {
+	return decodeTypedArg(s.Data, target)
+}
+
+// PeerResponse is the complete response of a peer invocation.
+type PeerResponse struct {
+	Streams []*PeerResponseStream // In the order the streams ended
+}
+
+// Find returns the first stream whose media URN is equivalent to mediaUrn, or nil.
+func (r *PeerResponse) Find(mediaUrn string) (*PeerResponseStream, error) {
+	targetUrn, err := urn.NewMediaUrnFromString(mediaUrn)
+	if err != nil {
+		return nil, err
+	}
+	for _, stream := range r.Streams {
+		streamUrn, err := urn.NewMediaUrnFromString(stream.MediaUrn)
+		if err != nil {
+			continue
+		}
+		if targetUrn.Accepts(streamUrn) && streamUrn.Accepts(targetUrn) {
+			return stream, nil
+		}
+	}
+	return nil, nil
+}
+
+// First returns the first stream of the response, or nil if it has none.
+func (r *PeerResponse) First() *PeerResponseStream {
+	if len(r.Streams) == 0 {
+		return nil
+	}
+	return r.Streams[0]
+}
+
+// peerCall invokes a peer cap and collects the full response.
+// If ctx is cancelled first, the call is aborted with CANCEL and ctx.Err() is returned.
+func peerCall(ctx context.Context, peer CancellablePeerInvoker, capUrn string, arguments []cap.CapArgumentValue) (*PeerResponse, error) {
+	requestID, frames, err := peer.InvokeWithId(capUrn, arguments)
+	if err != nil {
+		return nil, err
+	}
+	return awaitPeerResponse(ctx, peer, requestID, frames)
+}
+
+// awaitPeerResponse collects the response of the peer request requestID, cancelling
+// the request if ctx is cancelled first
+func awaitPeerResponse(ctx context.Context, peer CancellablePeerInvoker, requestID MessageId, frames <-chan Frame) (*PeerResponse, error) {
+	response, err := collectPeerFrames(ctx, frames)
+	if err != nil {
+		if err == ctx.Err() {
+			// Cancel fails only if the response completed meanwhile - nothing left to abort
+			_ = peer.Cancel(requestID)
+		}
+		// The response channel must be drained until the peer's terminal frame,
+		// otherwise the runtime's read loop blocks on it
+		go func() {
+			for range frames {
+			}
+		}()
+	}
+	return response, err
+}
+
+// collectPeerFrames reassembles a peer response: checksums and chunk counts are
+// verified per stream, and an ERR frame is returned as *PeerError.
+// The response is complete at END or when the channel is closed.
+func collectPeerFrames(ctx context.Context, frames <-chan Frame) (*PeerResponse, error) {
+	response := &PeerResponse{}
+	active := make(map[string]*PeerResponseStream)
+	chunkCounts := make(map[string]uint64)
+
+	for {
+		var frame Frame
+		var ok bool
+		select {
+		case frame, ok = <-frames:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if !ok {
+			// The runtime closes the channel on the peer's END instead of forwarding it
+			if len(active) > 0 {
+				return nil, fmt.Errorf("peer response ended with %d unterminated streams", len(active))
+			}
+			return response, nil
+		}
+
+		switch frame.FrameType {
+		case FrameTypeStreamStart:
+			if frame.StreamId == nil || frame.MediaUrn == nil {
+				return nil, fmt.Errorf("STREAM_START without stream_id or media_urn")
+			}
+			active[*frame.StreamId] = &PeerResponseStream{StreamId: *frame.StreamId, MediaUrn: *frame.MediaUrn}
+
+		case FrameTypeChunk:
+			if err := VerifyChunkChecksum(&frame); err != nil {
+				return nil, fmt.Errorf("corrupted data: %w", err)
+			}
+			if frame.StreamId == nil {
+				return nil, fmt.Errorf("CHUNK without stream_id")
+			}
+			stream, exists := active[*frame.StreamId]
+			if !exists {
+				return nil, fmt.Errorf("CHUNK for unknown stream %s", *frame.StreamId)
+			}
+			stream.Data = append(stream.Data, frame.Payload...)
+			chunkCounts[stream.StreamId]++
+
+		case FrameTypeStreamEnd:
+			if frame.StreamId == nil {
+				return nil, fmt.Errorf("STREAM_END without stream_id")
+			}
+			stream, exists := active[*frame.StreamId]
+			if !exists {
+				return nil, fmt.Errorf("STREAM_END for unknown stream %s", *frame.StreamId)
+			}
+			if frame.ChunkCount != nil && *frame.ChunkCount != chunkCounts[stream.StreamId] {
+				return nil, fmt.Errorf("stream %s: STREAM_END chunk_count %d but received %d chunks",
+					stream.StreamId, *frame.ChunkCount, chunkCounts[stream.StreamId])
+			}
+			response.Streams = append(response.Streams, stream)
+			delete(active, *frame.StreamId)
+
+		case FrameTypeEnd:
+			if len(active) > 0 {
+				return nil, fmt.Errorf("peer response ended with %d unterminated streams", len(active))
+			}
+			return response, nil
+
+		case FrameTypeErr:
+			return nil, ErrorFromFrame(&frame)
+		}
+	}
+}
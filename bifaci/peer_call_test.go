@@ -0,0 +1,184 @@
+package bifaci
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+
+	"github.com/machinefabric/capdag-go/cap"
+)
+
+// peerResponseChannel builds a response channel from frames, closing it afterwards.
+func peerResponseChannel(frames ...*Frame) <-chan Frame {
+	ch := make(chan Frame, len(frames))
+	for _, f := range frames {
+		ch <- *f
+	}
+	close(ch)
+	return ch
+}
+
+func cborChunk(t *testing.T, reqId MessageId, streamId string, index uint64, value interface{}) *Frame {
+	t.Helper()
+	payload, err := cborlib.Marshal(value)
+	if err != nil {
+		t.Fatalf("Failed to encode chunk: %v", err)
+	}
+	return NewChunk(reqId, streamId, index, payload, index, ComputeChecksum(payload))
+}
+
+// TestCollectPeerFramesMultipleStreams: streams are reassembled separately and found by media URN
+func TestCollectPeerFramesMultipleStreams(t *testing.T) {
+	id := NewMessageIdRandom()
+	frames := peerResponseChannel(
+		NewStreamStart(id, "s1", "media:pdf"),
+		NewStreamStart(id, "s2", "media:json"),
+		cborChunk(t, id, "s1", 0, []byte("%PDF-")),
+		cborChunk(t, id, "s2", 0, map[string]interface{}{"pages": 3}),
+		cborChunk(t, id, "s1", 1, []byte("1.7")),
+		NewStreamEnd(id, "s1", 2),
+		NewStreamEnd(id, "s2", 1),
+		NewEnd(id, nil),
+	)
+
+	response, err := collectPeerFrames(context.Background(), frames)
+	if err != nil {
+		t.Fatalf("collectPeerFrames failed: %v", err)
+	}
+	if len(response.Streams) != 2 {
+		t.Fatalf("Expected 2 streams, got %d", len(response.Streams))
+	}
+
+	pdf, err := response.Find("media:pdf")
+	if err != nil || pdf == nil {
+		t.Fatalf("media:pdf stream not found: %v", err)
+	}
+	var pdfBytes []byte
+	if err := pdf.Decode(&pdfBytes); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if string(pdfBytes) != "%PDF-1.7" {
+		t.Errorf("Expected joined chunks, got %q", pdfBytes)
+	}
+
+	meta, _ := response.Find("media:json")
+	var decoded struct {
+		Pages int `cbor:"pages"`
+	}
+	if err := meta.Decode(&decoded); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if decoded.Pages != 3 {
+		t.Errorf("Expected pages 3, got %d", decoded.Pages)
+	}
+}
+
+// TestCollectPeerFramesErr: an ERR response surfaces as *PeerError
+func TestCollectPeerFramesErr(t *testing.T) {
+	id := NewMessageIdRandom()
+	frames := peerResponseChannel(NewErr(id, "NO_HANDLER", "no plugin handles cap"))
+
+	_, err := collectPeerFrames(context.Background(), frames)
+	var peerErr *PeerError
+	if !errors.As(err, &peerErr) {
+		t.Fatalf("Expected *PeerError, got %v", err)
+	}
+	if peerErr.Code != "NO_HANDLER" || peerErr.Message != "no plugin handles cap" {
+		t.Errorf("Unexpected error contents: %+v", peerErr)
+	}
+}
+
+// TestCollectPeerFramesRejectsCorruption: bad checksums and chunk count mismatches are errors
+func TestCollectPeerFramesRejectsCorruption(t *testing.T) {
+	id := NewMessageIdRandom()
+
+	corrupted := cborChunk(t, id, "s1", 0, "data")
+	*corrupted.Checksum ^= 1
+	if _, err := collectPeerFrames(context.Background(), peerResponseChannel(
+		NewStreamStart(id, "s1", "media:"), corrupted, NewStreamEnd(id, "s1", 1), NewEnd(id, nil),
+	)); err == nil {
+		t.Error("Checksum mismatch must be rejected")
+	}
+
+	if _, err := collectPeerFrames(context.Background(), peerResponseChannel(
+		NewStreamStart(id, "s1", "media:"), cborChunk(t, id, "s1", 0, "data"), NewStreamEnd(id, "s1", 2), NewEnd(id, nil),
+	)); err == nil {
+		t.Error("Chunk count mismatch must be rejected")
+	}
+
+	if _, err := collectPeerFrames(context.Background(), peerResponseChannel(
+		NewStreamStart(id, "s1", "media:"),
+	)); err == nil {
+		t.Error("Response with an unterminated stream must be rejected")
+	}
+}
+
+// TestPeerCallEndToEnd: a handler uses PeerCall and gets the host's decoded response
+func TestPeerCallEndToEnd(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+
+	result := make(chan string, 1)
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		response, err := PeerCall(HandlerContext(emitter), peer, `cap:in="media:void";op=peer;out="media:void"`,
+			[]cap.CapArgumentValue{{MediaUrn: "media:", Value: []byte("ping")}})
+		if err != nil {
+			return err
+		}
+		var text string
+		if err := response.First().Decode(&text); err != nil {
+			return err
+		}
+		result <- text
+		return nil
+	})
+
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	reqId := NewMessageIdRandom()
+	writeTestRequest(t, writer, reqId, testCancelCap, []byte{0x40})
+
+	// Host side: answer the peer request once its END arrives
+	for {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		if frame.FrameType == FrameTypeEnd && !frame.Id.Equals(reqId) {
+			peerId := frame.Id
+			for _, f := range []*Frame{
+				NewStreamStart(peerId, "r1", "media:"),
+				cborChunk(t, peerId, "r1", 0, "po"),
+				cborChunk(t, peerId, "r1", 1, "ng"),
+				NewStreamEnd(peerId, "r1", 2),
+				NewEnd(peerId, nil),
+			} {
+				writer.WriteFrame(f)
+			}
+			break
+		}
+	}
+
+	select {
+	case text := <-result:
+		if text != "pong" {
+			t.Errorf("Expected pong, got %q", text)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Handler did not receive the peer response")
+	}
+
+	frames := readUntilTerminal(t, reader, reqId)
+	if frames[len(frames)-1].FrameType != FrameTypeEnd {
+		t.Errorf("Expected END, got %v", frames[len(frames)-1].FrameType)
+	}
+	if err := stop(); err != nil {
+		t.Errorf("Runtime exited with error: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package bifaci
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/machinefabric/capdag-go/cap"
+)
+
+// Optional PeerInvoker features. The PeerInvoker the runtime hands handlers implements
+// all of them; handlers reach them by type assertion or through the functions below,
+// which work on any PeerInvoker where they can.
+
+// CancellablePeerInvoker can cancel a peer invocation that is still in flight.
+type CancellablePeerInvoker interface {
+	PeerInvoker
+	// InvokeWithId is like Invoke but also returns the request ID of the peer call,
+	// which can later be passed to Cancel.
+	InvokeWithId(capUrn string, arguments []cap.CapArgumentValue) (MessageId, <-chan Frame, error)
+	// Cancel aborts a peer invocation that is still in flight by sending a CANCEL frame.
+	// The response channel is closed once the peer answers with its terminal frame
+	// (normally ERR with code CANCELLED, or END if the response already completed).
+	Cancel(requestID MessageId) error
+}
+
+// RetryingPeerInvoker re-issues failed peer invocations under an idempotency key (see
+// InvokeWithPolicy).
+type RetryingPeerInvoker interface {
+	// InvokeWithPolicy is like PeerCall bounded by the handler's request, re-issuing
+	// the request as policy allows if it fails (see RetryPolicy).
+	InvokeWithPolicy(capUrn string, arguments []cap.CapArgumentValue, policy RetryPolicy) (*PeerResponse, error)
+}
+
+// PeerCall invokes a peer cap and waits for the complete response, reassembled per
+// stream. Checksums and chunk counts are verified; an ERR response is returned as
+// *PeerError. If ctx is cancelled before the response completes, ctx.Err() is returned
+// and the call is cancelled if peer is a CancellablePeerInvoker.
+func PeerCall(ctx context.Context, peer PeerInvoker, capUrn string, arguments []cap.CapArgumentValue) (*PeerResponse, error) {
+	if cancellable, ok := peer.(CancellablePeerInvoker); ok {
+		return peerCall(ctx, cancellable, capUrn, arguments)
+	}
+	frames, err := peer.Invoke(capUrn, arguments)
+	if err != nil {
+		return nil, err
+	}
+	response, err := collectPeerFrames(ctx, frames)
+	if err != nil {
+		go func() {
+			for range frames {
+			}
+		}()
+	}
+	return response, err
+}
+
+// InvokeWithPolicy makes a peer call, re-issued as policy allows if it fails (see
+// RetryingPeerInvoker).
+func InvokeWithPolicy(peer PeerInvoker, capUrn string, arguments []cap.CapArgumentValue, policy RetryPolicy) (*PeerResponse, error) {
+	if retrying, ok := peer.(RetryingPeerInvoker); ok {
+		return retrying.InvokeWithPolicy(capUrn, arguments, policy)
+	}
+	return nil, fmt.Errorf("%T can't retry peer invocations: %w", peer, errors.ErrUnsupported)
+}
@@ -0,0 +1,103 @@
+package bifaci
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// DefaultPeerBufferSize is the number of response frames buffered per peer request
+// when PeerLimits.BufferSize is 0
+const DefaultPeerBufferSize = 64
+
+// Policies for a peer invocation made while PeerLimits.MaxInFlight requests are pending
+const (
+	PeerLimitBlock = "block" // Wait for one of them to complete (the default)
+	PeerLimitError = "error" // Fail with ErrPeerLimit
+)
+
+// ErrPeerLimit is returned by PeerInvoker.Invoke under the PeerLimitError policy when
+// PeerLimits.MaxInFlight peer requests are already pending
+var ErrPeerLimit = errors.New("too many peer requests in flight")
+
+// PeerLimits bounds the peer requests handlers make (see SetPeerLimits)
+type PeerLimits struct {
+	MaxInFlight int    // Peer requests pending at once across all handlers (0 = unlimited)
+	BufferSize  int    // Response frames buffered per peer request (0 = DefaultPeerBufferSize)
+	Policy      string // PeerLimitBlock or PeerLimitError ("" = PeerLimitBlock)
+}
+
+// peerLimiter counts the pending peer requests of a runtime and holds invocations
+// beyond PeerLimits.MaxInFlight
+type peerLimiter struct {
+	mu       sync.Mutex
+	limits   PeerLimits
+	inFlight int
+	freed    chan struct{} // Closed, and replaced, whenever a peer request completes
+}
+
+func newPeerLimiter() *peerLimiter {
+	return &peerLimiter{freed: make(chan struct{})}
+}
+
+// acquire admits a peer request, waiting for a slot under the PeerLimitBlock policy
+// until ctx is done
+func (l *peerLimiter) acquire(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.limits.MaxInFlight <= 0 || l.inFlight < l.limits.MaxInFlight {
+			l.inFlight++
+			l.mu.Unlock()
+			return nil
+		}
+		policy, freed := l.limits.Policy, l.freed
+		l.mu.Unlock()
+		if policy == PeerLimitError {
+			return ErrPeerLimit
+		}
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release frees the slot of a completed peer request
+func (l *peerLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	close(l.freed)
+	l.freed = make(chan struct{})
+}
+
+// bufferSize returns the response buffer of a new peer request
+func (l *peerLimiter) bufferSize() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limits.BufferSize <= 0 {
+		return DefaultPeerBufferSize
+	}
+	return l.limits.BufferSize
+}
+
+// SetPeerLimits bounds the peer requests handlers make, so a fan-out of invocations
+// can't pile up unanswered while the host stalls. Beyond limits.MaxInFlight pending
+// requests, Invoke waits for one to complete or fails with ErrPeerLimit, per
+// limits.Policy; a blocked Invoke gives up when its handler's request is cancelled.
+// Responses are buffered up to limits.BufferSize frames per request before the runtime
+// waits for the handler to read them. Takes effect at once, for all connections.
+func (pr *PluginRuntime) SetPeerLimits(limits PeerLimits) {
+	pr.peers.mu.Lock()
+	defer pr.peers.mu.Unlock()
+	pr.peers.limits = limits
+}
+
+// PeerRequestsInFlight returns the number of peer requests sent and not yet answered
+// with END or ERR, for diagnostics
+func (pr *PluginRuntime) PeerRequestsInFlight() int {
+	pr.peers.mu.Lock()
+	defer pr.peers.mu.Unlock()
+	return pr.peers.inFlight
+}
@@ -0,0 +1,94 @@
+package bifaci
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPeerLimitsErrorPolicy(t *testing.T) {
+	runtime := newRoutingRuntime(t)
+	runtime.SetPeerLimits(PeerLimits{MaxInFlight: 1, Policy: PeerLimitError})
+	inFlight := make(chan int, 2)
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		response, err := peer.Invoke(testCancelCap, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := peer.Invoke(testCancelCap, nil); !errors.Is(err, ErrPeerLimit) {
+			return fmt.Errorf("expected ErrPeerLimit, got %v", err)
+		}
+		inFlight <- runtime.PeerRequestsInFlight()
+		for range response {
+		}
+		inFlight <- runtime.PeerRequestsInFlight()
+		return nil
+	})
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	defer stop()
+
+	reqId := NewMessageIdRandom()
+	writeTestRequest(t, writer, reqId, testCancelCap, []byte{0x40})
+	for {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		if frame.FrameType == FrameTypeReq {
+			// The handler checks the limit once it sent the whole peer request
+			readUntilTerminal(t, reader, frame.Id)
+			if n := <-inFlight; n != 1 {
+				t.Errorf("Expected 1 peer request in flight, got %d", n)
+			}
+			if err := writer.WriteFrame(NewEnd(frame.Id, nil)); err != nil {
+				t.Fatalf("Failed to answer the peer request: %v", err)
+			}
+			break
+		}
+	}
+	frames := readUntilTerminal(t, reader, reqId)
+	if last := frames[len(frames)-1]; last.FrameType != FrameTypeEnd {
+		t.Fatalf("Expected END, got %v %s", last.FrameType, last.ErrorMessage())
+	}
+	if n := <-inFlight; n != 0 {
+		t.Errorf("Expected no peer request in flight once answered, got %d", n)
+	}
+}
+
+func TestPeerLimiterBlocks(t *testing.T) {
+	limiter := newPeerLimiter()
+	limiter.limits = PeerLimits{MaxInFlight: 1}
+	if err := limiter.acquire(context.Background()); err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+
+	acquired := make(chan error, 1)
+	go func() { acquired <- limiter.acquire(context.Background()) }()
+	select {
+	case err := <-acquired:
+		t.Fatalf("Expected acquire to wait for a free slot, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	limiter.release()
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatalf("acquire failed: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("acquire did not resume once a slot was freed")
+	}
+
+	// A waiting invocation gives up with its request
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { acquired <- limiter.acquire(ctx) }()
+	cancel()
+	if err := <-acquired; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if limiter.bufferSize() != DefaultPeerBufferSize {
+		t.Errorf("Expected the default buffer size, got %d", limiter.bufferSize())
+	}
+}
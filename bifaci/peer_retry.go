@@ -0,0 +1,58 @@
+package bifaci
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// RetryPolicy says when InvokeWithPolicy re-issues a failed peer request.
+// A request that couldn't be sent, or whose response arrived corrupted or incomplete,
+// is retried; one answered with ERR only if its code is in RetryOn, or if RetryOn is
+// nil, if the ERR is retryable (see CapError). Every attempt
+// carries the same idempotency key in its REQ (see Frame.IdempotencyKey), so the host
+// can tell a retry from a new request.
+type RetryPolicy struct {
+	MaxAttempts int           // Attempts in total, including the first (0 or 1 = no retry)
+	Backoff     time.Duration // Wait before the second attempt, doubled before each further one
+	RetryOn     []string      // ERR codes worth retrying, e.g. "BUSY" or "TIMEOUT" (nil = the retryable ones)
+}
+
+// retryable reports whether a failed attempt may be repeated
+func (p RetryPolicy) retryable(err error) bool {
+	var peerErr *PeerError
+	if errors.As(err, &peerErr) {
+		if p.RetryOn == nil {
+			return peerErr.Retryable
+		}
+		for _, code := range p.RetryOn {
+			if ErrCode(code) == peerErr.Code {
+				return true
+			}
+		}
+		return false
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// retryPeerCall makes attempts at a peer call until one succeeds, fails for good, or
+// policy allows no more. The last attempt's error is returned.
+func retryPeerCall(ctx context.Context, policy RetryPolicy, attempt func() (*PeerResponse, error)) (*PeerResponse, error) {
+	backoff := policy.Backoff
+	for n := 1; ; n++ {
+		response, err := attempt()
+		if err == nil || n >= policy.MaxAttempts || !policy.retryable(err) || ctx.Err() != nil {
+			return response, err
+		}
+		if backoff > 0 {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			}
+			backoff *= 2
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package bifaci
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestInvokeWithPolicyRetriesWithIdempotencyKey(t *testing.T) {
+	runtime := newRoutingRuntime(t)
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		response, err := InvokeWithPolicy(peer, testCancelCap, nil, RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, RetryOn: []string{"BUSY"}})
+		if err != nil {
+			return err
+		}
+		var answer string
+		if err := response.First().Decode(&answer); err != nil {
+			return err
+		}
+		return emitter.EmitCbor(answer)
+	})
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	defer stop()
+
+	reqId := NewMessageIdRandom()
+	writeTestRequest(t, writer, reqId, testCancelCap, []byte{0x40})
+
+	// The first attempt is answered BUSY, the second with a response
+	var attempts []*Frame
+	for len(attempts) < 2 {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		if frame.FrameType != FrameTypeReq {
+			continue
+		}
+		attempts = append(attempts, frame)
+		if len(attempts) == 1 {
+			writer.WriteFrame(NewErr(frame.Id, "BUSY", "try again"))
+			continue
+		}
+		writer.WriteFrame(NewStreamStart(frame.Id, "s1", "media:textable"))
+		writer.WriteFrame(cborChunk(t, frame.Id, "s1", 0, "second time lucky"))
+		writer.WriteFrame(NewStreamEnd(frame.Id, "s1", 1))
+		writer.WriteFrame(NewEnd(frame.Id, nil))
+	}
+	key := attempts[0].IdempotencyKey()
+	if key == "" || attempts[1].IdempotencyKey() != key || attempts[0].Id.Equals(attempts[1].Id) {
+		t.Errorf("Expected two requests sharing an idempotency key, got keys %q and %q", key, attempts[1].IdempotencyKey())
+	}
+
+	frames := readUntilTerminal(t, reader, reqId)
+	if last := frames[len(frames)-1]; last.FrameType != FrameTypeEnd {
+		t.Fatalf("Expected END, got %v %s", last.FrameType, last.ErrorMessage())
+	}
+}
+
+func TestRetryPeerCall(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, RetryOn: []string{"BUSY"}}
+	cases := []struct {
+		name     string
+		err      error
+		attempts int
+	}{
+		{"retryable code", &PeerError{Code: "BUSY"}, 3},
+		{"other code", &PeerError{Code: "HANDLER_ERROR"}, 1},
+		{"transport error", fmt.Errorf("failed to send REQ frame: %w", errors.New("broken pipe")), 3},
+		{"cancelled", context.Canceled, 1},
+	}
+	for _, c := range cases {
+		attempts := 0
+		_, err := retryPeerCall(context.Background(), policy, func() (*PeerResponse, error) {
+			attempts++
+			return nil, c.err
+		})
+		if attempts != c.attempts || err != c.err {
+			t.Errorf("%s: expected %d attempts returning the error, got %d (%v)", c.name, c.attempts, attempts, err)
+		}
+	}
+
+	// Waiting between attempts ends with the context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := retryPeerCall(ctx, RetryPolicy{MaxAttempts: 2, Backoff: time.Hour}, func() (*PeerResponse, error) {
+		return nil, errors.New("connection reset")
+	})
+	if err == nil {
+		t.Error("Expected an error")
+	}
+}
@@ -0,0 +1,126 @@
+package bifaci
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/machinefabric/capdag-go/standard"
+	"github.com/machinefabric/capdag-go/urn"
+)
+
+// capPermissions are the host caps a plugin may invoke, parsed from the permissions of
+// its manifest; nil permits every cap
+type capPermissions []*urn.CapUrn
+
+// parseCapPermissions parses the permission patterns of a manifest
+func parseCapPermissions(patterns []string) (capPermissions, error) {
+	var permissions capPermissions
+	for _, pattern := range patterns {
+		patternUrn, err := urn.NewCapUrnFromString(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid permission %q: %w", pattern, err)
+		}
+		permissions = append(permissions, patternUrn)
+	}
+	return permissions, nil
+}
+
+// check returns a PERMISSION_DENIED error unless a pattern accepts capUrn. Discovery is
+// always permitted: it only lists caps.
+func (p capPermissions) check(capUrn string) error {
+	if len(p) == 0 {
+		return nil
+	}
+	requested, err := urn.NewCapUrnFromString(capUrn)
+	if err != nil {
+		return NewCapError(ErrCodePermissionDenied, fmt.Sprintf("invalid cap URN %q: %v", capUrn, err))
+	}
+	if discoverUrn, err := urn.NewCapUrnFromString(standard.CapDiscover); err == nil && requested.Equals(discoverUrn) {
+		return nil
+	}
+	for _, pattern := range p {
+		// The pattern plays the handler: it permits every cap it would accept as a
+		// request, its missing tags being wildcards (e.g. cap:op=extract permits any
+		// extract cap)
+		if pattern.Accepts(requested) {
+			return nil
+		}
+	}
+	return NewCapError(ErrCodePermissionDenied, fmt.Sprintf("the plugin's manifest doesn't permit invoking %s", capUrn))
+}
+
+// permissionsFromManifest returns the permission patterns of a JSON manifest
+func permissionsFromManifest(manifest []byte) ([]string, error) {
+	if len(manifest) == 0 {
+		return nil, nil
+	}
+	var parsed struct {
+		Permissions []string `json:"permissions"`
+	}
+	if err := json.Unmarshal(manifest, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest JSON: %w", err)
+	}
+	return parsed.Permissions, nil
+}
+
+// PermissionApprover decides whether a plugin may run with the permissions its manifest
+// requests in the handshake, nil if the plugin requests none and may invoke any host cap.
+// A plugin is rejected if it returns an error.
+type PermissionApprover func(manifest []byte, permissions []string) error
+
+// SetPermissionApprover has the host ask approve, e.g. by prompting the user, for the
+// permissions of every plugin attached or spawned afterwards, right after the handshake.
+// A plugin that isn't approved fails to attach or spawn. approve runs with the host
+// locked and must not call its methods.
+func (h *PluginHost) SetPermissionApprover(approve PermissionApprover) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.approvePermissions = approve
+}
+
+// Permissions returns the permission patterns the plugin's manifest requested, nil if it
+// requested none or hasn't been spawned
+func (h *PluginHost) Permissions(pluginIdx int) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if pluginIdx < 0 || pluginIdx >= len(h.plugins) {
+		return nil
+	}
+	return h.plugins[pluginIdx].permissions
+}
+
+// grantPermissionsLocked parses the permissions of a plugin's manifest, asks the
+// approver for them unless the plugin's previous process was granted the same, and
+// grants them to the plugin, whose peer requests are checked against them from then on
+// (caller holds mu)
+func (h *PluginHost) grantPermissionsLocked(plugin *ManagedPlugin, manifest []byte) error {
+	patterns, err := permissionsFromManifest(manifest)
+	if err != nil {
+		return err
+	}
+	permissions, err := parseCapPermissions(patterns)
+	if err != nil {
+		return err
+	}
+	if h.approvePermissions != nil && !(plugin.granted && equalStrings(patterns, plugin.permissions)) {
+		if err := h.approvePermissions(manifest, patterns); err != nil {
+			return fmt.Errorf("permissions not approved: %w", err)
+		}
+	}
+	plugin.permissions = patterns
+	plugin.allowed = permissions
+	plugin.granted = true
+	return nil
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,111 @@
+package bifaci
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/machinefabric/capdag-go/standard"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const permissionsManifest = `{"name":"Summarizer","version":"1.0","caps":[{"urn":"cap:in=\"media:void\";op=summarize;out=\"media:void\""}],"permissions":["cap:op=extract"]}`
+
+func TestCapPermissionsCheck(t *testing.T) {
+	permissions, err := parseCapPermissions([]string{"cap:op=extract"})
+	require.NoError(t, err)
+
+	assert.NoError(t, permissions.check(`cap:in="media:pdf";op=extract;out="media:void"`))
+	assert.NoError(t, permissions.check(standard.CapDiscover), "discovery must always be permitted")
+	err = permissions.check(`cap:in="media:void";op=summarize;out="media:void"`)
+	assert.True(t, errors.Is(err, ErrCodePermissionDenied), "expected PERMISSION_DENIED, got %v", err)
+
+	var unrestricted capPermissions
+	assert.NoError(t, unrestricted.check(`cap:in="media:void";op=summarize;out="media:void"`))
+
+	_, err = parseCapPermissions([]string{"not a urn"})
+	assert.Error(t, err)
+}
+
+// A denied peer invocation fails before anything is written to the host
+func TestPeerInvokeDeniedLocally(t *testing.T) {
+	permissions, err := parseCapPermissions([]string{"cap:op=extract"})
+	require.NoError(t, err)
+	// A nil writer would panic if the REQ were sent
+	peer := newPeerInvokerImpl(context.Background(), nil, &sync.Map{}, newPeerLimiter(), permissions, DefaultMaxChunk, nil)
+
+	_, err = peer.Invoke(`cap:in="media:void";op=summarize;out="media:void"`, nil)
+	assert.True(t, errors.Is(err, ErrCodePermissionDenied), "expected PERMISSION_DENIED, got %v", err)
+	assert.Equal(t, string(ErrCodePermissionDenied), handlerErrorCode(err))
+}
+
+func TestManifestPermissionsValidated(t *testing.T) {
+	manifest := NewCapManifest("Test", "1.0", "", nil).EnsureIdentity().WithPermissions("cap:op=extract", "not a urn")
+	diagnostics := ValidateManifest(manifest)
+	require.Len(t, diagnostics, 1)
+	assert.Equal(t, "permissions[1]", diagnostics[0].Path)
+}
+
+// The host hands the permissions of the handshake to its approver and rejects the plugin
+// if it refuses them
+func TestHostApprovesPermissions(t *testing.T) {
+	for _, approve := range []bool{false, true} {
+		hostRead, pluginWrite := net.Pipe()
+		pluginRead, hostWrite := net.Pipe()
+		go simulatePlugin(t, pluginRead, pluginWrite, permissionsManifest, nil)
+
+		host := NewPluginHost()
+		var requested []string
+		host.SetPermissionApprover(func(manifest []byte, permissions []string) error {
+			requested = permissions
+			if !approve {
+				return errors.New("refused")
+			}
+			return nil
+		})
+		idx, err := host.AttachPlugin(hostRead, hostWrite)
+		pluginRead.Close()
+		assert.Equal(t, []string{"cap:op=extract"}, requested)
+		if !approve {
+			assert.Error(t, err, "a refused plugin must not attach")
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, []string{"cap:op=extract"}, host.Permissions(idx))
+	}
+}
+
+// The host denies a peer request its permissions don't cover and drops the rest of it
+func TestHostDeniesUnpermittedPeerRequest(t *testing.T) {
+	hostRead, pluginWrite := net.Pipe()
+	pluginRead, hostWrite := net.Pipe()
+	defer pluginRead.Close()
+	received := make(chan *Frame, 8)
+	go simulatePlugin(t, pluginRead, pluginWrite, permissionsManifest, func(reader *FrameReader, writer *FrameWriter) {
+		for {
+			frame, err := reader.ReadFrame()
+			if err != nil {
+				close(received)
+				return
+			}
+			received <- frame
+		}
+	})
+
+	host := NewPluginHost()
+	idx, err := host.AttachPlugin(hostRead, hostWrite)
+	require.NoError(t, err)
+
+	reqId := NewMessageIdRandom()
+	// With no relay to forward to, a forwarded frame would panic
+	host.handlePluginFrame(idx, 0, NewReq(reqId, `cap:in="media:void";op=summarize;out="media:void"`, nil, "application/cbor"), nil)
+	host.handlePluginFrame(idx, 0, NewEnd(reqId, nil), nil)
+	assert.Empty(t, host.answered, "the denial must be settled by the plugin's END")
+
+	frame := <-received
+	require.Equal(t, FrameTypeErr, frame.FrameType)
+	assert.Equal(t, string(ErrCodePermissionDenied), frame.ErrorCode())
+}
@@ -0,0 +1,95 @@
+package bifaci
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/machinefabric/capdag-go/cap"
+)
+
+// PipelineError is returned by Pipeline.Run for the stage that failed. It unwraps to the
+// stage's error, so a handler returning it answers with the failing cap's ERR code.
+type PipelineError struct {
+	Stage  int    // Index of the failed stage, from 0
+	CapUrn string // Cap of the failed stage
+	Err    error
+}
+
+func (e *PipelineError) Error() string {
+	return fmt.Sprintf("pipeline stage %d (%s) failed: %v", e.Stage, e.CapUrn, e.Err)
+}
+
+func (e *PipelineError) Unwrap() error {
+	return e.Err
+}
+
+// pipelineStage is one peer invocation of a Pipeline
+type pipelineStage struct {
+	capUrn string
+	extra  []cap.CapArgumentValue
+}
+
+// Pipeline chains peer invocations: every stream of one cap's response becomes an
+// argument of the next cap, under its media URN. An orchestration handler expresses
+// extract → transform → summarize as:
+//
+//	response, err := bifaci.NewPipeline(peer).
+//		Then(extractCap).
+//		Then(transformCap).
+//		Then(summarizeCap, cap.NewCapArgumentValueFromStr(standard.MediaModelSpec, "small")).
+//		Run(ctx, cap.NewCapArgumentValue(standard.MediaPDF, pdf))
+//
+// A peer request carries complete arguments, so a stage is invoked once the previous
+// response has ended; its frames are read as they arrive, the peer's flow control
+// holding off a stage that produces faster. The first stage to fail stops the pipeline.
+type Pipeline struct {
+	peer   PeerInvoker
+	stages []pipelineStage
+}
+
+// NewPipeline returns an empty pipeline invoking caps through peer
+func NewPipeline(peer PeerInvoker) *Pipeline {
+	return &Pipeline{peer: peer}
+}
+
+// Then appends a stage invoking capUrn with the previous stage's output, followed by
+// extra arguments of its own
+func (p *Pipeline) Then(capUrn string, extra ...cap.CapArgumentValue) *Pipeline {
+	p.stages = append(p.stages, pipelineStage{capUrn: capUrn, extra: extra})
+	return p
+}
+
+// Run invokes the stages in order, the first with input, and returns the response of
+// the last. If ctx is cancelled, the stage in flight is cancelled and ctx.Err() wrapped
+// in a *PipelineError returned.
+func (p *Pipeline) Run(ctx context.Context, input ...cap.CapArgumentValue) (*PeerResponse, error) {
+	if len(p.stages) == 0 {
+		return nil, fmt.Errorf("pipeline has no stages")
+	}
+	arguments := input
+	var response *PeerResponse
+	for i, stage := range p.stages {
+		var err error
+		stageArguments := append(append([]cap.CapArgumentValue(nil), arguments...), stage.extra...)
+		response, err = PeerCall(ctx, p.peer, stage.capUrn, stageArguments)
+		if err != nil {
+			return nil, &PipelineError{Stage: i, CapUrn: stage.capUrn, Err: err}
+		}
+		arguments = make([]cap.CapArgumentValue, 0, len(response.Streams))
+		for _, stream := range response.Streams {
+			arguments = append(arguments, streamArgument(stream))
+		}
+	}
+	return response, nil
+}
+
+// streamArgument turns a response stream into an argument: the contents of its byte or
+// text CHUNKs, or the CBOR encoding of the values it carries, which the receiver decodes
+// as a CBOR document (see Arg.DecodeCBOR)
+func streamArgument(stream *PeerResponseStream) cap.CapArgumentValue {
+	arg := Arg{MediaUrn: stream.MediaUrn, Data: stream.Data}
+	if contents, err := arg.Bytes(); err == nil {
+		return cap.NewCapArgumentValue(stream.MediaUrn, contents)
+	}
+	return cap.NewCapArgumentValue(stream.MediaUrn, stream.Data)
+}
@@ -0,0 +1,84 @@
+package bifaci
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+
+	"github.com/machinefabric/capdag-go/cap"
+)
+
+// funcPeer answers peer calls with the text a function makes of the arguments
+type funcPeer struct {
+	caps map[string]func(arguments []cap.CapArgumentValue) (string, error)
+}
+
+func (p *funcPeer) Invoke(capUrn string, arguments []cap.CapArgumentValue) (<-chan Frame, error) {
+	text, err := p.caps[capUrn](arguments)
+	if err != nil {
+		return nil, err
+	}
+	id := NewMessageIdRandom()
+	payload, _ := cborlib.Marshal(text)
+	ch := make(chan Frame, 4)
+	ch <- *NewStreamStart(id, "out", "media:"+strings.TrimPrefix(capUrn, "cap:op=")+";textable")
+	ch <- *NewChunk(id, "out", 0, payload, 0, ComputeChecksum(payload))
+	ch <- *NewStreamEnd(id, "out", 1)
+	ch <- *NewEnd(id, nil)
+	close(ch)
+	return ch, nil
+}
+
+func TestPipelineChainsStages(t *testing.T) {
+	peer := &funcPeer{caps: map[string]func([]cap.CapArgumentValue) (string, error){
+		"cap:op=extract": func(args []cap.CapArgumentValue) (string, error) {
+			return "text of " + string(args[0].Value), nil
+		},
+		"cap:op=summarize": func(args []cap.CapArgumentValue) (string, error) {
+			if len(args) != 2 || args[0].MediaUrn != "media:extract;textable" {
+				return "", errors.New("unexpected arguments")
+			}
+			return "summary of " + string(args[0].Value) + " by " + string(args[1].Value), nil
+		},
+	}}
+
+	response, err := NewPipeline(peer).
+		Then("cap:op=extract").
+		Then("cap:op=summarize", cap.NewCapArgumentValueFromStr("media:model-spec;textable", "small")).
+		Run(context.Background(), cap.NewCapArgumentValue("media:pdf", []byte("doc.pdf")))
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	var summary string
+	if err := response.First().Decode(&summary); err != nil || summary != "summary of text of doc.pdf by small" {
+		t.Errorf("Expected the summary, got %q (%v)", summary, err)
+	}
+}
+
+func TestPipelineStopsAtFailure(t *testing.T) {
+	calls := 0
+	peer := &funcPeer{caps: map[string]func([]cap.CapArgumentValue) (string, error){
+		"cap:op=extract": func([]cap.CapArgumentValue) (string, error) {
+			return "", NewCapError(ErrCodeBusy, "overloaded")
+		},
+		"cap:op=summarize": func([]cap.CapArgumentValue) (string, error) {
+			calls++
+			return "", nil
+		},
+	}}
+
+	_, err := NewPipeline(peer).Then("cap:op=extract").Then("cap:op=summarize").Run(context.Background())
+	var pipelineErr *PipelineError
+	if !errors.As(err, &pipelineErr) || pipelineErr.Stage != 0 || pipelineErr.CapUrn != "cap:op=extract" {
+		t.Fatalf("Expected the first stage to fail, got %v", err)
+	}
+	if code := handlerErrorCode(err); code != string(ErrCodeBusy) {
+		t.Errorf("Expected the stage's BUSY code, got %s", code)
+	}
+	if calls != 0 {
+		t.Error("Expected the pipeline to stop at the failed stage")
+	}
+}
@@ -0,0 +1,336 @@
+package bifaci
+
+import (
+	"context"
+	"fmt"
+	"unicode/utf8"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+
+	"github.com/machinefabric/capdag-go/urn"
+)
+
+// ResponseChunk represents a response chunk from a plugin (matches Rust ResponseChunk)
+type ResponseChunk struct {
+	Payload  []byte
+	Seq      uint64
+	Offset   *uint64
+	Len      *uint64
+	IsEof    bool
+	StreamId string // Stream the chunk belongs to, set by CollectPluginResponse
+}
+
+// PluginResponseType indicates whether a response is single or streaming
+type PluginResponseType int
+
+const (
+	PluginResponseTypeSingle PluginResponseType = iota
+	PluginResponseTypeStreaming
+)
+
+// PluginResponse represents a complete response from a plugin. CollectPluginResponse
+// assembles one from a response's frames: Streaming then holds every CHUNK in arrival
+// order, and Streams the chunks grouped by stream.
+type PluginResponse struct {
+	Type      PluginResponseType
+	Single    []byte
+	Streaming []*ResponseChunk
+	streams   []*ResponseStream
+}
+
+// ResponseStream is one stream of a response: STREAM_START, its CHUNKs, STREAM_END
+type ResponseStream struct {
+	StreamId string
+	MediaUrn string
+	Chunks   []*ResponseChunk
+}
+
+// FinalPayload gets the final payload
+func (pr *PluginResponse) FinalPayload() []byte {
+	switch pr.Type {
+	case PluginResponseTypeSingle:
+		return pr.Single
+	case PluginResponseTypeStreaming:
+		if len(pr.Streaming) > 0 {
+			return pr.Streaming[len(pr.Streaming)-1].Payload
+		}
+		return nil
+	default:
+		return nil
+	}
+}
+
+// Concatenated concatenates all payloads into a single buffer
+func (pr *PluginResponse) Concatenated() []byte {
+	switch pr.Type {
+	case PluginResponseTypeSingle:
+		result := make([]byte, len(pr.Single))
+		copy(result, pr.Single)
+		return result
+	case PluginResponseTypeStreaming:
+		totalLen := 0
+		for _, chunk := range pr.Streaming {
+			totalLen += len(chunk.Payload)
+		}
+		result := make([]byte, 0, totalLen)
+		for _, chunk := range pr.Streaming {
+			result = append(result, chunk.Payload...)
+		}
+		return result
+	default:
+		return nil
+	}
+}
+
+// Streams returns the response's streams in the order they started
+func (pr *PluginResponse) Streams() []*ResponseStream {
+	return pr.streams
+}
+
+// Stream returns the first stream whose media URN pattern accepts, or nil
+func (pr *PluginResponse) Stream(pattern string) (*ResponseStream, error) {
+	patternUrn, err := urn.NewMediaUrnFromString(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid media URN pattern '%s': %w", pattern, err)
+	}
+	for _, stream := range pr.streams {
+		streamUrn, err := urn.NewMediaUrnFromString(stream.MediaUrn)
+		if err == nil && patternUrn.Accepts(streamUrn) {
+			return stream, nil
+		}
+	}
+	return nil, nil
+}
+
+// primary returns the stream Bytes, Text and DecodeCBOR read: the first one
+func (pr *PluginResponse) primary() (*ResponseStream, error) {
+	if len(pr.streams) == 0 {
+		return nil, fmt.Errorf("response has no stream")
+	}
+	return pr.streams[0], nil
+}
+
+// Bytes returns the contents of the response's first stream, see ResponseStream.Bytes
+func (pr *PluginResponse) Bytes() ([]byte, error) {
+	stream, err := pr.primary()
+	if err != nil {
+		return nil, err
+	}
+	return stream.Bytes()
+}
+
+// Text returns the contents of the response's first stream as text
+func (pr *PluginResponse) Text() (string, error) {
+	stream, err := pr.primary()
+	if err != nil {
+		return "", err
+	}
+	return stream.Text()
+}
+
+// DecodeCBOR decodes the response's first stream into v, see ResponseStream.DecodeCBOR
+func (pr *PluginResponse) DecodeCBOR(v interface{}) error {
+	stream, err := pr.primary()
+	if err != nil {
+		return err
+	}
+	return stream.DecodeCBOR(v)
+}
+
+// values decodes the CBOR value of each chunk
+func (s *ResponseStream) values() ([]interface{}, error) {
+	values := make([]interface{}, len(s.Chunks))
+	for i, chunk := range s.Chunks {
+		if err := cborlib.Unmarshal(chunk.Payload, &values[i]); err != nil {
+			return nil, fmt.Errorf("stream %s: CHUNK %d is not valid CBOR: %w", s.StreamId, i, err)
+		}
+	}
+	return values, nil
+}
+
+// Bytes returns the stream's contents: the byte or text strings its chunks carry,
+// concatenated. A stream of other values, e.g. maps, has no byte contents.
+func (s *ResponseStream) Bytes() ([]byte, error) {
+	contents := make([]byte, 0)
+	for i, chunk := range s.Chunks {
+		content, err := cborStringContent(chunk.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("stream %s: CHUNK %d: %w", s.StreamId, i, err)
+		}
+		contents = append(contents, content...)
+	}
+	return contents, nil
+}
+
+// Text returns the stream's contents as text, failing on invalid UTF-8
+func (s *ResponseStream) Text() (string, error) {
+	contents, err := s.Bytes()
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(contents) {
+		return "", fmt.Errorf("stream %s is not valid UTF-8", s.StreamId)
+	}
+	return string(contents), nil
+}
+
+// DecodeCBOR decodes the value the stream carries into v. A value the sender split
+// across chunks is joined first: byte and text chunks are concatenated, and other
+// chunks, e.g. elements sent with EmitStreamElements, are collected into an array.
+// Concatenated bytes that don't decode into v are decoded as the CBOR document they
+// hold, as a value larger than a chunk is sent.
+func (s *ResponseStream) DecodeCBOR(v interface{}) error {
+	values, err := s.values()
+	if err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		return fmt.Errorf("stream %s is empty", s.StreamId)
+	}
+	joined := joinChunkValues(values)
+	encoded, err := cborlib.Marshal(joined)
+	if err != nil {
+		return err
+	}
+	err = cborlib.Unmarshal(encoded, v)
+	if data, isBytes := joined.([]byte); err != nil && isBytes && cborlib.Wellformed(data) == nil {
+		return cborlib.Unmarshal(data, v)
+	}
+	return err
+}
+
+// CollectPluginResponse assembles a response from its frames, up to END or the channel
+// closing. Assembly enforces the stream invariants: each CHUNK's checksum, a seq that
+// increases through the response (frames without one, 0, aren't checked), chunk_index
+// and offset continuing the stream, no CHUNK after one marked eof, the len declared on
+// STREAM_START or the first CHUNK matching the bytes received by eof and STREAM_END,
+// and STREAM_END's chunk_count. An ERR frame is returned as
+// *PeerError.
+func CollectPluginResponse(frames <-chan Frame) (*PluginResponse, error) {
+	return CollectPluginResponseContext(context.Background(), frames)
+}
+
+// CollectPluginResponseContext is CollectPluginResponse returning ctx.Err() once ctx is
+// done
+func CollectPluginResponseContext(ctx context.Context, frames <-chan Frame) (*PluginResponse, error) {
+	response := &PluginResponse{Type: PluginResponseTypeStreaming, Streaming: []*ResponseChunk{}}
+	assembly := make(map[string]*streamAssembly)
+	var lastSeq uint64
+
+	for {
+		var frame Frame
+		var ok bool
+		select {
+		case frame, ok = <-frames:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if !ok || frame.FrameType == FrameTypeEnd {
+			if len(assembly) > 0 {
+				return nil, fmt.Errorf("response ended with %d unterminated streams", len(assembly))
+			}
+			return response, nil
+		}
+		if frame.Seq != 0 {
+			if frame.Seq <= lastSeq {
+				return nil, fmt.Errorf("%v frame seq %d does not follow seq %d", frame.FrameType, frame.Seq, lastSeq)
+			}
+			lastSeq = frame.Seq
+		}
+
+		switch frame.FrameType {
+		case FrameTypeStreamStart:
+			if frame.StreamId == nil || frame.MediaUrn == nil {
+				return nil, fmt.Errorf("STREAM_START without stream_id or media_urn")
+			}
+			if _, exists := assembly[*frame.StreamId]; exists {
+				return nil, fmt.Errorf("stream %s started twice", *frame.StreamId)
+			}
+			stream := &ResponseStream{StreamId: *frame.StreamId, MediaUrn: *frame.MediaUrn}
+			assembly[stream.StreamId] = &streamAssembly{stream: stream, declared: frame.Len}
+			response.streams = append(response.streams, stream)
+
+		case FrameTypeChunk:
+			if frame.StreamId == nil {
+				return nil, fmt.Errorf("CHUNK without stream_id")
+			}
+			state, exists := assembly[*frame.StreamId]
+			if !exists {
+				return nil, fmt.Errorf("CHUNK for unknown stream %s", *frame.StreamId)
+			}
+			chunk, err := state.add(&frame)
+			if err != nil {
+				return nil, err
+			}
+			response.Streaming = append(response.Streaming, chunk)
+
+		case FrameTypeStreamEnd:
+			if frame.StreamId == nil {
+				return nil, fmt.Errorf("STREAM_END without stream_id")
+			}
+			state, exists := assembly[*frame.StreamId]
+			if !exists {
+				return nil, fmt.Errorf("STREAM_END for unknown stream %s", *frame.StreamId)
+			}
+			if received := uint64(len(state.stream.Chunks)); frame.ChunkCount != nil && *frame.ChunkCount != received {
+				return nil, fmt.Errorf("stream %s: STREAM_END chunk_count %d but received %d chunks",
+					state.stream.StreamId, *frame.ChunkCount, received)
+			}
+			if state.declared != nil && state.received != *state.declared {
+				return nil, &LengthError{StreamId: state.stream.StreamId, Declared: *state.declared, Received: state.received, Ended: true}
+			}
+			delete(assembly, *frame.StreamId)
+
+		case FrameTypeErr:
+			return nil, ErrorFromFrame(&frame)
+		}
+	}
+}
+
+// streamAssembly is the state of a stream being assembled
+type streamAssembly struct {
+	stream   *ResponseStream
+	declared *uint64 // len from STREAM_START or the first CHUNK
+	received uint64  // Payload bytes so far
+	eof      bool
+}
+
+// add validates a CHUNK against the stream so far and appends it
+func (a *streamAssembly) add(frame *Frame) (*ResponseChunk, error) {
+	id := a.stream.StreamId
+	if err := VerifyChunkChecksum(frame); err != nil {
+		return nil, fmt.Errorf("corrupted data: %w", err)
+	}
+	if a.eof {
+		return nil, fmt.Errorf("stream %s: CHUNK after the chunk marked eof", id)
+	}
+	if index := uint64(len(a.stream.Chunks)); frame.ChunkIndex != nil && *frame.ChunkIndex != index {
+		return nil, fmt.Errorf("stream %s: chunk_index %d where %d was expected", id, *frame.ChunkIndex, index)
+	}
+	if frame.Offset != nil && *frame.Offset != a.received {
+		return nil, fmt.Errorf("stream %s: offset %d where %d was expected", id, *frame.Offset, a.received)
+	}
+	if len(a.stream.Chunks) == 0 && a.declared == nil {
+		a.declared = frame.Len
+	}
+	a.received += uint64(len(frame.Payload))
+	if a.declared != nil && a.received > *a.declared {
+		return nil, &LengthError{StreamId: id, Declared: *a.declared, Received: a.received}
+	}
+	chunk := &ResponseChunk{
+		Payload:  frame.Payload,
+		Seq:      frame.Seq,
+		Offset:   frame.Offset,
+		Len:      frame.Len,
+		IsEof:    frame.IsEof(),
+		StreamId: id,
+	}
+	if chunk.IsEof {
+		a.eof = true
+		if a.declared != nil && a.received != *a.declared {
+			return nil, fmt.Errorf("stream %s: eof after %d bytes, short of its len %d", id, a.received, *a.declared)
+		}
+	}
+	a.stream.Chunks = append(a.stream.Chunks, chunk)
+	return chunk, nil
+}
@@ -0,0 +1,156 @@
+package bifaci
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+)
+
+// responseChunk builds a CHUNK of a CBOR-encoded value
+func responseChunk(id MessageId, streamId string, index uint64, value interface{}) *Frame {
+	payload, _ := cborlib.Marshal(value)
+	return NewChunk(id, streamId, 0, payload, index, ComputeChecksum(payload))
+}
+
+// sequenced returns frames on a closed channel, with seq assigned as a writer would
+func sequenced(frames ...*Frame) <-chan Frame {
+	assigner := NewSeqAssigner()
+	ch := make(chan Frame, len(frames))
+	for _, frame := range frames {
+		assigner.Assign(frame)
+		ch <- *frame
+	}
+	close(ch)
+	return ch
+}
+
+func TestCollectPluginResponse(t *testing.T) {
+	id := NewMessageIdRandom()
+	response, err := CollectPluginResponse(sequenced(
+		NewStreamStart(id, "result", "media:textable"),
+		NewStreamStart(id, "result-1", "media:json;record"),
+		responseChunk(id, "result", 0, "hello "),
+		responseChunk(id, "result-1", 0, map[string]interface{}{"pages": 3}),
+		responseChunk(id, "result", 1, "world"),
+		NewStreamEnd(id, "result", 2),
+		NewStreamEnd(id, "result-1", 1),
+		NewEnd(id, nil),
+	))
+	if err != nil {
+		t.Fatalf("CollectPluginResponse failed: %v", err)
+	}
+	if len(response.Streams()) != 2 || len(response.Streaming) != 3 {
+		t.Fatalf("Expected 2 streams and 3 chunks, got %d and %d", len(response.Streams()), len(response.Streaming))
+	}
+	if response.Streaming[1].StreamId != "result-1" {
+		t.Errorf("Streaming must keep arrival order, got %s second", response.Streaming[1].StreamId)
+	}
+
+	// The first stream is the primary one
+	if text, err := response.Text(); err != nil || text != "hello world" {
+		t.Errorf("Text: got %q (%v)", text, err)
+	}
+	var greeting string
+	if err := response.DecodeCBOR(&greeting); err != nil || greeting != "hello world" {
+		t.Errorf("DecodeCBOR of split text: got %q (%v)", greeting, err)
+	}
+
+	record, err := response.Stream("media:json")
+	if err != nil || record == nil {
+		t.Fatalf("Stream(media:json): got %v (%v)", record, err)
+	}
+	var pages struct{ Pages int }
+	if err := record.DecodeCBOR(&pages); err != nil || pages.Pages != 3 {
+		t.Errorf("DecodeCBOR of a map: got %+v (%v)", pages, err)
+	}
+	if _, err := record.Bytes(); err == nil {
+		t.Error("Bytes of a map stream must fail")
+	}
+	if missing, err := response.Stream("media:image"); missing != nil || err != nil {
+		t.Errorf("Expected no image stream, got %v (%v)", missing, err)
+	}
+}
+
+// Elements emitted with EmitStreamElements arrive one per chunk and decode back into
+// the array
+func TestCollectPluginResponseFromRuntime(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		return EmitStreamElements(emitter, Elements([]int{1, 2, 3}))
+	})
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	defer stop()
+
+	reqId := NewMessageIdRandom()
+	writeTestRequest(t, writer, reqId, testCancelCap, []byte{0x40})
+	frames := make(chan Frame, 16)
+	for _, frame := range readUntilTerminal(t, reader, reqId) {
+		frames <- *frame
+	}
+	close(frames)
+
+	response, err := CollectPluginResponse(frames)
+	if err != nil {
+		t.Fatalf("CollectPluginResponse failed: %v", err)
+	}
+	var values []int
+	if err := response.DecodeCBOR(&values); err != nil || len(values) != 3 || values[2] != 3 {
+		t.Errorf("Expected [1 2 3], got %v (%v)", values, err)
+	}
+}
+
+func TestCollectPluginResponseInvariants(t *testing.T) {
+	id := NewMessageIdRandom()
+	start := func() *Frame { return NewStreamStart(id, "s", "media:") }
+	chunk := func(index uint64) *Frame { return responseChunk(id, "s", index, []byte("abcd")) }
+	withOffset := func(frame *Frame, offset uint64) *Frame { frame.Offset = &offset; return frame }
+	withLen := func(frame *Frame, length uint64, eof bool) *Frame {
+		frame.Len = &length
+		frame.Eof = &eof
+		return frame
+	}
+	corrupted := chunk(0)
+	*corrupted.Checksum++
+
+	for _, tc := range []struct {
+		name   string
+		frames <-chan Frame
+		want   string
+	}{
+		{"checksum", sequenced(start(), corrupted, NewEnd(id, nil)), "corrupted data"},
+		{"chunk_index", sequenced(start(), chunk(0), chunk(2), NewEnd(id, nil)), "chunk_index 2"},
+		{"offset", sequenced(start(), withOffset(chunk(0), 0), withOffset(chunk(1), 3), NewEnd(id, nil)), "offset 3"},
+		{"after eof", sequenced(start(), withLen(chunk(0), 5, true), chunk(1), NewEnd(id, nil)), "eof"},
+		{"short of len", sequenced(start(), withLen(chunk(0), 100, true), NewEnd(id, nil)), "short of its len"},
+		{"chunk_count", sequenced(start(), chunk(0), NewStreamEnd(id, "s", 2), NewEnd(id, nil)), "chunk_count 2"},
+		{"unterminated", sequenced(start(), chunk(0), NewEnd(id, nil)), "unterminated"},
+		{"unknown stream", sequenced(chunk(0)), "unknown stream"},
+	} {
+		if _, err := CollectPluginResponse(tc.frames); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", tc.name, tc.want, err)
+		}
+	}
+
+	// seq must increase through the response
+	frames := make(chan Frame, 3)
+	first, second := start(), chunk(0)
+	first.Seq, second.Seq = 5, 3
+	frames <- *first
+	frames <- *second
+	close(frames)
+	if _, err := CollectPluginResponse(frames); err == nil || !strings.Contains(err.Error(), "seq 3") {
+		t.Errorf("Expected a seq error, got %v", err)
+	}
+
+	var peerErr *PeerError
+	if _, err := CollectPluginResponse(sequenced(NewErr(id, "FAILED", "no"))); !errors.As(err, &peerErr) || peerErr.Code != "FAILED" {
+		t.Errorf("Expected a *PeerError, got %v", err)
+	}
+}
@@ -1,18 +1,25 @@
 package bifaci
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	cborlib "github.com/fxamacker/cbor/v2"
 
 	"github.com/machinefabric/capdag-go/cap"
+	"github.com/machinefabric/capdag-go/standard"
 	"github.com/machinefabric/capdag-go/urn"
 	taggedurn "github.com/machinefabric/tagged-urn-go"
 )
@@ -30,12 +37,40 @@ const (
 type StreamEmitter interface {
 	// EmitCbor emits a CBOR value as output.
 	// The value is CBOR-encoded once and sent as raw CBOR bytes in CHUNK frames.
+	// Any other value, including arrays and maps, is sent as one complete CBOR value;
+	// one larger than a CHUNK is sent as byte strings of its encoding.
 	EmitCbor(value interface{}) error
 	// EmitLog emits a log message at the given level.
 	// Sends a LOG frame (side-channel, does not affect response stream).
 	EmitLog(level, message string)
 }
 
+// ElementSeq yields the elements EmitStreamElements sends, stopping when
+// yield returns false
+type ElementSeq func(yield func(element interface{}) bool)
+
+// Elements returns an ElementSeq of the elements of a slice
+func Elements[T any](elements []T) ElementSeq {
+	return func(yield func(element interface{}) bool) {
+		for _, element := range elements {
+			if !yield(element) {
+				return
+			}
+		}
+	}
+}
+
+// OutputStream is an additional response stream opened with OpenStream.
+// It has its own STREAM_START/CHUNK/STREAM_END frames and chunk counting.
+type OutputStream interface {
+	// StreamId returns the stream ID announced in STREAM_START.
+	StreamId() string
+	// EmitCbor emits a CBOR value on this stream, chunked like StreamEmitter.EmitCbor.
+	EmitCbor(value interface{}) error
+	// Close sends STREAM_END for this stream. Closing twice is a no-op.
+	Close() error
+}
+
 // PeerInvoker allows handlers to invoke caps on the peer (host).
 // Spawns a goroutine that receives response frames and forwards them to a channel.
 // Returns a channel that yields bare CBOR Frame objects (STREAM_START, CHUNK,
@@ -45,6 +80,25 @@ type PeerInvoker interface {
 	Invoke(capUrn string, arguments []cap.CapArgumentValue) (<-chan Frame, error)
 }
 
+// ErrRequestCancelled is returned by StreamEmitter.EmitCbor once the host has
+// cancelled the request the emitter belongs to.
+var ErrRequestCancelled = errors.New("request cancelled")
+
+// ErrResponseEnded is returned by emitters once the response has ended, e.g. after
+// Abort
+var ErrResponseEnded = errors.New("response already ended")
+
+// HandlerContext returns the context of the request an emitter belongs to.
+// The context is cancelled when the host sends CANCEL for the request, so
+// long-running handlers should watch ctx.Done() and return early.
+// Emitters not bound to a cancellable request (CLI mode, tests) yield context.Background().
+func HandlerContext(emitter StreamEmitter) context.Context {
+	if c, ok := emitter.(interface{ Context() context.Context }); ok {
+		return c.Context()
+	}
+	return context.Background()
+}
+
 // StreamChunk removed - handlers now receive bare CBOR Frame objects directly
 
 // HandlerFunc is the function signature for cap handlers.
@@ -54,11 +108,48 @@ type HandlerFunc func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvok
 
 // PluginRuntime handles all I/O for plugin binaries
 type PluginRuntime struct {
-	handlers     map[string]HandlerFunc
-	manifestData []byte
-	manifest     *CapManifest
-	limits       Limits
-	mu           sync.RWMutex
+	handlers         map[string]HandlerFunc
+	patterns         []patternRoute // Registration order (see RegisterPattern)
+	maxRequestMemory int            // Unconsumed input kept in memory per request before spilling
+	spillDir         string         // Directory for spill files ("" = os.TempDir())
+	manifestData     []byte
+	manifest         *CapManifest
+	limits           Limits
+	logger           Logger // Internal diagnostics (see SetLogger)
+	tracer           Tracer // Optional span hooks (see SetTracer)
+	metrics          MetricsCollector
+	limiter          *requestLimiter          // nil = unlimited (see SetMaxConcurrentRequests)
+	peers            *peerLimiter             // Pending peer requests and their limits (see SetPeerLimits)
+	defaultTimeout   time.Duration            // Handler timeout when none is registered for the cap (0 = none)
+	handlerTimeouts  map[string]time.Duration // Registered cap URN → handler timeout
+	middleware       []Middleware             // Wraps every handler, outermost first (see Use)
+	cliCommands      map[string]cliCommand    // Runtime-provided CLI subcommands (see AddCLICommand)
+	heartbeatTimeout time.Duration            // Host silence before shutting down (0 = never, see SetHeartbeatTimeout)
+	recorder         *Recorder                // Tees exchanged frames into a recording (see SetRecorder)
+	clock            Clock                    // Times the heartbeat watchdog (see SetClock)
+	onProgress       ProgressFunc             // Told of input stream progress (see OnProgress)
+	minChunk         int                      // Smallest adaptive CHUNK size (0 = fixed max_chunk, see SetAdaptiveChunking)
+	chunkLatency     time.Duration            // CHUNK write latency adaptive chunking aims at
+	validateInput    bool                     // Check arguments against their media spec schemas (see SetInputValidation)
+	validateOutput   bool                     // Check emitted values against the output spec (see WithOutputValidation)
+	coercions        *CoercionRegistry        // Converts arguments no cap argument accepts (nil = off, see SetCoercionRegistry)
+	coercionByCap    map[string]bool          // Registered cap URN → coercion on/off (see SetCoercion)
+	conns            connSet                  // Connections manifest updates and NOTIFY are sent on (see AddCap)
+	stdoutGuard      *stdoutGuard             // Forwards stray stdout to requests, nil if not guarded (see RunGuard)
+	writeTimeout     time.Duration            // Frame write time before the runtime gives up (0 = never, see SetWriteTimeout)
+	fileExpansion    FileExpansion            // How file-path-array patterns expand to files (see SetFileExpansion)
+	fileAccess       FileAccessPolicy         // Files file-path arguments may read (see SetFileAccessPolicy)
+	configPath       string                   // Config file CLI mode reads config sources from (see SetConfigFile)
+	configFile       *configFile              // Config file of the current CLI invocation, nil if none (see SetConfigFile)
+	wireCodec        WireCodec                // Codec of the CBOR-mode connection ("" = from WireEnvVar, see SetWireCodec)
+	lifecycle        lifecycle                // Start and stop hooks (see OnStart)
+	dedup            *requestDedup            // Outcomes of recently completed requests, nil = off (see SetRequestDedup)
+	strayFrames      StrayFramePolicy         // How input frames for unknown requests are handled (see SetStrayFramePolicy)
+	strictInterleave bool                     // Input frames of a request must be numbered gap-free (see SetStrictInterleave)
+	health           *healthState             // Reported by the health cap (see AddHealthCheck)
+	jobs             *jobRunner               // Async jobs, nil until RegisterAsync or SetJobStore
+	manifestMu       sync.Mutex               // Serializes manifest updates (see AddCap)
+	mu               sync.RWMutex
 }
 
 // NewPluginRuntime creates a new plugin runtime with the required manifest JSON
@@ -68,9 +159,14 @@ func NewPluginRuntime(manifestJSON []byte) (*PluginRuntime, error) {
 	parseErr := json.Unmarshal(manifestJSON, &manifest)
 
 	runtime := &PluginRuntime{
-		handlers:     make(map[string]HandlerFunc),
-		manifestData: manifestJSON,
-		limits:       DefaultLimits(),
+		handlers:         make(map[string]HandlerFunc),
+		maxRequestMemory: DefaultMaxRequestMemory,
+		manifestData:     manifestJSON,
+		limits:           DefaultLimits(),
+		logger:           defaultLogger(),
+		clock:            wallClock{},
+		peers:            newPeerLimiter(),
+		health:           newHealthState(),
 	}
 
 	if parseErr == nil {
@@ -100,20 +196,25 @@ func NewPluginRuntimeWithManifest(manifest *CapManifest) (*PluginRuntime, error)
 	if !hasIdentity {
 		return nil, fmt.Errorf(
 			"manifest validation failed - plugin MUST declare CAP_IDENTITY (cap:). " +
-			"All plugins must explicitly declare capabilities, no implicit fallbacks allowed",
+				"All plugins must explicitly declare capabilities, no implicit fallbacks allowed",
 		)
 	}
 
-	manifestData, err := json.Marshal(manifest)
+	manifestData, err := manifest.CanonicalJSON()
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal manifest: %w", err)
 	}
 
 	runtime := &PluginRuntime{
-		handlers:     make(map[string]HandlerFunc),
-		manifestData: manifestData,
-		manifest:     manifest,
-		limits:       DefaultLimits(),
+		handlers:         make(map[string]HandlerFunc),
+		maxRequestMemory: DefaultMaxRequestMemory,
+		manifestData:     manifestData,
+		manifest:         manifest,
+		limits:           DefaultLimits(),
+		logger:           defaultLogger(),
+		clock:            wallClock{},
+		peers:            newPeerLimiter(),
+		health:           newHealthState(),
 	}
 
 	// Auto-register identity handler if not already registered
@@ -122,83 +223,119 @@ func NewPluginRuntimeWithManifest(manifest *CapManifest) (*PluginRuntime, error)
 	return runtime, nil
 }
 
-// autoRegisterIdentity registers a default identity handler if none exists
+// handlerErrorCode maps an error returned by a handler to the ERR frame code sent to the host.
+func handlerErrorCode(err error) string {
+	var capErr *CapError
+	if errors.As(err, &capErr) {
+		return string(capErr.Code)
+	}
+	var decodeErr *ArgumentDecodeError
+	if errors.As(err, &decodeErr) {
+		return "INVALID_ARGUMENT"
+	}
+	var coercionErr *CoercionError
+	if errors.As(err, &coercionErr) {
+		return "INVALID_ARGUMENT"
+	}
+	var sequenceErr *SequenceError
+	if errors.As(err, &sequenceErr) {
+		return "PROTOCOL_ERROR"
+	}
+	var lengthErr *LengthError
+	if errors.As(err, &lengthErr) {
+		return "PROTOCOL_ERROR"
+	}
+	var validationErr *InputValidationError
+	if errors.As(err, &validationErr) {
+		return "VALIDATION_ERROR"
+	}
+	var outputErr *OutputValidationError
+	if errors.As(err, &outputErr) {
+		return "INVALID_OUTPUT"
+	}
+	var envErr *EnvArgError
+	if errors.As(err, &envErr) {
+		return "INVALID_ARGUMENT"
+	}
+	var exitErr *ExitCodeError
+	if errors.As(err, &exitErr) {
+		return "PROCESS_FAILED"
+	}
+	var startErr *StartError
+	if errors.As(err, &startErr) {
+		return "START_FAILED"
+	}
+	var resourceErr *ResourceError
+	if errors.As(err, &resourceErr) {
+		return "RESOURCE_EXHAUSTED"
+	}
+	var bindErr *BindError
+	if errors.As(err, &bindErr) {
+		return "INVALID_ARGUMENT"
+	}
+	var accessErr *FileAccessError
+	if errors.As(err, &accessErr) {
+		return "PERMISSION_DENIED"
+	}
+	return "HANDLER_ERROR"
+}
+
+// autoRegisterIdentity registers the default identity handler, which answers with the
+// manifest, if none exists (see SetIdentityHandler)
 func (pr *PluginRuntime) autoRegisterIdentity() {
 	pr.mu.Lock()
 	defer pr.mu.Unlock()
 
 	// Check if identity handler already registered
-	if _, exists := pr.handlers["cap:"]; !exists {
-		// Register default identity handler (echo - returns input as-is)
-		pr.handlers["cap:"] = func(input <-chan Frame, output StreamEmitter, peer PeerInvoker) error {
-			// Collect all incoming frames
-			var chunks []interface{}
-			for frame := range input {
-				switch frame.FrameType {
-				case FrameTypeChunk:
-					// Verify checksum (protocol v2 integrity check)
-					if err := VerifyChunkChecksum(&frame); err != nil {
-						return fmt.Errorf("corrupted data: %w", err)
-					}
-					if frame.Payload != nil {
-						// Decode each chunk as CBOR
-						var value interface{}
-						if err := cborlib.Unmarshal(frame.Payload, &value); err != nil {
-							return err
-						}
-						chunks = append(chunks, value)
-					}
-				case FrameTypeEnd:
-					goto done
-				}
-			}
-		done:
-			// Echo back - emit single value or concatenated chunks
-			if len(chunks) == 0 {
-				return output.EmitCbor([]byte{})
-			} else if len(chunks) == 1 {
-				return output.EmitCbor(chunks[0])
-			} else {
-				// Multiple chunks - try to concatenate if bytes/string, otherwise array
-				switch chunks[0].(type) {
-				case []byte:
-					var result []byte
-					for _, chunk := range chunks {
-						if b, ok := chunk.([]byte); ok {
-							result = append(result, b...)
-						}
-					}
-					return output.EmitCbor(result)
-				case string:
-					var result string
-					for _, chunk := range chunks {
-						if s, ok := chunk.(string); ok {
-							result += s
-						}
-					}
-					return output.EmitCbor(result)
-				default:
-					return output.EmitCbor(chunks)
-				}
-			}
-		}
+	if _, exists := pr.handlers[standard.CapIdentity]; !exists {
+		pr.handlers[standard.CapIdentity] = pr.manifestIdentityHandler
 	}
 }
 
-// Register registers a handler for a cap URN
+// Register registers a handler for a cap URN.
+//
+// The handler is invoked as soon as the REQ arrives and receives the request's
+// STREAM_START/CHUNK/STREAM_END frames live, so it can emit output before its input is
+// complete (e.g. incremental transcription). The frame channel is closed after END.
 func (pr *PluginRuntime) Register(capUrn string, handler HandlerFunc) {
 	pr.mu.Lock()
 	defer pr.mu.Unlock()
 	pr.handlers[capUrn] = handler
 }
 
+// SetMaxRequestMemory sets how many payload bytes of not yet consumed input are kept in
+// memory per request before further input frames spill to a temporary file.
+// 0 disables spilling. Takes effect for connections served afterwards.
+func (pr *PluginRuntime) SetMaxRequestMemory(bytes int) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.maxRequestMemory = bytes
+}
+
+// SetSpillDir sets the directory for input spill files (default: os.TempDir()).
+func (pr *PluginRuntime) SetSpillDir(dir string) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.spillDir = dir
+}
+
 // Request bundles the handler's input frames, output emitter, and peer invoker into a
 // single object. Struct-based handlers (CapHandler) receive a *Request instead of the
 // three separate HandlerFunc parameters. Mirrors the Rust capdag Request type.
 type Request struct {
+	ctx     context.Context
 	frames  <-chan Frame
 	emitter StreamEmitter
 	peer    PeerInvoker
+	args    *ArgSet // Collected arguments (see Args)
+}
+
+// Context returns the request context. It is cancelled when the host sends CANCEL.
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
 }
 
 // Frames returns the input frame channel. The handler owns the channel and must consume
@@ -222,7 +359,7 @@ type CapOp interface {
 // Bridges the struct-based CapOp interface to the function-based HandlerFunc.
 func (pr *PluginRuntime) RegisterOp(capUrn string, op CapOp) {
 	pr.Register(capUrn, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
-		return op.Perform(&Request{frames: frames, emitter: emitter, peer: peer})
+		return op.Perform(&Request{ctx: HandlerContext(emitter), frames: frames, emitter: emitter, peer: peer})
 	})
 }
 
@@ -235,22 +372,47 @@ func (pr *PluginRuntime) RegisterOp(capUrn string, op CapOp) {
 //
 // Selects the closest-specificity match to the request (not max-specificity),
 // to prevent identity handlers from stealing routes from specific handlers.
+// Pattern routes take precedence over this matching (see RegisterPattern); nil is
+// returned for a request they conflict on.
+// The returned handler is wrapped in the middleware added with Use.
 func (pr *PluginRuntime) FindHandler(capUrn string) HandlerFunc {
+	_, handler, _ := pr.findHandler(capUrn)
+	return handler
+}
+
+// findHandler is FindHandler that also returns the URN or pattern the handler was
+// registered under, and a *RouteConflictError for a request pattern routes tie on
+func (pr *PluginRuntime) findHandler(capUrn string) (string, HandlerFunc, error) {
 	pr.mu.RLock()
 	defer pr.mu.RUnlock()
 
 	// First try exact match
 	if handler, ok := pr.handlers[capUrn]; ok {
-		return handler
+		return capUrn, pr.wrapHandler(capUrn, handler), nil
 	}
 
 	// Then try pattern matching via CapUrn
 	requestUrn, err := urn.NewCapUrnFromString(capUrn)
 	if err != nil {
-		return nil
+		return "", nil, nil
+	}
+
+	// The runtime answers the health cap unless a handler is registered for it
+	if isHealthRequest(requestUrn) {
+		return standard.CapHealth, pr.wrapHandler(standard.CapHealth, pr.healthHandler), nil
+	}
+
+	// Explicit pattern routes before the implicit matching of registered URNs
+	route, err := pr.matchPattern(capUrn, requestUrn)
+	if err != nil {
+		return "", nil, err
+	}
+	if route != nil {
+		return route.pattern, pr.wrapHandler(route.pattern, route.handler), nil
 	}
 
 	requestSpecificity := requestUrn.Specificity()
+	var bestPattern string
 	var bestHandler HandlerFunc
 	bestDistance := -1
 
@@ -267,87 +429,512 @@ func (pr *PluginRuntime) FindHandler(capUrn string) HandlerFunc {
 				distance = -distance
 			}
 			if bestHandler == nil || distance < bestDistance {
+				bestPattern = pattern
 				bestHandler = handler
 				bestDistance = distance
 			}
 		}
 	}
 
-	return bestHandler
+	if bestHandler == nil {
+		return "", nil, nil
+	}
+	return bestPattern, pr.wrapHandler(bestPattern, bestHandler), nil
+}
+
+// wrapHandler wraps the handler registered under pattern in the runtime's middleware,
+// env arguments, argument coercion, validation and deprecation warning. Caller holds pr.mu.
+func (pr *PluginRuntime) wrapHandler(pattern string, handler HandlerFunc) HandlerFunc {
+	return pr.applyMiddleware(pr.withDeprecationWarning(pattern, pr.withEnvArgs(pattern, pr.withCoercion(pattern, pr.withValidation(pattern, handler)))))
 }
 
-// Run runs the plugin runtime (automatic mode detection)
+// Run runs the plugin runtime (automatic mode detection). In CLI mode a failure is
+// written to stderr as an error document and returned as a *CLIError (see RunWithExit).
 func (pr *PluginRuntime) Run() error {
 	args := os.Args
 
 	// No CLI arguments at all → Plugin CBOR mode
 	if len(args) == 1 {
-		return pr.runCBORMode()
+		return pr.stopAfter(pr.runCBORMode())
 	}
 
 	// Any CLI arguments → CLI mode
-	return pr.runCLIMode(args)
+	return reportCLIError(os.Stderr, pr.stopAfter(pr.runCLIMode(args)))
 }
 
 // runCBORMode runs in Plugin CBOR mode - binary frame protocol via stdin/stdout
 func (pr *PluginRuntime) runCBORMode() error {
-	reader := NewFrameReader(os.Stdin)
-	rawWriter := NewFrameWriter(os.Stdout)
+	protocol, release, err := pr.RunGuard()
+	if err != nil {
+		pr.log().Warn("stdout not guarded - writes to it will corrupt the frame protocol", "error", err)
+		return pr.serveCBOR(os.Stdin, os.Stdout)
+	}
+	defer release()
+	return pr.serveCBOR(os.Stdin, protocol)
+}
+
+// RunConn serves the frame protocol over a single connection (Unix socket, TCP, ...)
+// until the host closes it. Use this when stdin/stdout are owned by a supervisor.
+// A TLS connection completes its TLS handshake first. The connection is closed on return.
+func (pr *PluginRuntime) RunConn(conn net.Conn) error {
+	return pr.stopAfter(pr.serveConn(conn))
+}
+
+// serveConn is RunConn without the OnStop hooks
+func (pr *PluginRuntime) serveConn(conn net.Conn) error {
+	defer conn.Close()
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsHandshake(tlsConn); err != nil {
+			return err
+		}
+	}
+	return pr.serveCBOR(conn, conn)
+}
+
+// RunListener accepts host connections and serves each one concurrently with RunConn.
+// Returns nil once the listener is closed; connections already accepted keep being
+// served until their hosts disconnect.
+func (pr *PluginRuntime) RunListener(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return pr.stopAfter(nil)
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				// Transient (e.g. out of file descriptors) - retry after a short pause
+				pr.log().Warn("accept failed - retrying", "error", err)
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return pr.stopAfter(fmt.Errorf("accept failed: %w", err))
+		}
+
+		go func() {
+			remote := conn.RemoteAddr()
+			if err := pr.serveConn(conn); err != nil {
+				pr.log().Error("connection failed", "remote", remote.String(), "error", err)
+			}
+		}()
+	}
+}
+
+// handlerRequest is what serveCBOR starts a handler with for an accepted REQ
+type handlerRequest struct {
+	id        MessageId
+	routingId *MessageId // Echoed on every response frame for host routing
+	seq       uint64     // Seq of the REQ, where a strict flow sequence starts
+	capUrn    string
+	trace     TraceContext // Parent of the handler span; the connection's if zero
+	env       map[string]string
+	session   *Session
+	timeout   time.Duration // Handler deadline, none if 0
+	policy    cap.ResourcePolicy
+	priority  int
+	handler   HandlerFunc
+}
+
+// serveCBOR runs the frame protocol (handshake + main event loop) over the given streams.
+// Returns nil when the input stream reaches EOF and all handlers have finished.
+func (pr *PluginRuntime) serveCBOR(in io.Reader, out io.Writer) error {
+	reader := NewFrameReader(in)
+	rawWriter := NewFrameWriter(out)
+
+	pr.mu.RLock()
+	metrics := pr.metrics
+	recorder := pr.recorder
+	codec := pr.wireCodec
+	pr.mu.RUnlock()
+	if codec == "" {
+		var err error
+		if codec, err = WireCodecFromEnv(); err != nil {
+			return err
+		}
+	}
+	reader.SetCodec(codec)
+	rawWriter.SetCodec(codec)
+	if metrics == nil {
+		metrics = nopMetrics{}
+	}
+	// Sizes include the 4-byte length prefix
+	reader.observe = func(frame *Frame, encoded []byte) {
+		metrics.FrameRead(frame.FrameType, 4+len(encoded))
+		recorder.record(RecordedIn, encoded)
+	}
+	rawWriter.observe = func(frame *Frame, encoded []byte) {
+		metrics.FrameWritten(frame.FrameType, 4+len(encoded))
+		recorder.record(RecordedOut, encoded)
+	}
 
 	// Perform handshake - send our manifest in the HELLO response
 	// Handshake is single-threaded so raw writer is safe here
-	negotiatedLimits, err := HandshakeAccept(reader, rawWriter, pr.manifestData)
+	pr.mu.RLock()
+	manifestData := pr.manifestData
+	var permissionPatterns []string
+	if pr.manifest != nil {
+		permissionPatterns = pr.manifest.Permissions
+	}
+	pr.mu.RUnlock()
+	// Peer invocations are checked against the manifest's permissions
+	permissions, err := parseCapPermissions(permissionPatterns)
+	if err != nil {
+		return err
+	}
+	// The plugin answers HELLO once its OnStart hooks succeeded
+	negotiatedLimits, helloFrame, err := handshakeAccept(reader, rawWriter, manifestData, func() error {
+		return pr.start(context.Background())
+	})
 	if err != nil {
 		return fmt.Errorf("handshake failed: %w", err)
 	}
+	// Parent for requests whose REQ carries no trace context of its own
+	connTrace, _ := helloFrame.TraceContext()
+	// Environment for arguments with an env source, which a REQ's own env extends
+	connEnv := helloFrame.Env()
 
 	reader.SetLimits(negotiatedLimits)
 	rawWriter.SetLimits(negotiatedLimits)
 
 	// Wrap writer for thread-safe concurrent access from handler goroutines
 	writer := newSyncFrameWriter(rawWriter)
+	pr.mu.RLock()
+	writeTimeout := pr.writeTimeout
+	pr.mu.RUnlock()
+	writer.setWriteTimeout(writeTimeout, out)
 
 	pr.mu.Lock()
 	pr.limits = negotiatedLimits
 	pr.mu.Unlock()
 
+	// Caps added or removed from now on are announced with MANIFEST_UPDATED
+	pr.attachConn(writer, manifestData)
+	defer pr.detachConn(writer)
+
+	// State kept across the requests of a session ends with SESSION_CLOSE or the connection
+	sessions := newSessionTable()
+	defer sessions.closeAll()
+
 	// Track pending peer requests (plugin invoking host caps)
 	// Key is MessageId.ToString() because MessageId contains []byte which is not comparable
 	pendingPeerRequests := &sync.Map{} // map[string]*pendingPeerRequest
+	// Requests the host never answered free their in-flight slots with the connection
+	defer pendingPeerRequests.Range(func(key, value interface{}) bool {
+		if _, ok := pendingPeerRequests.LoadAndDelete(key); ok {
+			value.(*pendingPeerRequest).finish(errors.New("connection closed"))
+		}
+		return true
+	})
+
+	pr.mu.RLock()
+	maxRequestMemory := pr.maxRequestMemory
+	spillDir := pr.spillDir
+	logger := pr.logger
+	tracer := pr.tracer
+	limiter := pr.limiter
+	peers := pr.peers
+	health := pr.health
+	dedup := pr.dedup
+	heartbeatTimeout := pr.heartbeatTimeout
+	clock := pr.clock
+	onProgress := pr.onProgress
+	minChunk, chunkLatency := pr.minChunk, pr.chunkLatency
+	guard := pr.stdoutGuard
+	strayFrames := pr.strayFrames
+	strictInterleave := pr.strictInterleave
+	pr.mu.RUnlock()
+	traceRoutes := os.Getenv(RouteTraceEnvVar) != ""
+
+	// Track incoming requests. The handler is started on REQ and its input frames are
+	// forwarded as they arrive (through a queue that spills to disk past maxRequestMemory).
+	// CANCEL aborts the handler, ACK returns flow-control credit to its emitter.
+	type activeRequest struct {
+		cancel context.CancelFunc
+		window *flowWindow // nil if no window was negotiated
+		queue  *frameQueue // Input frames not yet consumed by the handler
+
+		// Input validation state - only touched by the main loop
+		streams  map[string]bool    // stream_id → true while the stream is open
+		ended    bool               // True after END frame - any stream activity after is FATAL
+		sequence *flowSequence      // seq and chunk_index expected next
+		lengths  *streamLengths     // Bytes received per stream against its declared len
+		policy   cap.ResourcePolicy // Limits of the request's cap
+		received int64              // Input CHUNK payload bytes, against policy.MaxPayloadBytes
+
+		// Terminal error sent instead of CANCELLED when the runtime aborts the request
+		// itself (protocol violation, spill failure)
+		abortMu      sync.Mutex
+		abortCode    string
+		abortMessage string
+	}
+	activeRequests := &sync.Map{} // map[string]*activeRequest
+	recent := newRecentRequests(strayFrames.grace())
+
+	// Track active handler goroutines for cleanup
+	var activeHandlers sync.WaitGroup
+
+	// startHandler runs a handler for a request in its own goroutine
+	startHandler := func(req handlerRequest) *activeRequest {
+		// Create buffered channel for input frames
+		framesChan := make(chan Frame, 64)
+
+		// Request context - cancelled by a CANCEL frame from the host.
+		// Carries the handler span, which peer invocations use as their parent.
+		if req.trace.IsZero() {
+			req.trace = connTrace
+		}
+		spanCtx, span := startTracedSpan(context.Background(), tracer, "bifaci.handle "+req.capUrn, req.trace, map[string]string{
+			"bifaci.cap":    req.capUrn,
+			"bifaci.req_id": req.id.ToString(),
+		})
+		spanCtx = contextWithEnv(spanCtx, req.env)
+		spanCtx = contextWithSession(spanCtx, req.session)
+		// Bounded by the request deadline or handler timeout, if any.
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if req.timeout > 0 {
+			ctx, cancel = context.WithTimeout(spanCtx, req.timeout)
+		} else {
+			ctx, cancel = context.WithCancel(spanCtx)
+		}
+		window := newFlowWindow(negotiatedLimits.MaxWindow)
+		sequence := newFlowSequence()
+		if strictInterleave {
+			sequence = newStrictFlowSequence(req.seq)
+		}
+		active := &activeRequest{
+			cancel:   cancel,
+			window:   window,
+			queue:    newFrameQueue(maxRequestMemory, spillDir, logger),
+			streams:  make(map[string]bool),
+			sequence: sequence,
+			lengths:  newStreamLengths(onProgress),
+			policy:   req.policy,
+		}
+		activeRequests.Store(req.id.ToString(), active)
+
+		// The feeder owns the channel: it closes it after END or when the request is cancelled.
+		go func() {
+			defer close(framesChan)
+			active.queue.pump(ctx, func(f *Frame) bool {
+				select {
+				case framesChan <- *f:
+					return true
+				case <-ctx.Done():
+					return false
+				}
+			})
+		}()
+
+		activeHandlers.Add(1)
+		metrics.HandlerStarted(req.capUrn)
+		health.handlerStarted()
+		go func() {
+			defer activeHandlers.Done()
+			started := time.Now()
+			errCode := ""
+			defer func() {
+				metrics.HandlerFinished(req.capUrn, time.Since(started), errCode)
+				health.handlerFinished(req.capUrn, errCode)
+			}()
+			defer recent.touch(req.id.ToString())
+			defer activeRequests.Delete(req.id.ToString())
+			// Releases the frame feeder if the handler returned without draining its input
+			defer cancel()
+
+			// Past the cap's wall time the handler is cancelled and answered with
+			// RESOURCE_EXHAUSTED
+			if req.policy.MaxWallTimeMs > 0 {
+				wallTime := time.AfterFunc(time.Duration(req.policy.MaxWallTimeMs)*time.Millisecond, func() {
+					active.abortMu.Lock()
+					if active.abortCode == "" {
+						active.abortCode = string(ErrCodeResourceExhausted)
+						active.abortMessage = (&ResourceError{Resource: "wall_time", Limit: req.policy.MaxWallTimeMs}).Error()
+					}
+					active.abortMu.Unlock()
+					cancel()
+				})
+				defer wallTime.Stop()
+			}
+
+			// Generate unique stream ID for response
+			streamID := fmt.Sprintf("resp-%s", req.id.ToString()[:8])
+			mediaUrn := "media:" // Default output media URN
+
+			// Create emitter with stream multiplexing (preserve routing_id for response routing)
+			emitter := newThreadSafeEmitter(ctx, writer, req.id, req.routingId, streamID, mediaUrn, negotiatedLimits.MaxChunk, window, logger)
+			emitter.tuner = newChunkTuner(minChunk, negotiatedLimits.MaxChunk, chunkLatency)
+			emitter.priority = req.priority
+			emitter.maxOutput = req.policy.MaxOutputBytes
+			peerInvoker := newPeerInvokerImpl(ctx, writer, pendingPeerRequests, peers, permissions, negotiatedLimits.MaxChunk, tracer)
+			// Remembered before the END or ERR is written, so a retry the host sends once it
+			// read them finds the outcome
+			emitter.onEnd = func(code, message string) {
+				dedup.complete(req.id.ToString(), code, message)
+			}
 
-	// Track incoming requests that are being chunked
-	// Protocol v2: Stream tracking for incoming request streams
-	type pendingStream struct {
-		mediaUrn string
-		chunks   [][]byte
-		complete bool
+			// Invoke handler with frame channel once a slot under the concurrency limit is free.
+			// Waiting only fails if the request is cancelled, which is answered below.
+			// Stray stdout is logged to the request while its handler runs.
+			var err error
+			if limiter.acquire(ctx, req.priority) {
+				detachStdout := guard.attach(emitter)
+				err = req.handler(framesChan, emitter, peerInvoker)
+				detachStdout()
+				limiter.release()
+			}
+			if ctx.Err() != nil {
+				endSpan(span, ErrRequestCancelled)
+			} else {
+				endSpan(span, err)
+			}
+
+			// The handler ended the response itself with Abort
+			if code := emitter.abortCode(); code != "" {
+				errCode = code
+				return
+			}
+
+			// Cancelled by the host (or aborted by the runtime): the response is terminated
+			// with ERR, whatever the handler returned
+			if ctx.Err() != nil {
+				code, message := "CANCELLED", "Request cancelled by peer"
+				if ctx.Err() == context.DeadlineExceeded {
+					code, message = "TIMEOUT", fmt.Sprintf("Handler exceeded its deadline of %v", req.timeout)
+				}
+				active.abortMu.Lock()
+				if active.abortCode != "" {
+					code, message = active.abortCode, active.abortMessage
+				}
+				active.abortMu.Unlock()
+
+				errCode = code
+				if writeErr := emitter.fail(NewErr(req.id, code, message)); writeErr != nil {
+					logger.Error("failed to write ERR frame", "error", writeErr)
+				}
+				return
+			}
+
+			// The ERR tells the host how much of the output sent so far is valid
+			if err != nil {
+				errFrame := NewErrFromError(req.id, err)
+				errCode = errFrame.ErrorCode()
+				if writeErr := emitter.fail(errFrame); writeErr != nil {
+					logger.Error("failed to write ERR frame", "error", writeErr)
+				}
+				return
+			}
+
+			// Finalize sends STREAM_END + END frames
+			emitter.Finalize()
+		}()
+
+		return active
+	}
+
+	// abortRequest stops the input of a running request and cancels its handler;
+	// the handler goroutine answers with ERR code/message
+	abortRequest := func(idKey string, active *activeRequest, code, message string) {
+		activeRequests.Delete(idKey)
+		recent.touch(idKey)
+		active.queue.close()
+		active.abortMu.Lock()
+		active.abortCode = code
+		active.abortMessage = message
+		active.abortMu.Unlock()
+		active.cancel()
+	}
+
+	// inSequence checks that an input frame continues its request's flow, aborting the
+	// request with PROTOCOL_ERROR if frames were dropped or reordered or a stream doesn't
+	// match its declared len
+	inSequence := func(idKey string, active *activeRequest, frame *Frame) bool {
+		err := active.sequence.check(frame)
+		if err == nil {
+			err = active.lengths.check(frame)
+		}
+		if err != nil {
+			abortRequest(idKey, active, "PROTOCOL_ERROR", err.Error())
+			return false
+		}
+		return true
 	}
 
-	type streamEntry struct {
-		streamID string
-		stream   *pendingStream
+	// strayFrame handles an input frame for a request that isn't running (see
+	// StrayFramePolicy)
+	strayFrame := func(frame *Frame) {
+		idKey := frame.Id.ToString()
+		metrics.StrayFrame(frame.FrameType)
+		if recent.withinGrace(idKey) {
+			logger.Debug("late frame for completed request", "type", frame.FrameType.String(), "req_id", idKey)
+			return
+		}
+		logger.Warn("frame for unknown request", "type", frame.FrameType.String(), "req_id", idKey)
+		if strayFrames.Strict {
+			errFrame := NewErr(frame.Id, string(ErrCodeProtocol), fmt.Sprintf("%s for unknown request %s", frame.FrameType.String(), idKey))
+			errFrame.RoutingId = frame.RoutingId
+			if err := writer.WriteFrame(errFrame); err != nil {
+				logger.Error("failed to write ERR frame", "error", err)
+			}
+		}
 	}
 
-	type pendingIncomingRequest struct {
-		capUrn    string
-		handler   HandlerFunc
-		routingId *MessageId    // XID from the REQ frame (preserved for response routing)
-		streams   []streamEntry // Ordered list of streams
-		ended     bool          // True after END frame - any stream activity after is FATAL
+	// forward queues an input frame for the handler, aborting the request if it cannot be queued
+	forward := func(idKey string, active *activeRequest, frame *Frame) {
+		if err := active.queue.push(*frame); err != nil {
+			abortRequest(idKey, active, "HANDLER_ERROR", fmt.Sprintf("Failed to buffer request input: %v", err))
+		}
 	}
-	pendingIncoming := make(map[string]*pendingIncomingRequest)
-	pendingIncomingMu := &sync.Mutex{}
 
-	// Track active handler goroutines for cleanup
-	var activeHandlers sync.WaitGroup
+	// rejectReq answers a REQ that won't be handled with ERR code/message
+	rejectReq := func(frame *Frame, code, message string) {
+		errFrame := NewErr(frame.Id, code, message)
+		errFrame.RoutingId = frame.RoutingId
+		if err := writer.WriteFrame(errFrame); err != nil {
+			logger.Error("failed to write ERR frame", "error", err)
+		}
+	}
+
+	// With a heartbeat timeout, a silent host shuts the runtime down
+	readFrame := reader.ReadFrame
+	if heartbeatTimeout > 0 {
+		stopWatchdog := make(chan struct{})
+		defer close(stopWatchdog)
+		readFrame = watchdogRead(reader.ReadFrame, clock, heartbeatTimeout, stopWatchdog)
+	}
+	// With a write timeout, a host that stops reading shuts the runtime down
+	if writeTimeout > 0 {
+		stopStallWatch := make(chan struct{})
+		defer close(stopStallWatch)
+		go writer.watchStalls(clock, stopStallWatch)
+		readFrame = stallableRead(readFrame, writer.stalled, stopStallWatch)
+	}
 
 	// Main event loop
 	for {
-		frame, err := reader.ReadFrame()
+		frame, err := readFrame()
 		if err != nil {
 			if err == io.EOF {
 				break // stdin closed, exit cleanly
 			}
+			if err == ErrHostSilent {
+				logger.Warn("host silent, shutting down", "timeout", heartbeatTimeout)
+				activeRequests.Range(func(key, value interface{}) bool {
+					abortRequest(key.(string), value.(*activeRequest), "CANCELLED", "Host stopped sending frames")
+					return true
+				})
+				activeHandlers.Wait()
+				return ErrHostSilent
+			}
+			if err == ErrWriteStalled {
+				logger.Error("host stopped reading, shutting down", "timeout", writeTimeout)
+				activeRequests.Range(func(key, value interface{}) bool {
+					abortRequest(key.(string), value.(*activeRequest), "CANCELLED", "Host stopped reading frames")
+					return true
+				})
+				// Not waiting for the handlers: one may be stuck in the stalled write
+				return ErrWriteStalled
+			}
 			return fmt.Errorf("failed to read frame: %w", err)
 		}
 
@@ -357,50 +944,86 @@ func (pr *PluginRuntime) runCBORMode() error {
 			routingId := frame.RoutingId
 
 			if frame.Cap == nil || *frame.Cap == "" {
-				errFrame := NewErr(frame.Id, "INVALID_REQUEST", "Request missing cap URN")
-				errFrame.RoutingId = routingId
-				if writeErr := writer.WriteFrame(errFrame); writeErr != nil {
-					fmt.Fprintf(os.Stderr, "[PluginRuntime] Failed to write error: %v\n", writeErr)
-				}
+				rejectReq(frame, "INVALID_REQUEST", "Request missing cap URN")
 				continue
 			}
 
 			capUrn := *frame.Cap
 			rawPayload := frame.Payload
+			// Input frames the host sends after an ERR rejecting the REQ are late, not stray
+			recent.touch(frame.Id.ToString())
 
 			// Protocol v2: REQ must have empty payload - arguments come as streams
 			if len(rawPayload) > 0 {
-				errFrame := NewErr(frame.Id, "PROTOCOL_ERROR", "REQ frame must have empty payload - use STREAM_START for arguments")
-				errFrame.RoutingId = routingId
-				if err := writer.WriteFrame(errFrame); err != nil {
-					fmt.Fprintf(os.Stderr, "[PluginRuntime] Failed to write PROTOCOL_ERROR: %v\n", err)
-				}
+				rejectReq(frame, "PROTOCOL_ERROR", "REQ frame must have empty payload - use STREAM_START for arguments")
 				continue
 			}
 
+			// A retried REQ doesn't run the handler again (see SetRequestDedup)
+			if dedup != nil {
+				var reply *Frame
+				if outcome, ok := dedup.outcome(frame.Id.ToString()); ok {
+					reply = outcome.duplicateReply(frame.Id)
+				} else if _, running := activeRequests.Load(frame.Id.ToString()); running {
+					reply = NewErr(frame.Id, string(ErrCodeDuplicateRequest), fmt.Sprintf("request %s is already running", frame.Id.ToString()))
+				}
+				if reply != nil {
+					// Queued on the request's flow without waiting for the write, so a host
+					// that pipelines the retry's END before reading can't stall this loop
+					reply.RoutingId = routingId
+					activeHandlers.Add(1)
+					go func() {
+						defer activeHandlers.Done()
+						if writeErr := writer.WriteFrame(reply); writeErr != nil {
+							logger.Error("failed to write ERR frame", "error", writeErr)
+						}
+					}()
+					continue
+				}
+			}
+
+			if traceRoutes {
+				traceFrame := pr.routeTraceFrame(frame.Id, capUrn)
+				traceFrame.RoutingId = routingId
+				if writeErr := writer.WriteFrame(traceFrame); writeErr != nil {
+					logger.Error("failed to write LOG frame", "error", writeErr)
+				}
+			}
+
 			// Find handler
-			handler := pr.FindHandler(capUrn)
+			pattern, handler, routeErr := pr.findHandler(capUrn)
+			if routeErr != nil {
+				rejectReq(frame, "ROUTE_CONFLICT", routeErr.Error())
+				continue
+			}
 			if handler == nil {
-				errFrame := NewErr(frame.Id, "NO_HANDLER", fmt.Sprintf("No handler registered for cap: %s", capUrn))
-				errFrame.RoutingId = routingId
-				if writeErr := writer.WriteFrame(errFrame); writeErr != nil {
-					fmt.Fprintf(os.Stderr, "[PluginRuntime] Failed to write error: %v\n", writeErr)
-				}
+				rejectReq(frame, "NO_HANDLER", fmt.Sprintf("No handler registered for cap: %s", capUrn))
 				continue
 			}
 
-			// Start tracking this request - streams will be added via STREAM_START
-			pendingIncomingMu.Lock()
-			pendingIncoming[frame.Id.ToString()] = &pendingIncomingRequest{
+			// Saturated: running and queued requests are at the configured limit
+			if !limiter.reserve() {
+				rejectReq(frame, "BUSY", "Too many concurrent requests")
+				continue
+			}
+
+			// Start the handler now - STREAM_START/CHUNK/STREAM_END/END frames are forwarded as they arrive
+			reqTrace, _ := frame.TraceContext()
+			startHandler(handlerRequest{
+				id:        frame.Id,
+				routingId: routingId,
+				seq:       frame.Seq,
 				capUrn:    capUrn,
+				trace:     reqTrace,
+				env:       mergeEnv(connEnv, frame.Env()),
+				session:   sessions.open(frame.SessionId()),
+				timeout:   pr.requestTimeout(pattern, frame),
+				policy:    pr.resourcePolicy(pattern),
+				priority:  requestPriority(frame, negotiatedLimits),
 				handler:   handler,
-				routingId: frame.RoutingId, // Preserve XID for response routing
-				streams:   []streamEntry{}, // Streams added via STREAM_START
-				ended:     false,
-			}
-			pendingIncomingMu.Unlock()
-			fmt.Fprintf(os.Stderr, "[PluginRuntime] REQ: req_id=%s cap=%s - waiting for streams\n", frame.Id.ToString(), capUrn)
-			continue // Wait for STREAM_START/CHUNK/STREAM_END/END frames
+			})
+			logger.Debug("REQ: handler started", "req_id", frame.Id.ToString(), "cap", capUrn)
+			continue
 
 		case FrameTypeHeartbeat:
 			// Respond to heartbeat immediately - never blocked by handlers
@@ -421,16 +1044,17 @@ func (pr *PluginRuntime) runCBORMode() error {
 			if frame.StreamId == nil {
 				errFrame := NewErr(frame.Id, "PROTOCOL_ERROR", "CHUNK frame missing stream_id")
 				if err := writer.WriteFrame(errFrame); err != nil {
-					fmt.Fprintf(os.Stderr, "[PluginRuntime] Failed to write error: %v\n", err)
+					logger.Error("failed to write ERR frame", "error", err)
 				}
 				continue
 			}
 
 			// Verify checksum (protocol v2 integrity check)
 			if err := VerifyChunkChecksum(frame); err != nil {
+				metrics.ChecksumFailure()
 				errFrame := NewErr(frame.Id, "CORRUPTED_DATA", err.Error())
 				if err := writer.WriteFrame(errFrame); err != nil {
-					fmt.Fprintf(os.Stderr, "[PluginRuntime] Failed to write error: %v\n", err)
+					logger.Error("failed to write ERR frame", "error", err)
 				}
 				continue
 			}
@@ -438,147 +1062,78 @@ func (pr *PluginRuntime) runCBORMode() error {
 			streamID := *frame.StreamId
 
 			// Check if this is a chunk for an incoming request
-			pendingIncomingMu.Lock()
-			if pendingReq, exists := pendingIncoming[frame.Id.ToString()]; exists {
+			idKey := frame.Id.ToString()
+			if entry, ok := activeRequests.Load(idKey); ok {
+				active := entry.(*activeRequest)
 				// FAIL HARD: Request already ended
-				if pendingReq.ended {
-					delete(pendingIncoming, frame.Id.ToString())
-					pendingIncomingMu.Unlock()
-					errFrame := NewErr(frame.Id, "PROTOCOL_ERROR", "CHUNK after request END")
-					if err := writer.WriteFrame(errFrame); err != nil {
-						fmt.Fprintf(os.Stderr, "[PluginRuntime] Failed to write error: %v\n", err)
-					}
+				if active.ended {
+					abortRequest(idKey, active, "PROTOCOL_ERROR", "CHUNK after request END")
 					continue
 				}
-
 				// FAIL HARD: Unknown or inactive stream
-				var foundStream *pendingStream
-				for i := range pendingReq.streams {
-					if pendingReq.streams[i].streamID == streamID {
-						foundStream = pendingReq.streams[i].stream
-						break
-					}
+				open, known := active.streams[streamID]
+				if !known {
+					abortRequest(idKey, active, "PROTOCOL_ERROR", fmt.Sprintf("CHUNK for unknown stream_id: %s", streamID))
+					continue
 				}
-
-				if foundStream == nil {
-					delete(pendingIncoming, frame.Id.ToString())
-					pendingIncomingMu.Unlock()
-					errFrame := NewErr(frame.Id, "PROTOCOL_ERROR", fmt.Sprintf("CHUNK for unknown stream_id: %s", streamID))
-					if err := writer.WriteFrame(errFrame); err != nil {
-						fmt.Fprintf(os.Stderr, "[PluginRuntime] Failed to write error: %v\n", err)
-					}
+				if !open {
+					abortRequest(idKey, active, "PROTOCOL_ERROR", fmt.Sprintf("CHUNK for ended stream: %s", streamID))
 					continue
 				}
-
-				if foundStream.complete {
-					delete(pendingIncoming, frame.Id.ToString())
-					pendingIncomingMu.Unlock()
-					errFrame := NewErr(frame.Id, "PROTOCOL_ERROR", fmt.Sprintf("CHUNK for ended stream: %s", streamID))
-					if err := writer.WriteFrame(errFrame); err != nil {
-						fmt.Fprintf(os.Stderr, "[PluginRuntime] Failed to write error: %v\n", err)
-					}
+				active.received += int64(len(frame.Payload))
+				if limit := active.policy.MaxPayloadBytes; limit > 0 && active.received > limit {
+					abortRequest(idKey, active, string(ErrCodeResourceExhausted), (&ResourceError{Resource: "payload", Limit: limit}).Error())
 					continue
 				}
-
-				// ✅ Valid chunk for active stream
-				if frame.Payload != nil {
-					foundStream.chunks = append(foundStream.chunks, frame.Payload)
+				if inSequence(idKey, active, frame) {
+					forward(idKey, active, frame)
 				}
-				pendingIncomingMu.Unlock()
-				continue // Wait for more chunks or STREAM_END
+				continue
 			}
-			pendingIncomingMu.Unlock()
 
 			// Not an incoming request chunk - must be a peer response chunk
 			// Forward bare Frame object to handler - no wrapping, no decoding
-			idKey := frame.Id.ToString()
 			if pending, ok := pendingPeerRequests.Load(idKey); ok {
 				pendingReq := pending.(*pendingPeerRequest)
 				pendingReq.sender <- *frame
+			} else {
+				strayFrame(frame)
 			}
 
 		case FrameTypeEnd:
-			// Protocol v2: END frame marks the end of all streams for this request
-			pendingIncomingMu.Lock()
-			pendingReq, exists := pendingIncoming[frame.Id.ToString()]
-			if exists {
-				pendingReq.ended = true
-				delete(pendingIncoming, frame.Id.ToString())
-			}
-			pendingIncomingMu.Unlock()
-
-			if exists {
-				// Build frame channel with all incoming frames in order
-				// Protocol v2: Send STREAM_START → CHUNK(s) → STREAM_END for each stream, then END
-				requestID := frame.Id
-				handler := pendingReq.handler
-				capUrn := pendingReq.capUrn
-
-				// Create buffered channel for input frames
-				framesChan := make(chan Frame, 64)
-
-				activeHandlers.Add(1)
-				go func() {
-					defer activeHandlers.Done()
-					defer close(framesChan)
-
-					// Generate unique stream ID for response
-					streamID := fmt.Sprintf("resp-%s", requestID.ToString()[:8])
-					mediaUrn := "media:" // Default output media URN
-
-					// Create emitter with stream multiplexing (preserve routing_id for response routing)
-					emitter := newThreadSafeEmitter(writer, requestID, pendingReq.routingId, streamID, mediaUrn, negotiatedLimits.MaxChunk)
-					peerInvoker := newPeerInvokerImpl(writer, pendingPeerRequests, negotiatedLimits.MaxChunk)
-
-					fmt.Fprintf(os.Stderr, "[PluginRuntime] END: Invoking handler for cap=%s with %d streams\n", capUrn, len(pendingReq.streams))
-
-					// Send all frames to channel: STREAM_START → CHUNK(s) → STREAM_END per stream, then END
-					go func() {
-						for _, entry := range pendingReq.streams {
-							// STREAM_START
-							startFrame := NewStreamStart(requestID, entry.streamID, entry.stream.mediaUrn)
-							framesChan <- *startFrame
-
-							// CHUNKs
-							for seq, chunk := range entry.stream.chunks {
-								checksum := ComputeChecksum(chunk)
-								chunkFrame := NewChunk(requestID, entry.streamID, uint64(seq), chunk, uint64(seq), checksum)
-								framesChan <- *chunkFrame
-							}
-
-							// STREAM_END
-							endStreamFrame := NewStreamEnd(requestID, entry.streamID, uint64(len(entry.stream.chunks)))
-							framesChan <- *endStreamFrame
-						}
-
-						// END frame
-						framesChan <- *frame
-					}()
-
-					// Invoke handler with frame channel
-					err := handler(framesChan, emitter, peerInvoker)
-					if err != nil {
-						errFrame := NewErr(requestID, "HANDLER_ERROR", err.Error())
-						errFrame.RoutingId = pendingReq.routingId
-						if writeErr := writer.WriteFrame(errFrame); writeErr != nil {
-							fmt.Fprintf(os.Stderr, "[PluginRuntime] Failed to write error: %v\n", writeErr)
-						}
-						return
+			// Protocol v2: END frame marks the end of all streams for this request.
+			// Forward it and close the handler's input.
+			idKey := frame.Id.ToString()
+			if entry, ok := activeRequests.Load(idKey); ok {
+				active := entry.(*activeRequest)
+				if !active.ended {
+					if !inSequence(idKey, active, frame) {
+						continue
 					}
-
-					// Finalize sends STREAM_END + END frames
-					emitter.Finalize()
-				}()
-
-				continue
+					active.ended = true
+					forward(idKey, active, frame)
+					active.queue.close()
+					continue
+				}
+				if _, peer := pendingPeerRequests.Load(idKey); !peer {
+					metrics.StrayFrame(frame.FrameType)
+					logger.Warn("duplicate END", "req_id", idKey)
+					if strayFrames.Strict {
+						abortRequest(idKey, active, string(ErrCodeProtocol), "Duplicate END")
+					}
+					continue
+				}
 			}
 
 			// Not an incoming request end - must be a peer response end
 			// Closing the channel signals completion to the handler
-			idKey := frame.Id.ToString()
 			if pending, ok := pendingPeerRequests.LoadAndDelete(idKey); ok {
 				pendingReq := pending.(*pendingPeerRequest)
+				pendingReq.finish(nil)
 				close(pendingReq.sender)
+				recent.touch(idKey)
+			} else {
+				strayFrame(frame)
 			}
 
 		// RES frame REMOVED - old protocol no longer supported
@@ -590,8 +1145,10 @@ func (pr *PluginRuntime) runCBORMode() error {
 			idKey := frame.Id.ToString()
 			if pending, ok := pendingPeerRequests.LoadAndDelete(idKey); ok {
 				pendingReq := pending.(*pendingPeerRequest)
+				pendingReq.finish(ErrorFromFrame(frame))
 				pendingReq.sender <- *frame
 				close(pendingReq.sender)
+				recent.touch(idKey)
 			}
 
 		case FrameTypeLog:
@@ -603,7 +1160,7 @@ func (pr *PluginRuntime) runCBORMode() error {
 			if frame.StreamId == nil {
 				errFrame := NewErr(frame.Id, "PROTOCOL_ERROR", "STREAM_START missing stream_id")
 				if err := writer.WriteFrame(errFrame); err != nil {
-					fmt.Fprintf(os.Stderr, "[PluginRuntime] Failed to write error: %v\n", err)
+					logger.Error("failed to write ERR frame", "error", err)
 				}
 				continue
 			}
@@ -611,7 +1168,7 @@ func (pr *PluginRuntime) runCBORMode() error {
 			if frame.MediaUrn == nil {
 				errFrame := NewErr(frame.Id, "PROTOCOL_ERROR", "STREAM_START missing media_urn")
 				if err := writer.WriteFrame(errFrame); err != nil {
-					fmt.Fprintf(os.Stderr, "[PluginRuntime] Failed to write error: %v\n", err)
+					logger.Error("failed to write ERR frame", "error", err)
 				}
 				continue
 			}
@@ -619,60 +1176,37 @@ func (pr *PluginRuntime) runCBORMode() error {
 			streamID := *frame.StreamId
 			mediaUrn := *frame.MediaUrn
 
-			fmt.Fprintf(os.Stderr, "[PluginRuntime] STREAM_START: req_id=%s stream_id=%s media_urn=%s\n",
-				frame.Id.ToString(), streamID, mediaUrn)
+			logger.Debug("STREAM_START", "req_id", frame.Id.ToString(), "stream_id", streamID, "media_urn", mediaUrn)
 
 			// STRICT: Add stream with validation
-			pendingIncomingMu.Lock()
-			if pendingReq, exists := pendingIncoming[frame.Id.ToString()]; exists {
+			idKey := frame.Id.ToString()
+			if entry, ok := activeRequests.Load(idKey); ok {
+				active := entry.(*activeRequest)
 				// FAIL HARD: Request already ended
-				if pendingReq.ended {
-					delete(pendingIncoming, frame.Id.ToString())
-					pendingIncomingMu.Unlock()
-					errFrame := NewErr(frame.Id, "PROTOCOL_ERROR", "STREAM_START after request END")
-					if err := writer.WriteFrame(errFrame); err != nil {
-						fmt.Fprintf(os.Stderr, "[PluginRuntime] Failed to write error: %v\n", err)
-					}
+				if active.ended {
+					abortRequest(idKey, active, "PROTOCOL_ERROR", "STREAM_START after request END")
 					continue
 				}
-
 				// FAIL HARD: Duplicate stream_id
-				for _, entry := range pendingReq.streams {
-					if entry.streamID == streamID {
-						delete(pendingIncoming, frame.Id.ToString())
-						pendingIncomingMu.Unlock()
-						errFrame := NewErr(frame.Id, "PROTOCOL_ERROR", fmt.Sprintf("Duplicate stream_id: %s", streamID))
-						if err := writer.WriteFrame(errFrame); err != nil {
-							fmt.Fprintf(os.Stderr, "[PluginRuntime] Failed to write error: %v\n", err)
-						}
-						continue
-					}
+				if _, dup := active.streams[streamID]; dup {
+					abortRequest(idKey, active, "PROTOCOL_ERROR", fmt.Sprintf("Duplicate stream_id: %s", streamID))
+					continue
+				}
+				active.streams[streamID] = true
+				if inSequence(idKey, active, frame) {
+					forward(idKey, active, frame)
 				}
-
-				// ✅ Add new stream
-				pendingReq.streams = append(pendingReq.streams, streamEntry{
-					streamID: streamID,
-					stream: &pendingStream{
-						mediaUrn: mediaUrn,
-						chunks:   [][]byte{},
-						complete: false,
-					},
-				})
-				pendingIncomingMu.Unlock()
-				fmt.Fprintf(os.Stderr, "[PluginRuntime] Incoming stream started: %s\n", streamID)
 				continue
 			}
-			pendingIncomingMu.Unlock()
 
 			// Not an incoming request — check if it's a peer response stream
-			idKey := frame.Id.ToString()
 			if pending, ok := pendingPeerRequests.Load(idKey); ok {
 				pendingReq := pending.(*pendingPeerRequest)
 				pendingReq.streams[streamID] = mediaUrn
 				// Forward bare STREAM_START frame to handler
 				pendingReq.sender <- *frame
 			} else {
-				fmt.Fprintf(os.Stderr, "[PluginRuntime] STREAM_START for unknown request_id: %s\n", frame.Id.ToString())
+				strayFrame(frame)
 			}
 
 		case FrameTypeStreamEnd:
@@ -680,53 +1214,64 @@ func (pr *PluginRuntime) runCBORMode() error {
 			if frame.StreamId == nil {
 				errFrame := NewErr(frame.Id, "PROTOCOL_ERROR", "STREAM_END missing stream_id")
 				if err := writer.WriteFrame(errFrame); err != nil {
-					fmt.Fprintf(os.Stderr, "[PluginRuntime] Failed to write error: %v\n", err)
+					logger.Error("failed to write ERR frame", "error", err)
 				}
 				continue
 			}
 
 			streamID := *frame.StreamId
-			fmt.Fprintf(os.Stderr, "[PluginRuntime] STREAM_END: stream_id=%s\n", streamID)
+			logger.Debug("STREAM_END", "req_id", frame.Id.ToString(), "stream_id", streamID)
 
 			// STRICT: Mark stream as complete with validation
-			pendingIncomingMu.Lock()
-			if pendingReq, exists := pendingIncoming[frame.Id.ToString()]; exists {
-				// Find and mark stream as complete
-				found := false
-				for i := range pendingReq.streams {
-					if pendingReq.streams[i].streamID == streamID {
-						pendingReq.streams[i].stream.complete = true
-						found = true
-						fmt.Fprintf(os.Stderr, "[PluginRuntime] Incoming stream marked complete: %s\n", streamID)
-						break
-					}
-				}
-
-				if !found {
-					// FAIL HARD: STREAM_END for unknown stream
-					delete(pendingIncoming, frame.Id.ToString())
-					pendingIncomingMu.Unlock()
-					errFrame := NewErr(frame.Id, "PROTOCOL_ERROR", fmt.Sprintf("STREAM_END for unknown stream_id: %s", streamID))
-					if err := writer.WriteFrame(errFrame); err != nil {
-						fmt.Fprintf(os.Stderr, "[PluginRuntime] Failed to write error: %v\n", err)
-					}
+			idKey := frame.Id.ToString()
+			if entry, ok := activeRequests.Load(idKey); ok {
+				active := entry.(*activeRequest)
+				// FAIL HARD: STREAM_END for unknown or already ended stream
+				if !active.streams[streamID] {
+					abortRequest(idKey, active, "PROTOCOL_ERROR", fmt.Sprintf("STREAM_END for unknown stream_id: %s", streamID))
 					continue
 				}
-				pendingIncomingMu.Unlock()
+				active.streams[streamID] = false
+				if inSequence(idKey, active, frame) {
+					forward(idKey, active, frame)
+				}
 				continue
 			}
-			pendingIncomingMu.Unlock()
 
 			// Not an incoming request stream — check if it's a peer response stream end
-			idKey := frame.Id.ToString()
 			if pending, ok := pendingPeerRequests.Load(idKey); ok {
 				pendingReq := pending.(*pendingPeerRequest)
 				// Forward bare STREAM_END frame to handler
 				pendingReq.sender <- *frame
 			} else {
-				fmt.Fprintf(os.Stderr, "[PluginRuntime] STREAM_END for unknown request_id: %s\n", frame.Id.ToString())
+				strayFrame(frame)
 			}
 
+		case FrameTypeCancel:
+			// Host aborts a request: stop forwarding its input and cancel the handler's
+			// context; the handler goroutine answers ERR CANCELLED.
+			idKey := frame.Id.ToString()
+			if entry, ok := activeRequests.Load(idKey); ok {
+				logger.Debug("CANCEL", "req_id", idKey)
+				active := entry.(*activeRequest)
+				active.queue.close()
+				active.cancel()
+			} else {
+				// Already completed - END/ERR crossed the CANCEL on the wire
+				logger.Debug("CANCEL for unknown request", "req_id", idKey)
+			}
+
+		case FrameTypeAck:
+			// Host consumed response chunks - return credit to the request's emitter.
+			// ACKs for completed requests are harmless and dropped.
+			if active, ok := activeRequests.Load(frame.Id.ToString()); ok {
+				active.(*activeRequest).window.grant(frame.AckCredit())
+			}
+
+		case FrameTypeSessionClose:
+			// Host ended a session - drop the state its requests kept
+			sessions.close(frame.SessionId())
+
 		case FrameTypeRelayNotify, FrameTypeRelayState:
 			// Relay-level frames must never reach a plugin runtime.
 			// If they do, it's a bug in the relay layer — fail hard.
@@ -740,12 +1285,19 @@ func (pr *PluginRuntime) runCBORMode() error {
 	return nil
 }
 
-// runCLIMode runs in CLI mode - parse arguments and invoke handler
+// runCLIMode runs in CLI mode - parse arguments and invoke handler. Errors of the
+// command line are usage errors, and those of the handler handler errors (see CLIError).
 func (pr *PluginRuntime) runCLIMode(args []string) error {
-	if pr.manifest == nil {
+	manifest := pr.Manifest()
+	if manifest == nil {
 		return errors.New("failed to parse manifest for CLI mode")
 	}
 
+	// A cap command must not be shadowed by a runtime subcommand
+	if shadowed := pr.shadowedCapCommands(); len(shadowed) > 0 {
+		return fmt.Errorf("manifest cap commands collide with runtime subcommands: %s", strings.Join(shadowed, ", "))
+	}
+
 	// Handle --help at top level
 	if len(args) == 2 && (args[1] == "--help" || args[1] == "-h") {
 		pr.printHelp()
@@ -754,16 +1306,34 @@ func (pr *PluginRuntime) runCLIMode(args []string) error {
 
 	subcommand := args[1]
 
+	// Runtime-provided subcommands registered with AddCLICommand
+	if command, ok := pr.findCLICommand(subcommand); ok {
+		return newHandlerError(command.run(args[2:]))
+	}
+
 	// Handle manifest subcommand (always provided by runtime)
 	if subcommand == "manifest" {
-		prettyJSON, err := json.MarshalIndent(pr.manifest, "", "  ")
+		canonical, err := manifest.CanonicalJSON()
 		if err != nil {
 			return fmt.Errorf("failed to marshal manifest: %w", err)
 		}
-		fmt.Println(string(prettyJSON))
+		fmt.Println(string(canonical))
 		return nil
 	}
 
+	// Handle completion subcommand (always provided by runtime)
+	if subcommand == "completion" {
+		if len(args) != 3 {
+			return newUsageError(fmt.Errorf("usage: %s completion bash|zsh|fish", filepath.Base(args[0])))
+		}
+		return newUsageError(pr.writeCompletion(os.Stdout, args[2], filepath.Base(args[0])))
+	}
+
+	// Handle repl subcommand (always provided by runtime)
+	if subcommand == "repl" {
+		return pr.RunInteractive(os.Stdin, os.Stdout)
+	}
+
 	// Handle subcommand --help
 	if len(args) == 3 && (args[2] == "--help" || args[2] == "-h") {
 		if cap := pr.findCapByCommand(subcommand); cap != nil {
@@ -775,122 +1345,105 @@ func (pr *PluginRuntime) runCLIMode(args []string) error {
 	// Find cap by command name
 	cap := pr.findCapByCommand(subcommand)
 	if cap == nil {
-		return fmt.Errorf("unknown subcommand '%s'. Run with --help to see available commands", subcommand)
+		return newUsageError(fmt.Errorf("unknown subcommand '%s'. Run with --help to see available commands", subcommand))
 	}
 
 	// Find handler
-	handler := pr.FindHandler(cap.UrnString())
+	_, handler, err := pr.findHandler(cap.UrnString())
+	if err != nil {
+		return err
+	}
 	if handler == nil {
 		return fmt.Errorf("no handler registered for cap '%s'", cap.UrnString())
 	}
 
-	// Build CBOR payload from CLI args
-	rawPayload, err := pr.buildPayloadFromCLI(cap, args[2:])
+	// Runtime flags (--output, --format, --quiet, --config, --no-stdin) are not cap arguments
+	outputOptions, capArgs, err := parseCLIOutputFlags(cap, args[2:])
 	if err != nil {
-		return fmt.Errorf("failed to build payload: %w", err)
+		return newUsageError(err)
 	}
 
-	// Create CLI-mode frame channel
-	// CLI mode: each argument as separate stream (STREAM_START → CHUNK → STREAM_END per arg, then END)
-	framesChan := make(chan Frame, 32)
-	requestID := NewMessageIdDefault()
-
-	// Send frames in a goroutine
-	go func() {
-		defer close(framesChan)
-
-		// Decode CBOR arguments array
-		var arguments []interface{}
-		if len(rawPayload) > 0 {
-			if err := cborlib.Unmarshal(rawPayload, &arguments); err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to decode CBOR arguments: %v\n", err)
-				return
-			}
-		}
-
-		// Send each argument as a separate stream
-		for i, arg := range arguments {
-			argMap, ok := arg.(map[interface{}]interface{})
-			if !ok {
-				continue
-			}
-
-			var mediaUrn string
-			var value interface{}
-
-			// Extract media_urn and value from arg map
-			for k, v := range argMap {
-				key, ok := k.(string)
-				if !ok {
-					continue
-				}
-				if key == "media_urn" {
-					if urnStr, ok := v.(string); ok {
-						mediaUrn = urnStr
-					}
-				} else if key == "value" {
-					value = v
-				}
-			}
+	// Arguments not given on the command line may come from the config file
+	if err := pr.loadConfigFile(outputOptions.config); err != nil {
+		return newUsageError(err)
+	}
 
-			if mediaUrn == "" || value == nil {
-				continue
-			}
+	// An output file argument takes the result like --output
+	if err := pr.applyCLIOutputArg(cap, capArgs, &outputOptions); err != nil {
+		return newUsageError(err)
+	}
 
-			streamID := fmt.Sprintf("arg-%d", i)
+	// Resolve the arguments; files and stdin are streamed to the handler as it runs
+	stdin, err := cliStdin(cap, outputOptions.noStdin)
+	if err != nil {
+		return fmt.Errorf("failed to read stdin: %w", err)
+	}
+	arguments, err := pr.buildCLIArguments(cap, capArgs, stdin)
+	if err != nil {
+		return newUsageError(fmt.Errorf("failed to build payload: %w", err))
+	}
 
-			// STREAM_START
-			startFrame := NewStreamStart(requestID, streamID, mediaUrn)
-			framesChan <- *startFrame
+	return pr.invokeCLIArguments(handler, arguments, outputOptions, os.Stdout, os.Stderr)
+}
 
-			// CHUNK: CBOR-encode the value before sending
-			// Protocol: ALL values must be CBOR-encoded (encode once, no double-wrapping)
-			cborValue, err := cborlib.Marshal(value)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to encode argument value: %v\n", err)
-				continue
-			}
+// invokeCLICap feeds a CLI-built payload to handler as one request and writes the
+// result to stdout (or the --output file) and logs and errors to stderr
+func (pr *PluginRuntime) invokeCLICap(handler HandlerFunc, rawPayload []byte, outputOptions cliOutputOptions, stdout, stderr io.Writer) error {
+	arguments, err := decodeCLIPayload(rawPayload)
+	if err != nil {
+		// The handler isn't run without its arguments
+		return reportCLIError(stderr, &CLIError{ExitCode: ExitUsageError, Code: string(ErrCodeInvalidArgument), Err: err})
+	}
+	return pr.invokeCLIArguments(handler, arguments, outputOptions, stdout, stderr)
+}
 
-			checksum := ComputeChecksum(cborValue)
-			chunkFrame := NewChunk(requestID, streamID, 0, cborValue, 0, checksum)
-			framesChan <- *chunkFrame
+// invokeCLIArguments feeds arguments to handler as one request (see invokeCLICap)
+func (pr *PluginRuntime) invokeCLIArguments(handler HandlerFunc, arguments []cliArgument, outputOptions cliOutputOptions, stdout, stderr io.Writer) error {
+	if err := pr.start(context.Background()); err != nil {
+		closeCLIArguments(arguments)
+		return reportCLIError(stderr, newHandlerError(err))
+	}
+	out, closeOutput, err := openCLIOutput(outputOptions, stdout)
+	if err != nil {
+		closeCLIArguments(arguments)
+		return err
+	}
 
-			// STREAM_END
-			endStreamFrame := NewStreamEnd(requestID, streamID, 1)
-			framesChan <- *endStreamFrame
-		}
+	// Create CLI-mode frame channel
+	// CLI mode: each argument as separate stream (STREAM_START → CHUNK* → STREAM_END per arg, then END)
+	framesChan := make(chan Frame, 32)
+	requestID := NewMessageIdDefault()
 
-		// END
-		endFrame := NewEnd(requestID, nil)
-		framesChan <- *endFrame
-	}()
+	// Send frames in a goroutine, until the handler returns
+	handlerDone := make(chan struct{})
+	defer close(handlerDone)
+	go pr.sendCLIArguments(framesChan, handlerDone, requestID, arguments, pr.Limits().MaxChunk)
 
 	// Create CLI-mode emitter and no-op peer invoker
-	emitter := &cliStreamEmitter{}
+	emitter := newCLIStreamEmitter(out, stderr, outputOptions.format, outputOptions.quiet)
 	peer := &noPeerInvoker{}
 
 	// Invoke handler with frame channel
 	err = handler(framesChan, emitter, peer)
-	if err != nil {
-		errorJSON, _ := json.Marshal(map[string]string{
-			"error": err.Error(),
-			"code":  "HANDLER_ERROR",
-		})
-		fmt.Fprintln(os.Stderr, string(errorJSON))
-		return err
+	if emitter.aborted != nil {
+		err = emitter.aborted
 	}
-
-	return nil
+	if closeErr := closeOutput(err == nil); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write output file: %w", closeErr)
+	}
+	// A failure is written to stderr as the error document (see CLIError)
+	return reportCLIError(stderr, newHandlerError(err))
 }
 
 // findCapByCommand finds a cap by its command name
 func (pr *PluginRuntime) findCapByCommand(commandName string) *cap.Cap {
-	if pr.manifest == nil {
+	manifest := pr.Manifest()
+	if manifest == nil {
 		return nil
 	}
-	for i := range pr.manifest.Caps {
-		if pr.manifest.Caps[i].Command == commandName {
-			return &pr.manifest.Caps[i]
+	for i := range manifest.Caps {
+		if manifest.Caps[i].Command == commandName {
+			return &manifest.Caps[i]
 		}
 	}
 	return nil
@@ -898,37 +1451,49 @@ func (pr *PluginRuntime) findCapByCommand(commandName string) *cap.Cap {
 
 // printHelp prints help message showing all available subcommands
 func (pr *PluginRuntime) printHelp() {
-	if pr.manifest == nil {
+	manifest := pr.Manifest()
+	if manifest == nil {
 		return
 	}
 
-	fmt.Fprintf(os.Stderr, "%s v%s\n", pr.manifest.Name, pr.manifest.Version)
-	fmt.Fprintf(os.Stderr, "%s\n\n", pr.manifest.Description)
+	fmt.Fprintf(os.Stderr, "%s v%s\n", manifest.Name, manifest.Version)
+	fmt.Fprintf(os.Stderr, "%s\n\n", manifest.Description)
 	fmt.Fprintf(os.Stderr, "USAGE:\n")
-	fmt.Fprintf(os.Stderr, "    %s <COMMAND> [OPTIONS]\n\n", pr.manifest.Name)
+	fmt.Fprintf(os.Stderr, "    %s <COMMAND> [OPTIONS]\n\n", manifest.Name)
 	fmt.Fprintf(os.Stderr, "COMMANDS:\n")
 	fmt.Fprintf(os.Stderr, "    manifest    Output the plugin manifest as JSON\n")
+	fmt.Fprintf(os.Stderr, "    completion  Output a bash, zsh or fish completion script\n")
+	fmt.Fprintf(os.Stderr, "    repl        Invoke caps interactively, one command per line\n")
+
+	pr.mu.RLock()
+	names := make([]string, 0, len(pr.cliCommands))
+	for name := range pr.cliCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "    %-12s %s\n", name, pr.cliCommands[name].description)
+	}
+	pr.mu.RUnlock()
 
-	for i := range pr.manifest.Caps {
-		cap := &pr.manifest.Caps[i]
+	for i := range manifest.Caps {
+		cap := &manifest.Caps[i]
 		desc := cap.Title
 		if cap.CapDescription != nil {
 			desc = *cap.CapDescription
 		}
+		if cap.Deprecated {
+			desc = "[deprecated] " + desc
+		}
 		fmt.Fprintf(os.Stderr, "    %-12s %s\n", cap.Command, desc)
 	}
 
-	fmt.Fprintf(os.Stderr, "\nRun '%s <COMMAND> --help' for more information on a command.\n", pr.manifest.Name)
+	fmt.Fprintf(os.Stderr, "\nRun '%s <COMMAND> --help' for more information on a command.\n", manifest.Name)
 }
 
 // printCapHelp prints help for a specific cap
 func (pr *PluginRuntime) printCapHelp(capDef *cap.Cap) {
-	fmt.Fprintf(os.Stderr, "%s\n", capDef.Title)
-	if capDef.CapDescription != nil {
-		fmt.Fprintf(os.Stderr, "%s\n", *capDef.CapDescription)
-	}
-	fmt.Fprintf(os.Stderr, "\nUSAGE:\n")
-	fmt.Fprintf(os.Stderr, "    plugin %s [OPTIONS]\n\n", capDef.Command)
+	writeCapHelp(os.Stderr, pr.Manifest().Name, capDef)
 }
 
 // extractEffectivePayload extracts the effective payload from a REQ frame.
@@ -1024,73 +1589,317 @@ func toBytes(v interface{}) []byte {
 	}
 }
 
-// syncFrameWriter wraps FrameWriter with a mutex for concurrent access and
-// centralized seq assignment. All frames pass through the SeqAssigner before
-// writing, ensuring monotonically increasing seq per flow (RID + XID).
+// syncFrameWriter lets handler goroutines write frames concurrently. Each flow (RID +
+// XID) queues its writes apart, and a single goroutine drains the queues in turns, so a
+// handler emitting large CHUNKs doesn't hold up other requests' responses; the frames of
+// a flow are written in the order they were queued. All frames pass through the
+// SeqAssigner before writing, ensuring monotonically increasing seq per flow.
 // (matches Rust PluginRuntime writer thread with SeqAssigner)
 type syncFrameWriter struct {
-	mu          sync.Mutex
+	mu       sync.Mutex
+	space    *sync.Cond             // Broadcast when a queued write is taken off its queue
+	flows    map[FlowKey]*flowQueue // Flows with writes queued
+	ring     []*flowQueue           // The same flows, in the order they take turns
+	next     int                    // Ring position of the flow whose turn is next
+	control  flowQueue              // Writes of frames outside any flow (HEARTBEAT...)
+	draining bool                   // A goroutine is draining the queues
+	limits   Limits                 // Applied to writer before each write (see SetLimits)
+
+	// Used by the draining goroutine only
 	writer      *FrameWriter
 	seqAssigner *SeqAssigner
+
+	// Stall detection (see SetWriteTimeout)
+	timeout      time.Duration           // 0 = writes may take forever
+	deadline     func(t time.Time) error // Sets the transport's write deadline, nil if it has none
+	writingSince atomic.Int64            // UnixNano a write to the transport started, 0 if none is running
+	stalled      chan struct{}           // Closed once a write stalled
+	stallOnce    sync.Once
 }
 
 func newSyncFrameWriter(w *FrameWriter) *syncFrameWriter {
-	return &syncFrameWriter{
+	s := &syncFrameWriter{
+		flows:       make(map[FlowKey]*flowQueue),
+		limits:      w.limits,
 		writer:      w,
 		seqAssigner: NewSeqAssigner(),
+		stalled:     make(chan struct{}),
 	}
+	s.space = sync.NewCond(&s.mu)
+	return s
 }
 
 func (s *syncFrameWriter) WriteFrame(frame *Frame) error {
+	return s.writeFrameAt(priorityControl, frame)
+}
+
+// writeFrameAt writes a frame, its flow taking turns with flows of the same priority
+// and waiting for more urgent ones, e.g. a response CHUNK at the priority of its request
+func (s *syncFrameWriter) writeFrameAt(priority int, frame *Frame) error {
+	return s.send(priority, []*Frame{frame})
+}
+
+// WriteFrames writes frames with a single write, see FrameWriter.WriteFrames. They are
+// queued on the flow of the first one.
+func (s *syncFrameWriter) WriteFrames(frames ...*Frame) error {
+	return s.send(priorityControl, frames)
+}
+
+// newChunk builds a CHUNK checksummed with the connection's negotiated algorithm. The
+// checksum is computed outside the lock so that emitters hash in parallel.
+func (s *syncFrameWriter) newChunk(reqId MessageId, streamId string, seq uint64, payload []byte, chunkIndex uint64) *Frame {
+	s.mu.Lock()
+	algorithm := s.limits.Checksum
+	s.mu.Unlock()
+	return newChunkWith(algorithm, reqId, streamId, seq, payload, chunkIndex)
+}
+
+// compression returns the stream compression negotiated for the connection, or ""
+func (s *syncFrameWriter) compression() CompressionAlgorithm {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	// Centralized seq assignment — all flow frames get monotonic seq per flow
-	s.seqAssigner.Assign(frame)
-	err := s.writer.WriteFrame(frame)
-	// Clean up flow tracking after terminal frames
-	if err == nil && (frame.FrameType == FrameTypeEnd || frame.FrameType == FrameTypeErr) {
-		key := FlowKeyFromFrame(frame)
-		s.seqAssigner.Remove(key)
-	}
-	return err
+	return s.limits.Compression
 }
 
 func (s *syncFrameWriter) SetLimits(limits Limits) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.writer.SetLimits(limits)
+	s.limits = limits
+}
+
+// responseStream is the state of one response stream (STREAM_START → CHUNK* → STREAM_END).
+// Each stream counts its own chunks; seq is assigned per flow by the syncFrameWriter.
+type responseStream struct {
+	streamID   string
+	mediaUrn   string
+	started    bool   // STREAM_START sent
+	closed     bool   // STREAM_END sent
+	chunkIndex uint64 // Chunks sent so far (required by protocol)
 }
 
 // threadSafeEmitter implements StreamEmitter with thread-safe writes using stream multiplexing
 type threadSafeEmitter struct {
-	writer        *syncFrameWriter
-	requestID     MessageId
-	routingId     *MessageId // XID from incoming request (preserved for response routing)
-	streamID      string     // Response stream ID
-	mediaUrn      string     // Response media URN
-	streamStarted bool       // Track if STREAM_START was sent
-	seq           uint64
-	chunkIndex    uint64 // Track chunk index (required by protocol)
-	seqMu         sync.Mutex
-	maxChunk      int
-}
-
-func newThreadSafeEmitter(writer *syncFrameWriter, requestID MessageId, routingId *MessageId, streamID string, mediaUrn string, maxChunk int) *threadSafeEmitter {
+	ctx       context.Context // Request context, cancelled by CANCEL
+	writer    *syncFrameWriter
+	requestID MessageId
+	routingId *MessageId      // XID from incoming request (preserved for response routing)
+	primary   *responseStream // Default response stream used by EmitCbor
+	opened    []*responseStream
+	seqMu     sync.Mutex
+	chunks    chunkEncoder // Reused for []byte and string CHUNK payloads (guarded by seqMu)
+	maxChunk  int
+	tuner     *chunkTuner                // Adapts the CHUNK size below maxChunk (nil = always maxChunk)
+	priority  int                        // Priority CHUNKs are written with (see Frame.SetPriority)
+	window    *flowWindow                // Flow-control credit (nil = unlimited)
+	ended     bool                       // END or ERR sent, nothing more may be emitted (guarded by seqMu)
+	aborted   string                     // Code of the ERR sent by Abort (guarded by seqMu)
+	maxOutput int64                      // Output CHUNK payload bytes before RESOURCE_EXHAUSTED (0 = unbounded)
+	emitted   int64                      // Output CHUNK payload bytes so far (guarded by seqMu)
+	onEnd     func(code, message string) // Told how the response ends before its END or ERR is written, code "" for END
+	text      *textWriter                // Writer returned by TextWriter, drained by Finalize (nil until then)
+	logger    Logger
+}
+
+func newThreadSafeEmitter(ctx context.Context, writer *syncFrameWriter, requestID MessageId, routingId *MessageId, streamID string, mediaUrn string, maxChunk int, window *flowWindow, logger Logger) *threadSafeEmitter {
 	return &threadSafeEmitter{
-		writer:        writer,
-		requestID:     requestID,
-		routingId:     routingId,
-		streamID:      streamID,
-		mediaUrn:      mediaUrn,
-		streamStarted: false,
-		maxChunk:      maxChunk,
+		ctx:       ctx,
+		writer:    writer,
+		requestID: requestID,
+		routingId: routingId,
+		primary:   &responseStream{streamID: streamID, mediaUrn: mediaUrn},
+		maxChunk:  maxChunk,
+		priority:  PriorityNormal,
+		window:    window,
+		logger:    logger,
+	}
+}
+
+// Context returns the request context (see HandlerContext).
+func (e *threadSafeEmitter) Context() context.Context {
+	return e.ctx
+}
+
+// open checks that the response can still be emitted on (caller must hold seqMu).
+// Nothing more may be sent for a cancelled request - the runtime terminates it with ERR.
+func (e *threadSafeEmitter) open() error {
+	if e.ctx.Err() != nil {
+		return ErrRequestCancelled
+	}
+	if e.ended {
+		return ErrResponseEnded
 	}
+	return nil
+}
+
+// accept answers the request with ACCEPTED for the async job it continues as (see
+// RegisterAsync)
+func (e *threadSafeEmitter) accept(jobId string) error {
+	e.seqMu.Lock()
+	defer e.seqMu.Unlock()
+
+	if err := e.open(); err != nil {
+		return err
+	}
+	frame := NewAccepted(e.requestID, jobId)
+	frame.RoutingId = e.routingId
+	return e.writer.WriteFrame(frame)
 }
 
 func (e *threadSafeEmitter) EmitCbor(value interface{}) error {
 	e.seqMu.Lock()
 	defer e.seqMu.Unlock()
 
+	if err := e.open(); err != nil {
+		return err
+	}
+	return e.emitToStream(e.primary, value)
+}
+
+func (e *threadSafeEmitter) EmitSeq(produce func(yield func(v interface{}) error) error) error {
+	return produce(e.emitElement)
+}
+
+// emitElement sends one element of a sequence as its own CHUNK on the primary stream.
+// The lock is taken per element, so a long sequence doesn't hold off other streams.
+func (e *threadSafeEmitter) emitElement(element interface{}) error {
+	cborPayload, err := cborlib.Marshal(element)
+	if err != nil {
+		return fmt.Errorf("failed to encode element: %w", err)
+	}
+	e.seqMu.Lock()
+	defer e.seqMu.Unlock()
+
+	if err := e.open(); err != nil {
+		return err
+	}
+	if !e.primary.started {
+		if err := e.startStream(e.primary); err != nil {
+			return err
+		}
+	}
+	return e.writeChunk(e.primary, cborPayload)
+}
+
+func (e *threadSafeEmitter) EmitJSON(v interface{}) error {
+	document, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to JSON-encode value: %w", err)
+	}
+	return e.emitTagged(standard.MediaJSON, string(document))
+}
+
+func (e *threadSafeEmitter) EmitText(s string) error {
+	return e.emitTagged(standard.MediaString, s)
+}
+
+func (e *threadSafeEmitter) EmitBytes(b []byte) error {
+	return e.emitTagged(standard.MediaBinary, b)
+}
+
+// emitTagged emits value on the primary stream, announcing it as mediaUrn unless it
+// already started
+func (e *threadSafeEmitter) emitTagged(mediaUrn string, value interface{}) error {
+	e.seqMu.Lock()
+	defer e.seqMu.Unlock()
+
+	if err := e.open(); err != nil {
+		return err
+	}
+	if !e.primary.started {
+		e.primary.mediaUrn = mediaUrn
+	}
+	return e.emitToStream(e.primary, value)
+}
+
+// OpenStream announces an additional response stream with its own STREAM_START.
+// The stream ID is derived from the primary stream ID.
+func (e *threadSafeEmitter) OpenStream(mediaUrn string) (OutputStream, error) {
+	if _, err := urn.NewMediaUrnFromString(mediaUrn); err != nil {
+		return nil, fmt.Errorf("invalid media URN for output stream '%s': %w", mediaUrn, err)
+	}
+
+	e.seqMu.Lock()
+	defer e.seqMu.Unlock()
+
+	if err := e.open(); err != nil {
+		return nil, err
+	}
+
+	stream := &responseStream{
+		streamID: fmt.Sprintf("%s-%d", e.primary.streamID, len(e.opened)+1),
+		mediaUrn: mediaUrn,
+	}
+	if err := e.startStream(stream); err != nil {
+		return nil, err
+	}
+	e.opened = append(e.opened, stream)
+	return &emitterOutputStream{emitter: e, stream: stream}, nil
+}
+
+// startStream sends STREAM_START for a stream (caller must hold seqMu).
+func (e *threadSafeEmitter) startStream(stream *responseStream) error {
+	stream.started = true
+	startFrame := NewStreamStart(e.requestID, stream.streamID, stream.mediaUrn)
+	startFrame.RoutingId = e.routingId
+	if compression := e.writer.compression(); compression != "" {
+		startFrame.SetStreamCompression(compression)
+	}
+	if err := e.writer.WriteFrame(startFrame); err != nil {
+		return fmt.Errorf("failed to write STREAM_START: %w", err)
+	}
+	return nil
+}
+
+// closeStream sends STREAM_END for a stream (caller must hold seqMu).
+func (e *threadSafeEmitter) closeStream(stream *responseStream) error {
+	if err := e.writer.WriteFrame(e.streamEnd(stream)); err != nil {
+		return fmt.Errorf("failed to write STREAM_END: %w", err)
+	}
+	return nil
+}
+
+// streamEnd marks a stream closed and returns its STREAM_END (caller must hold seqMu).
+func (e *threadSafeEmitter) streamEnd(stream *responseStream) *Frame {
+	stream.closed = true
+	streamEndFrame := NewStreamEnd(e.requestID, stream.streamID, stream.chunkIndex)
+	streamEndFrame.RoutingId = e.routingId
+	return streamEndFrame
+}
+
+// writeChunk sends one CHUNK with an independently decodable CBOR payload (caller must hold seqMu).
+// Blocks while the flow-control window is exhausted, so a slow consumer throttles the handler.
+// Past the cap's output limit the response is ended with RESOURCE_EXHAUSTED instead.
+func (e *threadSafeEmitter) writeChunk(stream *responseStream, cborPayload []byte) error {
+	if e.maxOutput > 0 && e.emitted+int64(len(cborPayload)) > e.maxOutput {
+		limitErr := &ResourceError{Resource: "output", Limit: e.maxOutput}
+		errFrame := NewErrFromError(e.requestID, limitErr)
+		e.aborted = errFrame.ErrorCode()
+		if err := e.terminate(errFrame); err != nil {
+			return err
+		}
+		return limitErr
+	}
+	e.emitted += int64(len(cborPayload))
+
+	if err := e.window.acquire(e.ctx); err != nil {
+		return err
+	}
+
+	currentIndex := stream.chunkIndex
+	stream.chunkIndex++
+
+	frame := e.writer.newChunk(e.requestID, stream.streamID, currentIndex, cborPayload, currentIndex)
+	frame.RoutingId = e.routingId
+	started := time.Now()
+	if err := e.writer.writeFrameAt(e.priority, frame); err != nil {
+		return fmt.Errorf("failed to write chunk: %w", err)
+	}
+	e.tuner.observe(len(cborPayload), time.Since(started))
+	return nil
+}
+
+// emitToStream encodes a value into CHUNK frames on the given stream (caller must hold seqMu).
+func (e *threadSafeEmitter) emitToStream(stream *responseStream, value interface{}) error {
 	// CHUNK payloads = complete, independently decodable CBOR values
 	//
 	// Streams might never end (logs, video, real-time data), so each CHUNK must be
@@ -1101,13 +1910,14 @@ func (e *threadSafeEmitter) EmitCbor(value interface{}) error {
 	//
 	// Each CHUNK payload can be decoded independently: cbor2.loads(chunk.payload)
 
+	if stream.closed {
+		return fmt.Errorf("output stream %s is already closed", stream.streamID)
+	}
+
 	// STREAM MULTIPLEXING: Send STREAM_START before first chunk
-	if !e.streamStarted {
-		e.streamStarted = true
-		startFrame := NewStreamStart(e.requestID, e.streamID, e.mediaUrn)
-		startFrame.RoutingId = e.routingId
-		if err := e.writer.WriteFrame(startFrame); err != nil {
-			return fmt.Errorf("failed to write STREAM_START: %w", err)
+	if !stream.started {
+		if err := e.startStream(stream); err != nil {
+			return err
 		}
 	}
 
@@ -1117,194 +1927,279 @@ func (e *threadSafeEmitter) EmitCbor(value interface{}) error {
 		offset := 0
 		for offset < len(byteSlice) {
 			chunkSize := len(byteSlice) - offset
-			if chunkSize > e.maxChunk {
-				chunkSize = e.maxChunk
+			if maxChunk := e.tuner.chunkSize(e.maxChunk); chunkSize > maxChunk {
+				chunkSize = maxChunk
 			}
 			chunkBytes := byteSlice[offset : offset+chunkSize]
 
 			// Encode as complete []byte - independently decodable
-			cborPayload, err := cborlib.Marshal(chunkBytes)
-			if err != nil {
-				return fmt.Errorf("failed to encode chunk: %w", err)
-			}
-
-			currentSeq := e.seq
-			e.seq++
-			currentIndex := e.chunkIndex
-			e.chunkIndex++
-			checksum := ComputeChecksum(cborPayload)
-
-			frame := NewChunk(e.requestID, e.streamID, currentSeq, cborPayload, currentIndex, checksum)
-			frame.RoutingId = e.routingId
-			if err := e.writer.WriteFrame(frame); err != nil {
-				return fmt.Errorf("failed to write chunk: %w", err)
+			if err := e.writeChunk(stream, e.chunks.bytes(chunkBytes)); err != nil {
+				return err
 			}
 
 			offset += chunkSize
 		}
 	} else if str, ok := value.(string); ok {
 		// Split string BEFORE encoding, encode each chunk as string
-		strBytes := []byte(str)
 		offset := 0
-		for offset < len(strBytes) {
-			chunkSize := len(strBytes) - offset
-			if chunkSize > e.maxChunk {
-				chunkSize = e.maxChunk
+		for offset < len(str) {
+			chunkSize := len(str) - offset
+			if maxChunk := e.tuner.chunkSize(e.maxChunk); chunkSize > maxChunk {
+				chunkSize = maxChunk
 			}
 			// Ensure we split on UTF-8 character boundaries
-			for chunkSize > 0 && offset+chunkSize < len(strBytes) && (strBytes[offset+chunkSize]&0xC0) == 0x80 {
+			for chunkSize > 0 && offset+chunkSize < len(str) && (str[offset+chunkSize]&0xC0) == 0x80 {
 				chunkSize--
 			}
 			if chunkSize == 0 {
 				return fmt.Errorf("cannot split string on character boundary")
 			}
 
-			chunkStr := string(strBytes[offset : offset+chunkSize])
-
 			// Encode as complete string - independently decodable
-			cborPayload, err := cborlib.Marshal(chunkStr)
-			if err != nil {
-				return fmt.Errorf("failed to encode chunk: %w", err)
-			}
-
-			currentSeq := e.seq
-			e.seq++
-			currentIndex := e.chunkIndex
-			e.chunkIndex++
-			checksum := ComputeChecksum(cborPayload)
-
-			frame := NewChunk(e.requestID, e.streamID, currentSeq, cborPayload, currentIndex, checksum)
-			frame.RoutingId = e.routingId
-			if err := e.writer.WriteFrame(frame); err != nil {
-				return fmt.Errorf("failed to write chunk: %w", err)
+			if err := e.writeChunk(stream, e.chunks.text(str[offset:offset+chunkSize])); err != nil {
+				return err
 			}
 
 			offset += chunkSize
 		}
-	} else if slice, ok := value.([]interface{}); ok {
-		// Array: send each element as independent CBOR chunk
-		// Allows receiver to reconstruct elements without waiting for entire array
-		for _, element := range slice {
-			// Encode each element as complete CBOR value
-			cborPayload, err := cborlib.Marshal(element)
-			if err != nil {
-				return fmt.Errorf("failed to encode array element: %w", err)
+	} else {
+		// Any other value (arrays and maps included): encode once as a complete CBOR
+		// value, sent as a single chunk. An encoding larger than a chunk is split at the
+		// byte level and sent as byte strings, which the receiver concatenates and
+		// decodes as a CBOR document (see Arg.DecodeCBOR). Streaming element by element
+		// is EmitStreamElements.
+		cborPayload, err := cborlib.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("failed to CBOR-encode value: %w", err)
+		}
+		maxChunk := e.tuner.chunkSize(e.maxChunk)
+		if len(cborPayload) <= maxChunk {
+			return e.writeChunk(stream, cborPayload)
+		}
+		for offset := 0; offset < len(cborPayload); offset += maxChunk {
+			end := offset + maxChunk
+			if end > len(cborPayload) {
+				end = len(cborPayload)
+			}
+			if err := e.writeChunk(stream, e.chunks.bytes(cborPayload[offset:end])); err != nil {
+				return err
 			}
+		}
+	}
 
-			currentSeq := e.seq
-			e.seq++
-			currentIndex := e.chunkIndex
-			e.chunkIndex++
-			checksum := ComputeChecksum(cborPayload)
+	return nil
+}
 
-			frame := NewChunk(e.requestID, e.streamID, currentSeq, cborPayload, currentIndex, checksum)
-			frame.RoutingId = e.routingId
-			if err := e.writer.WriteFrame(frame); err != nil {
-				return fmt.Errorf("failed to write chunk: %w", err)
-			}
+// Abort ends the response with an ERR for err, listing the streams it cuts short
+func (e *threadSafeEmitter) Abort(err error) error {
+	if err == nil {
+		return errors.New("abort requires an error")
+	}
+	e.seqMu.Lock()
+	defer e.seqMu.Unlock()
+
+	if openErr := e.open(); openErr != nil {
+		return openErr
+	}
+	errFrame := NewErrFromError(e.requestID, err)
+	e.aborted = errFrame.ErrorCode()
+	return e.terminate(errFrame)
+}
+
+// abortCode returns the code of the ERR sent by Abort, "" if the handler didn't abort
+func (e *threadSafeEmitter) abortCode() string {
+	e.seqMu.Lock()
+	defer e.seqMu.Unlock()
+	return e.aborted
+}
+
+// fail ends the response with errFrame unless it already ended
+func (e *threadSafeEmitter) fail(errFrame *Frame) error {
+	e.seqMu.Lock()
+	defer e.seqMu.Unlock()
+
+	if e.ended {
+		return nil
+	}
+	return e.terminate(errFrame)
+}
+
+// terminate sends errFrame as the end of the response, its meta "partial_streams"
+// listing each stream started but not closed with the chunks sent on it; streams closed
+// with STREAM_END are complete (caller must hold seqMu).
+func (e *threadSafeEmitter) terminate(errFrame *Frame) error {
+	e.ended = true
+	if e.onEnd != nil {
+		e.onEnd(errFrame.ErrorCode(), errFrame.ErrorMessage())
+	}
+	var partial []PartialStream
+	for _, stream := range append([]*responseStream{e.primary}, e.opened...) {
+		if stream.started && !stream.closed {
+			partial = append(partial, PartialStream{StreamId: stream.streamID, MediaUrn: stream.mediaUrn, ChunkCount: stream.chunkIndex})
 		}
-	} else if m, ok := value.(map[interface{}]interface{}); ok {
-		// Map: send each entry as independent CBOR chunk
-		// Receiver must wait for all entries before reconstructing map
-		for key, val := range m {
-			// Encode each key-value pair as a 2-element array: [key, value]
-			entry := []interface{}{key, val}
-			cborPayload, err := cborlib.Marshal(entry)
-			if err != nil {
-				return fmt.Errorf("failed to encode map entry: %w", err)
-			}
+	}
+	errFrame.SetPartialStreams(partial)
+	errFrame.RoutingId = e.routingId
+	return e.writer.WriteFrame(errFrame)
+}
 
-			currentSeq := e.seq
-			e.seq++
-			currentIndex := e.chunkIndex
-			e.chunkIndex++
-			checksum := ComputeChecksum(cborPayload)
+// Finalize sends STREAM_END for every open stream, then END to complete the response
+func (e *threadSafeEmitter) Finalize() {
+	e.seqMu.Lock()
+	defer e.seqMu.Unlock()
 
-			frame := NewChunk(e.requestID, e.streamID, currentSeq, cborPayload, currentIndex, checksum)
-			frame.RoutingId = e.routingId
-			if err := e.writer.WriteFrame(frame); err != nil {
-				return fmt.Errorf("failed to write chunk: %w", err)
+	if e.ended {
+		return
+	}
+	// Text the handler left in its TextWriter
+	if e.text != nil {
+		if text := e.text.drain(); text != "" {
+			if !e.primary.started {
+				e.primary.mediaUrn = standard.MediaString
+			}
+			if err := e.emitToStream(e.primary, text); err != nil {
+				e.logger.Error("failed to emit buffered text", "req_id", e.requestID.ToString(), "error", err)
 			}
 		}
-	} else {
-		// For other types (int, float, bool, nil): encode as single chunk
-		// These have single-value semantics and are typically small
-		cborPayload, err := cborlib.Marshal(value)
-		if err != nil {
-			return fmt.Errorf("failed to CBOR-encode value: %w", err)
-		}
+	}
+	if e.ended { // Past the cap's output limit the text ended the response with ERR
+		return
+	}
+	e.ended = true
+	if e.onEnd != nil {
+		e.onEnd("", "")
+	}
 
-		currentSeq := e.seq
-		e.seq++
-		currentIndex := e.chunkIndex
-		e.chunkIndex++
-		checksum := ComputeChecksum(cborPayload)
+	// If nothing was sent at all, still send the primary stream to keep protocol consistent
+	if !e.primary.started && len(e.opened) == 0 {
+		if err := e.startStream(e.primary); err != nil {
+			e.logger.Error("failed to finalize response", "req_id", e.requestID.ToString(), "error", err)
+			return
+		}
+	}
 
-		frame := NewChunk(e.requestID, e.streamID, currentSeq, cborPayload, currentIndex, checksum)
-		frame.RoutingId = e.routingId
-		if err := e.writer.WriteFrame(frame); err != nil {
-			return fmt.Errorf("failed to write chunk: %w", err)
+	// STREAM_END: Close every stream the handler left open, written together with the END
+	var frames []*Frame
+	for _, stream := range append([]*responseStream{e.primary}, e.opened...) {
+		if !stream.started || stream.closed {
+			continue
 		}
+		frames = append(frames, e.streamEnd(stream))
+	}
+
+	// END: Close the entire request
+	endFrame := NewEnd(e.requestID, nil)
+	endFrame.RoutingId = e.routingId
+	if err := e.writer.WriteFrames(append(frames, endFrame)...); err != nil {
+		e.logger.Error("failed to write END", "req_id", e.requestID.ToString(), "error", err)
+	}
+}
+
+func (e *threadSafeEmitter) EmitLog(level, message string) {
+	e.EmitLogAttrs(level, message)
+}
+
+func (e *threadSafeEmitter) EmitLogAttrs(level, message string, attrs ...any) {
+	frame := NewLogWithAttrs(e.requestID, level, message, logAttrsToMap(attrs))
+	frame.RoutingId = e.routingId
+	if err := e.writer.WriteFrame(frame); err != nil {
+		e.logger.Error("failed to write LOG", "req_id", e.requestID.ToString(), "error", err)
+	}
+}
+
+func (e *threadSafeEmitter) EmitProgress(stage string, completed, total uint64) {
+	frame := NewProgress(e.requestID, stage, completed, total)
+	frame.RoutingId = e.routingId
+	if err := e.writer.WriteFrame(frame); err != nil {
+		e.logger.Error("failed to write progress", "req_id", e.requestID.ToString(), "error", err)
 	}
-
-	return nil
 }
 
-// Finalize sends STREAM_END + END frames to complete the response
-func (e *threadSafeEmitter) Finalize() {
+func (e *threadSafeEmitter) TextWriter() TextWriter {
 	e.seqMu.Lock()
 	defer e.seqMu.Unlock()
-
-	// If no chunks were sent, still send STREAM_START to keep protocol consistent
-	if !e.streamStarted {
-		e.streamStarted = true
-		startFrame := NewStreamStart(e.requestID, e.streamID, e.mediaUrn)
-		startFrame.RoutingId = e.routingId
-		if err := e.writer.WriteFrame(startFrame); err != nil {
-			fmt.Fprintf(os.Stderr, "[PluginRuntime] Failed to write STREAM_START: %v\n", err)
-			return
-		}
+	if e.text == nil {
+		e.text = newTextWriter(e.EmitText, DefaultTextBatch)
 	}
+	return e.text
+}
 
-	// STREAM_END: Close this stream
-	streamEndFrame := NewStreamEnd(e.requestID, e.streamID, e.chunkIndex)
-	streamEndFrame.RoutingId = e.routingId
-	if err := e.writer.WriteFrame(streamEndFrame); err != nil {
-		fmt.Fprintf(os.Stderr, "[PluginRuntime] Failed to write STREAM_END: %v\n", err)
-		return
-	}
+// emitterOutputStream is the OutputStream handed out by threadSafeEmitter.OpenStream
+type emitterOutputStream struct {
+	emitter *threadSafeEmitter
+	stream  *responseStream
+}
 
-	// END: Close the entire request
-	endFrame := NewEnd(e.requestID, nil)
-	endFrame.RoutingId = e.routingId
-	if err := e.writer.WriteFrame(endFrame); err != nil {
-		fmt.Fprintf(os.Stderr, "[PluginRuntime] Failed to write END: %v\n", err)
+func (s *emitterOutputStream) StreamId() string {
+	return s.stream.streamID
+}
+
+func (s *emitterOutputStream) EmitCbor(value interface{}) error {
+	s.emitter.seqMu.Lock()
+	defer s.emitter.seqMu.Unlock()
+
+	if err := s.emitter.open(); err != nil {
+		return err
 	}
+	return s.emitter.emitToStream(s.stream, value)
 }
 
-func (e *threadSafeEmitter) EmitLog(level, message string) {
-	frame := NewLog(e.requestID, level, message)
-	frame.RoutingId = e.routingId
-	if err := e.writer.WriteFrame(frame); err != nil {
-		fmt.Fprintf(os.Stderr, "[PluginRuntime] Failed to write log: %v\n", err)
+func (s *emitterOutputStream) Close() error {
+	s.emitter.seqMu.Lock()
+	defer s.emitter.seqMu.Unlock()
+
+	if s.stream.closed {
+		return nil
+	}
+	if err := s.emitter.open(); err != nil {
+		return err
 	}
+	return s.emitter.closeStream(s.stream)
 }
 
 // cliStreamEmitter implements StreamEmitter for CLI mode
-type cliStreamEmitter struct{}
+type cliStreamEmitter struct {
+	out     io.Writer // Result destination (stdout or --output file)
+	logOut  io.Writer // LOG lines (stderr)
+	format  string    // CLIFormatRaw, CLIFormatJSON or CLIFormatCBOR
+	quiet   bool      // Suppress LOG lines
+	aborted error     // Error given to Abort, reported as the command's failure
+}
+
+func newCLIStreamEmitter(out, logOut io.Writer, format string, quiet bool) *cliStreamEmitter {
+	return &cliStreamEmitter{out: out, logOut: logOut, format: format, quiet: quiet}
+}
 
 func (e *cliStreamEmitter) EmitCbor(value interface{}) error {
-	// In CLI mode: extract raw bytes/text from value and emit to stdout
+	if e.aborted != nil {
+		return ErrResponseEnded
+	}
+
+	// Structured formats serialize every value as-is
+	if e.format == CLIFormatJSON || e.format == CLIFormatCBOR {
+		encoded, err := encodeCLIValue(e.format, value)
+		if err != nil {
+			return err
+		}
+		_, err = e.out.Write(encoded)
+		return err
+	}
+
+	// Raw format: extract raw bytes/text from value and emit to stdout
 	// Supported types: []byte, string, map (extract "value" field)
 	// NO FALLBACK - fail hard if unsupported type
 
 	switch v := value.(type) {
 	case []byte:
 		// Raw bytes - write directly
-		os.Stdout.Write(v)
+		if _, err := e.out.Write(v); err != nil {
+			return err
+		}
 	case string:
 		// Text - write as bytes
-		os.Stdout.WriteString(v)
+		if _, err := io.WriteString(e.out, v); err != nil {
+			return err
+		}
 	case map[string]interface{}:
 		// Map - extract "value" field
 		if val, ok := v["value"]; ok {
@@ -1317,8 +2212,105 @@ func (e *cliStreamEmitter) EmitCbor(value interface{}) error {
 	return nil
 }
 
+// EmitJSON in CLI mode writes the JSON document; the CBOR format encodes the value it
+// describes
+func (e *cliStreamEmitter) EmitJSON(v interface{}) error {
+	if e.aborted != nil {
+		return ErrResponseEnded
+	}
+	document, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to JSON-encode value: %w", err)
+	}
+	if e.format == CLIFormatCBOR {
+		var value interface{}
+		if err := json.Unmarshal(document, &value); err != nil {
+			return err
+		}
+		return e.EmitCbor(value)
+	}
+	if e.format == CLIFormatJSON {
+		document = append(document, '\n')
+	}
+	_, err = e.out.Write(document)
+	return err
+}
+
+// EmitSeq in CLI mode writes each element as EmitCbor does, as it is produced
+func (e *cliStreamEmitter) EmitSeq(produce func(yield func(v interface{}) error) error) error {
+	return produce(e.EmitCbor)
+}
+
+func (e *cliStreamEmitter) EmitText(s string) error {
+	return e.EmitCbor(s)
+}
+
+func (e *cliStreamEmitter) EmitBytes(b []byte) error {
+	return e.EmitCbor(b)
+}
+
 func (e *cliStreamEmitter) EmitLog(level, message string) {
-	fmt.Fprintf(os.Stderr, "[%s] %s\n", level, message)
+	e.EmitLogAttrs(level, message)
+}
+
+func (e *cliStreamEmitter) EmitLogAttrs(level, message string, attrs ...any) {
+	if e.quiet {
+		return
+	}
+	fmt.Fprintf(e.logOut, "[%s] %s%s\n", level, message, formatLogAttrs(attrs))
+}
+
+// EmitProgress in CLI mode writes a LOG line with the stage and its progress
+func (e *cliStreamEmitter) EmitProgress(stage string, completed, total uint64) {
+	if e.quiet {
+		return
+	}
+	progress := Progress{Stage: stage, Completed: completed, Total: total}
+	if percent := progress.Percent(); percent >= 0 {
+		fmt.Fprintf(e.logOut, "[progress] %s %d/%d (%.0f%%)\n", stage, completed, total, percent)
+		return
+	}
+	fmt.Fprintf(e.logOut, "[progress] %s %d\n", stage, completed)
+}
+
+// TextWriter in CLI mode writes text as it comes, stdout having no CHUNKs to split
+func (e *cliStreamEmitter) TextWriter() TextWriter {
+	return &directTextWriter{emit: e.EmitText}
+}
+
+// Abort in CLI mode makes err the command's failure; the output already written stays
+func (e *cliStreamEmitter) Abort(err error) error {
+	if err == nil {
+		return errors.New("abort requires an error")
+	}
+	if e.aborted != nil {
+		return ErrResponseEnded
+	}
+	e.aborted = err
+	return nil
+}
+
+// OpenStream in CLI mode returns a stream that writes to stdout like the primary output
+func (e *cliStreamEmitter) OpenStream(mediaUrn string) (OutputStream, error) {
+	return &cliOutputStream{emitter: e, streamID: mediaUrn}, nil
+}
+
+// cliOutputStream implements OutputStream for CLI mode
+type cliOutputStream struct {
+	emitter  *cliStreamEmitter
+	streamID string
+}
+
+func (s *cliOutputStream) StreamId() string {
+	return s.streamID
+}
+
+func (s *cliOutputStream) EmitCbor(value interface{}) error {
+	return s.emitter.EmitCbor(value)
+}
+
+func (s *cliOutputStream) Close() error {
+	return nil
 }
 
 // pendingPeerRequest tracks a pending peer request.
@@ -1327,35 +2319,81 @@ type pendingPeerRequest struct {
 	sender  chan Frame        // Channel to send response frames to handler
 	streams map[string]string // stream_id → media_urn mapping
 	ended   bool              // true after END frame (close channel)
+	span    Span              // Peer invocation span, ended on END/ERR (nil without tracer)
+	limiter *peerLimiter      // Released on END/ERR
+}
+
+// finish ends the span of a peer request removed from the pending requests and frees
+// its in-flight slot
+func (r *pendingPeerRequest) finish(err error) {
+	endSpan(r.span, err)
+	r.limiter.release()
 }
 
 // peerInvokerImpl implements PeerInvoker
 type peerInvokerImpl struct {
+	ctx             context.Context // Handler context - parent of peer invocation spans
 	writer          *syncFrameWriter
 	pendingRequests *sync.Map
+	limiter         *peerLimiter
+	permissions     capPermissions // Host caps the manifest permits invoking (see CapManifest.Permissions)
 	maxChunk        int
+	tracer          Tracer
 }
 
-func newPeerInvokerImpl(writer *syncFrameWriter, pendingRequests *sync.Map, maxChunk int) *peerInvokerImpl {
+func newPeerInvokerImpl(ctx context.Context, writer *syncFrameWriter, pendingRequests *sync.Map, limiter *peerLimiter, permissions capPermissions, maxChunk int, tracer Tracer) *peerInvokerImpl {
 	return &peerInvokerImpl{
+		ctx:             ctx,
 		writer:          writer,
 		pendingRequests: pendingRequests,
+		limiter:         limiter,
+		permissions:     permissions,
 		maxChunk:        maxChunk,
+		tracer:          tracer,
 	}
 }
 
 func (p *peerInvokerImpl) Invoke(capUrn string, arguments []cap.CapArgumentValue) (<-chan Frame, error) {
+	_, frames, err := p.InvokeWithId(capUrn, arguments)
+	return frames, err
+}
+
+func (p *peerInvokerImpl) InvokeWithId(capUrn string, arguments []cap.CapArgumentValue) (MessageId, <-chan Frame, error) {
+	return p.invoke(capUrn, arguments, "")
+}
+
+// invoke sends a peer request, carrying idempotencyKey unless it is empty
+func (p *peerInvokerImpl) invoke(capUrn string, arguments []cap.CapArgumentValue, idempotencyKey string) (MessageId, <-chan Frame, error) {
+	// Caps the manifest doesn't permit are rejected without asking the host
+	if err := p.permissions.check(capUrn); err != nil {
+		return MessageId{}, nil, err
+	}
+
+	// Wait for a slot under the in-flight limit (see SetPeerLimits)
+	if err := p.limiter.acquire(p.ctx); err != nil {
+		return MessageId{}, nil, err
+	}
+
 	// Generate a new message ID for this request
 	requestID := NewMessageIdRandom()
 
 	// Create a buffered channel for response frames
-	sender := make(chan Frame, 64)
+	sender := make(chan Frame, p.limiter.bufferSize())
+
+	// Child span of the handler span; its context travels in the REQ along with the
+	// handler's remaining deadline
+	spanCtx, span := startTracedSpan(p.ctx, p.tracer, "bifaci.peer "+capUrn, TraceContextFromContext(p.ctx), map[string]string{
+		"bifaci.cap":    capUrn,
+		"bifaci.req_id": requestID.ToString(),
+	})
 
 	// Register the pending request before sending
 	p.pendingRequests.Store(requestID.ToString(), &pendingPeerRequest{
 		sender:  sender,
 		streams: make(map[string]string),
 		ended:   false,
+		span:    span,
+		limiter: p.limiter,
 	})
 
 	maxChunk := p.maxChunk
@@ -1364,9 +2402,16 @@ func (p *peerInvokerImpl) Invoke(capUrn string, arguments []cap.CapArgumentValue
 
 	// 1. REQ with empty payload
 	reqFrame := NewReq(requestID, capUrn, nil, "application/cbor")
+	reqFrame.SetTraceContext(TraceContextFromContext(spanCtx))
+	if deadline, ok := p.ctx.Deadline(); ok {
+		reqFrame.SetDeadline(time.Until(deadline))
+	}
+	if idempotencyKey != "" {
+		reqFrame.SetIdempotencyKey(idempotencyKey)
+	}
 	if err := p.writer.WriteFrame(reqFrame); err != nil {
-		p.pendingRequests.Delete(requestID.ToString())
-		return nil, fmt.Errorf("failed to send REQ frame: %w", err)
+		p.abandon(requestID, err)
+		return MessageId{}, nil, fmt.Errorf("failed to send REQ frame: %w", err)
 	}
 
 	// 2. Each argument as an independent stream
@@ -1376,8 +2421,8 @@ func (p *peerInvokerImpl) Invoke(capUrn string, arguments []cap.CapArgumentValue
 		// STREAM_START
 		startFrame := NewStreamStart(requestID, streamID, arg.MediaUrn)
 		if err := p.writer.WriteFrame(startFrame); err != nil {
-			p.pendingRequests.Delete(requestID.ToString())
-			return nil, fmt.Errorf("failed to send STREAM_START: %w", err)
+			p.abandon(requestID, err)
+			return MessageId{}, nil, fmt.Errorf("failed to send STREAM_START: %w", err)
 		}
 
 		// CHUNK(s): Send argument data as CBOR-encoded chunks
@@ -1395,15 +2440,14 @@ func (p *peerInvokerImpl) Invoke(capUrn string, arguments []cap.CapArgumentValue
 			// CBOR-encode chunk as []byte - independently decodable
 			cborPayload, err := cborlib.Marshal(chunkBytes)
 			if err != nil {
-				p.pendingRequests.Delete(requestID.ToString())
-				return nil, fmt.Errorf("failed to encode chunk: %w", err)
+				p.abandon(requestID, err)
+				return MessageId{}, nil, fmt.Errorf("failed to encode chunk: %w", err)
 			}
 
-			checksum := ComputeChecksum(cborPayload)
-			chunkFrame := NewChunk(requestID, streamID, seq, cborPayload, chunkIndex, checksum)
+			chunkFrame := p.writer.newChunk(requestID, streamID, seq, cborPayload, chunkIndex)
 			if err := p.writer.WriteFrame(chunkFrame); err != nil {
-				p.pendingRequests.Delete(requestID.ToString())
-				return nil, fmt.Errorf("failed to send CHUNK: %w", err)
+				p.abandon(requestID, err)
+				return MessageId{}, nil, fmt.Errorf("failed to send CHUNK: %w", err)
 			}
 			offset += chunkSize
 			seq++
@@ -1413,19 +2457,49 @@ func (p *peerInvokerImpl) Invoke(capUrn string, arguments []cap.CapArgumentValue
 		// STREAM_END
 		endFrame := NewStreamEnd(requestID, streamID, chunkIndex)
 		if err := p.writer.WriteFrame(endFrame); err != nil {
-			p.pendingRequests.Delete(requestID.ToString())
-			return nil, fmt.Errorf("failed to send STREAM_END: %w", err)
+			p.abandon(requestID, err)
+			return MessageId{}, nil, fmt.Errorf("failed to send STREAM_END: %w", err)
 		}
 	}
 
 	// 3. END
 	endFrame := NewEnd(requestID, nil)
 	if err := p.writer.WriteFrame(endFrame); err != nil {
-		p.pendingRequests.Delete(requestID.ToString())
-		return nil, fmt.Errorf("failed to send END: %w", err)
+		p.abandon(requestID, err)
+		return MessageId{}, nil, fmt.Errorf("failed to send END: %w", err)
+	}
+
+	return requestID, sender, nil
+}
+
+// abandon unregisters a peer request that could not be sent and ends its span
+func (p *peerInvokerImpl) abandon(requestID MessageId, err error) {
+	if pending, ok := p.pendingRequests.LoadAndDelete(requestID.ToString()); ok {
+		pending.(*pendingPeerRequest).finish(err)
+	}
+}
+
+// Cancel sends a CANCEL frame for a pending peer request. The pending entry stays
+// registered so the peer's terminal frame still closes the response channel.
+func (p *peerInvokerImpl) Cancel(requestID MessageId) error {
+	if _, ok := p.pendingRequests.Load(requestID.ToString()); !ok {
+		return fmt.Errorf("no pending peer request with id %s", requestID.ToString())
+	}
+	if err := p.writer.WriteFrame(NewCancel(requestID)); err != nil {
+		return fmt.Errorf("failed to send CANCEL: %w", err)
 	}
+	return nil
+}
 
-	return sender, nil
+func (p *peerInvokerImpl) InvokeWithPolicy(capUrn string, arguments []cap.CapArgumentValue, policy RetryPolicy) (*PeerResponse, error) {
+	key := NewMessageIdRandom().ToString()
+	return retryPeerCall(p.ctx, policy, func() (*PeerResponse, error) {
+		requestID, frames, err := p.invoke(capUrn, arguments, key)
+		if err != nil {
+			return nil, err
+		}
+		return awaitPeerResponse(p.ctx, p, requestID, frames)
+	})
 }
 
 // noPeerInvoker is a no-op PeerInvoker that always returns an error
@@ -1435,6 +2509,18 @@ func (n *noPeerInvoker) Invoke(capUrn string, arguments []cap.CapArgumentValue)
 	return nil, errors.New("peer invocation not supported in this context")
 }
 
+func (n *noPeerInvoker) InvokeWithId(capUrn string, arguments []cap.CapArgumentValue) (MessageId, <-chan Frame, error) {
+	return MessageId{}, nil, errors.New("peer invocation not supported in this context")
+}
+
+func (n *noPeerInvoker) Cancel(requestID MessageId) error {
+	return errors.New("peer invocation not supported in this context")
+}
+
+func (n *noPeerInvoker) InvokeWithPolicy(capUrn string, arguments []cap.CapArgumentValue, policy RetryPolicy) (*PeerResponse, error) {
+	return nil, errors.New("peer invocation not supported in this context")
+}
+
 // Limits returns the current protocol limits
 func (pr *PluginRuntime) Limits() Limits {
 	pr.mu.RLock()
@@ -1442,6 +2528,14 @@ func (pr *PluginRuntime) Limits() Limits {
 	return pr.limits
 }
 
+// Manifest returns the parsed manifest, or nil if the manifest JSON could not be parsed.
+// The manifest returned isn't modified by AddCap or RemoveCap, which replace it.
+func (pr *PluginRuntime) Manifest() *CapManifest {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+	return pr.manifest
+}
+
 // buildPayloadFromStreamingReader builds CBOR payload from streaming reader (testable version).
 //
 // This simulates the CBOR chunked request flow for CLI piped stdin:
@@ -1512,12 +2606,19 @@ func (pr *PluginRuntime) buildPayloadFromStreamingReader(capDef *cap.Cap, reader
 // buildPayloadFromCLI builds CBOR payload from CLI arguments based on cap's arg definitions.
 // Returns CBOR-encoded array of cap.CapArgumentValue objects.
 func (pr *PluginRuntime) buildPayloadFromCLI(capDef *cap.Cap, cliArgs []string) ([]byte, error) {
-	// Read stdin if available (non-blocking check)
-	stdinData, err := pr.readStdinIfAvailable()
+	var stdinData []byte
+	stdin, err := cliStdin(capDef, false)
+	if err == nil && stdin != nil {
+		stdinData, err = io.ReadAll(stdin)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to read stdin: %w", err)
 	}
+	return pr.buildPayloadFromCLIArgs(capDef, cliArgs, stdinData)
+}
 
+// buildPayloadFromCLIArgs builds the payload from CLI args and already-read stdin data
+func (pr *PluginRuntime) buildPayloadFromCLIArgs(capDef *cap.Cap, cliArgs []string, stdinData []byte) ([]byte, error) {
 	// If no args defined, check for stdin data
 	if len(capDef.Args) == 0 {
 		if stdinData != nil {
@@ -1528,55 +2629,40 @@ func (pr *PluginRuntime) buildPayloadFromCLI(capDef *cap.Cap, cliArgs []string)
 	}
 
 	// Build CBOR arguments array (same format as CBOR mode)
-	var arguments []cap.CapArgumentValue
+	var arguments []cliArgument
+	cliArgs, err := normalizeCLIArgs(capDef, cliArgs)
+	if err != nil {
+		return nil, err
+	}
 
 	for i := range capDef.Args {
 		argDef := &capDef.Args[i]
+		if isOutputArg(argDef) {
+			continue // The runtime writes the result to it
+		}
 
-		// Extract argument value (handles file-path conversion)
-		value, err := pr.extractArgValue(argDef, cliArgs, stdinData)
+		source, err := pr.findArgSource(argDef, cliArgs, len(stdinData) > 0)
 		if err != nil {
 			return nil, err
 		}
-
-		if value != nil {
-			// Determine media URN for this argument value
-			// If file-path with stdin source, use stdin source's media URN (the target type)
-			mediaUrn := argDef.MediaUrn
-
-			// Check if this is a file-path arg using pattern matching
-			argMediaUrn, parseErr := urn.NewMediaUrnFromString(argDef.MediaUrn)
-			if parseErr == nil {
-				filePathPattern, _ := urn.NewMediaUrnFromString(MediaFilePath)
-				filePathArrayPattern, _ := urn.NewMediaUrnFromString(MediaFilePathArray)
-
-				// Pattern matching: check if patterns accept this instance
-				isFilePath := false
-				if filePathArrayPattern != nil && filePathArrayPattern.Accepts(argMediaUrn) {
-					isFilePath = true
-				} else if filePathPattern != nil && filePathPattern.Accepts(argMediaUrn) {
-					isFilePath = true
-				}
-
-				// If file-path type, check for stdin source and use its media URN
-				if isFilePath {
-					for i := range argDef.Sources {
-						source := &argDef.Sources[i]
-						if source.Stdin != nil {
-							mediaUrn = *source.Stdin
-							break
-						}
-					}
-				}
+		if source == nil {
+			if argDef.Required {
+				return nil, missingArgError(argDef)
 			}
+			continue
+		}
 
-			arguments = append(arguments, cap.CapArgumentValue{
-				MediaUrn: mediaUrn,
-				Value:    value,
-			})
-		} else if argDef.Required {
-			return nil, fmt.Errorf("required argument missing: %s", argDef.MediaUrn)
+		// Read the argument's file(s) or stdin, or type its value
+		var value interface{}
+		if source.path != "" || source.stdin {
+			value, err = pr.readArgSource(source, stdinData)
+		} else {
+			value, err = typedCLIValue(capDef, argDef, source)
+		}
+		if err != nil {
+			return nil, err
 		}
+		arguments = append(arguments, cliArgument{mediaUrn: cliArgMediaUrn(argDef), value: value})
 	}
 
 	if len(arguments) > 0 {
@@ -1584,8 +2670,8 @@ func (pr *PluginRuntime) buildPayloadFromCLI(capDef *cap.Cap, cliArgs []string)
 		cborArgs := make([]interface{}, len(arguments))
 		for i, arg := range arguments {
 			cborArgs[i] = map[string]interface{}{
-				"media_urn": arg.MediaUrn,
-				"value":     arg.Value,
+				"media_urn": arg.mediaUrn,
+				"value":     arg.value,
 			}
 		}
 
@@ -1600,9 +2686,75 @@ func (pr *PluginRuntime) buildPayloadFromCLI(capDef *cap.Cap, cliArgs []string)
 	return []byte{}, nil
 }
 
+// cliArgMediaUrn returns the media URN an argument's value is sent under: that of its
+// stdin source for a file-path argument, whose files' contents are sent (the target
+// type), else the argument's own
+func cliArgMediaUrn(argDef *cap.CapArg) string {
+	argMediaUrn, err := urn.NewMediaUrnFromString(argDef.MediaUrn)
+	if err != nil {
+		return argDef.MediaUrn
+	}
+	filePathPattern, _ := urn.NewMediaUrnFromString(MediaFilePath)
+	filePathArrayPattern, _ := urn.NewMediaUrnFromString(MediaFilePathArray)
+
+	// Pattern matching: check if patterns accept this instance
+	isFilePath := false
+	if filePathArrayPattern != nil && filePathArrayPattern.Accepts(argMediaUrn) {
+		isFilePath = true
+	} else if filePathPattern != nil && filePathPattern.Accepts(argMediaUrn) {
+		isFilePath = true
+	}
+	if isFilePath {
+		for i := range argDef.Sources {
+			if source := &argDef.Sources[i]; source.Stdin != nil {
+				return *source.Stdin
+			}
+		}
+	}
+	return argDef.MediaUrn
+}
+
 // extractArgValue extracts a single argument value from CLI args or stdin.
 // Handles automatic file-path to bytes conversion when appropriate.
 func (pr *PluginRuntime) extractArgValue(argDef *cap.CapArg, cliArgs []string, stdinData []byte) ([]byte, error) {
+	source, err := pr.findArgSource(argDef, cliArgs, len(stdinData) > 0)
+	if err != nil || source == nil {
+		return nil, err
+	}
+	return pr.readArgSource(source, stdinData)
+}
+
+// readArgSource returns the value of an argument from source: its file(s) read, stdin,
+// or the value or list of values itself
+func (pr *PluginRuntime) readArgSource(source *cliArgSource, stdinData []byte) ([]byte, error) {
+	switch {
+	case source.path != "":
+		return pr.readFilePaths(source.path, source.isArray, source.withMeta)
+	case source.stdin:
+		return stdinData, nil
+	case source.list != nil:
+		// As the JSON array it could have been given as
+		return json.Marshal(source.list)
+	default:
+		return source.value, nil
+	}
+}
+
+// cliArgSource is where a CLI argument's value comes from
+type cliArgSource struct {
+	value     []byte   // The value itself, when neither path nor stdin is set
+	isDefault bool     // value is the JSON of the argument's default value
+	list      []string // The values of a list argument given one by one, when value is not set
+	path      string   // A file-path argument whose file(s) are read for their contents
+	isArray   bool     // path is a JSON array of path patterns (media:file-path-array)
+	withMeta  bool     // path's files are sent with their metadata (see MediaTagWithMeta)
+	stdin     bool     // The value is read from stdin
+}
+
+// findArgSource finds the source of an argument's value, trying its sources in order,
+// then its default value. nil means the argument has no value. haveStdin tells whether
+// stdin carries data.
+func (pr *PluginRuntime) findArgSource(argDef *cap.CapArg, cliArgs []string, haveStdin bool) (*cliArgSource, error) {
 	// Check if this arg requires file-path to bytes conversion using pattern matching
 	argMediaUrn, err := urn.NewMediaUrnFromString(argDef.MediaUrn)
 	if err != nil {
@@ -1637,33 +2789,77 @@ func (pr *PluginRuntime) extractArgValue(argDef *cap.CapArg, cliArgs []string, s
 		}
 	}
 
+	// fromValue is the source of a value given on the command line
+	fromValue := func(value string) *cliArgSource {
+		// If file-path type with stdin source, read file(s)
+		if isFilePath && hasStdinSource {
+			return &cliArgSource{path: value, isArray: isArray, withMeta: argMediaUrn.HasTag(MediaTagWithMeta)}
+		}
+		return &cliArgSource{value: []byte(value)}
+	}
+
+	// fromValues is the source of the values of a list argument given on the command
+	// line: its repeated flag or the positional args from its position on. A single
+	// JSON array is taken as the whole list; for file paths, so is a single value that
+	// starts like one, so that a malformed array is reported rather than read as a path.
+	fromValues := func(values []string) *cliArgSource {
+		if len(values) == 1 && isJSONArray(values[0]) {
+			return fromValue(values[0])
+		}
+		if isFilePath && hasStdinSource {
+			if len(values) == 1 && strings.HasPrefix(strings.TrimSpace(values[0]), "[") {
+				return fromValue(values[0])
+			}
+			patterns, _ := json.Marshal(values)
+			return fromValue(string(patterns))
+		}
+		return &cliArgSource{list: values}
+	}
+	isList := argMediaUrn.IsList()
+
 	// Try each source in order
 	for i := range argDef.Sources {
 		source := &argDef.Sources[i]
 
 		if source.CliFlag != nil {
-			if value, found := pr.getCliFlagValue(cliArgs, *source.CliFlag); found {
-				// If file-path type with stdin source, read file(s)
-				if isFilePath && hasStdinSource {
-					return pr.readFilePathToBytes(value, isArray)
+			if isList {
+				if values := pr.getCliFlagValues(cliArgs, *source.CliFlag); len(values) > 0 {
+					return fromValues(values), nil
 				}
-				return []byte(value), nil
+			} else if value, found := pr.getCliFlagValue(cliArgs, *source.CliFlag); found {
+				return fromValue(value), nil
 			}
 		} else if source.Position != nil {
 			// Positional args: filter out flags and their values
 			positional := pr.getPositionalArgs(cliArgs)
 			if *source.Position < len(positional) {
-				value := positional[*source.Position]
-				// If file-path type with stdin source, read file(s)
-				if isFilePath && hasStdinSource {
-					return pr.readFilePathToBytes(value, isArray)
+				if isList {
+					return fromValues(positional[*source.Position:]), nil
 				}
-				return []byte(value), nil
+				return fromValue(positional[*source.Position]), nil
 			}
 		} else if source.Stdin != nil {
-			if stdinData != nil && len(stdinData) > 0 {
-				return stdinData, nil
+			if haveStdin {
+				return &cliArgSource{stdin: true}, nil
+			}
+		} else if source.Env != nil {
+			if value, found := os.LookupEnv(*source.Env); found {
+				return fromValue(value), nil
+			}
+		}
+	}
+
+	// Then the config file, which the command line and environment override
+	pr.mu.RLock()
+	config := pr.configFile
+	pr.mu.RUnlock()
+	if key := argDef.GetConfig(); key != nil && config != nil {
+		if value, found := config.lookup(*key); found {
+			text, err := config.text(value, isFilePath)
+			if err != nil {
+				return nil, fmt.Errorf("config key %s: %w", *key, err)
 			}
+			return fromValue(text), nil
 		}
 	}
 
@@ -1673,7 +2869,12 @@ func (pr *PluginRuntime) extractArgValue(argDef *cap.CapArg, cliArgs []string, s
 		if err != nil {
 			return nil, fmt.Errorf("failed to serialize default value: %w", err)
 		}
-		return bytes, nil
+		return &cliArgSource{value: bytes, isDefault: true}, nil
+	}
+
+	// A boolean flag not given is false
+	if isBoolFlag(argDef) {
+		return &cliArgSource{value: []byte("false")}, nil
 	}
 
 	return nil, nil
@@ -1682,6 +2883,9 @@ func (pr *PluginRuntime) extractArgValue(argDef *cap.CapArg, cliArgs []string, s
 // getCliFlagValue gets the value for a CLI flag (e.g., --model "value")
 func (pr *PluginRuntime) getCliFlagValue(args []string, flag string) (string, bool) {
 	for i := 0; i < len(args); i++ {
+		if args[i] == "--" {
+			break // Only positional args follow
+		}
 		if args[i] == flag {
 			if i+1 < len(args) {
 				return args[i+1], true
@@ -1696,16 +2900,43 @@ func (pr *PluginRuntime) getCliFlagValue(args []string, flag string) (string, bo
 	return "", false
 }
 
+// getCliFlagValues gets the values of a CLI flag given any number of times
+// (e.g., --file a --file b)
+func (pr *PluginRuntime) getCliFlagValues(args []string, flag string) []string {
+	var values []string
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" {
+			break // Only positional args follow
+		}
+		if args[i] == flag {
+			if i+1 < len(args) {
+				values = append(values, args[i+1])
+				i++
+			}
+			continue
+		}
+		// Handle --flag=value format
+		if len(args[i]) > len(flag) && args[i][:len(flag)] == flag && args[i][len(flag)] == '=' {
+			values = append(values, args[i][len(flag)+1:])
+		}
+	}
+	return values
+}
+
 // getPositionalArgs gets positional arguments (non-flag arguments)
 func (pr *PluginRuntime) getPositionalArgs(args []string) []string {
 	var positional []string
 	skipNext := false
 
-	for _, arg := range args {
+	for i, arg := range args {
 		if skipNext {
 			skipNext = false
 			continue
 		}
+		if arg == "--" {
+			// Every arg after "--" is positional (see normalizeCLIArgs)
+			return append(positional, args[i+1:]...)
+		}
 		if len(arg) > 0 && arg[0] == '-' {
 			// This is a flag - skip its value too if not --flag=value format
 			if !contains(arg, '=') {
@@ -1728,47 +2959,34 @@ func contains(s string, c byte) bool {
 	return false
 }
 
-// readStdinIfAvailable reads stdin if data is available (non-blocking check).
-// Returns nil immediately if stdin is a terminal or no data is ready.
-func (pr *PluginRuntime) readStdinIfAvailable() ([]byte, error) {
-	// Check if stdin is a terminal (interactive)
-	stat, err := os.Stdin.Stat()
-	if err != nil {
-		return nil, err
+// cliStdin returns the stdin of a CLI invocation of capDef, nil if it takes none: with
+// --no-stdin, when stdin is a terminal or another character device such as /dev/null,
+// when it is an empty file, or when no argument of the cap declares a stdin source (a
+// cap without arguments reads its CBOR arguments array from stdin). Stdin isn't probed
+// for data: a pipe is read, blocking, until the writer closes it.
+func cliStdin(capDef *cap.Cap, noStdin bool) (io.Reader, error) {
+	if noStdin {
+		return nil, nil
 	}
-
-	// Don't read from stdin if it's a terminal (interactive)
-	if (stat.Mode() & os.ModeCharDevice) != 0 {
+	takesStdin := len(capDef.Args) == 0
+	for i := range capDef.Args {
+		if capDef.Args[i].HasStdinSource() {
+			takesStdin = true
+			break
+		}
+	}
+	if !takesStdin {
 		return nil, nil
 	}
 
-	// Non-blocking check: try reading with immediate timeout
-	// Use a goroutine with select and timeout to avoid blocking
-	type result struct {
-		data []byte
-		err  error
+	stat, err := os.Stdin.Stat()
+	if err != nil {
+		return nil, err
 	}
-
-	done := make(chan result, 1)
-	go func() {
-		data, err := io.ReadAll(os.Stdin)
-		done <- result{data, err}
-	}()
-
-	// Wait up to 100ms for data - if nothing arrives, assume no stdin data
-	select {
-	case res := <-done:
-		if res.err != nil {
-			return nil, res.err
-		}
-		if len(res.data) == 0 {
-			return nil, nil
-		}
-		return res.data, nil
-	case <-time.After(100 * time.Millisecond):
-		// No data ready - return nil immediately
+	if stat.Mode()&os.ModeCharDevice != 0 || (stat.Mode().IsRegular() && stat.Size() == 0) {
 		return nil, nil
 	}
+	return os.Stdin, nil
 }
 
 // readFilePathToBytes reads file(s) for file-path arguments and returns bytes.
@@ -1779,7 +2997,7 @@ func (pr *PluginRuntime) readStdinIfAvailable() ([]byte, error) {
 //
 // # Arguments
 // * pathValue - File path string (single path or JSON array of path patterns)
-// * isArray - True if media:file-path-array (read multiple files with glob expansion)
+// * isArray - True if media:file-path-array (read multiple files, see SetFileExpansion)
 //
 // # Returns
 // - For single file: []byte containing raw file bytes
@@ -1788,6 +3006,16 @@ func (pr *PluginRuntime) readStdinIfAvailable() ([]byte, error) {
 // # Errors
 // Returns error if file cannot be read with clear error message.
 func (pr *PluginRuntime) readFilePathToBytes(pathValue string, isArray bool) ([]byte, error) {
+	return pr.readFilePaths(pathValue, isArray, false)
+}
+
+// readFilePaths is readFilePathToBytes sending each file as a CBOR-encoded FileMeta if
+// withMeta (see MediaTagWithMeta)
+func (pr *PluginRuntime) readFilePaths(pathValue string, isArray bool, withMeta bool) ([]byte, error) {
+	pr.mu.RLock()
+	policy := pr.fileAccess
+	pr.mu.RUnlock()
+
 	if isArray {
 		// Parse JSON array of path patterns
 		var pathPatterns []string
@@ -1798,62 +3026,42 @@ func (pr *PluginRuntime) readFilePathToBytes(pathValue string, isArray bool) ([]
 			)
 		}
 
-		// Expand globs and collect all file paths
-		var allFiles []string
-		for _, pattern := range pathPatterns {
-			// Check if this is a literal path (no glob metacharacters) or a glob pattern
-			isGlob := containsAny(pattern, "*?[")
+		pr.mu.RLock()
+		expansion := pr.fileExpansion
+		pr.mu.RUnlock()
+
+		// Expand directories and globs, less exclusions, into the files to read
+		allFiles, err := expandFilePaths(pathPatterns, expansion.Recursive)
+		if err != nil {
+			return nil, err
+		}
 
-			if !isGlob {
-				// Literal path - verify it exists and is a file
-				info, err := os.Stat(pattern)
+		// Read each file sequentially
+		var filesData []interface{}
+		for _, path := range allFiles {
+			if withMeta {
+				file, err := readFileMeta(path, policy)
 				if err != nil {
-					if os.IsNotExist(err) {
-						return nil, fmt.Errorf(
-							"failed to read file '%s' from file-path-array: No such file or directory",
-							pattern,
-						)
-					}
 					return nil, fmt.Errorf(
 						"failed to read file '%s' from file-path-array: %w",
-						pattern, err,
+						path, err,
 					)
 				}
-				if info.Mode().IsRegular() {
-					allFiles = append(allFiles, pattern)
-				}
-				// Skip directories silently for consistency with glob behavior
-			} else {
-				// Glob pattern - expand it
-				matches, err := filepath.Glob(pattern)
-				if err != nil {
-					return nil, fmt.Errorf("invalid glob pattern '%s': %w", pattern, err)
-				}
-
-				for _, path := range matches {
-					info, err := os.Stat(path)
-					if err != nil {
-						continue
-					}
-					// Only include files (skip directories)
-					if info.Mode().IsRegular() {
-						allFiles = append(allFiles, path)
-					}
-				}
+				filesData = append(filesData, file)
+				continue
 			}
-		}
-
-		// Read each file sequentially
-		var filesData []interface{}
-		for _, path := range allFiles {
-			bytes, err := os.ReadFile(path)
+			bytes, _, err := policy.readFile(path)
 			if err != nil {
 				return nil, fmt.Errorf(
 					"failed to read file '%s' from file-path-array: %w",
 					path, err,
 				)
 			}
-			filesData = append(filesData, bytes)
+			if expansion.WithNames {
+				filesData = append(filesData, map[string]interface{}{"name": path, "bytes": bytes})
+			} else {
+				filesData = append(filesData, bytes)
+			}
 		}
 
 		// Encode as CBOR array
@@ -1863,9 +3071,16 @@ func (pr *PluginRuntime) readFilePathToBytes(pathValue string, isArray bool) ([]
 		}
 
 		return cborBytes, nil
+	} else if withMeta {
+		// Single file path - read with its metadata
+		file, err := readFileMeta(pathValue, policy)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file '%s': %w", pathValue, err)
+		}
+		return cborlib.Marshal(file)
 	} else {
 		// Single file path - read and return raw bytes
-		bytes, err := os.ReadFile(pathValue)
+		bytes, _, err := policy.readFile(pathValue)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read file '%s': %w", pathValue, err)
 		}
@@ -1892,6 +3107,10 @@ func containsAny(s string, chars string) bool {
 // CollectStreams collects each stream individually into a slice of (mediaUrn, bytes) pairs.
 // Each stream's bytes are accumulated separately — NOT concatenated.
 // Use FindStream() helpers to retrieve args by URN pattern matching.
+// Streams still flagged as compressed (frames that didn't pass through a FrameReader)
+// are decompressed. Frames out of sequence fail with a *SequenceError, and a stream whose
+// bytes don't match the len it declared with a *LengthError. A response ending with ERR
+// fails with a *PartialResultError, returned with the streams completed before it.
 func CollectStreams(frames <-chan Frame) ([]struct {
 	MediaUrn string
 	Data     []byte
@@ -1900,26 +3119,46 @@ func CollectStreams(frames <-chan Frame) ([]struct {
 		MediaUrn string
 		Chunks   [][]byte
 	})
+	compressed := make(map[string]Compressor)
+	sequence := newFlowSequence()
+	lengths := newStreamLengths(nil)
 	var result []struct {
 		MediaUrn string
 		Data     []byte
 	}
 
 	for frame := range frames {
+		if err := sequence.check(&frame); err != nil {
+			return nil, err
+		}
 		switch frame.FrameType {
 		case FrameTypeStreamStart:
+			lengths.check(&frame)
 			if frame.StreamId != nil && frame.MediaUrn != nil {
 				streams[*frame.StreamId] = struct {
 					MediaUrn string
 					Chunks   [][]byte
 				}{MediaUrn: *frame.MediaUrn, Chunks: [][]byte{}}
+				if algorithm := frame.StreamCompression(); algorithm != "" {
+					compressor := lookupCompressor(algorithm)
+					if compressor == nil {
+						return nil, fmt.Errorf("stream %s compressed with unsupported %q", *frame.StreamId, algorithm)
+					}
+					compressed[*frame.StreamId] = compressor
+				}
 			}
 
 		case FrameTypeChunk:
+			if frame.StreamId != nil && compressed[*frame.StreamId] != nil {
+				frame = *decompressChunk(&frame, compressed[*frame.StreamId], MaxFrameHardLimit)
+			}
 			// Verify checksum (protocol v2 integrity check)
 			if err := VerifyChunkChecksum(&frame); err != nil {
 				return nil, fmt.Errorf("corrupted data: %w", err)
 			}
+			if err := lengths.check(&frame); err != nil {
+				return nil, err
+			}
 			if frame.StreamId != nil {
 				if stream, ok := streams[*frame.StreamId]; ok {
 					stream.Chunks = append(stream.Chunks, frame.Payload)
@@ -1928,6 +3167,9 @@ func CollectStreams(frames <-chan Frame) ([]struct {
 			}
 
 		case FrameTypeStreamEnd:
+			if err := lengths.check(&frame); err != nil {
+				return nil, err
+			}
 			if frame.StreamId != nil {
 				if stream, ok := streams[*frame.StreamId]; ok {
 					var combined []byte
@@ -1939,6 +3181,7 @@ func CollectStreams(frames <-chan Frame) ([]struct {
 						Data     []byte
 					}{MediaUrn: stream.MediaUrn, Data: combined})
 					delete(streams, *frame.StreamId)
+					delete(compressed, *frame.StreamId)
 				}
 			}
 
@@ -1946,15 +3189,14 @@ func CollectStreams(frames <-chan Frame) ([]struct {
 			return result, nil
 
 		case FrameTypeErr:
-			code := frame.ErrorCode()
-			msg := frame.ErrorMessage()
-			if code == "" {
-				code = "UNKNOWN"
+			capErr := ErrorFromFrame(&frame)
+			if capErr.Code == "" {
+				capErr.Code = "UNKNOWN"
 			}
-			if msg == "" {
-				msg = "Unknown error"
+			if capErr.Message == "" {
+				capErr.Message = "Unknown error"
 			}
-			return nil, fmt.Errorf("error: [%s] %s", code, msg)
+			return result, &PartialResultError{Err: capErr, Partial: partialStreams(&frame, streams)}
 		}
 	}
 
@@ -1964,6 +3206,8 @@ func CollectStreams(frames <-chan Frame) ([]struct {
 // FindStream finds a stream's bytes by exact URN equivalence.
 // Uses MediaUrn.IsEquivalent() — matches only if both URNs have the
 // exact same tag set (order-independent).
+//
+// Deprecated: use CollectArgs and ArgSet, which match by pattern.
 func FindStream(streams []struct {
 	MediaUrn string
 	Data     []byte
@@ -1990,6 +3234,8 @@ func FindStream(streams []struct {
 }
 
 // FindStreamStr is like FindStream but returns a UTF-8 string.
+//
+// Deprecated: use CollectArgs and ArgSet.String.
 func FindStreamStr(streams []struct {
 	MediaUrn string
 	Data     []byte
@@ -2005,6 +3251,8 @@ func FindStreamStr(streams []struct {
 }
 
 // RequireStream is like FindStream but fails hard if not found.
+//
+// Deprecated: use CollectArgs and ArgSet.RequireBytes.
 func RequireStream(streams []struct {
 	MediaUrn string
 	Data     []byte
@@ -2020,6 +3268,8 @@ func RequireStream(streams []struct {
 }
 
 // RequireStreamStr is like RequireStream but returns a UTF-8 string.
+//
+// Deprecated: use CollectArgs and ArgSet.RequireString.
 func RequireStreamStr(streams []struct {
 	MediaUrn string
 	Data     []byte
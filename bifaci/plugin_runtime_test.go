@@ -4,10 +4,14 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -981,7 +985,9 @@ func Test344FilePathArrayInvalidJSONFails(t *testing.T) {
 		t.Fatalf("Failed to create runtime: %v", err)
 	}
 
-	cliArgs := []string{"not a json array"}
+	// A single plain value is a one-path list, so only a value that starts like a
+	// JSON array is parsed as one
+	cliArgs := []string{`["not", "a json array"`}
 	_, err = runtime.extractArgValue(&manifest.Caps[0].Args[0], cliArgs, nil)
 
 	if err == nil {
@@ -2309,3 +2315,1185 @@ func Test398BuildPayloadIOError(t *testing.T) {
 		t.Errorf("Expected error to contain 'simulated read error', got: %s", err.Error())
 	}
 }
+
+const testCancelCap = `cap:in="media:void";op=test;out="media:void"`
+
+// startCBORRuntime runs the runtime's frame protocol over in-memory pipes and performs
+// the host side of the handshake. The returned stop function closes the host's write side,
+// drains remaining output and returns the runtime's exit error.
+func startCBORRuntime(t *testing.T, runtime *PluginRuntime) (*FrameReader, *FrameWriter, func() error) {
+	t.Helper()
+	return startCBORRuntimeWithWindow(t, runtime, 0)
+}
+
+// startCBORRuntimeWithWindow is startCBORRuntime with a flow-control window offered in HELLO.
+func startCBORRuntimeWithWindow(t *testing.T, runtime *PluginRuntime, maxWindow int) (*FrameReader, *FrameWriter, func() error) {
+	t.Helper()
+	hostToPluginR, hostToPluginW := io.Pipe()
+	pluginToHostR, pluginToHostW := io.Pipe()
+
+	done := make(chan error, 1)
+	go func() {
+		err := runtime.serveCBOR(hostToPluginR, pluginToHostW)
+		pluginToHostW.Close()
+		done <- err
+	}()
+
+	reader := NewFrameReader(pluginToHostR)
+	writer := NewFrameWriter(hostToPluginW)
+	if _, _, err := HandshakeInitiateWithWindow(reader, writer, maxWindow); err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+
+	stop := func() error {
+		hostToPluginW.Close()
+		go io.Copy(io.Discard, pluginToHostR)
+		return <-done
+	}
+	return reader, writer, stop
+}
+
+// writeTestRequest writes REQ + one argument stream (STREAM_START, CHUNK, STREAM_END) + END.
+func writeTestRequest(t *testing.T, writer *FrameWriter, reqId MessageId, capUrn string, arg []byte) {
+	t.Helper()
+	frames := []*Frame{
+		NewReq(reqId, capUrn, nil, "application/cbor"),
+		NewStreamStart(reqId, "arg-0", "media:"),
+		NewChunk(reqId, "arg-0", 0, arg, 0, ComputeChecksum(arg)),
+		NewStreamEnd(reqId, "arg-0", 1),
+		NewEnd(reqId, nil),
+	}
+	for _, f := range frames {
+		if err := writer.WriteFrame(f); err != nil {
+			t.Fatalf("Failed to write %v: %v", f.FrameType, err)
+		}
+	}
+}
+
+// readUntilTerminal reads response frames for reqId until END or ERR and returns them all.
+func readUntilTerminal(t *testing.T, reader *FrameReader, reqId MessageId) []*Frame {
+	t.Helper()
+	var frames []*Frame
+	for {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		if !frame.Id.Equals(reqId) {
+			continue
+		}
+		frames = append(frames, frame)
+		if frame.FrameType == FrameTypeEnd || frame.FrameType == FrameTypeErr {
+			return frames
+		}
+	}
+}
+
+// TestCancelActiveHandler: CANCEL cancels the handler context and the response ends with ERR CANCELLED
+func TestCancelActiveHandler(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+
+	started := make(chan struct{})
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		close(started)
+		ctx := HandlerContext(emitter)
+		<-ctx.Done()
+		if err := emitter.EmitCbor("too late"); err != ErrRequestCancelled {
+			t.Errorf("EmitCbor after cancel must return ErrRequestCancelled, got %v", err)
+		}
+		return ctx.Err()
+	})
+
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	reqId := NewMessageIdRandom()
+	writeTestRequest(t, writer, reqId, testCancelCap, []byte{0x40})
+
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Handler was not invoked")
+	}
+
+	if err := writer.WriteFrame(NewCancel(reqId)); err != nil {
+		t.Fatalf("Failed to write CANCEL: %v", err)
+	}
+
+	frames := readUntilTerminal(t, reader, reqId)
+	last := frames[len(frames)-1]
+	if last.FrameType != FrameTypeErr || last.ErrorCode() != "CANCELLED" {
+		t.Fatalf("Expected ERR CANCELLED, got %v %s", last.FrameType, last.ErrorCode())
+	}
+	for _, f := range frames {
+		if f.FrameType == FrameTypeChunk {
+			t.Error("No CHUNK may be sent for a cancelled request")
+		}
+	}
+
+	if err := stop(); err != nil {
+		t.Errorf("Runtime exited with error: %v", err)
+	}
+}
+
+// TestCancelPendingRequest: CANCEL before END aborts the running handler with a single ERR CANCELLED
+func TestCancelPendingRequest(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		return nil
+	})
+
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	reqId := NewMessageIdRandom()
+	writer.WriteFrame(NewReq(reqId, testCancelCap, nil, "application/cbor"))
+	writer.WriteFrame(NewStreamStart(reqId, "arg-0", "media:"))
+	writer.WriteFrame(NewCancel(reqId))
+
+	frames := readUntilTerminal(t, reader, reqId)
+	if len(frames) != 1 || frames[0].ErrorCode() != "CANCELLED" {
+		t.Fatalf("Expected a single ERR CANCELLED, got %d frames (%v)", len(frames), frames[0].FrameType)
+	}
+
+	// END after cancel belongs to no request - must be ignored
+	writer.WriteFrame(NewEnd(reqId, nil))
+	if err := stop(); err != nil {
+		t.Errorf("Runtime exited with error: %v", err)
+	}
+}
+
+// TestPeerInvokerCancel: Cancel sends CANCEL for the peer request; the peer's ERR closes the channel
+func TestPeerInvokerCancel(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+
+	peerErr := make(chan string, 1)
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		cancellable, ok := peer.(CancellablePeerInvoker)
+		if !ok {
+			return fmt.Errorf("%T can't cancel peer invocations", peer)
+		}
+		peerId, responses, err := cancellable.InvokeWithId(`cap:in="media:void";op=slow;out="media:void"`, nil)
+		if err != nil {
+			return err
+		}
+		if err := cancellable.Cancel(peerId); err != nil {
+			return err
+		}
+		for frame := range responses {
+			if frame.FrameType == FrameTypeErr {
+				peerErr <- frame.ErrorCode()
+			}
+		}
+		return nil
+	})
+
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	reqId := NewMessageIdRandom()
+	writeTestRequest(t, writer, reqId, testCancelCap, []byte{0x40})
+
+	// Host side: wait for the peer REQ, then its CANCEL, and acknowledge with ERR CANCELLED
+	var peerId MessageId
+	for {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		if frame.FrameType == FrameTypeReq {
+			peerId = frame.Id
+		}
+		if frame.FrameType == FrameTypeCancel {
+			if !frame.Id.Equals(peerId) {
+				t.Fatal("CANCEL must carry the peer request ID")
+			}
+			break
+		}
+	}
+	writer.WriteFrame(NewErr(peerId, "CANCELLED", "Request cancelled by peer"))
+
+	select {
+	case code := <-peerErr:
+		if code != "CANCELLED" {
+			t.Errorf("Expected CANCELLED, got %s", code)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Handler did not observe the peer's ERR")
+	}
+
+	frames := readUntilTerminal(t, reader, reqId)
+	if frames[len(frames)-1].FrameType != FrameTypeEnd {
+		t.Errorf("Original request must still complete with END")
+	}
+	if err := stop(); err != nil {
+		t.Errorf("Runtime exited with error: %v", err)
+	}
+}
+
+// TestOpenStreamMultipleOutputs: a handler emits a primary stream plus a named metadata stream,
+// each with its own STREAM_START/CHUNK/STREAM_END and chunk count
+func TestOpenStreamMultipleOutputs(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		meta, err := OpenStream(emitter, "media:json")
+		if err != nil {
+			return err
+		}
+		if err := emitter.EmitCbor([]byte("pdf-data")); err != nil {
+			return err
+		}
+		if err := meta.EmitCbor("first"); err != nil {
+			return err
+		}
+		if err := meta.EmitCbor("second"); err != nil {
+			return err
+		}
+		if err := meta.Close(); err != nil {
+			return err
+		}
+		if err := meta.EmitCbor("after close"); err == nil {
+			t.Error("EmitCbor on a closed output stream must fail")
+		}
+		return nil
+	})
+
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	reqId := NewMessageIdRandom()
+	writeTestRequest(t, writer, reqId, testCancelCap, []byte{0x40})
+
+	frames := readUntilTerminal(t, reader, reqId)
+	if last := frames[len(frames)-1]; last.FrameType != FrameTypeEnd {
+		t.Fatalf("Expected END, got %v", last.FrameType)
+	}
+
+	starts := map[string]string{}
+	chunks := map[string]uint64{}
+	ends := map[string]uint64{}
+	for _, f := range frames {
+		switch f.FrameType {
+		case FrameTypeStreamStart:
+			starts[*f.StreamId] = *f.MediaUrn
+		case FrameTypeChunk:
+			if _, ok := starts[*f.StreamId]; !ok {
+				t.Errorf("CHUNK on stream %s before its STREAM_START", *f.StreamId)
+			}
+			if _, ok := ends[*f.StreamId]; ok {
+				t.Errorf("CHUNK on stream %s after its STREAM_END", *f.StreamId)
+			}
+			chunks[*f.StreamId]++
+		case FrameTypeStreamEnd:
+			ends[*f.StreamId] = *f.ChunkCount
+		}
+	}
+
+	if len(starts) != 2 {
+		t.Fatalf("Expected 2 output streams, got %d: %v", len(starts), starts)
+	}
+	var metaId string
+	for id, media := range starts {
+		if media == "media:json" {
+			metaId = id
+		}
+	}
+	if metaId == "" {
+		t.Fatal("Metadata stream was not announced with media:json")
+	}
+	if chunks[metaId] != 2 || ends[metaId] != 2 {
+		t.Errorf("Metadata stream: expected 2 chunks and chunk_count 2, got %d/%d", chunks[metaId], ends[metaId])
+	}
+	for id := range starts {
+		if ends[id] != chunks[id] {
+			t.Errorf("Stream %s: chunk_count %d does not match %d chunks", id, ends[id], chunks[id])
+		}
+	}
+
+	if err := stop(); err != nil {
+		t.Errorf("Runtime exited with error: %v", err)
+	}
+}
+
+// TestFlowControlBlocksUntilAck: with a negotiated window the emitter stops after window chunks
+// and resumes only when the host grants credit with ACK
+func TestFlowControlBlocksUntilAck(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+
+	const window = 2
+	const total = 5
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		for i := 0; i < total; i++ {
+			if err := emitter.EmitCbor(int64(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	reader, writer, stop := startCBORRuntimeWithWindow(t, runtime, window)
+	reqId := NewMessageIdRandom()
+	writeTestRequest(t, writer, reqId, testCancelCap, []byte{0x40})
+
+	// Read frames in the background so a missing ACK shows up as a stall, not a hang
+	frameCh := make(chan *Frame, 16)
+	go func() {
+		defer close(frameCh)
+		for {
+			frame, err := reader.ReadFrame()
+			if err != nil {
+				return
+			}
+			if frame.Id.Equals(reqId) {
+				frameCh <- frame
+			}
+		}
+	}()
+
+	chunks := 0
+	waitChunks := func(n int) {
+		t.Helper()
+		for chunks < n {
+			select {
+			case frame := <-frameCh:
+				if frame.FrameType == FrameTypeChunk {
+					chunks++
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("Timed out waiting for chunk %d", chunks+1)
+			}
+		}
+	}
+
+	waitChunks(window)
+	select {
+	case frame := <-frameCh:
+		t.Fatalf("Emitter must block when the window is exhausted, got %v", frame.FrameType)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := writer.WriteFrame(NewAck(reqId, total-window)); err != nil {
+		t.Fatalf("Failed to write ACK: %v", err)
+	}
+	waitChunks(total)
+
+	for {
+		select {
+		case frame := <-frameCh:
+			if frame.FrameType == FrameTypeChunk {
+				t.Fatal("Emitter sent more chunks than credited")
+			}
+			if frame.FrameType == FrameTypeEnd {
+				if err := stop(); err != nil {
+					t.Errorf("Runtime exited with error: %v", err)
+				}
+				return
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for END")
+		}
+	}
+}
+
+// TestFlowControlDisabledWithoutHostWindow: a host that offers no window never has to ACK
+func TestFlowControlDisabledWithoutHostWindow(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		for i := 0; i < DefaultMaxWindow+10; i++ {
+			if err := emitter.EmitCbor(int64(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	reqId := NewMessageIdRandom()
+	writeTestRequest(t, writer, reqId, testCancelCap, []byte{0x40})
+
+	frames := readUntilTerminal(t, reader, reqId)
+	if last := frames[len(frames)-1]; last.FrameType != FrameTypeEnd {
+		t.Fatalf("Expected END, got %v", last.FrameType)
+	}
+	if err := stop(); err != nil {
+		t.Errorf("Runtime exited with error: %v", err)
+	}
+}
+
+// TestHandlerEmitsBeforeEnd: a handler is invoked on REQ and can answer
+// the first chunk before the host has sent the rest of the input
+func TestHandlerEmitsBeforeEnd(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for frame := range frames {
+			if frame.FrameType == FrameTypeChunk {
+				var text string
+				if err := cborlib.Unmarshal(frame.Payload, &text); err != nil {
+					return err
+				}
+				if err := emitter.EmitCbor("echo:" + text); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	reqId := NewMessageIdRandom()
+
+	writeChunk := func(index uint64, text string) {
+		payload, _ := cborlib.Marshal(text)
+		if err := writer.WriteFrame(NewChunk(reqId, "arg-0", index, payload, index, ComputeChecksum(payload))); err != nil {
+			t.Fatalf("Failed to write CHUNK: %v", err)
+		}
+	}
+	readChunkText := func() string {
+		t.Helper()
+		for {
+			frame, err := reader.ReadFrame()
+			if err != nil {
+				t.Fatalf("Failed to read frame: %v", err)
+			}
+			if frame.FrameType == FrameTypeErr {
+				t.Fatalf("Unexpected ERR: %s", frame.ErrorMessage())
+			}
+			if frame.FrameType == FrameTypeChunk {
+				var text string
+				cborlib.Unmarshal(frame.Payload, &text)
+				return text
+			}
+		}
+	}
+
+	writer.WriteFrame(NewReq(reqId, testCancelCap, nil, "application/cbor"))
+	writer.WriteFrame(NewStreamStart(reqId, "arg-0", "media:"))
+	writeChunk(0, "one")
+
+	// The response to the first chunk must arrive while the request is still open
+	if text := readChunkText(); text != "echo:one" {
+		t.Fatalf("Expected echo:one, got %q", text)
+	}
+
+	writeChunk(1, "two")
+	if text := readChunkText(); text != "echo:two" {
+		t.Fatalf("Expected echo:two, got %q", text)
+	}
+	writer.WriteFrame(NewStreamEnd(reqId, "arg-0", 2))
+	writer.WriteFrame(NewEnd(reqId, nil))
+
+	frames := readUntilTerminal(t, reader, reqId)
+	if last := frames[len(frames)-1]; last.FrameType != FrameTypeEnd {
+		t.Fatalf("Expected END, got %v", last.FrameType)
+	}
+	if err := stop(); err != nil {
+		t.Errorf("Runtime exited with error: %v", err)
+	}
+}
+
+// TestInputProtocolViolation: a CHUNK for an unknown stream aborts the running
+// handler and the response ends with ERR PROTOCOL_ERROR
+func TestInputProtocolViolation(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		return nil
+	})
+
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	reqId := NewMessageIdRandom()
+	payload := []byte{0x40}
+	writer.WriteFrame(NewReq(reqId, testCancelCap, nil, "application/cbor"))
+	writer.WriteFrame(NewChunk(reqId, "never-started", 0, payload, 0, ComputeChecksum(payload)))
+
+	frames := readUntilTerminal(t, reader, reqId)
+	last := frames[len(frames)-1]
+	if last.FrameType != FrameTypeErr || last.ErrorCode() != "PROTOCOL_ERROR" {
+		t.Fatalf("Expected ERR PROTOCOL_ERROR, got %v %s", last.FrameType, last.ErrorCode())
+	}
+	if err := stop(); err != nil {
+		t.Errorf("Runtime exited with error: %v", err)
+	}
+}
+
+// TestSpilledInputReachesHandler: input beyond the per-request memory cap is spilled
+// to disk and still delivered to the handler completely and in order
+func TestSpilledInputReachesHandler(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.SetMaxRequestMemory(16)
+	runtime.SetSpillDir(t.TempDir())
+
+	release := make(chan struct{})
+	received := make(chan []string, 1)
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		// Hold back consumption so the input piles up in the queue
+		<-release
+		var texts []string
+		for frame := range frames {
+			if frame.FrameType == FrameTypeChunk {
+				var text string
+				if err := cborlib.Unmarshal(frame.Payload, &text); err != nil {
+					return err
+				}
+				texts = append(texts, text)
+			}
+		}
+		received <- texts
+		return nil
+	})
+
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	reqId := NewMessageIdRandom()
+	writer.WriteFrame(NewReq(reqId, testCancelCap, nil, "application/cbor"))
+	writer.WriteFrame(NewStreamStart(reqId, "arg-0", "media:"))
+	const chunkCount = 20
+	for i := uint64(0); i < chunkCount; i++ {
+		payload, _ := cborlib.Marshal(fmt.Sprintf("chunk-%02d", i))
+		writer.WriteFrame(NewChunk(reqId, "arg-0", i, payload, i, ComputeChecksum(payload)))
+	}
+	writer.WriteFrame(NewStreamEnd(reqId, "arg-0", chunkCount))
+	writer.WriteFrame(NewEnd(reqId, nil))
+	close(release)
+
+	frames := readUntilTerminal(t, reader, reqId)
+	if last := frames[len(frames)-1]; last.FrameType != FrameTypeEnd {
+		t.Fatalf("Expected END, got %v %s", last.FrameType, last.ErrorMessage())
+	}
+	texts := <-received
+	if len(texts) != chunkCount {
+		t.Fatalf("Expected %d chunks, got %d", chunkCount, len(texts))
+	}
+	for i, text := range texts {
+		if text != fmt.Sprintf("chunk-%02d", i) {
+			t.Errorf("Chunk %d out of order: %q", i, text)
+		}
+	}
+	if err := stop(); err != nil {
+		t.Errorf("Runtime exited with error: %v", err)
+	}
+}
+
+// TestRunListenerServesConcurrentConnections: the frame protocol is served over TCP and
+// Unix sockets, with several hosts connected at once
+func TestRunListenerServesConcurrentConnections(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		return emitter.EmitCbor("pong")
+	})
+
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen on TCP: %v", err)
+	}
+	unixListener, err := net.Listen("unix", filepath.Join(t.TempDir(), "plugin.sock"))
+	if err != nil {
+		t.Fatalf("Failed to listen on Unix socket: %v", err)
+	}
+
+	for _, listener := range []net.Listener{tcpListener, unixListener} {
+		done := make(chan error, 1)
+		go func(l net.Listener) { done <- runtime.RunListener(l) }(listener)
+
+		// Two hosts: the second one is served while the first stays connected
+		var conns []net.Conn
+		for i := 0; i < 2; i++ {
+			conn, err := net.Dial(listener.Addr().Network(), listener.Addr().String())
+			if err != nil {
+				t.Fatalf("Failed to dial %s: %v", listener.Addr().Network(), err)
+			}
+			conns = append(conns, conn)
+
+			reader := NewFrameReader(conn)
+			writer := NewFrameWriter(conn)
+			if _, _, err := HandshakeInitiate(reader, writer); err != nil {
+				t.Fatalf("Handshake over %s failed: %v", listener.Addr().Network(), err)
+			}
+			reqId := NewMessageIdRandom()
+			writeTestRequest(t, writer, reqId, testCancelCap, []byte{0x40})
+			frames := readUntilTerminal(t, reader, reqId)
+			if last := frames[len(frames)-1]; last.FrameType != FrameTypeEnd {
+				t.Fatalf("Expected END over %s, got %v", listener.Addr().Network(), last.FrameType)
+			}
+		}
+
+		for _, conn := range conns {
+			conn.Close()
+		}
+		listener.Close()
+		if err := <-done; err != nil {
+			t.Errorf("RunListener returned error after Close: %v", err)
+		}
+	}
+}
+
+// TestEmitLogCarriesAttrs: EmitLogAttrs attributes travel in the LOG frame's meta
+func TestEmitLogCarriesAttrs(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		EmitLogAttrs(emitter, "info", "page rendered", "page", 3, "format", "png")
+		return nil
+	})
+
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	reqId := NewMessageIdRandom()
+	writeTestRequest(t, writer, reqId, testCancelCap, []byte{0x40})
+
+	var logFrame *Frame
+	for _, frame := range readUntilTerminal(t, reader, reqId) {
+		if frame.FrameType == FrameTypeLog {
+			logFrame = frame
+		}
+	}
+	if logFrame == nil {
+		t.Fatal("Expected a LOG frame")
+	}
+	attrs := logFrame.LogAttrs()
+	if logFrame.LogMessage() != "page rendered" || attrs["format"] != "png" || attrs["page"] != uint64(3) {
+		t.Errorf("Unexpected LOG frame: %q %v", logFrame.LogMessage(), attrs)
+	}
+	if err := stop(); err != nil {
+		t.Errorf("Runtime exited with error: %v", err)
+	}
+}
+
+// TestSetLoggerReceivesDiagnostics: runtime diagnostics go to the configured logger
+func TestSetLoggerReceivesDiagnostics(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	var logs bytes.Buffer
+	runtime.SetLogger(slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		return nil
+	})
+
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	reqId := NewMessageIdRandom()
+	writeTestRequest(t, writer, reqId, testCancelCap, []byte{0x40})
+	readUntilTerminal(t, reader, reqId)
+	if err := stop(); err != nil {
+		t.Errorf("Runtime exited with error: %v", err)
+	}
+
+	var found bool
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Logger output is not JSON: %q", line)
+		}
+		if record["msg"] == "REQ: handler started" && record["req_id"] == reqId.ToString() {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected a REQ record for %s, got:\n%s", reqId.ToString(), logs.String())
+	}
+}
+
+// TestConcurrencyLimitQueuesAndRejects: with one slot and one queue position, the second
+// request waits for the first and the third is rejected with ERR BUSY
+func TestConcurrencyLimitQueuesAndRejects(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.SetMaxConcurrentRequests(1, 1)
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		for range frames {
+		}
+		<-release
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	})
+
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	first, second, third := NewMessageIdRandom(), NewMessageIdRandom(), NewMessageIdRandom()
+	// The pipe is synchronous: write while the ERR BUSY is read below
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		for _, id := range []MessageId{first, second, third} {
+			writeTestRequest(t, writer, id, testCancelCap, []byte{0x40})
+		}
+	}()
+
+	frames := readUntilTerminal(t, reader, third)
+	if last := frames[len(frames)-1]; last.FrameType != FrameTypeErr || last.ErrorCode() != "BUSY" {
+		t.Fatalf("Expected ERR BUSY for the third request, got %v %s", last.FrameType, last.ErrorCode())
+	}
+
+	// The second request may finish first: its slot frees before the first writes END
+	close(release)
+	pending := map[string]bool{first.ToString(): true, second.ToString(): true}
+	for len(pending) > 0 {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		if !pending[frame.Id.ToString()] {
+			continue
+		}
+		switch frame.FrameType {
+		case FrameTypeEnd:
+			delete(pending, frame.Id.ToString())
+		case FrameTypeErr:
+			t.Fatalf("Expected END for admitted request, got ERR %s", frame.ErrorCode())
+		}
+	}
+	<-written
+	if err := stop(); err != nil {
+		t.Errorf("Runtime exited with error: %v", err)
+	}
+	if maxRunning != 1 {
+		t.Errorf("Expected at most 1 running handler, got %d", maxRunning)
+	}
+}
+
+// TestHandlerTimeoutSendsTimeoutErr: a handler running past the default timeout has its
+// context cancelled and the request ends with ERR TIMEOUT
+func TestHandlerTimeoutSendsTimeoutErr(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.SetDefaultHandlerTimeout(50 * time.Millisecond)
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		<-HandlerContext(emitter).Done()
+		return HandlerContext(emitter).Err()
+	})
+
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	reqId := NewMessageIdRandom()
+	writeTestRequest(t, writer, reqId, testCancelCap, []byte{0x40})
+
+	frames := readUntilTerminal(t, reader, reqId)
+	if last := frames[len(frames)-1]; last.FrameType != FrameTypeErr || last.ErrorCode() != "TIMEOUT" {
+		t.Errorf("Expected ERR TIMEOUT, got %v %s", last.FrameType, last.ErrorCode())
+	}
+	if err := stop(); err != nil {
+		t.Errorf("Runtime exited with error: %v", err)
+	}
+}
+
+// TestRequestDeadlinePropagatesToPeer: a REQ deadline shorter than the handler timeout
+// bounds the handler, and a peer invocation carries the remaining deadline
+func TestRequestDeadlinePropagatesToPeer(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.SetHandlerTimeout(testCancelCap, time.Hour)
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		responses, err := peer.Invoke(`cap:in="media:void";op=other;out="media:void"`, nil)
+		if err != nil {
+			return err
+		}
+		for range responses {
+		}
+		return nil
+	})
+
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	reqId := NewMessageIdRandom()
+	req := NewReq(reqId, testCancelCap, nil, "application/cbor")
+	req.SetDeadline(5 * time.Second)
+	writer.WriteFrame(req)
+	writer.WriteFrame(NewEnd(reqId, nil))
+
+	for {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		if frame.FrameType != FrameTypeReq {
+			continue
+		}
+		remaining, ok := frame.Deadline()
+		if !ok || remaining <= 0 || remaining > 5*time.Second {
+			t.Errorf("Peer REQ must carry the remaining deadline, got %v (ok=%v)", remaining, ok)
+		}
+		writer.WriteFrame(NewEnd(frame.Id, nil))
+		break
+	}
+	frames := readUntilTerminal(t, reader, reqId)
+	if frames[len(frames)-1].FrameType != FrameTypeEnd {
+		t.Errorf("Expected END, got %v", frames[len(frames)-1].FrameType)
+	}
+	if err := stop(); err != nil {
+		t.Errorf("Runtime exited with error: %v", err)
+	}
+}
+
+// TestMiddlewareWrapsHandlers: middleware runs outermost-first around every handler,
+// and RecoverMiddleware turns a panic into ERR HANDLER_ERROR
+func TestMiddlewareWrapsHandlers(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	var mu sync.Mutex
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+				mu.Lock()
+				calls = append(calls, name)
+				mu.Unlock()
+				return next(frames, emitter, peer)
+			}
+		}
+	}
+	runtime.Use(RecoverMiddleware, trace("outer"), trace("inner"))
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		panic("boom")
+	})
+
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	reqId := NewMessageIdRandom()
+	writeTestRequest(t, writer, reqId, testCancelCap, []byte{0x40})
+	frames := readUntilTerminal(t, reader, reqId)
+	if err := stop(); err != nil {
+		t.Errorf("Runtime exited with error: %v", err)
+	}
+
+	last := frames[len(frames)-1]
+	if last.FrameType != FrameTypeErr || last.ErrorCode() != "HANDLER_ERROR" || !strings.Contains(last.ErrorMessage(), "boom") {
+		t.Errorf("Expected ERR HANDLER_ERROR for the panic, got %v %s %s", last.FrameType, last.ErrorCode(), last.ErrorMessage())
+	}
+	if strings.Join(calls, ",") != "outer,inner" {
+		t.Errorf("Expected middleware order outer,inner, got %v", calls)
+	}
+}
+
+// TestAddCLICommand: runtime subcommands run in CLI mode, and names that are reserved,
+// duplicated or used by a cap are rejected
+func TestAddCLICommand(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+
+	var gotArgs []string
+	if err := runtime.AddCLICommand("selftest", "Run built-in checks", func(args []string) error {
+		gotArgs = args
+		return nil
+	}); err != nil {
+		t.Fatalf("AddCLICommand failed: %v", err)
+	}
+	for _, name := range []string{"manifest", "--help", "selftest", "test"} {
+		if err := runtime.AddCLICommand(name, "", func([]string) error { return nil }); err == nil {
+			t.Errorf("AddCLICommand(%q) must fail", name)
+		}
+	}
+
+	if err := runtime.runCLIMode([]string{"plugin", "selftest", "--verbose"}); err != nil {
+		t.Fatalf("selftest failed: %v", err)
+	}
+	if strings.Join(gotArgs, " ") != "--verbose" {
+		t.Errorf("Expected args [--verbose], got %v", gotArgs)
+	}
+	if diagnostics := runtime.ValidateManifest(); len(diagnostics) != 0 {
+		t.Errorf("Expected a valid manifest, got %v", diagnostics)
+	}
+}
+
+// TestCLIModeRejectsShadowedCapCommand: a cap whose command is a reserved subcommand is an
+// error rather than silently unreachable
+func TestCLIModeRejectsShadowedCapCommand(t *testing.T) {
+	manifest := strings.Replace(testManifest, `"command":"test"`, `"command":"manifest"`, 1)
+	runtime, err := NewPluginRuntime([]byte(manifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+
+	err = runtime.runCLIMode([]string{"plugin", "manifest"})
+	if err == nil || !strings.Contains(err.Error(), "manifest") {
+		t.Errorf("Expected a collision error, got %v", err)
+	}
+	diagnostics := runtime.ValidateManifest()
+	found := false
+	for _, d := range diagnostics {
+		if d.Path == "caps[0].command" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("ValidateManifest must report caps[0].command, got %v", diagnostics)
+	}
+}
+
+// TestCLIOutputFlags: output flags are stripped from the cap's arguments unless the cap
+// declares a flag of the same name
+func TestCLIOutputFlags(t *testing.T) {
+	flag := "--format"
+	capDef := &cap.Cap{Args: []cap.CapArg{{MediaUrn: "media:text", Sources: []cap.ArgSource{{CliFlag: &flag}}}}}
+
+	options, rest, err := parseCLIOutputFlags(capDef, []string{"--output", "out.json", "--quiet", "--format", "x", "--name=a"})
+	if err != nil {
+		t.Fatalf("parseCLIOutputFlags failed: %v", err)
+	}
+	if options.output != "out.json" || !options.quiet || options.format != CLIFormatRaw {
+		t.Errorf("Unexpected options %+v", options)
+	}
+	if strings.Join(rest, " ") != "--format x --name=a" {
+		t.Errorf("Cap flags must be kept, got %v", rest)
+	}
+
+	if _, _, err := parseCLIOutputFlags(&cap.Cap{}, []string{"--format=yaml"}); err == nil {
+		t.Error("Unknown format must be rejected")
+	}
+	if _, _, err := parseCLIOutputFlags(&cap.Cap{}, []string{"--output"}); err == nil {
+		t.Error("--output without a value must be rejected")
+	}
+}
+
+// TestCLIEmitterFormats: json writes one line per value, cbor a CBOR sequence, and quiet
+// drops LOG lines
+func TestCLIEmitterFormats(t *testing.T) {
+	var out, logs bytes.Buffer
+	emitter := newCLIStreamEmitter(&out, &logs, CLIFormatJSON, true)
+	emitter.EmitCbor(map[string]interface{}{"n": 1})
+	emitter.EmitCbor("text")
+	emitter.EmitLog("info", "hidden")
+	if out.String() != "{\"n\":1}\n\"text\"\n" {
+		t.Errorf("Unexpected JSON output %q", out.String())
+	}
+	if logs.Len() != 0 {
+		t.Errorf("Quiet emitter must not log, got %q", logs.String())
+	}
+
+	out.Reset()
+	emitter = newCLIStreamEmitter(&out, &logs, CLIFormatCBOR, false)
+	emitter.EmitCbor(uint64(7))
+	emitter.EmitCbor([]byte{1, 2})
+	decoder := cborlib.NewDecoder(&out)
+	var first uint64
+	var second []byte
+	if err := decoder.Decode(&first); err != nil || first != 7 {
+		t.Errorf("Expected 7, got %v (%v)", first, err)
+	}
+	if err := decoder.Decode(&second); err != nil || !bytes.Equal(second, []byte{1, 2}) {
+		t.Errorf("Expected [1 2], got %v (%v)", second, err)
+	}
+	emitter.EmitLog("warn", "shown")
+	if logs.String() != "[warn] shown\n" {
+		t.Errorf("Unexpected log output %q", logs.String())
+	}
+}
+
+// TestCompletionScripts: every shell's script offers the cap commands and their CLI flags
+func TestCompletionScripts(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testGatewayManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.AddCLICommand("selftest", "Run built-in checks", func([]string) error { return nil })
+
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		var script bytes.Buffer
+		if err := runtime.writeCompletion(&script, shell, "my-plugin"); err != nil {
+			t.Fatalf("%s: writeCompletion failed: %v", shell, err)
+		}
+		for _, want := range []string{"greet", "selftest", "manifest", "name", "format"} {
+			if !strings.Contains(script.String(), want) {
+				t.Errorf("%s script is missing %q:\n%s", shell, want, script.String())
+			}
+		}
+	}
+	if err := runtime.writeCompletion(io.Discard, "powershell", "my-plugin"); err == nil {
+		t.Error("Unsupported shell must be rejected")
+	}
+}
+
+// TestCapHelpDescribesArguments: cap help lists each argument's media URN, requirement,
+// sources and default, plus example invocations
+func TestCapHelpDescribesArguments(t *testing.T) {
+	var manifest CapManifest
+	if err := json.Unmarshal([]byte(testGatewayManifest), &manifest); err != nil {
+		t.Fatalf("Failed to parse manifest: %v", err)
+	}
+	capDef := &manifest.Caps[0]
+	capDef.Args[1].DefaultValue = "input.bin"
+
+	var help bytes.Buffer
+	writeCapHelp(&help, "my-plugin", capDef)
+	for _, want := range []string{
+		"my-plugin greet --name <NAME> [<FILE-PATH>] [OPTIONS]",
+		"media:text (required)",
+		"Sources: flag --name",
+		"media:file-path (optional)",
+		"Sources: stdin (media:bytes), position 0",
+		"Default: input.bin",
+		"cat input | my-plugin greet --name <NAME>",
+	} {
+		if !strings.Contains(help.String(), want) {
+			t.Errorf("Help is missing %q:\n%s", want, help.String())
+		}
+	}
+}
+
+// TestRunInteractiveInvokesHandlers: the REPL runs each line through the handler, reports
+// errors without stopping, and feeds "< FILE" as stdin
+func TestRunInteractiveInvokesHandlers(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testGatewayManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.Register(`cap:in="media:void";op=greet;out="media:void"`, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		var parts []string
+		for frame := range frames {
+			if frame.FrameType == FrameTypeChunk {
+				var value []byte
+				if err := cborlib.Unmarshal(frame.Payload, &value); err != nil {
+					return err
+				}
+				parts = append(parts, string(value))
+			}
+		}
+		return emitter.EmitCbor(strings.Join(parts, "+") + "\n")
+	})
+
+	stdinFile := filepath.Join(t.TempDir(), "input.txt")
+	if err := os.WriteFile(stdinFile, []byte("piped"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	input := strings.Join([]string{
+		`greet --name "Big World"`,
+		`frobnicate`,
+		`greet --name 'unterminated`,
+		`greet --name file < ` + stdinFile,
+		`help greet`,
+		`exit`,
+		`greet --name never`,
+	}, "\n")
+	var out bytes.Buffer
+	if err := runtime.RunInteractive(strings.NewReader(input), &out); err != nil {
+		t.Fatalf("RunInteractive failed: %v", err)
+	}
+
+	for _, want := range []string{
+		"Big World\n",
+		"error: unknown command 'frobnicate'",
+		"error: unterminated ' quote",
+		"file+piped\n",
+		"Sources: flag --name",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Output is missing %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "never") {
+		t.Errorf("Commands after exit must not run:\n%s", out.String())
+	}
+}
+
+// TestHeartbeatWatchdogShutsDownSilentHost: frames from the host keep the runtime alive;
+// once they stop for the heartbeat timeout, handlers are cancelled and the runtime exits
+func TestHeartbeatWatchdogShutsDownSilentHost(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.SetHeartbeatTimeout(100 * time.Millisecond)
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		<-HandlerContext(emitter).Done()
+		return HandlerContext(emitter).Err()
+	})
+
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	reqId := NewMessageIdRandom()
+	writeTestRequest(t, writer, reqId, testCancelCap, []byte{0x40})
+
+	// Heartbeats well within the timeout keep the request running
+	for i := 0; i < 5; i++ {
+		time.Sleep(40 * time.Millisecond)
+		heartbeatId := NewMessageIdRandom()
+		if err := writer.WriteFrame(NewHeartbeat(heartbeatId)); err != nil {
+			t.Fatalf("Failed to write heartbeat: %v", err)
+		}
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		if frame.FrameType != FrameTypeHeartbeat || !frame.Id.Equals(heartbeatId) {
+			t.Fatalf("Expected heartbeat reply, got %v", frame.FrameType)
+		}
+	}
+
+	frames := readUntilTerminal(t, reader, reqId)
+	if last := frames[len(frames)-1]; last.FrameType != FrameTypeErr || last.ErrorCode() != "CANCELLED" {
+		t.Errorf("Expected ERR CANCELLED, got %v %s", last.FrameType, last.ErrorCode())
+	}
+	if err := stop(); err != ErrHostSilent {
+		t.Errorf("Expected ErrHostSilent, got %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+package bifaci
+
+// Request priorities carried in REQ meta "priority" (see Frame.SetPriority). Higher
+// values are more urgent; a REQ without one is PriorityNormal.
+const (
+	PriorityBulk        = 0 // Batch work that may wait, e.g. a large conversion
+	PriorityNormal      = 1
+	PriorityInteractive = 2 // A user is waiting for the response
+)
+
+// priorityControl is the priority of frames other than response CHUNKs (heartbeats,
+// END, ERR, LOG...), which never wait behind other flows' CHUNKs
+const priorityControl = 1 << 30
+
+// Priority returns the priority of a REQ from meta "priority"
+func (f *Frame) Priority() (int, bool) {
+	if f.Meta == nil {
+		return 0, false
+	}
+	if _, ok := f.Meta["priority"]; !ok {
+		return 0, false
+	}
+	return extractIntFromMeta(f.Meta, "priority"), true
+}
+
+// SetPriority stores the priority of a REQ in meta "priority". It is only honored by a
+// plugin that accepted priorities in the handshake (see HandshakeOptions.Priorities).
+func (f *Frame) SetPriority(priority int) {
+	if f.Meta == nil {
+		f.Meta = make(map[string]interface{})
+	}
+	f.Meta["priority"] = priority
+}
+
+// requestPriority returns the priority a REQ is scheduled with on a connection
+func requestPriority(frame *Frame, limits Limits) int {
+	if !limits.Priorities {
+		return PriorityNormal
+	}
+	if priority, ok := frame.Priority(); ok {
+		return priority
+	}
+	return PriorityNormal
+}
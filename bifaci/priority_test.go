@@ -0,0 +1,68 @@
+package bifaci
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPriorityNegotiation(t *testing.T) {
+	host, plugin := handshakePipes(t, HandshakeOptions{Priorities: true})
+	if !host.Priorities || !plugin.Priorities {
+		t.Errorf("Expected priorities on both sides, got host %v plugin %v", host.Priorities, plugin.Priorities)
+	}
+	host, plugin = handshakePipes(t, HandshakeOptions{})
+	if host.Priorities || plugin.Priorities {
+		t.Errorf("Without an offer priorities are off, got host %v plugin %v", host.Priorities, plugin.Priorities)
+	}
+
+	req := NewReq(NewMessageIdRandom(), testCancelCap, nil, "application/cbor")
+	req.SetPriority(PriorityInteractive)
+	if requestPriority(req, plugin) != PriorityNormal {
+		t.Error("Expected the priority ignored unless negotiated")
+	}
+	if requestPriority(req, Limits{Priorities: true}) != PriorityInteractive {
+		t.Error("Expected the negotiated priority honored")
+	}
+}
+
+func TestRequestLimiterStartsMostUrgentFirst(t *testing.T) {
+	limiter := newRequestLimiter(1, 2)
+	for i := 0; i < 3; i++ {
+		if !limiter.reserve() {
+			t.Fatal("Expected the request admitted")
+		}
+	}
+	if !limiter.acquire(context.Background(), PriorityBulk) {
+		t.Fatal("Expected a free slot")
+	}
+
+	started := make(chan int, 2)
+	for _, priority := range []int{PriorityBulk, PriorityInteractive} {
+		priority := priority
+		go func() {
+			if limiter.acquire(context.Background(), priority) {
+				started <- priority
+			}
+		}()
+		// Queued in arrival order
+		for {
+			limiter.mu.Lock()
+			queued := len(limiter.waiters)
+			limiter.mu.Unlock()
+			if queued > 0 && (priority == PriorityBulk || queued == 2) {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	limiter.release()
+	if first := <-started; first != PriorityInteractive {
+		t.Errorf("Expected the interactive request to start first, got priority %d", first)
+	}
+	limiter.release()
+	if second := <-started; second != PriorityBulk {
+		t.Errorf("Expected the bulk request next, got priority %d", second)
+	}
+}
@@ -0,0 +1,232 @@
+package bifaci
+
+import (
+	"fmt"
+	"time"
+)
+
+// ProgressFunc is told of a stream's progress as its CHUNKs arrive: received is the
+// payload bytes so far, total the length the sender declared (0 if it declared none).
+// It is called from the frame reader loop and must not block.
+type ProgressFunc func(streamID string, received, total uint64)
+
+// OnProgress reports the progress of the input streams of every request as the runtime
+// receives them (nil disables it). Takes effect for connections served after the call.
+func (pr *PluginRuntime) OnProgress(fn ProgressFunc) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.onProgress = fn
+}
+
+// OnProgress reports the progress of the response streams the host routes from its
+// plugins to the relay (nil disables it).
+func (h *PluginHost) OnProgress(fn ProgressFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onProgress = fn
+	h.responseLengths = make(map[string]*streamLengths)
+}
+
+// noteProgressLocked reports the progress of the response streams of a request routed
+// to a plugin. Caller holds mu.
+func (h *PluginHost) noteProgressLocked(idKey string, frame *Frame) {
+	if h.onProgress == nil || h.peerRequests[idKey] {
+		return
+	}
+	switch frame.FrameType {
+	case FrameTypeStreamStart, FrameTypeChunk, FrameTypeStreamEnd:
+		lengths, ok := h.responseLengths[idKey]
+		if !ok {
+			lengths = newStreamLengths(h.onProgress)
+			h.responseLengths[idKey] = lengths
+		}
+		// The host only routes the response; its consumer rejects a wrong len
+		lengths.check(frame)
+	case FrameTypeEnd, FrameTypeErr:
+		delete(h.responseLengths, idKey)
+	}
+}
+
+// progressLevel is the LOG level of the frames carrying a Progress
+const progressLevel = "progress"
+
+// Progress is a handler's report of how far it got with a request, sent with
+// EmitProgress as a LOG frame of level "progress" whose meta carries
+// "stage", "completed" and "total"
+type Progress struct {
+	Stage     string
+	Completed uint64
+	Total     uint64        // 0 if unknown
+	ETA       time.Duration // Time left in the stage at its rate so far, 0 if unknown (see PluginHost.WatchProgress)
+}
+
+// Percent returns Completed as a percentage of Total, -1 if Total is unknown
+func (p Progress) Percent() float64 {
+	if p.Total == 0 {
+		return -1
+	}
+	return float64(p.Completed) * 100 / float64(p.Total)
+}
+
+// NewProgress creates the LOG frame reporting a request's progress
+func NewProgress(id MessageId, stage string, completed, total uint64) *Frame {
+	frame := NewLog(id, progressLevel, stage)
+	frame.Meta["stage"] = stage
+	frame.Meta["completed"] = completed
+	frame.Meta["total"] = total
+	return frame
+}
+
+// Progress gets the progress a LOG frame of level "progress" reports. ok is false for
+// other frames.
+func (f *Frame) Progress() (Progress, bool) {
+	if f.FrameType != FrameTypeLog || f.LogLevel() != progressLevel {
+		return Progress{}, false
+	}
+	stage, _ := f.Meta["stage"].(string)
+	return Progress{
+		Stage:     stage,
+		Completed: uint64(extractIntFromMeta(f.Meta, "completed")),
+		Total:     uint64(extractIntFromMeta(f.Meta, "total")),
+	}, true
+}
+
+// progressWatch is a WatchProgress subscription
+type progressWatch struct {
+	fn         func(Progress)
+	stage      string    // Stage of the last report
+	stageStart time.Time // When the stage was first reported
+	stageFrom  uint64    // Completed in the stage's first report
+}
+
+// WatchProgress calls fn with each progress report a plugin sends for the request reqId
+// until the request ends, replacing an earlier watch of the request; watch before
+// sending the REQ so no report is missed. The host fills in the ETA of each report
+// from the rate of its stage so far. fn is called from the host's event loop and must
+// not block. The returned func stops the watch.
+func (h *PluginHost) WatchProgress(reqId MessageId, fn func(Progress)) (stop func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.progressWatches == nil {
+		h.progressWatches = make(map[string]*progressWatch)
+	}
+	idKey := reqId.ToString()
+	watch := &progressWatch{fn: fn}
+	h.progressWatches[idKey] = watch
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.progressWatches[idKey] == watch {
+			delete(h.progressWatches, idKey)
+		}
+	}
+}
+
+// noteReportLocked passes a progress report from a plugin to the watch of its request,
+// and drops the watch once the request ends. Caller holds mu.
+func (h *PluginHost) noteReportLocked(idKey string, frame *Frame) {
+	watch, ok := h.progressWatches[idKey]
+	if !ok {
+		return
+	}
+	switch frame.FrameType {
+	case FrameTypeLog:
+		progress, ok := frame.Progress()
+		if !ok {
+			return
+		}
+		now := time.Now()
+		if watch.stageStart.IsZero() || progress.Stage != watch.stage {
+			watch.stage, watch.stageStart, watch.stageFrom = progress.Stage, now, progress.Completed
+		}
+		if progress.Completed > watch.stageFrom && progress.Total > progress.Completed {
+			perUnit := float64(now.Sub(watch.stageStart)) / float64(progress.Completed-watch.stageFrom)
+			progress.ETA = time.Duration(perUnit * float64(progress.Total-progress.Completed))
+		}
+		watch.fn(progress)
+	case FrameTypeEnd, FrameTypeErr:
+		if !h.peerRequests[idKey] {
+			delete(h.progressWatches, idKey)
+		}
+	}
+}
+
+// LengthError reports a stream whose bytes don't add up to the len its sender declared.
+// The runtime reports it as ERR PROTOCOL_ERROR.
+type LengthError struct {
+	StreamId string
+	Declared uint64
+	Received uint64
+	Ended    bool // False when the stream ran past its len before STREAM_END
+}
+
+func (e *LengthError) Error() string {
+	if !e.Ended {
+		return fmt.Sprintf("stream %s: %d bytes received, more than its len %d", e.StreamId, e.Received, e.Declared)
+	}
+	return fmt.Sprintf("stream %s: ended after %d bytes, short of its len %d", e.StreamId, e.Received, e.Declared)
+}
+
+// streamLength is the progress of one stream
+type streamLength struct {
+	declared *uint64 // len from STREAM_START or the first CHUNK, nil if none was sent
+	received uint64
+	chunks   uint64
+}
+
+// streamLengths tracks the payload bytes of each stream of a flow against the len its
+// sender declared on STREAM_START or the first CHUNK, reporting progress as CHUNKs
+// arrive. Streams without a len are reported with a total of 0 and aren't checked.
+type streamLengths struct {
+	streams    map[string]*streamLength
+	onProgress ProgressFunc // nil if progress isn't reported
+}
+
+func newStreamLengths(onProgress ProgressFunc) *streamLengths {
+	return &streamLengths{streams: make(map[string]*streamLength), onProgress: onProgress}
+}
+
+// check accounts for frame, failing with a *LengthError once a stream exceeds its len
+// or ends short of it. CHUNK payloads must already be decompressed.
+func (l *streamLengths) check(frame *Frame) error {
+	if frame.StreamId == nil {
+		return nil
+	}
+	streamId := *frame.StreamId
+	switch frame.FrameType {
+	case FrameTypeStreamStart:
+		l.streams[streamId] = &streamLength{declared: frame.Len}
+
+	case FrameTypeChunk:
+		stream, ok := l.streams[streamId]
+		if !ok {
+			return nil
+		}
+		if stream.chunks == 0 && stream.declared == nil {
+			stream.declared = frame.Len
+		}
+		stream.chunks++
+		stream.received += uint64(len(frame.Payload))
+		var total uint64
+		if stream.declared != nil {
+			total = *stream.declared
+			if stream.received > total {
+				return &LengthError{StreamId: streamId, Declared: total, Received: stream.received}
+			}
+		}
+		if l.onProgress != nil {
+			l.onProgress(streamId, stream.received, total)
+		}
+
+	case FrameTypeStreamEnd:
+		stream, ok := l.streams[streamId]
+		if !ok {
+			return nil
+		}
+		delete(l.streams, streamId)
+		if stream.declared != nil && stream.received != *stream.declared {
+			return &LengthError{StreamId: streamId, Declared: *stream.declared, Received: stream.received, Ended: true}
+		}
+	}
+	return nil
+}
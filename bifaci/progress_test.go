@@ -0,0 +1,210 @@
+package bifaci
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func withDeclaredLen(frame *Frame, total uint64) *Frame {
+	frame.Len = &total
+	return frame
+}
+
+func TestStreamLengthsProgress(t *testing.T) {
+	id := NewMessageIdRandom()
+	payload := []byte{0x43, 'a', 'b', 'c'}
+	chunk := func(streamId string, index uint64) *Frame {
+		return NewChunk(id, streamId, 0, payload, index, ComputeChecksum(payload))
+	}
+
+	type report struct{ received, total uint64 }
+	reports := make(map[string][]report)
+	lengths := newStreamLengths(func(streamID string, received, total uint64) {
+		reports[streamID] = append(reports[streamID], report{received, total})
+	})
+	for _, frame := range []*Frame{
+		withDeclaredLen(NewStreamStart(id, "a", "media:"), 8),
+		NewStreamStart(id, "b", "media:"),
+		chunk("a", 0),
+		withDeclaredLen(chunk("b", 0), 4),
+		chunk("a", 1),
+		NewStreamEnd(id, "a", 2),
+		NewStreamEnd(id, "b", 1),
+	} {
+		if err := lengths.check(frame); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if got := reports["a"]; len(got) != 2 || got[0] != (report{4, 8}) || got[1] != (report{8, 8}) {
+		t.Errorf("Unexpected progress for a: %v", got)
+	}
+	if got := reports["b"]; len(got) != 1 || got[0] != (report{4, 4}) {
+		t.Errorf("Unexpected progress for b: %v", got)
+	}
+
+	// Without a len the total is reported as 0 and nothing is checked
+	reports = make(map[string][]report)
+	lengths = newStreamLengths(func(streamID string, received, total uint64) {
+		reports[streamID] = append(reports[streamID], report{received, total})
+	})
+	lengths.check(NewStreamStart(id, "c", "media:"))
+	lengths.check(chunk("c", 0))
+	if err := lengths.check(NewStreamEnd(id, "c", 1)); err != nil || len(reports["c"]) != 1 || reports["c"][0] != (report{4, 0}) {
+		t.Errorf("Unexpected result for a stream without len: %v %v", err, reports["c"])
+	}
+
+	var lengthErr *LengthError
+	lengths = newStreamLengths(nil)
+	lengths.check(withDeclaredLen(NewStreamStart(id, "a", "media:"), 6))
+	lengths.check(chunk("a", 0))
+	if err := lengths.check(chunk("a", 1)); !errors.As(err, &lengthErr) || lengthErr.Ended || lengthErr.Received != 8 {
+		t.Errorf("Expected an overrun LengthError, got %v", err)
+	}
+
+	lengths = newStreamLengths(nil)
+	lengths.check(withDeclaredLen(NewStreamStart(id, "a", "media:"), 6))
+	lengths.check(chunk("a", 0))
+	if err := lengths.check(NewStreamEnd(id, "a", 1)); !errors.As(err, &lengthErr) || !lengthErr.Ended || lengthErr.Declared != 6 {
+		t.Errorf("Expected a short LengthError, got %v", err)
+	}
+	if handlerErrorCode(lengthErr) != "PROTOCOL_ERROR" {
+		t.Error("A LengthError returned by a handler must be reported as PROTOCOL_ERROR")
+	}
+}
+
+func TestCollectStreamsRejectsLenMismatch(t *testing.T) {
+	id := NewMessageIdRandom()
+	payload := []byte{0x41, 'x'}
+	frames := make(chan Frame, 4)
+	frames <- *withDeclaredLen(NewStreamStart(id, "arg-0", "media:"), 10)
+	frames <- *NewChunk(id, "arg-0", 0, payload, 0, ComputeChecksum(payload))
+	frames <- *NewStreamEnd(id, "arg-0", 1)
+	close(frames)
+
+	_, err := CollectStreams(frames)
+	var lengthErr *LengthError
+	if !errors.As(err, &lengthErr) || lengthErr.StreamId != "arg-0" || lengthErr.Received != 2 {
+		t.Errorf("Expected a LengthError for arg-0, got %v", err)
+	}
+}
+
+// The runtime reports input progress and aborts a request whose input falls short of its len
+func TestRuntimeInputProgressAndLen(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	var mu sync.Mutex
+	var progress []uint64
+	runtime.OnProgress(func(streamID string, received, total uint64) {
+		mu.Lock()
+		defer mu.Unlock()
+		if streamID == "arg-0" && total == 4 {
+			progress = append(progress, received)
+		}
+	})
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		if _, err := CollectStreams(frames); err != nil {
+			return err
+		}
+		return emitter.EmitCbor("ok")
+	})
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	defer stop()
+
+	payload := []byte{0x41, 'x'}
+	send := func(reqId MessageId, chunks uint64) {
+		frames := []*Frame{
+			NewReq(reqId, testCancelCap, nil, "application/cbor"),
+			withDeclaredLen(NewStreamStart(reqId, "arg-0", "media:"), 4),
+		}
+		for i := uint64(0); i < chunks; i++ {
+			frames = append(frames, NewChunk(reqId, "arg-0", 0, payload, i, ComputeChecksum(payload)))
+		}
+		frames = append(frames, NewStreamEnd(reqId, "arg-0", chunks), NewEnd(reqId, nil))
+		for _, frame := range frames {
+			if err := writer.WriteFrame(frame); err != nil {
+				t.Fatalf("WriteFrame failed: %v", err)
+			}
+		}
+	}
+
+	reqId := NewMessageIdRandom()
+	send(reqId, 2)
+	frames := readUntilTerminal(t, reader, reqId)
+	if last := frames[len(frames)-1]; last.FrameType != FrameTypeEnd {
+		t.Fatalf("Expected END, got %v %s", last.FrameType, last.ErrorMessage())
+	}
+	mu.Lock()
+	if len(progress) != 2 || progress[0] != 2 || progress[1] != 4 {
+		t.Errorf("Expected progress 2, 4 of 4, got %v", progress)
+	}
+	mu.Unlock()
+
+	reqId = NewMessageIdRandom()
+	send(reqId, 1)
+	frames = readUntilTerminal(t, reader, reqId)
+	last := frames[len(frames)-1]
+	if last.FrameType != FrameTypeErr || last.ErrorCode() != "PROTOCOL_ERROR" || !strings.Contains(last.ErrorMessage(), "arg-0") {
+		t.Errorf("Expected ERR PROTOCOL_ERROR naming arg-0, got %v %s %s", last.FrameType, last.ErrorCode(), last.ErrorMessage())
+	}
+}
+
+// EmitProgress sends a LOG frame of level "progress" the host can read back
+func TestEmitProgress(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		EmitProgress(emitter, "render", 3, 10)
+		return nil
+	})
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	defer stop()
+
+	reqId := NewMessageIdRandom()
+	writer.WriteFrame(NewReq(reqId, testCancelCap, nil, "application/cbor"))
+	writer.WriteFrame(NewEnd(reqId, nil))
+	frames := readUntilTerminal(t, reader, reqId)
+	progress, ok := frames[0].Progress()
+	if !ok || progress.Stage != "render" || progress.Completed != 3 || progress.Total != 10 || progress.Percent() != 30 {
+		t.Fatalf("Expected render 3/10 first, got %v %+v", frames[0].FrameType, progress)
+	}
+	if _, ok := NewLog(reqId, "info", "render").Progress(); ok {
+		t.Error("A plain LOG frame reports no progress")
+	}
+}
+
+func TestHostWatchProgress(t *testing.T) {
+	h := NewPluginHost()
+	reqId := NewMessageIdRandom()
+	var reports []Progress
+	stopWatch := h.WatchProgress(reqId, func(p Progress) { reports = append(reports, p) })
+	defer stopWatch()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	idKey := reqId.ToString()
+	h.noteReportLocked(idKey, NewProgress(reqId, "render", 0, 10))
+	h.progressWatches[idKey].stageStart = time.Now().Add(-time.Second)
+	h.noteReportLocked(idKey, NewProgress(reqId, "render", 5, 10))
+	h.noteReportLocked(idKey, NewProgress(reqId, "upload", 1, 4))
+	h.noteReportLocked(idKey, NewEnd(reqId, nil))
+	h.noteReportLocked(idKey, NewProgress(reqId, "upload", 2, 4))
+
+	if len(reports) != 3 {
+		t.Fatalf("Expected 3 reports before END, got %+v", reports)
+	}
+	if reports[0].ETA != 0 || reports[1].ETA < time.Second || reports[1].ETA > 2*time.Second {
+		t.Errorf("Expected an ETA of about a second halfway through the stage, got %+v", reports[:2])
+	}
+	if reports[2].Stage != "upload" || reports[2].ETA != 0 {
+		t.Errorf("Expected a new stage to restart the ETA, got %+v", reports[2])
+	}
+}
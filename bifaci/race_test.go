@@ -0,0 +1,5 @@
+//go:build race
+
+package bifaci
+
+const raceEnabled = true
@@ -0,0 +1,314 @@
+package bifaci
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+)
+
+// RecordDirection says which side sent a recorded frame
+type RecordDirection string
+
+const (
+	RecordedIn  RecordDirection = "in"  // Host → plugin
+	RecordedOut RecordDirection = "out" // Plugin → host
+)
+
+// replayStepTimeout bounds how long replay waits for the output recorded before the
+// next input frame
+const replayStepTimeout = 5 * time.Second
+
+// RecordedFrame is one frame of a recording
+type RecordedFrame struct {
+	Direction RecordDirection
+	Offset    time.Duration // Since the recording started
+	Frame     *Frame
+}
+
+// recordEntry is the on-disk form of a RecordedFrame; a recording is a CBOR sequence
+// (RFC 8742) of them
+type recordEntry struct {
+	Direction RecordDirection `cbor:"dir"`
+	Offset    int64           `cbor:"t"`     // Nanoseconds
+	Frame     []byte          `cbor:"frame"` // Encoded as on the wire, without length prefix
+}
+
+// Recorder tees every frame a runtime exchanges with its host into a recording that
+// ReplayRecording can feed back (see SetRecorder). A nil Recorder records nothing.
+type Recorder struct {
+	mu      sync.Mutex
+	w       io.Writer
+	closer  io.Closer
+	started time.Time
+	err     error // First write error; later frames are dropped
+}
+
+// NewRecorder records to w
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w, started: time.Now()}
+}
+
+// CreateRecording records to a new file at path
+func CreateRecording(path string) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording: %w", err)
+	}
+	recorder := NewRecorder(file)
+	recorder.closer = file
+	return recorder, nil
+}
+
+// SetRecorder tees the frames of every connection the runtime serves into recorder
+func (pr *PluginRuntime) SetRecorder(recorder *Recorder) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.recorder = recorder
+}
+
+func (r *Recorder) record(direction RecordDirection, encoded []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	entry, err := cborlib.Marshal(recordEntry{
+		Direction: direction,
+		Offset:    int64(time.Since(r.started)),
+		Frame:     encoded,
+	})
+	if err == nil {
+		_, err = r.w.Write(entry)
+	}
+	r.err = err
+}
+
+// Close reports the first error hit while recording and closes the file of a recording
+// made with CreateRecording
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.err
+	if r.closer != nil {
+		if closeErr := r.closer.Close(); err == nil {
+			err = closeErr
+		}
+		r.closer = nil
+	}
+	return err
+}
+
+// ReadRecording decodes a recording
+func ReadRecording(r io.Reader) ([]RecordedFrame, error) {
+	decoder := cborlib.NewDecoder(r)
+	var frames []RecordedFrame
+	for {
+		var entry recordEntry
+		if err := decoder.Decode(&entry); err != nil {
+			if errors.Is(err, io.EOF) {
+				return frames, nil
+			}
+			return nil, fmt.Errorf("failed to decode recording entry %d: %w", len(frames), err)
+		}
+		frame, err := DecodeFrame(entry.Frame)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode frame of recording entry %d: %w", len(frames), err)
+		}
+		frames = append(frames, RecordedFrame{Direction: entry.Direction, Offset: time.Duration(entry.Offset), Frame: frame})
+	}
+}
+
+// ReplayMismatch is a difference between the recorded and the replayed output of a
+// request
+type ReplayMismatch struct {
+	RequestId string
+	Index     int    // Position among the request's output frames
+	Expected  string // "" if the replay produced an extra frame
+	Actual    string // "" if the replay is missing the frame
+}
+
+func (m ReplayMismatch) String() string {
+	return fmt.Sprintf("request %s frame %d: expected %q, got %q", m.RequestId, m.Index, m.Expected, m.Actual)
+}
+
+// ReplayRecording feeds the host's frames from the recording at path into runtime,
+// starting with the handshake, and compares what the runtime emits with the recorded
+// output, request by request. Each input frame is sent once the output recorded before
+// it has been emitted, so requests see the same interleaving. HELLO, HEARTBEAT and LOG
+// frames are not compared. Peer invocations get fresh request IDs, so recordings
+// that contain them don't replay faithfully. An empty result means the runtime
+// reproduced the recording.
+func ReplayRecording(path string, runtime *PluginRuntime) ([]ReplayMismatch, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording: %w", err)
+	}
+	defer file.Close()
+	records, err := ReadRecording(file)
+	if err != nil {
+		return nil, err
+	}
+	return replayRecords(records, runtime)
+}
+
+func replayRecords(records []RecordedFrame, runtime *PluginRuntime) ([]ReplayMismatch, error) {
+	// Inputs, each with the number of output frames recorded before it
+	var inputs []*Frame
+	var outputsBefore []int
+	var expected []*Frame
+	for _, record := range records {
+		if record.Direction == RecordedIn {
+			inputs = append(inputs, record.Frame)
+			outputsBefore = append(outputsBefore, len(expected))
+		} else {
+			expected = append(expected, record.Frame)
+		}
+	}
+	if len(inputs) == 0 || inputs[0].FrameType != FrameTypeHello {
+		return nil, errors.New("recording does not start with the host's HELLO")
+	}
+
+	hostToPluginR, hostToPluginW := io.Pipe()
+	pluginToHostR, pluginToHostW := io.Pipe()
+	serveDone := make(chan error, 1)
+	go func() {
+		err := runtime.serveCBOR(hostToPluginR, pluginToHostW)
+		pluginToHostW.Close()
+		serveDone <- err
+	}()
+
+	// Recorded frames were within the negotiated limits; don't apply the defaults
+	replayLimits := DefaultLimits()
+	replayLimits.MaxFrame = MaxFrameHardLimit
+
+	var mu sync.Mutex
+	var actual []*Frame
+	progress := make(chan struct{}, 1)
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		reader := NewFrameReader(pluginToHostR)
+		reader.SetLimits(replayLimits)
+		for {
+			frame, err := reader.ReadFrame()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			actual = append(actual, frame)
+			mu.Unlock()
+			select {
+			case progress <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	writer := NewFrameWriter(hostToPluginW)
+	writer.SetLimits(replayLimits)
+	for i, frame := range inputs {
+		waitForOutputs(&mu, &actual, outputsBefore[i], progress, readDone)
+		if err := writer.WriteFrame(frame); err != nil {
+			break // The runtime stopped reading; its error is reported below
+		}
+	}
+	hostToPluginW.Close()
+	<-readDone
+	if err := <-serveDone; err != nil {
+		return nil, fmt.Errorf("runtime failed during replay: %w", err)
+	}
+
+	return diffReplayOutputs(expected, actual), nil
+}
+
+// waitForOutputs blocks until count output frames were read, the runtime's output
+// ended, or replayStepTimeout passed without progress
+func waitForOutputs(mu *sync.Mutex, actual *[]*Frame, count int, progress <-chan struct{}, readDone <-chan struct{}) {
+	timer := time.NewTimer(replayStepTimeout)
+	defer timer.Stop()
+	for {
+		mu.Lock()
+		n := len(*actual)
+		mu.Unlock()
+		if n >= count {
+			return
+		}
+		select {
+		case <-progress:
+		case <-readDone:
+			return
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// diffReplayOutputs compares output frames per request, in order of first appearance
+func diffReplayOutputs(expected, actual []*Frame) []ReplayMismatch {
+	var order []string
+	expectedByRequest := make(map[string][]string)
+	actualByRequest := make(map[string][]string)
+	group := func(frames []*Frame, byRequest map[string][]string) {
+		for _, frame := range frames {
+			switch frame.FrameType {
+			case FrameTypeHello, FrameTypeHeartbeat, FrameTypeLog:
+				continue
+			}
+			key := frame.Id.ToString()
+			if _, seen := expectedByRequest[key]; !seen {
+				if _, seen := actualByRequest[key]; !seen {
+					order = append(order, key)
+				}
+			}
+			byRequest[key] = append(byRequest[key], describeReplayFrame(frame))
+		}
+	}
+	group(expected, expectedByRequest)
+	group(actual, actualByRequest)
+
+	var mismatches []ReplayMismatch
+	for _, key := range order {
+		want, got := expectedByRequest[key], actualByRequest[key]
+		for i := 0; i < len(want) || i < len(got); i++ {
+			var mismatch ReplayMismatch
+			if i < len(want) {
+				mismatch.Expected = want[i]
+			}
+			if i < len(got) {
+				mismatch.Actual = got[i]
+			}
+			if mismatch.Expected != mismatch.Actual {
+				mismatch.RequestId = key
+				mismatch.Index = i
+				mismatches = append(mismatches, mismatch)
+			}
+		}
+	}
+	return mismatches
+}
+
+// describeReplayFrame renders the parts of an output frame replay compares
+func describeReplayFrame(frame *Frame) string {
+	description := frame.FrameType.String()
+	if frame.StreamId != nil {
+		description += " stream=" + *frame.StreamId
+	}
+	if frame.MediaUrn != nil {
+		description += " media=" + *frame.MediaUrn
+	}
+	if frame.FrameType == FrameTypeErr {
+		description += fmt.Sprintf(" code=%s message=%s", frame.ErrorCode(), frame.ErrorMessage())
+	}
+	if len(frame.Payload) > 0 {
+		description += fmt.Sprintf(" payload=%x", frame.Payload)
+	}
+	return description
+}
@@ -0,0 +1,66 @@
+package bifaci
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// echoRuntime returns a runtime whose test cap emits its input transformed by transform
+func echoRuntime(t *testing.T, transform func([]byte) []byte) *PluginRuntime {
+	t.Helper()
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		var input []byte
+		for frame := range frames {
+			if frame.FrameType == FrameTypeChunk {
+				input = append(input, frame.Payload...)
+			}
+		}
+		return emitter.EmitCbor(transform(input))
+	})
+	return runtime
+}
+
+// TestRecordAndReplay: a recorded session replays cleanly against the same handler
+// and reports the differing frames against a changed one
+func TestRecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.cbor")
+	recorder, err := CreateRecording(path)
+	if err != nil {
+		t.Fatalf("Failed to create recording: %v", err)
+	}
+
+	runtime := echoRuntime(t, func(b []byte) []byte { return b })
+	runtime.SetRecorder(recorder)
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	reqId := NewMessageIdRandom()
+	writeTestRequest(t, writer, reqId, testCancelCap, []byte("hello"))
+	readUntilTerminal(t, reader, reqId)
+	if err := stop(); err != nil {
+		t.Fatalf("Runtime exited with error: %v", err)
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Recording failed: %v", err)
+	}
+
+	mismatches, err := ReplayRecording(path, echoRuntime(t, func(b []byte) []byte { return b }))
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if len(mismatches) != 0 {
+		t.Errorf("Expected a faithful replay, got %v", mismatches)
+	}
+
+	mismatches, err = ReplayRecording(path, echoRuntime(t, bytes.ToUpper))
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if len(mismatches) != 1 || !strings.HasPrefix(mismatches[0].Actual, "CHUNK") {
+		t.Errorf("Expected the output chunk to differ, got %v", mismatches)
+	}
+}
@@ -258,6 +258,28 @@ func (sw *RelaySwitch) SendToMaster(frame *Frame, preferredCap *string) error {
 
 		return nil
 
+	case FrameTypeCancel:
+		// Routed like a continuation frame; routing is cleaned up by the terminal ERR
+		entry, ok := sw.requestRouting[frame.Id.ToString()]
+		if !ok {
+			return &RelaySwitchError{
+				Type:    RelaySwitchErrorTypeUnknownRequest,
+				Message: frame.Id.ToString(),
+			}
+		}
+		return sw.masters[entry.DestinationMasterIdx].socketWriter.WriteFrame(frame)
+
+	case FrameTypeSessionClose:
+		// A session may span requests routed to any master
+		for _, master := range sw.masters {
+			if master.healthy {
+				if err := master.socketWriter.WriteFrame(frame); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+
 	default:
 		return &RelaySwitchError{
 			Type:    RelaySwitchErrorTypeProtocol,
@@ -467,6 +489,16 @@ func (sw *RelaySwitch) handleMasterFrame(sourceIdx int, frame *Frame) (*Frame, e
 
 		return frame, nil
 
+	case FrameTypeCancel:
+		// Master aborts one of its peer requests — forward to the handling master
+		entry, ok := sw.requestRouting[frame.Id.ToString()]
+		if ok && entry.SourceMasterIdx == sourceIdx {
+			if err := sw.masters[entry.DestinationMasterIdx].socketWriter.WriteFrame(frame); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+
 	case FrameTypeRelayNotify:
 		// Capability update from host — update our cap table
 		manifest := frame.RelayNotifyManifest()
@@ -0,0 +1,36 @@
+package bifaci
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/machinefabric/capdag-go/cap"
+)
+
+// ResourceError reports a request exceeding a limit of its cap's resource policy
+// (see cap.ResourcePolicy). The runtime reports it as ERR RESOURCE_EXHAUSTED.
+type ResourceError struct {
+	Resource string // "payload", "wall_time" or "output"
+	Limit    int64  // Bytes, or milliseconds for wall_time
+}
+
+func (e *ResourceError) Error() string {
+	switch e.Resource {
+	case "wall_time":
+		return fmt.Sprintf("handler exceeded the cap's wall time limit of %v", time.Duration(e.Limit)*time.Millisecond)
+	case "payload":
+		return fmt.Sprintf("input exceeds the cap's payload limit of %d bytes", e.Limit)
+	}
+	return fmt.Sprintf("%s exceeds the cap's limit of %d bytes", e.Resource, e.Limit)
+}
+
+// resourcePolicy returns the resource policy the manifest declares for the cap a
+// handler is registered under, the zero policy if none
+func (pr *PluginRuntime) resourcePolicy(pattern string) cap.ResourcePolicy {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+	if capDef := pr.manifestCap(pattern); capDef != nil && capDef.Resources != nil {
+		return *capDef.Resources
+	}
+	return cap.ResourcePolicy{}
+}
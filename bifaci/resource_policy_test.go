@@ -0,0 +1,76 @@
+package bifaci
+
+import (
+	"bytes"
+	"testing"
+)
+
+// resourceManifest declares testCancelCap with a resource policy
+const resourceManifest = `{"name":"TestPlugin","version":"1.0.0","description":"Test plugin","caps":[{"urn":"cap:in=\"media:void\";op=test;out=\"media:void\"","title":"Test","command":"test","resources":{"max_payload_bytes":16,"max_wall_time_ms":50,"max_output_bytes":64}}]}`
+
+// runLimited sends a REQ with the given input to a handler under resourceManifest's
+// policy and returns the response frames
+func runLimited(t *testing.T, handler HandlerFunc, input []byte) []*Frame {
+	t.Helper()
+	runtime, err := NewPluginRuntime([]byte(resourceManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.Register(testCancelCap, handler)
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	defer stop()
+
+	reqId := NewMessageIdRandom()
+	writer.WriteFrame(NewReq(reqId, testCancelCap, nil, "application/cbor"))
+	if input != nil {
+		writer.WriteFrame(NewStreamStart(reqId, "arg-0", "media:"))
+		writer.WriteFrame(NewChunk(reqId, "arg-0", 1, input, 0, ComputeChecksum(input)))
+		writer.WriteFrame(NewStreamEnd(reqId, "arg-0", 1))
+	}
+	writer.WriteFrame(NewEnd(reqId, nil))
+	return readUntilTerminal(t, reader, reqId)
+}
+
+func expectResourceExhausted(t *testing.T, frames []*Frame, resource string) {
+	t.Helper()
+	last := frames[len(frames)-1]
+	if last.FrameType != FrameTypeErr || last.ErrorCode() != string(ErrCodeResourceExhausted) {
+		t.Fatalf("Expected ERR RESOURCE_EXHAUSTED for the %s limit, got %v %s", resource, last.FrameType, last.ErrorCode())
+	}
+}
+
+func TestResourcePolicyPayload(t *testing.T) {
+	frames := runLimited(t, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		return nil
+	}, bytes.Repeat([]byte("x"), 32))
+	expectResourceExhausted(t, frames, "payload")
+}
+
+func TestResourcePolicyWallTime(t *testing.T) {
+	frames := runLimited(t, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		<-HandlerContext(emitter).Done()
+		return nil
+	}, nil)
+	expectResourceExhausted(t, frames, "wall time")
+}
+
+// Output past the limit is cut short: the ERR lists the primary stream as partial
+func TestResourcePolicyOutput(t *testing.T) {
+	frames := runLimited(t, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		for i := 0; i < 10; i++ {
+			if err := emitter.EmitCbor(bytes.Repeat([]byte("y"), 20)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil)
+	expectResourceExhausted(t, frames, "output")
+	partial := frames[len(frames)-1].PartialStreams()
+	if len(partial) != 1 || partial[0].ChunkCount == 0 {
+		t.Errorf("Expected the output sent before the limit as partial, got %+v", partial)
+	}
+}
@@ -0,0 +1,309 @@
+package bifaci
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+	"github.com/machinefabric/capdag-go/cap"
+	"github.com/machinefabric/capdag-go/urn"
+)
+
+// ResponseCache stores the responses of cacheable caps by request (see
+// PluginHost.SetResponseCache). Implementations must be safe for concurrent use.
+type ResponseCache interface {
+	// Get returns the response frames stored under key, unless they expired
+	Get(key string) ([]*Frame, bool)
+	// Put stores the response frames of a request under key for ttl (0 = until evicted)
+	Put(key string, frames []*Frame, ttl time.Duration)
+}
+
+// cacheKeyEncMode encodes cache keys' CBOR the same for equal requests
+var cacheKeyEncMode, _ = cborlib.CanonicalEncOptions().EncMode()
+
+// ResponseCacheKey returns the key of a request in a ResponseCache: a digest of the cap
+// URN in canonical form and the arguments, their media URNs canonical too, as canonical
+// CBOR in order. Requests differing only in how their URNs are written have the same key.
+func ResponseCacheKey(capUrn string, arguments []cap.CapArgumentValue) (string, error) {
+	canonicalCap, err := urn.Canonicalize(capUrn)
+	if err != nil {
+		return "", err
+	}
+	encodedArgs := make([]interface{}, len(arguments))
+	for i, argument := range arguments {
+		mediaUrn, err := urn.Canonicalize(argument.MediaUrn)
+		if err != nil {
+			return "", fmt.Errorf("argument %d: %w", i, err)
+		}
+		encodedArgs[i] = []interface{}{mediaUrn, argument.Value}
+	}
+	encoded, err := cacheKeyEncMode.Marshal([]interface{}{canonicalCap, encodedArgs})
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256(encoded)
+	return hex.EncodeToString(digest[:]), nil
+}
+
+// requestCacheKey returns the ResponseCacheKey of a request from its frames, REQ first.
+// An argument's value is its chunks' byte or text strings joined, so the key doesn't
+// depend on how the argument was chunked.
+func requestCacheKey(frames []*Frame) (string, error) {
+	if len(frames) == 0 || frames[0].Cap == nil {
+		return "", errors.New("request has no cap URN")
+	}
+	input := make(chan Frame, len(frames))
+	for _, frame := range frames[1:] {
+		input <- *frame
+	}
+	close(input)
+	streams, err := CollectStreams(input)
+	if err != nil {
+		return "", err
+	}
+	arguments := make([]cap.CapArgumentValue, len(streams))
+	for i, stream := range streams {
+		value, err := argumentValue(stream.Data)
+		if err != nil {
+			return "", err
+		}
+		arguments[i] = cap.CapArgumentValue{MediaUrn: stream.MediaUrn, Value: value}
+	}
+	return ResponseCacheKey(*frames[0].Cap, arguments)
+}
+
+// argumentValue returns the value of an argument stream from its chunks' CBOR values:
+// byte and text strings joined (see joinChunkValues), anything else canonical CBOR
+func argumentValue(data []byte) ([]byte, error) {
+	values, err := decodeCborSequence(data)
+	if err != nil || len(values) == 0 {
+		return nil, err
+	}
+	switch value := joinChunkValues(values).(type) {
+	case []byte:
+		return value, nil
+	case string:
+		return []byte(value), nil
+	default:
+		return cacheKeyEncMode.Marshal(value)
+	}
+}
+
+// LRUResponseCache is an in-memory ResponseCache keeping the most recently used
+// responses
+type LRUResponseCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // *cachedResponse, most recently used first
+	entries  map[string]*list.Element
+	now      func() time.Time
+}
+
+type cachedResponse struct {
+	key     string
+	frames  []*Frame
+	expires time.Time // Zero if the response doesn't expire
+}
+
+// NewLRUResponseCache returns a cache of up to capacity responses, evicting the least
+// recently used one past it
+func NewLRUResponseCache(capacity int) *LRUResponseCache {
+	return &LRUResponseCache{capacity: capacity, order: list.New(), entries: make(map[string]*list.Element), now: time.Now}
+}
+
+func (c *LRUResponseCache) Get(key string) ([]*Frame, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	response := element.Value.(*cachedResponse)
+	if !response.expires.IsZero() && !c.now().Before(response.expires) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return response.frames, true
+}
+
+func (c *LRUResponseCache) Put(key string, frames []*Frame, ttl time.Duration) {
+	if c.capacity <= 0 {
+		return
+	}
+	response := &cachedResponse{key: key, frames: frames}
+	if ttl > 0 {
+		response.expires = c.now().Add(ttl)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.order.Remove(element)
+	}
+	c.entries[key] = c.order.PushFront(response)
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedResponse).key)
+	}
+}
+
+// Len returns how many responses are cached, expired ones included until looked up
+func (c *LRUResponseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// cacheableRequest is a request to a cacheable cap the host routes (see
+// SetResponseCache)
+type cacheableRequest struct {
+	ttl      time.Duration
+	held     []*Frame // Request frames held back until its END, nil once it was sent on
+	key      string   // ResponseCacheKey, set once the request was sent on
+	response []*Frame // Response frames so far
+}
+
+// SetResponseCache makes the host answer requests for caps their plugin's manifest
+// marks cacheable from cache: the frames of such a request are held back until its END,
+// and a request with the ResponseCacheKey of an earlier one that succeeded gets the
+// earlier response replayed without reaching the plugin. A response is stored for the
+// cap's cache_ttl_ms; one ending with ERR isn't stored. nil disables caching.
+//
+// Holding back a request keeps all of its input in memory until its END, and the
+// response is kept until it ends too, however large either is: only mark caps with small
+// arguments and responses cacheable.
+func (h *PluginHost) SetResponseCache(cache ResponseCache) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.responseCache = cache
+	if h.cacheable == nil {
+		h.cacheable = make(map[string]*cacheableRequest)
+	}
+}
+
+// holdCacheableLocked starts holding back the frames of a REQ for a cacheable cap,
+// reporting whether it did. Caller holds mu.
+func (h *PluginHost) holdCacheableLocked(idKey string, pluginIdx int, frame *Frame) bool {
+	if h.responseCache == nil || frame.Cap == nil {
+		return false
+	}
+	ttl, ok := h.plugins[pluginIdx].cacheTTLFor(*frame.Cap)
+	if !ok {
+		return false
+	}
+	h.cacheable[idKey] = &cacheableRequest{ttl: ttl, held: []*Frame{frame}}
+	return true
+}
+
+// holdFrameLocked holds back a frame of a request held back, reporting whether it did.
+// Caller holds mu.
+func (h *PluginHost) holdFrameLocked(idKey string, frame *Frame) bool {
+	request, ok := h.cacheable[idKey]
+	if !ok || request.held == nil {
+		return false
+	}
+	request.held = append(request.held, frame)
+	return true
+}
+
+// releaseCacheableLocked ends holding back a request on its END: it is answered from
+// cache if it can be, else its frames are sent on to the plugin. Caller holds mu.
+func (h *PluginHost) releaseCacheableLocked(idKey string, entry routingEntry, end *Frame, relayWriter *FrameWriter) {
+	request := h.cacheable[idKey]
+	frames := append(request.held, end)
+	request.held = nil
+
+	key, err := requestCacheKey(frames)
+	if err != nil || h.responseCache == nil {
+		// Not cached; the plugin tells the caller what is wrong with the request
+		delete(h.cacheable, idKey)
+	} else if response, ok := h.responseCache.Get(key); ok {
+		for _, frame := range response {
+			replayed := *frame
+			replayed.Id = entry.msgId
+			replayed.RoutingId = frames[0].RoutingId
+			if err := relayWriter.WriteFrame(&replayed); err != nil {
+				h.logger.Error("failed to replay cached response", "req_id", idKey, "error", err)
+				break
+			}
+		}
+		delete(h.cacheable, idKey)
+		delete(h.requestRouting, idKey)
+		return
+	} else {
+		request.key = key
+	}
+	for _, frame := range frames {
+		h.trackRelayFrameLocked(idKey, frame)
+		h.sendToPlugin(entry.pluginIdx, frame)
+	}
+}
+
+// noteCacheableLocked records a plugin's response to a cacheable request, storing it
+// once it ends with END. Caller holds mu.
+func (h *PluginHost) noteCacheableLocked(idKey string, frame *Frame) {
+	request, ok := h.cacheable[idKey]
+	if !ok || request.held != nil {
+		return
+	}
+	switch frame.FrameType {
+	case FrameTypeStreamStart, FrameTypeChunk, FrameTypeStreamEnd:
+		request.response = append(request.response, frame)
+	case FrameTypeEnd:
+		if h.responseCache != nil {
+			h.responseCache.Put(request.key, append(request.response, frame), request.ttl)
+		}
+		delete(h.cacheable, idKey)
+	case FrameTypeErr:
+		delete(h.cacheable, idKey)
+	}
+}
+
+// cacheTTLFor reports whether the plugin's cap serving capUrn, the first of its manifest
+// the request accepts, is cacheable, and for how long
+func (p *ManagedPlugin) cacheTTLFor(capUrn string) (time.Duration, bool) {
+	if ttl, ok := p.cacheTTL[capUrn]; ok {
+		return ttl, true
+	}
+	requestUrn, err := urn.NewCapUrnFromString(capUrn)
+	if err != nil {
+		return 0, false
+	}
+	for _, registered := range p.caps {
+		registeredUrn, err := urn.NewCapUrnFromString(registered)
+		if err == nil && requestUrn.Accepts(registeredUrn) {
+			ttl, ok := p.cacheTTL[registered]
+			return ttl, ok
+		}
+	}
+	return 0, false
+}
+
+// parseCacheableCaps returns the caps a JSON manifest marks cacheable, with their TTLs
+func parseCacheableCaps(manifest []byte) map[string]time.Duration {
+	var parsed struct {
+		Caps []struct {
+			Urn        string `json:"urn"`
+			Cacheable  bool   `json:"cacheable"`
+			CacheTTLMs int64  `json:"cache_ttl_ms"`
+		} `json:"caps"`
+	}
+	if len(manifest) == 0 || json.Unmarshal(manifest, &parsed) != nil {
+		return nil
+	}
+	caps := make(map[string]time.Duration)
+	for _, c := range parsed.Caps {
+		if c.Cacheable && c.Urn != "" {
+			caps[c.Urn] = time.Duration(c.CacheTTLMs) * time.Millisecond
+		}
+	}
+	return caps
+}
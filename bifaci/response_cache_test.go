@@ -0,0 +1,168 @@
+package bifaci
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+	"github.com/machinefabric/capdag-go/cap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRUResponseCache(t *testing.T) {
+	now := time.Unix(0, 0)
+	cache := NewLRUResponseCache(2)
+	cache.now = func() time.Time { return now }
+	end := []*Frame{NewEnd(NewMessageIdRandom(), nil)}
+
+	cache.Put("a", end, 0)
+	cache.Put("b", end, time.Second)
+	_, ok := cache.Get("a") // a is now the most recently used
+	require.True(t, ok)
+	cache.Put("c", end, 0)
+	_, ok = cache.Get("b")
+	assert.False(t, ok, "the least recently used response is evicted")
+	assert.Equal(t, 2, cache.Len())
+
+	_, ok = cache.Get("a") // c is now the least recently used, and evicted by d
+	require.True(t, ok)
+	cache.Put("d", end, time.Second)
+	now = now.Add(time.Second)
+	_, ok = cache.Get("d")
+	assert.False(t, ok, "an expired response is not returned")
+	_, ok = cache.Get("a")
+	assert.True(t, ok, "a response without TTL doesn't expire")
+}
+
+func TestResponseCacheKey(t *testing.T) {
+	args := []cap.CapArgumentValue{{MediaUrn: "media:textable;txt", Value: []byte("hello")}}
+	key, err := ResponseCacheKey(`cap:op=hash;in="media:txt;textable";out="media:textable"`, args)
+	require.NoError(t, err)
+	same, err := ResponseCacheKey(`cap:in="media:textable;txt";op=hash;out="media:textable"`,
+		[]cap.CapArgumentValue{{MediaUrn: "media:txt;textable", Value: []byte("hello")}})
+	require.NoError(t, err)
+	assert.Equal(t, key, same, "URNs written differently are the same request")
+
+	other, err := ResponseCacheKey(`cap:op=hash;in="media:txt;textable";out="media:textable"`,
+		[]cap.CapArgumentValue{{MediaUrn: "media:textable;txt", Value: []byte("world")}})
+	require.NoError(t, err)
+	assert.NotEqual(t, key, other)
+
+	_, err = ResponseCacheKey("not a urn", nil)
+	assert.Error(t, err)
+}
+
+// The key of a request doesn't depend on how its arguments were chunked
+func TestRequestCacheKeyIgnoresChunking(t *testing.T) {
+	request := func(parts ...string) []*Frame {
+		id := NewMessageIdRandom()
+		frames := []*Frame{NewReq(id, "cap:op=hash", nil, "application/cbor"), NewStreamStart(id, "arg", "media:textable")}
+		for i, part := range parts {
+			payload, _ := cborlib.Marshal([]byte(part))
+			frames = append(frames, NewChunk(id, "arg", 0, payload, uint64(i), ComputeChecksum(payload)))
+		}
+		return append(frames, NewStreamEnd(id, "arg", uint64(len(parts))), NewEnd(id, nil))
+	}
+	whole, err := requestCacheKey(request("hello"))
+	require.NoError(t, err)
+	split, err := requestCacheKey(request("hel", "lo"))
+	require.NoError(t, err)
+	assert.Equal(t, whole, split)
+	expected, err := ResponseCacheKey("cap:op=hash", []cap.CapArgumentValue{{MediaUrn: "media:textable", Value: []byte("hello")}})
+	require.NoError(t, err)
+	assert.Equal(t, expected, whole)
+}
+
+// A repeated request for a cacheable cap is answered from cache without reaching the plugin
+func TestHostResponseCache(t *testing.T) {
+	manifest := `{"name":"Test","version":"1.0","caps":[{"urn":"cap:op=hash","cacheable":true,"cache_ttl_ms":60000}]}`
+	hostReadP, pluginWriteP := net.Pipe()
+	pluginReadP, hostWriteP := net.Pipe()
+
+	var requests atomic.Int32
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		simulatePlugin(t, pluginReadP, pluginWriteP, manifest, func(r *FrameReader, w *FrameWriter) {
+			for {
+				frame, err := r.ReadFrame()
+				if err != nil {
+					return
+				}
+				if frame.FrameType == FrameTypeReq {
+					requests.Add(1)
+				}
+				if frame.FrameType != FrameTypeEnd {
+					continue
+				}
+				payload, _ := cborlib.Marshal([]byte("digest"))
+				w.WriteFrame(NewStreamStart(frame.Id, "out", "media:"))
+				w.WriteFrame(NewChunk(frame.Id, "out", 0, payload, 0, ComputeChecksum(payload)))
+				w.WriteFrame(NewStreamEnd(frame.Id, "out", 1))
+				w.WriteFrame(NewEnd(frame.Id, nil))
+			}
+		})
+		pluginReadP.Close()
+		pluginWriteP.Close()
+	}()
+
+	host := NewPluginHost()
+	host.SetResponseCache(NewLRUResponseCache(8))
+	_, err := host.AttachPlugin(hostReadP, hostWriteP)
+	require.NoError(t, err)
+
+	relayRead, engineWrite := net.Pipe()
+	engineRead, relayWrite := net.Pipe()
+	responses := make(chan []*Frame, 2)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		writer := NewFrameWriter(engineWrite)
+		reader := NewFrameReader(engineRead)
+		for i := 0; i < 2; i++ {
+			reqId := NewMessageIdRandom()
+			payload, _ := cborlib.Marshal([]byte("input"))
+			writer.WriteFrame(NewReq(reqId, "cap:op=hash", []byte{}, "application/cbor"))
+			writer.WriteFrame(NewStreamStart(reqId, "arg", "media:"))
+			writer.WriteFrame(NewChunk(reqId, "arg", 0, payload, 0, ComputeChecksum(payload)))
+			writer.WriteFrame(NewStreamEnd(reqId, "arg", 1))
+			writer.WriteFrame(NewEnd(reqId, nil))
+
+			var frames []*Frame
+			for {
+				frame, err := reader.ReadFrame()
+				if err != nil {
+					break
+				}
+				if frame.Id.Equals(reqId) {
+					frames = append(frames, frame)
+				}
+				if frame.FrameType == FrameTypeEnd || frame.FrameType == FrameTypeErr {
+					break
+				}
+			}
+			responses <- frames
+		}
+		engineWrite.Close()
+		engineRead.Close()
+	}()
+
+	host.Run(relayRead, relayWrite, nil)
+	relayRead.Close()
+	relayWrite.Close()
+	hostReadP.Close()
+	hostWriteP.Close()
+	wg.Wait()
+
+	first, second := <-responses, <-responses
+	require.Len(t, first, 4)
+	require.Len(t, second, 4, "the cached response is replayed under the new request's ID")
+	assert.Equal(t, first[1].Payload, second[1].Payload)
+	assert.Equal(t, FrameTypeEnd, second[3].FrameType)
+	assert.Equal(t, int32(1), requests.Load(), "the plugin sees the request once")
+}
@@ -0,0 +1,251 @@
+package bifaci
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/machinefabric/capdag-go/urn"
+)
+
+// Route kinds listed by Routes
+const (
+	RouteExact   = "exact"   // Registered with Register
+	RoutePattern = "pattern" // Registered with RegisterPattern
+)
+
+// Route is a registered handler as listed by Routes
+type Route struct {
+	Pattern     string
+	Kind        string // RouteExact or RoutePattern
+	Specificity int    // CapUrn specificity of the pattern, -1 if it doesn't parse
+}
+
+// RouteConflictError is returned for a request two pattern routes of the same
+// specificity both accept
+type RouteConflictError struct {
+	CapUrn   string
+	Patterns []string
+}
+
+func (e *RouteConflictError) Error() string {
+	return fmt.Sprintf("cap %s is matched equally well by patterns %s", e.CapUrn, strings.Join(e.Patterns, " and "))
+}
+
+// patternRoute is a handler registered with RegisterPattern
+type patternRoute struct {
+	pattern string
+	urn     *urn.CapUrn
+	handler HandlerFunc
+}
+
+// RegisterPattern registers a handler for every request the cap URN pattern accepts,
+// e.g. `cap:in="media:bytes";op=convert` for conversions of any bytes input to any
+// output. Tags the pattern leaves out or sets to * match any value, and its in/out specs
+// match media URNs carrying at least their tags.
+//
+// A request is routed, in order of precedence, to:
+//  1. the handler registered with Register under exactly the requested URN
+//  2. the most specific pattern route accepting the request; two such routes of the
+//     same specificity are a conflict, answered with ERR ROUTE_CONFLICT
+//  3. the handler registered with Register whose URN is closest in specificity to the
+//     request among those the request accepts (see FindHandler)
+//
+// An error is returned for a pattern that doesn't parse or is already registered.
+func (pr *PluginRuntime) RegisterPattern(pattern string, handler HandlerFunc) error {
+	patternUrn, err := urn.NewCapUrnFromString(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid cap URN pattern %q: %w", pattern, err)
+	}
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	for _, route := range pr.patterns {
+		if route.urn.Equals(patternUrn) {
+			return fmt.Errorf("cap URN pattern %q is already registered as %q", pattern, route.pattern)
+		}
+	}
+	pr.patterns = append(pr.patterns, patternRoute{pattern: pattern, urn: patternUrn, handler: handler})
+	return nil
+}
+
+// Routes lists the registered handlers in order of precedence: exact registrations by
+// URN, then pattern routes from most to least specific
+func (pr *PluginRuntime) Routes() []Route {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+
+	exact := make([]Route, 0, len(pr.handlers))
+	for capUrn := range pr.handlers {
+		specificity := -1
+		if parsed, err := urn.NewCapUrnFromString(capUrn); err == nil {
+			specificity = parsed.Specificity()
+		}
+		exact = append(exact, Route{Pattern: capUrn, Kind: RouteExact, Specificity: specificity})
+	}
+	sort.Slice(exact, func(i, j int) bool { return exact[i].Pattern < exact[j].Pattern })
+
+	patterns := make([]Route, len(pr.patterns))
+	for i, route := range pr.patterns {
+		patterns[i] = Route{Pattern: route.pattern, Kind: RoutePattern, Specificity: route.urn.Specificity()}
+	}
+	sort.SliceStable(patterns, func(i, j int) bool { return patterns[i].Specificity > patterns[j].Specificity })
+
+	return append(exact, patterns...)
+}
+
+// matchPattern returns the most specific pattern route accepting requestUrn, nil if
+// there is none. Caller holds pr.mu.
+func (pr *PluginRuntime) matchPattern(capUrn string, requestUrn *urn.CapUrn) (*patternRoute, error) {
+	var best []*patternRoute
+	bestSpecificity := -1
+	for i := range pr.patterns {
+		route := &pr.patterns[i]
+		if !route.urn.Accepts(requestUrn) {
+			continue
+		}
+		switch specificity := route.urn.Specificity(); {
+		case specificity > bestSpecificity:
+			best = []*patternRoute{route}
+			bestSpecificity = specificity
+		case specificity == bestSpecificity:
+			best = append(best, route)
+		}
+	}
+	if len(best) > 1 {
+		patterns := make([]string, len(best))
+		for i, route := range best {
+			patterns[i] = route.pattern
+		}
+		return nil, &RouteConflictError{CapUrn: capUrn, Patterns: patterns}
+	}
+	if len(best) == 0 {
+		return nil, nil
+	}
+	return best[0], nil
+}
+
+// RouteTraceEnvVar names the environment variable that, set to a non-empty value,
+// makes the runtime answer each REQ with a LOG frame explaining its routing (see
+// ExplainRoute) before anything else
+const RouteTraceEnvVar = "CAPDAG_ROUTE_TRACE"
+
+// RouteCandidate is a registered handler considered for a request (see ExplainRoute)
+type RouteCandidate struct {
+	Pattern     string
+	Kind        string // RouteExact or RoutePattern
+	Accepted    bool   // Matched: the request accepts an exact route, a pattern route accepts the request
+	Specificity int    // CapUrn specificity of the pattern, -1 if it doesn't parse
+	Distance    int    // How far Specificity is from the request's, which exact routes are picked by (-1 if either doesn't parse)
+	Chosen      bool   // The request is routed to this handler
+}
+
+func (c RouteCandidate) String() string {
+	state := "rejected"
+	switch {
+	case c.Chosen:
+		state = "chosen"
+	case c.Accepted:
+		state = "accepted"
+	}
+	return fmt.Sprintf("%s (%s, specificity %d, distance %d, %s)", c.Pattern, c.Kind, c.Specificity, c.Distance, state)
+}
+
+// RouteExplanation is how a request would be routed (see ExplainRoute)
+type RouteExplanation struct {
+	CapUrn      string
+	Specificity int              // CapUrn specificity of the request, -1 if it doesn't parse
+	Candidates  []RouteCandidate // In the order of Routes
+	Winner      string           // Pattern of the handler the request is routed to, "" if none
+	Err         error            // Why the request isn't routed, e.g. a *RouteConflictError
+}
+
+// String describes the explanation on one line per candidate
+func (e RouteExplanation) String() string {
+	var b strings.Builder
+	switch {
+	case e.Err != nil:
+		fmt.Fprintf(&b, "route %s: %v", e.CapUrn, e.Err)
+	case e.Winner == "":
+		fmt.Fprintf(&b, "route %s: no handler", e.CapUrn)
+	default:
+		fmt.Fprintf(&b, "route %s -> %s", e.CapUrn, e.Winner)
+	}
+	for _, candidate := range e.Candidates {
+		b.WriteString("\n  ")
+		b.WriteString(candidate.String())
+	}
+	return b.String()
+}
+
+// ExplainRoute explains how a request for capUrn would be routed: every registered
+// handler considered with whether it matched, its specificity and distance from the
+// request, and which one won (see RegisterPattern for the order of precedence). It
+// helps diagnose misrouted requests, e.g. the identity handler winning a route a more
+// specific handler was meant for.
+func (pr *PluginRuntime) ExplainRoute(capUrn string) RouteExplanation {
+	explanation := RouteExplanation{CapUrn: capUrn, Specificity: -1}
+	requestUrn, parseErr := urn.NewCapUrnFromString(capUrn)
+	if parseErr == nil {
+		explanation.Specificity = requestUrn.Specificity()
+	}
+
+	winner, handler, err := pr.findHandler(capUrn)
+	explanation.Err = err
+	if handler != nil {
+		explanation.Winner = winner
+	} else if err == nil && parseErr != nil {
+		explanation.Err = fmt.Errorf("invalid cap URN %q: %w", capUrn, parseErr)
+	}
+
+	pr.mu.RLock()
+	patterns := make(map[string]*urn.CapUrn, len(pr.patterns))
+	for _, route := range pr.patterns {
+		patterns[route.pattern] = route.urn
+	}
+	pr.mu.RUnlock()
+
+	for _, route := range pr.Routes() {
+		candidate := RouteCandidate{Pattern: route.Pattern, Kind: route.Kind, Specificity: route.Specificity, Distance: -1}
+		if requestUrn != nil && route.Specificity >= 0 {
+			candidate.Distance = route.Specificity - explanation.Specificity
+			if candidate.Distance < 0 {
+				candidate.Distance = -candidate.Distance
+			}
+			if route.Kind == RoutePattern {
+				candidate.Accepted = patterns[route.Pattern].Accepts(requestUrn)
+			} else if registeredUrn, err := urn.NewCapUrnFromString(route.Pattern); err == nil {
+				candidate.Accepted = requestUrn.Accepts(registeredUrn)
+			}
+		}
+		if route.Pattern == capUrn && route.Kind == RouteExact {
+			candidate.Accepted = true
+		}
+		candidate.Chosen = explanation.Winner != "" && route.Pattern == explanation.Winner
+		explanation.Candidates = append(explanation.Candidates, candidate)
+	}
+	return explanation
+}
+
+// routeTraceFrame returns the LOG frame tracing the routing of a REQ (see
+// RouteTraceEnvVar)
+func (pr *PluginRuntime) routeTraceFrame(reqID MessageId, capUrn string) *Frame {
+	explanation := pr.ExplainRoute(capUrn)
+	candidates := make([]interface{}, len(explanation.Candidates))
+	for i, candidate := range explanation.Candidates {
+		candidates[i] = candidate.String()
+	}
+	attrs := map[string]interface{}{
+		"cap":         capUrn,
+		"specificity": explanation.Specificity,
+		"winner":      explanation.Winner,
+		"candidates":  candidates,
+	}
+	if explanation.Err != nil {
+		attrs["error"] = explanation.Err.Error()
+	}
+	message := fmt.Sprintf("route %s -> %s", capUrn, explanation.Winner)
+	if explanation.Winner == "" {
+		message = fmt.Sprintf("route %s: no handler", capUrn)
+	}
+	return NewLogWithAttrs(reqID, "debug", message, attrs)
+}
@@ -0,0 +1,184 @@
+package bifaci
+
+import (
+	"errors"
+	"testing"
+)
+
+// newRoutingRuntime returns a runtime without a cap manifest to route on
+func newRoutingRuntime(t *testing.T) *PluginRuntime {
+	t.Helper()
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	return runtime
+}
+
+// routedTo returns the pattern a request is routed to
+func routedTo(t *testing.T, runtime *PluginRuntime, capUrn string) (string, error) {
+	t.Helper()
+	pattern, handler, err := runtime.findHandler(capUrn)
+	if err == nil && handler == nil {
+		t.Fatalf("Expected a handler for %s", capUrn)
+	}
+	return pattern, err
+}
+
+func TestRegisterPatternPrecedence(t *testing.T) {
+	runtime := newRoutingRuntime(t)
+	noop := func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error { return nil }
+	const (
+		anyConvert  = `cap:in="media:bytes";op=convert;out="media:"`
+		pdfConvert  = `cap:in="media:pdf;bytes";op=convert;out="media:"`
+		exactPdfTxt = `cap:in="media:pdf;bytes";op=convert;out="media:textable"`
+	)
+	for _, pattern := range []string{anyConvert, pdfConvert} {
+		if err := runtime.RegisterPattern(pattern, noop); err != nil {
+			t.Fatalf("RegisterPattern(%s) failed: %v", pattern, err)
+		}
+	}
+	runtime.Register(exactPdfTxt, noop)
+
+	// An exact registration wins, then the most specific pattern
+	cases := map[string]string{
+		exactPdfTxt: exactPdfTxt,
+		`cap:in="media:pdf;bytes";op=convert;out="media:image;bytes"`: pdfConvert,
+		`cap:in="media:png;bytes";op=convert;out="media:image;bytes"`: anyConvert,
+	}
+	for request, expected := range cases {
+		if pattern, err := routedTo(t, runtime, request); err != nil || pattern != expected {
+			t.Errorf("Expected %s to route to %s, got %s (%v)", request, expected, pattern, err)
+		}
+	}
+
+	// A pattern doesn't match requests it doesn't accept
+	if _, handler, _ := runtime.findHandler(`cap:in="media:void";op=convert;out="media:"`); handler != nil {
+		t.Error("Expected no handler for a void input")
+	}
+
+	if err := runtime.RegisterPattern(`cap:op=convert;in="media:bytes";out="media:"`, noop); err == nil {
+		t.Error("Expected an error registering an equal pattern twice")
+	}
+	if err := runtime.RegisterPattern("not a urn", noop); err == nil {
+		t.Error("Expected an error for an invalid pattern")
+	}
+}
+
+// Two patterns of the same specificity accepting a request are a conflict
+func TestRegisterPatternConflict(t *testing.T) {
+	runtime := newRoutingRuntime(t)
+	noop := func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error { return nil }
+	runtime.RegisterPattern(`cap:in="media:pdf;bytes";op=convert;out="media:"`, noop)
+	runtime.RegisterPattern(`cap:in="media:bytes";op=convert;out="media:textable"`, noop)
+
+	_, err := routedTo(t, runtime, `cap:in="media:pdf;bytes";op=convert;out="media:textable"`)
+	var conflict *RouteConflictError
+	if !errors.As(err, &conflict) || len(conflict.Patterns) != 2 {
+		t.Fatalf("Expected a RouteConflictError naming both patterns, got %v", err)
+	}
+	if runtime.FindHandler(`cap:in="media:pdf;bytes";op=convert;out="media:textable"`) != nil {
+		t.Error("Expected FindHandler to return nil on a conflict")
+	}
+
+	// Requests only one of them accepts are unaffected
+	if _, err := routedTo(t, runtime, `cap:in="media:pdf;bytes";op=convert;out="media:image;bytes"`); err != nil {
+		t.Errorf("Expected no conflict, got %v", err)
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	runtime := newRoutingRuntime(t)
+	noop := func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error { return nil }
+	runtime.Register(testCancelCap, noop)
+	runtime.RegisterPattern(`cap:op=convert`, noop)
+	runtime.RegisterPattern(`cap:in="media:pdf;bytes";op=convert;out="media:"`, noop)
+
+	routes := runtime.Routes()
+	var kinds, patterns []string
+	for _, route := range routes {
+		kinds = append(kinds, route.Kind)
+		patterns = append(patterns, route.Pattern)
+	}
+	n := len(routes)
+	if n < 3 || kinds[0] != RouteExact || kinds[n-2] != RoutePattern || kinds[n-1] != RoutePattern {
+		t.Fatalf("Expected exact routes before pattern routes, got %v", kinds)
+	}
+	if patterns[n-2] != `cap:in="media:pdf;bytes";op=convert;out="media:"` || patterns[n-1] != `cap:op=convert` {
+		t.Errorf("Expected pattern routes from most to least specific, got %v", patterns[n-2:])
+	}
+	if routes[n-2].Specificity != 3 || routes[n-1].Specificity != 1 {
+		t.Errorf("Expected specificities 3 and 1, got %d and %d", routes[n-2].Specificity, routes[n-1].Specificity)
+	}
+}
+
+// The explanation agrees with the router: a request naming an op rejects the identity
+// handler, which lacks the tag, and one naming none is won by the identity handler
+// though a more specific handler also matches
+func TestExplainRoute(t *testing.T) {
+	runtime := newRoutingRuntime(t)
+	noop := func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error { return nil }
+	const extract = `cap:in="media:pdf;bytes";op=extract;out="media:textable"`
+	runtime.Register("cap:", noop)
+	runtime.Register(extract, noop)
+	runtime.RegisterPattern(`cap:op=convert`, noop)
+
+	explanation := runtime.ExplainRoute(`cap:in="media:pdf;bytes";op=extract;out="media:"`)
+	if explanation.Err != nil || explanation.Winner != extract {
+		t.Fatalf("Expected the request to route to %s, got %q (%v)", extract, explanation.Winner, explanation.Err)
+	}
+	candidates := make(map[string]RouteCandidate)
+	for _, candidate := range explanation.Candidates {
+		candidates[candidate.Pattern] = candidate
+	}
+	if c := candidates[extract]; !c.Accepted || !c.Chosen || c.Distance != 1 {
+		t.Errorf("Expected %s accepted and chosen at distance 1, got %+v", extract, c)
+	}
+	if c := candidates["cap:"]; c.Accepted || c.Chosen || c.Distance != explanation.Specificity {
+		t.Errorf("Expected the identity handler rejected, got %+v", c)
+	}
+	if c := candidates["cap:op=convert"]; c.Accepted || c.Kind != RoutePattern {
+		t.Errorf("Expected the convert pattern rejected, got %+v", c)
+	}
+
+	explanation = runtime.ExplainRoute(`cap:in=media:;out=media:`)
+	if explanation.Err != nil || explanation.Winner != "cap:" {
+		t.Fatalf("Expected the request to route to the identity handler, got %q (%v)", explanation.Winner, explanation.Err)
+	}
+	candidates = make(map[string]RouteCandidate)
+	for _, candidate := range explanation.Candidates {
+		candidates[candidate.Pattern] = candidate
+	}
+	if c := candidates["cap:"]; !c.Accepted || !c.Chosen || c.Distance != 0 {
+		t.Errorf("Expected the identity handler chosen at distance 0, got %+v", c)
+	}
+	if c := candidates[extract]; !c.Accepted || c.Chosen {
+		t.Errorf("Expected %s accepted but not chosen, got %+v", extract, c)
+	}
+	if pattern, _, _ := runtime.findHandler(`cap:in=media:;out=media:`); pattern != explanation.Winner {
+		t.Errorf("Expected the router to choose %s like the explanation, got %q", explanation.Winner, pattern)
+	}
+
+	if explanation := runtime.ExplainRoute("not a urn"); explanation.Err == nil || explanation.Winner != "" {
+		t.Errorf("Expected an invalid URN to be explained as unroutable, got %+v", explanation)
+	}
+}
+
+func TestRouteTraceFrame(t *testing.T) {
+	runtime := newRoutingRuntime(t)
+	noop := func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error { return nil }
+	runtime.RegisterPattern(`cap:in="media:pdf;bytes";op=convert;out="media:"`, noop)
+	runtime.RegisterPattern(`cap:in="media:bytes";op=convert;out="media:textable"`, noop)
+
+	frame := runtime.routeTraceFrame(NewMessageIdRandom(), `cap:in="media:pdf;bytes";op=convert;out="media:textable"`)
+	if frame.FrameType != FrameTypeLog || frame.LogLevel() != "debug" {
+		t.Fatalf("Expected a debug LOG frame, got %v %q", frame.FrameType, frame.LogLevel())
+	}
+	attrs := frame.LogAttrs()
+	if attrs["winner"] != "" || attrs["error"] == nil {
+		t.Errorf("Expected the conflict traced without a winner, got %v", attrs)
+	}
+	if candidates, _ := attrs["candidates"].([]interface{}); len(candidates) != 2 {
+		t.Errorf("Expected both patterns traced, got %v", attrs["candidates"])
+	}
+}
@@ -0,0 +1,316 @@
+package bifaci
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+	"github.com/machinefabric/capdag-go/cap"
+)
+
+// notifyFrameOverhead is room left in max_frame for the rest of a NOTIFY frame
+const notifyFrameOverhead = 1024
+
+// Notification is the outcome of a scheduled run of a cap, the payload of a NOTIFY frame
+// (see PluginRuntime.Schedule)
+type Notification struct {
+	Cap      string      `cbor:"cap"`
+	Schedule string      `cbor:"schedule"` // The cron expression the run was due by
+	RunMs    int64       `cbor:"run_ms"`   // Unix time the run was due
+	Output   []JobOutput `cbor:"output,omitempty"`
+	ErrCode  string      `cbor:"error_code,omitempty"` // Code of the ERR the run failed with
+	ErrMsg   string      `cbor:"error_message,omitempty"`
+}
+
+// Notification decodes the payload of a NOTIFY frame
+func (f *Frame) Notification() (*Notification, error) {
+	if f.FrameType != FrameTypeNotify {
+		return nil, fmt.Errorf("expected a NOTIFY frame, got %s", f.FrameType)
+	}
+	var notification Notification
+	if err := cborlib.Unmarshal(f.Payload, &notification); err != nil {
+		return nil, fmt.Errorf("invalid NOTIFY payload: %w", err)
+	}
+	return &notification, nil
+}
+
+// Schedule runs the handler of capUrn with arguments periodically, as a cron expression
+// says, and pushes the outcome of each run to every connected host in a NOTIFY frame,
+// for plugins that watch or poll something without an external cron. The expression has the five
+// fields minute, hour, day of month, month and day of week (0 or 7 is Sunday), each "*",
+// a value, a range "a-b", a step "*/n" or "a-b/n", or a list of them; or it is one of
+// @yearly, @monthly, @weekly, @daily, @hourly or "@every <duration>". Times are local.
+//
+// A run is skipped while the previous one of the schedule still runs, and while no host
+// is connected. The handler is found as for a request, and has no peer to invoke caps
+// on. stop ends the schedule and cancels a run in progress.
+func (pr *PluginRuntime) Schedule(cron string, capUrn string, arguments []cap.CapArgumentValue) (stop func(), err error) {
+	spec, err := parseCron(cron)
+	if err != nil {
+		return nil, err
+	}
+	pr.mu.RLock()
+	clock := pr.clock
+	pr.mu.RUnlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	scheduled := &scheduledCap{pr: pr, cron: cron, capUrn: capUrn, arguments: arguments}
+	go scheduled.loop(ctx, spec, clock)
+	var once sync.Once
+	return func() { once.Do(cancel) }, nil
+}
+
+// scheduledCap is a cap run on a schedule (see Schedule)
+type scheduledCap struct {
+	pr        *PluginRuntime
+	cron      string
+	capUrn    string
+	arguments []cap.CapArgumentValue
+	running   sync.Mutex // Held while a run is in progress
+}
+
+// loop runs the cap each time it is due until ctx is cancelled
+func (s *scheduledCap) loop(ctx context.Context, spec *cronSpec, clock Clock) {
+	for {
+		due := spec.next(time.Now())
+		if due.IsZero() {
+			s.pr.log().Warn("schedule never comes due", "cap", s.capUrn, "schedule", s.cron)
+			return
+		}
+		timer := clock.NewTimer(time.Until(due))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+		if !s.running.TryLock() {
+			s.pr.log().Warn("scheduled run skipped, the previous one still runs", "cap", s.capUrn, "schedule", s.cron)
+			continue
+		}
+		go func() {
+			defer s.running.Unlock()
+			s.run(ctx, due)
+		}()
+	}
+}
+
+// run runs the cap once and sends its outcome to the connected hosts
+func (s *scheduledCap) run(ctx context.Context, due time.Time) {
+	s.pr.mu.RLock()
+	conns := s.pr.connsLocked()
+	maxChunk := s.pr.limits.MaxChunk
+	maxFrame := s.pr.limits.MaxFrame
+	logger := s.pr.logger
+	s.pr.mu.RUnlock()
+	if len(conns) == 0 {
+		logger.Debug("scheduled run skipped, no host is connected", "cap", s.capUrn, "schedule", s.cron)
+		return
+	}
+
+	notification := &Notification{Cap: s.capUrn, Schedule: s.cron, RunMs: due.UnixMilli()}
+	_, handler, err := s.pr.findHandler(s.capUrn)
+	if err == nil && handler == nil {
+		err = NewCapError(ErrCodeNoHandler, fmt.Sprintf("no handler for scheduled cap %s", s.capUrn))
+	}
+	if err == nil {
+		err = s.invoke(ctx, handler, maxChunk, notification)
+	}
+	if err != nil {
+		errFrame := NewErrFromError(MessageId{}, err)
+		notification.ErrCode, notification.ErrMsg = errFrame.ErrorCode(), errFrame.ErrorMessage()
+	}
+
+	if ctx.Err() != nil {
+		return // Stopped: the run's outcome is of no interest anymore
+	}
+	payload, err := cborlib.Marshal(notification)
+	if err == nil && len(payload) > maxFrame-notifyFrameOverhead {
+		notification.Output = nil
+		notification.ErrCode = string(ErrCodeResourceExhausted)
+		notification.ErrMsg = fmt.Sprintf("output of %d bytes exceeds what a NOTIFY frame carries", len(payload))
+		payload, err = cborlib.Marshal(notification)
+	}
+	if err != nil {
+		logger.Error("failed to encode notification", "cap", s.capUrn, "error", err)
+		return
+	}
+	for _, conn := range conns {
+		if err := conn.WriteFrame(NewNotify(NewMessageIdRandom(), s.capUrn, payload)); err != nil {
+			logger.Error("failed to write NOTIFY", "cap", s.capUrn, "error", err)
+		}
+	}
+}
+
+// invoke runs the handler with the schedule's arguments, recording its output in the
+// notification
+func (s *scheduledCap) invoke(ctx context.Context, handler HandlerFunc, maxChunk int, notification *Notification) error {
+	input, err := argumentFrames(NewMessageIdRandom(), s.arguments, maxChunk)
+	if err != nil {
+		return err
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	recorder := &jobEmitter{ctx: runCtx, cancel: cancel, logger: s.pr.log, now: time.Now, job: &Job{CapUrn: s.capUrn, State: JobRunning}}
+	var handlerErr error
+	func() {
+		defer func() {
+			if p := recover(); p != nil {
+				handlerErr = fmt.Errorf("handler panicked: %v", p)
+			}
+		}()
+		handlerErr = handler(replayFrames(input), recorder, &noPeerInvoker{})
+	}()
+	recorder.finish(handlerErr)
+	cancel()
+
+	job := recorder.snapshot()
+	if job.State == JobFailed || job.State == JobCancelled {
+		return &CapError{Code: ErrCode(job.ErrCode), Message: job.ErrMsg}
+	}
+	notification.Output = job.Output
+	return nil
+}
+
+// cronSpec is a parsed cron expression (see Schedule)
+type cronSpec struct {
+	every                         time.Duration // Interval of "@every", 0 for fields
+	minute, hour, dom, month, dow uint64        // Bit i set if value i matches
+	domRestricted, dowRestricted  bool          // The day field isn't "*"
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseCron parses a cron expression
+func parseCron(expr string) (*cronSpec, error) {
+	expr = strings.TrimSpace(expr)
+	if interval, ok := strings.CutPrefix(expr, "@every "); ok {
+		every, err := time.ParseDuration(strings.TrimSpace(interval))
+		if err != nil || every <= 0 {
+			return nil, fmt.Errorf("invalid schedule %q: @every needs a positive duration", expr)
+		}
+		return &cronSpec{every: every}, nil
+	}
+	if fields, ok := cronDescriptors[expr]; ok {
+		expr = fields
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields, got %d", expr, len(fields))
+	}
+	spec := &cronSpec{}
+	bounds := []struct {
+		name     string
+		min, max int
+		bits     *uint64
+	}{
+		{"minute", 0, 59, &spec.minute},
+		{"hour", 0, 23, &spec.hour},
+		{"day of month", 1, 31, &spec.dom},
+		{"month", 1, 12, &spec.month},
+		{"day of week", 0, 7, &spec.dow},
+	}
+	for i, field := range fields {
+		bits, err := parseCronField(field, bounds[i].min, bounds[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %s: %w", expr, bounds[i].name, err)
+		}
+		*bounds[i].bits = bits
+	}
+	if spec.dow&(1<<7) != 0 {
+		spec.dow |= 1 // 7 is Sunday too
+	}
+	spec.domRestricted = fields[2] != "*"
+	spec.dowRestricted = fields[4] != "*"
+	return spec, nil
+}
+
+// parseCronField returns the values a cron field matches as a bit set
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepPart)
+			}
+			step = n
+		}
+		low, high := min, max
+		if rangePart != "*" {
+			from, to, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = strconv.Atoi(from); err != nil {
+				return 0, fmt.Errorf("invalid value %q", from)
+			}
+			high = low
+			if isRange {
+				if high, err = strconv.Atoi(to); err != nil {
+					return 0, fmt.Errorf("invalid value %q", to)
+				}
+			} else if hasStep {
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	if bits == 0 {
+		return 0, errors.New("matches nothing")
+	}
+	return bits, nil
+}
+
+// next returns the first time after t the schedule is due, zero if it never is
+func (c *cronSpec) next(t time.Time) time.Time {
+	if c.every > 0 {
+		return t.Add(c.every)
+	}
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches reports whether the day of t matches: when both day fields are restricted
+// either may match, as in cron
+func (c *cronSpec) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domRestricted && c.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
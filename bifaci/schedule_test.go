@@ -0,0 +1,94 @@
+package bifaci
+
+import (
+	"testing"
+	"time"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+	"github.com/machinefabric/capdag-go/cap"
+)
+
+func TestParseCron(t *testing.T) {
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	cases := []struct {
+		expr  string
+		after time.Time
+		want  time.Time
+	}{
+		{"*/15 * * * *", at(3, 4, 10, 7), at(3, 4, 10, 15)},
+		{"*/15 * * * *", at(3, 4, 10, 45), at(3, 4, 11, 0)},
+		{"0 9 * * 1-5", at(3, 7, 12, 0), at(3, 9, 9, 0)}, // Saturday → Monday
+		{"30 8 1,15 * *", at(3, 2, 0, 0), at(3, 15, 8, 30)},
+		{"0 0 1 1 *", at(3, 2, 0, 0), time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", at(3, 4, 10, 0), at(3, 4, 11, 0)},
+		{"0 12 * * 7", at(3, 4, 0, 0), at(3, 8, 12, 0)}, // 7 is Sunday
+		{"0 0 13 * 5", at(3, 4, 0, 0), at(3, 6, 0, 0)},  // The 13th or a Friday
+		{"0 0 31 2 *", at(3, 4, 0, 0), time.Time{}},     // Never
+		{"@every 90s", at(3, 4, 10, 0), at(3, 4, 10, 0).Add(90 * time.Second)},
+	}
+	for _, c := range cases {
+		spec, err := parseCron(c.expr)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", c.expr, err)
+			continue
+		}
+		if got := spec.next(c.after); !got.Equal(c.want) {
+			t.Errorf("%q after %v: expected %v, got %v", c.expr, c.after, c.want, got)
+		}
+	}
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "@every -1s", "@often"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+}
+
+func TestScheduleNotify(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.Register(testAsyncCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		raw, err := CollectFirstArg(frames)
+		if err != nil {
+			return err
+		}
+		var target []byte
+		if err := cborlib.Unmarshal(raw, &target); err != nil {
+			return err
+		}
+		return EmitText(emitter, "polled "+string(target))
+	})
+	reader, _, stop := startCBORRuntime(t, runtime)
+	defer stop()
+
+	stopSchedule, err := runtime.Schedule("@every 20ms", testAsyncCap, []cap.CapArgumentValue{{MediaUrn: "media:", Value: []byte("feed")}})
+	if err != nil {
+		t.Fatalf("Failed to schedule: %v", err)
+	}
+	defer stopSchedule()
+
+	var frame *Frame
+	for frame == nil || frame.FrameType != FrameTypeNotify {
+		if frame, err = reader.ReadFrame(); err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+	}
+	if frame.Cap == nil || *frame.Cap != testAsyncCap || frame.IsFlowFrame() {
+		t.Errorf("Expected an unsequenced NOTIFY for the scheduled cap, got %+v", frame)
+	}
+	notification, err := frame.Notification()
+	if err != nil {
+		t.Fatalf("Failed to decode notification: %v", err)
+	}
+	if notification.ErrCode != "" || notification.Schedule != "@every 20ms" || len(notification.Output) != 1 {
+		t.Fatalf("Expected the run's output, got %+v", notification)
+	}
+	var text string
+	if err := cborlib.Unmarshal(notification.Output[0].Cbor, &text); err != nil || text != "polled feed" {
+		t.Errorf("Expected the handler's text, got %q %v", text, err)
+	}
+}
@@ -0,0 +1,167 @@
+package bifaci
+
+// flowQueueDepth is the number of writes a flow may have queued; further writers of
+// the flow wait for room
+const flowQueueDepth = 16
+
+// queuedWrite is a WriteFrame or WriteFrames waiting for its turn
+type queuedWrite struct {
+	frames   []*Frame
+	priority int
+	done     chan error // Receives the result of the write
+}
+
+// flowQueue holds the queued writes of one flow, oldest first
+type flowQueue struct {
+	key    FlowKey
+	writes []*queuedWrite
+}
+
+// pop takes the oldest write off the queue
+func (q *flowQueue) pop() *queuedWrite {
+	write := q.writes[0]
+	q.writes[0] = nil
+	q.writes = q.writes[1:]
+	if len(q.writes) == 0 {
+		q.writes = nil
+	}
+	return write
+}
+
+// send queues frames, written together, behind the writes of the first one's flow and
+// waits until they are written. The frames aren't used once send returns.
+func (s *syncFrameWriter) send(priority int, frames []*Frame) error {
+	write := &queuedWrite{frames: frames, priority: priority, done: make(chan error, 1)}
+	s.mu.Lock()
+	for {
+		if s.isStalled() {
+			s.mu.Unlock()
+			return ErrWriteStalled
+		}
+		queue := s.queueFor(frames[0])
+		if len(queue.writes) < flowQueueDepth {
+			queue.writes = append(queue.writes, write)
+			break
+		}
+		s.space.Wait()
+	}
+	if !s.draining {
+		s.draining = true
+		go s.drain()
+	}
+	s.mu.Unlock()
+
+	select {
+	case err := <-write.done:
+		return err
+	case <-s.stalled:
+		// A write finishing as the writer stalls still succeeded
+		select {
+		case err := <-write.done:
+			return err
+		default:
+			return ErrWriteStalled
+		}
+	}
+}
+
+// queueFor returns the queue of frame's flow, adding it to the ring if the flow has
+// nothing queued. Caller holds s.mu.
+func (s *syncFrameWriter) queueFor(frame *Frame) *flowQueue {
+	if !frame.IsFlowFrame() {
+		return &s.control
+	}
+	key := FlowKeyFromFrame(frame)
+	queue := s.flows[key]
+	if queue == nil {
+		queue = &flowQueue{key: key}
+		s.flows[key] = queue
+		s.ring = append(s.ring, queue)
+	}
+	return queue
+}
+
+// nextWrite takes the next write to make off the queues, nil if they are empty. Frames
+// outside any flow go first, then the oldest write of the flow whose is most urgent;
+// flows of the same priority take turns. Caller holds s.mu.
+func (s *syncFrameWriter) nextWrite() *queuedWrite {
+	if len(s.control.writes) > 0 {
+		return s.control.pop()
+	}
+	best := -1
+	for i := range s.ring {
+		j := (s.next + i) % len(s.ring)
+		if best < 0 || s.ring[j].writes[0].priority > s.ring[best].writes[0].priority {
+			best = j
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+
+	queue := s.ring[best]
+	write := queue.pop()
+	s.next = best + 1
+	if len(queue.writes) == 0 {
+		delete(s.flows, queue.key)
+		s.ring = append(s.ring[:best], s.ring[best+1:]...)
+		s.next = best
+	}
+	if len(s.ring) > 0 {
+		s.next %= len(s.ring)
+	} else {
+		s.next = 0
+	}
+	return write
+}
+
+// drain makes the queued writes until the queues are empty or the writer stalled. One
+// drain runs at a time.
+func (s *syncFrameWriter) drain() {
+	for {
+		s.mu.Lock()
+		write := s.nextWrite()
+		if write == nil || s.isStalled() {
+			s.draining = false
+			s.mu.Unlock()
+			return
+		}
+		s.space.Broadcast()
+		s.writer.SetLimits(s.limits)
+		s.mu.Unlock()
+		write.done <- s.write(write.frames)
+	}
+}
+
+// write writes the frames of one queued write to the transport. Called by drain only.
+func (s *syncFrameWriter) write(frames []*Frame) error {
+	// Centralized seq assignment — all flow frames get monotonic seq per flow
+	for _, frame := range frames {
+		s.seqAssigner.Assign(frame)
+	}
+	var err error
+	if len(frames) == 1 {
+		err = s.timedWrite(func() error { return s.writer.WriteFrame(frames[0]) })
+	} else {
+		err = s.timedWrite(func() error { return s.writer.WriteFrames(frames...) })
+	}
+	// Clean up flow tracking after terminal frames
+	if err == nil {
+		for _, frame := range frames {
+			if frame.FrameType == FrameTypeEnd || frame.FrameType == FrameTypeErr {
+				s.seqAssigner.Remove(FlowKeyFromFrame(frame))
+			}
+		}
+	}
+	return err
+}
+
+// isStalled reports whether a write stalled (see SetWriteTimeout)
+func (s *syncFrameWriter) isStalled() bool {
+	select {
+	case <-s.stalled:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,117 @@
+package bifaci
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// blockedWriter returns a writer whose writes block until frames are read from the
+// returned reader, with the frame of flow blocker being written
+func blockedWriter(t *testing.T) (*syncFrameWriter, *FrameReader) {
+	t.Helper()
+	pr, pw := io.Pipe()
+	t.Cleanup(func() { pr.Close() })
+	writer := newSyncFrameWriter(NewFrameWriter(pw))
+	go writer.WriteFrame(NewLog(NewMessageIdRandom(), "info", "blocker"))
+	waitQueued(t, writer, 0)
+	return writer, NewFrameReader(pr)
+}
+
+// waitQueued waits until n writes wait in the writer's queues
+func waitQueued(t *testing.T, writer *syncFrameWriter, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		writer.mu.Lock()
+		queued := len(writer.control.writes)
+		for _, queue := range writer.ring {
+			queued += len(queue.writes)
+		}
+		draining := writer.draining
+		writer.mu.Unlock()
+		if queued == n && draining {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d queued writes, got %d", n, queued)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// readLogMessages reads n frames, returning their LOG messages ("" for other frames)
+func readLogMessages(t *testing.T, reader *FrameReader, n int) []string {
+	t.Helper()
+	var messages []string
+	for i := 0; i < n; i++ {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		messages = append(messages, frame.LogMessage())
+	}
+	return messages
+}
+
+// A flow with a backlog takes turns with the others instead of holding them up
+func TestSendQueuesTakeTurns(t *testing.T) {
+	writer, reader := blockedWriter(t)
+	a, b, c := NewMessageIdRandom(), NewMessageIdRandom(), NewMessageIdRandom()
+	queued := 0
+	for _, log := range []struct {
+		id      MessageId
+		message string
+	}{{a, "a1"}, {a, "a2"}, {a, "a3"}, {b, "b1"}, {c, "c1"}} {
+		go writer.WriteFrame(NewLog(log.id, "info", log.message))
+		queued++
+		waitQueued(t, writer, queued)
+	}
+
+	messages := readLogMessages(t, reader, 6)
+	expected := []string{"blocker", "a1", "b1", "c1", "a2", "a3"}
+	for i := range expected {
+		if messages[i] != expected[i] {
+			t.Fatalf("Expected frames in the order %v, got %v", expected, messages)
+		}
+	}
+}
+
+// Frames outside any flow go first, then flows in order of priority
+func TestSendQueuesMostUrgentFirst(t *testing.T) {
+	writer, reader := blockedWriter(t)
+	writes := []func(){
+		func() { writer.writeFrameAt(PriorityBulk, NewLog(NewMessageIdRandom(), "info", "bulk")) },
+		func() { writer.writeFrameAt(PriorityInteractive, NewLog(NewMessageIdRandom(), "info", "interactive")) },
+		func() { writer.WriteFrame(NewHeartbeat(NewMessageIdRandom())) },
+	}
+	for i, write := range writes {
+		go write()
+		waitQueued(t, writer, i+1)
+	}
+
+	messages := readLogMessages(t, reader, 4)
+	expected := []string{"blocker", "", "interactive", "bulk"}
+	for i := range expected {
+		if messages[i] != expected[i] {
+			t.Fatalf("Expected frames in the order %v, got %v", expected, messages)
+		}
+	}
+}
+
+// A flow's writers wait once its queue is full, while other flows still queue
+func TestSendQueueIsBounded(t *testing.T) {
+	writer, reader := blockedWriter(t)
+	id := NewMessageIdRandom()
+	for i := 0; i < flowQueueDepth+1; i++ {
+		go writer.WriteFrame(NewLog(id, "info", "full"))
+	}
+	waitQueued(t, writer, flowQueueDepth)
+	go writer.WriteFrame(NewLog(NewMessageIdRandom(), "info", "other"))
+	waitQueued(t, writer, flowQueueDepth+1)
+
+	messages := readLogMessages(t, reader, flowQueueDepth+3)
+	if messages[2] != "other" {
+		t.Errorf("Expected the other flow's frame second in line, got %v", messages)
+	}
+}
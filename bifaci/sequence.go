@@ -0,0 +1,96 @@
+package bifaci
+
+import "fmt"
+
+// SetStrictInterleave sets whether the runtime requires the input frames of each
+// request to be numbered gap-free, so frames of concurrent requests interleaved on the
+// connection are checked to keep their order exactly.
+//
+// The runtime guarantees, for the frames it writes: the frames of one flow (a request
+// ID and routing ID) are written in the order the handler emitted them, and carry seq
+// 0, 1, 2... without gaps (see SeqAssigner). The frames of different flows interleave in
+// no specified order, and frames outside any flow (HEARTBEAT, CANCEL, ACK...) may
+// overtake flow frames queued before them.
+//
+// It expects the same of the frames it reads. By default a request's seq only has to
+// increase, since a relay may not pass on every frame of a flow, and unnumbered frames
+// (seq 0 throughout) are accepted; each stream's chunk_index must count up from 0
+// either way. In strict mode every input frame of a request must carry the seq after
+// the previous one, starting after the REQ's, and any other frame fails the request
+// with ERR PROTOCOL_ERROR; it is meant for testing hosts that number their frames, e.g.
+// with a SeqAssigner. Takes effect for connections served afterwards.
+func (pr *PluginRuntime) SetStrictInterleave(strict bool) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.strictInterleave = strict
+}
+
+// SequenceError reports a frame received out of order: a CHUNK whose chunk_index doesn't
+// continue its stream, or a frame whose seq goes back in its flow, as happens when frames
+// are dropped or reordered on the way. The runtime reports it as ERR PROTOCOL_ERROR.
+type SequenceError struct {
+	StreamId string // Stream of the frame, "" for frames outside a stream
+	Field    string // "chunk_index" or "seq"
+	Expected uint64 // For seq, the lowest acceptable one
+	Actual   uint64
+	Strict   bool // Expected is the only acceptable seq (see SetStrictInterleave)
+}
+
+func (e *SequenceError) Error() string {
+	expected := fmt.Sprintf("%s %d", e.Field, e.Expected)
+	if e.Field == "seq" && !e.Strict {
+		expected += " or later"
+	}
+	if e.StreamId == "" {
+		return fmt.Sprintf("out of order frame: expected %s, got %d", expected, e.Actual)
+	}
+	return fmt.Sprintf("out of order frame on stream %s: expected %s, got %d", e.StreamId, expected, e.Actual)
+}
+
+// flowSequence validates the order of the frames of one flow as they are received.
+// Each stream's CHUNKs must carry chunk_index 0, 1, 2..., which catches a dropped CHUNK;
+// and seq must increase through the flow, which catches reordering. seq may skip, since
+// frames such as LOG are not passed on everywhere. Senders that don't number frames send
+// seq 0 throughout, which isn't checked.
+type flowSequence struct {
+	lastSeq   uint64
+	strict    bool              // seq must be lastSeq+1 (see SetStrictInterleave)
+	nextIndex map[string]uint64 // stream_id → chunk_index of the stream's next CHUNK
+}
+
+func newFlowSequence() *flowSequence {
+	return &flowSequence{nextIndex: make(map[string]uint64)}
+}
+
+// newStrictFlowSequence returns a flowSequence requiring the frames after one numbered
+// seq to be numbered gap-free
+func newStrictFlowSequence(seq uint64) *flowSequence {
+	return &flowSequence{lastSeq: seq, strict: true, nextIndex: make(map[string]uint64)}
+}
+
+// check validates frame and records it as received
+func (s *flowSequence) check(frame *Frame) error {
+	streamId := ""
+	if frame.StreamId != nil {
+		streamId = *frame.StreamId
+	}
+	if s.strict {
+		if frame.Seq != s.lastSeq+1 {
+			return &SequenceError{StreamId: streamId, Field: "seq", Expected: s.lastSeq + 1, Actual: frame.Seq, Strict: true}
+		}
+		s.lastSeq = frame.Seq
+	} else if frame.Seq != 0 {
+		if frame.Seq <= s.lastSeq {
+			return &SequenceError{StreamId: streamId, Field: "seq", Expected: s.lastSeq + 1, Actual: frame.Seq}
+		}
+		s.lastSeq = frame.Seq
+	}
+	if frame.FrameType == FrameTypeChunk && frame.ChunkIndex != nil {
+		expected := s.nextIndex[streamId]
+		if *frame.ChunkIndex != expected {
+			return &SequenceError{StreamId: streamId, Field: "chunk_index", Expected: expected, Actual: *frame.ChunkIndex}
+		}
+		s.nextIndex[streamId] = expected + 1
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package bifaci
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFlowSequence(t *testing.T) {
+	id := NewMessageIdRandom()
+	chunk := func(streamId string, seq, index uint64) *Frame {
+		return NewChunk(id, streamId, seq, []byte{0x40}, index, ComputeChecksum([]byte{0x40}))
+	}
+
+	// Interleaved streams, seq skipping a frame that wasn't passed on
+	sequence := newFlowSequence()
+	for _, frame := range []*Frame{chunk("a", 1, 0), chunk("b", 2, 0), chunk("a", 4, 1), chunk("b", 5, 1)} {
+		if err := sequence.check(frame); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// Unnumbered frames
+	sequence = newFlowSequence()
+	for _, frame := range []*Frame{chunk("a", 0, 0), chunk("a", 0, 1)} {
+		if err := sequence.check(frame); err != nil {
+			t.Fatalf("Unexpected error for unnumbered frames: %v", err)
+		}
+	}
+
+	var sequenceErr *SequenceError
+	sequence = newFlowSequence()
+	sequence.check(chunk("a", 0, 0))
+	if err := sequence.check(chunk("a", 0, 2)); !errors.As(err, &sequenceErr) ||
+		sequenceErr.Field != "chunk_index" || sequenceErr.Expected != 1 || sequenceErr.Actual != 2 || sequenceErr.StreamId != "a" {
+		t.Errorf("Expected a chunk_index error, got %v", err)
+	}
+
+	sequence = newFlowSequence()
+	sequence.check(chunk("a", 3, 0))
+	if err := sequence.check(chunk("b", 2, 0)); !errors.As(err, &sequenceErr) || sequenceErr.Field != "seq" || sequenceErr.Actual != 2 {
+		t.Errorf("Expected a seq error, got %v", err)
+	}
+	if handlerErrorCode(sequenceErr) != "PROTOCOL_ERROR" {
+		t.Error("A SequenceError returned by a handler must be reported as PROTOCOL_ERROR")
+	}
+}
+
+func TestCollectStreamsRejectsOutOfSequence(t *testing.T) {
+	id := NewMessageIdRandom()
+	payload := []byte{0x41, 'x'}
+	frames := make(chan Frame, 4)
+	frames <- *NewStreamStart(id, "arg-0", "media:")
+	frames <- *NewChunk(id, "arg-0", 0, payload, 1, ComputeChecksum(payload)) // chunk 0 dropped
+	frames <- *NewStreamEnd(id, "arg-0", 2)
+	close(frames)
+
+	_, err := CollectStreams(frames)
+	var sequenceErr *SequenceError
+	if !errors.As(err, &sequenceErr) || sequenceErr.StreamId != "arg-0" {
+		t.Errorf("Expected a SequenceError for arg-0, got %v", err)
+	}
+}
+
+// The runtime aborts a request whose input arrives out of sequence, naming the stream
+func TestRuntimeRejectsOutOfSequenceInput(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		return emitter.EmitCbor("unreachable")
+	})
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	defer stop()
+
+	for name, build := range map[string]func(id MessageId, payload []byte) []*Frame{
+		"dropped chunk": func(id MessageId, payload []byte) []*Frame {
+			return []*Frame{
+				NewReq(id, testCancelCap, nil, "application/cbor"),
+				NewStreamStart(id, "arg-0", "media:"),
+				NewChunk(id, "arg-0", 0, payload, 0, ComputeChecksum(payload)),
+				NewChunk(id, "arg-0", 0, payload, 2, ComputeChecksum(payload)),
+				NewStreamEnd(id, "arg-0", 3),
+				NewEnd(id, nil),
+			}
+		},
+		"reordered frames": func(id MessageId, payload []byte) []*Frame {
+			frames := []*Frame{
+				NewReq(id, testCancelCap, nil, "application/cbor"),
+				NewStreamStart(id, "arg-0", "media:"),
+				NewChunk(id, "arg-0", 0, payload, 0, ComputeChecksum(payload)),
+				NewStreamEnd(id, "arg-0", 1),
+				NewEnd(id, nil),
+			}
+			for seq, frame := range frames {
+				frame.Seq = uint64(seq)
+			}
+			frames[2].Seq, frames[3].Seq = frames[3].Seq, frames[2].Seq
+			return frames
+		},
+	} {
+		reqId := NewMessageIdRandom()
+		payload := []byte{0x41, 'x'}
+		for _, frame := range build(reqId, payload) {
+			if err := writer.WriteFrame(frame); err != nil {
+				t.Fatalf("%s: WriteFrame failed: %v", name, err)
+			}
+		}
+		frames := readUntilTerminal(t, reader, reqId)
+		last := frames[len(frames)-1]
+		if last.FrameType != FrameTypeErr || last.ErrorCode() != "PROTOCOL_ERROR" || !strings.Contains(last.ErrorMessage(), "arg-0") {
+			t.Errorf("%s: expected ERR PROTOCOL_ERROR naming arg-0, got %v %s %s", name, last.FrameType, last.ErrorCode(), last.ErrorMessage())
+		}
+	}
+}
+
+func TestStrictFlowSequence(t *testing.T) {
+	id := NewMessageIdRandom()
+	payload := []byte{0x40}
+	sequence := newStrictFlowSequence(0)
+	for seq, frame := range []*Frame{NewStreamStart(id, "a", "media:"), NewChunk(id, "a", 0, payload, 0, ComputeChecksum(payload))} {
+		frame.Seq = uint64(seq + 1)
+		if err := sequence.check(frame); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// A skipped seq passes by default but not in strict mode
+	var sequenceErr *SequenceError
+	skipped := NewStreamEnd(id, "a", 1)
+	skipped.Seq = 4
+	if err := sequence.check(skipped); !errors.As(err, &sequenceErr) || !sequenceErr.Strict || sequenceErr.Expected != 3 {
+		t.Fatalf("Expected a strict seq error, got %v", err)
+	}
+	if strings.Contains(sequenceErr.Error(), "or later") {
+		t.Errorf("Expected the strict error to name the only acceptable seq: %v", sequenceErr)
+	}
+	if err := newStrictFlowSequence(0).check(NewEnd(id, nil)); err == nil {
+		t.Error("Expected unnumbered frames to fail in strict mode")
+	}
+}
+
+// In strict mode interleaved requests numbered per flow succeed, and a request with a
+// frame missing from its flow fails
+func TestStrictInterleave(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.SetStrictInterleave(true)
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		return emitter.EmitCbor("done")
+	})
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	defer stop()
+
+	payload := []byte{0x41, 'x'}
+	request := func(id MessageId) []*Frame {
+		return []*Frame{
+			NewReq(id, testCancelCap, nil, "application/cbor"),
+			NewStreamStart(id, "arg-0", "media:"),
+			NewChunk(id, "arg-0", 0, payload, 0, ComputeChecksum(payload)),
+			NewStreamEnd(id, "arg-0", 1),
+			NewEnd(id, nil),
+		}
+	}
+	first, second := NewMessageIdRandom(), NewMessageIdRandom()
+	firstFrames, secondFrames := request(first), request(second)
+	assigner := NewSeqAssigner()
+	for i := range firstFrames {
+		for _, frame := range []*Frame{firstFrames[i], secondFrames[i]} {
+			assigner.Assign(frame)
+			if err := writer.WriteFrame(frame); err != nil {
+				t.Fatalf("WriteFrame failed: %v", err)
+			}
+		}
+	}
+	for _, id := range []MessageId{first, second} {
+		frames := readUntilTerminal(t, reader, id)
+		if last := frames[len(frames)-1]; last.FrameType != FrameTypeEnd {
+			t.Errorf("Expected interleaved request %s to succeed, got %v %s", id.ToString(), last.FrameType, last.ErrorMessage())
+		}
+	}
+
+	gapped := NewMessageIdRandom()
+	frames := request(gapped)
+	for _, frame := range frames {
+		assigner.Assign(frame)
+	}
+	frames = append(frames[:2], frames[3:]...) // The CHUNK is lost on the way
+	for _, frame := range frames {
+		writer.WriteFrame(frame)
+	}
+	response := readUntilTerminal(t, reader, gapped)
+	if last := response[len(response)-1]; last.ErrorCode() != "PROTOCOL_ERROR" || !strings.Contains(last.ErrorMessage(), "seq") {
+		t.Errorf("Expected ERR PROTOCOL_ERROR for the gap, got %v %s %s", last.FrameType, last.ErrorCode(), last.ErrorMessage())
+	}
+}
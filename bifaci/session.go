@@ -0,0 +1,173 @@
+package bifaci
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Session is the state a plugin keeps across the requests its host sends with the same
+// session ID (see Frame.SetSessionId), e.g. a model loaded by the first request and
+// used by the next ones. A session starts with the first REQ naming it and lasts until
+// the host sends SESSION_CLOSE for it or the connection ends; its values are dropped
+// then, and those implementing io.Closer are closed. Handlers get the session of their
+// request with HandlerSession. A Session is safe for concurrent use by the handlers of
+// its requests.
+type Session struct {
+	id      string
+	mu      sync.Mutex
+	entries map[string]sessionEntry
+	closed  bool
+}
+
+// sessionEntry is a value stored in a session
+type sessionEntry struct {
+	value   interface{}
+	expires time.Time // Zero if the value doesn't expire
+}
+
+func newSession(id string) *Session {
+	return &Session{id: id, entries: make(map[string]sessionEntry)}
+}
+
+// Id returns the session ID the host chose
+func (s *Session) Id() string {
+	return s.id
+}
+
+// Get returns the value stored under key, ok false if there is none or it expired
+func (s *Session) Get(key string) (value interface{}, ok bool) {
+	s.mu.Lock()
+	entry, ok := s.entries[key]
+	expired := ok && !entry.expires.IsZero() && !time.Now().Before(entry.expires)
+	if expired {
+		delete(s.entries, key)
+	}
+	s.mu.Unlock()
+	if expired {
+		closeSessionValue(entry.value)
+		return nil, false
+	}
+	return entry.value, ok
+}
+
+// Set stores value under key until the session ends, replacing (and closing) the value
+// stored before
+func (s *Session) Set(key string, value interface{}) {
+	s.SetWithTTL(key, value, 0)
+}
+
+// SetWithTTL stores value under key for ttl, or until the session ends if ttl is 0
+func (s *Session) SetWithTTL(key string, value interface{}, ttl time.Duration) {
+	entry := sessionEntry{value: value}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		closeSessionValue(value)
+		return
+	}
+	previous, replaced := s.entries[key]
+	s.entries[key] = entry
+	s.mu.Unlock()
+	if replaced && previous.value != value {
+		closeSessionValue(previous.value)
+	}
+}
+
+// Delete drops the value stored under key, closing it
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	entry, ok := s.entries[key]
+	delete(s.entries, key)
+	s.mu.Unlock()
+	if ok {
+		closeSessionValue(entry.value)
+	}
+}
+
+// close drops every value of the session; values stored afterwards are dropped at once
+func (s *Session) close() {
+	s.mu.Lock()
+	entries := s.entries
+	s.entries = make(map[string]sessionEntry)
+	s.closed = true
+	s.mu.Unlock()
+	for _, entry := range entries {
+		closeSessionValue(entry.value)
+	}
+}
+
+// closeSessionValue closes a value dropped from a session if it is an io.Closer
+func closeSessionValue(value interface{}) {
+	if closer, ok := value.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// sessionTable holds the sessions of a connection
+type sessionTable struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+func newSessionTable() *sessionTable {
+	return &sessionTable{sessions: make(map[string]*Session)}
+}
+
+// open returns the session with the ID, starting it if it is new; nil for ""
+func (t *sessionTable) open(id string) *Session {
+	if id == "" {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	session, ok := t.sessions[id]
+	if !ok {
+		session = newSession(id)
+		t.sessions[id] = session
+	}
+	return session
+}
+
+// close ends the session with the ID, if it was started
+func (t *sessionTable) close(id string) {
+	t.mu.Lock()
+	session, ok := t.sessions[id]
+	delete(t.sessions, id)
+	t.mu.Unlock()
+	if ok {
+		session.close()
+	}
+}
+
+// closeAll ends every session, when the connection ends
+func (t *sessionTable) closeAll() {
+	t.mu.Lock()
+	sessions := t.sessions
+	t.sessions = make(map[string]*Session)
+	t.mu.Unlock()
+	for _, session := range sessions {
+		session.close()
+	}
+}
+
+type sessionContextKey struct{}
+
+// contextWithSession returns ctx carrying the session of a request
+func contextWithSession(ctx context.Context, session *Session) context.Context {
+	if session == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, sessionContextKey{}, session)
+}
+
+// HandlerSession returns the session of the request an emitter belongs to, nil if the
+// REQ named none or the emitter isn't bound to a request (CLI mode, tests)
+func HandlerSession(emitter StreamEmitter) *Session {
+	session, _ := HandlerContext(emitter).Value(sessionContextKey{}).(*Session)
+	return session
+}
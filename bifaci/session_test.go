@@ -0,0 +1,101 @@
+package bifaci
+
+import (
+	"testing"
+	"time"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+)
+
+type closeRecorder struct{ closed bool }
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestSessionStore(t *testing.T) {
+	session := newSession("s1")
+	model := &closeRecorder{}
+	session.Set("model", model)
+	if value, ok := session.Get("model"); !ok || value != model {
+		t.Fatalf("Expected the stored model, got %v %v", value, ok)
+	}
+
+	session.SetWithTTL("token", "abc", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := session.Get("token"); ok {
+		t.Error("Expected the token to expire")
+	}
+
+	replaced := &closeRecorder{}
+	session.Set("cache", replaced)
+	session.Set("cache", "fresh")
+	if !replaced.closed {
+		t.Error("Expected a replaced value to be closed")
+	}
+
+	session.close()
+	if !model.closed {
+		t.Error("Expected closing the session to close its values")
+	}
+	if _, ok := session.Get("cache"); ok {
+		t.Error("Expected no values after close")
+	}
+	late := &closeRecorder{}
+	session.Set("late", late)
+	if !late.closed {
+		t.Error("Expected a value stored after close to be dropped")
+	}
+}
+
+// Requests naming a session share its state until SESSION_CLOSE
+func TestRuntimeSessions(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		session := HandlerSession(emitter)
+		if session == nil {
+			return emitter.EmitCbor(uint64(0))
+		}
+		count, _ := session.Get("count")
+		n, _ := count.(uint64)
+		session.Set("count", n+1)
+		return emitter.EmitCbor(n + 1)
+	})
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	defer stop()
+
+	call := func(sessionId string) uint64 {
+		t.Helper()
+		reqId := NewMessageIdRandom()
+		req := NewReq(reqId, testCancelCap, nil, "application/cbor")
+		if sessionId != "" {
+			req.SetSessionId(sessionId)
+		}
+		writer.WriteFrame(req)
+		writer.WriteFrame(NewEnd(reqId, nil))
+		frames := readUntilTerminal(t, reader, reqId)
+		streams, err := collectFrames(frames)
+		if err != nil || len(streams) != 1 {
+			t.Fatalf("Expected one stream, got %v %v", streams, err)
+		}
+		var n uint64
+		if err := cborlib.Unmarshal(streams[0].Data, &n); err != nil {
+			t.Fatalf("Failed to decode count: %v", err)
+		}
+		return n
+	}
+
+	if got := []uint64{call("a"), call("a"), call("b"), call("")}; got[0] != 1 || got[1] != 2 || got[2] != 1 || got[3] != 0 {
+		t.Fatalf("Unexpected session counts %v", got)
+	}
+	writer.WriteFrame(NewSessionClose("a"))
+	if n := call("a"); n != 1 {
+		t.Errorf("Expected SESSION_CLOSE to drop the session state, got count %d", n)
+	}
+}
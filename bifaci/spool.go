@@ -0,0 +1,216 @@
+package bifaci
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// DefaultSpoolThreshold is the default size beyond which SpoolArgs moves an argument's
+// contents from memory to a temporary file
+const DefaultSpoolThreshold int = 64 * 1024 * 1024
+
+// SpooledArg is the contents of one argument stream, held in memory up to a threshold and
+// in a temporary file beyond it, so a multi-GB argument is read from disk instead of
+// RAM. It implements io.ReaderAt; Reader returns a sequential view. Close removes the
+// temporary file.
+type SpooledArg struct {
+	MediaUrn string
+	size     int64
+	data     []byte   // Contents while in memory
+	file     *os.File // Contents once spooled to disk, nil until then
+}
+
+// Size returns the length of the argument's contents
+func (a *SpooledArg) Size() int64 {
+	return a.size
+}
+
+// OnDisk reports whether the contents were spooled to a temporary file
+func (a *SpooledArg) OnDisk() bool {
+	return a.file != nil
+}
+
+// ReadAt implements io.ReaderAt over the argument's contents
+func (a *SpooledArg) ReadAt(p []byte, off int64) (int, error) {
+	if a.file != nil {
+		return a.file.ReadAt(p, off)
+	}
+	if off >= int64(len(a.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, a.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Reader returns a reader of the argument's contents from the start. Readers are
+// independent of each other.
+func (a *SpooledArg) Reader() io.Reader {
+	return io.NewSectionReader(a, 0, a.size)
+}
+
+// Close removes the argument's temporary file, if any
+func (a *SpooledArg) Close() error {
+	if a.file == nil {
+		a.data = nil
+		return nil
+	}
+	err := a.file.Close()
+	if removeErr := os.Remove(a.file.Name()); err == nil {
+		err = removeErr
+	}
+	a.file = nil
+	return err
+}
+
+// write appends content, moving the contents to a file in dir once they outgrow
+// threshold (0 = never)
+func (a *SpooledArg) write(content []byte, threshold int, dir string) error {
+	if a.file == nil && threshold > 0 && a.size+int64(len(content)) > int64(threshold) {
+		file, err := os.CreateTemp(dir, "bifaci-arg-*")
+		if err != nil {
+			return fmt.Errorf("failed to create spool file: %w", err)
+		}
+		a.file = file
+		if _, err := file.Write(a.data); err != nil {
+			return fmt.Errorf("failed to write spool file: %w", err)
+		}
+		a.data = nil
+	}
+	if a.file != nil {
+		if _, err := a.file.Write(content); err != nil {
+			return fmt.Errorf("failed to write spool file: %w", err)
+		}
+	} else {
+		a.data = append(a.data, content...)
+	}
+	a.size += int64(len(content))
+	return nil
+}
+
+// SpooledArgs holds the argument streams of a request collected by SpoolArgs, looked up
+// by media URN pattern like ArgSet. Close removes their temporary files.
+type SpooledArgs struct {
+	args []*SpooledArg
+}
+
+// SpoolArgs collects every argument stream of a request like CollectArgs, but keeps only
+// the decoded contents of each stream, the byte or text strings its CHUNKs carry, and
+// moves them to a temporary file in dir ("" = os.TempDir()) once they exceed threshold
+// bytes (0 = never). Frames are validated as CollectStreams does. The caller must Close
+// the result.
+func SpoolArgs(frames <-chan Frame, threshold int, dir string) (*SpooledArgs, error) {
+	spooled := &SpooledArgs{}
+	if err := spooled.collect(frames, threshold, dir); err != nil {
+		spooled.Close()
+		return nil, err
+	}
+	return spooled, nil
+}
+
+func (s *SpooledArgs) collect(frames <-chan Frame, threshold int, dir string) error {
+	streams := make(map[string]*SpooledArg)
+	compressed := make(map[string]Compressor)
+	sequence := newFlowSequence()
+	lengths := newStreamLengths(nil)
+
+	for frame := range frames {
+		if err := sequence.check(&frame); err != nil {
+			return err
+		}
+		switch frame.FrameType {
+		case FrameTypeStreamStart:
+			lengths.check(&frame)
+			if frame.StreamId == nil || frame.MediaUrn == nil {
+				continue
+			}
+			arg := &SpooledArg{MediaUrn: *frame.MediaUrn}
+			streams[*frame.StreamId] = arg
+			s.args = append(s.args, arg)
+			if algorithm := frame.StreamCompression(); algorithm != "" {
+				compressor := lookupCompressor(algorithm)
+				if compressor == nil {
+					return fmt.Errorf("stream %s compressed with unsupported %q", *frame.StreamId, algorithm)
+				}
+				compressed[*frame.StreamId] = compressor
+			}
+
+		case FrameTypeChunk:
+			if frame.StreamId == nil || streams[*frame.StreamId] == nil {
+				continue
+			}
+			if compressor := compressed[*frame.StreamId]; compressor != nil {
+				frame = *decompressChunk(&frame, compressor, MaxFrameHardLimit)
+			}
+			if err := VerifyChunkChecksum(&frame); err != nil {
+				return fmt.Errorf("corrupted data: %w", err)
+			}
+			if err := lengths.check(&frame); err != nil {
+				return err
+			}
+			content, err := cborStringContent(frame.Payload)
+			if err != nil {
+				return fmt.Errorf("stream %s: %w", *frame.StreamId, err)
+			}
+			if err := streams[*frame.StreamId].write(content, threshold, dir); err != nil {
+				return err
+			}
+
+		case FrameTypeStreamEnd:
+			if err := lengths.check(&frame); err != nil {
+				return err
+			}
+			if frame.StreamId != nil {
+				delete(streams, *frame.StreamId)
+				delete(compressed, *frame.StreamId)
+			}
+
+		case FrameTypeEnd:
+			return nil
+
+		case FrameTypeErr:
+			return ErrorFromFrame(&frame)
+		}
+	}
+	return nil
+}
+
+// Args returns the arguments in the order they were sent
+func (s *SpooledArgs) Args() []*SpooledArg {
+	return s.args
+}
+
+// Find returns the first argument whose media URN pattern accepts, or nil
+func (s *SpooledArgs) Find(pattern string) (*SpooledArg, error) {
+	i, err := firstAccepted(pattern, len(s.args), func(i int) string { return s.args[i].MediaUrn })
+	if i < 0 || err != nil {
+		return nil, err
+	}
+	return s.args[i], nil
+}
+
+// Require is like Find but fails if no argument matches
+func (s *SpooledArgs) Require(pattern string) (*SpooledArg, error) {
+	arg, err := s.Find(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if arg == nil {
+		return nil, fmt.Errorf("missing required arg: %s", pattern)
+	}
+	return arg, nil
+}
+
+// Close removes the temporary files of all arguments
+func (s *SpooledArgs) Close() error {
+	var firstErr error
+	for _, arg := range s.args {
+		if err := arg.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
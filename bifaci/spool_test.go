@@ -0,0 +1,95 @@
+package bifaci
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+)
+
+func spoolFrames(pieces map[string][]string, order ...string) chan Frame {
+	id := NewMessageIdRandom()
+	frames := make(chan Frame, 64)
+	for i, mediaUrn := range order {
+		streamId := "arg-" + string(rune('0'+i))
+		frames <- *NewStreamStart(id, streamId, mediaUrn)
+		for j, piece := range pieces[mediaUrn] {
+			payload, _ := cborlib.Marshal([]byte(piece))
+			frames <- *NewChunk(id, streamId, 0, payload, uint64(j), ComputeChecksum(payload))
+		}
+		frames <- *NewStreamEnd(id, streamId, uint64(len(pieces[mediaUrn])))
+	}
+	frames <- *NewEnd(id, nil)
+	close(frames)
+	return frames
+}
+
+func TestSpoolArgs(t *testing.T) {
+	dir := t.TempDir()
+	spooled, err := SpoolArgs(spoolFrames(map[string][]string{
+		"media:pdf;bytes":    {"%PDF-", "1.7 ", "body"},
+		"media:txt;textable": {"hi"},
+	}, "media:pdf;bytes", "media:txt;textable"), 8, dir)
+	if err != nil {
+		t.Fatalf("SpoolArgs failed: %v", err)
+	}
+
+	pdf, err := spooled.Require("media:pdf")
+	if err != nil {
+		t.Fatalf("Require failed: %v", err)
+	}
+	if !pdf.OnDisk() || pdf.Size() != 13 {
+		t.Errorf("Expected the pdf spooled to disk with 13 bytes, got %v %d", pdf.OnDisk(), pdf.Size())
+	}
+	contents, _ := io.ReadAll(pdf.Reader())
+	if string(contents) != "%PDF-1.7 body" {
+		t.Errorf("Unexpected pdf contents %q", contents)
+	}
+	buf := make([]byte, 3)
+	if n, err := pdf.ReadAt(buf, 5); n != 3 || err != nil || string(buf) != "1.7" {
+		t.Errorf("Unexpected ReadAt result %d %v %q", n, err, buf)
+	}
+
+	txt, _ := spooled.Find("media:txt")
+	if txt == nil || txt.OnDisk() {
+		t.Fatal("Expected the small argument to stay in memory")
+	}
+	if contents, _ := io.ReadAll(txt.Reader()); !bytes.Equal(contents, []byte("hi")) {
+		t.Errorf("Unexpected txt contents %q", contents)
+	}
+	if missing, err := spooled.Find("media:json"); missing != nil || err != nil {
+		t.Errorf("Expected no match, got %v %v", missing, err)
+	}
+	if _, err := spooled.Require("media:json"); err == nil || !strings.Contains(err.Error(), "missing required arg") {
+		t.Errorf("Expected a missing arg error, got %v", err)
+	}
+
+	if err := spooled.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if left, _ := filepath.Glob(filepath.Join(dir, "*")); len(left) != 0 {
+		t.Errorf("Expected the spool files to be removed, found %v", left)
+	}
+}
+
+func TestSpoolArgsRemovesFilesOnError(t *testing.T) {
+	dir := t.TempDir()
+	id := NewMessageIdRandom()
+	payload, _ := cborlib.Marshal([]byte("0123456789"))
+	frames := make(chan Frame, 4)
+	frames <- *NewStreamStart(id, "arg-0", "media:")
+	frames <- *NewChunk(id, "arg-0", 0, payload, 0, ComputeChecksum(payload))
+	frames <- *NewErr(id, "BAD", "sender failed")
+	close(frames)
+
+	if _, err := SpoolArgs(frames, 4, dir); err == nil || !strings.Contains(err.Error(), "BAD") {
+		t.Errorf("Expected the ERR to be returned, got %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected the spool file to be removed, found %d entries", len(entries))
+	}
+}
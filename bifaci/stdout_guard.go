@@ -0,0 +1,156 @@
+package bifaci
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// maxStdoutLine is the longest line of stray stdout forwarded in one LOG frame; longer
+// lines are split
+const maxStdoutLine = 64 * 1024
+
+// stdoutGuard forwards what is written to the process's stdout, once RunGuard moved
+// the frame protocol off it, as LOG frames of the request most recently started
+type stdoutGuard struct {
+	mu       sync.Mutex
+	requests []StreamEmitter // Running requests, in the order they started
+	logger   Logger          // Receives output while no request is running
+}
+
+// RunGuard keeps writes to the process's stdout, e.g. by a tool a handler runs or a
+// stray fmt.Println, from corrupting the frame protocol. Stdout is redirected to a pipe
+// whose lines are sent as LOG frames (level "info", attribute stream=stdout) of the
+// request most recently started, or logged while none is running; the returned file
+// writes to the original stdout, for the protocol. release undoes the redirection.
+// Output meant as the response goes through Stdout instead.
+//
+// Run calls RunGuard in plugin CBOR mode; call it before RunConn when serving over
+// stdio yourself. Only one guard can be active per process. Unix only.
+func (pr *PluginRuntime) RunGuard() (protocol *os.File, release func(), err error) {
+	stdoutFd := int(os.Stdout.Fd())
+	// Tools the handlers run mustn't inherit the protocol
+	protocolFd, err := dupCloseOnExec(stdoutFd)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to duplicate stdout: %w", err)
+	}
+	protocol = os.NewFile(uintptr(protocolFd), "protocol")
+
+	pipeReader, pipeWriter, err := os.Pipe()
+	if err != nil {
+		protocol.Close()
+		return nil, nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+	if err := redirectFd(int(pipeWriter.Fd()), stdoutFd); err != nil {
+		pipeReader.Close()
+		pipeWriter.Close()
+		protocol.Close()
+		return nil, nil, fmt.Errorf("failed to redirect stdout: %w", err)
+	}
+	// fd 1 is the only write end left, so the pipe ends once it is restored
+	pipeWriter.Close()
+
+	guard := &stdoutGuard{logger: pr.log()}
+	pr.mu.Lock()
+	pr.stdoutGuard = guard
+	pr.mu.Unlock()
+	go guard.forward(pipeReader)
+
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			pr.mu.Lock()
+			if pr.stdoutGuard == guard {
+				pr.stdoutGuard = nil
+			}
+			pr.mu.Unlock()
+			if err := redirectFd(protocolFd, stdoutFd); err != nil {
+				guard.logger.Error("failed to restore stdout", "error", err)
+			}
+			protocol.Close()
+		})
+	}
+	return protocol, release, nil
+}
+
+// attach makes emitter receive the guarded output until detach is called. A nil guard
+// does nothing.
+func (g *stdoutGuard) attach(emitter StreamEmitter) (detach func()) {
+	if g == nil {
+		return func() {}
+	}
+	g.mu.Lock()
+	g.requests = append(g.requests, emitter)
+	g.mu.Unlock()
+	return func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		for i, request := range g.requests {
+			if request == emitter {
+				g.requests = append(g.requests[:i:i], g.requests[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// forward sends each line read from r to the request most recently started, until r
+// ends
+func (g *stdoutGuard) forward(r io.ReadCloser) {
+	defer r.Close()
+	lines := bufio.NewReaderSize(r, maxStdoutLine)
+	for {
+		line, err := lines.ReadSlice('\n')
+		if len(line) > 0 {
+			g.emit(string(trimNewline(line)))
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			return
+		}
+	}
+}
+
+// emit sends one line of output
+func (g *stdoutGuard) emit(line string) {
+	g.mu.Lock()
+	var target StreamEmitter
+	if n := len(g.requests); n > 0 {
+		target = g.requests[n-1]
+	}
+	g.mu.Unlock()
+	if target == nil {
+		g.logger.Info(line, "stream", "stdout")
+		return
+	}
+	EmitLogAttrs(target, "info", line, "stream", "stdout")
+}
+
+// trimNewline removes a trailing \n or \r\n
+func trimNewline(line []byte) []byte {
+	if n := len(line); n > 0 && line[n-1] == '\n' {
+		line = line[:n-1]
+		if n := len(line); n > 0 && line[n-1] == '\r' {
+			line = line[:n-1]
+		}
+	}
+	return line
+}
+
+// emitterWriter is the io.Writer returned by Stdout: each write is
+// emitted as bytes on the response stream
+type emitterWriter struct {
+	emitter StreamEmitter
+}
+
+func (w *emitterWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	// EmitCbor may keep the slice past the call, which io.Writer forbids for p
+	if err := w.emitter.EmitCbor(append([]byte(nil), p...)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
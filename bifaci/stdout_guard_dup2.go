@@ -0,0 +1,10 @@
+//go:build unix && !linux
+
+package bifaci
+
+import "syscall"
+
+// redirectFd makes newFd refer to what oldFd does
+func redirectFd(oldFd, newFd int) error {
+	return syscall.Dup2(oldFd, newFd)
+}
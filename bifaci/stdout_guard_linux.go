@@ -0,0 +1,9 @@
+package bifaci
+
+import "syscall"
+
+// redirectFd makes newFd refer to what oldFd does, like dup2 (not every Linux
+// architecture has the dup2 system call)
+func redirectFd(oldFd, newFd int) error {
+	return syscall.Dup3(oldFd, newFd, 0)
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package bifaci
+
+import "errors"
+
+// Stdout can't be redirected here, so RunGuard fails and Run serves on stdout as is
+
+func dupCloseOnExec(fd int) (int, error) {
+	return -1, errors.ErrUnsupported
+}
+
+func redirectFd(oldFd, newFd int) error {
+	return errors.ErrUnsupported
+}
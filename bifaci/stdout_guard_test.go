@@ -0,0 +1,99 @@
+package bifaci
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+)
+
+// logRecordingEmitter records the messages of the LOG frames a handler emits
+type logRecordingEmitter struct {
+	mockStreamEmitter
+	logs []string
+}
+
+func (e *logRecordingEmitter) EmitLog(level, message string) {
+	e.logs = append(e.logs, level+" "+message)
+}
+
+func (e *logRecordingEmitter) EmitLogAttrs(level, message string, attrs ...any) {
+	e.EmitLog(level, message)
+}
+
+// channelLogger sends the messages logged at info level to a channel
+type channelLogger chan string
+
+func (l channelLogger) Debug(msg string, args ...any) {}
+func (l channelLogger) Info(msg string, args ...any)  { l <- msg }
+func (l channelLogger) Warn(msg string, args ...any)  {}
+func (l channelLogger) Error(msg string, args ...any) {}
+
+func TestStdoutGuardForwardsToLatestRequest(t *testing.T) {
+	logged := make(channelLogger, 4)
+	guard := &stdoutGuard{logger: logged}
+	first, second := &logRecordingEmitter{}, &logRecordingEmitter{}
+	guard.attach(first)
+	detachSecond := guard.attach(second)
+
+	guard.forward(io.NopCloser(strings.NewReader("converting\r\n50%\n")))
+	if len(first.logs) != 0 || strings.Join(second.logs, ",") != "info converting,info 50%" {
+		t.Errorf("Expected the lines logged to the latest request, got %v and %v", first.logs, second.logs)
+	}
+
+	detachSecond()
+	guard.forward(io.NopCloser(strings.NewReader("done")))
+	if len(first.logs) != 1 || first.logs[0] != "info done" {
+		t.Errorf("Expected the earlier request to receive output once the latest ended, got %v", first.logs)
+	}
+
+	guard = &stdoutGuard{logger: logged}
+	guard.forward(io.NopCloser(strings.NewReader("idle\n")))
+	if msg := <-logged; msg != "idle" {
+		t.Errorf("Expected output without a request logged by the runtime, got %q", msg)
+	}
+}
+
+func TestRunGuard(t *testing.T) {
+	runtime := newRoutingRuntime(t)
+	logged := make(channelLogger, 4)
+	runtime.SetLogger(logged)
+	protocol, release, err := runtime.RunGuard()
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("stdout can't be redirected on this platform")
+	}
+	if err != nil {
+		t.Fatalf("RunGuard failed: %v", err)
+	}
+	defer release()
+	if protocol == nil {
+		t.Fatal("Expected a file for the protocol")
+	}
+
+	fmt.Fprintln(os.Stdout, "stray output")
+	release()
+	select {
+	case msg := <-logged:
+		if msg != "stray output" {
+			t.Errorf("Expected the stray output logged, got %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stray output was not forwarded")
+	}
+}
+
+func TestEmitterStdout(t *testing.T) {
+	emitter := &mockStreamEmitter{}
+	if n, err := fmt.Fprint(Stdout(emitter), "tool output"); err != nil || n != 11 {
+		t.Fatalf("Write failed: %d, %v", n, err)
+	}
+	var data []byte
+	if len(emitter.emittedData) != 1 || cborlib.Unmarshal(emitter.emittedData[0], &data) != nil || string(data) != "tool output" {
+		t.Errorf("Expected the write emitted as bytes, got %v", emitter.emittedData)
+	}
+}
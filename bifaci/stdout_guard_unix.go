@@ -0,0 +1,17 @@
+//go:build unix
+
+package bifaci
+
+import "syscall"
+
+// dupCloseOnExec duplicates fd into a descriptor child processes don't inherit
+func dupCloseOnExec(fd int) (int, error) {
+	syscall.ForkLock.RLock()
+	defer syscall.ForkLock.RUnlock()
+	dup, err := syscall.Dup(fd)
+	if err != nil {
+		return -1, err
+	}
+	syscall.CloseOnExec(dup)
+	return dup, nil
+}
@@ -0,0 +1,81 @@
+package bifaci
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultStrayFrameGrace is how long after a request completes the runtime drops its
+// late input frames without error by default (see StrayFramePolicy)
+const DefaultStrayFrameGrace = 5 * time.Second
+
+// StrayFramePolicy is how the runtime handles stray input frames: a STREAM_START,
+// CHUNK, STREAM_END or END for a message ID that is neither a running request nor a
+// pending peer request, and a duplicate END for a running request. Stray frames are
+// counted (see MetricsCollector.StrayFrame) and logged whatever the policy.
+//
+// Frames for a request that completed or was answered with ERR within Grace are
+// expected, having crossed the response on the wire like a late CANCEL, and are
+// dropped. Any other stray frame is a protocol bug in the host: by default it is
+// dropped too, in Strict mode it is answered with ERR PROTOCOL_ERROR, and a duplicate
+// END aborts its request with PROTOCOL_ERROR.
+type StrayFramePolicy struct {
+	Strict bool
+	Grace  time.Duration // 0 = DefaultStrayFrameGrace, negative = no grace
+}
+
+// SetStrayFramePolicy sets how stray input frames are handled (see StrayFramePolicy).
+// Takes effect for connections served afterwards.
+func (pr *PluginRuntime) SetStrayFramePolicy(policy StrayFramePolicy) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.strayFrames = policy
+}
+
+func (p StrayFramePolicy) grace() time.Duration {
+	switch {
+	case p.Grace == 0:
+		return DefaultStrayFrameGrace
+	case p.Grace < 0:
+		return 0
+	}
+	return p.Grace
+}
+
+// recentRequests remembers when requests were last seen, a REQ arriving or the request
+// completing, for the grace window of stray frames
+type recentRequests struct {
+	mu    sync.Mutex
+	grace time.Duration
+	seen  map[string]time.Time
+	now   func() time.Time
+}
+
+func newRecentRequests(grace time.Duration) *recentRequests {
+	return &recentRequests{grace: grace, seen: make(map[string]time.Time), now: time.Now}
+}
+
+// touch records that the request with the ID was seen now, forgetting requests seen
+// longer than the grace window ago
+func (r *recentRequests) touch(idKey string) {
+	if r.grace <= 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	for key, seen := range r.seen {
+		if now.Sub(seen) > r.grace {
+			delete(r.seen, key)
+		}
+	}
+	r.seen[idKey] = now
+}
+
+// withinGrace reports whether the request with the ID was seen within the grace window
+func (r *recentRequests) withinGrace(idKey string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	seen, ok := r.seen[idKey]
+	return ok && r.now().Sub(seen) <= r.grace
+}
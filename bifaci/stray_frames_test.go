@@ -0,0 +1,84 @@
+package bifaci
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecentRequestsGrace(t *testing.T) {
+	now := time.Unix(0, 0)
+	recent := newRecentRequests(time.Second)
+	recent.now = func() time.Time { return now }
+	recent.touch("a")
+	if !recent.withinGrace("a") || recent.withinGrace("b") {
+		t.Fatal("Expected only the touched request within grace")
+	}
+	now = now.Add(2 * time.Second)
+	if recent.withinGrace("a") {
+		t.Error("Expected the request to leave the grace window")
+	}
+	recent.touch("b")
+	if _, ok := recent.seen["a"]; ok {
+		t.Error("Expected requests past the grace window to be forgotten")
+	}
+
+	if (StrayFramePolicy{}).grace() != DefaultStrayFrameGrace || (StrayFramePolicy{Grace: -1}).grace() != 0 {
+		t.Error("Expected a zero grace to default and a negative one to disable it")
+	}
+}
+
+// In strict mode a frame for an unknown request is answered with ERR PROTOCOL_ERROR,
+// while a late END for a request that just completed is dropped
+func TestStrictStrayFrames(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	metrics := NewMetrics()
+	runtime.SetMetrics(metrics)
+	runtime.SetStrayFramePolicy(StrayFramePolicy{Strict: true})
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		return emitter.EmitCbor("done")
+	})
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	defer stop()
+
+	unknownId := NewMessageIdRandom()
+	writer.WriteFrame(NewEnd(unknownId, nil))
+	frames := readUntilTerminal(t, reader, unknownId)
+	if last := frames[len(frames)-1]; last.ErrorCode() != string(ErrCodeProtocol) {
+		t.Fatalf("Expected PROTOCOL_ERROR for an unknown request, got %v %s", last.FrameType, last.ErrorCode())
+	}
+
+	reqId := NewMessageIdRandom()
+	writer.WriteFrame(NewReq(reqId, testCancelCap, nil, "application/cbor"))
+	writer.WriteFrame(NewEnd(reqId, nil))
+	readUntilTerminal(t, reader, reqId)
+	writer.WriteFrame(NewEnd(reqId, nil))
+
+	// Nothing answers the late END before the ERR for the next stray frame
+	nextId := NewMessageIdRandom()
+	writer.WriteFrame(NewStreamEnd(nextId, "s", 0))
+	for {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		if frame.Id.Equals(reqId) {
+			t.Fatalf("Expected the late END to be dropped, got %v", frame.FrameType)
+		}
+		if frame.Id.Equals(nextId) {
+			if frame.ErrorCode() != string(ErrCodeProtocol) {
+				t.Fatalf("Expected PROTOCOL_ERROR, got %v %s", frame.FrameType, frame.ErrorCode())
+			}
+			break
+		}
+	}
+
+	stray := metrics.Snapshot().StrayFrames
+	if stray[FrameTypeEnd] != 2 || stray[FrameTypeStreamEnd] != 1 {
+		t.Errorf("Expected 2 stray ENDs and 1 STREAM_END counted, got %v", stray)
+	}
+}
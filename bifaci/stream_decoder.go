@@ -0,0 +1,248 @@
+package bifaci
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+)
+
+// StreamDecoder reads the first stream of a request as an io.Reader, decoding each CHUNK
+// as it arrives instead of collecting the whole argument like CollectFirstArg. Every
+// CHUNK payload is an independently decodable CBOR byte or text string; Read returns
+// their contents back to back, so a handler can feed an argument straight into a parser:
+//
+//	decoder := bifaci.NewStreamDecoder(frames)
+//	records, err := csv.NewReader(decoder).ReadAll()
+//
+// Each CHUNK's checksum is verified as it is read, and streams still flagged as
+// compressed are decompressed. Frames of other streams are skipped. Read returns io.EOF
+// at the stream's STREAM_END, or at END if the request has no stream, and an error for
+// an ERR frame or a CHUNK that fails verification.
+type StreamDecoder struct {
+	frames     <-chan Frame
+	streamId   string
+	mediaUrn   string
+	started    bool
+	compressor Compressor
+	pending    []byte // Decoded bytes of the current CHUNK not yet read
+	elements   bool   // pending holds the whole CBOR payload (see ElementDecoder)
+	err        error  // Sticky: io.EOF once the stream ended
+	done       bool   // END or ERR seen, nothing left to drain
+}
+
+// NewStreamDecoder returns a decoder of the first stream among frames
+func NewStreamDecoder(frames <-chan Frame) *StreamDecoder {
+	return &StreamDecoder{frames: frames}
+}
+
+// MediaUrn waits for the stream's STREAM_START and returns its media URN; "" if the
+// request has no stream
+func (d *StreamDecoder) MediaUrn() (string, error) {
+	for !d.started && d.err == nil {
+		d.next()
+	}
+	if d.err != nil && d.err != io.EOF {
+		return "", d.err
+	}
+	return d.mediaUrn, nil
+}
+
+// Read implements io.Reader over the decoded contents of the stream's CHUNKs
+func (d *StreamDecoder) Read(p []byte) (int, error) {
+	for len(d.pending) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		d.next()
+	}
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
+
+// Close drains the frames that follow the stream up to the request's END, and returns
+// the error of an ERR frame among them
+func (d *StreamDecoder) Close() error {
+	for !d.done {
+		frame, ok := <-d.frames
+		if !ok {
+			break
+		}
+		switch frame.FrameType {
+		case FrameTypeEnd:
+			d.done = true
+		case FrameTypeErr:
+			d.done = true
+			return ErrorFromFrame(&frame)
+		}
+	}
+	if d.err != nil && d.err != io.EOF {
+		return d.err
+	}
+	return nil
+}
+
+// ElementDecoder iterates over the elements of the first stream of a response as they
+// arrive, one per CHUNK, as EmitSeq and EmitStreamElements send them. It
+// reads the frames of a peer invocation (PeerInvoker.Invoke) or of a response a host
+// forwards, so an unbounded sequence is processed without waiting for its END:
+//
+//	elements := bifaci.NewElementDecoder(frames)
+//	for {
+//		var token string
+//		if err := elements.Next(&token); err == io.EOF {
+//			break
+//		} else if err != nil {
+//			return err
+//		}
+//		fmt.Print(token)
+//	}
+//
+// Frames are verified as StreamDecoder verifies them. Next returns io.EOF at the
+// stream's STREAM_END, and the error of an ERR frame; Close drains the rest.
+type ElementDecoder struct {
+	stream StreamDecoder
+}
+
+// NewElementDecoder returns a decoder of the elements of the first stream among frames
+func NewElementDecoder(frames <-chan Frame) *ElementDecoder {
+	return &ElementDecoder{stream: StreamDecoder{frames: frames, elements: true}}
+}
+
+// MediaUrn waits for the stream's STREAM_START and returns its media URN; "" if the
+// response has no stream
+func (d *ElementDecoder) MediaUrn() (string, error) {
+	return d.stream.MediaUrn()
+}
+
+// Next decodes the next element into v, waiting for its CHUNK
+func (d *ElementDecoder) Next(v interface{}) error {
+	for len(d.stream.pending) == 0 {
+		if d.stream.err != nil {
+			return d.stream.err
+		}
+		d.stream.next()
+	}
+	payload := d.stream.pending
+	d.stream.pending = nil
+	if err := cborlib.Unmarshal(payload, v); err != nil {
+		return fmt.Errorf("stream %s: element is not valid CBOR: %w", d.stream.streamId, err)
+	}
+	return nil
+}
+
+// Close drains the frames that follow the stream up to the response's END, and returns
+// the error of an ERR frame among them
+func (d *ElementDecoder) Close() error {
+	return d.stream.Close()
+}
+
+// next handles one frame, setting pending or err
+func (d *StreamDecoder) next() {
+	frame, ok := <-d.frames
+	if !ok {
+		d.done = true
+		if d.started {
+			d.err = io.ErrUnexpectedEOF
+		} else {
+			d.err = io.EOF
+		}
+		return
+	}
+	ours := d.started && frame.StreamId != nil && *frame.StreamId == d.streamId
+
+	switch frame.FrameType {
+	case FrameTypeStreamStart:
+		if d.started || frame.StreamId == nil {
+			return
+		}
+		d.started = true
+		d.streamId = *frame.StreamId
+		if frame.MediaUrn != nil {
+			d.mediaUrn = *frame.MediaUrn
+		}
+		if algorithm := frame.StreamCompression(); algorithm != "" {
+			if d.compressor = lookupCompressor(algorithm); d.compressor == nil {
+				d.err = fmt.Errorf("stream %s compressed with unsupported %q", d.streamId, algorithm)
+			}
+		}
+
+	case FrameTypeChunk:
+		if !ours {
+			return
+		}
+		if d.compressor != nil {
+			frame = *decompressChunk(&frame, d.compressor, MaxFrameHardLimit)
+		}
+		if err := VerifyChunkChecksum(&frame); err != nil {
+			d.err = fmt.Errorf("corrupted data: %w", err)
+			return
+		}
+		if d.elements {
+			d.pending = frame.Payload
+			return
+		}
+		content, err := cborStringContent(frame.Payload)
+		if err != nil {
+			d.err = fmt.Errorf("stream %s: %w", d.streamId, err)
+			return
+		}
+		d.pending = content
+
+	case FrameTypeStreamEnd:
+		if ours {
+			d.err = io.EOF
+		}
+
+	case FrameTypeEnd:
+		d.done = true
+		if d.started {
+			d.err = io.ErrUnexpectedEOF
+		} else {
+			d.err = io.EOF
+		}
+
+	case FrameTypeErr:
+		d.done = true
+		d.err = ErrorFromFrame(&frame)
+	}
+}
+
+// cborStringContent returns the contents of a CHUNK payload holding a CBOR byte or text
+// string. Definite-length strings, which is what every sender writes, are sliced out of
+// the payload without copying.
+func cborStringContent(payload []byte) ([]byte, error) {
+	if len(payload) == 0 {
+		return nil, nil
+	}
+	content, n, err := cborStringItem(payload)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(payload) {
+		return nil, errors.New("CHUNK holds more than one CBOR data item")
+	}
+	return content, nil
+}
+
+// cborHeadArgument reads the argument of a CBOR head with a definite length, returning
+// it with the size of the head
+func cborHeadArgument(data []byte) (uint64, int, bool) {
+	info := data[0] & 0x1f
+	switch {
+	case info < 24:
+		return uint64(info), 1, true
+	case info == 24 && len(data) >= 2:
+		return uint64(data[1]), 2, true
+	case info == 25 && len(data) >= 3:
+		return uint64(binary.BigEndian.Uint16(data[1:])), 3, true
+	case info == 26 && len(data) >= 5:
+		return uint64(binary.BigEndian.Uint32(data[1:])), 5, true
+	case info == 27 && len(data) >= 9:
+		return binary.BigEndian.Uint64(data[1:]), 9, true
+	}
+	return 0, 0, false
+}
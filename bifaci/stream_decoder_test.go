@@ -0,0 +1,148 @@
+package bifaci
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+	"testing"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+)
+
+// streamFrames returns the frames of a request carrying values as CHUNKs of stream
+// "arg-0", preceded by a CHUNK of an unrelated stream
+func streamFrames(values ...interface{}) chan Frame {
+	id := NewMessageIdRandom()
+	frames := make(chan Frame, len(values)+8)
+	frames <- *NewReq(id, testCancelCap, nil, "application/cbor")
+	frames <- *NewStreamStart(id, "arg-0", "media:csv")
+	frames <- *NewStreamStart(id, "arg-1", "media:")
+	other, _ := cborlib.Marshal([]byte("not this one"))
+	frames <- *NewChunk(id, "arg-1", 0, other, 0, ComputeChecksum(other))
+	for i, value := range values {
+		payload, _ := cborlib.Marshal(value)
+		frames <- *NewChunk(id, "arg-0", uint64(i), payload, uint64(i), ComputeChecksum(payload))
+	}
+	frames <- *NewStreamEnd(id, "arg-0", uint64(len(values)))
+	frames <- *NewStreamEnd(id, "arg-1", 1)
+	frames <- *NewEnd(id, nil)
+	close(frames)
+	return frames
+}
+
+func TestStreamDecoderFeedsParser(t *testing.T) {
+	// Records split across CHUNKs, as byte and text strings
+	decoder := NewStreamDecoder(streamFrames([]byte("name,size\na,"), "1\nb", []byte(",2\n")))
+	mediaUrn, err := decoder.MediaUrn()
+	if err != nil || mediaUrn != "media:csv" {
+		t.Errorf("Expected media:csv, got %q (%v)", mediaUrn, err)
+	}
+	records, err := csv.NewReader(decoder).ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if len(records) != 3 || records[2][0] != "b" || records[2][1] != "2" {
+		t.Errorf("Unexpected records %v", records)
+	}
+	if err := decoder.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+}
+
+func TestStreamDecoderLargeChunks(t *testing.T) {
+	big := strings.Repeat("0123456789", 30000)
+	data, err := io.ReadAll(NewStreamDecoder(streamFrames(big, []byte(big))))
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if string(data) != big+big {
+		t.Errorf("Expected %d bytes, got %d", 2*len(big), len(data))
+	}
+}
+
+func TestStreamDecoderErrors(t *testing.T) {
+	id := NewMessageIdRandom()
+	payload, _ := cborlib.Marshal([]byte("abc"))
+
+	// A CHUNK failing verification
+	frames := make(chan Frame, 4)
+	frames <- *NewStreamStart(id, "arg-0", "media:")
+	frames <- *NewChunk(id, "arg-0", 0, payload, 0, ComputeChecksum(payload)+1)
+	close(frames)
+	if _, err := io.ReadAll(NewStreamDecoder(frames)); err == nil || !strings.Contains(err.Error(), "corrupted data") {
+		t.Errorf("Expected a checksum error, got %v", err)
+	}
+
+	// A CHUNK that isn't a string
+	notString, _ := cborlib.Marshal(42)
+	frames = make(chan Frame, 4)
+	frames <- *NewStreamStart(id, "arg-0", "media:")
+	frames <- *NewChunk(id, "arg-0", 0, notString, 0, ComputeChecksum(notString))
+	close(frames)
+	if _, err := io.ReadAll(NewStreamDecoder(frames)); err == nil {
+		t.Error("Expected an error for a CHUNK that isn't a string")
+	}
+
+	// ERR in the middle of the stream
+	frames = make(chan Frame, 4)
+	frames <- *NewStreamStart(id, "arg-0", "media:")
+	frames <- *NewChunk(id, "arg-0", 0, payload, 0, ComputeChecksum(payload))
+	frames <- *NewErr(id, "BOOM", "went wrong")
+	close(frames)
+	decoder := NewStreamDecoder(frames)
+	if _, err := io.ReadAll(decoder); err == nil || !strings.Contains(err.Error(), "BOOM") {
+		t.Errorf("Expected the ERR, got %v", err)
+	}
+	if err := decoder.Close(); err == nil {
+		t.Error("Close must report the ERR")
+	}
+
+	// END without any stream is an empty argument
+	frames = make(chan Frame, 1)
+	frames <- *NewEnd(id, nil)
+	close(frames)
+	if data, err := io.ReadAll(NewStreamDecoder(frames)); err != nil || len(data) != 0 {
+		t.Errorf("Expected an empty stream, got %d bytes (%v)", len(data), err)
+	}
+}
+
+// Streams the runtime receives compressed are decompressed by its FrameReader; a
+// decoder given frames straight from the wire decompresses them itself
+func TestStreamDecoderDecompresses(t *testing.T) {
+	id := NewMessageIdRandom()
+	payload, _ := cborlib.Marshal("compressed argument")
+	compressed, _ := gzipCompressor{}.Compress(payload)
+	start := NewStreamStart(id, "arg-0", "media:")
+	start.SetStreamCompression(CompressionGzip)
+	frames := make(chan Frame, 4)
+	frames <- *start
+	frames <- *NewChunk(id, "arg-0", 0, compressed, 0, ComputeChecksum(payload))
+	frames <- *NewStreamEnd(id, "arg-0", 1)
+	close(frames)
+	data, err := io.ReadAll(NewStreamDecoder(frames))
+	if err != nil || string(data) != "compressed argument" {
+		t.Errorf("Expected the decompressed argument, got %q (%v)", data, err)
+	}
+}
+
+func TestElementDecoder(t *testing.T) {
+	elements := NewElementDecoder(streamFrames(map[string]int{"n": 1}, "token", 3))
+	var record map[string]int
+	if err := elements.Next(&record); err != nil || record["n"] != 1 {
+		t.Fatalf("Expected the map element, got %v (%v)", record, err)
+	}
+	var token string
+	if err := elements.Next(&token); err != nil || token != "token" {
+		t.Fatalf("Expected the text element, got %q (%v)", token, err)
+	}
+	var n int
+	if err := elements.Next(&n); err != nil || n != 3 {
+		t.Fatalf("Expected the number element, got %d (%v)", n, err)
+	}
+	if err := elements.Next(&n); err != io.EOF {
+		t.Errorf("Expected io.EOF after the last element, got %v", err)
+	}
+	if err := elements.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package bifaci
+
+import (
+	"io"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultTextBatch is how many bytes a TextWriter buffers before it emits a CHUNK on its
+// own; Flush emits sooner
+const DefaultTextBatch = 1024
+
+// TextWriter is the writer returned by TextWriterOf, for text produced in
+// small pieces such as the tokens of a language model
+type TextWriter interface {
+	io.Writer
+	io.StringWriter
+	// Flush emits the text buffered so far, up to the last point it can be split at
+	Flush() error
+}
+
+// textWriter batches text written in small pieces into CHUNKs of at least batch bytes,
+// each a CBOR text string split where neither a UTF-8 sequence nor a grapheme cluster
+// spans two CHUNKs. It is not safe for concurrent use.
+type textWriter struct {
+	emit  func(text string) error
+	batch int
+	buf   []byte
+}
+
+func newTextWriter(emit func(text string) error, batch int) *textWriter {
+	return &textWriter{emit: emit, batch: batch}
+}
+
+func (w *textWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.batch {
+		if err := w.emitUpTo(textBoundary(w.buf, false)); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *textWriter) WriteString(s string) (int, error) {
+	w.buf = append(w.buf, s...)
+	if len(w.buf) >= w.batch {
+		if err := w.emitUpTo(textBoundary(w.buf, false)); err != nil {
+			return 0, err
+		}
+	}
+	return len(s), nil
+}
+
+// Flush emits the buffered text. Whether the next write extends the last character,
+// e.g. with a combining mark, can't be known; only an incomplete UTF-8 sequence, a
+// trailing zero-width joiner or half a regional indicator pair is held back.
+func (w *textWriter) Flush() error {
+	return w.emitUpTo(textBoundary(w.buf, true))
+}
+
+// drain returns and forgets all the buffered text, held back or not
+func (w *textWriter) drain() string {
+	text := string(w.buf)
+	w.buf = w.buf[:0]
+	return text
+}
+
+func (w *textWriter) emitUpTo(n int) error {
+	if n == 0 {
+		return nil
+	}
+	text := string(w.buf[:n])
+	w.buf = append(w.buf[:0], w.buf[n:]...)
+	return w.emit(text)
+}
+
+// textBoundary returns the length of the longest prefix of text that can be emitted
+// without splitting a UTF-8 sequence or a grapheme cluster. The end of text is a
+// boundary only at a flush, as the next write may extend the last cluster.
+func textBoundary(text []byte, flush bool) int {
+	complete := len(text)
+	for i := len(text) - 1; i >= 0 && i >= len(text)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(text[i]) {
+			if !utf8.FullRune(text[i:]) {
+				complete = i
+			}
+			break
+		}
+	}
+	text = text[:complete]
+	if flush && canEndAfter(text) {
+		return complete
+	}
+	for pos := complete; pos > 0; {
+		after, _ := utf8.DecodeRune(text[pos:])
+		if pos < complete && canSplitBefore(text[:pos], after) {
+			return pos
+		}
+		_, size := utf8.DecodeLastRune(text[:pos])
+		pos -= size
+	}
+	return 0
+}
+
+// canSplitBefore reports whether a CHUNK may end after text with the next starting
+// with r: not inside a CR LF pair, a zero-width joiner sequence, a regional indicator
+// pair, or before a mark or modifier extending the preceding character
+func canSplitBefore(text []byte, r rune) bool {
+	before, _ := utf8.DecodeLastRune(text)
+	switch {
+	case before == '\r' && r == '\n':
+		return false
+	case before == zeroWidthJoiner || extendsCluster(r):
+		return false
+	case isRegionalIndicator(before) && isRegionalIndicator(r):
+		return trailingRegionalIndicators(text)%2 == 0
+	}
+	return true
+}
+
+// canEndAfter reports whether text is complete at its end as far as can be told
+func canEndAfter(text []byte) bool {
+	last, _ := utf8.DecodeLastRune(text)
+	if last == zeroWidthJoiner {
+		return false
+	}
+	return !isRegionalIndicator(last) || trailingRegionalIndicators(text)%2 == 0
+}
+
+const zeroWidthJoiner = '\u200d'
+
+// extendsCluster reports whether r belongs to the grapheme cluster of the character
+// before it: combining marks, variation selectors, emoji modifiers and tags
+func extendsCluster(r rune) bool {
+	switch {
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc):
+		return true
+	case r == zeroWidthJoiner, r >= 0xFE00 && r <= 0xFE0F, r >= 0xE0100 && r <= 0xE01EF:
+		return true
+	case r >= 0x1F3FB && r <= 0x1F3FF, r >= 0xE0020 && r <= 0xE007F:
+		return true
+	}
+	return false
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// trailingRegionalIndicators counts the regional indicators text ends with
+func trailingRegionalIndicators(text []byte) int {
+	n := 0
+	for len(text) > 0 {
+		r, size := utf8.DecodeLastRune(text)
+		if !isRegionalIndicator(r) {
+			break
+		}
+		n++
+		text = text[:len(text)-size]
+	}
+	return n
+}
+
+// directTextWriter is a TextWriter emitting each write as it comes, where CHUNK
+// boundaries don't matter
+type directTextWriter struct {
+	emit func(text string) error
+}
+
+func (w *directTextWriter) Write(p []byte) (int, error) {
+	return w.WriteString(string(p))
+}
+
+func (w *directTextWriter) WriteString(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if err := w.emit(s); err != nil {
+		return 0, err
+	}
+	return len(s), nil
+}
+
+func (w *directTextWriter) Flush() error {
+	return nil
+}
@@ -0,0 +1,81 @@
+package bifaci
+
+import (
+	"strings"
+	"testing"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+)
+
+func TestTextBoundary(t *testing.T) {
+	cases := []struct {
+		name  string
+		text  string
+		flush bool
+		want  int
+	}{
+		{"incomplete UTF-8", "ab\xe2\x82", true, 2},
+		{"complete at flush", "abc", true, 3},
+		{"last char held back", "abc", false, 2},
+		{"combining mark", "ae\u0301", false, 1},
+		{"zero-width joiner", "x\U0001F468\u200d\U0001F469", false, 1},
+		{"trailing joiner at flush", "x\U0001F468\u200d", true, 1},
+		{"flag pair", "x\U0001F1EB\U0001F1F7y", false, 9},
+		{"half a flag at flush", "x\U0001F1EB", true, 1},
+		{"CR LF", "a\r\n", false, 1},
+	}
+	for _, c := range cases {
+		if got := textBoundary([]byte(c.text), c.flush); got != c.want {
+			t.Errorf("%s: textBoundary(%q, %v) = %d, want %d", c.name, c.text, c.flush, got, c.want)
+		}
+	}
+}
+
+func TestTextWriterBatches(t *testing.T) {
+	var emitted []string
+	writer := newTextWriter(func(text string) error {
+		emitted = append(emitted, text)
+		return nil
+	}, 8)
+
+	for _, token := range []string{"Caf", "e", "\u0301", " au", " lait"} {
+		if _, err := writer.WriteString(token); err != nil {
+			t.Fatalf("WriteString failed: %v", err)
+		}
+	}
+	if len(emitted) != 1 || emitted[0] != "Cafe\u0301 a" {
+		t.Errorf("Expected one batch ending before the last character, got %q", emitted)
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if strings.Join(emitted, "") != "Cafe\u0301 au lait" {
+		t.Errorf("Expected the whole text after Flush, got %q", emitted)
+	}
+}
+
+// Text left in the writer is sent before END
+func TestTextWriterDrainedAtEnd(t *testing.T) {
+	frames := runEmitHandler(t, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		_, err := TextWriterOf(emitter).WriteString("héllo")
+		return err
+	})
+	var text string
+	for _, frame := range frames {
+		if frame.FrameType == FrameTypeChunk {
+			var chunk string
+			if err := cborlib.Unmarshal(frame.Payload, &chunk); err != nil {
+				t.Fatalf("Expected a text CHUNK: %v", err)
+			}
+			text += chunk
+		}
+	}
+	if text != "héllo" {
+		t.Errorf("Expected the buffered text, got %q", text)
+	}
+	if last := frames[len(frames)-1]; last.FrameType != FrameTypeEnd {
+		t.Errorf("Expected END, got %v", last.FrameType)
+	}
+}
@@ -0,0 +1,49 @@
+package bifaci
+
+import (
+	"time"
+)
+
+// SetDefaultHandlerTimeout bounds every handler without a timeout of its own
+// (see SetHandlerTimeout); 0 disables it. When a handler runs past its deadline its
+// context is cancelled and the request is answered with ERR TIMEOUT.
+func (pr *PluginRuntime) SetDefaultHandlerTimeout(timeout time.Duration) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.defaultTimeout = timeout
+}
+
+// SetHandlerTimeout bounds the handler registered for capUrn, overriding the default
+// timeout; 0 removes the override.
+func (pr *PluginRuntime) SetHandlerTimeout(capUrn string, timeout time.Duration) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if timeout <= 0 {
+		delete(pr.handlerTimeouts, capUrn)
+		return
+	}
+	if pr.handlerTimeouts == nil {
+		pr.handlerTimeouts = make(map[string]time.Duration)
+	}
+	pr.handlerTimeouts[capUrn] = timeout
+}
+
+// requestTimeout returns the time a request may run: the handler's timeout, shortened
+// to the deadline the caller put in the REQ. 0 means unbounded.
+func (pr *PluginRuntime) requestTimeout(pattern string, req *Frame) time.Duration {
+	pr.mu.RLock()
+	timeout, ok := pr.handlerTimeouts[pattern]
+	if !ok {
+		timeout = pr.defaultTimeout
+	}
+	pr.mu.RUnlock()
+
+	if remaining, ok := req.Deadline(); ok && (timeout <= 0 || remaining < timeout) {
+		// An already expired deadline still has to time out rather than run unbounded
+		if remaining <= 0 {
+			remaining = time.Nanosecond
+		}
+		timeout = remaining
+	}
+	return timeout
+}
@@ -0,0 +1,80 @@
+package bifaci
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// TLSHandshakeTimeout bounds the TLS handshake of a connection served by RunTLS or
+// dialed by DialTLS, so that a peer that stalls in it doesn't hold the connection
+const TLSHandshakeTimeout = 10 * time.Second
+
+// RunTLS serves the frame protocol over TLS: it accepts connections from listener like
+// RunListener, completes the TLS handshake of each and then the HELLO handshake over the
+// encrypted connection. config needs a certificate; to only accept hosts with a client
+// certificate, set ClientAuth to tls.RequireAndVerifyClientCert and ClientCAs. Versions
+// older than TLS 1.2 are refused.
+func (pr *PluginRuntime) RunTLS(listener net.Listener, config *tls.Config) error {
+	if config == nil || (len(config.Certificates) == 0 && config.GetCertificate == nil && config.GetConfigForClient == nil) {
+		return errors.New("TLS config has no certificate")
+	}
+	return pr.RunListener(tls.NewListener(listener, secureTLSConfig(config)))
+}
+
+// DialTLS connects to a plugin served with RunTLS and completes the TLS handshake.
+// Without a ServerName in config, the host part of addr is verified against the
+// plugin's certificate.
+func DialTLS(ctx context.Context, addr string, config *tls.Config) (*tls.Conn, error) {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	ctx, cancel := context.WithTimeout(ctx, TLSHandshakeTimeout)
+	defer cancel()
+	dialer := &tls.Dialer{Config: secureTLSConfig(config)}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("TLS dial %s failed: %w", addr, err)
+	}
+	return conn.(*tls.Conn), nil
+}
+
+// AttachTLS dials a plugin served with RunTLS and attaches it like AttachPlugin: the
+// HELLO handshake and limits negotiation run over the encrypted connection.
+func (h *PluginHost) AttachTLS(ctx context.Context, addr string, config *tls.Config) (int, error) {
+	conn, err := DialTLS(ctx, addr, config)
+	if err != nil {
+		return -1, err
+	}
+	pluginIdx, err := h.AttachPlugin(conn, conn)
+	if err != nil {
+		conn.Close()
+		return -1, err
+	}
+	return pluginIdx, nil
+}
+
+// secureTLSConfig returns config with TLS 1.2 as the minimum version, leaving the
+// caller's config untouched
+func secureTLSConfig(config *tls.Config) *tls.Config {
+	if config.MinVersion >= tls.VersionTLS12 {
+		return config
+	}
+	secured := config.Clone()
+	secured.MinVersion = tls.VersionTLS12
+	return secured
+}
+
+// tlsHandshake completes the TLS handshake of a served connection before any frame is
+// read, so that a failed handshake is reported as such rather than as a failed HELLO
+func tlsHandshake(conn *tls.Conn) error {
+	ctx, cancel := context.WithTimeout(context.Background(), TLSHandshakeTimeout)
+	defer cancel()
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return fmt.Errorf("TLS handshake failed: %w", err)
+	}
+	return nil
+}
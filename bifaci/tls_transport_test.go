@@ -0,0 +1,162 @@
+package bifaci
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testCertificate returns a self-signed certificate for 127.0.0.1 and a pool trusting it
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "bifaci test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(parsed)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: parsed}, pool
+}
+
+// serveTLS runs a runtime with RunTLS on a loopback listener and returns its address
+func serveTLS(t *testing.T, runtime *PluginRuntime, config *tls.Config) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go runtime.RunTLS(listener, config)
+	return listener.Addr().String()
+}
+
+func TestTLSRuntimeRoundtrip(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		return emitter.EmitCbor("pong")
+	})
+	cert, pool := testCertificate(t)
+	addr := serveTLS(t, runtime, &tls.Config{Certificates: []tls.Certificate{cert}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := DialTLS(ctx, addr, &tls.Config{RootCAs: pool})
+	if err != nil {
+		t.Fatalf("DialTLS failed: %v", err)
+	}
+	defer conn.Close()
+	if state := conn.ConnectionState(); state.Version < tls.VersionTLS12 {
+		t.Errorf("Expected at least TLS 1.2, got %x", state.Version)
+	}
+
+	reader := NewFrameReader(conn)
+	writer := NewFrameWriter(conn)
+	if _, _, err := HandshakeInitiate(reader, writer); err != nil {
+		t.Fatalf("Handshake over TLS failed: %v", err)
+	}
+	reqId := NewMessageIdRandom()
+	writeTestRequest(t, writer, reqId, testCancelCap, []byte{0x40})
+	frames := readUntilTerminal(t, reader, reqId)
+	if last := frames[len(frames)-1]; last.FrameType != FrameTypeEnd {
+		t.Fatalf("Expected END, got %v", last.FrameType)
+	}
+}
+
+func TestTLSAttachPlugin(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	cert, pool := testCertificate(t)
+	addr := serveTLS(t, runtime, &tls.Config{Certificates: []tls.Certificate{cert}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// A host that doesn't trust the plugin's certificate never gets to the HELLO
+	if _, err := NewPluginHost().AttachTLS(ctx, addr, &tls.Config{}); err == nil {
+		t.Error("Expected an untrusted certificate to be refused")
+	}
+
+	host := NewPluginHost()
+	pluginIdx, err := host.AttachTLS(ctx, addr, &tls.Config{RootCAs: pool})
+	if err != nil {
+		t.Fatalf("AttachTLS failed: %v", err)
+	}
+	if pluginIdx != 0 {
+		t.Errorf("Expected plugin index 0, got %d", pluginIdx)
+	}
+	if len(host.Capabilities()) == 0 {
+		t.Error("Expected the remote plugin's capabilities to be registered")
+	}
+}
+
+// With client authentication required, only hosts presenting a trusted certificate attach
+func TestTLSMutualAuthentication(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	cert, pool := testCertificate(t)
+	addr := serveTLS(t, runtime, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := NewPluginHost().AttachTLS(ctx, addr, &tls.Config{RootCAs: pool}); err == nil {
+		t.Error("Expected a host without a client certificate to be refused")
+	}
+	if _, err := NewPluginHost().AttachTLS(ctx, addr, &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}}); err != nil {
+		t.Errorf("AttachTLS with a client certificate failed: %v", err)
+	}
+}
+
+func TestRunTLSRequiresCertificate(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	if err := runtime.RunTLS(listener, &tls.Config{}); err == nil {
+		t.Error("Expected RunTLS to refuse a config without a certificate")
+	}
+}
@@ -0,0 +1,247 @@
+package bifaci
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseTOML parses the subset of TOML config files use: comments, [table] headers,
+// and key = value pairs with bare, quoted or dotted keys, where a value is a basic or
+// literal string, integer, float, boolean, array or inline table on one line. Arrays
+// of tables, multi-line strings and dates are not supported.
+func parseTOML(data string) (map[string]interface{}, error) {
+	root := make(map[string]interface{})
+	current := root
+	for n, line := range strings.Split(data, "\n") {
+		p := &tomlParser{s: strings.TrimSpace(strings.TrimSuffix(line, "\r"))}
+		if p.done() {
+			continue
+		}
+		fail := func(err error) (map[string]interface{}, error) {
+			return nil, fmt.Errorf("line %d: %w", n+1, err)
+		}
+
+		if p.peek() == '[' {
+			if strings.HasPrefix(p.s, "[[") {
+				return fail(fmt.Errorf("arrays of tables are not supported"))
+			}
+			p.pos++
+			key, err := p.key()
+			if err != nil {
+				return fail(err)
+			}
+			if !p.consume(']') || !p.done() {
+				return fail(fmt.Errorf("invalid table header"))
+			}
+			if current, err = tomlTable(root, key); err != nil {
+				return fail(err)
+			}
+			continue
+		}
+
+		key, err := p.key()
+		if err != nil {
+			return fail(err)
+		}
+		if !p.consume('=') {
+			return fail(fmt.Errorf("expected '=' after key"))
+		}
+		value, err := p.value()
+		if err != nil {
+			return fail(err)
+		}
+		if !p.done() {
+			return fail(fmt.Errorf("unexpected %q after value", p.s[p.pos:]))
+		}
+		table, err := tomlTable(current, key[:len(key)-1])
+		if err != nil {
+			return fail(err)
+		}
+		name := key[len(key)-1]
+		if _, exists := table[name]; exists {
+			return fail(fmt.Errorf("duplicate key %q", strings.Join(key, ".")))
+		}
+		table[name] = value
+	}
+	return root, nil
+}
+
+// tomlTable returns the table at key under table, creating the missing ones
+func tomlTable(table map[string]interface{}, key []string) (map[string]interface{}, error) {
+	for _, name := range key {
+		switch next := table[name].(type) {
+		case nil:
+			created := make(map[string]interface{})
+			table[name] = created
+			table = created
+		case map[string]interface{}:
+			table = next
+		default:
+			return nil, fmt.Errorf("key %q is not a table", name)
+		}
+	}
+	return table, nil
+}
+
+// tomlParser reads the key or value on a line of TOML
+type tomlParser struct {
+	s   string
+	pos int
+}
+
+func (p *tomlParser) skipSpace() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// done reports whether only whitespace and a comment are left
+func (p *tomlParser) done() bool {
+	p.skipSpace()
+	return p.pos == len(p.s) || p.s[p.pos] == '#'
+}
+
+func (p *tomlParser) peek() byte {
+	p.skipSpace()
+	if p.pos == len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+// consume skips c if it is next
+func (p *tomlParser) consume(c byte) bool {
+	if p.peek() != c {
+		return false
+	}
+	p.pos++
+	return true
+}
+
+// key reads a dotted key into its parts
+func (p *tomlParser) key() ([]string, error) {
+	var parts []string
+	for {
+		var part string
+		switch c := p.peek(); {
+		case c == '"' || c == '\'':
+			s, err := p.str()
+			if err != nil {
+				return nil, err
+			}
+			part = s
+		default:
+			start := p.pos
+			for p.pos < len(p.s) && isBareKeyChar(p.s[p.pos]) {
+				p.pos++
+			}
+			if p.pos == start {
+				return nil, fmt.Errorf("expected a key")
+			}
+			part = p.s[start:p.pos]
+		}
+		parts = append(parts, part)
+		if !p.consume('.') {
+			return parts, nil
+		}
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// value reads a string, number, boolean, array or inline table
+func (p *tomlParser) value() (interface{}, error) {
+	switch c := p.peek(); c {
+	case '"', '\'':
+		return p.str()
+	case '[':
+		p.pos++
+		items := []interface{}{}
+		for !p.consume(']') {
+			item, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			if !p.consume(',') && p.peek() != ']' {
+				return nil, fmt.Errorf("expected ',' or ']' in array")
+			}
+		}
+		return items, nil
+	case '{':
+		p.pos++
+		table := make(map[string]interface{})
+		for !p.consume('}') {
+			key, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			if !p.consume('=') {
+				return nil, fmt.Errorf("expected '=' after key")
+			}
+			value, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			inner, err := tomlTable(table, key[:len(key)-1])
+			if err != nil {
+				return nil, err
+			}
+			inner[key[len(key)-1]] = value
+			if !p.consume(',') && p.peek() != '}' {
+				return nil, fmt.Errorf("expected ',' or '}' in inline table")
+			}
+		}
+		return table, nil
+	case 0:
+		return nil, fmt.Errorf("expected a value")
+	}
+
+	start := p.pos
+	for p.pos < len(p.s) && !strings.ContainsRune(" \t,]}#", rune(p.s[p.pos])) {
+		p.pos++
+	}
+	token := p.s[start:p.pos]
+	switch token {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	number := strings.ReplaceAll(token, "_", "")
+	if i, err := strconv.ParseInt(number, 0, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(number, 64); err == nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("invalid value %q", token)
+}
+
+// str reads a basic ("...", with escapes) or literal ('...') string
+func (p *tomlParser) str() (string, error) {
+	quote := p.s[p.pos]
+	start := p.pos
+	p.pos++
+	for p.pos < len(p.s) && p.s[p.pos] != quote {
+		if quote == '"' && p.s[p.pos] == '\\' {
+			p.pos++
+		}
+		p.pos++
+	}
+	if p.pos >= len(p.s) {
+		return "", fmt.Errorf("unterminated string")
+	}
+	p.pos++
+	if quote == '\'' {
+		return p.s[start+1 : p.pos-1], nil
+	}
+	s, err := strconv.Unquote(p.s[start:p.pos])
+	if err != nil {
+		return "", fmt.Errorf("invalid string %s", p.s[start:p.pos])
+	}
+	return s, nil
+}
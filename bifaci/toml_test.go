@@ -0,0 +1,56 @@
+package bifaci
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	values, err := parseTOML(`
+# Defaults
+model = "large" # trailing comment
+temperature = 0.5
+max_tokens = 1_024
+stream = true
+stop = ["\n", 'END']
+
+[openai]
+api.base = 'https://api.example.com'
+"org id" = "org-1"
+limits = { rpm = 60, burst = 10 }
+`)
+	if err != nil {
+		t.Fatalf("parseTOML failed: %v", err)
+	}
+	expected := map[string]interface{}{
+		"model":       "large",
+		"temperature": 0.5,
+		"max_tokens":  int64(1024),
+		"stream":      true,
+		"stop":        []interface{}{"\n", "END"},
+		"openai": map[string]interface{}{
+			"api":    map[string]interface{}{"base": "https://api.example.com"},
+			"org id": "org-1",
+			"limits": map[string]interface{}{"rpm": int64(60), "burst": int64(10)},
+		},
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected %v, got %v", expected, values)
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	for _, data := range []string{
+		`model = `,
+		`model = "unterminated`,
+		`model = "a" "b"`,
+		"model = 1\nmodel = 2",
+		"model = 1\n[model]",
+		`[[servers]]`,
+		`= 1`,
+	} {
+		if _, err := parseTOML(data); err == nil {
+			t.Errorf("Expected an error for %q", data)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package bifaci
+
+import (
+	"context"
+)
+
+// TraceContext is a W3C trace context (https://www.w3.org/TR/trace-context/) carried in
+// frame meta, so spans of a multi-plugin DAG execution link up across processes.
+type TraceContext struct {
+	TraceParent string // "00-<trace-id>-<parent-id>-<flags>"
+	TraceState  string // Optional vendor state
+}
+
+// IsZero reports whether no trace context is set.
+func (tc TraceContext) IsZero() bool {
+	return tc.TraceParent == ""
+}
+
+// Span is one unit of traced work started by a Tracer.
+type Span interface {
+	// TraceContext returns the span's context, propagated to peer invocations as their parent.
+	TraceContext() TraceContext
+	// End finishes the span; err is the outcome (nil on success).
+	End(err error)
+}
+
+// Tracer creates spans for handler and peer invocations. Plug in OpenTelemetry by
+// implementing it on top of a TracerProvider: extract parent with a
+// propagation.TraceContext propagator, start the span, and inject its context back
+// into a TraceContext. The returned context is handed to the handler (HandlerContext),
+// so the handler can reach the span with the tracing library's own helpers.
+type Tracer interface {
+	// StartSpan starts a span named name. parent is the remote parent (zero if none);
+	// ctx may carry a local parent. attrs describe the invocation (cap URN, request ID).
+	StartSpan(ctx context.Context, name string, parent TraceContext, attrs map[string]string) (context.Context, Span)
+}
+
+// SetTracer enables spans for handler invocations ("bifaci.handle") and peer
+// invocations ("bifaci.peer"). Without a tracer, incoming trace contexts are still
+// propagated unchanged to peer invocations.
+func (pr *PluginRuntime) SetTracer(tracer Tracer) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.tracer = tracer
+}
+
+type traceContextKey struct{}
+
+// ContextWithTraceContext returns ctx carrying tc for propagation.
+func ContextWithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// TraceContextFromContext returns the trace context the current handler or span runs in.
+func TraceContextFromContext(ctx context.Context) TraceContext {
+	tc, _ := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc
+}
+
+// startTracedSpan starts a span if a tracer is set and records the trace context to
+// propagate in ctx. Without a tracer, parent is propagated as-is and the span is nil.
+func startTracedSpan(ctx context.Context, tracer Tracer, name string, parent TraceContext, attrs map[string]string) (context.Context, Span) {
+	if tracer == nil {
+		if parent.IsZero() {
+			return ctx, nil
+		}
+		return ContextWithTraceContext(ctx, parent), nil
+	}
+	spanCtx, span := tracer.StartSpan(ctx, name, parent, attrs)
+	if span != nil {
+		spanCtx = ContextWithTraceContext(spanCtx, span.TraceContext())
+	}
+	return spanCtx, span
+}
+
+// endSpan ends span if it is set.
+func endSpan(span Span, err error) {
+	if span != nil {
+		span.End(err)
+	}
+}
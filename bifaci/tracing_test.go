@@ -0,0 +1,141 @@
+package bifaci
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// recordingTracer hands out sequential span IDs and records each span's parent and outcome
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	name   string
+	parent TraceContext
+	ctx    TraceContext
+	ended  bool
+	err    error
+}
+
+func (s *recordedSpan) TraceContext() TraceContext { return s.ctx }
+
+func (s *recordedSpan) End(err error) {
+	s.ended = true
+	s.err = err
+}
+
+func (r *recordingTracer) StartSpan(ctx context.Context, name string, parent TraceContext, attrs map[string]string) (context.Context, Span) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	span := &recordedSpan{
+		name:   name,
+		parent: parent,
+		ctx:    TraceContext{TraceParent: fmt.Sprintf("00-4bf92f3577b34da6a3ce929d0e0e4736-%016x-01", len(r.spans)+1)},
+	}
+	r.spans = append(r.spans, span)
+	return ctx, span
+}
+
+// TestFrameTraceContextRoundtrip: traceparent/tracestate survive encoding in REQ meta
+func TestFrameTraceContextRoundtrip(t *testing.T) {
+	tc := TraceContext{TraceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", TraceState: "vendor=1"}
+	frame := NewReq(NewMessageIdRandom(), "cap:", nil, "application/cbor")
+	frame.SetTraceContext(tc)
+
+	encoded, err := EncodeFrame(frame)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	decoded, err := DecodeFrame(encoded)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	got, ok := decoded.TraceContext()
+	if !ok || got != tc {
+		t.Errorf("Expected %+v, got %+v (ok=%v)", tc, got, ok)
+	}
+
+	if _, ok := NewReq(NewMessageIdRandom(), "cap:", nil, "").TraceContext(); ok {
+		t.Error("REQ without traceparent must report no trace context")
+	}
+}
+
+// TestHandlerAndPeerSpans: the handler span is a child of the REQ's traceparent, and a peer
+// invocation gets a child span whose context travels in the peer REQ
+func TestHandlerAndPeerSpans(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	tracer := &recordingTracer{}
+	runtime.SetTracer(tracer)
+
+	handlerTrace := make(chan TraceContext, 1)
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		handlerTrace <- TraceContextFromContext(HandlerContext(emitter))
+		responses, err := peer.Invoke(`cap:in="media:void";op=other;out="media:void"`, nil)
+		if err != nil {
+			return err
+		}
+		for range responses {
+		}
+		return nil
+	})
+
+	reader, writer, stop := startCBORRuntime(t, runtime)
+	reqId := NewMessageIdRandom()
+	incoming := TraceContext{TraceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}
+	req := NewReq(reqId, testCancelCap, nil, "application/cbor")
+	req.SetTraceContext(incoming)
+	writer.WriteFrame(req)
+	writer.WriteFrame(NewEnd(reqId, nil))
+
+	// Host side: answer the peer REQ with END
+	var peerTrace TraceContext
+	for {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		if frame.FrameType == FrameTypeReq {
+			peerTrace, _ = frame.TraceContext()
+			writer.WriteFrame(NewEnd(frame.Id, nil))
+			break
+		}
+	}
+	frames := readUntilTerminal(t, reader, reqId)
+	if frames[len(frames)-1].FrameType != FrameTypeEnd {
+		t.Fatalf("Expected END, got %v", frames[len(frames)-1].FrameType)
+	}
+	if err := stop(); err != nil {
+		t.Errorf("Runtime exited with error: %v", err)
+	}
+
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	if len(tracer.spans) != 2 {
+		t.Fatalf("Expected handler and peer spans, got %d", len(tracer.spans))
+	}
+	handlerSpan, peerSpan := tracer.spans[0], tracer.spans[1]
+	if handlerSpan.name != "bifaci.handle "+testCancelCap || handlerSpan.parent != incoming {
+		t.Errorf("Handler span %q has parent %+v, want %+v", handlerSpan.name, handlerSpan.parent, incoming)
+	}
+	if got := <-handlerTrace; got != handlerSpan.ctx {
+		t.Errorf("HandlerContext must carry the handler span, got %+v", got)
+	}
+	if peerSpan.parent != handlerSpan.ctx {
+		t.Errorf("Peer span must be a child of the handler span, parent %+v", peerSpan.parent)
+	}
+	if peerTrace != peerSpan.ctx {
+		t.Errorf("Peer REQ must carry the peer span context, got %+v", peerTrace)
+	}
+	if !handlerSpan.ended || handlerSpan.err != nil || !peerSpan.ended || peerSpan.err != nil {
+		t.Errorf("Both spans must end successfully: handler=%v/%v peer=%v/%v", handlerSpan.ended, handlerSpan.err, peerSpan.ended, peerSpan.err)
+	}
+}
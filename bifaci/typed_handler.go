@@ -0,0 +1,155 @@
+package bifaci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+)
+
+// ArgumentDecodeError is returned by typed handlers when the incoming argument cannot be
+// decoded into the handler's input type. The runtime reports it as ERR INVALID_ARGUMENT
+// instead of the generic HANDLER_ERROR.
+type ArgumentDecodeError struct {
+	CapUrn string // Cap the argument was sent to
+	Target string // Go type the argument was decoded into
+	Err    error  // Underlying CBOR/JSON error
+}
+
+func (e *ArgumentDecodeError) Error() string {
+	return fmt.Sprintf("failed to decode argument for %s into %s: %v", e.CapUrn, e.Target, e.Err)
+}
+
+func (e *ArgumentDecodeError) Unwrap() error {
+	return e.Err
+}
+
+// RegisterTyped registers a handler that works on Go values instead of frames.
+//
+// The first argument stream is decoded into In:
+//   - structured CBOR values (maps, arrays, numbers) are decoded directly via CBOR
+//   - byte/text values are treated as JSON text, unless In is []byte or string,
+//     in which case they are assigned as-is
+//
+// The returned Out is emitted as a single CBOR value. The context is the request
+// context (cancelled by CANCEL, see HandlerContext).
+//
+// This is a function rather than a method because Go methods cannot have type parameters.
+func RegisterTyped[In, Out any](rt *PluginRuntime, capUrn string, fn func(ctx context.Context, in In) (Out, error)) {
+	rt.Register(capUrn, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		raw, err := CollectFirstArg(frames)
+		if err != nil {
+			return err
+		}
+
+		var in In
+		if err := decodeTypedArg(raw, &in); err != nil {
+			return &ArgumentDecodeError{CapUrn: capUrn, Target: fmt.Sprintf("%T", in), Err: err}
+		}
+
+		out, err := fn(HandlerContext(emitter), in)
+		if err != nil {
+			return err
+		}
+		return emitter.EmitCbor(out)
+	})
+}
+
+// decodeTypedArg decodes the raw bytes of one argument stream into target.
+// raw is the concatenation of the stream's CHUNK payloads, each an independent CBOR value.
+func decodeTypedArg(raw []byte, target interface{}) error {
+	if len(raw) == 0 {
+		// No argument - leave target at its zero value
+		return nil
+	}
+
+	values, err := decodeCborSequence(raw)
+	if err != nil {
+		// Not CBOR at all - accept raw JSON text
+		return json.Unmarshal(raw, target)
+	}
+
+	value := joinChunkValues(values)
+	switch v := value.(type) {
+	case []byte:
+		err := assignOrUnmarshalJSON(v, target)
+		if err != nil && cborlib.Wellformed(v) == nil {
+			// A structured value larger than a chunk, sent as byte strings of its encoding
+			return cborlib.Unmarshal(v, target)
+		}
+		return err
+	case string:
+		return assignOrUnmarshalJSON([]byte(v), target)
+	}
+
+	// Structured value - re-encode once and decode into the target type
+	encoded, err := cborlib.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return cborlib.Unmarshal(encoded, target)
+}
+
+// decodeCborSequence decodes a sequence of concatenated CBOR values.
+func decodeCborSequence(data []byte) ([]interface{}, error) {
+	decoder := cborlib.NewDecoder(bytes.NewReader(data))
+	var values []interface{}
+	for {
+		var value interface{}
+		err := decoder.Decode(&value)
+		if errors.Is(err, io.EOF) {
+			return values, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+}
+
+// joinChunkValues reassembles a value that the sender split across chunks:
+// byte and text chunks are concatenated, anything else is collected into an array.
+func joinChunkValues(values []interface{}) interface{} {
+	if len(values) == 1 {
+		return values[0]
+	}
+	switch values[0].(type) {
+	case []byte:
+		var result []byte
+		for _, v := range values {
+			if b, ok := v.([]byte); ok {
+				result = append(result, b...)
+			}
+		}
+		return result
+	case string:
+		var result string
+		for _, v := range values {
+			if s, ok := v.(string); ok {
+				result += s
+			}
+		}
+		return result
+	default:
+		return values
+	}
+}
+
+// assignOrUnmarshalJSON stores data directly into *[]byte / *string targets and
+// decodes it as JSON text for every other target type.
+func assignOrUnmarshalJSON(data []byte, target interface{}) error {
+	switch t := target.(type) {
+	case *[]byte:
+		*t = data
+		return nil
+	case *string:
+		*t = string(data)
+		return nil
+	default:
+		return json.Unmarshal(data, target)
+	}
+}
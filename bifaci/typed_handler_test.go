@@ -0,0 +1,122 @@
+package bifaci
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	cborlib "github.com/fxamacker/cbor/v2"
+)
+
+type typedTestInput struct {
+	Name  string `json:"name" cbor:"name"`
+	Count int    `json:"count" cbor:"count"`
+}
+
+type typedTestOutput struct {
+	Greeting string `cbor:"greeting"`
+}
+
+// TestDecodeTypedArgFromCborMap: a structured CBOR argument decodes directly into a struct
+func TestDecodeTypedArgFromCborMap(t *testing.T) {
+	raw, _ := cborlib.Marshal(map[string]interface{}{"name": "go", "count": 3})
+
+	var in typedTestInput
+	if err := decodeTypedArg(raw, &in); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if in.Name != "go" || in.Count != 3 {
+		t.Errorf("unexpected value: %+v", in)
+	}
+}
+
+// TestDecodeTypedArgFromJsonBytes: a CBOR byte string holding JSON text decodes via JSON
+func TestDecodeTypedArgFromJsonBytes(t *testing.T) {
+	raw, _ := cborlib.Marshal([]byte(`{"name":"json","count":7}`))
+
+	var in typedTestInput
+	if err := decodeTypedArg(raw, &in); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if in.Name != "json" || in.Count != 7 {
+		t.Errorf("unexpected value: %+v", in)
+	}
+}
+
+// TestDecodeTypedArgChunkedString: text split across chunks is rejoined before assignment
+func TestDecodeTypedArgChunkedString(t *testing.T) {
+	first, _ := cborlib.Marshal("hello ")
+	second, _ := cborlib.Marshal("world")
+
+	var in string
+	if err := decodeTypedArg(append(first, second...), &in); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if in != "hello world" {
+		t.Errorf("expected 'hello world', got %q", in)
+	}
+}
+
+// TestRegisterTypedRoundtrip: typed handler receives decoded input and its output is emitted as CBOR
+func TestRegisterTypedRoundtrip(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+
+	capUrn := `cap:in="media:json;textable";op=greet;out="media:json;textable"`
+	RegisterTyped(runtime, capUrn, func(ctx context.Context, in typedTestInput) (typedTestOutput, error) {
+		return typedTestOutput{Greeting: "hello " + in.Name}, nil
+	})
+
+	handler := runtime.FindHandler(capUrn)
+	if handler == nil {
+		t.Fatal("typed handler not registered")
+	}
+
+	raw, _ := cborlib.Marshal(map[string]interface{}{"name": "typed", "count": 1})
+	emitter := &mockStreamEmitter{}
+	if err := handler(bytesToFrameChannel(raw), emitter, &noPeerInvoker{}); err != nil {
+		t.Fatalf("handler failed: %v", err)
+	}
+
+	var out typedTestOutput
+	if err := cborlib.Unmarshal(emitter.GetAllData(), &out); err != nil {
+		t.Fatalf("output is not CBOR: %v", err)
+	}
+	if out.Greeting != "hello typed" {
+		t.Errorf("unexpected output: %+v", out)
+	}
+}
+
+// TestRegisterTypedDecodeFailure: undecodable input yields ArgumentDecodeError mapped to INVALID_ARGUMENT
+func TestRegisterTypedDecodeFailure(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+
+	capUrn := `cap:in="media:json;textable";op=greet;out="media:json;textable"`
+	called := false
+	RegisterTyped(runtime, capUrn, func(ctx context.Context, in typedTestInput) (typedTestOutput, error) {
+		called = true
+		return typedTestOutput{}, nil
+	})
+
+	raw, _ := cborlib.Marshal([]byte("not json"))
+	err = runtime.FindHandler(capUrn)(bytesToFrameChannel(raw), &mockStreamEmitter{}, &noPeerInvoker{})
+
+	var decodeErr *ArgumentDecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("expected ArgumentDecodeError, got %v", err)
+	}
+	if decodeErr.Target != "bifaci.typedTestInput" {
+		t.Errorf("unexpected target type: %s", decodeErr.Target)
+	}
+	if handlerErrorCode(err) != "INVALID_ARGUMENT" {
+		t.Errorf("expected INVALID_ARGUMENT, got %s", handlerErrorCode(err))
+	}
+	if called {
+		t.Error("typed function must not run when decoding fails")
+	}
+}
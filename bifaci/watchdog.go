@@ -0,0 +1,91 @@
+package bifaci
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrHostSilent is returned by Run when no frame arrived from the host within the
+// heartbeat timeout (see SetHeartbeatTimeout)
+var ErrHostSilent = errors.New("no frames from host within the heartbeat timeout")
+
+// SetHeartbeatTimeout makes the runtime shut down when the host sends nothing (not even
+// a HEARTBEAT) for timeout: running handlers are cancelled, and once they return Run
+// returns ErrHostSilent. This keeps a plugin from lingering after its host crashed
+// without closing the pipe. The host must send frames, e.g. heartbeats, more often than
+// timeout. 0 disables the watchdog.
+func (pr *PluginRuntime) SetHeartbeatTimeout(timeout time.Duration) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.heartbeatTimeout = timeout
+}
+
+// Clock is the time source of the heartbeat watchdog. Tests substitute a fake one to
+// trigger the watchdog without waiting (see SetClock).
+type Clock interface {
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a timer of a Clock, with the semantics of time.Timer
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// SetClock replaces the wall clock that times the heartbeat watchdog
+func (pr *PluginRuntime) SetClock(clock Clock) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.clock = clock
+}
+
+// wallClock is the default Clock
+type wallClock struct{}
+
+func (wallClock) NewTimer(d time.Duration) Timer { return wallTimer{time.NewTimer(d)} }
+
+type wallTimer struct{ *time.Timer }
+
+func (t wallTimer) C() <-chan time.Time { return t.Timer.C }
+
+// watchdogRead wraps read so that it fails with ErrHostSilent when no frame arrives for
+// timeout. Frames are read by a goroutine that stops once done is closed; a read that
+// is blocked when the runtime gives up ends with the process or the connection.
+func watchdogRead(read func() (*Frame, error), clock Clock, timeout time.Duration, done <-chan struct{}) func() (*Frame, error) {
+	type result struct {
+		frame *Frame
+		err   error
+	}
+	results := make(chan result)
+	go func() {
+		for {
+			frame, err := read()
+			select {
+			case results <- result{frame, err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	timer := clock.NewTimer(timeout)
+	return func() (*Frame, error) {
+		select {
+		case r := <-results:
+			if !timer.Stop() {
+				select {
+				case <-timer.C():
+				default:
+				}
+			}
+			timer.Reset(timeout)
+			return r.frame, r.err
+		case <-timer.C():
+			return nil, ErrHostSilent
+		}
+	}
+}
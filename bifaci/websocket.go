@@ -0,0 +1,488 @@
+package bifaci
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebSocketSubprotocol is the Sec-WebSocket-Protocol name of the frame protocol.
+const WebSocketSubprotocol = "bifaci"
+
+// DefaultWebSocketPingInterval is how often AttachWebSocket pings the plugin to keep
+// idle connections alive through proxies and load balancers.
+const DefaultWebSocketPingInterval = 30 * time.Second
+
+// RFC 6455 opcodes
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// websocketGUID is appended to Sec-WebSocket-Key to compute Sec-WebSocket-Accept (RFC 6455 §4.2.2)
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocketConn carries the frame protocol over a WebSocket connection,
+// one CBOR frame per binary message.
+//
+// Read and Write present the connection as the length-prefixed byte stream that
+// FrameReader and FrameWriter expect: the 4-byte length prefix is stripped from
+// outgoing frames and re-added to incoming messages. This lets serveCBOR and
+// PluginHost.AttachPlugin (handshake, limits negotiation, heartbeats) run unchanged.
+type WebSocketConn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool // Client-side connections mask outgoing messages (RFC 6455 §5.3)
+
+	writeMu   sync.Mutex // Serializes WebSocket frames (data, pong, close)
+	closeOnce sync.Once
+	closeSent bool
+
+	readMu   sync.Mutex
+	readBuf  []byte // Length-prefixed frame not yet consumed by Read
+	writeBuf []byte // Partial length-prefixed frame accumulated by Write (guarded by writeMu)
+}
+
+// UpgradeWebSocket completes the server side of the WebSocket handshake for an HTTP request.
+// On error an HTTP error response has already been written.
+func UpgradeWebSocket(w http.ResponseWriter, r *http.Request) (*WebSocketConn, error) {
+	if r.Method != http.MethodGet ||
+		!headerContainsToken(r.Header, "Connection", "upgrade") ||
+		!headerContainsToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "WebSocket upgrade required", http.StatusUpgradeRequired)
+		return nil, fmt.Errorf("not a WebSocket upgrade request")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusBadRequest)
+		return nil, fmt.Errorf("unsupported WebSocket version %q", r.Header.Get("Sec-WebSocket-Version"))
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "Missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, fmt.Errorf("missing Sec-WebSocket-Key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("hijack failed: %w", err)
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + websocketAccept(key) + "\r\n"
+	if headerContainsToken(r.Header, "Sec-WebSocket-Protocol", WebSocketSubprotocol) {
+		response += "Sec-WebSocket-Protocol: " + WebSocketSubprotocol + "\r\n"
+	}
+	response += "\r\n"
+	if _, err := rw.WriteString(response); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to write upgrade response: %w", err)
+	}
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to write upgrade response: %w", err)
+	}
+
+	return &WebSocketConn{conn: conn, br: rw.Reader}, nil
+}
+
+// DialWebSocket opens a client WebSocket connection to a ws:// or wss:// URL.
+// ctx bounds the connection and the opening handshake.
+func DialWebSocket(ctx context.Context, rawURL string) (*WebSocketConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebSocket URL: %w", err)
+	}
+
+	host := u.Host
+	var conn net.Conn
+	switch u.Scheme {
+	case "ws":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", host)
+	case "wss":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		}
+		dialer := &tls.Dialer{Config: &tls.Config{ServerName: u.Hostname()}}
+		conn, err = dialer.DialContext(ctx, "tcp", host)
+	default:
+		return nil, fmt.Errorf("unsupported WebSocket scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", rawURL, err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	var keyBytes [16]byte
+	if _, err := rand.Read(keyBytes[:]); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to generate WebSocket key: %w", err)
+	}
+	key := base64.StdEncoding.EncodeToString(keyBytes[:])
+
+	request := &http.Request{
+		Method:     http.MethodGet,
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Host:       u.Host,
+		Header: http.Header{
+			"Upgrade":                {"websocket"},
+			"Connection":             {"Upgrade"},
+			"Sec-Websocket-Key":      {key},
+			"Sec-Websocket-Version":  {"13"},
+			"Sec-Websocket-Protocol": {WebSocketSubprotocol},
+		},
+	}
+	if err := request.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send WebSocket handshake: %w", err)
+	}
+
+	br := bufio.NewReader(conn)
+	response, err := http.ReadResponse(br, request)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read WebSocket handshake response: %w", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, fmt.Errorf("WebSocket handshake rejected: %s", response.Status)
+	}
+	if response.Header.Get("Sec-WebSocket-Accept") != websocketAccept(key) {
+		conn.Close()
+		return nil, fmt.Errorf("WebSocket handshake failed: invalid Sec-WebSocket-Accept")
+	}
+
+	conn.SetDeadline(time.Time{})
+	return &WebSocketConn{conn: conn, br: br, client: true}, nil
+}
+
+// ReadMessage reads the next complete binary message.
+// Pings are answered, pongs are ignored. Returns io.EOF once the peer closed the connection.
+func (c *WebSocketConn) ReadMessage() ([]byte, error) {
+	var message []byte
+	inMessage := false
+
+	for {
+		fin, opcode, payload, err := c.readWireFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case wsOpPing:
+			if err := c.writeWireFrame(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			// Echo the close (RFC 6455 §5.5.1) - the connection is done
+			c.sendClose()
+			return nil, io.EOF
+		case wsOpText:
+			return nil, fmt.Errorf("unexpected WebSocket text message - frames are sent as binary messages")
+		case wsOpBinary:
+			if inMessage {
+				return nil, fmt.Errorf("WebSocket binary message started inside a fragmented message")
+			}
+			inMessage = true
+			message = payload
+		case wsOpContinuation:
+			if !inMessage {
+				return nil, fmt.Errorf("WebSocket continuation frame without a message")
+			}
+			message = append(message, payload...)
+		default:
+			return nil, fmt.Errorf("unknown WebSocket opcode 0x%x", opcode)
+		}
+
+		if len(message) > MaxFrameHardLimit {
+			return nil, fmt.Errorf("WebSocket message size %d exceeds hard limit %d", len(message), MaxFrameHardLimit)
+		}
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+// WriteMessage sends payload as a single binary message.
+func (c *WebSocketConn) WriteMessage(payload []byte) error {
+	return c.writeWireFrame(wsOpBinary, payload)
+}
+
+// Ping sends a ping control frame; the peer answers with a pong.
+func (c *WebSocketConn) Ping() error {
+	return c.writeWireFrame(wsOpPing, nil)
+}
+
+// KeepAlive pings the peer every interval until the connection is closed.
+func (c *WebSocketConn) KeepAlive(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := c.Ping(); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// Read implements io.Reader as a length-prefixed frame stream (see WebSocketConn).
+func (c *WebSocketConn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	if len(c.readBuf) == 0 {
+		message, err := c.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		c.readBuf = make([]byte, 4+len(message))
+		binary.BigEndian.PutUint32(c.readBuf, uint32(len(message)))
+		copy(c.readBuf[4:], message)
+	}
+
+	n := copy(p, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
+
+// Write implements io.Writer as a length-prefixed frame stream (see WebSocketConn):
+// every complete frame written is sent as one binary message.
+func (c *WebSocketConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.writeBuf = append(c.writeBuf, p...)
+	for len(c.writeBuf) >= 4 {
+		length := int(binary.BigEndian.Uint32(c.writeBuf))
+		if len(c.writeBuf) < 4+length {
+			break
+		}
+		if err := c.writeWireFrameLocked(wsOpBinary, c.writeBuf[4:4+length]); err != nil {
+			return 0, err
+		}
+		c.writeBuf = c.writeBuf[4+length:]
+	}
+	return len(p), nil
+}
+
+// Close sends a close frame and closes the underlying connection.
+func (c *WebSocketConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.sendClose()
+		err = c.conn.Close()
+	})
+	return err
+}
+
+// RemoteAddr returns the address of the peer.
+func (c *WebSocketConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *WebSocketConn) sendClose() {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return
+	}
+	c.closeSent = true
+	// 1000 = normal closure
+	_ = c.writeWireFrameLocked(wsOpClose, []byte{0x03, 0xE8})
+}
+
+// readWireFrame reads one WebSocket frame (RFC 6455 §5.2) and unmasks its payload.
+func (c *WebSocketConn) readWireFrame() (bool, byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin := header[0]&0x80 != 0
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, fmt.Errorf("WebSocket frame uses reserved bits without a negotiated extension")
+	}
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	if masked == c.client {
+		// Clients must mask, servers must not (RFC 6455 §5.1)
+		return false, 0, nil, fmt.Errorf("WebSocket frame masking violates RFC 6455")
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > uint64(MaxFrameHardLimit) {
+		return false, 0, nil, fmt.Errorf("WebSocket frame size %d exceeds hard limit %d", length, MaxFrameHardLimit)
+	}
+	if opcode >= wsOpClose && (length > 125 || !fin) {
+		return false, 0, nil, fmt.Errorf("invalid WebSocket control frame")
+	}
+
+	var maskKey [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, maskKey[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= maskKey[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+func (c *WebSocketConn) writeWireFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeWireFrameLocked(opcode, payload)
+}
+
+// writeWireFrameLocked writes one unfragmented WebSocket frame (caller must hold writeMu).
+func (c *WebSocketConn) writeWireFrameLocked(opcode byte, payload []byte) error {
+	header := make([]byte, 0, 14)
+	header = append(header, 0x80|opcode)
+
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) <= 125:
+		header = append(header, maskBit|byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		header = append(header, maskBit|126)
+		header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	default:
+		header = append(header, maskBit|127)
+		header = binary.BigEndian.AppendUint64(header, uint64(len(payload)))
+	}
+
+	data := payload
+	if c.client {
+		var maskKey [4]byte
+		if _, err := rand.Read(maskKey[:]); err != nil {
+			return fmt.Errorf("failed to generate WebSocket mask: %w", err)
+		}
+		header = append(header, maskKey[:]...)
+		data = make([]byte, len(payload))
+		for i := range payload {
+			data[i] = payload[i] ^ maskKey[i%4]
+		}
+	}
+
+	if _, err := c.conn.Write(append(header, data...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerContainsToken reports whether a comma-separated header contains token (case-insensitive).
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RunWebSocket serves the frame protocol over an upgraded WebSocket connection
+// until the host closes it. The connection is closed on return.
+func (pr *PluginRuntime) RunWebSocket(conn *WebSocketConn) error {
+	defer conn.Close()
+	return pr.stopAfter(pr.serveCBOR(conn, conn))
+}
+
+// WebSocketHandler returns an http.Handler that upgrades each request and serves it
+// with RunWebSocket.
+func (pr *PluginRuntime) WebSocketHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := UpgradeWebSocket(w, r)
+		if err != nil {
+			return
+		}
+		if err := pr.RunWebSocket(conn); err != nil {
+			pr.log().Error("WebSocket connection failed", "remote", conn.RemoteAddr().String(), "error", err)
+		}
+	})
+}
+
+// AttachWebSocket dials a plugin served over WebSocket (see PluginRuntime.RunWebSocket)
+// and attaches it like AttachPlugin: HELLO handshake and limits negotiation are performed
+// immediately. The connection is kept alive with pings every DefaultWebSocketPingInterval.
+func (h *PluginHost) AttachWebSocket(ctx context.Context, rawURL string) (int, error) {
+	conn, err := DialWebSocket(ctx, rawURL)
+	if err != nil {
+		return -1, err
+	}
+	pluginIdx, err := h.AttachPlugin(conn, conn)
+	if err != nil {
+		conn.Close()
+		return -1, err
+	}
+	conn.KeepAlive(DefaultWebSocketPingInterval)
+	return pluginIdx, nil
+}
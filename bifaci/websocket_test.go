@@ -0,0 +1,139 @@
+package bifaci
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWebSocketRuntimeRoundtrip: a host dials a runtime served over WebSocket,
+// handshakes and gets a response - one frame per binary message
+func TestWebSocketRuntimeRoundtrip(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for range frames {
+		}
+		return emitter.EmitCbor("pong")
+	})
+
+	server := httptest.NewServer(runtime.WebSocketHandler())
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := DialWebSocket(ctx, wsURL)
+	if err != nil {
+		t.Fatalf("DialWebSocket failed: %v", err)
+	}
+	defer conn.Close()
+
+	reader := NewFrameReader(conn)
+	writer := NewFrameWriter(conn)
+	manifest, limits, err := HandshakeInitiate(reader, writer)
+	if err != nil {
+		t.Fatalf("Handshake over WebSocket failed: %v", err)
+	}
+	if len(manifest) == 0 || limits.MaxFrame == 0 {
+		t.Fatalf("Expected manifest and negotiated limits, got %d bytes / %+v", len(manifest), limits)
+	}
+
+	// Heartbeats are answered over the same connection
+	heartbeatId := NewMessageIdRandom()
+	if err := writer.WriteFrame(NewHeartbeat(heartbeatId)); err != nil {
+		t.Fatalf("Failed to write HEARTBEAT: %v", err)
+	}
+	frame, err := reader.ReadFrame()
+	if err != nil || frame.FrameType != FrameTypeHeartbeat || !frame.Id.Equals(heartbeatId) {
+		t.Fatalf("Expected HEARTBEAT response, got %v (%v)", frame, err)
+	}
+
+	reqId := NewMessageIdRandom()
+	writeTestRequest(t, writer, reqId, testCancelCap, []byte{0x40})
+	frames := readUntilTerminal(t, reader, reqId)
+	if last := frames[len(frames)-1]; last.FrameType != FrameTypeEnd {
+		t.Fatalf("Expected END, got %v", last.FrameType)
+	}
+}
+
+// TestWebSocketAttachPlugin: PluginHost.AttachWebSocket registers the remote plugin's caps
+func TestWebSocketAttachPlugin(t *testing.T) {
+	runtime, err := NewPluginRuntime([]byte(testManifest))
+	if err != nil {
+		t.Fatalf("Failed to create runtime: %v", err)
+	}
+	server := httptest.NewServer(runtime.WebSocketHandler())
+	defer server.Close()
+
+	host := NewPluginHost()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	pluginIdx, err := host.AttachWebSocket(ctx, "ws"+strings.TrimPrefix(server.URL, "http"))
+	if err != nil {
+		t.Fatalf("AttachWebSocket failed: %v", err)
+	}
+	if pluginIdx != 0 {
+		t.Errorf("Expected plugin index 0, got %d", pluginIdx)
+	}
+	if len(host.Capabilities()) == 0 {
+		t.Error("Expected capabilities from the attached plugin")
+	}
+}
+
+// TestWebSocketFragmentedMessage: fragments are reassembled and a ping interleaved
+// with them is answered
+func TestWebSocketFragmentedMessage(t *testing.T) {
+	clientSide, serverSide := net.Pipe()
+	defer clientSide.Close()
+	defer serverSide.Close()
+	client := &WebSocketConn{conn: clientSide, br: bufio.NewReader(clientSide), client: true}
+	server := &WebSocketConn{conn: serverSide, br: bufio.NewReader(serverSide)}
+
+	// Binary without FIN, ping, continuation with FIN - all masked as a client must
+	go func() {
+		clientSide.Write(maskedWireFrame(false, wsOpBinary, []byte("frag")))
+		clientSide.Write(maskedWireFrame(true, wsOpPing, []byte("p")))
+		clientSide.Write(maskedWireFrame(true, wsOpContinuation, []byte("ment")))
+	}()
+	pong := make(chan []byte, 1)
+	go func() {
+		_, opcode, payload, err := client.readWireFrame()
+		if err == nil && opcode == wsOpPong {
+			pong <- payload
+		}
+	}()
+
+	message, err := server.ReadMessage()
+	if err != nil || string(message) != "fragment" {
+		t.Fatalf("Expected fragment, got %q (%v)", message, err)
+	}
+	select {
+	case payload := <-pong:
+		if string(payload) != "p" {
+			t.Errorf("Pong must echo the ping payload, got %q", payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Ping was not answered")
+	}
+}
+
+// maskedWireFrame builds a client WebSocket frame with a fixed mask (payload < 126 bytes)
+func maskedWireFrame(fin bool, opcode byte, payload []byte) []byte {
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame := append([]byte{first, 0x80 | byte(len(payload))}, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
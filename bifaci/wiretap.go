@@ -0,0 +1,125 @@
+package bifaci
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// wiretapPreview is how many payload bytes a wiretap line shows
+const wiretapPreview = 32
+
+// Wiretap copies the frames of one direction of a connection from r to w, byte for
+// byte, and writes a line describing each to logSink: its type, id, stream, seq, a
+// preview of its payload, and for a CHUNK whether its checksum verifies under one of
+// the SupportedChecksums. A frame that doesn't decode is logged as such and copied all
+// the same. Tap a connection with one Wiretap per direction; each line is a single
+// Write to logSink. Wiretap returns nil when r reaches EOF between frames.
+func Wiretap(r io.Reader, w io.Writer, logSink io.Writer) error {
+	reader := NewFrameReader(r)
+	limits := DefaultLimits()
+	limits.MaxFrame = MaxFrameHardLimit
+	reader.SetLimits(limits)
+
+	for {
+		encoded, err := reader.readCBORFrame()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("wiretap: failed to read frame: %w", err)
+		}
+
+		var line string
+		if frame, err := DecodeFrame(encoded); err != nil {
+			line = fmt.Sprintf("undecodable frame (%d bytes): %v", len(encoded), err)
+		} else {
+			line = describeWiretapFrame(frame)
+		}
+		if _, err := io.WriteString(logSink, line+"\n"); err != nil {
+			return fmt.Errorf("wiretap: failed to log frame: %w", err)
+		}
+
+		// Length prefix and frame in one Write, as FrameWriter sends them
+		out := make([]byte, 4+len(encoded))
+		binary.BigEndian.PutUint32(out, uint32(len(encoded)))
+		copy(out[4:], encoded)
+		if _, err := w.Write(out); err != nil {
+			return fmt.Errorf("wiretap: failed to forward frame: %w", err)
+		}
+	}
+}
+
+// describeWiretapFrame renders a frame as one wiretap line
+func describeWiretapFrame(frame *Frame) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-16s id=%s", frame.FrameType, frame.Id.ToString())
+	if frame.RoutingId != nil {
+		fmt.Fprintf(&b, " routing=%s", frame.RoutingId.ToString())
+	}
+	if frame.Cap != nil {
+		fmt.Fprintf(&b, " cap=%s", *frame.Cap)
+	}
+	if frame.StreamId != nil {
+		fmt.Fprintf(&b, " stream=%s", *frame.StreamId)
+	}
+	if frame.MediaUrn != nil {
+		fmt.Fprintf(&b, " media=%s", *frame.MediaUrn)
+	}
+	if frame.FrameType == FrameTypeChunk {
+		fmt.Fprintf(&b, " seq=%d", frame.Seq)
+		if frame.ChunkIndex != nil {
+			fmt.Fprintf(&b, " index=%d", *frame.ChunkIndex)
+		}
+	}
+	if frame.ChunkCount != nil {
+		fmt.Fprintf(&b, " chunks=%d", *frame.ChunkCount)
+	}
+	switch frame.FrameType {
+	case FrameTypeErr:
+		fmt.Fprintf(&b, " code=%s message=%q", frame.ErrorCode(), frame.ErrorMessage())
+	case FrameTypeLog:
+		fmt.Fprintf(&b, " level=%s message=%q", frame.LogLevel(), frame.LogMessage())
+	}
+	if frame.Payload != nil {
+		fmt.Fprintf(&b, " payload(%d)=%s", len(frame.Payload), previewPayload(frame.Payload))
+	}
+	if frame.FrameType == FrameTypeChunk {
+		b.WriteString(" checksum=" + chunkChecksumStatus(frame))
+	}
+	return b.String()
+}
+
+// previewPayload shows the start of a payload, quoted if it is text and in hex if not
+func previewPayload(payload []byte) string {
+	preview, more := payload, ""
+	if len(preview) > wiretapPreview {
+		preview, more = preview[:wiretapPreview], "..."
+	}
+	// Text cut mid-rune is still text
+	text := preview
+	for i := 1; i < utf8.UTFMax && more != "" && !utf8.Valid(text); i++ {
+		text = text[:len(text)-1]
+	}
+	if len(text) > 0 && isPrintableText(text) {
+		return fmt.Sprintf("%q%s", text, more)
+	}
+	return fmt.Sprintf("%x%s", preview, more)
+}
+
+// chunkChecksumStatus says whether a CHUNK's checksum verifies, naming the algorithm it
+// verifies under; a tap doesn't know which one the connection negotiated
+func chunkChecksumStatus(frame *Frame) string {
+	if frame.Checksum == nil {
+		return "missing"
+	}
+	for _, algorithm := range SupportedChecksums() {
+		if VerifyChunkChecksumWith(frame, algorithm) == nil {
+			return "ok(" + string(algorithm.normalize()) + ")"
+		}
+	}
+	return "FAIL"
+}
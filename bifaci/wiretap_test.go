@@ -0,0 +1,59 @@
+package bifaci
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWiretap(t *testing.T) {
+	reqId := NewMessageIdFromUint(9)
+	corrupt := NewChunk(reqId, "s1", 1, []byte("tail"), 1, 42)
+	frames := []*Frame{
+		NewReq(reqId, `cap:in="media:void";op=test;out="media:void"`, []byte("hello"), "text/plain"),
+		NewStreamStart(reqId, "s1", "media:bytes"),
+		NewChunk(reqId, "s1", 0, []byte{0x00, 0xff}, 0, ComputeChecksum([]byte{0x00, 0xff})),
+		corrupt,
+		NewEnd(reqId, nil),
+	}
+	var in bytes.Buffer
+	if err := NewFrameWriter(&in).WriteFrames(frames...); err != nil {
+		t.Fatalf("WriteFrames failed: %v", err)
+	}
+	sent := append([]byte(nil), in.Bytes()...)
+
+	var out, log bytes.Buffer
+	if err := Wiretap(&in, &out, &log); err != nil {
+		t.Fatalf("Wiretap failed: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), sent) {
+		t.Error("Expected the frames forwarded unchanged")
+	}
+
+	lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+	if len(lines) != len(frames) {
+		t.Fatalf("Expected a line per frame, got %q", log.String())
+	}
+	expected := []string{
+		`payload(5)="hello"`,
+		"stream=s1 media=media:bytes",
+		"payload(2)=00ff checksum=ok(fnv1a64)",
+		"checksum=FAIL",
+		"END",
+	}
+	for i, want := range expected {
+		if !strings.Contains(lines[i], want) || !strings.Contains(lines[i], "id=9") {
+			t.Errorf("Line %d: expected %q, got %q", i, want, lines[i])
+		}
+	}
+}
+
+func TestPreviewPayload(t *testing.T) {
+	long := strings.Repeat("é", 20) // 40 bytes, cut mid-rune
+	if preview := previewPayload([]byte(long)); preview != `"`+strings.Repeat("é", 16)+`"...` {
+		t.Errorf("Unexpected text preview %s", preview)
+	}
+	if preview := previewPayload([]byte{0xff, 'a', 'b'}); preview != "ff6162" {
+		t.Errorf("Unexpected binary preview %s", preview)
+	}
+}
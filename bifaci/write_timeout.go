@@ -0,0 +1,137 @@
+package bifaci
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// ErrWriteStalled is returned by Run when writing a frame to the host made no progress
+// within the write timeout (see SetWriteTimeout)
+var ErrWriteStalled = errors.New("frame write to host stalled beyond the write timeout")
+
+// SetWriteTimeout makes the runtime shut down when writing a frame to the host takes
+// longer than timeout, e.g. because the host stopped reading: Run returns
+// ErrWriteStalled at once, running handlers are cancelled, and their further writes
+// fail. Transports with write deadlines (net.Conn, pipes) have the stalled write itself
+// aborted; on others it stays blocked until the process exits. Chunk writes held back
+// by flow control don't count, only writes to the transport. 0 (the default) waits
+// forever.
+func (pr *PluginRuntime) SetWriteTimeout(timeout time.Duration) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.writeTimeout = timeout
+}
+
+// writeDeadliner is a transport whose writes can be given a deadline
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// setWriteTimeout makes writes taking longer than timeout stall the writer. out is the
+// transport written to; its write deadline is used if it has one.
+func (s *syncFrameWriter) setWriteTimeout(timeout time.Duration, out interface{}) {
+	s.timeout = timeout
+	if deadliner, ok := out.(writeDeadliner); ok && timeout > 0 {
+		// Files that aren't pipes or sockets refuse deadlines
+		if err := deadliner.SetWriteDeadline(time.Time{}); err == nil {
+			s.deadline = deadliner.SetWriteDeadline
+		}
+	}
+}
+
+// timedWrite runs write, a write to the transport, under the write timeout. Called by
+// drain only.
+func (s *syncFrameWriter) timedWrite(write func() error) error {
+	if s.timeout <= 0 {
+		return write()
+	}
+	select {
+	case <-s.stalled:
+		return ErrWriteStalled
+	default:
+	}
+	if s.deadline != nil {
+		s.deadline(time.Now().Add(s.timeout))
+	}
+	s.writingSince.Store(time.Now().UnixNano())
+	err := write()
+	s.writingSince.Store(0)
+	if err != nil && isWriteTimeout(err) {
+		s.stall()
+		return fmt.Errorf("%w: %v", ErrWriteStalled, err)
+	}
+	return err
+}
+
+// stall fails the writer: queued and later writes return ErrWriteStalled
+func (s *syncFrameWriter) stall() {
+	s.stallOnce.Do(func() {
+		close(s.stalled)
+		s.mu.Lock()
+		s.space.Broadcast()
+		s.mu.Unlock()
+	})
+}
+
+// watchStalls stalls the writer once a write has been in progress for longer than the
+// write timeout, checking a few times per timeout until done is closed. It covers
+// transports without write deadlines.
+func (s *syncFrameWriter) watchStalls(clock Clock, done <-chan struct{}) {
+	interval := s.timeout / 4
+	timer := clock.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C():
+		case <-done:
+			return
+		}
+		if since := s.writingSince.Load(); since != 0 && time.Since(time.Unix(0, since)) > s.timeout {
+			s.stall()
+			return
+		}
+		timer.Reset(interval)
+	}
+}
+
+// isWriteTimeout reports whether err is a write that ran into its deadline
+func isWriteTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, os.ErrDeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// stallableRead wraps read so that it fails with ErrWriteStalled once stalled is
+// closed, even while a read is blocked. Frames are read by a goroutine that stops once
+// done is closed (see watchdogRead).
+func stallableRead(read func() (*Frame, error), stalled, done <-chan struct{}) func() (*Frame, error) {
+	type result struct {
+		frame *Frame
+		err   error
+	}
+	results := make(chan result)
+	go func() {
+		for {
+			frame, err := read()
+			select {
+			case results <- result{frame, err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return func() (*Frame, error) {
+		select {
+		case r := <-results:
+			return r.frame, r.err
+		case <-stalled:
+			return nil, ErrWriteStalled
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package bifaci
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// stallHost handshakes with a runtime serving on in/out, sends a request whose handler
+// writes without end, then stops reading, and returns what the runtime returned
+func stallHost(t *testing.T, serve func() error, reader *FrameReader, writer *FrameWriter) error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- serve() }()
+	if _, _, err := HandshakeInitiate(reader, writer); err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+	writeTestRequest(t, writer, NewMessageIdRandom(), testCancelCap, []byte{0x40})
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("Runtime did not give up on the stalled write")
+		return nil
+	}
+}
+
+func newStallingRuntime(t *testing.T) *PluginRuntime {
+	t.Helper()
+	runtime := newRoutingRuntime(t)
+	runtime.SetWriteTimeout(100 * time.Millisecond)
+	runtime.Register(testCancelCap, func(frames <-chan Frame, emitter StreamEmitter, peer PeerInvoker) error {
+		for {
+			if err := emitter.EmitCbor([]byte("more output nobody reads")); err != nil {
+				return err
+			}
+		}
+	})
+	return runtime
+}
+
+// Without write deadlines the watchdog notices the stalled write
+func TestWriteTimeoutWatchdog(t *testing.T) {
+	runtime := newStallingRuntime(t)
+	hostToPluginR, hostToPluginW := io.Pipe()
+	pluginToHostR, pluginToHostW := io.Pipe()
+	defer hostToPluginW.Close()
+	defer pluginToHostR.Close()
+
+	err := stallHost(t, func() error { return runtime.serveCBOR(hostToPluginR, pluginToHostW) },
+		NewFrameReader(pluginToHostR), NewFrameWriter(hostToPluginW))
+	if err != ErrWriteStalled {
+		t.Errorf("Expected ErrWriteStalled, got %v", err)
+	}
+}
+
+// On a connection the write deadline aborts the stalled write
+func TestWriteTimeoutDeadline(t *testing.T) {
+	runtime := newStallingRuntime(t)
+	hostConn, pluginConn := net.Pipe()
+	defer hostConn.Close()
+
+	err := stallHost(t, func() error { return runtime.RunConn(pluginConn) },
+		NewFrameReader(hostConn), NewFrameWriter(hostConn))
+	if err != ErrWriteStalled {
+		t.Errorf("Expected ErrWriteStalled, got %v", err)
+	}
+}
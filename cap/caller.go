@@ -2,11 +2,15 @@
 package cap
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"unicode/utf8"
 
+	"github.com/fxamacker/cbor/v2"
+
 	"github.com/machinefabric/capdag-go/media"
 	"github.com/machinefabric/capdag-go/urn"
 )
@@ -92,6 +96,63 @@ func NewCapArgumentValueFromStr(mediaUrn string, value string) CapArgumentValue
 	}
 }
 
+// NewCapArgumentValueJSON creates a CapArgumentValue holding the JSON encoding of v
+func NewCapArgumentValueJSON(mediaUrn string, v interface{}) (CapArgumentValue, error) {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return CapArgumentValue{}, fmt.Errorf("failed to encode %s argument as JSON: %w", mediaUrn, err)
+	}
+	return NewCapArgumentValue(mediaUrn, value), nil
+}
+
+// NewCapArgumentValueCBOR creates a CapArgumentValue holding the CBOR encoding of v
+func NewCapArgumentValueCBOR(mediaUrn string, v interface{}) (CapArgumentValue, error) {
+	value, err := cbor.Marshal(v)
+	if err != nil {
+		return CapArgumentValue{}, fmt.Errorf("failed to encode %s argument as CBOR: %w", mediaUrn, err)
+	}
+	return NewCapArgumentValue(mediaUrn, value), nil
+}
+
+// NewCapArgumentValueReader creates a CapArgumentValue from the contents of r. With a
+// size of 0 or more, exactly size bytes are read into a buffer allocated up front, and
+// a shorter r is an error; with a negative size, r is read to EOF.
+func NewCapArgumentValueReader(mediaUrn string, r io.Reader, size int64) (CapArgumentValue, error) {
+	if size < 0 {
+		value, err := io.ReadAll(r)
+		if err != nil {
+			return CapArgumentValue{}, fmt.Errorf("failed to read %s argument: %w", mediaUrn, err)
+		}
+		return NewCapArgumentValue(mediaUrn, value), nil
+	}
+	value := make([]byte, size)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return CapArgumentValue{}, fmt.Errorf("failed to read %d bytes of %s argument: %w", size, mediaUrn, err)
+	}
+	return NewCapArgumentValue(mediaUrn, value), nil
+}
+
+// DecodeJSON decodes the value as JSON into v
+func (a *CapArgumentValue) DecodeJSON(v interface{}) error {
+	if err := json.Unmarshal(a.Value, v); err != nil {
+		return fmt.Errorf("%s argument is not valid JSON: %w", a.MediaUrn, err)
+	}
+	return nil
+}
+
+// DecodeCBOR decodes the value as CBOR into v
+func (a *CapArgumentValue) DecodeCBOR(v interface{}) error {
+	if err := cbor.Unmarshal(a.Value, v); err != nil {
+		return fmt.Errorf("%s argument is not valid CBOR: %w", a.MediaUrn, err)
+	}
+	return nil
+}
+
+// Reader returns a reader of the value
+func (a *CapArgumentValue) Reader() io.Reader {
+	return bytes.NewReader(a.Value)
+}
+
 // ValueAsStr returns the value as a UTF-8 string. Returns error for non-UTF-8 data.
 func (a *CapArgumentValue) ValueAsStr() (string, error) {
 	if !utf8.Valid(a.Value) {
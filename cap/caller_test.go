@@ -3,6 +3,8 @@ package cap
 import (
 	"context"
 	"fmt"
+	"io"
+	"strings"
 	"testing"
 
 	"github.com/machinefabric/capdag-go/media"
@@ -443,3 +445,45 @@ func Test283_cap_argument_value_large_binary(t *testing.T) {
 	assert.Equal(t, 10000, len(arg.Value))
 	assert.Equal(t, data, arg.Value)
 }
+
+func TestCapArgumentValueStructuredBuilders(t *testing.T) {
+	type spec struct {
+		Name  string `json:"name" cbor:"name"`
+		Pages int    `json:"pages" cbor:"pages"`
+	}
+	in := spec{Name: "report", Pages: 3}
+
+	jsonArg, err := NewCapArgumentValueJSON("media:json", in)
+	require.NoError(t, err)
+	assert.Equal(t, "media:json", jsonArg.MediaUrn)
+	assert.JSONEq(t, `{"name":"report","pages":3}`, string(jsonArg.Value))
+	var fromJSON spec
+	require.NoError(t, jsonArg.DecodeJSON(&fromJSON))
+	assert.Equal(t, in, fromJSON)
+
+	cborArg, err := NewCapArgumentValueCBOR("media:cbor", in)
+	require.NoError(t, err)
+	var fromCBOR spec
+	require.NoError(t, cborArg.DecodeCBOR(&fromCBOR))
+	assert.Equal(t, in, fromCBOR)
+	assert.Error(t, jsonArg.DecodeCBOR(&fromCBOR), "JSON text is not a CBOR document")
+
+	_, err = NewCapArgumentValueJSON("media:json", make(chan int))
+	assert.Error(t, err)
+}
+
+func TestCapArgumentValueReader(t *testing.T) {
+	arg, err := NewCapArgumentValueReader("media:textable", strings.NewReader("hello world"), 5)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(arg.Value))
+
+	arg, err = NewCapArgumentValueReader("media:textable", strings.NewReader("hello world"), -1)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(arg.Value))
+	data, err := io.ReadAll(arg.Reader())
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+
+	_, err = NewCapArgumentValueReader("media:textable", strings.NewReader("short"), 10)
+	assert.Error(t, err, "a reader shorter than size must fail")
+}
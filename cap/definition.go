@@ -14,6 +14,8 @@ type ArgSource struct {
 	Stdin    *string `json:"stdin,omitempty"`
 	Position *int    `json:"position,omitempty"`
 	CliFlag  *string `json:"cli_flag,omitempty"`
+	Env      *string `json:"env,omitempty"`    // Environment variable, or the host's env map in CBOR mode
+	Config   *string `json:"config,omitempty"` // Dotted key in the plugin's config file, CLI mode only
 }
 
 // GetType returns the type of this source
@@ -27,6 +29,12 @@ func (s *ArgSource) GetType() string {
 	if s.CliFlag != nil {
 		return "cli_flag"
 	}
+	if s.Env != nil {
+		return "env"
+	}
+	if s.Config != nil {
+		return "config"
+	}
 	return ""
 }
 
@@ -45,6 +53,16 @@ func (s *ArgSource) IsCliFlag() bool {
 	return s.CliFlag != nil
 }
 
+// IsEnv returns true if this is an env source
+func (s *ArgSource) IsEnv() bool {
+	return s.Env != nil
+}
+
+// IsConfig returns true if this is a config source
+func (s *ArgSource) IsConfig() bool {
+	return s.Config != nil
+}
+
 // StdinMediaUrn returns the stdin media URN if this is a stdin source
 // Matches Rust: pub fn stdin_media_urn(&self) -> Option<&str>
 func (s *ArgSource) StdinMediaUrn() *string {
@@ -65,6 +83,18 @@ func (s *ArgSource) GetCliFlag() *string {
 	return s.CliFlag
 }
 
+// GetEnv returns the environment variable name if this is an env source
+// Named GetEnv to avoid conflict with Env field
+func (s *ArgSource) GetEnv() *string {
+	return s.Env
+}
+
+// GetConfig returns the config file key if this is a config source
+// Named GetConfig to avoid conflict with Config field
+func (s *ArgSource) GetConfig() *string {
+	return s.Config
+}
+
 // CapArg represents an argument definition with sources
 type CapArg struct {
 	MediaUrn       string      `json:"media_urn"`
@@ -154,6 +184,46 @@ func (a *CapArg) GetCliFlag() *string {
 	return nil
 }
 
+// HasEnvSource checks if this argument has an env source
+func (a *CapArg) HasEnvSource() bool {
+	for _, s := range a.Sources {
+		if s.IsEnv() {
+			return true
+		}
+	}
+	return false
+}
+
+// GetEnv returns the environment variable name if present
+func (a *CapArg) GetEnv() *string {
+	for _, s := range a.Sources {
+		if s.Env != nil {
+			return s.Env
+		}
+	}
+	return nil
+}
+
+// HasConfigSource checks if this argument has a config source
+func (a *CapArg) HasConfigSource() bool {
+	for _, s := range a.Sources {
+		if s.IsConfig() {
+			return true
+		}
+	}
+	return false
+}
+
+// GetConfig returns the config file key if present
+func (a *CapArg) GetConfig() *string {
+	for _, s := range a.Sources {
+		if s.Config != nil {
+			return s.Config
+		}
+	}
+	return nil
+}
+
 // Resolve resolves the argument's media URN to a media.ResolvedMediaSpec
 func (a *CapArg) Resolve(mediaSpecs []media.MediaSpecDef, registry *media.MediaUrnRegistry) (*media.ResolvedMediaSpec, error) {
 	return media.ResolveMediaUrn(a.MediaUrn, mediaSpecs, registry)
@@ -251,6 +321,14 @@ type RegisteredBy struct {
 	RegisteredAt string `json:"registered_at"`
 }
 
+// ResourcePolicy bounds what one request to a cap may consume. The plugin runtime
+// enforces it, failing the request with RESOURCE_EXHAUSTED; a zero limit is unbounded.
+type ResourcePolicy struct {
+	MaxPayloadBytes int64 `json:"max_payload_bytes,omitempty"` // Input CHUNK payload bytes
+	MaxWallTimeMs   int64 `json:"max_wall_time_ms,omitempty"`  // Handler wall time in milliseconds
+	MaxOutputBytes  int64 `json:"max_output_bytes,omitempty"`  // Output CHUNK payload bytes
+}
+
 // NewRegisteredBy creates a new registration attribution
 func NewRegisteredBy(username string, registeredAt string) RegisteredBy {
 	return RegisteredBy{
@@ -301,6 +379,12 @@ type Cap struct {
 	Output         *CapOutput           `json:"output,omitempty"`
 	MetadataJSON   any                  `json:"metadata_json,omitempty"`
 	RegisteredBy   *RegisteredBy        `json:"registered_by,omitempty"`
+	Deprecated     bool                 `json:"deprecated,omitempty"`   // Still served, but callers should migrate
+	ReplacedBy     string               `json:"replaced_by,omitempty"`  // URN of the cap to use instead of a deprecated one
+	Since          string               `json:"since,omitempty"`        // Plugin version the cap was introduced in
+	Resources      *ResourcePolicy      `json:"resources,omitempty"`    // Per-request limits, nil if unbounded
+	Cacheable      bool                 `json:"cacheable,omitempty"`    // Responses depend only on the arguments, so hosts may cache them
+	CacheTTLMs     int64                `json:"cache_ttl_ms,omitempty"` // How long a cached response stays valid, 0 = until evicted
 }
 
 // NewCap creates a new cap
@@ -634,9 +718,33 @@ func (c *Cap) Equals(other *Cap) bool {
 		return false
 	}
 
+	if c.Deprecated != other.Deprecated || c.ReplacedBy != other.ReplacedBy || c.Since != other.Since {
+		return false
+	}
+
+	if !reflect.DeepEqual(c.Resources, other.Resources) {
+		return false
+	}
+
+	if c.Cacheable != other.Cacheable || c.CacheTTLMs != other.CacheTTLMs {
+		return false
+	}
+
 	return true
 }
 
+// DeprecationNotice describes a deprecated cap for users, naming its replacement if it
+// has one. It is empty if the cap isn't deprecated.
+func (c *Cap) DeprecationNotice() string {
+	if !c.Deprecated {
+		return ""
+	}
+	if c.ReplacedBy != "" {
+		return fmt.Sprintf("cap %s is deprecated, use %s instead", c.UrnString(), c.ReplacedBy)
+	}
+	return fmt.Sprintf("cap %s is deprecated", c.UrnString())
+}
+
 // MarshalJSON implements custom JSON marshaling
 func (c *Cap) MarshalJSON() ([]byte, error) {
 	capData := map[string]any{
@@ -673,6 +781,30 @@ func (c *Cap) MarshalJSON() ([]byte, error) {
 		capData["registered_by"] = c.RegisteredBy
 	}
 
+	if c.Deprecated {
+		capData["deprecated"] = true
+	}
+
+	if c.ReplacedBy != "" {
+		capData["replaced_by"] = c.ReplacedBy
+	}
+
+	if c.Since != "" {
+		capData["since"] = c.Since
+	}
+
+	if c.Resources != nil {
+		capData["resources"] = c.Resources
+	}
+
+	if c.Cacheable {
+		capData["cacheable"] = true
+	}
+
+	if c.CacheTTLMs != 0 {
+		capData["cache_ttl_ms"] = c.CacheTTLMs
+	}
+
 	return json.Marshal(capData)
 }
 
@@ -770,5 +902,34 @@ func (c *Cap) UnmarshalJSON(data []byte) error {
 		c.RegisteredBy = &registeredBy
 	}
 
+	if deprecated, ok := raw["deprecated"].(bool); ok {
+		c.Deprecated = deprecated
+	}
+
+	if replacedBy, ok := raw["replaced_by"].(string); ok {
+		c.ReplacedBy = replacedBy
+	}
+
+	if since, ok := raw["since"].(string); ok {
+		c.Since = since
+	}
+
+	if resourcesRaw, ok := raw["resources"]; ok {
+		resourcesBytes, _ := json.Marshal(resourcesRaw)
+		var resources ResourcePolicy
+		if err := json.Unmarshal(resourcesBytes, &resources); err != nil {
+			return fmt.Errorf("failed to unmarshal resources: %w", err)
+		}
+		c.Resources = &resources
+	}
+
+	if cacheable, ok := raw["cacheable"].(bool); ok {
+		c.Cacheable = cacheable
+	}
+
+	if ttl, ok := raw["cache_ttl_ms"].(float64); ok {
+		c.CacheTTLMs = int64(ttl)
+	}
+
 	return nil
 }
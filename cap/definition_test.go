@@ -146,7 +146,7 @@ func Test113_cap_stdin(t *testing.T) {
 	assert.Equal(t, "media:textable", *deserialized.GetStdinMediaUrn())
 }
 
-// TEST114: Test ArgSource type variants stdin, position, and cli_flag with their accessors
+// TEST114: Test ArgSource type variants stdin, position, cli_flag, env and config with their accessors
 func Test114_arg_source_types(t *testing.T) {
 	// Test stdin source
 	stdinUrn := "media:text"
@@ -174,6 +174,31 @@ func Test114_arg_source_types(t *testing.T) {
 	assert.Nil(t, cliFlagSource.GetPosition())
 	assert.NotNil(t, cliFlagSource.GetCliFlag())
 	assert.Equal(t, "--input", *cliFlagSource.GetCliFlag())
+	assert.Nil(t, cliFlagSource.GetEnv())
+
+	// Test env source
+	envVar := "OPENAI_API_KEY"
+	envSource := ArgSource{Env: &envVar}
+	assert.Equal(t, "env", envSource.GetType())
+	assert.True(t, envSource.IsEnv())
+	assert.Nil(t, envSource.GetCliFlag())
+	assert.Equal(t, "OPENAI_API_KEY", *envSource.GetEnv())
+
+	arg := CapArg{MediaUrn: "media:string", Sources: []ArgSource{cliFlagSource, envSource}}
+	assert.True(t, arg.HasEnvSource())
+	assert.Equal(t, "OPENAI_API_KEY", *arg.GetEnv())
+	serialized, err := json.Marshal(envSource)
+	require.NoError(t, err)
+	assert.Equal(t, `{"env":"OPENAI_API_KEY"}`, string(serialized))
+
+	// Test config source
+	configKey := "openai.model"
+	configSource := ArgSource{Config: &configKey}
+	assert.Equal(t, "config", configSource.GetType())
+	assert.True(t, configSource.IsConfig())
+	assert.Nil(t, configSource.GetEnv())
+	assert.Equal(t, "openai.model", *configSource.GetConfig())
+	assert.False(t, arg.HasConfigSource())
 }
 
 // TEST115: Test CapArg serialization and deserialization with multiple sources
@@ -341,3 +366,77 @@ func TestCapJSONRoundTrip(t *testing.T) {
 	assert.Equal(t, cap.GetArgs()[0].MediaUrn, deserialized.GetArgs()[0].MediaUrn)
 	assert.Equal(t, cap.Output.MediaUrn, deserialized.Output.MediaUrn)
 }
+
+func TestCapDeprecationRoundTrip(t *testing.T) {
+	id, err := urn.NewCapUrnFromString(capTestUrn("op=old"))
+	require.NoError(t, err)
+
+	cap := NewCap(id, "Old Cap", "old")
+	assert.Empty(t, cap.DeprecationNotice(), "a current cap has no deprecation notice")
+	jsonData, err := json.Marshal(cap)
+	require.NoError(t, err)
+	assert.NotContains(t, string(jsonData), "deprecated", "a current cap must not be marked")
+
+	cap.Deprecated = true
+	cap.ReplacedBy = capTestUrn("op=new")
+	cap.Since = "1.2.0"
+	jsonData, err = json.Marshal(cap)
+	require.NoError(t, err)
+
+	var deserialized Cap
+	require.NoError(t, json.Unmarshal(jsonData, &deserialized))
+	assert.True(t, deserialized.Deprecated)
+	assert.Equal(t, cap.ReplacedBy, deserialized.ReplacedBy)
+	assert.Equal(t, "1.2.0", deserialized.Since)
+
+	other := *cap
+	assert.True(t, cap.Equals(&other))
+	other.Since = "1.3.0"
+	assert.False(t, cap.Equals(&other), "caps deprecated since different versions must differ")
+	assert.Contains(t, cap.DeprecationNotice(), "use "+cap.ReplacedBy+" instead")
+}
+
+func TestCapResourcePolicyRoundTrip(t *testing.T) {
+	id, err := urn.NewCapUrnFromString(capTestUrn("op=render"))
+	require.NoError(t, err)
+
+	cap := NewCap(id, "Render", "render")
+	cap.Resources = &ResourcePolicy{MaxPayloadBytes: 1 << 20, MaxWallTimeMs: 5000}
+	jsonData, err := json.Marshal(cap)
+	require.NoError(t, err)
+	assert.Contains(t, string(jsonData), `"resources":{"max_payload_bytes":1048576,"max_wall_time_ms":5000}`)
+
+	var deserialized Cap
+	require.NoError(t, json.Unmarshal(jsonData, &deserialized))
+	require.NotNil(t, deserialized.Resources)
+	assert.Equal(t, *cap.Resources, *deserialized.Resources)
+
+	other := *cap
+	other.Resources = &ResourcePolicy{MaxPayloadBytes: 1 << 20, MaxWallTimeMs: 5000}
+	assert.True(t, cap.Equals(&other), "limits are compared by value")
+	other.Resources.MaxOutputBytes = 10
+	assert.False(t, cap.Equals(&other), "caps with different limits must differ")
+}
+
+func TestCapCacheableRoundTrip(t *testing.T) {
+	id, err := urn.NewCapUrnFromString(capTestUrn("op=hash"))
+	require.NoError(t, err)
+
+	cap := NewCap(id, "Hash", "hash")
+	cap.Cacheable = true
+	cap.CacheTTLMs = 60000
+	jsonData, err := json.Marshal(cap)
+	require.NoError(t, err)
+	assert.Contains(t, string(jsonData), `"cacheable":true`)
+	assert.Contains(t, string(jsonData), `"cache_ttl_ms":60000`)
+
+	var deserialized Cap
+	require.NoError(t, json.Unmarshal(jsonData, &deserialized))
+	assert.True(t, deserialized.Cacheable)
+	assert.Equal(t, int64(60000), deserialized.CacheTTLMs)
+
+	other := *cap
+	assert.True(t, cap.Equals(&other))
+	other.CacheTTLMs = 0
+	assert.False(t, cap.Equals(&other), "caps with different cache TTLs must differ")
+}
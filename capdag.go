@@ -45,8 +45,16 @@ type FrameWriter = bifaci.FrameWriter
 type PluginRuntime = bifaci.PluginRuntime
 type StreamEmitter = bifaci.StreamEmitter
 type PeerInvoker = bifaci.PeerInvoker
+type PeerResponse = bifaci.PeerResponse
 type HandlerFunc = bifaci.HandlerFunc
 type CapManifest = bifaci.CapManifest
+type Logger = bifaci.Logger
+type Tracer = bifaci.Tracer
+type Span = bifaci.Span
+type TraceContext = bifaci.TraceContext
+type MetricsCollector = bifaci.MetricsCollector
+type Middleware = bifaci.Middleware
+type Diagnostic = bifaci.Diagnostic
 
 var NewMessageIdFromUuid = bifaci.NewMessageIdFromUuid
 var NewMessageIdFromUint = bifaci.NewMessageIdFromUint
@@ -55,6 +63,7 @@ var NewFrameReader = bifaci.NewFrameReader
 var NewFrameWriter = bifaci.NewFrameWriter
 var NewPluginRuntime = bifaci.NewPluginRuntime
 var NewCapManifest = bifaci.NewCapManifest
+var ValidateManifest = bifaci.ValidateManifest
 
 // Standard caps (constants)
 const CapIdentity = standard.CapIdentity
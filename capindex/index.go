@@ -0,0 +1,131 @@
+// Package capindex indexes the caps of plugin manifests and selects them by cap URN,
+// with the matching the plugin runtime routes requests with, so hosts don't reimplement
+// it.
+package capindex
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/machinefabric/capdag-go/bifaci"
+	"github.com/machinefabric/capdag-go/cap"
+	"github.com/machinefabric/capdag-go/urn"
+)
+
+// Entry is an indexed cap and the manifest declaring it
+type Entry struct {
+	Cap      *cap.Cap
+	Manifest *bifaci.CapManifest
+}
+
+// Index is an index of the caps of manifests, safe for concurrent use
+type Index struct {
+	mu      sync.RWMutex
+	entries []Entry // In the order the manifests were added
+}
+
+// New returns an index of the caps of manifests
+func New(manifests ...*bifaci.CapManifest) *Index {
+	index := &Index{}
+	for _, manifest := range manifests {
+		index.Add(manifest)
+	}
+	return index
+}
+
+// Add indexes the caps of a manifest. Caps without a URN are left out.
+func (ix *Index) Add(manifest *bifaci.CapManifest) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for i := range manifest.Caps {
+		if manifest.Caps[i].Urn != nil {
+			ix.entries = append(ix.entries, Entry{Cap: &manifest.Caps[i], Manifest: manifest})
+		}
+	}
+}
+
+// Entries returns every indexed cap, in the order the manifests were added
+func (ix *Index) Entries() []Entry {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return append([]Entry(nil), ix.entries...)
+}
+
+// FindCaps returns the caps a request for pattern would be routed to, closest in
+// specificity to pattern first (see BestMatch)
+func (ix *Index) FindCaps(pattern string) ([]Entry, error) {
+	request, err := urn.NewCapUrnFromString(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cap URN pattern %q: %w", pattern, err)
+	}
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	type match struct {
+		entry    Entry
+		distance int
+	}
+	var matches []match
+	for _, entry := range ix.entries {
+		if distance, ok := routingDistance(request, entry.Cap.Urn); ok {
+			matches = append(matches, match{entry, distance})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].distance < matches[j].distance
+	})
+	found := make([]Entry, len(matches))
+	for i, m := range matches {
+		found[i] = m.entry
+	}
+	return found, nil
+}
+
+// BestMatch returns the cap a request is routed to, as PluginRuntime.FindHandler picks
+// a handler: of the caps the request accepts, the one whose specificity is closest to
+// the request's; ties go to the cap indexed first
+func (ix *Index) BestMatch(request *urn.CapUrn) (Entry, bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	var best Entry
+	bestDistance := -1
+	for _, entry := range ix.entries {
+		distance, ok := routingDistance(request, entry.Cap.Urn)
+		if ok && (bestDistance < 0 || distance < bestDistance) {
+			best, bestDistance = entry, distance
+		}
+	}
+	return best, bestDistance >= 0
+}
+
+// CapsByTag returns the caps whose URN has the tag with the value, any value if it is
+// "*", in the order they were indexed
+func (ix *Index) CapsByTag(key, value string) []Entry {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	var found []Entry
+	for _, entry := range ix.entries {
+		tagValue, ok := entry.Cap.Urn.GetTag(key)
+		if ok && (value == "*" || tagValue == value) {
+			found = append(found, entry)
+		}
+	}
+	return found
+}
+
+// routingDistance reports whether request is routed to registered, and how far their
+// specificities are apart
+func routingDistance(request, registered *urn.CapUrn) (int, bool) {
+	// Routing direction: request.Accepts(registered_cap)
+	if !request.Accepts(registered) {
+		return 0, false
+	}
+	distance := registered.Specificity() - request.Specificity()
+	if distance < 0 {
+		distance = -distance
+	}
+	return distance, true
+}
@@ -0,0 +1,75 @@
+package capindex
+
+import (
+	"testing"
+
+	"github.com/machinefabric/capdag-go/bifaci"
+	"github.com/machinefabric/capdag-go/cap"
+	"github.com/machinefabric/capdag-go/urn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testManifest(t *testing.T, name string, capUrns ...string) *bifaci.CapManifest {
+	t.Helper()
+	var caps []cap.Cap
+	for _, capUrn := range capUrns {
+		parsed, err := urn.NewCapUrnFromString(capUrn)
+		require.NoError(t, err)
+		caps = append(caps, *cap.NewCap(parsed, capUrn, "cmd"))
+	}
+	return bifaci.NewCapManifest(name, "1.0", "", caps)
+}
+
+func testIndex(t *testing.T) *Index {
+	return New(
+		testManifest(t, "Imaging",
+			`cap:in="media:image;png";op=thumbnail;out="media:image;png";type=imaging`,
+			`cap:in="media:image;png";op=thumbnail;out="media:image;png";size=large;type=imaging`),
+		testManifest(t, "Docs",
+			`cap:in="media:pdf";op=extract;out="media:textable";type=document`),
+	)
+}
+
+func TestFindCaps(t *testing.T) {
+	index := testIndex(t)
+
+	found, err := index.FindCaps("cap:op=thumbnail")
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+	assert.Equal(t, "Imaging", found[0].Manifest.Name)
+
+	found, err = index.FindCaps(`cap:in="media:image;png";op=thumbnail;out="media:image;png";size=large;type=imaging`)
+	require.NoError(t, err)
+	require.Len(t, found, 1, "a more specific request must not reach the less specific cap")
+
+	_, err = index.FindCaps("not a urn")
+	assert.Error(t, err)
+}
+
+// BestMatch picks the cap closest in specificity, as the runtime routes
+func TestBestMatch(t *testing.T) {
+	index := testIndex(t)
+
+	request, err := urn.NewCapUrnFromString(`cap:in="media:image;png";op=thumbnail;out="media:image;png";type=imaging`)
+	require.NoError(t, err)
+	best, ok := index.BestMatch(request)
+	require.True(t, ok)
+	_, hasSize := best.Cap.Urn.GetTag("size")
+	assert.False(t, hasSize, "the exact cap is closer than the more specific one")
+
+	request, err = urn.NewCapUrnFromString("cap:op=summarize")
+	require.NoError(t, err)
+	_, ok = index.BestMatch(request)
+	assert.False(t, ok)
+}
+
+func TestCapsByTag(t *testing.T) {
+	index := testIndex(t)
+
+	assert.Len(t, index.CapsByTag("type", "imaging"), 2)
+	assert.Len(t, index.CapsByTag("type", "*"), 3)
+	assert.Empty(t, index.CapsByTag("type", "audio"))
+	assert.Len(t, index.CapsByTag("size", "*"), 1)
+	assert.Len(t, index.Entries(), 3)
+}
@@ -0,0 +1,60 @@
+// Command capns-gen generates a typed Go client for the caps of a plugin manifest
+// (see bifaci.GenerateClient). It is meant to run from go:generate:
+//
+//	//go:generate go run github.com/machinefabric/capdag-go/cmd/capns-gen -manifest manifest.yaml -package models -out client_gen.go
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/machinefabric/capdag-go/bifaci"
+)
+
+func main() {
+	manifestPath := flag.String("manifest", "", "manifest file (JSON or YAML)")
+	packageName := flag.String("package", os.Getenv("GOPACKAGE"), "package of the generated file")
+	typeName := flag.String("type", "", "client type (default: the manifest name followed by Client)")
+	outPath := flag.String("out", "", "output file (default: stdout)")
+	flag.Parse()
+
+	if err := run(*manifestPath, *packageName, *typeName, *outPath); err != nil {
+		fmt.Fprintf(os.Stderr, "capns-gen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(manifestPath, packageName, typeName, outPath string) error {
+	if manifestPath == "" {
+		return fmt.Errorf("-manifest is required")
+	}
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return err
+	}
+	manifestJSON, err := bifaci.ManifestJSON(manifestPath, data)
+	if err != nil {
+		return err
+	}
+	var manifest bifaci.CapManifest
+	if err := json.Unmarshal(manifestJSON, &manifest); err != nil {
+		return fmt.Errorf("invalid manifest %s: %w", manifestPath, err)
+	}
+
+	source, err := bifaci.GenerateClient(&manifest, bifaci.ClientOptions{
+		Package:  packageName,
+		TypeName: typeName,
+		Command:  "capns-gen " + strings.Join(os.Args[1:], " "),
+	})
+	if err != nil {
+		return err
+	}
+	if outPath == "" {
+		_, err = os.Stdout.Write(source)
+		return err
+	}
+	return os.WriteFile(outPath, source, 0o644)
+}
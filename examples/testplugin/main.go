@@ -72,11 +72,8 @@ func main() {
 			return nil
 		})
 
-	// Run runtime (auto-detects CLI vs CBOR mode)
-	if err := runtime.Run(); err != nil {
-		fmt.Fprintf(os.Stderr, "Runtime error: %v\n", err)
-		os.Exit(1)
-	}
+	// Run runtime (auto-detects CLI vs CBOR mode) and exit with its exit code
+	runtime.RunWithExit()
 }
 
 func mustParseCapUrn(urnStr string) *capdag.CapUrn {
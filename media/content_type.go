@@ -0,0 +1,177 @@
+package media
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/machinefabric/capdag-go/urn"
+)
+
+// mediaTypeUrns maps MIME types to the media URNs of their values
+var mediaTypeUrns = map[string]string{
+	"application/pdf":          MediaPdf,
+	"application/epub+zip":     MediaEpub,
+	"application/json":         MediaJson,
+	"application/schema+json":  MediaJsonSchema,
+	"application/yaml":         MediaYaml,
+	"application/x-yaml":       MediaYaml,
+	"text/yaml":                MediaYaml,
+	"application/xml":          MediaXml,
+	"text/xml":                 MediaXml,
+	"text/html":                MediaHtml,
+	"text/markdown":            MediaMd,
+	"text/x-rst":               MediaRst,
+	"text/plain":               MediaTxt,
+	"text/csv":                 "media:csv;textable",
+	"image/png":                MediaImage,
+	"image/jpeg":               "media:image;jpeg",
+	"image/gif":                "media:gif;image",
+	"image/webp":               "media:image;webp",
+	"audio/wav":                MediaAudio,
+	"audio/x-wav":              MediaAudio,
+	"audio/wave":               MediaAudio,
+	"audio/mpeg":               "media:audio;mp3",
+	"video/mp4":                "media:mp4;video",
+	"application/zip":          "media:zip",
+	"application/gzip":         "media:gzip",
+	"application/octet-stream": MediaBinary,
+}
+
+// mediaTypeAliases are the MIME types of mediaTypeUrns ToMediaType doesn't return, as
+// another names the same values
+var mediaTypeAliases = map[string]bool{
+	"application/x-yaml": true,
+	"text/yaml":          true,
+	"text/xml":           true,
+	"audio/x-wav":        true,
+	"audio/wave":         true,
+}
+
+// FromMediaType returns the media URN of values of a MIME type, e.g. media:pdf for
+// "application/pdf", so a host that only has a Content-Type can build a
+// CapArgumentValue. Parameters such as charset are ignored. A "+json" or "+xml" type
+// unknown otherwise is JSON or XML, and any other unknown text/* type is text.
+func FromMediaType(mediaType string) (*urn.MediaUrn, error) {
+	base, _, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid media type '%s': %w", mediaType, err)
+	}
+	mediaUrn, ok := mediaTypeUrns[base]
+	if !ok {
+		switch {
+		case strings.HasSuffix(base, "+json"):
+			mediaUrn = MediaJson
+		case strings.HasSuffix(base, "+xml"):
+			mediaUrn = MediaXml
+		case strings.HasPrefix(base, "text/"):
+			mediaUrn = MediaString
+		default:
+			return nil, fmt.Errorf("no media URN is known for media type '%s'", base)
+		}
+	}
+	return urn.NewMediaUrnFromString(mediaUrn)
+}
+
+// ToMediaType returns the MIME type of values of a media URN: that of the most specific
+// known media URN it conforms to, else text/plain for a textable one and
+// application/octet-stream for bytes
+func ToMediaType(mediaUrn *urn.MediaUrn) string {
+	best, bestSpecificity := "", -1
+	for mediaType, known := range mediaTypeUrns {
+		if mediaTypeAliases[mediaType] {
+			continue
+		}
+		knownUrn, err := urn.NewMediaUrnFromString(known)
+		if err != nil || !knownUrn.Accepts(mediaUrn) {
+			continue
+		}
+		// Ties go to the first MIME type in order, so the result is stable
+		specificity := knownUrn.Specificity()
+		if specificity > bestSpecificity || (specificity == bestSpecificity && mediaType < best) {
+			best, bestSpecificity = mediaType, specificity
+		}
+	}
+	switch {
+	case best != "" && best != "application/octet-stream":
+		return best
+	case mediaUrn.IsTextable():
+		return "text/plain"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// magicNumbers are the leading bytes identifying binary formats, checked in order
+var magicNumbers = []struct {
+	offset    int
+	magic     string
+	mediaType string
+}{
+	{0, "%PDF-", "application/pdf"},
+	{0, "\x89PNG\r\n\x1a\n", "image/png"},
+	{0, "\xff\xd8\xff", "image/jpeg"},
+	{0, "GIF87a", "image/gif"},
+	{0, "GIF89a", "image/gif"},
+	{8, "WEBP", "image/webp"},
+	{8, "WAVE", "audio/wav"},
+	{0, "ID3", "audio/mpeg"},
+	{4, "ftyp", "video/mp4"},
+	{30, "mimetypeapplication/epub+zip", "application/epub+zip"},
+	{0, "PK\x03\x04", "application/zip"},
+	{0, "\x1f\x8b", "application/gzip"},
+}
+
+// Sniff returns the media URN of data from its leading bytes: PDF, PNG, JPEG, GIF, WebP,
+// WAV, MP3, MP4, EPUB, ZIP and gzip are recognized by their magic numbers, and UTF-8
+// text is JSON, XML, HTML or plain text. Other data is bytes (MediaBinary).
+func Sniff(data []byte) (*urn.MediaUrn, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("no data to sniff")
+	}
+	return FromMediaType(sniffMediaType(data))
+}
+
+func sniffMediaType(data []byte) string {
+	for _, m := range magicNumbers {
+		if len(data) >= m.offset+len(m.magic) && string(data[m.offset:m.offset+len(m.magic)]) == m.magic {
+			// WebP and WAV are both RIFF containers
+			if m.offset == 8 && !bytes.HasPrefix(data, []byte("RIFF")) {
+				continue
+			}
+			return m.mediaType
+		}
+	}
+	// MP3 frames without an ID3 tag start with a frame sync
+	if len(data) >= 2 && data[0] == 0xff && data[1]&0xe0 == 0xe0 {
+		return "audio/mpeg"
+	}
+
+	// A leading part of a text may end in the middle of a character
+	valid := data
+	for cut := 0; cut < utf8.UTFMax-1 && len(valid) > 1 && !utf8.Valid(valid); cut++ {
+		valid = valid[:len(valid)-1]
+	}
+	if !utf8.Valid(valid) || bytes.IndexByte(data, 0) >= 0 {
+		return "application/octet-stream"
+	}
+	text := bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	head := text
+	if len(head) > 512 {
+		head = head[:512]
+	}
+	lower := bytes.ToLower(head)
+	switch {
+	case len(text) > 0 && (text[0] == '{' || text[0] == '[') && json.Valid(text):
+		return "application/json"
+	case bytes.HasPrefix(lower, []byte("<!doctype html")) || bytes.HasPrefix(lower, []byte("<html")):
+		return "text/html"
+	case bytes.HasPrefix(lower, []byte("<?xml")):
+		return "application/xml"
+	default:
+		return "text/plain"
+	}
+}
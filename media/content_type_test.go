@@ -0,0 +1,84 @@
+package media
+
+import (
+	"testing"
+
+	"github.com/machinefabric/capdag-go/urn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromMediaType(t *testing.T) {
+	cases := map[string]string{
+		"application/pdf":          MediaPdf,
+		"text/html; charset=utf-8": MediaHtml,
+		"Application/JSON":         MediaJson,
+		"application/ld+json":      MediaJson,
+		"image/svg+xml":            MediaXml,
+		"text/x-unknown":           MediaString,
+		"application/octet-stream": MediaBinary,
+		"audio/x-wav":              MediaAudio,
+	}
+	for mediaType, expected := range cases {
+		mediaUrn, err := FromMediaType(mediaType)
+		require.NoError(t, err, mediaType)
+		expectedUrn, err := urn.NewMediaUrnFromString(expected)
+		require.NoError(t, err)
+		assert.True(t, mediaUrn.Equals(expectedUrn), "%s: expected %s, got %s", mediaType, expected, mediaUrn)
+	}
+
+	_, err := FromMediaType("application/x-unknown")
+	assert.Error(t, err)
+	_, err = FromMediaType("not a media type")
+	assert.Error(t, err)
+}
+
+func TestToMediaType(t *testing.T) {
+	cases := map[string]string{
+		MediaPdf:                    "application/pdf",
+		MediaYaml:                   "application/yaml",
+		MediaAudio:                  "audio/wav",
+		MediaImageThumbnail:         "image/png",
+		"media:textable":            "text/plain",
+		"media:model-spec;textable": "text/plain",
+		"media:unknown":             "application/octet-stream",
+	}
+	for mediaUrn, expected := range cases {
+		parsed, err := urn.NewMediaUrnFromString(mediaUrn)
+		require.NoError(t, err)
+		assert.Equal(t, expected, ToMediaType(parsed), mediaUrn)
+	}
+}
+
+func TestSniff(t *testing.T) {
+	cases := []struct {
+		name     string
+		data     string
+		expected string
+	}{
+		{"pdf", "%PDF-1.7\n", MediaPdf},
+		{"png", "\x89PNG\r\n\x1a\n\x00\x00", MediaImage},
+		{"jpeg", "\xff\xd8\xff\xe0", "media:image;jpeg"},
+		{"wav", "RIFF\x24\x00\x00\x00WAVEfmt ", MediaAudio},
+		{"webp", "RIFF\x24\x00\x00\x00WEBPVP8 ", "media:image;webp"},
+		{"mp4", "\x00\x00\x00\x18ftypmp42", "media:mp4;video"},
+		{"epub", "PK\x03\x04" + string(make([]byte, 26)) + "mimetypeapplication/epub+zip", MediaEpub},
+		{"zip", "PK\x03\x04\x14\x00", "media:zip"},
+		{"json", " {\"a\": [1, 2]}\n", MediaJson},
+		{"html", "<!DOCTYPE html><html></html>", MediaHtml},
+		{"xml", "<?xml version=\"1.0\"?><a/>", MediaXml},
+		{"text", "hello, w\xc3\xb6rld", MediaTxt},
+		{"truncated text", "w\xc3\xb6rld \xc3", MediaTxt},
+		{"binary", "\x00\x01\x02\x03", MediaBinary},
+	}
+	for _, c := range cases {
+		mediaUrn, err := Sniff([]byte(c.data))
+		require.NoError(t, err, c.name)
+		expected, err := urn.NewMediaUrnFromString(c.expected)
+		require.NoError(t, err)
+		assert.True(t, mediaUrn.Equals(expected), "%s: expected %s, got %s", c.name, c.expected, mediaUrn)
+	}
+
+	_, err := Sniff(nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,259 @@
+package media
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+
+	"github.com/machinefabric/capdag-go/urn"
+)
+
+// MediaFamily is a custom family of media URNs registered at run time: the media URNs
+// its URN accepts, e.g. "media:model-spec" for "media:model-spec;provider=openai". Members
+// without a spec of their own resolve to the family's defaults, and the values of their
+// tags are checked against its rules.
+type MediaFamily struct {
+	Urn        string             `json:"urn"`
+	Textable   bool               `json:"textable"`   // Members are text, whether or not they are tagged textable
+	MediaType  string             `json:"media_type"` // Default MIME type of members
+	ProfileURI string             `json:"profile_uri,omitempty"`
+	Title      string             `json:"title,omitempty"`
+	Extensions []string           `json:"extensions,omitempty"`
+	Tags       map[string]TagRule `json:"tags,omitempty"` // Rules for the tags of members, by tag name
+}
+
+// TagRule constrains a tag of the members of a media family
+type TagRule struct {
+	Required bool     `json:"required,omitempty"` // Members must have the tag
+	Values   []string `json:"values,omitempty"`   // Values the tag may have (none = any)
+	Pattern  string   `json:"pattern,omitempty"`  // Regular expression the whole value must match
+}
+
+// registeredFamily is a media family with its URN parsed and tag patterns compiled
+type registeredFamily struct {
+	def      MediaFamily
+	urn      *urn.MediaUrn
+	patterns map[string]*regexp.Regexp
+}
+
+// RegistryDefinitions are the media specs and families added to a registry, in the
+// JSON form SaveDefinitions writes and LoadDefinitions reads
+type RegistryDefinitions struct {
+	Specs    []StoredMediaSpec `json:"specs,omitempty"`
+	Families []MediaFamily     `json:"families,omitempty"`
+}
+
+// RegisterFamily registers a media family, replacing one with the same URN. It fails if
+// the URN doesn't parse, the family has no media type, or a tag pattern doesn't compile.
+func (r *MediaUrnRegistry) RegisterFamily(family MediaFamily) error {
+	registered, err := newRegisteredFamily(family)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families[registered.urn.String()] = registered
+	return nil
+}
+
+func newRegisteredFamily(family MediaFamily) (*registeredFamily, error) {
+	familyUrn, err := urn.NewMediaUrnFromString(family.Urn)
+	if err != nil {
+		return nil, &MediaRegistryError{Message: fmt.Sprintf("invalid media family URN '%s': %v", family.Urn, err)}
+	}
+	if family.MediaType == "" {
+		return nil, &MediaRegistryError{Message: fmt.Sprintf("media family '%s' has no media type", family.Urn)}
+	}
+	// Members all carry the family's tags
+	family.Textable = family.Textable || familyUrn.IsTextable()
+	patterns := make(map[string]*regexp.Regexp)
+	for tag, rule := range family.Tags {
+		if rule.Pattern == "" {
+			continue
+		}
+		pattern, err := regexp.Compile("^(?:" + rule.Pattern + ")$")
+		if err != nil {
+			return nil, &MediaRegistryError{Message: fmt.Sprintf("media family '%s': invalid pattern for tag '%s': %v", family.Urn, tag, err)}
+		}
+		patterns[tag] = pattern
+	}
+	return &registeredFamily{def: family, urn: familyUrn, patterns: patterns}, nil
+}
+
+// Family returns the most specific registered family the media URN is a member of
+func (r *MediaUrnRegistry) Family(mediaUrn string) (*MediaFamily, bool) {
+	parsed, err := urn.NewMediaUrnFromString(mediaUrn)
+	if err != nil {
+		return nil, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	family := r.familyOf(parsed)
+	if family == nil {
+		return nil, false
+	}
+	def := family.def
+	return &def, true
+}
+
+// familyOf returns the most specific family accepting mediaUrn, nil if none (caller
+// holds mu)
+func (r *MediaUrnRegistry) familyOf(mediaUrn *urn.MediaUrn) *registeredFamily {
+	var best *registeredFamily
+	for _, family := range r.families {
+		if !family.urn.Accepts(mediaUrn) {
+			continue
+		}
+		if best == nil || family.urn.Specificity() > best.urn.Specificity() ||
+			(family.urn.Specificity() == best.urn.Specificity() && family.urn.String() < best.urn.String()) {
+			best = family
+		}
+	}
+	return best
+}
+
+// familyOfString is familyOf for a media URN string, nil if it doesn't parse (caller
+// holds mu)
+func (r *MediaUrnRegistry) familyOfString(mediaUrn string) *registeredFamily {
+	parsed, err := urn.NewMediaUrnFromString(mediaUrn)
+	if err != nil {
+		return nil
+	}
+	return r.familyOf(parsed)
+}
+
+// ValidateMediaUrn checks the tags of a media URN against the rules of its family; a
+// media URN of no registered family is valid if it parses
+func (r *MediaUrnRegistry) ValidateMediaUrn(mediaUrn string) error {
+	parsed, err := urn.NewMediaUrnFromString(mediaUrn)
+	if err != nil {
+		return &MediaRegistryError{Message: fmt.Sprintf("invalid media URN '%s': %v", mediaUrn, err)}
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if family := r.familyOf(parsed); family != nil {
+		return family.validate(mediaUrn, parsed)
+	}
+	return nil
+}
+
+func (f *registeredFamily) validate(mediaUrn string, parsed *urn.MediaUrn) error {
+	tags := make([]string, 0, len(f.def.Tags))
+	for tag := range f.def.Tags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		rule := f.def.Tags[tag]
+		value, ok := parsed.GetTag(tag)
+		if !ok {
+			if rule.Required {
+				return &MediaRegistryError{Message: fmt.Sprintf("media URN '%s' lacks tag '%s' required by family '%s'", mediaUrn, tag, f.def.Urn)}
+			}
+			continue
+		}
+		if len(rule.Values) > 0 && !containsString(rule.Values, value) {
+			return &MediaRegistryError{Message: fmt.Sprintf("media URN '%s': tag '%s' is '%s', family '%s' allows %v", mediaUrn, tag, value, f.def.Urn, rule.Values)}
+		}
+		if pattern := f.patterns[tag]; pattern != nil && !pattern.MatchString(value) {
+			return &MediaRegistryError{Message: fmt.Sprintf("media URN '%s': tag '%s' is '%s', family '%s' requires it to match %s", mediaUrn, tag, value, f.def.Urn, rule.Pattern)}
+		}
+	}
+	return nil
+}
+
+// IsTextable reports whether values of the media URN are text: as its family classifies
+// them if it is in one, else if it is tagged textable
+func (r *MediaUrnRegistry) IsTextable(mediaUrn string) bool {
+	parsed, err := urn.NewMediaUrnFromString(mediaUrn)
+	if err != nil {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if family := r.familyOf(parsed); family != nil {
+		return family.def.Textable
+	}
+	return parsed.IsTextable()
+}
+
+// familySpec returns the spec a member of a family without a spec of its own resolves to
+func (f *registeredFamily) familySpec(mediaUrn string) StoredMediaSpec {
+	return StoredMediaSpec{
+		Urn:        mediaUrn,
+		MediaType:  f.def.MediaType,
+		Title:      f.def.Title,
+		ProfileURI: f.def.ProfileURI,
+		Extensions: f.def.Extensions,
+	}
+}
+
+// Definitions returns the media specs added with AddSpec and the registered families,
+// sorted by URN
+func (r *MediaUrnRegistry) Definitions() RegistryDefinitions {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var definitions RegistryDefinitions
+	for normalizedUrn := range r.addedSpecs {
+		definitions.Specs = append(definitions.Specs, r.cachedSpecs[normalizedUrn])
+	}
+	sort.Slice(definitions.Specs, func(i, j int) bool {
+		return normalizeMediaUrn(definitions.Specs[i].Urn) < normalizeMediaUrn(definitions.Specs[j].Urn)
+	})
+	keys := make([]string, 0, len(r.families))
+	for key := range r.families {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		definitions.Families = append(definitions.Families, r.families[key].def)
+	}
+	return definitions
+}
+
+// SaveDefinitions writes the registry's definitions (see Definitions) as JSON
+func (r *MediaUrnRegistry) SaveDefinitions(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r.Definitions())
+}
+
+// LoadDefinitions adds the media specs and registers the families of definitions
+// SaveDefinitions wrote. Nothing is added if a family is invalid.
+func (r *MediaUrnRegistry) LoadDefinitions(rd io.Reader) error {
+	var definitions RegistryDefinitions
+	if err := json.NewDecoder(rd).Decode(&definitions); err != nil {
+		return &MediaRegistryError{Message: fmt.Sprintf("invalid registry definitions: %v", err)}
+	}
+	// Checked before anything is added
+	families := make([]*registeredFamily, 0, len(definitions.Families))
+	for _, family := range definitions.Families {
+		registered, err := newRegisteredFamily(family)
+		if err != nil {
+			return err
+		}
+		families = append(families, registered)
+	}
+
+	for _, spec := range definitions.Specs {
+		r.AddSpec(spec)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, family := range families {
+		r.families[family.urn.String()] = family
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
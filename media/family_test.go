@@ -0,0 +1,97 @@
+package media
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func modelSpecFamily() MediaFamily {
+	return MediaFamily{
+		Urn:       "media:model-spec",
+		Textable:  true,
+		MediaType: "text/plain",
+		Title:     "Model Spec",
+		Tags: map[string]TagRule{
+			"provider": {Required: true, Values: []string{"openai", "local"}},
+			"size":     {Pattern: "[0-9]+b"},
+		},
+	}
+}
+
+func TestRegisterFamilyResolvesMembers(t *testing.T) {
+	registry := testRegistry(t)
+	require.NoError(t, registry.RegisterFamily(modelSpecFamily()))
+
+	resolved, err := ResolveMediaUrn("media:model-spec;provider=local;size=7b", nil, registry)
+	require.NoError(t, err)
+	assert.Equal(t, "text/plain", resolved.MediaType)
+	assert.Equal(t, "Model Spec", resolved.Title)
+	assert.True(t, resolved.IsText(), "the family classifies its members as text without a textable tag")
+	assert.True(t, registry.IsTextable("media:model-spec;provider=openai"))
+
+	_, err = ResolveMediaUrn("media:model-spec;size=7b", nil, registry)
+	assert.Error(t, err, "a required tag is missing")
+	_, err = ResolveMediaUrn("media:model-spec;provider=other", nil, registry)
+	assert.Error(t, err, "the tag value is not allowed")
+	_, err = ResolveMediaUrn("media:model-spec;provider=local;size=big", nil, registry)
+	assert.Error(t, err, "the tag value doesn't match the pattern")
+}
+
+// The family's rules hold for a member resolved from a cap's media_specs too
+func TestFamilyRulesApplyToMediaSpecs(t *testing.T) {
+	registry := testRegistry(t)
+	require.NoError(t, registry.RegisterFamily(modelSpecFamily()))
+	mediaSpecs := []MediaSpecDef{NewMediaSpecDef("media:provider=other;model-spec", "application/x-model", "")}
+
+	_, err := ResolveMediaUrn("media:model-spec;provider=other", mediaSpecs, registry)
+	assert.Error(t, err)
+
+	mediaSpecs = []MediaSpecDef{NewMediaSpecDef("media:provider=local;model-spec", "application/x-model", "")}
+	resolved, err := ResolveMediaUrn("media:model-spec;provider=local", mediaSpecs, registry)
+	require.NoError(t, err, "media_specs must match in normalized form")
+	assert.Equal(t, "application/x-model", resolved.MediaType)
+	assert.True(t, resolved.IsText())
+}
+
+func TestMostSpecificFamily(t *testing.T) {
+	registry := testRegistry(t)
+	require.NoError(t, registry.RegisterFamily(MediaFamily{Urn: "media:dataset", MediaType: "application/octet-stream"}))
+	require.NoError(t, registry.RegisterFamily(MediaFamily{Urn: "media:dataset;csv;textable", MediaType: "text/csv"}))
+
+	family, ok := registry.Family("media:dataset;csv;textable;rows=10")
+	require.True(t, ok)
+	assert.Equal(t, "text/csv", family.MediaType)
+	assert.True(t, family.Textable, "a family tagged textable is textable")
+	assert.False(t, registry.IsTextable("media:dataset;parquet"))
+}
+
+func TestRegisterFamilyRejectsInvalid(t *testing.T) {
+	registry := testRegistry(t)
+	assert.Error(t, registry.RegisterFamily(MediaFamily{Urn: "not-media", MediaType: "text/plain"}))
+	assert.Error(t, registry.RegisterFamily(MediaFamily{Urn: "media:thing"}))
+	assert.Error(t, registry.RegisterFamily(MediaFamily{Urn: "media:thing", MediaType: "text/plain", Tags: map[string]TagRule{"x": {Pattern: "("}}}))
+}
+
+func TestSaveAndLoadDefinitions(t *testing.T) {
+	registry := testRegistry(t)
+	require.NoError(t, registry.RegisterFamily(modelSpecFamily()))
+	registry.AddSpec(StoredMediaSpec{Urn: "media:notebook;textable", MediaType: "application/x-ipynb+json", Title: "Notebook"})
+
+	var saved bytes.Buffer
+	require.NoError(t, registry.SaveDefinitions(&saved))
+
+	loaded := testRegistry(t)
+	require.NoError(t, loaded.LoadDefinitions(&saved))
+	assert.Equal(t, registry.Definitions(), loaded.Definitions())
+
+	spec, err := loaded.GetMediaSpec("media:notebook;textable")
+	require.NoError(t, err)
+	assert.Equal(t, "Notebook", spec.Title)
+	_, err = loaded.GetMediaSpec("media:model-spec;provider=openai")
+	assert.NoError(t, err)
+
+	assert.Error(t, loaded.LoadDefinitions(bytes.NewBufferString(`{"families":[{"urn":"media:x"}]}`)))
+}
@@ -60,7 +60,9 @@ func (s *StoredMediaSpec) ToMediaSpecDef() MediaSpecDef {
 type MediaUrnRegistry struct {
 	mu          sync.RWMutex
 	cachedSpecs map[string]StoredMediaSpec
-	extIndex    map[string][]string // lowercase extension -> list of URNs
+	extIndex    map[string][]string          // lowercase extension -> list of URNs
+	addedSpecs  map[string]bool              // Normalized URNs of the specs added with AddSpec
+	families    map[string]*registeredFamily // Normalized URN -> media family (see RegisterFamily)
 	config      RegistryConfig
 }
 
@@ -80,6 +82,8 @@ func NewMediaUrnRegistry() (*MediaUrnRegistry, error) {
 	registry := &MediaUrnRegistry{
 		cachedSpecs: make(map[string]StoredMediaSpec),
 		extIndex:    make(map[string][]string),
+		addedSpecs:  make(map[string]bool),
+		families:    make(map[string]*registeredFamily),
 		config:      config,
 	}
 
@@ -97,6 +101,8 @@ func NewMediaUrnRegistryForTest() (*MediaUrnRegistry, error) {
 	return &MediaUrnRegistry{
 		cachedSpecs: make(map[string]StoredMediaSpec),
 		extIndex:    make(map[string][]string),
+		addedSpecs:  make(map[string]bool),
+		families:    make(map[string]*registeredFamily),
 		config:      DefaultRegistryConfig(),
 	}, nil
 }
@@ -127,19 +133,29 @@ func (r *MediaUrnRegistry) installStandardSpecs() error {
 // This matches Rust's get_media_spec method
 //
 // Resolution order:
-//  1. In-memory cache (bundled standard specs)
-//  2. (Future: disk cache, remote fetch)
+//  1. In-memory cache (bundled standard specs and specs added with AddSpec)
+//  2. The defaults of the most specific registered family the URN is a member of
+//  3. (Future: disk cache, remote fetch)
+//
+// A member of a family whose tags break the family's rules is not found.
 func (r *MediaUrnRegistry) GetMediaSpec(urn string) (*StoredMediaSpec, error) {
 	normalizedUrn := normalizeMediaUrn(urn)
+	if err := r.ValidateMediaUrn(urn); err != nil {
+		return nil, err
+	}
 
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	spec, ok := r.cachedSpecs[normalizedUrn]
 	if !ok {
-		return nil, &MediaRegistryError{
-			Message: fmt.Sprintf("media URN '%s' not found in registry", urn),
+		family := r.familyOfString(urn)
+		if family == nil {
+			return nil, &MediaRegistryError{
+				Message: fmt.Sprintf("media URN '%s' not found in registry", urn),
+			}
 		}
+		spec = family.familySpec(urn)
 	}
 
 	return &spec, nil
@@ -322,13 +338,15 @@ func getBundledStandardMediaSpecs() []StoredMediaSpec {
 	}
 }
 
-// AddSpec adds a media spec to the registry (for testing)
+// AddSpec adds a media spec to the registry, replacing one with the same URN; it is
+// kept by SaveDefinitions
 func (r *MediaUrnRegistry) AddSpec(spec StoredMediaSpec) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	normalizedUrn := normalizeMediaUrn(spec.Urn)
 	r.cachedSpecs[normalizedUrn] = spec
+	r.addedSpecs[normalizedUrn] = true
 
 	// Update extension index
 	for _, ext := range spec.Extensions {
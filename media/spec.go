@@ -185,11 +185,15 @@ type ResolvedMediaSpec struct {
 	Metadata map[string]interface{}
 	// Extensions are the file extensions for storing this media type (e.g., ["pdf"], ["jpg", "jpeg"])
 	Extensions []string
+	// Textable is the text/bytes classification of the registered family the media URN is
+	// a member of, nil if it is in none (see MediaUrnRegistry.RegisterFamily)
+	Textable *bool
 }
 
-// IsBinary returns true if the "textable" marker tag is NOT present in the source media URN.
+// IsBinary returns true if the media URN's family classifies it as bytes, or if it is in
+// no family and the "textable" marker tag is NOT present in the source media URN.
 func (r *ResolvedMediaSpec) IsBinary() bool {
-	return !HasMediaUrnTag(r.SpecID, "textable")
+	return !r.IsText()
 }
 
 // IsRecord returns true if record marker tag is present (has internal key-value structure).
@@ -225,8 +229,12 @@ func (r *ResolvedMediaSpec) IsStructured() bool {
 	return r.IsRecord()
 }
 
-// IsText returns true if the "textable" marker tag is present in the source media URN.
+// IsText returns true if the media URN's family classifies it as text, or if it is in
+// no family and the "textable" marker tag is present in the source media URN.
 func (r *ResolvedMediaSpec) IsText() bool {
+	if r.Textable != nil {
+		return *r.Textable
+	}
 	return HasMediaUrnTag(r.SpecID, "textable")
 }
 
@@ -371,9 +379,14 @@ func ValidateNoMediaSpecDuplicates(mediaSpecs []MediaSpecDef) error {
 //
 // Resolution order (matches Rust implementation):
 //  1. Cap's local media_specs array (HIGHEST - cap-specific definitions)
-//  2. Registry's bundled standard specs
-//  3. (Future: Registry's cache and online fetch)
-//  4. If none resolve → FAIL HARD
+//  2. Registry's bundled standard specs and specs added to it
+//  3. Defaults of the registry's most specific family the URN is a member of
+//  4. (Future: Registry's cache and online fetch)
+//  5. If none resolve → FAIL HARD
+//
+// URNs are compared in their normalized form in both the media_specs and the registry.
+// Whichever resolves it, a media URN must satisfy the tag rules of its registered
+// family, and is text or bytes as the family classifies it.
 //
 // Arguments:
 //   - mediaUrn: The media URN to resolve (e.g., "media:textable")
@@ -388,17 +401,34 @@ func ResolveMediaUrn(mediaUrn string, mediaSpecs []MediaSpecDef, registry *Media
 	if !strings.HasPrefix(mediaUrn, "media:") {
 		return nil, ErrInvalidMediaUrn
 	}
+	if registry != nil {
+		if err := registry.ValidateMediaUrn(mediaUrn); err != nil {
+			return nil, err
+		}
+	}
+
+	resolved, err := resolveMediaUrn(mediaUrn, mediaSpecs, registry)
+	if err != nil {
+		return nil, err
+	}
+	if registry != nil {
+		if family, ok := registry.Family(mediaUrn); ok {
+			resolved.Textable = &family.Textable
+		}
+	}
+	return resolved, nil
+}
 
+func resolveMediaUrn(mediaUrn string, mediaSpecs []MediaSpecDef, registry *MediaUrnRegistry) (*ResolvedMediaSpec, error) {
 	// 1. First, try cap's local media_specs (highest priority - cap-specific definitions)
-	if mediaSpecs != nil {
-		for i := range mediaSpecs {
-			if mediaSpecs[i].Urn == mediaUrn {
-				return resolveMediaSpecDef(&mediaSpecs[i])
-			}
+	normalizedUrn := normalizeMediaUrn(mediaUrn)
+	for i := range mediaSpecs {
+		if mediaSpecs[i].Urn == mediaUrn || normalizeMediaUrn(mediaSpecs[i].Urn) == normalizedUrn {
+			return resolveMediaSpecDef(&mediaSpecs[i])
 		}
 	}
 
-	// 2. Try registry (checks bundled standard specs, then cache, then online)
+	// 2. Try registry (checks bundled and added specs, then families, then cache, then online)
 	if registry != nil {
 		storedSpec, err := registry.GetMediaSpec(mediaUrn)
 		if err == nil {
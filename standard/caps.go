@@ -14,6 +14,29 @@ const CapIdentity = "cap:"
 // Accepts any media type as input and produces void output
 const CapDiscard = "cap:in=media:;out=media:void"
 
+// CapHealth is the standard health capability URN
+// Takes no input and reports the plugin's health as a record
+// Answered by the plugin runtime unless the plugin registers its own handler
+const CapHealth = `cap:in="media:void";op=health;out="media:json;record;textable"`
+
+// CapDiscover is the standard discovery capability URN
+// Takes no input and lists the definitions of the caps the answering peer serves
+// Plugins send it to their host (see bifaci.Discover)
+const CapDiscover = `cap:in="media:void";op=discover;out="media:json;record;textable"`
+
+// CapJobStatus is the standard async job status capability URN
+// Takes a job ID and reports the job's state and progress as a record
+// Answered by the plugin runtime once a cap is registered async (see bifaci.PluginRuntime.RegisterAsync)
+const CapJobStatus = `cap:in="media:textable";op=job-status;out="media:json;record;textable"`
+
+// CapJobResult is the standard async job result capability URN
+// Takes a job ID and answers with the output of the finished job, or its error
+const CapJobResult = `cap:in="media:textable";op=job-result;out="media:"`
+
+// CapJobCancel is the standard async job cancellation capability URN
+// Takes a job ID, cancels the job if it is running and reports its state as a record
+const CapJobCancel = `cap:in="media:textable";op=job-cancel;out="media:json;record;textable"`
+
 // =============================================================================
 // STANDARD CAP URN BUILDERS
 // These return URN strings that can be parsed with urn.NewCapUrnFromString()
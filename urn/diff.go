@@ -0,0 +1,148 @@
+package urn
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Canonicalize returns the canonical form of a cap or media URN: tags sorted, keys
+// lowercased and values quoted only where needed, as String writes a parsed URN, and
+// the media URNs of a cap's in and out specs canonical too. Two URNs are equal if their
+// canonical forms are.
+func Canonicalize(s string) (string, error) {
+	switch {
+	case hasPrefixFold(s, "cap:"):
+		capUrn, err := NewCapUrnFromString(s)
+		if err != nil {
+			return "", err
+		}
+		inSpec, err := NewMediaUrnFromString(capUrn.inSpec)
+		if err != nil {
+			return "", err
+		}
+		outSpec, err := NewMediaUrnFromString(capUrn.outSpec)
+		if err != nil {
+			return "", err
+		}
+		return capUrn.WithInSpec(inSpec.String()).WithOutSpec(outSpec.String()).String(), nil
+	case hasPrefixFold(s, "media:"):
+		mediaUrn, err := NewMediaUrnFromString(s)
+		if err != nil {
+			return "", err
+		}
+		return mediaUrn.String(), nil
+	}
+	return "", fmt.Errorf("'%s' is neither a cap URN nor a media URN", s)
+}
+
+// TagChange is how a tag differs between two URNs
+type TagChange string
+
+const (
+	TagAdded   TagChange = "added"   // Only the second URN has the tag
+	TagRemoved TagChange = "removed" // Only the first URN has the tag
+	TagChanged TagChange = "changed" // The URNs have different values for the tag
+)
+
+// TagDelta is a tag two URNs differ in (see Diff)
+type TagDelta struct {
+	// Tag key. The tags of the media URNs of a cap's direction specs are keyed
+	// "in.<tag>" and "out.<tag>".
+	Key    string
+	Change TagChange
+	A      string // Value in the first URN, "" if it lacks the tag
+	B      string // Value in the second URN, "" if it lacks the tag
+}
+
+func (d TagDelta) String() string {
+	switch d.Change {
+	case TagAdded:
+		return fmt.Sprintf("+%s=%s", d.Key, d.B)
+	case TagRemoved:
+		return fmt.Sprintf("-%s=%s", d.Key, d.A)
+	default:
+		return fmt.Sprintf("~%s: %s -> %s", d.Key, d.A, d.B)
+	}
+}
+
+// Diff returns the tags two cap URNs or two media URNs differ in, sorted by key, none if
+// they are equal. It helps tell why a pattern doesn't accept a URN: a pattern accepts
+// an instance only if the instance has each of the pattern's tags with the same value
+// or a wildcard (see CapUrn.Accepts), so a tag the pattern has and the instance lacks
+// shows as removed, and one with different non-wildcard values as changed.
+func Diff(a, b string) ([]TagDelta, error) {
+	if hasPrefixFold(a, "cap:") != hasPrefixFold(b, "cap:") {
+		return nil, fmt.Errorf("can't diff a cap URN against a media URN: '%s', '%s'", a, b)
+	}
+	aTags, err := urnTags(a)
+	if err != nil {
+		return nil, err
+	}
+	bTags, err := urnTags(b)
+	if err != nil {
+		return nil, err
+	}
+	return diffTags(aTags, bTags), nil
+}
+
+// urnTags returns the tags of a cap or media URN, with a cap's direction specs
+// flattened into "in.<tag>" and "out.<tag>" tags
+func urnTags(s string) (map[string]string, error) {
+	canonical, err := Canonicalize(s)
+	if err != nil {
+		return nil, err
+	}
+	if !hasPrefixFold(canonical, "cap:") {
+		mediaUrn, err := NewMediaUrnFromString(canonical)
+		if err != nil {
+			return nil, err
+		}
+		return mediaUrn.inner.AllTags(), nil
+	}
+
+	capUrn, err := NewCapUrnFromString(canonical)
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string, len(capUrn.tags))
+	for key, value := range capUrn.tags {
+		tags[key] = value
+	}
+	for direction, spec := range map[string]string{"in": capUrn.inSpec, "out": capUrn.outSpec} {
+		mediaUrn, err := NewMediaUrnFromString(spec)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range mediaUrn.inner.AllTags() {
+			tags[direction+"."+key] = value
+		}
+	}
+	return tags, nil
+}
+
+func diffTags(a, b map[string]string) []TagDelta {
+	var deltas []TagDelta
+	for key, aValue := range a {
+		bValue, ok := b[key]
+		switch {
+		case !ok:
+			deltas = append(deltas, TagDelta{Key: key, Change: TagRemoved, A: aValue})
+		case aValue != bValue:
+			deltas = append(deltas, TagDelta{Key: key, Change: TagChanged, A: aValue, B: bValue})
+		}
+	}
+	for key, bValue := range b {
+		if _, ok := a[key]; !ok {
+			deltas = append(deltas, TagDelta{Key: key, Change: TagAdded, B: bValue})
+		}
+	}
+	sort.Slice(deltas, func(i, j int) bool {
+		return deltas[i].Key < deltas[j].Key
+	})
+	return deltas
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
@@ -0,0 +1,62 @@
+package urn
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalize(t *testing.T) {
+	canonical, err := Canonicalize(`CAP:op=extract;out="media:textable";in="media:pdf"`)
+	require.NoError(t, err)
+	expected, err := NewCapUrnFromString(`cap:in="media:pdf";op=extract;out="media:textable"`)
+	require.NoError(t, err)
+	assert.Equal(t, expected.String(), canonical)
+
+	canonical, err = Canonicalize("media:textable;pdf")
+	require.NoError(t, err)
+	again, err := Canonicalize("media:pdf;textable")
+	require.NoError(t, err)
+	assert.Equal(t, canonical, again)
+
+	// The tags of the direction specs are sorted too
+	canonical, err = Canonicalize(`cap:in="media:textable;model-spec";op=generate;out="media:textable"`)
+	require.NoError(t, err)
+	again, err = Canonicalize(`cap:in="media:model-spec;textable";op=generate;out="media:textable"`)
+	require.NoError(t, err)
+	assert.Equal(t, canonical, again)
+
+	_, err = Canonicalize("urn:x")
+	assert.Error(t, err)
+}
+
+func TestDiffCapUrns(t *testing.T) {
+	deltas, err := Diff(
+		`cap:in="media:pdf";op=extract;out="media:textable";lang=en`,
+		`cap:in="media:pdf;v=2";op=summarize;out="media:textable"`,
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []TagDelta{
+		{Key: "in.v", Change: TagAdded, B: "2"},
+		{Key: "lang", Change: TagRemoved, A: "en"},
+		{Key: "op", Change: TagChanged, A: "extract", B: "summarize"},
+	}, deltas)
+	assert.Equal(t, "~op: extract -> summarize", deltas[2].String())
+
+	deltas, err = Diff(`cap:op=extract;in="media:pdf";out="media:textable"`, `cap:in="media:pdf";op=extract;out="media:textable"`)
+	require.NoError(t, err)
+	assert.Empty(t, deltas, "equal URNs written differently don't differ")
+}
+
+func TestDiffMediaUrns(t *testing.T) {
+	deltas, err := Diff("media:image;png", "media:image;jpeg")
+	require.NoError(t, err)
+	require.Len(t, deltas, 2)
+	assert.Equal(t, TagAdded, deltas[0].Change)
+	assert.Equal(t, "jpeg", deltas[0].Key)
+	assert.Equal(t, TagRemoved, deltas[1].Change)
+
+	_, err = Diff("media:pdf", `cap:in="media:pdf";op=extract;out="media:textable"`)
+	assert.Error(t, err)
+}